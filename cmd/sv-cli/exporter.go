@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/csv"
 	"fmt"
 	"os"
@@ -50,37 +51,55 @@ func (e *Exporter) ExportCSV(filename, table string) error {
 	return nil
 }
 
+// ExportJSON writes table to filename as a JSON array of objects, with
+// JSON columns written as the values they hold.
 func (e *Exporter) ExportJSON(filename, table string) error {
-	rows, err := e.db.Query("SELECT * FROM " + table)
-	if err != nil {
-		return err
-	}
+	return e.exportJSON(filename, table, sqlvibe.JSONFormatObjects)
+}
+
+// ExportNDJSON writes table to filename as NDJSON, an object per line.
+func (e *Exporter) ExportNDJSON(filename, table string) error {
+	return e.exportJSON(filename, table, sqlvibe.JSONFormatLines)
+}
 
+func (e *Exporter) exportJSON(filename, table string, format sqlvibe.JSONFormat) error {
+	return e.exportFile(filename, func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		err := e.db.ExportJSON(w, "SELECT * FROM "+table, sqlvibe.JSONExportOptions{Format: format, ParseJSON: true})
+		if err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+// ExportParquet writes table to filename as a snappy-compressed Parquet file.
+func (e *Exporter) ExportParquet(filename, table string) error {
+	return e.exportFile(filename, func(f *os.File) error {
+		return e.db.ExportParquet(f, "SELECT * FROM "+table, sqlvibe.ParquetExportOptions{})
+	})
+}
+
+// ExportArrow writes table to filename as an Arrow IPC stream.
+func (e *Exporter) ExportArrow(filename, table string) error {
+	return e.exportFile(filename, func(f *os.File) error {
+		return e.db.ExportArrow(f, "SELECT * FROM "+table)
+	})
+}
+
+// exportFile creates filename and writes it with write, removing it again
+// if that fails.
+func (e *Exporter) exportFile(filename string, write func(*os.File) error) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	fmt.Fprintln(file, "[")
-
-	for i, row := range rows.Data {
-		fmt.Fprint(file, "  {")
-		for j, col := range rows.Columns {
-			if j > 0 {
-				fmt.Fprint(file, ", ")
-			}
-			fmt.Fprintf(file, `"%s": "%v"`, col, row[j])
-		}
-		fmt.Fprint(file, "}")
-		if i < len(rows.Data)-1 {
-			fmt.Fprintln(file, ",")
-		} else {
-			fmt.Fprintln(file)
-		}
+	err = write(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
 	}
-
-	fmt.Fprintln(file, "]")
-
-	return nil
+	if err != nil {
+		os.Remove(filename)
+	}
+	return err
 }
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"encoding/csv"
-	"fmt"
+	"bufio"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/cyw0ng95/sqlvibe/pkg/sqlvibe"
@@ -17,58 +17,106 @@ func NewImporter(db *sqlvibe.Database) *Importer {
 	return &Importer{db: db}
 }
 
-func (i *Importer) ImportCSV(filename, table string) (int, error) {
+// ImportOptions controls how ImportCSV reads a file.
+type ImportOptions struct {
+	Comma   rune              // field delimiter; 0 sniffs it, or uses tab for a .tsv file
+	Skip    int               // lines to skip before the header
+	Types   map[string]string // declared types to use instead of inferred ones, by column
+	Rejects string            // file for rows that cannot be imported; default FILE.rejects
+}
+
+// ImportCSV loads the CSV file filename into table, creating the table from
+// the header, with column types inferred from the data, if it does not
+// exist. Rows that cannot be parsed or stored are written, with their line
+// and the reason, to the rejects file, which is only created if there are
+// any. Returns the rows imported and rejected.
+func (i *Importer) ImportCSV(filename, table string, opts ImportOptions) (imported, rejected int, err error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return 0, err
+	if opts.Comma == 0 && strings.EqualFold(filepath.Ext(filename), ".tsv") {
+		opts.Comma = '\t'
 	}
-
-	if len(records) == 0 {
-		return 0, fmt.Errorf("empty CSV file")
+	if opts.Rejects == "" {
+		opts.Rejects = filename + ".rejects"
 	}
+	rejects := &rejectFile{path: opts.Rejects}
+	defer rejects.Close()
+	imported, err = i.db.ImportCSV(table, file, sqlvibe.CSVImportOptions{
+		HasHeader:   true,
+		Comma:       opts.Comma,
+		CreateTable: true,
+		Skip:        opts.Skip,
+		ColumnTypes: opts.Types,
+		Rejects:     rejects,
+	})
+	return imported, rejects.records, err
+}
+
+// rejectFile creates its file at the first rejected row; ImportCSV writes
+// each row in one Write.
+type rejectFile struct {
+	path    string
+	f       *os.File
+	records int
+}
 
-	headers := records[0]
-	rows := records[1:]
-	if len(rows) == 0 {
-		return 0, fmt.Errorf("no data rows in CSV")
+func (r *rejectFile) Write(p []byte) (int, error) {
+	if r.f == nil {
+		f, err := os.Create(r.path)
+		if err != nil {
+			return 0, err
+		}
+		r.f = f
 	}
+	r.records++
+	return r.f.Write(p)
+}
 
-	colList := make([]string, len(headers))
-	placeholders := make([]string, len(headers))
-	for j := range headers {
-		colList[j] = fmt.Sprintf(`"%s" TEXT`, headers[j])
-		placeholders[j] = "?"
+func (r *rejectFile) Close() error {
+	if r.f == nil {
+		return nil
 	}
+	return r.f.Close()
+}
 
-	createSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(colList, ", "))
-	if _, err := i.db.Exec(createSQL); err != nil {
+// ImportParquet loads the Parquet file filename into table, creating the
+// table from the file's schema if it does not exist. Returns the rows
+// imported.
+func (i *Importer) ImportParquet(filename, table string) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
 		return 0, err
 	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return i.db.ImportParquet(table, file, st.Size(), sqlvibe.ArrowImportOptions{CreateTable: true})
+}
 
-	count := 0
-	for _, row := range rows {
-		insertSQL := fmt.Sprintf("INSERT INTO %s VALUES (%s)", table, strings.Join(placeholders, ", "))
-		stmt, err := i.db.Prepare(insertSQL)
-		if err != nil {
-			continue
-		}
-		vals := make([]interface{}, len(row))
-		for k, v := range row {
-			vals[k] = v
-		}
-		_, err = stmt.Exec(vals...)
-		if err != nil {
-			continue
-		}
-		count++
+// ImportArrow loads the Arrow IPC stream in filename into table as
+// ImportParquet does.
+func (i *Importer) ImportArrow(filename, table string) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
 	}
+	defer file.Close()
+	return i.db.ImportArrow(table, bufio.NewReader(file), sqlvibe.ArrowImportOptions{CreateTable: true})
+}
 
-	return count, nil
+// ImportJSON loads the objects of the JSON or NDJSON file filename into
+// table as ImportParquet does, creating the table from their keys.
+func (i *Importer) ImportJSON(filename, table string) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return i.db.ImportJSON(table, file, sqlvibe.JSONImportOptions{CreateTable: true})
 }
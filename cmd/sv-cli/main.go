@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -219,7 +220,7 @@ func handleMetaCommand(db *sqlvibe.Database, line string, formatter *Formatter,
 		setColumnWidths(formatter, args)
 
 	case ".import":
-		importCSV(importer, args)
+		importData(importer, args)
 
 	case ".export":
 		exportData(db, exporter, args)
@@ -255,11 +256,18 @@ func printHelp() {
 	fmt.Println("  .output stdout        Restore output to stdout")
 	fmt.Println()
 	fmt.Println("I/O:")
-	fmt.Println("  .import FILE TABLE    Import CSV file into table")
+	fmt.Println("  .import [--csv|--tsv] [--skip N] [--type COL=TYPE]... [--rejects FILE] FILE TABLE")
+	fmt.Println("                        Import CSV/TSV file into table, inferring column types")
+	fmt.Println("  .import --json|--parquet|--arrow FILE TABLE")
+	fmt.Println("                        Import JSON/NDJSON objects, Parquet file or Arrow IPC stream")
 	fmt.Println("  .export csv FILE TABLE  Export table to CSV")
 	fmt.Println("  .export json FILE TABLE Export table to JSON")
-	fmt.Println("  .dump [FILE] [--data-only|--schema-only|--inserts]  Dump database as SQL")
-	fmt.Println("  .read FILE            Execute SQL from file")
+	fmt.Println("  .export ndjson FILE TABLE   Export table to NDJSON, an object per line")
+	fmt.Println("  .export parquet FILE TABLE  Export table to Parquet")
+	fmt.Println("  .export arrow FILE TABLE    Export table to an Arrow IPC stream")
+	fmt.Println("  .dump [--data-only|--schema-only] [FILE] [TABLE...]")
+	fmt.Println("                        Dump database, or the tables and views given, as SQL")
+	fmt.Println("  .read FILE            Execute SQL from file, such as a dump")
 	fmt.Println()
 	fmt.Println("Other:")
 	fmt.Println("  .timer on|off         Toggle query timer")
@@ -428,22 +436,99 @@ func setColumnWidths(formatter *Formatter, args []string) {
 	formatter.SetColumnWidths(widths)
 }
 
-func importCSV(importer *Importer, args []string) {
-	if len(args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: .import FILE TABLE\n")
+func importData(importer *Importer, args []string) {
+	const usage = "Usage: .import [--csv|--tsv|--json|--parquet|--arrow] [--skip N] [--type COL=TYPE]... [--rejects FILE] FILE TABLE\n"
+	var opts ImportOptions
+	var format string
+	var files []string
+	for k := 0; k < len(args); k++ {
+		switch arg := args[k]; arg {
+		case "--csv":
+			opts.Comma = ','
+		case "--tsv":
+			opts.Comma = '\t'
+		case "--json", "--parquet", "--arrow":
+			format = arg[2:]
+		case "--skip", "--type", "--rejects":
+			if k+1 == len(args) {
+				fmt.Fprint(os.Stderr, usage)
+				return
+			}
+			k++
+			switch arg {
+			case "--skip":
+				n, err := strconv.Atoi(args[k])
+				if err != nil || n < 0 {
+					fmt.Fprintf(os.Stderr, "Error: invalid --skip %q\n", args[k])
+					return
+				}
+				opts.Skip = n
+			case "--type":
+				col, typ, ok := strings.Cut(args[k], "=")
+				if !ok || col == "" || typ == "" {
+					fmt.Fprintf(os.Stderr, "Error: --type wants COL=TYPE, got %q\n", args[k])
+					return
+				}
+				if opts.Types == nil {
+					opts.Types = map[string]string{}
+				}
+				opts.Types[col] = typ
+			case "--rejects":
+				opts.Rejects = args[k]
+			}
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 2 {
+		fmt.Fprint(os.Stderr, usage)
 		return
 	}
-	count, err := importer.ImportCSV(args[0], args[1])
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(files[0])) {
+		case ".json", ".ndjson", ".jsonl":
+			format = "json"
+		case ".parquet":
+			format = "parquet"
+		case ".arrow", ".arrows":
+			format = "arrow"
+		}
+	}
+	if format != "" {
+		var count int
+		var err error
+		switch format {
+		case "json":
+			count, err = importer.ImportJSON(files[0], files[1])
+		case "parquet":
+			count, err = importer.ImportParquet(files[0], files[1])
+		default:
+			count, err = importer.ImportArrow(files[0], files[1])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Imported %d rows\n", count)
+		return
+	}
+	if opts.Rejects == "" {
+		opts.Rejects = files[0] + ".rejects"
+	}
+	count, rejected, err := importer.ImportCSV(files[0], files[1], opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
 	fmt.Printf("Imported %d rows\n", count)
+	if rejected > 0 {
+		fmt.Printf("Rejected %d rows, written to %s\n", rejected, opts.Rejects)
+	}
 }
 
 func exportData(db *sqlvibe.Database, exporter *Exporter, args []string) {
 	if len(args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: .export csv|json FILE TABLE\n")
+		fmt.Fprintf(os.Stderr, "Usage: .export csv|json|ndjson|parquet|arrow FILE TABLE\n")
 		return
 	}
 	format := strings.ToLower(args[0])
@@ -468,8 +553,24 @@ func exportData(db *sqlvibe.Database, exporter *Exporter, args []string) {
 			return
 		}
 		err = exporter.ExportJSON(filename, tableName)
+	case "ndjson":
+		if tableName == "" {
+			fmt.Fprintf(os.Stderr, "Usage: .export ndjson FILE TABLE\n")
+			return
+		}
+		err = exporter.ExportNDJSON(filename, tableName)
+	case "parquet", "arrow":
+		if tableName == "" {
+			fmt.Fprintf(os.Stderr, "Usage: .export %s FILE TABLE\n", format)
+			return
+		}
+		if format == "parquet" {
+			err = exporter.ExportParquet(filename, tableName)
+		} else {
+			err = exporter.ExportArrow(filename, tableName)
+		}
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown export format '%s' (supported: csv, json)\n", format)
+		fmt.Fprintf(os.Stderr, "Unknown export format '%s' (supported: csv, json, ndjson, parquet, arrow)\n", format)
 		return
 	}
 	if err != nil {
@@ -483,6 +584,14 @@ func dumpDatabase(db *sqlvibe.Database, args []string) {
 	opts := sqlvibe.DumpOptions{}
 	var outfile string
 
+	// An argument naming a table or view limits the dump to it; another
+	// names the output file.
+	known := make(map[string]bool)
+	if tables, err := db.GetTables(); err == nil {
+		for _, t := range tables {
+			known[strings.ToLower(t.Name)] = true
+		}
+	}
 	for _, arg := range args {
 		switch strings.ToLower(arg) {
 		case "--data-only":
@@ -492,7 +601,14 @@ func dumpDatabase(db *sqlvibe.Database, args []string) {
 		case "--inserts":
 			opts.UseInserts = true
 		default:
-			outfile = arg
+			if known[strings.ToLower(arg)] {
+				opts.Tables = append(opts.Tables, arg)
+			} else if outfile == "" {
+				outfile = arg
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: no such table: %s\n", arg)
+				return
+			}
 		}
 	}
 
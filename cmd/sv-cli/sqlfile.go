@@ -1,10 +1,7 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
 	"os"
-	"strings"
 
 	"github.com/cyw0ng95/sqlvibe/pkg/sqlvibe"
 )
@@ -16,25 +13,5 @@ func ExecuteSQLFile(db *sqlvibe.Database, filename string) error {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	var sql strings.Builder
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		sql.WriteString(line)
-		sql.WriteString(" ")
-
-		if strings.Contains(line, ";") {
-			query := strings.TrimSpace(sql.String())
-			if query != "" && !strings.HasPrefix(query, "--") {
-				_, err := db.Exec(query)
-				if err != nil {
-					return fmt.Errorf("error executing %s: %v", query, err)
-				}
-			}
-			sql.Reset()
-		}
-	}
-
-	return scanner.Err()
+	return db.ExecScript(file)
 }
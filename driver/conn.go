@@ -96,7 +96,8 @@ func splitSQLStatements(sql string) []string {
 // Conn implements driver.Conn, driver.ConnBeginTx, driver.ConnPrepareContext,
 // driver.ExecerContext, and driver.QueryerContext.
 type Conn struct {
-	db *sqlvibe.Database
+	db    *sqlvibe.Database
+	begin string // statement that starts a transaction; "" means BEGIN
 }
 
 // Prepare returns a prepared statement.
@@ -113,9 +114,18 @@ func (c *Conn) Close() error {
 	return c.db.Close()
 }
 
+// beginStatement returns the statement that starts a transaction, BEGIN
+// or, under the _txlock option, BEGIN IMMEDIATE or BEGIN EXCLUSIVE.
+func (c *Conn) beginStatement() string {
+	if c.begin == "" {
+		return "BEGIN"
+	}
+	return c.begin
+}
+
 // Begin starts a new transaction using the SQL BEGIN statement.
 func (c *Conn) Begin() (driver.Tx, error) {
-	if _, err := c.db.Exec("BEGIN"); err != nil {
+	if _, err := c.db.Exec(c.beginStatement()); err != nil {
 		return nil, err
 	}
 	return &Tx{db: c.db}, nil
@@ -128,7 +138,7 @@ func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, e
 	}
 	ch := make(chan result, 1)
 	go func() {
-		_, err := c.db.Exec("BEGIN")
+		_, err := c.db.Exec(c.beginStatement())
 		ch <- result{err}
 	}()
 	select {
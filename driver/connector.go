@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"context"
+	gosqldriver "database/sql/driver"
+
+	"github.com/cyw0ng95/sqlvibe/pkg/sqlvibe"
+)
+
+// Connector opens connections configured by a sqlvibe.Config. Use it with
+// sql.OpenDB; sqlvibe.NewConnector returns one too.
+type Connector struct {
+	cfg *sqlvibe.Config
+
+	// ConnectHook, if set, runs on every new connection before it is
+	// used. An error closes the connection and fails the connect.
+	ConnectHook func(db *sqlvibe.Database) error
+}
+
+// NewConnector returns a Connector for cfg.
+func NewConnector(cfg *sqlvibe.Config) *Connector {
+	return &Connector{cfg: cfg}
+}
+
+// Connect opens a connection and applies the configuration to it.
+func (c *Connector) Connect(ctx context.Context) (gosqldriver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db, err := sqlvibe.OpenConfig(c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if c.ConnectHook != nil {
+		if err := c.ConnectHook(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &Conn{db: db, begin: c.cfg.BeginStatement()}, nil
+}
+
+// Driver returns the sqlvibe driver.
+func (c *Connector) Driver() gosqldriver.Driver {
+	return &Driver{}
+}
+
+// Ensure Connector implements driver.Connector.
+var _ gosqldriver.Connector = &Connector{}
@@ -7,9 +7,31 @@
 //	import _ "github.com/cyw0ng95/sqlvibe/driver"
 //
 //	db, err := sql.Open("sqlvibe", ":memory:")
+//
+// The name is a path, or ":memory:", optionally prefixed with "file:" and
+// followed by options that are applied to every new connection:
+//
+//	db, err := sql.Open("sqlvibe", "file:app.db?mode=ro&_busy_timeout=5000&_txlock=immediate")
+//
+// Each connection database/sql opens to ":memory:" is a database of its
+// own. Connections to a named in-memory database with a shared cache use one
+// database, which lives while any of them is open:
+//
+//	db, err := sql.Open("sqlvibe", "file:testdb?mode=memory&cache=shared")
+//
+// See sqlvibe.ParseDSN for the options. To configure connections in code,
+// and to run setup on each one, open the database with a Connector:
+//
+//	c, err := sqlvibe.NewConnector(&sqlvibe.Config{Path: "app.db", ForeignKeys: true},
+//		func(db *sqlvibe.Database) error { ... })
+//	db := sql.OpenDB(c)
+//
+// driver.NewConnector returns the same Connector, whose ConnectHook can be
+// set directly.
 package driver
 
 import (
+	"context"
 	"database/sql"
 	gosqldriver "database/sql/driver"
 
@@ -21,20 +43,38 @@ const DriverName = "sqlvibe"
 
 func init() {
 	sql.Register(DriverName, &Driver{})
+	sqlvibe.RegisterConnector(func(cfg *sqlvibe.Config, hook func(db *sqlvibe.Database) error) gosqldriver.Connector {
+		c := NewConnector(cfg)
+		c.ConnectHook = hook
+		return c
+	})
 }
 
 // Driver implements database/sql/driver.Driver.
 type Driver struct{}
 
-// Open opens a new database connection. The name parameter is the path to the
-// database file, or ":memory:" for an in-memory database.
+// Open opens a new database connection. The name parameter is the path to
+// the database file, or ":memory:" for an in-memory database, optionally
+// followed by options; see sqlvibe.ParseDSN.
 func (d *Driver) Open(name string) (gosqldriver.Conn, error) {
-	db, err := sqlvibe.Open(name)
+	c, err := d.OpenConnector(name)
 	if err != nil {
 		return nil, err
 	}
-	return &Conn{db: db}, nil
+	return c.Connect(context.Background())
 }
 
-// Ensure Driver implements driver.Driver.
-var _ gosqldriver.Driver = &Driver{}
+// OpenConnector parses name once for every connection database/sql opens.
+func (d *Driver) OpenConnector(name string) (gosqldriver.Connector, error) {
+	cfg, err := sqlvibe.ParseDSN(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnector(cfg), nil
+}
+
+// Ensure Driver implements driver.Driver and driver.DriverContext.
+var (
+	_ gosqldriver.Driver        = &Driver{}
+	_ gosqldriver.DriverContext = &Driver{}
+)
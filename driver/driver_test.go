@@ -1,8 +1,15 @@
 package driver
 
 import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/cyw0ng95/sqlvibe/pkg/sqlvibe"
 )
 
 func TestDriver(t *testing.T) {
@@ -68,6 +75,171 @@ func TestRows(t *testing.T) {
 	_ = &Rows{}
 }
 
+func TestRowsColumnTypes(t *testing.T) {
+	db, err := sql.Open(DriverName, ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, label VARCHAR(16) NOT NULL, price REAL)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	rows, err := db.Query("SELECT id, label, price, price * 2 AS doubled FROM items")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatalf("ColumnTypes: %v", err)
+	}
+	want := []struct {
+		name, dbType     string
+		nullable, nullOK bool
+		scan             reflect.Type
+		length           int64
+		lengthOK         bool
+	}{
+		{"id", "INTEGER", false, true, reflect.TypeOf(int64(0)), 0, false},
+		{"label", "VARCHAR", false, true, reflect.TypeOf(""), 16, true},
+		{"price", "REAL", true, true, reflect.TypeOf(sql.NullFloat64{}), 0, false},
+		{"doubled", "", true, false, reflect.TypeOf((*interface{})(nil)).Elem(), 0, false},
+	}
+	if len(types) != len(want) {
+		t.Fatalf("%d column types, want %d", len(types), len(want))
+	}
+	for i, w := range want {
+		ct := types[i]
+		nullable, nullOK := ct.Nullable()
+		length, lengthOK := ct.Length()
+		if ct.Name() != w.name || ct.DatabaseTypeName() != w.dbType || nullable != w.nullable ||
+			nullOK != w.nullOK || ct.ScanType() != w.scan || length != w.length || lengthOK != w.lengthOK {
+			t.Errorf("column %d = %s %q nullable=%v,%v scan=%v length=%d,%v", i, ct.Name(), ct.DatabaseTypeName(),
+				nullable, nullOK, ct.ScanType(), length, lengthOK)
+		}
+	}
+}
+
+func TestTimeOptions(t *testing.T) {
+	db, err := sql.Open(DriverName, ":memory:?_time_format=unixmilli&_loc=Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE ev (at DATETIME)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	when := time.Date(2024, 3, 5, 6, 7, 8, 9e6, time.UTC)
+	if _, err := db.Exec("INSERT INTO ev VALUES (?)", when); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	var stored int64
+	if err := db.QueryRow("SELECT at + 0 FROM ev").Scan(&stored); err != nil || stored != when.UnixMilli() {
+		t.Errorf("stored %d, %v; want %d", stored, err, when.UnixMilli())
+	}
+	var back time.Time
+	if err := db.QueryRow("SELECT at FROM ev").Scan(&back); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !back.Equal(when) || back.Location().String() != "Asia/Tokyo" {
+		t.Errorf("scanned %v, want %v in Asia/Tokyo", back, when)
+	}
+	for _, dsn := range []string{":memory:?_time_format=fortnights", ":memory:?_loc=Nowhere/Special"} {
+		if _, err := sql.Open(DriverName, dsn); err == nil {
+			t.Errorf("%s: expected an error", dsn)
+		}
+	}
+}
+
+func TestConnector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	c := NewConnector(&sqlvibe.Config{Path: path, ForeignKeys: true, TxLock: sqlvibe.TxLockImmediate})
+	var hooked int
+	c.ConnectHook = func(db *sqlvibe.Database) error {
+		hooked++
+		_, err := db.Exec("CREATE TABLE IF NOT EXISTS t (x)")
+		return err
+	}
+	db := sql.OpenDB(c)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	var fk int
+	if err := db.QueryRow("PRAGMA foreign_keys").Scan(&fk); err != nil || fk != 1 || hooked == 0 {
+		t.Errorf("foreign_keys = %d, %v; hook ran %d times", fk, err, hooked)
+	}
+	db.Close()
+	if got := (&Conn{}).beginStatement(); got != "BEGIN" {
+		t.Errorf("default begin statement = %q", got)
+	}
+
+	ro, err := sql.Open(DriverName, "file:"+path+"?mode=ro&_txlock=exclusive")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer ro.Close()
+	var n int
+	if err := ro.QueryRow("SELECT count(*) FROM t").Scan(&n); err != nil || n != 1 {
+		t.Errorf("count = %d, %v", n, err)
+	}
+	if _, err := ro.Exec("INSERT INTO t VALUES (2)"); err == nil || !strings.Contains(err.Error(), "readonly") {
+		t.Errorf("insert into a read-only database: %v", err)
+	}
+
+	c.ConnectHook = func(*sqlvibe.Database) error { return errors.New("no thanks") }
+	if err := sql.OpenDB(c).Ping(); err == nil || err.Error() != "no thanks" {
+		t.Errorf("failing hook: %v", err)
+	}
+}
+
+func TestSqlvibeNewConnector(t *testing.T) {
+	var hooked []string
+	hook := func(name string) func(*sqlvibe.Database) error {
+		return func(db *sqlvibe.Database) error {
+			hooked = append(hooked, name)
+			_, err := db.Exec("CREATE TABLE IF NOT EXISTS t (x)")
+			return err
+		}
+	}
+	cfg := &sqlvibe.Config{Path: filepath.Join(t.TempDir(), "app.db"), ForeignKeys: true}
+	c, err := sqlvibe.NewConnector(cfg, hook("first"), hook("second"))
+	if err != nil {
+		t.Fatalf("NewConnector: %v", err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	var fk int
+	if err := db.QueryRow("PRAGMA foreign_keys").Scan(&fk); err != nil || fk != 1 {
+		t.Errorf("foreign_keys = %d, %v", fk, err)
+	}
+	if strings.Join(hooked, ",") != "first,second" {
+		t.Errorf("hooks ran as %v", hooked)
+	}
+
+	hooked = nil
+	c, err = sqlvibe.NewConnector(cfg, func(*sqlvibe.Database) error { return errors.New("not today") }, hook("never"))
+	if err != nil {
+		t.Fatalf("NewConnector: %v", err)
+	}
+	if err := sql.OpenDB(c).Ping(); err == nil || err.Error() != "not today" || hooked != nil {
+		t.Errorf("failing hook: %v; later hooks ran %v", err, hooked)
+	}
+	if c, err := sqlvibe.NewConnector(cfg); err != nil || c.(*Connector).ConnectHook != nil {
+		t.Errorf("NewConnector without hooks: %v", err)
+	}
+}
+
 func TestValueConversion(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -89,3 +261,42 @@ func TestValueConversion(t *testing.T) {
 		})
 	}
 }
+
+func TestSharedMemoryPool(t *testing.T) {
+	db, err := sql.Open(DriverName, "file:pooltest?mode=memory&cache=shared&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(4)
+	if _, err := db.Exec("CREATE TABLE t (x)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// Hold one connection in a transaction so the others are new ones
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			_, err := db.Exec("INSERT INTO t VALUES (?)", i)
+			errs <- err
+		}(i)
+	}
+	if _, err := tx.Exec("INSERT INTO t VALUES (100)"); err != nil {
+		t.Fatalf("insert in transaction: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("insert: %v", err)
+		}
+	}
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM t").Scan(&n); err != nil || n != 9 {
+		t.Errorf("count = %d, %v; want 9", n, err)
+	}
+}
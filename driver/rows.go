@@ -3,6 +3,7 @@ package driver
 import (
 	"database/sql/driver"
 	"io"
+	"reflect"
 
 	"github.com/cyw0ng95/sqlvibe/pkg/sqlvibe"
 )
@@ -50,5 +51,46 @@ func (r *Rows) Next(dest []driver.Value) error {
 	return nil
 }
 
-// Ensure Rows implements driver.Rows.
-var _ driver.Rows = &Rows{}
+// columnType returns the description of column index.
+func (r *Rows) columnType(index int) sqlvibe.ColumnType {
+	if r.rows == nil {
+		return sqlvibe.ColumnType{}
+	}
+	types := r.rows.ColumnTypes()
+	if index < 0 || index >= len(types) {
+		return sqlvibe.ColumnType{}
+	}
+	return types[index]
+}
+
+// ColumnTypeDatabaseTypeName returns the declared type of the column, such
+// as "INTEGER" or "VARCHAR", or "" for a computed column.
+func (r *Rows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.columnType(index).DatabaseTypeName()
+}
+
+// ColumnTypeNullable reports whether the column can hold NULL; ok is false
+// when that is unknown.
+func (r *Rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	ct := r.columnType(index)
+	return ct.Nullable, ct.NullableKnown
+}
+
+// ColumnTypeScanType returns a Go type suitable for scanning the column.
+func (r *Rows) ColumnTypeScanType(index int) reflect.Type {
+	return r.columnType(index).ScanType()
+}
+
+// ColumnTypeLength returns the length of a TEXT or BLOB column.
+func (r *Rows) ColumnTypeLength(index int) (length int64, ok bool) {
+	return r.columnType(index).Length()
+}
+
+// Ensure Rows implements driver.Rows and the column type interfaces.
+var (
+	_ driver.Rows                           = &Rows{}
+	_ driver.RowsColumnTypeDatabaseTypeName = &Rows{}
+	_ driver.RowsColumnTypeNullable         = &Rows{}
+	_ driver.RowsColumnTypeScanType         = &Rows{}
+	_ driver.RowsColumnTypeLength           = &Rows{}
+)
@@ -7,7 +7,8 @@ import (
 )
 
 // toDriverValue converts a sqlvibe value to a driver.Value.
-// Supported types: nil, int64, float64, string, []byte, time.Time.
+// Supported types: nil, int64, float64, string, []byte, and time.Time, which
+// columns declared DATE, DATETIME or TIMESTAMP scan as.
 func toDriverValue(v interface{}) (driver.Value, error) {
 	if v == nil {
 		return nil, nil
@@ -2,16 +2,51 @@ module github.com/cyw0ng95/sqlvibe
 
 go 1.25.6
 
-require github.com/glebarez/go-sqlite v1.21.2
+require (
+	github.com/apache/arrow-go/v18 v18.4.1
+	github.com/glebarez/go-sqlite v1.21.2
+)
 
 require (
+	github.com/andybalholm/brotli v1.2.0 // indirect
+	github.com/apache/thrift v0.22.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
-	github.com/google/uuid v1.3.0 // indirect
-	github.com/mattn/go-isatty v0.0.17 // indirect
+	github.com/goccy/go-json v0.10.5 // indirect
+	github.com/golang/snappy v1.0.0 // indirect
+	github.com/google/flatbuffers v25.2.10+incompatible // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.3.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.22 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
-	golang.org/x/sys v0.7.0 // indirect
-	modernc.org/libc v1.22.5 // indirect
-	modernc.org/mathutil v1.5.0 // indirect
-	modernc.org/memory v1.5.0 // indirect
-	modernc.org/sqlite v1.23.1 // indirect
+	github.com/zeebo/xxh3 v1.0.2 // indirect
+	golang.org/x/exp v0.0.0-20250408133849-7e4ce0ab07d0 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/sync v0.16.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250707201910-8d1bb00bc6a7 // indirect
+	google.golang.org/grpc v1.75.0 // indirect
+	google.golang.org/protobuf v1.36.8 // indirect
+	modernc.org/libc v1.41.0 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.7.2 // indirect
+	modernc.org/sqlite v1.29.6 // indirect
+)
+
+// arrow-go/v18 requires newer releases of these for packages sqlvibe does
+// not build (its Flight SQL example uses modernc.org/sqlite). Keep the
+// versions the SQLite reference tests were written against.
+replace (
+	github.com/google/uuid => github.com/google/uuid v1.3.0
+	github.com/mattn/go-isatty => github.com/mattn/go-isatty v0.0.17
+	golang.org/x/sys => golang.org/x/sys v0.7.0
+	modernc.org/libc => modernc.org/libc v1.22.5
+	modernc.org/mathutil => modernc.org/mathutil v1.5.0
+	modernc.org/memory => modernc.org/memory v1.5.0
+	modernc.org/sqlite => modernc.org/sqlite v1.23.1
 )
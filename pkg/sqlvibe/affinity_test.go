@@ -0,0 +1,158 @@
+package sqlvibe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestColumnAffinityOnInsert(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	db.Exec("CREATE TABLE t (i INTEGER, r REAL, s TEXT, n NUMERIC, b BLOB, x)")
+	if _, err := db.Exec("INSERT INTO t VALUES ('5', 2, 3, '4.0', '7', '8')"); err != nil {
+		t.Fatalf("Insert error: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (1.0, '2.5', 4.5, 'abc', 9, 10)"); err != nil {
+		t.Fatalf("Insert error: %v", err)
+	}
+
+	rows, err := db.Query("SELECT typeof(i), typeof(r), typeof(s), typeof(n), typeof(b), typeof(x) FROM t")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	expected := [][]string{
+		{"integer", "real", "text", "integer", "text", "text"},
+		{"integer", "real", "text", "text", "integer", "integer"},
+	}
+	if len(rows.Data) != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), len(rows.Data))
+	}
+	for ri, row := range rows.Data {
+		for ci, want := range expected[ri] {
+			if row[ci] != want {
+				t.Errorf("row %d col %s: expected %s, got %v", ri, rows.Columns[ci], want, row[ci])
+			}
+		}
+	}
+}
+
+func TestColumnAffinityOnUpdate(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	db.Exec("CREATE TABLE t (id INTEGER, v INTEGER)")
+	db.Exec("INSERT INTO t VALUES (1, 0)")
+	if _, err := db.Exec("UPDATE t SET v = '42' WHERE id = 1"); err != nil {
+		t.Fatalf("Update error: %v", err)
+	}
+
+	rows, err := db.Query("SELECT typeof(v) FROM t WHERE v > '9'")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	if len(rows.Data) != 1 || rows.Data[0][0] != "integer" {
+		t.Errorf("expected one integer row, got %v", rows.Data)
+	}
+}
+
+func TestStrictTableRejectsMismatchedValues(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE s (a INTEGER, b TEXT, c ANY) STRICT"); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO s VALUES ('12', 5, 1.5)"); err != nil {
+		t.Fatalf("lossless insert should succeed: %v", err)
+	}
+
+	cases := []string{
+		"INSERT INTO s VALUES ('abc', 'x', 1)",
+		"INSERT INTO s SELECT 'abc', 'x', 1",
+		"UPDATE s SET a = 'zz'",
+	}
+	for _, sql := range cases {
+		_, err := db.Exec(sql)
+		if err == nil || !strings.Contains(err.Error(), "cannot store TEXT value in INTEGER column s.a") {
+			t.Errorf("%s: expected datatype error, got %v", sql, err)
+		}
+	}
+
+	rows, err := db.Query("SELECT a, typeof(a), b, typeof(b), typeof(c) FROM s")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	if len(rows.Data) != 1 {
+		t.Fatalf("expected 1 row after rejected writes, got %d", len(rows.Data))
+	}
+	if rows.Data[0][1] != "integer" || rows.Data[0][2] != "5" || rows.Data[0][4] != "real" {
+		t.Errorf("unexpected row: %v", rows.Data[0])
+	}
+}
+
+func TestStrictTableColumnTypes(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE s1 (a VARCHAR(10)) STRICT"); err == nil {
+		t.Error("expected unknown datatype error")
+	}
+	if _, err := db.Exec("CREATE TABLE s2 (a) STRICT"); err == nil {
+		t.Error("expected missing datatype error")
+	}
+	if _, err := db.Exec("CREATE TABLE s3 (a INT) UNKNOWN"); err == nil {
+		t.Error("expected unknown table option error")
+	}
+
+	db.Exec("CREATE TABLE s (a INT) STRICT")
+	if _, err := db.Exec("ALTER TABLE s ADD COLUMN b DATE"); err == nil {
+		t.Error("expected ALTER ADD COLUMN to reject a non-STRICT type")
+	}
+	if _, err := db.Exec("ALTER TABLE s ADD COLUMN c REAL DEFAULT 3"); err != nil {
+		t.Errorf("ALTER ADD COLUMN error: %v", err)
+	}
+
+	rows, err := db.Query("PRAGMA table_list")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	for _, row := range rows.Data {
+		if row[1] == "s" && row[5] != int64(1) {
+			t.Errorf("expected strict=1 for s, got %v", row[5])
+		}
+	}
+}
+
+func TestComparisonAffinity(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	db.Exec("CREATE TABLE t (i INTEGER, s TEXT, b BLOB, x)")
+	db.Exec("INSERT INTO t VALUES (10, 10, 10, 10)")
+
+	cases := map[string]int{
+		"i = '10'":                    1,
+		"'10' = i":                    1,
+		"s = 10":                      1,
+		"s < 9":                       1,
+		"i = s":                       1,
+		"t.b = '10'":                  0,
+		"x = '10'":                    0,
+		"b = 10":                      1,
+		"CAST(b AS TEXT) = 10":        1,
+		"(CAST(x AS INTEGER)) = '10'": 1,
+		"rowid = '1'":                 1,
+		"'' = x":                      0,
+	}
+	for where, want := range cases {
+		rows, err := db.Query("SELECT count(*) FROM t WHERE " + where)
+		if err != nil {
+			t.Errorf("%s: %v", where, err)
+			continue
+		}
+		if got := rows.Data[0][0]; got != int64(want) {
+			t.Errorf("%s: got %v, want %d", where, got, want)
+		}
+	}
+}
@@ -0,0 +1,560 @@
+package sqlvibe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+
+	cgo "github.com/cyw0ng95/sqlvibe/pkg/sqlvibe/cgo"
+)
+
+// arrowBatchRows is the number of rows in each record batch written, and
+// read from Parquet files at a time.
+const arrowBatchRows = 64 << 10
+
+// ParquetExportOptions controls how ExportParquet behaves.
+type ParquetExportOptions struct {
+	Compression  string // "snappy" (default), "zstd", "gzip" or "none"
+	RowGroupSize int    // rows per row group (default 65536)
+}
+
+// ArrowImportOptions controls how ImportArrow and ImportParquet behave.
+type ArrowImportOptions struct {
+	// CreateTable creates the table if it does not exist, with a column per
+	// field declared after its Arrow type (see ImportArrow), NOT NULL for
+	// fields that are not nullable.
+	CreateTable bool
+	// MaxRejects is the number of rows that may violate a constraint before
+	// the import fails, as for BulkInsert.
+	MaxRejects int
+}
+
+// ExportArrow executes sql and writes the result to w as an Arrow IPC
+// stream. Each column is typed after its declared type and the values it
+// holds: int64 for integers, float64 for numbers, utf8 for text, binary for
+// blobs, bool for a BOOLEAN column of 0 and 1, date32 for a DATE column of
+// dates and microsecond UTC timestamps for other date and time columns. A
+// column whose values differ in type is written as utf8. The batches are
+// written from a result read into memory whole, so a large result needs
+// memory in proportion to its size.
+func (db *Database) ExportArrow(w io.Writer, sql string) error {
+	rows, err := db.Query(sql)
+	if err != nil {
+		return fmt.Errorf("ExportArrow: query: %w", err)
+	}
+	o := db.timeOptions()
+	schema, kinds := arrowSchema(rows, o)
+	iw := ipc.NewWriter(w, ipc.WithSchema(schema))
+	err = writeArrowBatches(rows, schema, kinds, o, arrowBatchRows, iw.Write)
+	if cerr := iw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("ExportArrow: %w", err)
+	}
+	return nil
+}
+
+// ExportParquet executes sql and writes the result to w as a Parquet file,
+// with columns typed as ExportArrow types them. Like ExportArrow it reads
+// the whole result into memory first.
+func (db *Database) ExportParquet(w io.Writer, sql string, opts ParquetExportOptions) error {
+	codec, err := parquetCodec(opts.Compression)
+	if err != nil {
+		return fmt.Errorf("ExportParquet: %w", err)
+	}
+	if opts.RowGroupSize <= 0 {
+		opts.RowGroupSize = arrowBatchRows
+	}
+	rows, err := db.Query(sql)
+	if err != nil {
+		return fmt.Errorf("ExportParquet: query: %w", err)
+	}
+	o := db.timeOptions()
+	schema, kinds := arrowSchema(rows, o)
+	props := parquet.NewWriterProperties(
+		parquet.WithCompression(codec),
+		parquet.WithMaxRowGroupLength(int64(opts.RowGroupSize)))
+	// The file writer would close w if it were a Closer; w is the caller's
+	fw, err := pqarrow.NewFileWriter(schema, struct{ io.Writer }{w}, props,
+		pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	if err != nil {
+		return fmt.Errorf("ExportParquet: %w", err)
+	}
+	err = writeArrowBatches(rows, schema, kinds, o, opts.RowGroupSize, fw.Write)
+	if cerr := fw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("ExportParquet: %w", err)
+	}
+	return nil
+}
+
+// ImportArrow reads an Arrow IPC stream from r and inserts its rows into
+// table through BulkInsert, matching fields to columns by name. Integers are
+// stored as INTEGER (unsigned ones beyond int64 as REAL), floats as REAL,
+// strings and decimals as TEXT, binaries as BLOB, booleans as 1 and 0, and
+// dates and timestamps as times are stored (see SetTimeFormat); lists,
+// structs and maps become JSON text, dictionaries their values and other
+// types their text form. Returns the number of rows inserted.
+func (db *Database) ImportArrow(table string, r io.Reader, opts ArrowImportOptions) (int, error) {
+	rr, err := ipc.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("ImportArrow: %w", err)
+	}
+	defer rr.Release()
+	n, err := db.importArrow(table, rr, opts)
+	if err != nil {
+		return n, fmt.Errorf("ImportArrow: %w", err)
+	}
+	return n, nil
+}
+
+// ImportParquet reads the Parquet file held by the size bytes of r and
+// inserts its rows into table as ImportArrow does.
+func (db *Database) ImportParquet(table string, r io.ReaderAt, size int64, opts ArrowImportOptions) (int, error) {
+	rr, err := openParquet(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return 0, fmt.Errorf("ImportParquet: %w", err)
+	}
+	defer rr.Release()
+	n, err := db.importArrow(table, rr, opts)
+	if err != nil {
+		return n, fmt.Errorf("ImportParquet: %w", err)
+	}
+	return n, nil
+}
+
+func (db *Database) importArrow(table string, rr array.RecordReader, opts ArrowImportOptions) (int, error) {
+	fields := rr.Schema().Fields()
+	cols := make([]string, len(fields))
+	for i, f := range fields {
+		cols[i] = f.Name
+	}
+	if opts.CreateTable {
+		defs := make([]string, len(fields))
+		for i, f := range fields {
+			defs[i] = quoteIdent(f.Name)
+			if t := arrowDeclType(f.Type); t != "" {
+				defs[i] += " " + t
+			}
+			if !f.Nullable {
+				defs[i] += " NOT NULL"
+			}
+		}
+		if _, err := db.Exec("CREATE TABLE IF NOT EXISTS " + quoteIdent(table) + " (" + strings.Join(defs, ", ") + ")"); err != nil {
+			return 0, err
+		}
+	}
+	res, err := db.BulkInsert(table, cols, &arrowInput{rr: rr, o: db.timeOptions()}, BulkOptions{MaxRejects: opts.MaxRejects})
+	return res.Inserted, err
+}
+
+// openParquet returns a reader of the record batches of a Parquet file.
+func openParquet(r parquet.ReaderAtSeeker) (pqarrow.RecordReader, error) {
+	pf, err := file.NewParquetReader(r)
+	if err != nil {
+		return nil, err
+	}
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: arrowBatchRows}, memory.DefaultAllocator)
+	if err != nil {
+		return nil, err
+	}
+	return fr.GetRecordReader(context.Background(), nil, nil)
+}
+
+func parquetCodec(name string) (compress.Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "snappy":
+		return compress.Codecs.Snappy, nil
+	case "zstd":
+		return compress.Codecs.Zstd, nil
+	case "gzip":
+		return compress.Codecs.Gzip, nil
+	case "none", "uncompressed":
+		return compress.Codecs.Uncompressed, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", name)
+}
+
+// ── Exporting ────────────────────────────────────────────────────────────────
+
+// arrowKind is the Arrow type a result column is written as.
+type arrowKind int
+
+const (
+	arrowText arrowKind = iota
+	arrowInt
+	arrowFloat
+	arrowBlob
+	arrowBool
+	arrowDate
+	arrowTimestamp
+)
+
+func (k arrowKind) dataType() arrow.DataType {
+	switch k {
+	case arrowInt:
+		return arrow.PrimitiveTypes.Int64
+	case arrowFloat:
+		return arrow.PrimitiveTypes.Float64
+	case arrowBlob:
+		return arrow.BinaryTypes.Binary
+	case arrowBool:
+		return arrow.FixedWidthTypes.Boolean
+	case arrowDate:
+		return arrow.FixedWidthTypes.Date32
+	case arrowTimestamp:
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+	}
+	return arrow.BinaryTypes.String
+}
+
+// arrowSchema returns the schema rows are written with and the kind of
+// each column. Columns are nullable unless NOT NULL and without NULLs.
+func arrowSchema(rows *Rows, o timeOptions) (*arrow.Schema, []arrowKind) {
+	types := rows.ColumnTypes()
+	fields := make([]arrow.Field, len(types))
+	kinds := make([]arrowKind, len(types))
+	for i, ct := range types {
+		var hasNull bool
+		kinds[i], hasNull = columnArrowKind(rows.Data, i, ct, o)
+		fields[i] = arrow.Field{
+			Name:     ct.Name,
+			Type:     kinds[i].dataType(),
+			Nullable: hasNull || !ct.NullableKnown || ct.Nullable,
+		}
+	}
+	return arrow.NewSchema(fields, nil), kinds
+}
+
+// columnArrowKind picks the kind of column col of data, and reports
+// whether it holds a NULL.
+func columnArrowKind(data [][]interface{}, col int, ct ColumnType, o timeOptions) (arrowKind, bool) {
+	var ints, floats, texts, blobs, nulls int
+	for _, row := range data {
+		switch row[col].(type) {
+		case int64:
+			ints++
+		case float64:
+			floats++
+		case string:
+			texts++
+		case []byte:
+			blobs++
+		default:
+			nulls++
+		}
+	}
+	n := ints + floats + texts + blobs
+	decl := ct.DatabaseTypeName()
+	if isTimeType(decl) {
+		date, ok := decl == "DATE", true
+		for _, row := range data {
+			v := row[col]
+			if v == nil {
+				continue
+			}
+			t, err := o.parse(v)
+			if !o.stores(v) || err != nil {
+				ok = false
+				break
+			}
+			if h, m, s := t.Clock(); h != 0 || m != 0 || s != 0 || t.Nanosecond() != 0 {
+				date = false
+			}
+		}
+		if ok && date {
+			return arrowDate, nulls > 0
+		}
+		if ok {
+			return arrowTimestamp, nulls > 0
+		}
+	}
+	if decl == "BOOLEAN" || decl == "BOOL" {
+		ok := ints == n
+		for _, row := range data {
+			if v, isInt := row[col].(int64); isInt && v != 0 && v != 1 {
+				ok = false
+			}
+		}
+		if ok {
+			return arrowBool, nulls > 0
+		}
+	}
+	switch {
+	case n == 0:
+		switch ct.affinity() {
+		case "INTEGER":
+			return arrowInt, true
+		case "REAL":
+			return arrowFloat, true
+		case "BLOB":
+			return arrowBlob, true
+		}
+	case ints == n:
+		return arrowInt, nulls > 0
+	case ints+floats == n:
+		return arrowFloat, nulls > 0
+	case blobs == n:
+		return arrowBlob, nulls > 0
+	}
+	return arrowText, nulls > 0
+}
+
+// writeArrowBatches writes the rows as record batches of up to batch rows.
+func writeArrowBatches(rows *Rows, schema *arrow.Schema, kinds []arrowKind, o timeOptions, batch int,
+	write func(arrow.RecordBatch) error) error {
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	for start := 0; start < len(rows.Data); start += batch {
+		for _, row := range rows.Data[start:min(start+batch, len(rows.Data))] {
+			for i, v := range row {
+				appendArrowValue(b.Field(i), kinds[i], v, o)
+			}
+		}
+		rec := b.NewRecordBatch()
+		err := write(rec)
+		rec.Release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendArrowValue appends v to the builder of a column of kind k, which
+// columnArrowKind chose to hold it.
+func appendArrowValue(fb array.Builder, k arrowKind, v interface{}, o timeOptions) {
+	if v == nil {
+		fb.AppendNull()
+		return
+	}
+	switch k {
+	case arrowInt:
+		fb.(*array.Int64Builder).Append(v.(int64))
+	case arrowFloat:
+		f, ok := v.(float64)
+		if !ok {
+			f = float64(v.(int64))
+		}
+		fb.(*array.Float64Builder).Append(f)
+	case arrowBlob:
+		fb.(*array.BinaryBuilder).Append(v.([]byte))
+	case arrowBool:
+		fb.(*array.BooleanBuilder).Append(v.(int64) != 0)
+	case arrowDate:
+		t, _ := o.parse(v)
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		fb.(*array.Date32Builder).Append(arrow.Date32(day.Unix() / 86400))
+	case arrowTimestamp:
+		t, _ := o.parse(v)
+		fb.(*array.TimestampBuilder).Append(arrow.Timestamp(t.UnixMicro()))
+	default:
+		fb.(*array.StringBuilder).Append(valueToString(v))
+	}
+}
+
+// ── Importing ────────────────────────────────────────────────────────────────
+
+// arrowInput is the RowSource of the record batches of an Arrow reader.
+type arrowInput struct {
+	rr  array.RecordReader
+	rec arrow.RecordBatch
+	i   int // next row of rec
+	o   timeOptions
+	row []interface{}
+}
+
+// Next implements RowSource.
+func (in *arrowInput) Next() ([]interface{}, error) {
+	for in.rec == nil || in.i >= int(in.rec.NumRows()) {
+		if !in.rr.Next() {
+			if err := in.rr.Err(); err != nil && err != io.EOF {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		in.rec, in.i = in.rr.RecordBatch(), 0
+	}
+	in.row = in.row[:0]
+	for _, col := range in.rec.Columns() {
+		v, err := arrowValue(col, in.i, in.o)
+		if err != nil {
+			return nil, err
+		}
+		in.row = append(in.row, v)
+	}
+	in.i++
+	return in.row, nil
+}
+
+// arrowValue returns value i of arr as it is stored: nil, bool, int64,
+// float64, string or []byte, copied out of the array's buffers.
+func arrowValue(arr arrow.Array, i int, o timeOptions) (interface{}, error) {
+	if arr.IsNull(i) {
+		return nil, nil
+	}
+	switch a := arr.(type) {
+	case *array.Boolean:
+		return a.Value(i), nil
+	case *array.Int8:
+		return int64(a.Value(i)), nil
+	case *array.Int16:
+		return int64(a.Value(i)), nil
+	case *array.Int32:
+		return int64(a.Value(i)), nil
+	case *array.Int64:
+		return a.Value(i), nil
+	case *array.Uint8:
+		return int64(a.Value(i)), nil
+	case *array.Uint16:
+		return int64(a.Value(i)), nil
+	case *array.Uint32:
+		return int64(a.Value(i)), nil
+	case *array.Uint64:
+		return bulkUint(a.Value(i)), nil
+	case *array.Float16:
+		return float64(a.Value(i).Float32()), nil
+	case *array.Float32:
+		return float64(a.Value(i)), nil
+	case *array.Float64:
+		return a.Value(i), nil
+	case *array.String:
+		return strings.Clone(a.Value(i)), nil
+	case *array.LargeString:
+		return strings.Clone(a.Value(i)), nil
+	case *array.StringView:
+		return strings.Clone(a.Value(i)), nil
+	case *array.Binary:
+		return bytes.Clone(a.Value(i)), nil
+	case *array.LargeBinary:
+		return bytes.Clone(a.Value(i)), nil
+	case *array.BinaryView:
+		return bytes.Clone(a.Value(i)), nil
+	case *array.FixedSizeBinary:
+		return bytes.Clone(a.Value(i)), nil
+	case *array.Date32:
+		return bindDate(a.Value(i).ToTime(), o), nil
+	case *array.Date64:
+		return bindDate(a.Value(i).ToTime(), o), nil
+	case *array.Timestamp:
+		tt := a.DataType().(*arrow.TimestampType)
+		t := a.Value(i).ToTime(tt.Unit)
+		if tt.TimeZone == "" {
+			// A timestamp without a zone is a wall clock reading
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), o.location())
+		}
+		return o.bind(t), nil
+	case *array.Dictionary:
+		return arrowValue(a.Dictionary(), a.GetValueIndex(i), o)
+	case array.ExtensionArray:
+		return arrowValue(a.Storage(), i, o)
+	case *array.List, *array.LargeList, *array.FixedSizeList, *array.ListView, *array.LargeListView,
+		*array.Struct, *array.Map:
+		b, err := json.Marshal(arr.GetOneForMarshal(i))
+		if err != nil {
+			return nil, fmt.Errorf("%s value: %w", arr.DataType(), err)
+		}
+		return string(b), nil
+	}
+	return arr.ValueStr(i), nil
+}
+
+// bindDate returns the date of t as it is stored: as text, the date alone.
+func bindDate(t time.Time, o timeOptions) interface{} {
+	if o.format == TimeFormatText {
+		return t.Format("2006-01-02")
+	}
+	return o.bind(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, o.location()))
+}
+
+// arrowDeclType returns the declared type of a column holding values of
+// type dt, or "" for the null type.
+func arrowDeclType(dt arrow.DataType) string {
+	switch dt.ID() {
+	case arrow.NULL:
+		return ""
+	case arrow.BOOL:
+		return "BOOLEAN"
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64,
+		arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64:
+		return "INTEGER"
+	case arrow.FLOAT16, arrow.FLOAT32, arrow.FLOAT64:
+		return "REAL"
+	case arrow.DECIMAL32, arrow.DECIMAL64, arrow.DECIMAL128, arrow.DECIMAL256:
+		d := dt.(arrow.DecimalType)
+		return fmt.Sprintf("DECIMAL(%d,%d)", d.GetPrecision(), d.GetScale())
+	case arrow.BINARY, arrow.LARGE_BINARY, arrow.BINARY_VIEW, arrow.FIXED_SIZE_BINARY:
+		return "BLOB"
+	case arrow.DATE32, arrow.DATE64:
+		return "DATE"
+	case arrow.TIMESTAMP:
+		return "TIMESTAMP"
+	case arrow.DICTIONARY:
+		return arrowDeclType(dt.(*arrow.DictionaryType).ValueType)
+	case arrow.EXTENSION:
+		return arrowDeclType(dt.(arrow.ExtensionType).StorageType())
+	}
+	return "TEXT"
+}
+
+// ── read_parquet ─────────────────────────────────────────────────────────────
+
+// registerTableFunctions makes the built-in table functions usable on cdb.
+func registerTableFunctions(cdb *cgo.DB) error {
+	return cdb.RegisterTableFunction("read_parquet", readParquet)
+}
+
+// readParquet is the table function read_parquet(path): the rows of a
+// Parquet file, in columns declared as ImportParquet would create them.
+func readParquet(args []interface{}, t *cgo.Table) error {
+	path, ok := "", len(args) == 1
+	if ok {
+		path, ok = args[0].(string)
+	}
+	if !ok {
+		return fmt.Errorf("expected the path of a file")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rr, err := openParquet(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer rr.Release()
+	for _, field := range rr.Schema().Fields() {
+		t.AddColumn(field.Name, arrowDeclType(field.Type))
+	}
+	in := &arrowInput{rr: rr}
+	for {
+		row, err := in.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := t.AddRow(row); err != nil {
+			return err
+		}
+	}
+}
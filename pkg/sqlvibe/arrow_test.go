@@ -0,0 +1,180 @@
+package sqlvibe
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+)
+
+func TestArrowRoundTrip(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE src (id INTEGER NOT NULL, score REAL, name TEXT, data BLOB, day DATE, at TIMESTAMP, ok BOOLEAN, mixed)",
+		"INSERT INTO src VALUES (1, 1.5, 'ann', X'0102', '2024-01-15', '2024-01-15 10:30:00', 1, 7)",
+		"INSERT INTO src VALUES (2, 2, NULL, NULL, NULL, '2024-02-01 00:00:00.25', 0, 'x')",
+		"INSERT INTO src VALUES (3, NULL, 'c,d', X'', '1999-12-31', NULL, NULL, 2.5)")
+
+	var buf bytes.Buffer
+	if err := db.ExportArrow(&buf, "SELECT * FROM src ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	rr, err := ipc.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, f := range rr.Schema().Fields() {
+		s := f.Name + " " + f.Type.String()
+		if !f.Nullable {
+			s += " not null"
+		}
+		fields = append(fields, s)
+	}
+	rr.Release()
+	want := "id int64 not null, score float64, name utf8, data binary, day date32, " +
+		"at timestamp[us, tz=UTC], ok bool, mixed utf8"
+	if got := strings.Join(fields, ", "); got != want {
+		t.Errorf("schema:\n%s\nwant:\n%s", got, want)
+	}
+
+	n, err := db.ImportArrow("dst", bytes.NewReader(buf.Bytes()), ArrowImportOptions{CreateTable: true})
+	if err != nil || n != 3 {
+		t.Fatalf("ImportArrow = %d, %v", n, err)
+	}
+	cols, _ := db.GetColumns("dst")
+	var decl []string
+	for _, c := range cols {
+		decl = append(decl, c.Name+" "+c.Type)
+	}
+	if got := strings.Join(decl, ", "); got != "id INTEGER, score REAL, name TEXT, data BLOB, day DATE, at TIMESTAMP, ok BOOLEAN, mixed TEXT" {
+		t.Errorf("columns: %s", got)
+	}
+	got := queryAll(t, db, "SELECT id, score, name, hex(data), day, at, ok, mixed FROM dst ORDER BY id")
+	want = "1,1.5,ann,0102,2024-01-15,2024-01-15 10:30:00+00:00,1,7|" +
+		"2,2,<nil>,<nil>,<nil>,2024-02-01 00:00:00.25+00:00,0,x|" +
+		"3,<nil>,c,d,,1999-12-31,<nil>,<nil>,2.5"
+	if got != want {
+		t.Errorf("rows:\n%s\nwant:\n%s", got, want)
+	}
+	if _, err := db.Exec("INSERT INTO dst (id) VALUES (NULL)"); err == nil {
+		t.Error("NOT NULL field imported as a nullable column")
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE src (id INTEGER NOT NULL, score REAL, name TEXT, data BLOB, day DATE, at TIMESTAMP, ok BOOLEAN, mixed)",
+		"INSERT INTO src VALUES (1, 1.5, 'ann', X'0102', '2024-01-15', '2024-01-15 10:30:00', 1, 7)",
+		"INSERT INTO src VALUES (2, 2, NULL, NULL, NULL, '2024-02-01 00:00:00.25', 0, 'x')",
+		"INSERT INTO src VALUES (3, NULL, 'c,d', X'', '1999-12-31', NULL, NULL, 2.5)")
+
+	for _, codec := range []string{"", "zstd", "gzip", "none"} {
+		var buf bytes.Buffer
+		err := db.ExportParquet(&buf, "SELECT * FROM src ORDER BY id", ParquetExportOptions{Compression: codec, RowGroupSize: 2})
+		if err != nil {
+			t.Fatalf("%q: %v", codec, err)
+		}
+		execOK(t, db, "DROP TABLE IF EXISTS dst")
+		n, err := db.ImportParquet("dst", bytes.NewReader(buf.Bytes()), int64(buf.Len()), ArrowImportOptions{CreateTable: true})
+		if err != nil || n != 3 {
+			t.Fatalf("%q: ImportParquet = %d, %v", codec, n, err)
+		}
+		got := queryAll(t, db, "SELECT id, typeof(score), name, hex(data), day, at, ok, mixed FROM dst ORDER BY id")
+		want := "1,real,ann,0102,2024-01-15,2024-01-15 10:30:00+00:00,1,7|" +
+			"2,real,<nil>,<nil>,<nil>,2024-02-01 00:00:00.25+00:00,0,x|" +
+			"3,null,c,d,,1999-12-31,<nil>,<nil>,2.5"
+		if got != want {
+			t.Errorf("%q rows:\n%s\nwant:\n%s", codec, got, want)
+		}
+	}
+
+	if err := db.ExportParquet(&bytes.Buffer{}, "SELECT 1", ParquetExportOptions{Compression: "lz9"}); err == nil ||
+		!strings.Contains(err.Error(), `unknown compression "lz9"`) {
+		t.Errorf("unknown codec: %v", err)
+	}
+	if _, err := db.ImportParquet("dst", strings.NewReader("not parquet"), 11, ArrowImportOptions{}); err == nil {
+		t.Error("ImportParquet accepted a file that is not Parquet")
+	}
+}
+
+func TestArrowImportRejects(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE src (id INTEGER NOT NULL, score REAL, name TEXT, data BLOB, day DATE, at TIMESTAMP, ok BOOLEAN, mixed)",
+		"INSERT INTO src VALUES (1, 1.5, 'ann', X'0102', '2024-01-15', '2024-01-15 10:30:00', 1, 7)",
+		"INSERT INTO src VALUES (2, 2, NULL, NULL, NULL, '2024-02-01 00:00:00.25', 0, 'x')",
+		"INSERT INTO src VALUES (3, NULL, 'c,d', X'', '1999-12-31', NULL, NULL, 2.5)")
+	execOK(t, db, "CREATE TABLE dst (id INTEGER PRIMARY KEY, name TEXT NOT NULL)")
+
+	var buf bytes.Buffer
+	if err := db.ExportArrow(&buf, "SELECT id, name FROM src"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ImportArrow("dst", bytes.NewReader(buf.Bytes()), ArrowImportOptions{}); err == nil ||
+		!strings.Contains(err.Error(), "NOT NULL constraint failed") {
+		t.Errorf("ImportArrow error = %v", err)
+	}
+	execOK(t, db, "DELETE FROM dst")
+	n, err := db.ImportArrow("dst", bytes.NewReader(buf.Bytes()), ArrowImportOptions{MaxRejects: 1})
+	if err != nil || n != 2 {
+		t.Errorf("ImportArrow with MaxRejects = %d, %v", n, err)
+	}
+}
+
+func TestReadParquet(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE src (id INTEGER NOT NULL, score REAL, name TEXT, data BLOB, day DATE, at TIMESTAMP, ok BOOLEAN, mixed)",
+		"INSERT INTO src VALUES (1, 1.5, 'ann', X'0102', '2024-01-15', '2024-01-15 10:30:00', 1, 7)",
+		"INSERT INTO src VALUES (2, 2, NULL, NULL, NULL, '2024-02-01 00:00:00.25', 0, 'x')",
+		"INSERT INTO src VALUES (3, NULL, 'c,d', X'', '1999-12-31', NULL, NULL, 2.5)")
+
+	path := filepath.Join(t.TempDir(), "src.parquet")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ExportParquet(f, "SELECT id, name, day FROM src", ParquetExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	lit := "'" + path + "'"
+	if got := queryAll(t, db, "SELECT id, name FROM read_parquet("+lit+") WHERE id > 1 ORDER BY id"); got != "2,<nil>|3,c,d" {
+		t.Errorf("SELECT: %s", got)
+	}
+	if got := queryAll(t, db, "SELECT s.id, p.day FROM src s JOIN read_parquet("+lit+") AS p ON p.id = s.id WHERE s.id = 3"); got != "3,1999-12-31" {
+		t.Errorf("JOIN: %s", got)
+	}
+	if got := queryAll(t, db, "SELECT count(*) FROM src, read_parquet("+lit+") p"); got != "9" {
+		t.Errorf("comma join: %s", got)
+	}
+	execOK(t, db, "CREATE TABLE copy AS SELECT * FROM read_parquet("+lit+")",
+		"INSERT INTO copy SELECT * FROM read_parquet("+lit+") WHERE id = 1")
+	if got := queryAll(t, db, "SELECT id, count(*) FROM copy GROUP BY id ORDER BY id"); got != "1,2|2,1|3,1" {
+		t.Errorf("copy: %s", got)
+	}
+	// Not in a FROM clause, the name is an ordinary function call
+	if _, err := db.Query("SELECT read_parquet(" + lit + ")"); err == nil {
+		t.Error("read_parquet called as a scalar function")
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM read_parquet()",
+		"SELECT * FROM read_parquet(42)",
+		"SELECT * FROM read_parquet('" + filepath.Join(t.TempDir(), "missing.parquet") + "')",
+	} {
+		if _, err := db.Query(sql); err == nil || !strings.Contains(err.Error(), "read_parquet: ") {
+			t.Errorf("%s: %v", sql, err)
+		}
+	}
+}
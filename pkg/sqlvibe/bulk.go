@@ -0,0 +1,225 @@
+package sqlvibe
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// RowSource yields the rows of a bulk insert, each holding a value per
+// column being loaded.
+type RowSource interface {
+	// Next returns the next row, or io.EOF after the last one. The row may
+	// be reused once Next is called again.
+	Next() ([]interface{}, error)
+}
+
+// RowSourceFunc adapts a function to a RowSource.
+type RowSourceFunc func() ([]interface{}, error)
+
+// Next calls f.
+func (f RowSourceFunc) Next() ([]interface{}, error) { return f() }
+
+// SliceRows returns a RowSource yielding rows in order.
+func SliceRows(rows [][]interface{}) RowSource {
+	i := 0
+	return RowSourceFunc(func() ([]interface{}, error) {
+		if i == len(rows) {
+			return nil, io.EOF
+		}
+		i++
+		return rows[i-1], nil
+	})
+}
+
+// BulkOptions controls how BulkInsert behaves.
+type BulkOptions struct {
+	// ChunkSize is the number of rows checked and committed at a time
+	// (default 10000). Chunks committed before a failure stay committed
+	// unless the insert runs inside a transaction.
+	ChunkSize int
+	// MaxRejects is the number of rows that may be rejected for violating
+	// a constraint before the insert fails. 0 fails at the first; -1
+	// allows any number.
+	MaxRejects int
+	// Progress, if set, is called after each chunk with the rows inserted
+	// and rejected so far.
+	Progress func(inserted, rejected int)
+	// DeferIndexes leaves the table's indexes to be built once, after the
+	// last chunk, rather than after every chunk. Queries run between
+	// chunks then build them on first use.
+	DeferIndexes bool
+}
+
+// BulkReject is a row BulkInsert did not store.
+type BulkReject struct {
+	Row    int           // position of the row in the source, from 0
+	Values []interface{} // the row's values, bound if binding succeeded
+	Err    error
+}
+
+// BulkResult reports the outcome of BulkInsert.
+type BulkResult struct {
+	Inserted int
+	Rejected []BulkReject
+}
+
+// BulkInsert stores the rows of src in columns of table (all of its columns,
+// in order, if columns is empty). Rows are bound as parameters would be and
+// stored without going through SQL text, checked against the table's NOT
+// NULL, PRIMARY KEY, UNIQUE, CHECK and FOREIGN KEY constraints a chunk at a
+// time. Triggers do not fire.
+//
+// Rows that violate a constraint, or cannot be bound, are skipped and listed
+// in the result up to opts.MaxRejects; the next one fails the insert, and
+// its chunk is not stored. A row that cannot be bound ends its chunk, so
+// rows ahead of it are checked first. The result always reports what was
+// committed.
+func (db *Database) BulkInsert(table string, columns []string, src RowSource, opts BulkOptions) (BulkResult, error) {
+	var res BulkResult
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 10000
+	}
+	if err := db.lock(); err != nil {
+		return res, err
+	}
+	bulk, err := db.cdb.BulkBegin(table, columns)
+	db.unlock()
+	if err != nil {
+		return res, fmt.Errorf("BulkInsert %s: %w", table, err)
+	}
+	defer bulk.Close()
+	ncols := bulk.Columns()
+	o := db.timeOptions()
+
+	tooMany := func(rejected int) bool {
+		return opts.MaxRejects >= 0 && rejected > opts.MaxRejects
+	}
+	fail := func(r BulkReject) error {
+		return fmt.Errorf("BulkInsert %s: row %d: %w", table, r.Row, r.Err)
+	}
+
+	rows := make([][]interface{}, 0, opts.ChunkSize)
+	var source []int        // source position of each row in rows
+	var unbound *BulkReject // row over the limit that could not be bound
+	for n, done := 0, false; !done; {
+		rows, source = rows[:0], source[:0]
+		start, first := n, len(res.Rejected) // where this chunk's rows and rejects begin
+		for len(rows) < opts.ChunkSize {
+			row, err := src.Next()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return res, fmt.Errorf("BulkInsert %s: reading row %d: %w", table, n, err)
+			}
+			vals, err := bulkValues(row, ncols, o)
+			if err != nil {
+				r := BulkReject{Row: n, Values: append([]interface{}(nil), row...), Err: err}
+				if tooMany(len(res.Rejected) + 1) {
+					unbound, done = &r, true
+					break
+				}
+				res.Rejected = append(res.Rejected, r)
+			} else {
+				rows = append(rows, vals)
+				source = append(source, n)
+			}
+			n++
+		}
+		if len(rows) > 0 {
+			budget := int64(-1)
+			if opts.MaxRejects >= 0 {
+				budget = int64(opts.MaxRejects - len(res.Rejected))
+			}
+			if err := db.lock(); err != nil {
+				return res, err
+			}
+			r, rejects, err := bulk.Insert(rows, budget)
+			if err == nil && !opts.DeferIndexes {
+				err = bulk.BuildIndexes()
+			}
+			db.unlock()
+			for _, rj := range rejects {
+				res.Rejected = append(res.Rejected, BulkReject{
+					Row: source[rj.Row], Values: rows[rj.Row], Err: errors.New(rj.Msg),
+				})
+			}
+			chunk := res.Rejected[first:]
+			sort.SliceStable(chunk, func(i, j int) bool { return chunk[i].Row < chunk[j].Row })
+			if err != nil {
+				if len(rejects) > 0 && tooMany(len(res.Rejected)) {
+					return res, fail(res.Rejected[len(res.Rejected)-1])
+				}
+				return res, fmt.Errorf("BulkInsert %s: %w", table, err)
+			}
+			res.Inserted += int(r.RowsAffected)
+		}
+		if unbound != nil {
+			res.Rejected = append(res.Rejected, *unbound)
+			return res, fail(*unbound)
+		}
+		if opts.Progress != nil && n > start {
+			opts.Progress(res.Inserted, len(res.Rejected))
+		}
+	}
+	if opts.DeferIndexes {
+		if err := db.lock(); err != nil {
+			return res, err
+		}
+		err := bulk.BuildIndexes()
+		db.unlock()
+		if err != nil {
+			return res, fmt.Errorf("BulkInsert %s: %w", table, err)
+		}
+	}
+	return res, nil
+}
+
+// bulkValues binds the values of a source row for the engine: nil, int64,
+// float64, bool, string or []byte.
+func bulkValues(row []interface{}, ncols int, o timeOptions) ([]interface{}, error) {
+	if len(row) != ncols {
+		return nil, fmt.Errorf("%d values for %d columns", len(row), ncols)
+	}
+	out := make([]interface{}, len(row))
+	for i, v := range row {
+		b, err := bindValue(v, o)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %w", i, err)
+		}
+		switch b := b.(type) {
+		case int:
+			out[i] = int64(b)
+		case int32:
+			out[i] = int64(b)
+		case int16:
+			out[i] = int64(b)
+		case int8:
+			out[i] = int64(b)
+		case uint:
+			out[i] = bulkUint(uint64(b))
+		case uint32:
+			out[i] = int64(b)
+		case uint64:
+			out[i] = bulkUint(b)
+		case float32:
+			out[i] = float64(b)
+		default:
+			out[i] = b
+		}
+	}
+	return out, nil
+}
+
+// bulkUint stores u as an INTEGER if it fits, as a REAL otherwise, as a
+// literal of it would be.
+func bulkUint(u uint64) interface{} {
+	if u > math.MaxInt64 {
+		return float64(u)
+	}
+	return int64(u)
+}
@@ -0,0 +1,225 @@
+package sqlvibe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func rejectRows(rs []BulkReject) []int {
+	var out []int
+	for _, r := range rs {
+		out = append(out, r.Row)
+	}
+	return out
+}
+
+func TestBulkInsert(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE p (id INTEGER PRIMARY KEY, email TEXT UNIQUE, age INTEGER NOT NULL CHECK (age >= 0))",
+		"INSERT INTO p VALUES (1, 'a@x', 30)")
+
+	rows := [][]interface{}{
+		{2, "b@x", 20},
+		{3, "a@x", 40},     // duplicate of a stored email
+		{4, "c@x", nil},    // NOT NULL
+		{5, "d@x", -1},     // CHECK
+		{2, "e@x", 50},     // duplicate of row 0's key
+		{nil, "f@x", "60"}, // id assigned, age converted
+		{6, "g@x"},         // too few values
+		{7, "b@x", 70},     // duplicate within the load
+		{uint8(8), "h@x", 5},
+	}
+	var progress []int
+	res, err := db.BulkInsert("p", nil, SliceRows(rows), BulkOptions{
+		ChunkSize:  4,
+		MaxRejects: -1,
+		Progress:   func(ins, rej int) { progress = append(progress, ins, rej) },
+	})
+	if err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+	if res.Inserted != 3 {
+		t.Errorf("Inserted = %d, want 3", res.Inserted)
+	}
+	if got := fmt.Sprint(rejectRows(res.Rejected)); got != "[1 2 3 4 6 7]" {
+		t.Errorf("rejected rows %s", got)
+	}
+	msgs := map[int]string{
+		1: "UNIQUE constraint failed: p",
+		2: "NOT NULL constraint failed: p.age",
+		3: "CHECK constraint failed: p",
+		4: "UNIQUE constraint failed: p.id",
+		6: "2 values for 3 columns",
+	}
+	for _, r := range res.Rejected {
+		if want, ok := msgs[r.Row]; ok && !strings.Contains(r.Err.Error(), want) {
+			t.Errorf("row %d: %v, want %q", r.Row, r.Err, want)
+		}
+	}
+	if got := fmt.Sprint(progress); got != "[1 3 3 6]" {
+		t.Errorf("progress %s", got)
+	}
+	if got := queryAll(t, db, "SELECT id, email, age, typeof(age) FROM p ORDER BY id"); got !=
+		"1,a@x,30,integer|2,b@x,20,integer|3,f@x,60,integer|8,h@x,5,integer" {
+		t.Errorf("rows: %s", got)
+	}
+}
+
+func TestBulkInsertMaxRejects(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db, "CREATE TABLE t (k INTEGER PRIMARY KEY, v TEXT)")
+
+	rows := [][]interface{}{{1, "a"}, {2, "b"}, {1, "c"}, {3, "d"}, {2, "e"}, {4, "f"}}
+	res, err := db.BulkInsert("t", []string{"k", "v"}, SliceRows(rows), BulkOptions{ChunkSize: 4, MaxRejects: 1})
+	if err == nil || !strings.Contains(err.Error(), "row 4: UNIQUE constraint failed: t.k") {
+		t.Fatalf("BulkInsert error = %v", err)
+	}
+	// The first chunk, with one reject, was committed; the second was not
+	if res.Inserted != 3 || len(res.Rejected) != 2 {
+		t.Errorf("Inserted %d, rejected %v", res.Inserted, rejectRows(res.Rejected))
+	}
+	if got := queryAll(t, db, "SELECT k, v FROM t"); got != "1,a|2,b|3,d" {
+		t.Errorf("rows: %s", got)
+	}
+
+	// Inside a transaction the failure can be rolled back whole
+	execOK(t, db, "BEGIN")
+	if _, err := db.BulkInsert("t", nil, SliceRows([][]interface{}{{5, "g"}, {5, "h"}}), BulkOptions{}); err == nil {
+		t.Fatal("duplicate key accepted")
+	}
+	execOK(t, db, "ROLLBACK")
+	if got := queryAll(t, db, "SELECT count(*) FROM t"); got != "3" {
+		t.Errorf("count after rollback = %s", got)
+	}
+}
+
+func TestBulkInsertBetweenChunks(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE t (k TEXT, v INTEGER)",
+		"CREATE UNIQUE INDEX t_k ON t (lower(k))",
+		"CREATE INDEX t_v ON t (v)",
+		"INSERT INTO t VALUES ('A', 0)")
+
+	// Changes made by other statements between chunks are seen
+	chunk := 0
+	res, err := db.BulkInsert("t", nil, SliceRows([][]interface{}{{"b", 1}, {"a", 2}, {"B", 3}, {"c", 4}}), BulkOptions{
+		ChunkSize:  2,
+		MaxRejects: -1,
+		Progress: func(int, int) {
+			if chunk++; chunk == 1 {
+				execOK(t, db, "DELETE FROM t WHERE k = 'b'")
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+	if got := rejectRows(res.Rejected); len(got) != 1 || got[0] != 1 {
+		t.Errorf("rejected %v", got)
+	} else if !strings.Contains(res.Rejected[0].Err.Error(), "index 't_k'") {
+		t.Errorf("reject error %v", res.Rejected[0].Err)
+	}
+	if got := queryAll(t, db, "SELECT k FROM t WHERE v = 3"); got != "B" {
+		t.Errorf("index lookup after load: %q", got)
+	}
+
+	res, err = db.BulkInsert("t", []string{"v", "k"}, SliceRows([][]interface{}{{5, "d"}, {6, "e"}}), BulkOptions{DeferIndexes: true})
+	if err != nil || res.Inserted != 2 {
+		t.Fatalf("BulkInsert: %v, %+v", err, res)
+	}
+	if got := queryAll(t, db, "SELECT k FROM t WHERE v = 6"); got != "e" {
+		t.Errorf("index lookup after deferred build: %q", got)
+	}
+}
+
+func TestBulkInsertWithoutRowid(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE kv (tenant INTEGER, k TEXT, v TEXT, PRIMARY KEY (tenant, k)) WITHOUT ROWID",
+		"INSERT INTO kv VALUES (2, 'b', 'x'), (1, 'z', 'y'), (1, 'a', 'w'), (2, 'a', 'q')")
+
+	rows := [][]interface{}{{1, "m", "p"}, {1, "a", "dup"}, {nil, "x", "null key"}, {0, "q", "r"}}
+	res, err := db.BulkInsert("kv", nil, SliceRows(rows), BulkOptions{MaxRejects: -1})
+	if err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+	if got := fmt.Sprint(rejectRows(res.Rejected)); got != "[1 2]" {
+		t.Errorf("rejected %s", got)
+	}
+	if got := queryAll(t, db, "SELECT tenant, k FROM kv"); got != "0,q|1,a|1,m|1,z|2,a|2,b" {
+		t.Errorf("rows not in key order: %s", got)
+	}
+}
+
+func TestCopyFrom(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT NOT NULL, note TEXT)")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "t.csv")
+	data := "\xef\xbb\xbfid,name,note\r\n1,alpha,\r\n2,\"be, \"\"ta\"\"\",\"two\nlines\"\r\n3,gamma,\"\"\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.Exec("COPY t FROM '" + path + "' WITH (FORMAT csv, HEADER)")
+	if err != nil {
+		t.Fatalf("COPY: %v", err)
+	}
+	if r.RowsAffected != 3 {
+		t.Errorf("RowsAffected = %d", r.RowsAffected)
+	}
+	if got := queryAll(t, db, "SELECT id, name, note IS NULL, note FROM t"); got !=
+		"1,alpha,1,<nil>|2,be, \"ta\",0,two\nlines|3,gamma,0," {
+		t.Errorf("rows: %q", got)
+	}
+
+	// A bad line fails the whole statement and names its line
+	bad := filepath.Join(dir, "bad.csv")
+	os.WriteFile(bad, []byte("name;id\nx;10\n\"multi\nline\";11\n;12\n"), 0o644)
+	_, err = db.Exec("COPY t (name, id) FROM '" + bad + "' DELIMITER ';' NULL '' CSV HEADER")
+	if err == nil || !strings.Contains(err.Error(), "COPY t: line 5: NOT NULL constraint failed: t.name") {
+		t.Errorf("COPY error = %v", err)
+	}
+	if got := queryAll(t, db, "SELECT count(*) FROM t"); got != "3" {
+		t.Errorf("count after failed COPY = %s", got)
+	}
+
+	for sql, want := range map[string]string{
+		"COPY t TO '" + path + "'":                         "COPY TO is not supported",
+		"COPY t FROM '" + path + "' WITH (FORMAT binary)":  "COPY format binary is not supported",
+		"COPY nope FROM '" + path + "'":                    "no such table: nope",
+		"COPY t FROM '" + filepath.Join(dir, "none") + "'": "cannot open",
+	} {
+		if _, err := db.Exec(sql); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v, want %q", sql, err, want)
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db, "CREATE TABLE t (a INTEGER, b TEXT)")
+
+	n, err := db.ImportCSV("t", strings.NewReader("b, a\nx, 1\nNA, 2\n"), CSVImportOptions{HasHeader: true, NullString: "NA"})
+	if err != nil || n != 2 {
+		t.Fatalf("ImportCSV = %d, %v", n, err)
+	}
+	n, err = db.ImportCSV("t", strings.NewReader("3,z\n"), CSVImportOptions{})
+	if err != nil || n != 1 {
+		t.Fatalf("ImportCSV without header = %d, %v", n, err)
+	}
+	if got := queryAll(t, db, "SELECT a, typeof(a), b FROM t"); got != "1,integer,x|2,integer,<nil>|3,integer,z" {
+		t.Errorf("rows: %s", got)
+	}
+}
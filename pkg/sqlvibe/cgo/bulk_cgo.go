@@ -0,0 +1,172 @@
+package cgo
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/../../../src/core/svdb
+#include "svdb.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Bulk wraps a svdb_bulk_t bulk load into one table.
+type Bulk struct {
+	h     *C.svdb_bulk_t
+	db    *DB
+	ncols int
+}
+
+// Reject is a row a bulk insert turned away: its index in the rows given
+// and the constraint error.
+type Reject struct {
+	Row int
+	Msg string
+}
+
+// BulkBegin starts a bulk load of cols of table, or of all its columns if
+// cols is empty.
+func (db *DB) BulkBegin(table string, cols []string) (*Bulk, error) {
+	ct := C.CString(table)
+	defer C.free(unsafe.Pointer(ct))
+	var ccols **C.char
+	if len(cols) > 0 {
+		arr := unsafe.Slice((**C.char)(C.malloc(C.size_t(len(cols))*C.size_t(unsafe.Sizeof(uintptr(0))))), len(cols))
+		for i, c := range cols {
+			arr[i] = C.CString(c)
+		}
+		defer func() {
+			for _, p := range arr {
+				C.free(unsafe.Pointer(p))
+			}
+			C.free(unsafe.Pointer(&arr[0]))
+		}()
+		ccols = &arr[0]
+	}
+	var h *C.svdb_bulk_t
+	code := C.svdb_bulk_begin(db.h, ct, ccols, C.int(len(cols)), &h)
+	if code != C.SVDB_OK {
+		return nil, svdbErr(db, code)
+	}
+	return &Bulk{h: h, db: db, ncols: int(C.svdb_bulk_column_count(h))}, nil
+}
+
+// Columns returns how many values each row must hold.
+func (b *Bulk) Columns() int { return b.ncols }
+
+// Insert stores rows as one statement. Each value is nil, int64, float64,
+// bool, string or []byte. Rows violating a constraint are skipped and
+// returned; if there are more than maxRejects of them (maxRejects < 0: no
+// limit) nothing is stored and the error is the last reject's.
+func (b *Bulk) Insert(rows [][]interface{}, maxRejects int64) (Result, []Reject, error) {
+	if len(rows) == 0 {
+		return Result{}, nil, nil
+	}
+	n := len(rows) * b.ncols
+	size := 0
+	for _, row := range rows {
+		size += textSize(row)
+	}
+	vals := unsafe.Slice((*C.svdb_val_t)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(C.svdb_val_t{})))), n)
+	defer C.free(unsafe.Pointer(&vals[0]))
+	var buf []byte
+	if size > 0 {
+		p := C.malloc(C.size_t(size))
+		defer C.free(p)
+		buf = unsafe.Slice((*byte)(p), size)
+	}
+	off := 0
+	for r, row := range rows {
+		if len(row) != b.ncols {
+			return Result{}, nil, fmt.Errorf("svdb: row %d has %d values for %d columns", r, len(row), b.ncols)
+		}
+		for c, val := range row {
+			var err error
+			if off, err = setValue(&vals[r*b.ncols+c], val, buf, off); err != nil {
+				return Result{}, nil, err
+			}
+		}
+	}
+	var res C.svdb_result_t
+	code := C.svdb_bulk_insert(b.h, &vals[0], C.int(len(rows)), C.int64_t(maxRejects), &res)
+	var rejects []Reject
+	for i := 0; i < int(C.svdb_bulk_reject_count(b.h)); i++ {
+		var row C.int64_t
+		msg := C.svdb_bulk_reject(b.h, C.int(i), &row)
+		rejects = append(rejects, Reject{Row: int(row), Msg: C.GoString(msg)})
+	}
+	if code != C.SVDB_OK {
+		return Result{}, rejects, svdbErr(b.db, code)
+	}
+	return Result{
+		RowsAffected:    int64(res.rows_affected),
+		LastInsertRowid: int64(res.last_insert_rowid),
+	}, rejects, nil
+}
+
+// BuildIndexes builds the table's indexes ahead of the first query that
+// uses them.
+func (b *Bulk) BuildIndexes() error {
+	return svdbErr(b.db, C.svdb_bulk_build_indexes(b.h))
+}
+
+// Close ends the bulk load.
+func (b *Bulk) Close() error {
+	if b.h == nil {
+		return nil
+	}
+	code := C.svdb_bulk_close(b.h)
+	b.h = nil
+	return svdbErr(nil, code)
+}
+
+// textSize returns the bytes of text and blobs among vals.
+func textSize(vals []interface{}) int {
+	size := 0
+	for _, v := range vals {
+		switch v := v.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		}
+	}
+	return size
+}
+
+// setValue sets v to val, one of nil, int64, float64, bool, string or
+// []byte, copying text and blobs to buf[off:]. Returns the offset after them.
+func setValue(v *C.svdb_val_t, val interface{}, buf []byte, off int) (int, error) {
+	text := func(t C.svdb_type_t, s []byte) {
+		v._type = t
+		v.slen = C.size_t(len(s))
+		if len(s) > 0 {
+			copy(buf[off:], s)
+			v.sval = (*C.char)(unsafe.Pointer(&buf[off]))
+			off += len(s)
+		}
+	}
+	switch val := val.(type) {
+	case nil:
+		v._type = C.SVDB_TYPE_NULL
+	case int64:
+		v._type = C.SVDB_TYPE_INT
+		v.ival = C.int64_t(val)
+	case float64:
+		v._type = C.SVDB_TYPE_REAL
+		v.rval = C.double(val)
+	case bool:
+		v._type = C.SVDB_TYPE_INT
+		if val {
+			v.ival = 1
+		}
+	case string:
+		text(C.SVDB_TYPE_TEXT, []byte(val))
+	case []byte:
+		text(C.SVDB_TYPE_BLOB, val)
+	default:
+		return off, fmt.Errorf("svdb: unsupported value type %T", val)
+	}
+	return off, nil
+}
@@ -7,10 +7,17 @@ package cgo
 #include <stdlib.h>
 */
 import "C"
-import "unsafe"
+import (
+	"fmt"
+	rcgo "runtime/cgo"
+	"unsafe"
+)
 
 // DB wraps a svdb_db_t handle.
-type DB struct{ h *C.svdb_db_t }
+type DB struct {
+	h       *C.svdb_db_t
+	handles []rcgo.Handle // registered callbacks, released on Close
+}
 
 // Open opens (or creates) a database at the given path.
 // Use ":memory:" for an in-memory database.
@@ -24,6 +31,30 @@ func Open(path string) (*DB, error) {
 	return &DB{h: h}, nil
 }
 
+// Open flags for OpenV2.
+const (
+	OpenReadOnly  = int(C.SVDB_OPEN_READONLY)
+	OpenReadWrite = int(C.SVDB_OPEN_READWRITE)
+	OpenCreate    = int(C.SVDB_OPEN_CREATE)
+	OpenMemory    = int(C.SVDB_OPEN_MEMORY)
+)
+
+// OpenV2 opens a database at the given path with the given Open* flags.
+// Without OpenCreate the file must already exist.
+func OpenV2(path string, flags int) (*DB, error) {
+	cs := C.CString(path)
+	defer C.free(unsafe.Pointer(cs))
+	var h *C.svdb_db_t
+	switch code := C.svdb_open_v2(cs, C.int(flags), &h); code {
+	case C.SVDB_OK:
+		return &DB{h: h}, nil
+	case C.SVDB_NOTFOUND:
+		return nil, fmt.Errorf("svdb: unable to open database file %q: no such file", path)
+	default:
+		return nil, svdbErr(nil, code)
+	}
+}
+
 // Close closes the database and frees all resources.
 func (db *DB) Close() error {
 	if db.h == nil {
@@ -31,6 +62,10 @@ func (db *DB) Close() error {
 	}
 	code := C.svdb_close(db.h)
 	db.h = nil
+	for _, h := range db.handles {
+		h.Delete()
+	}
+	db.handles = nil
 	if code != C.SVDB_OK {
 		return svdbErr(nil, code)
 	}
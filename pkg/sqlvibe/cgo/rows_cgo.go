@@ -29,6 +29,47 @@ func (r *Rows) ColumnName(col int) string {
 	return C.GoString(C.svdb_rows_column_name(r.h, C.int(col)))
 }
 
+// ColumnDeclType returns the declared type of the table column that column
+// col reads, or "" for a computed column.
+func (r *Rows) ColumnDeclType(col int) string {
+	if r.h == nil {
+		return ""
+	}
+	return goStringOrEmpty(C.svdb_rows_column_decltype(r.h, C.int(col)))
+}
+
+// ColumnTable returns the table column col reads from, or "".
+func (r *Rows) ColumnTable(col int) string {
+	if r.h == nil {
+		return ""
+	}
+	return goStringOrEmpty(C.svdb_rows_column_table(r.h, C.int(col)))
+}
+
+// ColumnOrigin returns the table column that column col reads, or "".
+func (r *Rows) ColumnOrigin(col int) string {
+	if r.h == nil {
+		return ""
+	}
+	return goStringOrEmpty(C.svdb_rows_column_origin(r.h, C.int(col)))
+}
+
+// ColumnNullable reports whether column col can hold NULL: 1 if it can, 0
+// if it is NOT NULL and -1 if that is unknown.
+func (r *Rows) ColumnNullable(col int) int {
+	if r.h == nil {
+		return -1
+	}
+	return int(C.svdb_rows_column_nullable(r.h, C.int(col)))
+}
+
+func goStringOrEmpty(s *C.char) string {
+	if s == nil {
+		return ""
+	}
+	return C.GoString(s)
+}
+
 // Next advances to the next row. Returns true if a row is available.
 func (r *Rows) Next() bool {
 	if r.h == nil {
@@ -42,7 +83,11 @@ func (r *Rows) Get(col int) interface{} {
 	if r.h == nil {
 		return nil
 	}
-	v := C.svdb_rows_get(r.h, C.int(col))
+	return goValue(C.svdb_rows_get(r.h, C.int(col)))
+}
+
+// goValue converts v to nil, int64, float64, string or []byte.
+func goValue(v C.svdb_val_t) interface{} {
 	// v._type: CGO renames C struct field "type" to "_type" because "type" is a Go keyword.
 	switch v._type {
 	case C.SVDB_TYPE_INT:
@@ -53,7 +98,7 @@ func (r *Rows) Get(col int) interface{} {
 		if v.sval == nil {
 			return ""
 		}
-		return C.GoString(v.sval)
+		return C.GoStringN(v.sval, C.int(v.slen))
 	case C.SVDB_TYPE_BLOB:
 		if v.sval == nil {
 			return []byte(nil)
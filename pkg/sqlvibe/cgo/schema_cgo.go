@@ -41,3 +41,18 @@ func (db *DB) Indexes(table string) (*Rows, error) {
 	}
 	return &Rows{h: h}, nil
 }
+
+// TableScan returns up to max rows of table from position start on, in the
+// order they are stored, and a number that changes whenever rows of the
+// database change.
+func (db *DB) TableScan(table string, start int64, max int) (*Rows, uint64, error) {
+	cs := C.CString(table)
+	defer C.free(unsafe.Pointer(cs))
+	var h *C.svdb_rows_t
+	var gen C.uint64_t
+	code := C.svdb_table_scan(db.h, cs, C.int64_t(start), C.int(max), &h, &gen)
+	if code != C.SVDB_OK {
+		return nil, 0, svdbErr(db, code)
+	}
+	return &Rows{h: h}, uint64(gen), nil
+}
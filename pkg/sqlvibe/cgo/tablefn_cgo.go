@@ -0,0 +1,117 @@
+package cgo
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/../../../src/core/svdb
+#include "svdb.h"
+#include <stdlib.h>
+
+extern svdb_code_t svdbGoTableFunc(uintptr_t ctx, svdb_val_t *args, int nargs, svdb_table_t *out);
+*/
+import "C"
+import (
+	"fmt"
+	rcgo "runtime/cgo"
+	"unsafe"
+)
+
+// TableFunc produces the table FROM name(args) reads, adding its columns
+// to t and then its rows. Args are nil, int64, float64, string or []byte.
+type TableFunc func(args []interface{}, t *Table) error
+
+// Table receives the columns and rows of a TableFunc.
+type Table struct {
+	h     *C.svdb_table_t
+	ncols int
+	vals  []C.svdb_val_t // C memory for a row
+	buf   []byte         // C memory for its text and blobs
+}
+
+// RegisterTableFunction makes fn usable as name(...) in FROM clauses.
+func (db *DB) RegisterTableFunction(name string, fn TableFunc) error {
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+	h := rcgo.NewHandle(fn)
+	code := C.svdb_register_table_function(db.h, cs, C.svdb_table_fn(unsafe.Pointer(C.svdbGoTableFunc)), C.uintptr_t(h))
+	if code != C.SVDB_OK {
+		h.Delete()
+		return svdbErr(db, code)
+	}
+	db.handles = append(db.handles, h)
+	return nil
+}
+
+// AddColumn adds a column with a declared type, which may be empty.
+// Columns must be added before rows.
+func (t *Table) AddColumn(name, declType string) {
+	cn, ct := C.CString(name), C.CString(declType)
+	C.svdb_table_add_column(t.h, cn, ct)
+	C.free(unsafe.Pointer(cn))
+	C.free(unsafe.Pointer(ct))
+	t.ncols++
+}
+
+// AddRow adds a row of a value per column: nil, int64, float64, bool,
+// string or []byte.
+func (t *Table) AddRow(row []interface{}) error {
+	if len(row) != t.ncols {
+		return fmt.Errorf("svdb: %d values for %d columns", len(row), t.ncols)
+	}
+	if len(t.vals) < t.ncols {
+		t.free()
+		t.vals = unsafe.Slice((*C.svdb_val_t)(C.calloc(C.size_t(t.ncols), C.size_t(unsafe.Sizeof(C.svdb_val_t{})))), t.ncols)
+	}
+	if size := textSize(row); size > len(t.buf) {
+		if t.buf != nil {
+			C.free(unsafe.Pointer(&t.buf[0]))
+		}
+		size = max(size, 2*len(t.buf))
+		t.buf = unsafe.Slice((*byte)(C.malloc(C.size_t(size))), size)
+	}
+	off := 0
+	for i, v := range row {
+		t.vals[i] = C.svdb_val_t{}
+		var err error
+		if off, err = setValue(&t.vals[i], v, t.buf, off); err != nil {
+			return err
+		}
+	}
+	C.svdb_table_add_row(t.h, &t.vals[0])
+	return nil
+}
+
+func (t *Table) free() {
+	if t.vals != nil {
+		C.free(unsafe.Pointer(&t.vals[0]))
+		t.vals = nil
+	}
+	if t.buf != nil {
+		C.free(unsafe.Pointer(&t.buf[0]))
+		t.buf = nil
+	}
+}
+
+//export svdbGoTableFunc
+func svdbGoTableFunc(ctx C.uintptr_t, args *C.svdb_val_t, nargs C.int, out *C.svdb_table_t) (code C.svdb_code_t) {
+	t := &Table{h: out}
+	defer t.free()
+	defer func() {
+		if r := recover(); r != nil {
+			msg := C.CString(fmt.Sprint("panic: ", r))
+			C.svdb_table_error(out, msg)
+			C.free(unsafe.Pointer(msg))
+			code = C.SVDB_ERR
+		}
+	}()
+	fn := rcgo.Handle(ctx).Value().(TableFunc)
+	vals := make([]interface{}, int(nargs))
+	for i, v := range unsafe.Slice(args, int(nargs)) {
+		vals[i] = goValue(v)
+	}
+	if err := fn(vals, t); err != nil {
+		msg := C.CString(err.Error())
+		C.svdb_table_error(out, msg)
+		C.free(unsafe.Pointer(msg))
+		return C.SVDB_ERR
+	}
+	return C.SVDB_OK
+}
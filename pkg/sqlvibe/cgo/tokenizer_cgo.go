@@ -0,0 +1,62 @@
+package cgo
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/../../../src/core/svdb
+#include "svdb.h"
+#include <stdlib.h>
+
+extern svdb_code_t svdbGoTokenize(uintptr_t ctx, char *text, int len, svdb_tokens_t *out);
+*/
+import "C"
+import (
+	rcgo "runtime/cgo"
+	"unsafe"
+)
+
+// Token is one token produced by a Tokenizer: the term to index and the
+// byte range of the text it came from. A Colocated token is a synonym at the
+// same position as the token before it.
+type Token struct {
+	Term       string
+	Start, End int
+	Colocated  bool
+}
+
+// Tokenizer splits text into tokens.
+type Tokenizer func(text string) []Token
+
+// RegisterTokenizer makes fn usable as fts5(..., tokenize='name').
+func (db *DB) RegisterTokenizer(name string, fn Tokenizer) error {
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+	h := rcgo.NewHandle(fn)
+	code := C.svdb_register_tokenizer(db.h, cs, C.svdb_tokenizer_fn(unsafe.Pointer(C.svdbGoTokenize)), C.uintptr_t(h))
+	if code != C.SVDB_OK {
+		h.Delete()
+		return svdbErr(db, code)
+	}
+	db.handles = append(db.handles, h)
+	return nil
+}
+
+//export svdbGoTokenize
+func svdbGoTokenize(ctx C.uintptr_t, text *C.char, n C.int, out *C.svdb_tokens_t) (code C.svdb_code_t) {
+	defer func() {
+		if recover() != nil {
+			code = C.SVDB_ERR
+		}
+	}()
+	fn := rcgo.Handle(ctx).Value().(Tokenizer)
+	for _, t := range fn(C.GoStringN(text, n)) {
+		if t.Term == "" {
+			continue
+		}
+		colocated := C.int(0)
+		if t.Colocated {
+			colocated = 1
+		}
+		C.svdb_tokens_add(out, (*C.char)(unsafe.Pointer(unsafe.StringData(t.Term))), C.int(len(t.Term)),
+			C.int(t.Start), C.int(t.End), colocated)
+	}
+	return C.SVDB_OK
+}
@@ -9,7 +9,10 @@ import "C"
 import "unsafe"
 
 // Tx wraps a svdb_tx_t transaction handle.
-type Tx struct{ h *C.svdb_tx_t }
+type Tx struct {
+	h  *C.svdb_tx_t
+	db *DB
+}
 
 // Begin starts a new transaction.
 func (db *DB) Begin() (*Tx, error) {
@@ -18,14 +21,14 @@ func (db *DB) Begin() (*Tx, error) {
 	if code != C.SVDB_OK {
 		return nil, svdbErr(db, code)
 	}
-	return &Tx{h: h}, nil
+	return &Tx{h: h, db: db}, nil
 }
 
 // Commit commits the transaction.
 func (tx *Tx) Commit() error {
 	code := C.svdb_commit(tx.h)
 	tx.h = nil
-	return svdbErr(nil, code)
+	return svdbErr(tx.db, code)
 }
 
 // Rollback rolls back the transaction.
@@ -55,3 +58,8 @@ func (tx *Tx) RollbackTo(name string) error {
 	defer C.free(unsafe.Pointer(cs))
 	return svdbErr(nil, C.svdb_rollback_to(tx.h, cs))
 }
+
+// InTransaction reports whether a transaction is open on the database.
+func (db *DB) InTransaction() bool {
+	return C.svdb_in_transaction(db.h) != 0
+}
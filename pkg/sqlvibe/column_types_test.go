@@ -0,0 +1,126 @@
+package sqlvibe
+
+import (
+	"database/sql"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestColumnTypes(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name VARCHAR(40) NOT NULL, score REAL, avatar BLOB)",
+		"CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, title TEXT NOT NULL)",
+		"CREATE VIEW names AS SELECT id AS uid, name FROM users",
+	)
+
+	type col struct {
+		decl, table, origin string
+		nullable            int // 1, 0 or -1 for unknown
+	}
+	cases := []struct {
+		sql  string
+		want []col
+	}{
+		{"SELECT * FROM users", []col{
+			{"INTEGER", "users", "id", 0},
+			{"VARCHAR(40)", "users", "name", 0},
+			{"REAL", "users", "score", 1},
+			{"BLOB", "users", "avatar", 1},
+		}},
+		{"SELECT u.name AS who, p.title, score * 2, count(*), CAST(score AS INTEGER), 'x' FROM users u LEFT JOIN posts p ON p.user_id = u.id GROUP BY u.id", []col{
+			{"VARCHAR(40)", "users", "name", 0},
+			{"TEXT", "posts", "title", 1},
+			{"", "", "", -1},
+			{"INTEGER", "", "", 0},
+			{"INTEGER", "", "", -1},
+			{"TEXT", "", "", 0},
+		}},
+		{"SELECT uid, name FROM names WHERE uid > 10", []col{
+			{"INTEGER", "users", "id", 0},
+			{"VARCHAR(40)", "users", "name", 0},
+		}},
+		{"WITH t AS (SELECT title AS heading FROM posts) SELECT s.heading FROM (SELECT heading FROM t) AS s", []col{
+			{"TEXT", "posts", "title", 0},
+		}},
+		{"SELECT p.*, rowid FROM posts p", []col{
+			{"INTEGER", "posts", "id", 0},
+			{"INTEGER", "posts", "user_id", 0},
+			{"TEXT", "posts", "title", 0},
+			{"INTEGER", "posts", "id", 0},
+		}},
+		{"SELECT name FROM users UNION SELECT NULL", []col{
+			{"VARCHAR(40)", "users", "name", -1},
+		}},
+	}
+	for _, c := range cases {
+		rows, err := db.Query(c.sql)
+		if err != nil {
+			t.Fatalf("%s: %v", c.sql, err)
+		}
+		types := rows.ColumnTypes()
+		if len(types) != len(c.want) {
+			t.Errorf("%s: %d column types, want %d", c.sql, len(types), len(c.want))
+			continue
+		}
+		for i, w := range c.want {
+			ct := types[i]
+			nullable := -1
+			if ct.NullableKnown {
+				nullable = 0
+				if ct.Nullable {
+					nullable = 1
+				}
+			}
+			got := col{ct.DeclType, ct.Table, ct.Origin, nullable}
+			// rowid of a table with an INTEGER PRIMARY KEY is that column
+			if ct.Origin == "rowid" {
+				got.origin = "id"
+			}
+			if got != w {
+				t.Errorf("%s: column %d (%s) = %+v, want %+v", c.sql, i, ct.Name, got, w)
+			}
+			if ct.Name != rows.Columns[i] {
+				t.Errorf("%s: column %d named %q, want %q", c.sql, i, ct.Name, rows.Columns[i])
+			}
+		}
+	}
+
+	// An empty result still carries its types
+	rows, err := db.Query("SELECT id, name, score, avatar FROM users WHERE 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := rows.ColumnTypes()
+	if len(rows.Data) != 0 || len(types) != 4 {
+		t.Fatalf("unexpected result: %d rows, %d types", len(rows.Data), len(types))
+	}
+	wantScan := []reflect.Type{
+		reflect.TypeOf(int64(0)),
+		reflect.TypeOf(""),
+		reflect.TypeOf(sql.NullFloat64{}),
+		reflect.TypeOf([]byte(nil)),
+	}
+	for i, w := range wantScan {
+		if got := types[i].ScanType(); got != w {
+			t.Errorf("ScanType of %s = %v, want %v", types[i].Name, got, w)
+		}
+	}
+	if got := types[1].DatabaseTypeName(); got != "VARCHAR" {
+		t.Errorf("DatabaseTypeName = %q", got)
+	}
+	if n, ok := types[1].Length(); !ok || n != 40 {
+		t.Errorf("Length of VARCHAR(40) = %d, %v", n, ok)
+	}
+	if n, ok := types[3].Length(); !ok || n != math.MaxInt64 {
+		t.Errorf("Length of BLOB = %d, %v", n, ok)
+	}
+	if _, ok := types[0].Length(); ok {
+		t.Error("INTEGER column reports a length")
+	}
+}
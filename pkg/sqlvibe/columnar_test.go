@@ -0,0 +1,220 @@
+package sqlvibe
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// loadPair creates a row table and a COLUMNAR table holding the same rows.
+func loadPair(t *testing.T, db *Database) {
+	t.Helper()
+	const cols = "(id INTEGER PRIMARY KEY, region TEXT, qty INTEGER, price REAL, note TEXT, misc)"
+	execOK(t, db, "CREATE TABLE r "+cols, "CREATE TABLE c "+cols+" USING COLUMNAR")
+	regions := []string{"'eu'", "'us'", "'ap'", "NULL", "'latam'"}
+	for i := 0; i < 400; i++ {
+		qty := fmt.Sprint(i % 17)
+		if i%11 == 0 {
+			qty = "NULL"
+		}
+		price := fmt.Sprintf("%d.25", i%23)
+		if i%13 == 0 {
+			price = "NULL"
+		}
+		misc := fmt.Sprint(i)
+		if i%2 == 0 {
+			misc = fmt.Sprintf("'m%d'", i)
+		}
+		vals := fmt.Sprintf("(%s, %s, %s, 'note %d', %s)", regions[i%len(regions)], qty, price, i, misc)
+		execOK(t, db,
+			"INSERT INTO r (region, qty, price, note, misc) VALUES "+vals,
+			"INSERT INTO c (region, qty, price, note, misc) VALUES "+vals)
+	}
+}
+
+func TestColumnarMatchesRowStorage(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	loadPair(t, db)
+
+	queries := []string{
+		"SELECT * FROM %s",
+		"SELECT id, region FROM %s WHERE qty = 3",
+		"SELECT x.note, QTY FROM %s AS x WHERE x.region = 'eu' AND price > 10",
+		"SELECT id FROM %s WHERE region IN ('us', 'ap') AND qty BETWEEN 2 AND 5",
+		"SELECT id, misc FROM %s WHERE region IS NULL LIMIT 5 OFFSET 3",
+		"SELECT id FROM %s WHERE qty IS NOT NULL AND price <= 4.5 LIMIT 2, 4",
+		"SELECT note FROM %s WHERE note >= 'note 390'",
+		"SELECT id FROM %s WHERE qty <> 4 AND qty < 2.5",
+		"SELECT id FROM %s WHERE region = 'nowhere'",
+		"SELECT count(*), count(qty), sum(qty), avg(qty), min(qty), max(qty) FROM %s",
+		"SELECT sum(price), avg(price), min(price), max(price), min(note), max(note) FROM %s",
+		"SELECT Sum( qty ) AS total_qty, count(price) FROM %s WHERE region = 'eu'",
+		"SELECT sum(qty), min(region), max(price) FROM %s WHERE qty > 100",
+		"SELECT region, count(*), sum(qty), avg(price) FROM %s GROUP BY region",
+		"SELECT region, max(qty) FROM %s WHERE price > 3 GROUP BY region ORDER BY region",
+		"SELECT region, count(*) FROM %s GROUP BY region ORDER BY region DESC LIMIT 3",
+		"SELECT qty, count(*) AS n FROM %s GROUP BY qty ORDER BY qty DESC",
+		"SELECT count(*) FROM %s WHERE misc = 7",
+		// Shapes the column image leaves to the row executor
+		"SELECT DISTINCT region FROM %s",
+		"SELECT region FROM %s WHERE qty = 1 OR qty = 2",
+		"SELECT upper(region), qty * 2 FROM %s WHERE id < 5",
+		"SELECT count(*) FROM %s WHERE qty = '3'",
+		"SELECT sum(qty) FROM %s LIMIT 0",
+		"SELECT region, count(*) FROM %s GROUP BY region HAVING count(*) > 80",
+	}
+	for _, q := range queries {
+		want, err := db.Query(fmt.Sprintf(q, "r"))
+		if err != nil {
+			t.Fatalf("%s: %v", fmt.Sprintf(q, "r"), err)
+		}
+		got, err := db.Query(fmt.Sprintf(q, "c"))
+		if err != nil {
+			t.Fatalf("%s: %v", fmt.Sprintf(q, "c"), err)
+		}
+		// DeepEqual also catches a value coming back with a different Go type
+		if !reflect.DeepEqual(got.Columns, want.Columns) || !reflect.DeepEqual(got.Data, want.Data) {
+			t.Errorf("%s\ncolumnar: %v %v\nrow: %v %v", fmt.Sprintf(q, "c"), got.Columns, got.Data, want.Columns, want.Data)
+		}
+	}
+
+	// The image follows writes
+	execOK(t, db,
+		"UPDATE c SET qty = 1000 WHERE id = 1",
+		"DELETE FROM c WHERE region = 'ap'",
+		"INSERT INTO c (region, qty) VALUES ('eu', 5)",
+	)
+	if got := queryAll(t, db, "SELECT max(qty), count(*) FROM c"); got != "1000,321" {
+		t.Errorf("columnar aggregate after writes = %s", got)
+	}
+	if got := queryAll(t, db, "SELECT count(*) FROM c WHERE region = 'ap'"); got != "0" {
+		t.Errorf("deleted rows still counted: %s", got)
+	}
+}
+
+func TestColumnarImageFollowsWrites(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	loadPair(t, db)
+
+	queries := []string{
+		"SELECT region, count(*), sum(qty), avg(price) FROM %s GROUP BY region",
+		"SELECT id, qty FROM %s WHERE region = 'eu' AND qty > 2",
+		"SELECT id, region FROM %s WHERE region IN ('mars', 'us') AND price IS NOT NULL LIMIT 30",
+		"SELECT count(*), count(qty), max(qty), min(price), max(note) FROM %s",
+		"SELECT qty, count(*) FROM %s GROUP BY qty ORDER BY qty",
+	}
+	check := func(after string) {
+		t.Helper()
+		for _, q := range queries {
+			want := queryAll(t, db, fmt.Sprintf(q, "r"))
+			if got := queryAll(t, db, fmt.Sprintf(q, "c")); got != want {
+				t.Errorf("after %s: %s\ncolumnar: %s\nrow: %s", after, q, got, want)
+			}
+		}
+	}
+	check("load")
+	writes := []string{
+		"INSERT INTO %s (region, qty, price, note, misc) VALUES ('eu', 3, 1.25, 'new', 'x')",
+		"INSERT INTO %s (region, qty) VALUES (NULL, NULL)",
+		"UPDATE %s SET region = 'mars' WHERE id = 5 OR id = 77",
+		"UPDATE %s SET qty = NULL, price = 9.5 WHERE id % 7 = 0",
+		"DELETE FROM %s WHERE qty = 4",
+		"INSERT INTO %s (region, qty, price) VALUES ('mars', 16, 0.25)",
+		"DELETE FROM %s WHERE region = 'mars'",
+		"UPDATE %s SET qty = 'lots' WHERE id = 3",
+		"INSERT INTO %s (region, qty, note) VALUES ('ap', 2, 'zzz')",
+		"DELETE FROM %s WHERE id > 390",
+	}
+	for _, w := range writes {
+		// Write both tables, so the image also sees writes to another table
+		execOK(t, db, fmt.Sprintf(w, "c"), fmt.Sprintf(w, "r"))
+		check(w)
+	}
+}
+
+func TestColumnarExplain(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	loadPair(t, db)
+
+	plan := func(sql string) string {
+		rows, err := db.Query("EXPLAIN QUERY PLAN " + sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		if len(rows.Data) == 0 {
+			return ""
+		}
+		return fmt.Sprint(rows.Data[0][3])
+	}
+	if got := plan("SELECT sum(qty) FROM c WHERE region = 'eu' AND price > 2"); got != "SCAN c USING COLUMNAR (bitmap index on region)" {
+		t.Errorf("unexpected plan: %s", got)
+	}
+	if got := plan("SELECT note FROM c WHERE note = 'note 7'"); got != "SCAN c USING COLUMNAR" {
+		t.Errorf("unexpected plan for a high-cardinality column: %s", got)
+	}
+	if got := plan("SELECT sum(qty) FROM r WHERE region = 'eu'"); got != "SCAN r" {
+		t.Errorf("unexpected plan for a row table: %s", got)
+	}
+	if got := plan("SELECT region FROM c WHERE qty = 1 OR qty = 2"); got != "SCAN c" {
+		t.Errorf("unexpected plan for an unsupported filter: %s", got)
+	}
+}
+
+func TestColumnarStorageMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "columnar.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	execOK(t, db,
+		"PRAGMA storage_mode = COLUMNAR",
+		"CREATE TABLE facts (k TEXT, v INTEGER)",
+		"CREATE TABLE rows_only (k TEXT, v INTEGER) USING ROW",
+		"CREATE TABLE copy AS SELECT * FROM facts",
+		"PRAGMA storage_mode = ROW",
+		"CREATE TABLE plain (k TEXT, v INTEGER)",
+		"INSERT INTO facts VALUES ('a', 1), ('b', 2), ('a', 3), ('b', 4)",
+	)
+	if _, err := db.Exec("PRAGMA storage_mode = SIDEWAYS"); err == nil {
+		t.Error("expected an error for an unknown storage mode")
+	}
+	if _, err := db.Exec("CREATE TABLE bad (a TEXT) USING SIDEWAYS"); err == nil {
+		t.Error("expected an error for an unknown table layout")
+	}
+
+	db = reopen(t, db, path)
+	defer db.Close()
+	explain := func(table string) string {
+		rows, err := db.Query("EXPLAIN QUERY PLAN SELECT count(*) FROM " + table)
+		if err != nil {
+			t.Fatalf("EXPLAIN %s: %v", table, err)
+		}
+		return fmt.Sprint(rows.Data[0][3])
+	}
+	for table, want := range map[string]string{
+		"facts":     "SCAN facts USING COLUMNAR",
+		"copy":      "SCAN copy USING COLUMNAR",
+		"rows_only": "SCAN rows_only",
+		"plain":     "SCAN plain",
+	} {
+		if got := explain(table); got != want {
+			t.Errorf("plan for %s = %q, want %q", table, got, want)
+		}
+	}
+	if got := queryAll(t, db, "SELECT k, sum(v) FROM facts GROUP BY k ORDER BY k"); got != "a,4|b,6" {
+		t.Errorf("unexpected grouped sums after reopen: %s", got)
+	}
+}
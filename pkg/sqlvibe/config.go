@@ -0,0 +1,277 @@
+package sqlvibe
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	cgo "github.com/cyw0ng95/sqlvibe/pkg/sqlvibe/cgo"
+)
+
+// OpenMode says whether a database may be written and created, as the
+// mode= option of a data source name gives it.
+type OpenMode int
+
+const (
+	// ModeReadWriteCreate opens the file for writing, creating it if it
+	// does not exist (mode=rwc, the default).
+	ModeReadWriteCreate OpenMode = iota
+	// ModeReadWrite opens an existing file for writing (mode=rw).
+	ModeReadWrite
+	// ModeReadOnly opens an existing file and refuses every write (mode=ro).
+	ModeReadOnly
+	// ModeMemory opens an in-memory database whatever the path (mode=memory).
+	ModeMemory
+)
+
+var openModeNames = map[OpenMode]string{
+	ModeReadWriteCreate: "rwc",
+	ModeReadWrite:       "rw",
+	ModeReadOnly:        "ro",
+	ModeMemory:          "memory",
+}
+
+func (m OpenMode) String() string {
+	if name, ok := openModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("OpenMode(%d)", int(m))
+}
+
+// flags returns the svdb_open_v2 flags of m.
+func (m OpenMode) flags() int {
+	switch m {
+	case ModeReadWrite:
+		return cgo.OpenReadWrite
+	case ModeReadOnly:
+		return cgo.OpenReadOnly
+	case ModeMemory:
+		return cgo.OpenReadWrite | cgo.OpenCreate | cgo.OpenMemory
+	}
+	return cgo.OpenReadWrite | cgo.OpenCreate
+}
+
+// Transaction locking behaviours, as the _txlock option gives them. The
+// statement is what BEGIN runs as.
+const (
+	TxLockDeferred  = "deferred"
+	TxLockImmediate = "immediate"
+	TxLockExclusive = "exclusive"
+)
+
+// Config holds the options a database is opened with. ParseDSN builds one
+// from a data source name; OpenConfig opens it. The zero value, with a
+// Path, opens that file read-write, creating it if needed.
+type Config struct {
+	Path string // file path, or ":memory:"
+	Mode OpenMode
+	// Cache is "private" (the default) or "shared". Connections to an
+	// in-memory database with a shared cache attach to the same database,
+	// by Path, which lives until the last of them closes; see sharedDB.
+	// File databases ignore it.
+	Cache       string
+	BusyTimeout time.Duration // PRAGMA busy_timeout; 0 leaves the default
+	ForeignKeys bool          // PRAGMA foreign_keys
+	JournalMode string        // PRAGMA journal_mode, e.g. "WAL"; "" leaves it as stored
+	TxLock      string        // TxLockDeferred (the default), TxLockImmediate or TxLockExclusive
+	TimeFormat  TimeFormat    // see SetTimeFormat
+	Location    *time.Location
+}
+
+// ParseDSN parses a data source name: a path, optionally prefixed with
+// "file:", and optionally followed by "?" and options, e.g.
+//
+//	file:app.db?mode=ro&_busy_timeout=5000&_foreign_keys=on&_journal_mode=WAL&_txlock=immediate
+//
+// The options are:
+//
+//	mode           ro, rw, rwc (the default) or memory
+//	cache          private (the default) or shared, to share an in-memory database
+//	_busy_timeout  milliseconds
+//	_foreign_keys  on/off, true/false, yes/no or 1/0
+//	_journal_mode  WAL or DELETE
+//	_txlock        deferred (the default), immediate or exclusive
+//	_time_format   text (the default), unix, unixmilli or julianday
+//	_loc           an IANA zone name, or auto for the local zone
+//
+// An unknown option is an error.
+func ParseDSN(dsn string) (*Config, error) {
+	cfg := &Config{Cache: "private"}
+	path, query, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	cfg.Path = path
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options in %q: %w", dsn, err)
+	}
+	for key, vals := range params {
+		v := vals[len(vals)-1]
+		switch key {
+		case "mode":
+			switch v {
+			case "ro":
+				cfg.Mode = ModeReadOnly
+			case "rw":
+				cfg.Mode = ModeReadWrite
+			case "rwc":
+				cfg.Mode = ModeReadWriteCreate
+			case "memory":
+				cfg.Mode = ModeMemory
+			default:
+				return nil, fmt.Errorf("invalid mode %q", v)
+			}
+		case "cache":
+			if v != "private" && v != "shared" {
+				return nil, fmt.Errorf("invalid cache %q", v)
+			}
+			cfg.Cache = v
+		case "_busy_timeout":
+			ms, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || ms < 0 {
+				return nil, fmt.Errorf("invalid _busy_timeout %q", v)
+			}
+			cfg.BusyTimeout = time.Duration(ms) * time.Millisecond
+		case "_foreign_keys":
+			if cfg.ForeignKeys, err = parseBoolOption(v); err != nil {
+				return nil, fmt.Errorf("invalid _foreign_keys %q", v)
+			}
+		case "_journal_mode":
+			switch strings.ToUpper(v) {
+			case "WAL", "DELETE":
+				cfg.JournalMode = strings.ToUpper(v)
+			default:
+				return nil, fmt.Errorf("invalid _journal_mode %q", v)
+			}
+		case "_txlock":
+			switch strings.ToLower(v) {
+			case TxLockDeferred, TxLockImmediate, TxLockExclusive:
+				cfg.TxLock = strings.ToLower(v)
+			default:
+				return nil, fmt.Errorf("invalid _txlock %q", v)
+			}
+		case "_time_format":
+			if cfg.TimeFormat, err = ParseTimeFormat(v); err != nil {
+				return nil, err
+			}
+		case "_loc":
+			if v == "auto" {
+				cfg.Location = time.Local
+			} else if cfg.Location, err = time.LoadLocation(v); err != nil {
+				return nil, fmt.Errorf("invalid _loc %q: %w", v, err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %q in %q", key, dsn)
+		}
+	}
+	if cfg.Path == "" && cfg.Mode == ModeMemory {
+		cfg.Path = ":memory:"
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("no database path in %q", dsn)
+	}
+	return cfg, nil
+}
+
+func parseBoolOption(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "1", "on", "true", "yes":
+		return true, nil
+	case "0", "off", "false", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", v)
+}
+
+// BeginStatement returns the statement that starts a transaction under
+// cfg.TxLock, e.g. "BEGIN IMMEDIATE".
+func (cfg *Config) BeginStatement() string {
+	if cfg.TxLock == "" || cfg.TxLock == TxLockDeferred {
+		return "BEGIN"
+	}
+	return "BEGIN " + strings.ToUpper(cfg.TxLock)
+}
+
+// OpenConfig opens the database cfg describes and applies its options,
+// setting the PRAGMAs they stand for.
+func OpenConfig(cfg *Config) (*Database, error) {
+	var db *Database
+	if cfg.Cache == "shared" && (cfg.Mode == ModeMemory || cfg.Path == ":memory:") {
+		s, err := openShared(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		db = &Database{cdb: s.cdb, shared: s}
+	} else {
+		cdb, err := cgo.OpenV2(cfg.Path, cfg.Mode.flags())
+		if err != nil {
+			return nil, err
+		}
+		if err := registerTableFunctions(cdb); err != nil {
+			cdb.Close()
+			return nil, err
+		}
+		db = &Database{cdb: cdb}
+	}
+	db.SetTimeFormat(cfg.TimeFormat)
+	db.SetLocation(cfg.Location)
+	var pragmas []string
+	if cfg.BusyTimeout > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("busy_timeout = %d", cfg.BusyTimeout.Milliseconds()))
+	}
+	if cfg.ForeignKeys {
+		pragmas = append(pragmas, "foreign_keys = ON")
+	}
+	if cfg.JournalMode != "" {
+		pragmas = append(pragmas, "journal_mode = "+cfg.JournalMode)
+	}
+	for _, p := range pragmas {
+		if _, err := db.Exec("PRAGMA " + p); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("PRAGMA %s: %w", p, err)
+		}
+	}
+	return db, nil
+}
+
+// newConnector builds the connectors NewConnector returns; see
+// RegisterConnector.
+var newConnector func(cfg *Config, hook func(db *Database) error) driver.Connector
+
+// RegisterConnector sets how NewConnector builds a connector: f returns one
+// that opens connections with OpenConfig(cfg) and runs hook, if not nil, on
+// each. The driver package, which holds the database/sql driver, calls it
+// when it is imported.
+func RegisterConnector(f func(cfg *Config, hook func(db *Database) error) driver.Connector) {
+	newConnector = f
+}
+
+// NewConnector returns a database/sql connector, for sql.OpenDB, that opens
+// each connection with OpenConfig(cfg) and runs the hooks on it in order
+// before it is used. A hook's error closes the connection and fails the
+// connect. It needs the driver package to be imported:
+//
+//	import _ "github.com/cyw0ng95/sqlvibe/driver"
+//
+//	c, err := sqlvibe.NewConnector(&sqlvibe.Config{Path: "app.db", ForeignKeys: true},
+//		func(db *sqlvibe.Database) error { ... })
+//	db := sql.OpenDB(c)
+func NewConnector(cfg *Config, hooks ...func(db *Database) error) (driver.Connector, error) {
+	if newConnector == nil {
+		return nil, errors.New("sqlvibe: NewConnector needs the github.com/cyw0ng95/sqlvibe/driver package to be imported")
+	}
+	var hook func(db *Database) error
+	if len(hooks) > 0 {
+		hook = func(db *Database) error {
+			for _, h := range hooks {
+				if err := h(db); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return newConnector(cfg, hook), nil
+}
@@ -0,0 +1,108 @@
+package sqlvibe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDSN(t *testing.T) {
+	cfg, err := ParseDSN("file:app.db?mode=ro&cache=shared&_busy_timeout=5000&_foreign_keys=on&_journal_mode=wal&_txlock=IMMEDIATE&_time_format=unix&_loc=UTC")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	want := Config{Path: "app.db", Mode: ModeReadOnly, Cache: "shared", BusyTimeout: 5 * time.Second,
+		ForeignKeys: true, JournalMode: "WAL", TxLock: TxLockImmediate, TimeFormat: TimeFormatUnix, Location: time.UTC}
+	if *cfg != want {
+		t.Errorf("ParseDSN = %+v, want %+v", *cfg, want)
+	}
+	if cfg.BeginStatement() != "BEGIN IMMEDIATE" {
+		t.Errorf("BeginStatement = %q", cfg.BeginStatement())
+	}
+
+	if cfg, err := ParseDSN("file:scratch?mode=memory"); err != nil || cfg.Path != "scratch" || cfg.Mode != ModeMemory {
+		t.Errorf("memory DSN = %+v, %v", cfg, err)
+	}
+	if cfg, err := ParseDSN(":memory:"); err != nil || cfg.Path != ":memory:" || cfg.BeginStatement() != "BEGIN" {
+		t.Errorf("plain path = %+v, %v", cfg, err)
+	}
+	for _, bad := range []string{"app.db?mode=rx", "app.db?_txlock=now", "app.db?_foreign_keys=maybe", "app.db?_busy_timeout=-1", "app.db?colour=red", "file:?mode=ro"} {
+		if _, err := ParseDSN(bad); err == nil {
+			t.Errorf("ParseDSN(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestOpenConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	if _, err := OpenConfig(&Config{Path: path, Mode: ModeReadWrite}); err == nil {
+		t.Fatal("mode=rw created a missing file")
+	}
+	if _, err := OpenConfig(&Config{Path: path, Mode: ModeReadOnly}); err == nil {
+		t.Fatal("mode=ro opened a missing file")
+	}
+
+	cfg, err := ParseDSN("file:" + path + "?_foreign_keys=on&_busy_timeout=250")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	db, err := OpenConfig(cfg)
+	if err != nil {
+		t.Fatalf("OpenConfig: %v", err)
+	}
+	if got := queryAll(t, db, "PRAGMA foreign_keys"); got != "1" {
+		t.Errorf("foreign_keys = %s", got)
+	}
+	if got := queryAll(t, db, "PRAGMA busy_timeout"); got != "250" {
+		t.Errorf("busy_timeout = %s", got)
+	}
+	execOK(t, db, "CREATE TABLE t (x)", "INSERT INTO t VALUES (1)")
+	db.Close()
+	before, _ := os.ReadFile(path)
+
+	ro, err := OpenConfig(&Config{Path: path, Mode: ModeReadOnly})
+	if err != nil {
+		t.Fatalf("open read-only: %v", err)
+	}
+	if got := queryAll(t, ro, "SELECT x FROM t"); got != "1" {
+		t.Errorf("read-only SELECT = %s", got)
+	}
+	for _, sql := range []string{"INSERT INTO t VALUES (2)", "UPDATE t SET x = 3", "DELETE FROM t",
+		"CREATE TABLE u (y)", "DROP TABLE t", "VACUUM", "PRAGMA journal_mode = DELETE"} {
+		if _, err := ro.Exec(sql); err == nil || !strings.Contains(err.Error(), "readonly") {
+			t.Errorf("%s on a read-only database: %v", sql, err)
+		}
+	}
+	if _, err := ro.Query("INSERT INTO t VALUES (4) RETURNING x"); err == nil {
+		t.Error("INSERT ... RETURNING on a read-only database succeeded")
+	}
+	if err := ro.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Error("closing a read-only database rewrote the file")
+	}
+
+	// query_only refuses writes the same way until it is turned off
+	rw, err := OpenConfig(&Config{Path: path, Mode: ModeReadWrite})
+	if err != nil {
+		t.Fatalf("open read-write: %v", err)
+	}
+	defer rw.Close()
+	execOK(t, rw, "PRAGMA query_only = ON")
+	if _, err := rw.Exec("INSERT INTO t VALUES (5)"); err == nil {
+		t.Error("INSERT with query_only on succeeded")
+	}
+	execOK(t, rw, "PRAGMA query_only = OFF", "INSERT INTO t VALUES (5)")
+
+	mem, err := OpenConfig(&Config{Path: path, Mode: ModeMemory})
+	if err != nil {
+		t.Fatalf("open in memory: %v", err)
+	}
+	defer mem.Close()
+	if got := queryAll(t, mem, "SELECT name FROM sqlite_master"); got != "" {
+		t.Errorf("mode=memory read the file: %s", got)
+	}
+}
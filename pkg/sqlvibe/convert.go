@@ -0,0 +1,270 @@
+package sqlvibe
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// scanValue stores the column value src in dst, which must be a non-nil
+// pointer. dst may be a sql.Scanner, a pointer to a pointer (set to nil for
+// NULL), *interface{}, *time.Time, *[]byte or a pointer to a string,
+// integer, float or bool type, named or not. Conversions that would lose
+// information — NULL into a non-nullable type, a fraction or an
+// out-of-range value into an integer, unparsable text — return an error.
+// o says how a time.Time destination reads numbers and zone-less text.
+func scanValue(dst interface{}, src interface{}, o timeOptions) error {
+	if s, ok := dst.(sql.Scanner); ok {
+		return s.Scan(src)
+	}
+	switch d := dst.(type) {
+	case *interface{}:
+		if b, ok := src.([]byte); ok {
+			src = append([]byte(nil), b...)
+		}
+		*d = src
+		return nil
+	case *[]byte:
+		switch v := src.(type) {
+		case nil:
+			*d = nil
+		case []byte:
+			*d = append([]byte(nil), v...)
+		case string:
+			*d = []byte(v)
+		default:
+			return fmt.Errorf("converting %T to []byte is unsupported", src)
+		}
+		return nil
+	case *time.Time:
+		t, err := o.parse(src)
+		if err != nil {
+			return err
+		}
+		*d = t
+		return nil
+	}
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Pointer || dv.IsNil() {
+		return fmt.Errorf("destination not a non-nil pointer: %T", dst)
+	}
+	return assignValue(dv.Elem(), src, o)
+}
+
+// assignValue stores src in the settable dv.
+func assignValue(dv reflect.Value, src interface{}, o timeOptions) error {
+	if dv.Kind() == reflect.Pointer {
+		if src == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+		p := reflect.New(dv.Type().Elem())
+		if err := scanValue(p.Interface(), src, o); err != nil {
+			return err
+		}
+		dv.Set(p)
+		return nil
+	}
+	if dv.CanAddr() && dv.Addr().Type().Implements(scannerType) {
+		return dv.Addr().Interface().(sql.Scanner).Scan(src)
+	}
+	if dv.Type() == timeType {
+		t, err := o.parse(src)
+		if err != nil {
+			return err
+		}
+		dv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if dv.Kind() == reflect.Interface {
+		if src == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+		} else {
+			dv.Set(reflect.ValueOf(src))
+		}
+		return nil
+	}
+	if src == nil {
+		return fmt.Errorf("converting NULL to %s is unsupported", dv.Type())
+	}
+	switch dv.Kind() {
+	case reflect.String:
+		switch v := src.(type) {
+		case string:
+			dv.SetString(v)
+		case []byte:
+			dv.SetString(string(v))
+		case int64:
+			dv.SetString(strconv.FormatInt(v, 10))
+		case float64:
+			dv.SetString(strconv.FormatFloat(v, 'g', -1, 64))
+		case bool:
+			dv.SetString(strconv.FormatBool(v))
+		case time.Time:
+			dv.SetString(v.In(o.location()).Format(timeFormats[0]))
+		default:
+			return fmt.Errorf("converting %T to %s is unsupported", src, dv.Type())
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := asInt(src)
+		if err != nil {
+			return fmt.Errorf("converting %v to %s: %w", src, dv.Type(), err)
+		}
+		if dv.OverflowInt(n) {
+			return fmt.Errorf("converting %d to %s: value out of range", n, dv.Type())
+		}
+		dv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if s, ok := src.(string); ok {
+			u, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				return fmt.Errorf("converting %q to %s: %w", s, dv.Type(), err)
+			}
+			if dv.OverflowUint(u) {
+				return fmt.Errorf("converting %d to %s: value out of range", u, dv.Type())
+			}
+			dv.SetUint(u)
+			return nil
+		}
+		n, err := asInt(src)
+		if err != nil {
+			return fmt.Errorf("converting %v to %s: %w", src, dv.Type(), err)
+		}
+		if n < 0 || dv.OverflowUint(uint64(n)) {
+			return fmt.Errorf("converting %d to %s: value out of range", n, dv.Type())
+		}
+		dv.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch v := src.(type) {
+		case float64:
+			f = v
+		case int64:
+			f = float64(v)
+		case string:
+			var err error
+			if f, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				return fmt.Errorf("converting %q to %s: %w", v, dv.Type(), err)
+			}
+		case bool:
+			if v {
+				f = 1
+			}
+		default:
+			return fmt.Errorf("converting %T to %s is unsupported", src, dv.Type())
+		}
+		if dv.OverflowFloat(f) {
+			return fmt.Errorf("converting %g to %s: value out of range", f, dv.Type())
+		}
+		dv.SetFloat(f)
+		return nil
+	case reflect.Bool:
+		b, err := driver.Bool.ConvertValue(src)
+		if err != nil {
+			return fmt.Errorf("converting %v to %s: %w", src, dv.Type(), err)
+		}
+		dv.SetBool(b.(bool))
+		return nil
+	case reflect.Slice:
+		if dv.Type().Elem().Kind() == reflect.Uint8 {
+			switch v := src.(type) {
+			case []byte:
+				dv.SetBytes(append([]byte(nil), v...))
+				return nil
+			case string:
+				dv.SetBytes([]byte(v))
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unsupported Scan, storing %T into type %s", src, dv.Type())
+}
+
+// asInt converts a column value to an int64 without losing information.
+func asInt(src interface{}) (int64, error) {
+	switch v := src.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, fmt.Errorf("%g is not an integer", v)
+		}
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	case []byte:
+		return strconv.ParseInt(strings.TrimSpace(string(v)), 10, 64)
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unsupported type %T", src)
+}
+
+// bindValue reduces a parameter to a value formatSQLLiteral writes
+// faithfully: driver.Valuer results, time.Time as o stores it, dereferenced
+// pointers and the underlying value of named basic types. Other types,
+// such as structs and maps, are an error.
+func bindValue(v interface{}, o timeOptions) (interface{}, error) {
+	if vr, ok := v.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return nil, nil
+		}
+		dv, err := vr.Value()
+		if err != nil {
+			return nil, err
+		}
+		if _, again := dv.(driver.Valuer); again {
+			return nil, fmt.Errorf("Value() of %T returned another driver.Valuer", v)
+		}
+		return bindValue(dv, o)
+	}
+	switch val := v.(type) {
+	case nil, int64, int, int32, int16, int8, uint64, uint, uint32, float64, float32, bool, string, []byte:
+		return v, nil
+	case uint16:
+		return uint64(val), nil
+	case uint8:
+		return uint64(val), nil
+	case time.Time:
+		return o.bind(val), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return bindValue(rv.Elem().Interface(), o)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return rv.Bytes(), nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported parameter type %T", v)
+}
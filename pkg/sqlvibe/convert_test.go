@@ -0,0 +1,189 @@
+package sqlvibe
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upper is a sql.Scanner and driver.Valuer that stores text upper-cased.
+type upper string
+
+func (u *upper) Scan(src interface{}) error {
+	s, ok := src.(string)
+	if !ok {
+		return errors.New("upper: not text")
+	}
+	*u = upper(strings.ToUpper(s))
+	return nil
+}
+
+func (u upper) Value() (driver.Value, error) { return strings.ToUpper(string(u)), nil }
+
+type level int
+
+func TestScanConversions(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT 42, 2.5, 'text', x'0102', NULL, '2024-03-05 06:07:08', '2024-03-05T06:07:08.5+02:00', 1, '17'")
+	if err != nil || !rows.Next() {
+		t.Fatalf("Query: %v", err)
+	}
+	var (
+		i8    int8
+		f32   float32
+		s     string
+		b     []byte
+		ns    sql.NullString
+		ts    time.Time
+		tz    time.Time
+		ok    bool
+		lv    level
+		pi    *int64
+		ps    *string
+		iface interface{}
+		u     upper
+	)
+	if err := rows.Scan(&i8, &f32, &u, &b, &ns, &ts, &tz, &ok, &lv); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if i8 != 42 || f32 != 2.5 || u != "TEXT" || string(b) != "\x01\x02" || ns.Valid || !ok || lv != 17 {
+		t.Errorf("unexpected values: %v %v %q %v %v %v %v", i8, f32, u, b, ns, ok, lv)
+	}
+	if want := time.Date(2024, 3, 5, 6, 7, 8, 0, time.UTC); !ts.Equal(want) {
+		t.Errorf("time = %v, want %v", ts, want)
+	}
+	if want := time.Date(2024, 3, 5, 4, 7, 8, 5e8, time.UTC); !tz.Equal(want) {
+		t.Errorf("time with zone = %v, want %v", tz, want)
+	}
+	if err := rows.Scan(&pi, &s, &s, &iface, &ps, &s, &s, &s, &s); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if pi == nil || *pi != 42 || ps != nil || iface.([]byte)[1] != 2 {
+		t.Errorf("unexpected pointer values: %v %v %v", pi, ps, iface)
+	}
+
+	// Lossy conversions fail and name the column
+	bad := []struct {
+		col  int
+		dest interface{}
+	}{
+		{1, new(int)}, // 2.5 is not an integer
+		{2, new(float64)},
+		{2, new(time.Time)},
+		{4, new(int)}, // NULL
+		{4, new(string)},
+		{2, new(bool)},
+		{0, new(struct{})},
+	}
+	for _, c := range bad {
+		dest := make([]interface{}, 9)
+		for i := range dest {
+			dest[i] = new(interface{})
+		}
+		dest[c.col] = c.dest
+		err := rows.Scan(dest...)
+		if err == nil || !strings.Contains(err.Error(), "column index") {
+			t.Errorf("scanning column %d into %T: %v", c.col, c.dest, err)
+		}
+	}
+	if err := rows.Scan(&s); err == nil {
+		t.Error("Scan with too few destinations succeeded")
+	}
+	var over int8
+	rows, _ = db.Query("SELECT 300")
+	rows.Next()
+	if err := rows.Scan(&over); err == nil {
+		t.Error("300 scanned into an int8")
+	}
+}
+
+func TestBindConversions(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	execOK(t, db, "CREATE TABLE v (a, b, c, d, e, f)")
+	when := time.Date(2024, 3, 5, 6, 7, 8, 0, time.UTC)
+	name := "ptr"
+	var none *string
+	if _, err := db.ExecWithParams("INSERT INTO v VALUES (?, ?, ?, ?, ?, ?)",
+		[]interface{}{upper("shout"), when, &name, none, level(3), sql.NullInt64{}}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if got := queryAll(t, db, "SELECT a, b, c, d, e, f FROM v"); got != "SHOUT,2024-03-05 06:07:08+00:00,ptr,<nil>,3,<nil>" {
+		t.Errorf("unexpected bound values: %s", got)
+	}
+	if _, err := db.ExecWithParams("INSERT INTO v (a) VALUES (?)", []interface{}{struct{}{}}); err == nil {
+		t.Error("binding a struct succeeded")
+	}
+	var back time.Time
+	rows, _ := db.Query("SELECT b FROM v")
+	rows.Next()
+	if err := rows.Scan(&back); err != nil || !back.Equal(when) {
+		t.Errorf("time round trip = %v, %v", back, err)
+	}
+}
+
+type account struct {
+	ID      int64
+	Owner   string `db:"owner_name"`
+	Balance sql.NullFloat64
+	Note    *string
+	Skipped string `db:"-"`
+	audit
+}
+
+type audit struct {
+	Created time.Time `db:"created_at"`
+}
+
+func TestQueryStructs(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE accounts (id INTEGER PRIMARY KEY, owner_name TEXT, balance REAL, note TEXT, created_at TEXT)",
+		"INSERT INTO accounts VALUES (1, 'ann', 10.5, NULL, '2024-01-02 03:04:05'), (2, 'bob', NULL, 'vip', '2024-02-03')",
+	)
+	all, err := QueryStructs[account](db, "SELECT * FROM accounts WHERE id >= ? ORDER BY id", 1)
+	if err != nil {
+		t.Fatalf("QueryStructs: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("got %d accounts", len(all))
+	}
+	a, b := all[0], all[1]
+	if a.ID != 1 || a.Owner != "ann" || !a.Balance.Valid || a.Balance.Float64 != 10.5 || a.Note != nil ||
+		a.Created != time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) {
+		t.Errorf("unexpected first account: %+v", a)
+	}
+	if b.Balance.Valid || b.Note == nil || *b.Note != "vip" || b.Created.Day() != 3 {
+		t.Errorf("unexpected second account: %+v", b)
+	}
+
+	one, err := QueryOne[account](db, "SELECT id, owner_name FROM accounts WHERE owner_name = ?", "bob")
+	if err != nil || one.ID != 2 || one.Owner != "bob" {
+		t.Errorf("QueryOne = %+v, %v", one, err)
+	}
+	if _, err := QueryOne[account](db, "SELECT id FROM accounts WHERE id = ?", 99); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("QueryOne with no rows: %v", err)
+	}
+	if _, err := QueryStructs[account](db, "SELECT id, 1 AS stray FROM accounts"); err == nil || !strings.Contains(err.Error(), "stray") {
+		t.Errorf("unmatched column: %v", err)
+	}
+	rows, _ := db.Query("SELECT id FROM accounts")
+	rows.Next()
+	if err := rows.ScanStruct(account{}); err == nil {
+		t.Error("ScanStruct into a non-pointer succeeded")
+	}
+}
@@ -0,0 +1,376 @@
+package sqlvibe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVImportOptions controls how ImportCSV behaves.
+type CSVImportOptions struct {
+	HasHeader   bool   // first row names the columns; otherwise fields fill the table's columns in order
+	Comma       rune   // field delimiter; 0 picks ',', '\t', ';' or '|', whichever the first line holds most of
+	CreateTable bool   // CREATE TABLE IF NOT EXISTS with column types inferred from the data
+	NullString  string // field value treated as NULL (default: "")
+	Skip        int    // lines to skip before the header or first row
+	// SampleRows is how many rows CreateTable infers column types from
+	// (default 1000).
+	SampleRows int
+	// ColumnTypes gives declared types for CreateTable to use instead of
+	// inferred ones, by column name.
+	ColumnTypes map[string]string
+	// Rejects, if set, receives the rows that cannot be parsed or stored, as
+	// CSV records of the line, the reason and the row's fields, each in one
+	// Write, and the import goes on. Otherwise the first such row fails it.
+	Rejects io.Writer
+}
+
+// ImportCSV reads CSV data from r and inserts rows into tableName through
+// BulkInsert, streaming the file. A leading byte order mark is skipped.
+// Fields are stored as TEXT, converted by the column affinities; with
+// CreateTable a missing table is created with INTEGER, REAL, DATE,
+// DATETIME or TEXT columns, as the first rows' values fit. Returns the
+// number of rows inserted.
+func (db *Database) ImportCSV(tableName string, r io.Reader, opts CSVImportOptions) (int, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+	for i := 0; i < opts.Skip; i++ {
+		if _, err := br.ReadString('\n'); err == io.EOF {
+			return 0, nil
+		} else if err != nil {
+			return 0, fmt.Errorf("ImportCSV: %w", err)
+		}
+	}
+	if opts.Comma == 0 {
+		opts.Comma = sniffDelimiter(br)
+	}
+	in := &csvInput{cr: csv.NewReader(br), skip: opts.Skip, null: opts.NullString, strict: opts.Rejects == nil}
+	in.cr.Comma = opts.Comma
+	in.cr.TrimLeadingSpace = true
+	in.cr.FieldsPerRecord = -1
+
+	var cols []string
+	if opts.HasHeader {
+		header, _, err := in.read()
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("ImportCSV: reading header: %w", err)
+		}
+		cols = make([]string, len(header))
+		for i, h := range header {
+			if cols[i] = strings.TrimSpace(h); cols[i] == "" {
+				cols[i] = fmt.Sprintf("c%d", i)
+			}
+		}
+	}
+	if opts.CreateTable {
+		if err := in.sample(opts.SampleRows); err != nil {
+			return 0, fmt.Errorf("ImportCSV: %w", err)
+		}
+		if err := db.createCSVTable(tableName, cols, in.pending, opts); err != nil {
+			return 0, fmt.Errorf("ImportCSV: %w", err)
+		}
+	}
+
+	maxRejects := 0
+	if opts.Rejects != nil {
+		maxRejects = -1
+	}
+	res, err := db.BulkInsert(tableName, cols, in, BulkOptions{MaxRejects: maxRejects})
+	if in.err != nil {
+		return res.Inserted, fmt.Errorf("ImportCSV: %w", in.err)
+	}
+	if err != nil {
+		if n := len(res.Rejected); n > 0 && opts.Rejects == nil {
+			last := res.Rejected[n-1]
+			return res.Inserted, fmt.Errorf("ImportCSV: line %d: %w", in.lines.line(last.Row), last.Err)
+		}
+		return res.Inserted, fmt.Errorf("ImportCSV: %w", err)
+	}
+	if opts.Rejects != nil {
+		for _, rj := range res.Rejected {
+			fields := make([]string, len(rj.Values))
+			for i, v := range rj.Values {
+				if v == nil {
+					fields[i] = opts.NullString
+				} else {
+					fields[i] = valueToString(v)
+				}
+			}
+			in.rejects = append(in.rejects, csvReject{in.lines.line(rj.Row), rj.Err.Error(), fields})
+		}
+		if err := writeCSVRejects(opts.Rejects, opts.Comma, in.rejects); err != nil {
+			return res.Inserted, fmt.Errorf("ImportCSV: writing rejects: %w", err)
+		}
+	}
+	return res.Inserted, nil
+}
+
+// csvInput is the RowSource of ImportCSV: the records of a CSV reader,
+// after those read ahead to sample column types. Records that do not parse
+// are set aside as rejects unless strict, when they end the input.
+type csvInput struct {
+	cr      *csv.Reader
+	skip    int // lines skipped before the reader's first
+	null    string
+	strict  bool
+	pending [][]string // records read ahead, not yet returned
+	pendAt  []int      // their lines
+	n       int        // records returned so far
+	lines   lineIndex  // line of each record returned
+	row     []interface{}
+	rejects []csvReject
+	err     error // why the input ended early
+}
+
+type csvReject struct {
+	line   int
+	reason string
+	fields []string
+}
+
+// read returns the next record that parses, with its line.
+func (in *csvInput) read() ([]string, int, error) {
+	for {
+		rec, err := in.cr.Read()
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			line := perr.StartLine + in.skip
+			if in.strict {
+				return nil, 0, fmt.Errorf("line %d: %w", line, perr.Err)
+			}
+			in.rejects = append(in.rejects, csvReject{line: line, reason: perr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		line, _ := in.cr.FieldPos(0)
+		return rec, line + in.skip, nil
+	}
+}
+
+// sample reads up to n records (default 1000) ahead.
+func (in *csvInput) sample(n int) error {
+	if n <= 0 {
+		n = 1000
+	}
+	for len(in.pending) < n {
+		rec, line, err := in.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		in.pending = append(in.pending, rec)
+		in.pendAt = append(in.pendAt, line)
+	}
+	return nil
+}
+
+// Next implements RowSource.
+func (in *csvInput) Next() ([]interface{}, error) {
+	var rec []string
+	var line int
+	if len(in.pending) > 0 {
+		rec, line = in.pending[0], in.pendAt[0]
+		in.pending, in.pendAt = in.pending[1:], in.pendAt[1:]
+	} else {
+		var err error
+		if rec, line, err = in.read(); err != nil {
+			if err != io.EOF {
+				in.err = err
+			}
+			return nil, io.EOF
+		}
+	}
+	in.lines.add(in.n, line)
+	in.n++
+	in.row = in.row[:0]
+	for _, v := range rec {
+		if v == in.null {
+			in.row = append(in.row, nil)
+		} else {
+			in.row = append(in.row, v)
+		}
+	}
+	return in.row, nil
+}
+
+// lineIndex maps record numbers to line numbers, storing only where a
+// record spanning several lines shifts them
+type lineIndex struct {
+	rows, deltas []int
+}
+
+func (x *lineIndex) add(row, line int) {
+	if n := len(x.deltas); n == 0 || x.deltas[n-1] != line-row {
+		x.rows = append(x.rows, row)
+		x.deltas = append(x.deltas, line-row)
+	}
+}
+
+func (x *lineIndex) line(row int) int {
+	i := sort.SearchInts(x.rows, row+1) - 1
+	if i < 0 {
+		return 0
+	}
+	return row + x.deltas[i]
+}
+
+// writeCSVRejects writes rejects in line order, one Write each.
+func writeCSVRejects(w io.Writer, comma rune, rejects []csvReject) error {
+	sort.SliceStable(rejects, func(i, j int) bool { return rejects[i].line < rejects[j].line })
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Comma = comma
+	for _, rj := range rejects {
+		buf.Reset()
+		cw.Write(append([]string{strconv.Itoa(rj.line), rj.reason}, rj.fields...))
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sniffDelimiter picks the delimiter of the CSV data br starts with: the
+// candidate the first line holds most of outside quotes, ',' if none.
+func sniffDelimiter(br *bufio.Reader) rune {
+	head, _ := br.Peek(br.Size())
+	counts := map[byte]int{}
+	quoted := false
+	for _, c := range head {
+		if c == '"' {
+			quoted = !quoted
+		} else if !quoted && (c == '\n' || c == '\r') {
+			break
+		} else if !quoted {
+			counts[c]++
+		}
+	}
+	best := byte(',')
+	for _, c := range []byte{'\t', ';', '|'} {
+		if counts[c] > counts[best] {
+			best = c
+		}
+	}
+	return rune(best)
+}
+
+// createCSVTable creates table, if it does not exist, with a column per
+// field and types inferred from sample or given by opts.ColumnTypes.
+func (db *Database) createCSVTable(table string, cols []string, sample [][]string, opts CSVImportOptions) error {
+	if cols == nil {
+		n := 0
+		for _, rec := range sample {
+			n = max(n, len(rec))
+		}
+		for i := 0; i < n; i++ {
+			cols = append(cols, fmt.Sprintf("c%d", i))
+		}
+	}
+	if len(cols) == 0 {
+		return fmt.Errorf("no columns to create %s with", table)
+	}
+	types := make(map[string]string, len(opts.ColumnTypes))
+	for name, typ := range opts.ColumnTypes {
+		types[strings.ToLower(name)] = typ
+	}
+	defs := make([]string, len(cols))
+	for i, c := range cols {
+		typ, ok := types[strings.ToLower(c)]
+		if ok {
+			delete(types, strings.ToLower(c))
+		} else {
+			var vals []string
+			for _, rec := range sample {
+				if i < len(rec) && rec[i] != opts.NullString && strings.TrimSpace(rec[i]) != "" {
+					vals = append(vals, strings.TrimSpace(rec[i]))
+				}
+			}
+			typ = inferColumnType(vals)
+		}
+		defs[i] = quoteIdent(c) + " " + typ
+	}
+	if len(types) > 0 {
+		var names []string
+		for name := range types {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("ColumnTypes names %s, not columns of the data", strings.Join(names, ", "))
+	}
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + quoteIdent(table) + " (" + strings.Join(defs, ", ") + ")")
+	return err
+}
+
+// inferColumnType returns the narrowest of INTEGER, REAL, DATE, DATETIME
+// and TEXT that holds every value of vals. Integers with leading zeros,
+// such as ZIP codes, are TEXT so that the zeros are kept.
+func inferColumnType(vals []string) string {
+	if len(vals) == 0 {
+		return "TEXT"
+	}
+	isInt, isReal, isDate, isDateTime := true, true, true, true
+	for _, v := range vals {
+		digits := strings.TrimPrefix(v, "-")
+		leadingZero := len(digits) > 1 && digits[0] == '0' && digits[1] != '.'
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil || leadingZero {
+			isInt = false
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil || leadingZero || strings.ContainsAny(v, "iInNxX_") {
+			isReal = false
+		}
+		date, datetime := timeKind(v)
+		isDate = isDate && date
+		isDateTime = isDateTime && (date || datetime)
+		if !isInt && !isReal && !isDateTime {
+			return "TEXT"
+		}
+	}
+	switch {
+	case isInt:
+		return "INTEGER"
+	case isReal:
+		return "REAL"
+	case isDate:
+		return "DATE"
+	case isDateTime:
+		return "DATETIME"
+	}
+	return "TEXT"
+}
+
+// timeKind reports whether v is a date, or a date and time, in one of the
+// layouts times are read back from.
+func timeKind(v string) (date, datetime bool) {
+	v = strings.TrimSuffix(v, "Z")
+	for i, layout := range timeFormats {
+		if _, err := time.Parse(layout, v); err == nil {
+			return i == len(timeFormats)-1, i < len(timeFormats)-1
+		}
+	}
+	return false, false
+}
+
+// quoteIdent quotes name as an SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,98 @@
+package sqlvibe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInferColumnType(t *testing.T) {
+	tests := []struct {
+		vals []string
+		want string
+	}{
+		{nil, "TEXT"},
+		{[]string{"1", "-20", "300"}, "INTEGER"},
+		{[]string{"1", "2.5", "1e3"}, "REAL"},
+		{[]string{"02134", "10001"}, "TEXT"},
+		{[]string{"0.5", "0"}, "REAL"},
+		{[]string{"NaN"}, "TEXT"},
+		{[]string{"2024-01-15", "1999-12-31"}, "DATE"},
+		{[]string{"2024-01-15", "2024-01-15 10:30:00", "2024-01-15T10:30:00Z"}, "DATETIME"},
+		{[]string{"2024-01-15", "soon"}, "TEXT"},
+	}
+	for _, tt := range tests {
+		if got := inferColumnType(tt.vals); got != tt.want {
+			t.Errorf("inferColumnType(%q) = %s, want %s", tt.vals, got, tt.want)
+		}
+	}
+}
+
+func TestImportCSVCreateTable(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	data := "\xef\xbb\xbf# exported 2024-01-15\n" +
+		"\"user id\";name;score;joined;zip\n" +
+		"1;ann;9.5;2024-01-02;02134\n" +
+		"2;\"bob; jr\";7;2024-02-03;10001\n"
+	n, err := db.ImportCSV("people", strings.NewReader(data), CSVImportOptions{
+		HasHeader:   true,
+		CreateTable: true,
+		Skip:        1,
+		ColumnTypes: map[string]string{"ZIP": "TEXT NOT NULL"},
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("ImportCSV = %d, %v", n, err)
+	}
+	cols, err := db.GetColumns("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decl []string
+	for _, c := range cols {
+		decl = append(decl, c.Name+" "+c.Type)
+	}
+	if got := strings.Join(decl, ", "); got != "user id INTEGER, name TEXT, score REAL, joined DATE, zip TEXT" {
+		t.Errorf("columns: %s", got)
+	}
+	if got := queryAll(t, db, `SELECT "user id", name, typeof(score), zip FROM people`); got != "1,ann,real,02134|2,bob; jr,real,10001" {
+		t.Errorf("rows: %s", got)
+	}
+
+	if _, err := db.ImportCSV("other", strings.NewReader("a\n1\n"), CSVImportOptions{
+		HasHeader: true, CreateTable: true, ColumnTypes: map[string]string{"b": "TEXT"},
+	}); err == nil || !strings.Contains(err.Error(), "ColumnTypes names b") {
+		t.Errorf("unknown ColumnTypes column: %v", err)
+	}
+}
+
+func TestImportCSVRejects(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT NOT NULL)")
+
+	data := "id\tname\n1\tann\n2\t\n3\t\"two\nlines\"\n1\tdup\n4\tx\ty\n5\teve\n"
+	var rejects bytes.Buffer
+	n, err := db.ImportCSV("t", strings.NewReader(data), CSVImportOptions{HasHeader: true, Rejects: &rejects})
+	if err != nil || n != 3 {
+		t.Fatalf("ImportCSV = %d, %v", n, err)
+	}
+	want := "3\tNOT NULL constraint failed: t.name\t2\t\n" +
+		"6\tUNIQUE constraint failed: t.id\t1\tdup\n" +
+		"7\t3 values for 2 columns\t4\tx\ty\n"
+	if got := rejects.String(); got != want {
+		t.Errorf("rejects:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without a rejects writer the first bad row fails the import
+	execOK(t, db, "DELETE FROM t")
+	_, err = db.ImportCSV("t", strings.NewReader(data), CSVImportOptions{HasHeader: true})
+	if err == nil || !strings.Contains(err.Error(), "line 3: NOT NULL constraint failed") {
+		t.Errorf("ImportCSV error = %v", err)
+	}
+	_, err = db.ImportCSV("t", strings.NewReader("id,name\n9,\"bad\"quote\n"), CSVImportOptions{HasHeader: true})
+	if err == nil || !strings.Contains(err.Error(), "line 2:") {
+		t.Errorf("ImportCSV parse error = %v", err)
+	}
+}
@@ -5,15 +5,23 @@
 package sqlvibe
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/base64"
 	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	cgo "github.com/cyw0ng95/sqlvibe/pkg/sqlvibe/cgo"
 )
@@ -21,7 +29,9 @@ import (
 // Database is the primary handle for a sqlvibe database.
 // All methods are safe to call concurrently from multiple goroutines.
 type Database struct {
-	cdb *cgo.DB
+	cdb      *cgo.DB
+	shared   *sharedDB // set for a shared in-memory database; cdb is its handle
+	timeOpts atomic.Pointer[timeOptions]
 }
 
 // Result holds the outcome of a non-query SQL execution.
@@ -33,11 +43,112 @@ type Result struct {
 // Rows holds a materialized query result set.
 // Columns and Data are exported for direct inspection.
 type Rows struct {
-	Columns []string
-	Data    [][]interface{}
-	pos     int  // current row index; valid range [0, len(Data))
-	started bool // whether Next() has been called at least once
-	err     error
+	Columns  []string
+	Data     [][]interface{}
+	types    []ColumnType // parallel to Columns when the engine described them
+	timeOpts timeOptions  // how time destinations read values
+	pos      int          // current row index; valid range [0, len(Data))
+	started  bool         // whether Next() has been called at least once
+	err      error
+}
+
+// ColumnType describes a result column: the table column it reads, if any,
+// with that column's declared type and whether it can hold NULL.
+type ColumnType struct {
+	Name     string
+	DeclType string // declared type as written, e.g. "VARCHAR(20)"; "" if computed
+	Table    string // source table, "" if computed
+	Origin   string // source column, "" if computed
+	// Nullable is false only for columns known to be NOT NULL;
+	// NullableKnown tells whether the engine could tell.
+	Nullable      bool
+	NullableKnown bool
+}
+
+// DatabaseTypeName returns the declared type upper-cased and without its
+// length, e.g. "VARCHAR".
+func (c ColumnType) DatabaseTypeName() string {
+	t := c.DeclType
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = t[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(t))
+}
+
+// affinity returns the type affinity of the declared type, by SQLite's
+// rules, or "" when there is no declared type.
+func (c ColumnType) affinity() string {
+	t := strings.ToUpper(c.DeclType)
+	switch {
+	case t == "":
+		return ""
+	case strings.Contains(t, "INT"):
+		return "INTEGER"
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return "TEXT"
+	case strings.Contains(t, "BLOB"):
+		return "BLOB"
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return "REAL"
+	}
+	return "NUMERIC"
+}
+
+// ScanType returns a Go type suitable for scanning the column: int64,
+// float64, string or []byte by affinity, their sql.Null variants unless the
+// column is NOT NULL, and interface{} when the type is unknown.
+func (c ColumnType) ScanType() reflect.Type {
+	notNull := c.NullableKnown && !c.Nullable
+	switch c.affinity() {
+	case "INTEGER":
+		if notNull {
+			return reflect.TypeOf(int64(0))
+		}
+		return reflect.TypeOf(sql.NullInt64{})
+	case "REAL":
+		if notNull {
+			return reflect.TypeOf(float64(0))
+		}
+		return reflect.TypeOf(sql.NullFloat64{})
+	case "TEXT":
+		if notNull {
+			return reflect.TypeOf("")
+		}
+		return reflect.TypeOf(sql.NullString{})
+	case "BLOB":
+		return reflect.TypeOf([]byte(nil))
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
+// Length returns the length of a TEXT or BLOB column: the n of a declared
+// "(n)", or math.MaxInt64 when unbounded. ok is false for other types.
+func (c ColumnType) Length() (length int64, ok bool) {
+	if a := c.affinity(); a != "TEXT" && a != "BLOB" {
+		return 0, false
+	}
+	if i, j := strings.IndexByte(c.DeclType, '('), strings.IndexByte(c.DeclType, ')'); i >= 0 && j > i {
+		if n, err := strconv.ParseInt(strings.TrimSpace(c.DeclType[i+1:j]), 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return math.MaxInt64, true
+}
+
+// ColumnTypes describes each result column. Columns the engine could not
+// trace to a table carry only their name.
+func (r *Rows) ColumnTypes() []ColumnType {
+	if r == nil {
+		return nil
+	}
+	out := make([]ColumnType, len(r.Columns))
+	for i, name := range r.Columns {
+		if i < len(r.types) {
+			out[i] = r.types[i]
+		}
+		out[i].Name = name
+	}
+	return out
 }
 
 // Next advances to the next row. On the first call it positions on row 0
@@ -60,12 +171,23 @@ func (r *Rows) Scan(dest ...interface{}) error {
 		return fmt.Errorf("no rows available")
 	}
 	row := r.Data[r.pos]
+	if len(dest) != len(row) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(row), len(dest))
+	}
 	for i, val := range dest {
-		if i >= len(row) {
-			break
+		src := row[i]
+		// Date and time columns scan into an interface{} as time.Time
+		if _, ok := val.(*interface{}); ok && i < len(r.types) && isTimeType(r.types[i].DeclType) && r.timeOpts.stores(src) {
+			if t, err := r.timeOpts.parse(src); err == nil {
+				src = t
+			}
 		}
-		if err := scanValue(val, row[i]); err != nil {
-			return err
+		if err := scanValue(val, src, r.timeOpts); err != nil {
+			name := ""
+			if i < len(r.Columns) {
+				name = r.Columns[i]
+			}
+			return fmt.Errorf("Scan error on column index %d, name %q: %w", i, name, err)
 		}
 	}
 	return nil
@@ -82,70 +204,6 @@ func (r *Rows) Err() error {
 // Close is a no-op; results are materialized on Query().
 func (r *Rows) Close() error { return nil }
 
-// scanValue copies src into the typed pointer dst.
-func scanValue(dst interface{}, src interface{}) error {
-	if src == nil {
-		return nil
-	}
-	switch d := dst.(type) {
-	case *int:
-		switch v := src.(type) {
-		case int:
-			*d = v
-		case int64:
-			*d = int(v)
-		case float64:
-			*d = int(v)
-		case string:
-			n, _ := strconv.ParseInt(v, 10, 64)
-			*d = int(n)
-		}
-	case *int64:
-		switch v := src.(type) {
-		case int:
-			*d = int64(v)
-		case int64:
-			*d = v
-		case float64:
-			*d = int64(v)
-		case string:
-			n, _ := strconv.ParseInt(v, 10, 64)
-			*d = n
-		}
-	case *float64:
-		switch v := src.(type) {
-		case int:
-			*d = float64(v)
-		case int64:
-			*d = float64(v)
-		case float64:
-			*d = v
-		case string:
-			f, _ := strconv.ParseFloat(v, 64)
-			*d = f
-		}
-	case *string:
-		switch v := src.(type) {
-		case string:
-			*d = v
-		default:
-			*d = fmt.Sprintf("%v", v)
-		}
-	case *interface{}:
-		*d = src
-	case *bool:
-		switch v := src.(type) {
-		case bool:
-			*d = v
-		case int64:
-			*d = v != 0
-		case int:
-			*d = v != 0
-		}
-	}
-	return nil
-}
-
 // Statement is a compiled SQL statement for repeated execution.
 type Statement struct {
 	cstmt *cgo.Stmt
@@ -194,7 +252,11 @@ func (tx *Transaction) Commit() error {
 	if tx.ctx == nil {
 		return fmt.Errorf("transaction already closed")
 	}
+	if err := tx.db.lock(); err != nil {
+		return err
+	}
 	err := tx.ctx.Commit()
+	tx.db.unlock()
 	tx.ctx = nil
 	return err
 }
@@ -204,7 +266,11 @@ func (tx *Transaction) Rollback() error {
 	if tx.ctx == nil {
 		return fmt.Errorf("transaction already closed")
 	}
+	if err := tx.db.lock(); err != nil {
+		return err
+	}
 	err := tx.ctx.Rollback()
+	tx.db.unlock()
 	tx.ctx = nil
 	return err
 }
@@ -217,6 +283,10 @@ func Open(path string) (*Database, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := registerTableFunctions(cdb); err != nil {
+		cdb.Close()
+		return nil, err
+	}
 	return &Database{cdb: cdb}, nil
 }
 
@@ -225,14 +295,23 @@ func (db *Database) Close() error {
 	if db.cdb == nil {
 		return nil
 	}
-	err := db.cdb.Close()
+	var err error
+	if db.shared != nil {
+		err = db.shared.detach(db)
+	} else {
+		err = db.cdb.Close()
+	}
 	db.cdb = nil
 	return err
 }
 
 // Exec executes a non-query SQL statement and returns the result.
 func (db *Database) Exec(sql string) (Result, error) {
+	if err := db.lock(); err != nil {
+		return Result{}, err
+	}
 	r, err := db.cdb.Exec(sql)
+	db.unlock()
 	if err != nil {
 		return Result{}, err
 	}
@@ -255,7 +334,11 @@ func (db *Database) Prepare(sql string) (*Statement, error) {
 
 // Begin starts a new explicit transaction.
 func (db *Database) Begin() (*Transaction, error) {
+	if err := db.lock(); err != nil {
+		return nil, err
+	}
 	ctx, err := db.cdb.Begin()
+	db.unlock()
 	if err != nil {
 		return nil, err
 	}
@@ -266,7 +349,7 @@ func (db *Database) Begin() (*Transaction, error) {
 
 // ExecWithParams executes a statement with positional (?) parameters.
 func (db *Database) ExecWithParams(sql string, params []interface{}) (Result, error) {
-	bound, err := formatParamSQL(sql, params, nil)
+	bound, err := formatParamSQL(sql, params, nil, db.timeOptions())
 	if err != nil {
 		return Result{}, err
 	}
@@ -275,7 +358,7 @@ func (db *Database) ExecWithParams(sql string, params []interface{}) (Result, er
 
 // QueryWithParams executes a query with positional (?) parameters.
 func (db *Database) QueryWithParams(sql string, params []interface{}) (*Rows, error) {
-	bound, err := formatParamSQL(sql, params, nil)
+	bound, err := formatParamSQL(sql, params, nil, db.timeOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -284,7 +367,7 @@ func (db *Database) QueryWithParams(sql string, params []interface{}) (*Rows, er
 
 // ExecNamed executes a statement with named parameters (:name or @name).
 func (db *Database) ExecNamed(sql string, params map[string]interface{}) (Result, error) {
-	bound, err := formatParamSQL(sql, nil, params)
+	bound, err := formatParamSQL(sql, nil, params, db.timeOptions())
 	if err != nil {
 		return Result{}, err
 	}
@@ -293,7 +376,7 @@ func (db *Database) ExecNamed(sql string, params map[string]interface{}) (Result
 
 // QueryNamed executes a query with named parameters.
 func (db *Database) QueryNamed(sql string, params map[string]interface{}) (*Rows, error) {
-	bound, err := formatParamSQL(sql, nil, params)
+	bound, err := formatParamSQL(sql, nil, params, db.timeOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -382,18 +465,32 @@ func Version() string { return cgo.Version() }
 
 // queryCGO calls svdb_query and materialises the result into a *Rows.
 func (db *Database) queryCGO(sql string) (*Rows, error) {
+	if err := db.lock(); err != nil {
+		return nil, err
+	}
+	defer db.unlock()
 	crows, err := db.cdb.Query(sql)
 	if err != nil {
 		return nil, err
 	}
 	defer crows.Close()
 
-	rows := &Rows{}
+	rows := &Rows{timeOpts: db.timeOptions()}
 	// Collect column names
 	n := crows.ColumnCount()
 	rows.Columns = make([]string, n)
+	rows.types = make([]ColumnType, n)
 	for i := 0; i < n; i++ {
 		rows.Columns[i] = crows.ColumnName(i)
+		nullable := crows.ColumnNullable(i)
+		rows.types[i] = ColumnType{
+			Name:          rows.Columns[i],
+			DeclType:      crows.ColumnDeclType(i),
+			Table:         crows.ColumnTable(i),
+			Origin:        crows.ColumnOrigin(i),
+			Nullable:      nullable != 0,
+			NullableKnown: nullable >= 0,
+		}
 	}
 	// Materialise all rows
 	for crows.Next() {
@@ -407,8 +504,8 @@ func (db *Database) queryCGO(sql string) (*Rows, error) {
 }
 
 // formatParamSQL substitutes positional ('?') and named (':name', '@name')
-// placeholders with safely-quoted SQL literals.
-func formatParamSQL(sql string, params []interface{}, namedParams map[string]interface{}) (string, error) {
+// placeholders with safely-quoted SQL literals, writing times as o says.
+func formatParamSQL(sql string, params []interface{}, namedParams map[string]interface{}, o timeOptions) (string, error) {
 	var sb strings.Builder
 	sb.Grow(len(sql) + 32)
 	paramIdx := 0
@@ -440,7 +537,11 @@ func formatParamSQL(sql string, params []interface{}, namedParams map[string]int
 			if paramIdx >= len(params) {
 				return "", fmt.Errorf("missing parameter at position %d", paramIdx+1)
 			}
-			sb.WriteString(formatSQLLiteral(params[paramIdx]))
+			v, err := bindValue(params[paramIdx], o)
+			if err != nil {
+				return "", fmt.Errorf("parameter %d: %w", paramIdx+1, err)
+			}
+			sb.WriteString(formatSQLLiteral(v))
 			paramIdx++
 			i++
 			continue
@@ -461,7 +562,11 @@ func formatParamSQL(sql string, params []interface{}, namedParams map[string]int
 			if !ok {
 				return "", fmt.Errorf("missing named parameter: %s", name)
 			}
-			sb.WriteString(formatSQLLiteral(val))
+			v, err := bindValue(val, o)
+			if err != nil {
+				return "", fmt.Errorf("parameter %s: %w", name, err)
+			}
+			sb.WriteString(formatSQLLiteral(v))
 			continue
 		}
 		sb.WriteByte(ch)
@@ -593,8 +698,12 @@ type PageStats struct {
 	OverflowPages int
 }
 
-// GetTables returns metadata for all user tables in the database.
+// GetTables returns metadata for all user tables and views in the database.
 func (db *Database) GetTables() ([]TableInfo, error) {
+	if err := db.lock(); err != nil {
+		return nil, err
+	}
+	defer db.unlock()
 	crows, err := db.cdb.Tables()
 	if err != nil {
 		return nil, err
@@ -607,6 +716,9 @@ func (db *Database) GetTables() ([]TableInfo, error) {
 		if crows.ColumnCount() >= 2 {
 			ti.SQL, _ = crows.Get(1).(string)
 		}
+		if crows.ColumnCount() >= 3 {
+			ti.Type, _ = crows.Get(2).(string)
+		}
 		tables = append(tables, ti)
 	}
 	return tables, nil
@@ -679,6 +791,10 @@ func (db *Database) GetColumns(table string) ([]ColumnInfo, error) {
 
 // GetIndexes returns index metadata for the named table.
 func (db *Database) GetIndexes(table string) ([]IndexInfo, error) {
+	if err := db.lock(); err != nil {
+		return nil, err
+	}
+	defer db.unlock()
 	crows, err := db.cdb.Indexes(table)
 	if err != nil {
 		return nil, err
@@ -737,9 +853,43 @@ func (db *Database) GetPageStats() (PageStats, error) {
 
 // BackupTo creates a copy of the database at destPath.
 func (db *Database) BackupTo(destPath string) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
 	return db.cdb.Backup(destPath)
 }
 
+// ── Full-text search ─────────────────────────────────────────────────────────
+
+// Token is a token produced by a tokenizer registered with RegisterTokenizer.
+// Term is the text indexed (and matched against query tokens); Start and End
+// are the byte offsets in the tokenized text that highlight() and snippet()
+// mark. A Colocated token is a synonym at the same position as the token
+// before it: a document containing "first" tokenized as "first" followed by a
+// colocated "1st" matches both 'first' and '1st'.
+type Token struct {
+	Term       string
+	Start, End int
+	Colocated  bool
+}
+
+// RegisterTokenizer makes fn available to FTS5 tables of this database as
+// tokenize='name'. fn tokenizes both documents and MATCH queries; for a query
+// only the first token at each position is used. It is called with the
+// database locked and must not use the database. Registering a name again
+// replaces the tokenizer, also for tables already using it.
+func (db *Database) RegisterTokenizer(name string, fn func(text string) []Token) error {
+	return db.cdb.RegisterTokenizer(name, func(text string) []cgo.Token {
+		toks := fn(text)
+		out := make([]cgo.Token, len(toks))
+		for i, t := range toks {
+			out[i] = cgo.Token{Term: t.Term, Start: t.Start, End: t.End, Colocated: t.Colocated}
+		}
+		return out
+	})
+}
+
 // ── StatementPool ────────────────────────────────────────────────────────────
 
 // StatementPool manages a pool of prepared statements with LRU eviction.
@@ -878,98 +1028,152 @@ func (db *Database) ExportCSV(w io.Writer, sql string, opts CSVExportOptions) er
 	return cw.Error()
 }
 
-// CSVImportOptions controls how ImportCSV behaves.
-type CSVImportOptions struct {
-	HasHeader   bool   // first row contains column names
-	Comma       rune   // field delimiter (default: ',')
-	CreateTable bool   // CREATE TABLE IF NOT EXISTS before importing
-	NullString  string // string value treated as NULL (default: "")
-}
+// JSONFormat selects the layout ExportJSON writes.
+type JSONFormat int
 
-// ImportCSV reads CSV data from r and inserts rows into tableName.
-// Returns the number of rows inserted.
-func (db *Database) ImportCSV(tableName string, r io.Reader, opts CSVImportOptions) (int, error) {
-	if opts.Comma == 0 {
-		opts.Comma = ','
-	}
-	cr := csv.NewReader(r)
-	cr.Comma = opts.Comma
-	cr.TrimLeadingSpace = true
+const (
+	// JSONFormatObjects is an array holding an object per row, its members
+	// in column order.
+	JSONFormatObjects JSONFormat = iota
+	// JSONFormatLines is NDJSON: an object per row, each on its own line.
+	JSONFormatLines
+	// JSONFormatArrays is an array of arrays: the column names, then the
+	// values of each row.
+	JSONFormatArrays
+)
 
-	var cols []string
-	if opts.HasHeader {
-		header, err := cr.Read()
-		if err == io.EOF {
-			return 0, nil
-		}
-		if err != nil {
-			return 0, fmt.Errorf("ImportCSV: reading header: %w", err)
-		}
-		cols = make([]string, len(header))
-		for i, h := range header {
-			cols[i] = strings.TrimSpace(h)
-		}
+// JSONExportOptions controls how ExportJSON behaves.
+type JSONExportOptions struct {
+	Format JSONFormat
+	// ParseJSON writes the text of columns declared JSON, and of those named
+	// in JSONColumns, as the value it holds rather than as a string. Text
+	// that is not valid JSON is still written as a string.
+	ParseJSON   bool
+	JSONColumns []string
+}
+
+// ExportJSON executes sql and writes the result to w as JSON. NULL is
+// written as null, numbers as numbers (null if not finite), text as strings
+// and BLOBs as base64 strings. The whole result is read into memory before
+// the first row is written, in every format including NDJSON, so a large
+// result needs memory in proportion to its size; Dump reads a table a batch
+// at a time instead.
+func (db *Database) ExportJSON(w io.Writer, sql string, opts JSONExportOptions) error {
+	rows, err := db.Query(sql)
+	if err != nil {
+		return fmt.Errorf("ExportJSON: query: %w", err)
 	}
-
-	count := 0
-	for {
-		record, rerr := cr.Read()
-		if rerr == io.EOF {
-			break
-		}
-		if rerr != nil {
-			return count, fmt.Errorf("ImportCSV: reading row %d: %w", count+1, rerr)
-		}
-		if cols == nil {
-			cols = make([]string, len(record))
-			for i := range record {
-				cols[i] = fmt.Sprintf("c%d", i)
+	parse := make([]bool, len(rows.Columns))
+	if opts.ParseJSON {
+		for i, ct := range rows.ColumnTypes() {
+			parse[i] = ct.DatabaseTypeName() == "JSON"
+			for _, c := range opts.JSONColumns {
+				parse[i] = parse[i] || strings.EqualFold(c, ct.Name)
 			}
 		}
-		if err := db.insertCSVRow(tableName, cols, record, opts.NullString); err != nil {
-			return count, err
-		}
-		count++
 	}
-	return count, nil
-}
-
-func (db *Database) insertCSVRow(table string, cols []string, record []string, nullStr string) error {
-	vals := make([]string, len(cols))
-	for i, v := range record {
-		if v == nullStr {
-			vals[i] = "NULL"
-		} else {
-			escaped := strings.ReplaceAll(v, "'", "''")
-			vals[i] = "'" + escaped + "'"
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	str := func(s string) {
+		enc.Encode(s)
+		buf.Truncate(buf.Len() - 1) // Encode's newline
+	}
+	lines := opts.Format == JSONFormatLines
+	items := 0
+	item := func() { // starts an element of the outer array, or a line
+		switch {
+		case lines:
+		case items == 0:
+			buf.WriteByte('\n')
+		default:
+			buf.WriteString(",\n")
 		}
+		items++
 	}
-	sql := "INSERT INTO " + table + " (" + strings.Join(cols, ", ") + ") VALUES (" + strings.Join(vals, ", ") + ")"
-	_, err := db.Exec(sql)
-	return err
-}
-
-// ExportJSON executes sql and writes the result as a JSON array of objects to w.
-func (db *Database) ExportJSON(w io.Writer, sql string) error {
-	rows, err := db.Query(sql)
-	if err != nil {
-		return fmt.Errorf("ExportJSON: query: %w", err)
+	if !lines {
+		buf.WriteByte('[')
+	}
+	if opts.Format == JSONFormatArrays {
+		item()
+		buf.WriteByte('[')
+		for i, col := range rows.Columns {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			str(col)
+		}
+		buf.WriteByte(']')
 	}
-	enc := json.NewEncoder(w)
-	enc.SetEscapeHTML(false)
-	objects := make([]map[string]interface{}, 0, len(rows.Data))
 	for _, row := range rows.Data {
-		obj := make(map[string]interface{}, len(rows.Columns))
+		item()
+		if opts.Format == JSONFormatArrays {
+			buf.WriteByte('[')
+		} else {
+			buf.WriteByte('{')
+		}
 		for i, col := range rows.Columns {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if opts.Format != JSONFormatArrays {
+				str(col)
+				buf.WriteByte(':')
+			}
+			var v interface{}
 			if i < len(row) {
-				obj[col] = row[i]
-			} else {
-				obj[col] = nil
+				v = row[i]
+			}
+			switch v := v.(type) {
+			case nil:
+				buf.WriteString("null")
+			case int64:
+				buf.WriteString(strconv.FormatInt(v, 10))
+			case float64:
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					buf.WriteString("null")
+				} else {
+					buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+				}
+			case []byte:
+				buf.WriteByte('"')
+				buf.WriteString(base64.StdEncoding.EncodeToString(v))
+				buf.WriteByte('"')
+			case string:
+				if parse[i] && json.Valid([]byte(v)) {
+					json.Compact(&buf, []byte(v))
+				} else {
+					str(v)
+				}
+			default:
+				str(valueToString(v))
+			}
+		}
+		if opts.Format == JSONFormatArrays {
+			buf.WriteByte(']')
+		} else {
+			buf.WriteByte('}')
+		}
+		if lines {
+			buf.WriteByte('\n')
+		}
+		if buf.Len() >= 32<<10 {
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return fmt.Errorf("ExportJSON: %w", err)
 			}
+			buf.Reset()
 		}
-		objects = append(objects, obj)
 	}
-	return enc.Encode(objects)
+	if !lines {
+		if items > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString("]\n")
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("ExportJSON: %w", err)
+	}
+	return nil
 }
 
 // ── SQL Dump ─────────────────────────────────────────────────────────────────
@@ -979,47 +1183,412 @@ type DumpOptions struct {
 	DataOnly   bool // only output INSERT statements, no schema
 	SchemaOnly bool // only output CREATE statements, no data
 	UseInserts bool // always true for Dump
+	// Tables limits the dump to these tables, with their indexes and
+	// triggers, and views; names match case-insensitively. Empty dumps all.
+	Tables []string
+}
+
+// dumpObject is a row of sqlite_master.
+type dumpObject struct {
+	typ, name, table, sql string
 }
 
-// Dump writes an SQL dump of the entire database to w.
+// Dump writes an SQL script that recreates the database to w, as
+// ExecScript runs it: in one transaction with foreign keys off, each table
+// and its rows, then the AUTOINCREMENT counters, indexes, views (each after
+// the views it reads) and triggers. Identifiers are quoted, a REAL keeps its
+// precision and a BLOB is written as a hex literal, X'...'.
 func (db *Database) Dump(w io.Writer, opts DumpOptions) error {
 	if opts.DataOnly && opts.SchemaOnly {
 		return fmt.Errorf("Dump: DataOnly and SchemaOnly cannot both be set")
 	}
-	tables, err := db.GetTables()
+	objs, err := db.dumpObjects(opts.Tables)
 	if err != nil {
-		return fmt.Errorf("Dump: list tables: %w", err)
+		return fmt.Errorf("Dump: %w", err)
+	}
+	var seqs [][]interface{}
+	if !opts.SchemaOnly {
+		rows, err := db.Query("PRAGMA sqlite_sequence")
+		if err != nil {
+			return fmt.Errorf("Dump: %w", err)
+		}
+		seqs = rows.Data
 	}
-	for _, t := range tables {
+
+	bw := bufio.NewWriterSize(w, 32<<10)
+	bw.WriteString("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+	dumped := make(map[string]bool)
+	for _, o := range objs {
+		if o.typ != "table" {
+			continue
+		}
 		if !opts.DataOnly {
-			sql := t.SQL
-			if sql == "" {
-				// Fall back to reconstructing from PRAGMA
-				schema, _ := db.GetSchema(t.Name)
-				sql = schema
+			writeDumpSQL(bw, o.sql)
+		}
+		if !opts.SchemaOnly {
+			if err := db.dumpRows(bw, o.name); err != nil {
+				return fmt.Errorf("Dump: %s: %w", o.name, err)
+			}
+		}
+		dumped[strings.ToLower(o.name)] = true
+	}
+	if len(seqs) > 0 {
+		var entries []string
+		for _, row := range seqs {
+			if name, _ := row[0].(string); dumped[strings.ToLower(name)] {
+				entries = append(entries, "INSERT INTO sqlite_sequence VALUES("+
+					dumpLiteral(name)+","+dumpLiteral(row[1])+");\n")
+			}
+		}
+		if len(entries) > 0 {
+			sort.Strings(entries)
+			bw.WriteString("DELETE FROM sqlite_sequence;\n")
+			for _, e := range entries {
+				bw.WriteString(e)
 			}
-			if sql != "" {
-				if _, err2 := fmt.Fprintf(w, "%s;\n", sql); err2 != nil {
-					return err2
+		}
+	}
+	if !opts.DataOnly {
+		for _, typ := range []string{"index", "view", "trigger"} {
+			for _, o := range objs {
+				if o.typ == typ {
+					writeDumpSQL(bw, o.sql)
 				}
 			}
 		}
-		if !opts.SchemaOnly && t.Type == "table" {
-			rows, qerr := db.Query("SELECT * FROM " + t.Name)
-			if qerr != nil {
+	}
+	bw.WriteString("COMMIT;\n")
+	return bw.Flush()
+}
+
+// dumpObjects returns the objects of sqlite_master that Dump writes, those
+// of the tables and views named in filter if it is not empty: tables,
+// indexes and triggers by name, views in dependency order, each after the
+// views named in its SQL.
+func (db *Database) dumpObjects(filter []string) ([]dumpObject, error) {
+	rows, err := db.Query("SELECT type, name, tbl_name, sql FROM sqlite_master")
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool, len(filter))
+	for _, name := range filter {
+		want[strings.ToLower(name)] = true
+	}
+	found := make(map[string]bool, len(filter))
+	var objs []dumpObject
+	for _, row := range rows.Data {
+		var o dumpObject
+		o.typ, _ = row[0].(string)
+		o.name, _ = row[1].(string)
+		o.table, _ = row[2].(string)
+		o.sql, _ = row[3].(string)
+		if strings.HasPrefix(strings.ToLower(o.name), "sqlite_") {
+			continue
+		}
+		if len(want) > 0 {
+			key := strings.ToLower(o.table)
+			if o.typ == "view" {
+				key = strings.ToLower(o.name)
+			}
+			if !want[key] {
 				continue
 			}
-			for _, row := range rows.Data {
-				vals := make([]string, len(row))
-				for i, v := range row {
-					vals[i] = formatSQLLiteral(v)
+			found[key] = true
+		}
+		if o.sql == "" && o.typ == "table" {
+			o.sql, _ = db.GetSchema(quoteIdent(o.name))
+		}
+		if o.sql == "" {
+			continue
+		}
+		objs = append(objs, o)
+	}
+	for _, name := range filter {
+		if !found[strings.ToLower(name)] {
+			return nil, fmt.Errorf("no such table: %s", name)
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].name < objs[j].name })
+
+	// Views in dependency order, by depth-first search
+	views := make(map[string]dumpObject)
+	for _, o := range objs {
+		if o.typ == "view" {
+			views[strings.ToLower(o.name)] = o
+		}
+	}
+	placed := make(map[string]bool, len(views))
+	var ordered []dumpObject
+	var place func(o dumpObject)
+	place = func(o dumpObject) {
+		key := strings.ToLower(o.name)
+		if placed[key] {
+			return
+		}
+		placed[key] = true
+		for _, word := range sqlWords(o.sql) {
+			if v, ok := views[word]; ok {
+				place(v)
+			}
+		}
+		ordered = append(ordered, o)
+	}
+	out := objs[:0]
+	for _, o := range objs {
+		if o.typ == "view" {
+			place(o)
+		} else {
+			out = append(out, o)
+		}
+	}
+	return append(out, ordered...), nil
+}
+
+// sqlWords returns the identifiers and keywords of sql, quoted or not, in
+// lower case, skipping string literals.
+func sqlWords(sql string) []string {
+	var words []string
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'':
+			i++
+			for i < len(sql) && sql[i] != '\'' {
+				i++
+			}
+			i++
+		case c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := i + 1
+			for j < len(sql) && sql[j] != end {
+				j++
+			}
+			words = append(words, strings.ToLower(sql[i+1:j]))
+			i = j + 1
+		case isParamIdentByte(c) || c >= 0x80:
+			j := i
+			for j < len(sql) && (isParamIdentByte(sql[j]) || sql[j] >= 0x80) {
+				j++
+			}
+			words = append(words, strings.ToLower(sql[i:j]))
+			i = j
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+// writeDumpSQL writes a CREATE statement of sqlite_master with a semicolon.
+func writeDumpSQL(w *bufio.Writer, sql string) {
+	w.WriteString(strings.TrimRight(strings.TrimSpace(sql), ";"))
+	w.WriteString(";\n")
+}
+
+// dumpBatch is how many rows dumpRows reads from the engine at a time.
+const dumpBatch = 1000
+
+// dumpRows writes an INSERT with a column list for each row of table. It
+// reads the rows dumpBatch at a time, so a large table is never held in
+// memory whole, and fails if they change between batches.
+func (db *Database) dumpRows(w *bufio.Writer, table string) error {
+	var prefix string
+	var first uint64
+	for start := int64(0); ; start += dumpBatch {
+		batch, gen, err := db.scanTable(table, start, dumpBatch)
+		if err != nil {
+			return err
+		}
+		if start == 0 {
+			first = gen
+			cols := make([]string, len(batch.Columns))
+			for i, c := range batch.Columns {
+				cols[i] = quoteIdent(c)
+			}
+			prefix = "INSERT INTO " + quoteIdent(table) + "(" + strings.Join(cols, ",") + ") VALUES("
+		} else if gen != first {
+			return fmt.Errorf("rows changed while dumping")
+		}
+		for _, row := range batch.Data {
+			w.WriteString(prefix)
+			for i, v := range row {
+				if i > 0 {
+					w.WriteByte(',')
 				}
-				line := fmt.Sprintf("INSERT INTO %s VALUES (%s);\n", t.Name, strings.Join(vals, ", "))
-				if _, werr := fmt.Fprint(w, line); werr != nil {
-					return werr
+				w.WriteString(dumpLiteral(v))
+			}
+			if _, err := w.WriteString(");\n"); err != nil {
+				return err
+			}
+		}
+		if len(batch.Data) < dumpBatch {
+			return nil
+		}
+	}
+}
+
+// scanTable reads up to max rows of table from position start on, as
+// SELECT * returns them, with the engine's count of row changes.
+func (db *Database) scanTable(table string, start int64, max int) (*Rows, uint64, error) {
+	if err := db.lock(); err != nil {
+		return nil, 0, err
+	}
+	defer db.unlock()
+	crows, gen, err := db.cdb.TableScan(table, start, max)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer crows.Close()
+	n := crows.ColumnCount()
+	rows := &Rows{Columns: make([]string, n)}
+	for i := range rows.Columns {
+		rows.Columns[i] = crows.ColumnName(i)
+	}
+	for crows.Next() {
+		row := make([]interface{}, n)
+		for i := range row {
+			row[i] = crows.Get(i)
+		}
+		rows.Data = append(rows.Data, row)
+	}
+	return rows, gen, nil
+}
+
+// dumpLiteral is formatSQLLiteral as Dump writes values: a REAL keeps a
+// decimal point or exponent so that it reloads as REAL, an infinity is
+// 1e999 or -1e999, NaN is NULL and a BLOB is X'...' in upper-case hex.
+func dumpLiteral(v interface{}) string {
+	switch val := v.(type) {
+	case float64:
+		switch {
+		case math.IsNaN(val):
+			return "NULL"
+		case math.IsInf(val, 1):
+			return "1e999"
+		case math.IsInf(val, -1):
+			return "-1e999"
+		}
+		s := strconv.FormatFloat(val, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case []byte:
+		return "X'" + strings.ToUpper(hex.EncodeToString(val)) + "'"
+	}
+	return formatSQLLiteral(v)
+}
+
+// ExecScript runs the SQL statements read from r in order, as Dump writes
+// them, and stops at the first that fails. A statement ends at a semicolon
+// outside quotes and comments, or in CREATE TRIGGER at one after END.
+func (db *Database) ExecScript(r io.Reader) error {
+	sc := scriptScanner{br: bufio.NewReaderSize(r, 64<<10)}
+	for n := 1; ; n++ {
+		stmt, err := sc.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("ExecScript: %w", err)
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("ExecScript: statement %d: %w", n, err)
+		}
+	}
+}
+
+// scriptScanner splits an SQL script into statements, dropping comments.
+type scriptScanner struct {
+	br *bufio.Reader
+}
+
+// next returns the next statement without its semicolon, or io.EOF.
+func (sc *scriptScanner) next() (string, error) {
+	var sb strings.Builder
+	var quote byte // the byte closing the literal or identifier being read
+	for {
+		c, err := sc.br.ReadByte()
+		if err == io.EOF {
+			if stmt := strings.TrimSpace(sb.String()); stmt != "" {
+				return stmt, nil
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			sb.WriteByte(c)
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '[':
+			quote = ']'
+		case '-', '/':
+			if next, _ := sc.br.Peek(1); len(next) == 1 && (c == '-' && next[0] == '-' || c == '/' && next[0] == '*') {
+				if err := sc.skipComment(c); err != nil {
+					return "", err
 				}
+				sb.WriteByte(' ')
+				continue
+			}
+		case ';':
+			stmt := strings.TrimSpace(sb.String())
+			if stmt == "" {
+				continue
+			}
+			if !isCreateTrigger(stmt) || endsWithEnd(stmt) {
+				return stmt, nil
 			}
 		}
+		sb.WriteByte(c)
 	}
-	return nil
+}
+
+// skipComment reads the rest of a comment whose first byte c was read: a
+// line for "--", up to "*/" for "/*".
+func (sc *scriptScanner) skipComment(c byte) error {
+	if c == '-' {
+		_, err := sc.br.ReadString('\n')
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	sc.br.ReadByte() // the '*'
+	var prev byte
+	for {
+		b, err := sc.br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("unterminated comment")
+		}
+		if prev == '*' && b == '/' {
+			return nil
+		}
+		prev = b
+	}
+}
+
+// isCreateTrigger reports whether stmt is CREATE [TEMP] TRIGGER.
+func isCreateTrigger(stmt string) bool {
+	f := strings.Fields(strings.ToUpper(stmt))
+	if len(f) > 2 && (f[1] == "TEMP" || f[1] == "TEMPORARY") {
+		f = append(f[:1], f[2:]...)
+	}
+	return len(f) > 1 && f[0] == "CREATE" && f[1] == "TRIGGER"
+}
+
+// endsWithEnd reports whether the last word of stmt is END.
+func endsWithEnd(stmt string) bool {
+	n := len(stmt)
+	return n >= 3 && strings.EqualFold(stmt[n-3:], "END") && (n == 3 || !isParamIdentByte(stmt[n-4]))
 }
@@ -1210,6 +1210,51 @@ func TestInsertMultipleRows(t *testing.T) {
 	}
 }
 
+func TestInsertValuesLiteralsAndExpressions(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	db.Exec("CREATE TABLE test (s TEXT, n INTEGER, b BLOB, e, c TEXT)")
+	for _, sql := range []string{
+		`INSERT INTO test VALUES ('it''s', -5, x'00ff', 1 + 2, 'a' || 'b')`,
+		`INSERT INTO test VALUES ('(x), y', -2.5e3, NULL, -3 * 2, upper('q'))`,
+		`INSERT INTO test VALUES ('''', '7' + 1, X'', '-', 'a''b' || 'c')`,
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	rows, err := db.Query("SELECT s, n, ifnull(hex(b), ''), typeof(b), e, c FROM test ORDER BY rowid")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	expected := []string{
+		"[it's -5 00FF blob 3 ab]",
+		"[(x), y -2500  null -6 Q]",
+		"[' 8  blob - a'bc]",
+	}
+	if len(rows.Data) != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), len(rows.Data))
+	}
+	for i, row := range rows.Data {
+		if got := fmt.Sprint(row); got != expected[i] {
+			t.Errorf("row %d = %s, want %s", i, got, expected[i])
+		}
+	}
+
+	// Column defaults are read by the same value parser
+	db.Exec("CREATE TABLE d (a TEXT DEFAULT 'x' NOT NULL, b INT DEFAULT -1)")
+	db.Exec("INSERT INTO d DEFAULT VALUES")
+	rows, err = db.Query("SELECT a, b FROM d")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	if len(rows.Data) != 1 || fmt.Sprint(rows.Data[0]) != "[x -1]" {
+		t.Errorf("defaults = %v, want [[x -1]]", rows.Data)
+	}
+}
+
 // ---- UPDATE tests ----
 
 func TestUpdate(t *testing.T) {
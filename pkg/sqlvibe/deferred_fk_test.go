@@ -0,0 +1,218 @@
+package sqlvibe
+
+import (
+	"strings"
+	"testing"
+)
+
+func isFKError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "FOREIGN KEY constraint failed")
+}
+
+func TestDeferredForeignKeyCycle(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	execOK(t, db,
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE dept (id INTEGER PRIMARY KEY, head INTEGER REFERENCES emp(id) DEFERRABLE INITIALLY DEFERRED)",
+		"CREATE TABLE emp (id INTEGER PRIMARY KEY, dept INTEGER REFERENCES dept(id) DEFERRABLE INITIALLY DEFERRED)",
+	)
+	// Outside a transaction the check still happens at once
+	if _, err := db.Exec("INSERT INTO emp VALUES (1, 10)"); !isFKError(err) {
+		t.Fatalf("expected FK error in autocommit mode, got %v", err)
+	}
+
+	execOK(t, db,
+		"BEGIN",
+		"INSERT INTO emp VALUES (1, 10)",
+		"INSERT INTO dept VALUES (10, 1)",
+		"COMMIT",
+	)
+	if n := queryAll(t, db, "SELECT count(*) FROM emp"); n != "1" {
+		t.Fatalf("expected 1 emp row, got %s", n)
+	}
+
+	execOK(t, db, "BEGIN", "INSERT INTO emp VALUES (2, 20)", "INSERT INTO dept VALUES (30, NULL)")
+	if _, err := db.Exec("COMMIT"); !isFKError(err) {
+		t.Fatalf("expected COMMIT to fail, got %v", err)
+	}
+	if n := queryAll(t, db, "SELECT count(*) FROM emp"); n != "1" {
+		t.Errorf("expected the failed transaction to be rolled back, got %s emp rows", n)
+	}
+	if n := queryAll(t, db, "SELECT count(*) FROM dept"); n != "1" {
+		t.Errorf("expected the failed transaction to be rolled back, got %s dept rows", n)
+	}
+
+	// Deleting a parent is deferred the same way
+	execOK(t, db, "BEGIN", "DELETE FROM dept WHERE id = 10")
+	if _, err := db.Exec("COMMIT"); !isFKError(err) {
+		t.Fatalf("expected COMMIT after orphaning delete to fail, got %v", err)
+	}
+	if n := queryAll(t, db, "SELECT count(*) FROM dept"); n != "1" {
+		t.Errorf("expected dept row to be restored, got %s", n)
+	}
+}
+
+func TestDeferForeignKeysPragma(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	execOK(t, db,
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE p (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE c (pid INTEGER REFERENCES p(id) ON DELETE RESTRICT)",
+		"BEGIN",
+		"PRAGMA defer_foreign_keys = ON",
+		"INSERT INTO c VALUES (1)",
+		"INSERT INTO p VALUES (1)",
+		"COMMIT",
+	)
+	rows, _ := db.Query("PRAGMA defer_foreign_keys")
+	if len(rows.Data) != 1 || rows.Data[0][0] != int64(0) {
+		t.Errorf("expected defer_foreign_keys to be cleared by COMMIT, got %v", rows.Data)
+	}
+
+	// RESTRICT is never deferred
+	execOK(t, db, "BEGIN", "PRAGMA defer_foreign_keys = ON")
+	if _, err := db.Exec("DELETE FROM p WHERE id = 1"); !isFKError(err) {
+		t.Errorf("expected RESTRICT to fail at once, got %v", err)
+	}
+	execOK(t, db, "ROLLBACK")
+}
+
+func TestSetConstraints(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	execOK(t, db,
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE p (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE c (pid INTEGER REFERENCES p DEFERRABLE)",
+		"CREATE TABLE fixed (pid INTEGER REFERENCES p(id))",
+		"BEGIN",
+		"SET CONSTRAINTS ALL DEFERRED",
+		"INSERT INTO c VALUES (1)",
+	)
+	// Only DEFERRABLE constraints are affected
+	if _, err := db.Exec("INSERT INTO fixed VALUES (1)"); !isFKError(err) {
+		t.Errorf("expected NOT DEFERRABLE FK to fail at once, got %v", err)
+	}
+	// Switching back to IMMEDIATE checks the pending violations
+	if _, err := db.Exec("SET CONSTRAINTS ALL IMMEDIATE"); !isFKError(err) {
+		t.Errorf("expected SET CONSTRAINTS ALL IMMEDIATE to fail, got %v", err)
+	}
+	execOK(t, db, "INSERT INTO p VALUES (1)", "SET CONSTRAINTS ALL IMMEDIATE", "COMMIT")
+
+	if _, err := db.Exec("SET CONSTRAINTS c_fk DEFERRED"); err == nil {
+		t.Error("expected an error for a named constraint")
+	}
+}
+
+func TestSetConstraintsIgnoresNotDeferrable(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	execOK(t, db,
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE p (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE a (pid INTEGER REFERENCES p(id))",
+		"CREATE TABLE b (pid INTEGER REFERENCES p(id) NOT DEFERRABLE INITIALLY DEFERRED)",
+		"BEGIN",
+	)
+	// The statement is accepted, but leaves NOT DEFERRABLE keys immediate
+	if _, err := db.Exec("SET CONSTRAINTS ALL DEFERRED"); err != nil {
+		t.Fatalf("SET CONSTRAINTS ALL DEFERRED: %v", err)
+	}
+	for _, sql := range []string{"INSERT INTO a VALUES (1)", "INSERT INTO b VALUES (1)"} {
+		if _, err := db.Exec(sql); !isFKError(err) {
+			t.Errorf("%s: expected an immediate FK error, got %v", sql, err)
+		}
+	}
+	execOK(t, db, "COMMIT")
+	if got := queryAll(t, db, "SELECT (SELECT count(*) FROM a) + (SELECT count(*) FROM b)"); got != "0" {
+		t.Errorf("rejected rows were kept: %s", got)
+	}
+}
+
+func TestDeferredForeignKeyTransactionAPI(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	execOK(t, db,
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE p (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE c (pid INTEGER REFERENCES p(id) DEFERRABLE INITIALLY DEFERRED)",
+	)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO c VALUES (7)"); err != nil {
+		t.Fatalf("deferred insert: %v", err)
+	}
+	if err := tx.Commit(); !isFKError(err) {
+		t.Fatalf("expected Commit to fail, got %v", err)
+	}
+	if n := queryAll(t, db, "SELECT count(*) FROM c"); n != "0" {
+		t.Errorf("expected rollback after failed Commit, got %s rows", n)
+	}
+}
+
+func TestForeignKeyCheckPragma(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	execOK(t, db,
+		"CREATE TABLE p (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE c (a INTEGER REFERENCES p(id), b INTEGER REFERENCES p)",
+		"INSERT INTO p VALUES (1)",
+		"INSERT INTO c VALUES (1, NULL), (2, 1), (1, 3)",
+	)
+	rows, err := db.Query("PRAGMA foreign_key_check(c)")
+	if err != nil {
+		t.Fatalf("PRAGMA foreign_key_check: %v", err)
+	}
+	want := [][]interface{}{{"c", int64(2), "p", int64(0)}, {"c", int64(3), "p", int64(1)}}
+	if len(rows.Data) != len(want) {
+		t.Fatalf("expected %v, got %v", want, rows.Data)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if rows.Data[i][j] != want[i][j] {
+				t.Errorf("row %d: expected %v, got %v", i, want[i], rows.Data[i])
+				break
+			}
+		}
+	}
+}
+
+func TestFailedCommitResetsCaches(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	execOK(t, db,
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE p (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE c (id INTEGER, email TEXT, p INTEGER REFERENCES p(id) DEFERRABLE INITIALLY DEFERRED)",
+		"CREATE UNIQUE INDEX c_email ON c(email) WHERE id > 0",
+		"CREATE TABLE m (qty INTEGER) USING COLUMNAR",
+		"INSERT INTO m VALUES (10)",
+		// Fill the key counts and the column image before the transaction
+		"INSERT INTO c VALUES (1, 'b', NULL)",
+	)
+	if got := queryAll(t, db, "SELECT sum(qty), count(*) FROM m"); got != "10,1" {
+		t.Fatalf("unexpected columnar rows: %s", got)
+	}
+
+	execOK(t, db, "BEGIN", "INSERT INTO m VALUES (20)", "INSERT INTO c VALUES (2, 'a', 99)")
+	if _, err := db.Exec("COMMIT"); !isFKError(err) {
+		t.Fatalf("expected COMMIT to fail, got %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO c VALUES (3, 'a', NULL)"); err != nil {
+		t.Errorf("insert after the failed COMMIT: %v", err)
+	}
+	if got := queryAll(t, db, "SELECT sum(qty), count(*) FROM m"); got != "10,1" {
+		t.Errorf("columnar table kept rolled-back rows: %s", got)
+	}
+}
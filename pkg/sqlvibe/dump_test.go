@@ -0,0 +1,172 @@
+package sqlvibe
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func dumpString(t *testing.T, db *Database, opts DumpOptions) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := db.Dump(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		`CREATE TABLE "order" (id INTEGER PRIMARY KEY AUTOINCREMENT, "Mixed Case" TEXT, r REAL, b BLOB, u)`,
+		`CREATE TABLE child (id INTEGER PRIMARY KEY, oid INTEGER REFERENCES "order"(id))`,
+		`CREATE INDEX idx_r ON "order"(r)`,
+		`CREATE UNIQUE INDEX "idx Mixed" ON "order"("Mixed Case") WHERE r > 0`,
+		`CREATE VIEW v2 AS SELECT id FROM v1 WHERE r > 0`,
+		`CREATE VIEW v1 AS SELECT id, r FROM "order"`,
+		`CREATE TRIGGER tr AFTER INSERT ON "order" BEGIN
+  INSERT INTO child (oid) VALUES (new.id);
+END`,
+		`INSERT INTO "order" ("Mixed Case", r, b, u) VALUES ('it''s; a
+line', 0.1, X'00ff', 2.0)`,
+		`INSERT INTO "order" ("Mixed Case", r, b, u) VALUES (NULL, 1e300, NULL, 'x')`,
+		`INSERT INTO "order" ("Mixed Case", r, b, u) VALUES ('c', -2.5e-10, X'', 3)`,
+		`DELETE FROM "order" WHERE id = 3`)
+
+	dump := dumpString(t, db, DumpOptions{})
+	for _, want := range []string{
+		"PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\nCREATE TABLE child",
+		`INSERT INTO "order"("id","Mixed Case","r","b","u") VALUES(1,'it''s; a` + "\nline',0.1,X'00FF',2.0);\n",
+		`INSERT INTO "order"("id","Mixed Case","r","b","u") VALUES(2,NULL,1e+300,NULL,'x');` + "\n",
+		"DELETE FROM sqlite_sequence;\nINSERT INTO sqlite_sequence VALUES('order',3);\nCREATE UNIQUE INDEX",
+		"CREATE VIEW v1 AS SELECT id, r FROM \"order\";\nCREATE VIEW v2",
+		"\nEND;\nCOMMIT;\n",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump lacks %q:\n%s", want, dump)
+		}
+	}
+
+	dst, _ := Open(":memory:")
+	defer dst.Close()
+	if err := dst.ExecScript(strings.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if got := dumpString(t, dst, DumpOptions{}); got != dump {
+		t.Errorf("dump of the restored database:\n%s\nwant:\n%s", got, dump)
+	}
+	got := queryAll(t, dst, `SELECT id, typeof(r), r = 0.1, hex(b), typeof(u) FROM "order" ORDER BY id`)
+	if want := "1,real,1,00FF,real|2,real,0,<nil>,text"; got != want {
+		t.Errorf("rows: %s, want %s", got, want)
+	}
+	if got := queryAll(t, dst, "SELECT count(*) FROM v2"); got != "2" {
+		t.Errorf("view: %s", got)
+	}
+	execOK(t, dst, `INSERT INTO "order" ("Mixed Case") VALUES ('new')`)
+	if got := queryAll(t, dst, `SELECT count(*), max(oid) FROM child`); got != "4,4" {
+		t.Errorf("trigger and sequence after restore: %s", got)
+	}
+}
+
+func TestDumpOptions(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		`CREATE TABLE "order" (id INTEGER PRIMARY KEY AUTOINCREMENT, "Mixed Case" TEXT, r REAL, b BLOB, u)`,
+		`CREATE TABLE child (id INTEGER PRIMARY KEY, oid INTEGER REFERENCES "order"(id))`,
+		`CREATE INDEX idx_r ON "order"(r)`,
+		`CREATE UNIQUE INDEX "idx Mixed" ON "order"("Mixed Case") WHERE r > 0`,
+		`CREATE VIEW v2 AS SELECT id FROM v1 WHERE r > 0`,
+		`CREATE VIEW v1 AS SELECT id, r FROM "order"`,
+		`CREATE TRIGGER tr AFTER INSERT ON "order" BEGIN
+  INSERT INTO child (oid) VALUES (new.id);
+END`,
+		`INSERT INTO "order" ("Mixed Case", r, b, u) VALUES ('it''s; a
+line', 0.1, X'00ff', 2.0)`,
+		`INSERT INTO "order" ("Mixed Case", r, b, u) VALUES (NULL, 1e300, NULL, 'x')`,
+		`INSERT INTO "order" ("Mixed Case", r, b, u) VALUES ('c', -2.5e-10, X'', 3)`,
+		`DELETE FROM "order" WHERE id = 3`)
+
+	schema := dumpString(t, db, DumpOptions{SchemaOnly: true})
+	if strings.Contains(schema, "INSERT INTO \"order\"") || strings.Contains(schema, "sqlite_sequence") {
+		t.Errorf("schema-only dump has data:\n%s", schema)
+	}
+	data := dumpString(t, db, DumpOptions{DataOnly: true})
+	if strings.Contains(data, "CREATE") || !strings.Contains(data, "INSERT INTO sqlite_sequence") {
+		t.Errorf("data-only dump:\n%s", data)
+	}
+
+	filtered := dumpString(t, db, DumpOptions{Tables: []string{"ORDER", "v1"}})
+	for _, want := range []string{`CREATE TABLE "order"`, "idx_r", `"idx Mixed"`, "CREATE VIEW v1", "CREATE TRIGGER tr"} {
+		if !strings.Contains(filtered, want) {
+			t.Errorf("filtered dump lacks %s:\n%s", want, filtered)
+		}
+	}
+	if strings.Contains(filtered, "CREATE TABLE child") || strings.Contains(filtered, "VIEW v2") {
+		t.Errorf("filtered dump has other objects:\n%s", filtered)
+	}
+	if err := db.Dump(&bytes.Buffer{}, DumpOptions{Tables: []string{"missing"}}); err == nil ||
+		!strings.Contains(err.Error(), "no such table: missing") {
+		t.Errorf("missing table: %v", err)
+	}
+}
+
+func TestDumpAfterAlter(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db, "CREATE TABLE t (x INTEGER PRIMARY KEY, y TEXT, gone TEXT, CHECK (x > 0))")
+	for i := 1; i <= 2500; i++ {
+		execOK(t, db, fmt.Sprintf("INSERT INTO t VALUES (%d, 'y%d', NULL)", i, i))
+	}
+	execOK(t, db,
+		"ALTER TABLE t ADD COLUMN z INT DEFAULT 7",
+		"ALTER TABLE t RENAME COLUMN y TO \"why not\"",
+		"ALTER TABLE t DROP COLUMN gone",
+		"ALTER TABLE t ADD CONSTRAINT z_pos CHECK (z > 0)",
+		"ALTER TABLE t RENAME TO u",
+	)
+	if got := queryAll(t, db, "SELECT sql FROM sqlite_master WHERE name = 'u'"); got !=
+		`CREATE TABLE u (x INTEGER PRIMARY KEY, "why not" TEXT, z INT DEFAULT 7, CHECK (x > 0), CONSTRAINT z_pos CHECK (z > 0))` {
+		t.Errorf("CREATE text after ALTER: %s", got)
+	}
+
+	dump := dumpString(t, db, DumpOptions{})
+	if !strings.Contains(dump, `INSERT INTO "u"("x","why not","z") VALUES(2500,'y2500',7);`) {
+		t.Errorf("dump lacks the last row:\n%s", dump[len(dump)-200:])
+	}
+	dst, _ := Open(":memory:")
+	defer dst.Close()
+	if err := dst.ExecScript(strings.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryAll(t, dst, `SELECT count(*), sum(z), max("why not") FROM u`); got != "2500,17500,y999" {
+		t.Errorf("restored rows: %s", got)
+	}
+	if _, err := dst.Exec("INSERT INTO u (x, z) VALUES (9000, 0)"); err == nil {
+		t.Error("restored table lost the added CHECK constraint")
+	}
+}
+
+func TestExecScript(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	script := `-- a comment; with a semicolon
+CREATE TABLE t (a TEXT, b INTEGER, "c;" TEXT); /* another; */
+INSERT INTO t VALUES ('x;--y', 1, NULL);;
+CREATE TRIGGER tr AFTER INSERT ON t BEGIN
+  UPDATE t SET b = b + 1 WHERE b = 1;
+END;
+INSERT INTO t VALUES ('z', 5, 'c')`
+	if err := db.ExecScript(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryAll(t, db, `SELECT a, b, "c;" FROM t ORDER BY a`); got != "x;--y,2,<nil>|z,5,c" {
+		t.Errorf("rows: %s", got)
+	}
+	err := db.ExecScript(strings.NewReader("INSERT INTO t VALUES ('ok', 1, NULL);\nINSERT INTO nope VALUES (1);"))
+	if err == nil || !strings.Contains(err.Error(), "statement 2:") {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,487 @@
+package sqlvibe
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFTS5QuerySyntax(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE VIRTUAL TABLE docs USING fts5(title, body)",
+		"INSERT INTO docs VALUES ('Go tips', 'The quick brown fox jumps over the lazy dog')",
+		"INSERT INTO docs VALUES ('SQLite FTS', 'Full text search with the fts5 module and bm25 ranking')",
+		"INSERT INTO docs VALUES ('Foxes', 'A fox is quick; a fox is brown. Foxes everywhere.')")
+
+	for _, tc := range []struct{ query, want string }{
+		{"fox", "1|3"},
+		{"fox AND lazy", "1"},
+		{"fox lazy", "1"},
+		{"lazy OR search", "1|2"},
+		{"fox NOT lazy", "3"},
+		{`"quick brown"`, "1"},
+		{"quick + brown", "1"},
+		{"fox*", "1|3"},
+		{"title:fox*", "3"},
+		{"{title}: foxes OR search", "2|3"},
+		{"- title : fox", "1|3"},
+		{"^a", "3"},
+		{"NEAR(quick dog, 6)", "1"},
+		{"NEAR(quick dog, 5)", ""},
+		{"(fox OR fts5) NOT brown", "2"},
+	} {
+		sql := "SELECT rowid FROM docs WHERE docs MATCH '" + tc.query + "' ORDER BY rowid"
+		if got := queryAll(t, db, sql); got != tc.want {
+			t.Errorf("MATCH %q: expected %q, got %q", tc.query, tc.want, got)
+		}
+	}
+
+	rows, err := db.Query("SELECT title FROM docs WHERE title MATCH 'go'")
+	if err != nil || len(rows.Data) != 1 || rows.Data[0][0] != "Go tips" {
+		t.Errorf("column MATCH: expected Go tips, got %v (%v)", rows, err)
+	}
+	for _, query := range []string{"AND fox", `"open`, "nosuch:fox"} {
+		if _, err := db.Query("SELECT * FROM docs WHERE docs MATCH '" + query + "'"); err == nil {
+			t.Errorf("MATCH %q: expected an error", query)
+		}
+	}
+}
+
+func TestFTS5IndexMaintenance(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE VIRTUAL TABLE docs USING fts5(title, body)",
+		"INSERT INTO docs VALUES ('Go tips', 'The quick brown fox jumps over the lazy dog')",
+		"INSERT INTO docs VALUES ('SQLite FTS', 'Full text search with the fts5 module and bm25 ranking')",
+		"INSERT INTO docs VALUES ('Foxes', 'A fox is quick; a fox is brown. Foxes everywhere.')")
+
+	execOK(t, db,
+		"UPDATE docs SET body = 'no animals here' WHERE rowid = 1",
+		"DELETE FROM docs WHERE rowid = 3",
+	)
+	if got := queryAll(t, db, "SELECT rowid FROM docs WHERE docs MATCH 'fox' ORDER BY rowid"); got != "" {
+		t.Errorf("expected no fox after update and delete, got %q", got)
+	}
+	if got := queryAll(t, db, "SELECT rowid FROM docs WHERE docs MATCH 'animals' ORDER BY rowid"); got != "1" {
+		t.Errorf("expected updated row to match, got %q", got)
+	}
+
+	execOK(t, db, "BEGIN", "INSERT INTO docs VALUES ('Temp', 'fox again')")
+	if got := queryAll(t, db, "SELECT rowid FROM docs WHERE docs MATCH 'fox' ORDER BY rowid"); got != "4" {
+		t.Errorf("expected uncommitted row to match, got %q", got)
+	}
+	execOK(t, db, "ROLLBACK")
+	if got := queryAll(t, db, "SELECT rowid FROM docs WHERE docs MATCH 'fox' ORDER BY rowid"); got != "" {
+		t.Errorf("expected rolled back row to be gone from the index, got %q", got)
+	}
+}
+
+func TestFTS5RankAndAuxiliaryFunctions(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE VIRTUAL TABLE docs USING fts5(title, body)",
+		"INSERT INTO docs VALUES ('Go tips', 'The quick brown fox jumps over the lazy dog')",
+		"INSERT INTO docs VALUES ('SQLite FTS', 'Full text search with the fts5 module and bm25 ranking')",
+		"INSERT INTO docs VALUES ('Foxes', 'A fox is quick; a fox is brown. Foxes everywhere.')")
+	execOK(t, db, "INSERT INTO docs VALUES ('Cooking', 'Bread, butter and jam')")
+
+	// fox occurs twice in row 3 and once in row 1
+	rows, err := db.Query("SELECT rowid, rank, bm25(docs) FROM docs WHERE docs MATCH 'fox' ORDER BY rank")
+	if err != nil {
+		t.Fatalf("rank query: %v", err)
+	}
+	if len(rows.Data) != 2 || rows.Data[0][0] != int64(3) || rows.Data[1][0] != int64(1) {
+		t.Fatalf("expected rows 3, 1 by rank, got %v", rows.Data)
+	}
+	for _, row := range rows.Data {
+		if r, ok := row[1].(float64); !ok || r >= 0 || row[2] != row[1] {
+			t.Errorf("expected negative rank equal to bm25(), got %v", row)
+		}
+	}
+	// Weighting the title makes the title match win
+	rows, _ = db.Query("SELECT rowid FROM docs WHERE docs MATCH 'fox*' ORDER BY bm25(docs, 100.0, 1.0)")
+	if len(rows.Data) != 2 || rows.Data[0][0] != int64(3) {
+		t.Errorf("expected weighted title match first, got %v", rows.Data)
+	}
+
+	rows, _ = db.Query("SELECT highlight(docs, 1, '[', ']') FROM docs WHERE docs MATCH 'fox' ORDER BY rowid")
+	if len(rows.Data) != 2 ||
+		rows.Data[0][0] != "The quick brown [fox] jumps over the lazy dog" ||
+		rows.Data[1][0] != "A [fox] is quick; a [fox] is brown. Foxes everywhere." {
+		t.Errorf("unexpected highlight: %v", rows.Data)
+	}
+	rows, _ = db.Query(`SELECT highlight(docs, 1, '<b>', '</b>') FROM docs WHERE docs MATCH '"brown fox"'`)
+	if len(rows.Data) != 1 || rows.Data[0][0] != "The quick <b>brown fox</b> jumps over the lazy dog" {
+		t.Errorf("unexpected phrase highlight: %v", rows.Data)
+	}
+	rows, _ = db.Query("SELECT snippet(docs, 1, '[', ']', '...', 4) FROM docs WHERE docs MATCH 'lazy'")
+	if len(rows.Data) != 1 || rows.Data[0][0] != "...over the [lazy] dog" {
+		t.Errorf("unexpected snippet: %v", rows.Data)
+	}
+	if _, err := db.Query("SELECT highlight(docs, 1) FROM docs WHERE docs MATCH 'fox'"); err == nil {
+		t.Error("expected an error for highlight() with too few arguments")
+	}
+}
+
+func TestFTS5TableDefinition(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	execOK(t, db,
+		"CREATE VIRTUAL TABLE notes USING fts5(body, tag UNINDEXED, tokenize = 'porter')",
+		"CREATE VIRTUAL TABLE IF NOT EXISTS notes USING fts5(x)",
+		"INSERT INTO notes VALUES ('running runners ran', 'running')",
+	)
+	rows, _ := db.Query("SELECT tag FROM notes WHERE notes MATCH 'run'")
+	if len(rows.Data) != 1 || rows.Data[0][0] != "running" {
+		t.Errorf("expected porter stemming to match run, got %v", rows.Data)
+	}
+	rows, _ = db.Query("SELECT * FROM notes WHERE notes MATCH 'tag:running'")
+	if len(rows.Data) != 0 {
+		t.Errorf("expected UNINDEXED column not to match, got %v", rows.Data)
+	}
+
+	for _, sql := range []string{
+		"CREATE VIRTUAL TABLE notes USING fts5(x)",
+		"CREATE VIRTUAL TABLE v USING nosuchmodule(x)",
+		"CREATE VIRTUAL TABLE v USING fts5(rank)",
+		"CREATE VIRTUAL TABLE v USING fts5(a, a)",
+		"CREATE VIRTUAL TABLE v USING fts5(a, bogus = 1)",
+		"ALTER TABLE notes ADD COLUMN extra",
+	} {
+		if _, err := db.Exec(sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+
+	execOK(t, db, "DROP TABLE notes", "CREATE VIRTUAL TABLE notes USING fts5(x)")
+}
+
+func TestMatchOnRegularTable(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE t (v TEXT)",
+		"INSERT INTO t VALUES ('Hello world'), ('goodbye')",
+	)
+	rows, _ := db.Query("SELECT v FROM t WHERE v MATCH 'lo wo'")
+	if len(rows.Data) != 1 || rows.Data[0][0] != "Hello world" {
+		t.Errorf("expected substring MATCH on a regular table, got %v", rows.Data)
+	}
+}
+
+func TestFTS5ExternalContent(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT, body TEXT)",
+		"INSERT INTO posts VALUES (10, 'Hello', 'first fox post')",
+		"CREATE VIRTUAL TABLE posts_fts USING fts5(title, body, content='posts', content_rowid='id')",
+		"INSERT INTO posts_fts(posts_fts) VALUES('rebuild')",
+		`CREATE TRIGGER posts_ai AFTER INSERT ON posts BEGIN
+			INSERT INTO posts_fts(rowid, title, body) VALUES (new.id, new.title, new.body);
+		END`,
+		`CREATE TRIGGER posts_ad AFTER DELETE ON posts BEGIN
+			INSERT INTO posts_fts(posts_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+		END`,
+		`CREATE TRIGGER posts_au AFTER UPDATE ON posts BEGIN
+			INSERT INTO posts_fts(posts_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+			INSERT INTO posts_fts(rowid, title, body) VALUES (new.id, new.title, new.body);
+		END`,
+		"INSERT INTO posts VALUES (20, 'Second', 'a lazy dog')",
+		"UPDATE posts SET body = 'a lazy cat' WHERE id = 20",
+		"DELETE FROM posts WHERE id = 10",
+	)
+
+	search := func(query string) [][]interface{} {
+		t.Helper()
+		rows, err := db.Query("SELECT rowid, title, body FROM posts_fts WHERE posts_fts MATCH '" + query + "'")
+		if err != nil {
+			t.Fatalf("MATCH %q: %v", query, err)
+		}
+		return rows.Data
+	}
+	if got := search("fox OR dog"); len(got) != 0 {
+		t.Errorf("expected deleted and updated text to be gone, got %v", got)
+	}
+	if got := search("cat"); len(got) != 1 || got[0][0] != int64(20) || got[0][2] != "a lazy cat" {
+		t.Errorf("expected row 20 read from the content table, got %v", got)
+	}
+	execOK(t, db, "INSERT INTO posts_fts(posts_fts) VALUES('integrity-check')")
+
+	// Without the update trigger the index drifts from the content table
+	execOK(t, db, "DROP TRIGGER posts_au", "UPDATE posts SET body = 'a lazy bird' WHERE id = 20")
+	if _, err := db.Exec("INSERT INTO posts_fts(posts_fts) VALUES('integrity-check')"); err == nil {
+		t.Error("expected integrity-check to report the stale index")
+	}
+	execOK(t, db,
+		"INSERT INTO posts_fts(posts_fts) VALUES('rebuild')",
+		"INSERT INTO posts_fts(posts_fts) VALUES('integrity-check')",
+	)
+	if got := search("bird"); len(got) != 1 || got[0][0] != int64(20) {
+		t.Errorf("expected rebuilt index to find bird, got %v", got)
+	}
+}
+
+func TestFTS5Contentless(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE VIRTUAL TABLE cl USING fts5(a, content='')",
+		"INSERT INTO cl(rowid, a) VALUES (5, 'hidden text')",
+	)
+	rows, _ := db.Query("SELECT rowid, a FROM cl WHERE cl MATCH 'hidden'")
+	if len(rows.Data) != 1 || rows.Data[0][0] != int64(5) || rows.Data[0][1] != nil {
+		t.Errorf("expected rowid 5 with no stored content, got %v", rows.Data)
+	}
+	execOK(t, db, "INSERT INTO cl(cl, rowid, a) VALUES ('delete', 5, 'hidden text')")
+	rows, _ = db.Query("SELECT rowid FROM cl WHERE cl MATCH 'hidden'")
+	if len(rows.Data) != 0 {
+		t.Errorf("expected deleted document to be gone, got %v", rows.Data)
+	}
+	if _, err := db.Exec("INSERT INTO cl(cl) VALUES ('rebuild')"); err == nil {
+		t.Error("expected rebuild of a contentless table to fail")
+	}
+}
+
+func TestFTS5SpecialCommands(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE VIRTUAL TABLE docs USING fts5(title, body)",
+		"INSERT INTO docs VALUES ('Go tips', 'The quick brown fox jumps over the lazy dog')",
+		"INSERT INTO docs VALUES ('SQLite FTS', 'Full text search with the fts5 module and bm25 ranking')",
+		"INSERT INTO docs VALUES ('Foxes', 'A fox is quick; a fox is brown. Foxes everywhere.')")
+
+	execOK(t, db,
+		"INSERT INTO docs(docs) VALUES('optimize')",
+		"INSERT INTO docs(docs) VALUES('rebuild')",
+		"INSERT INTO docs(docs) VALUES('integrity-check')",
+		"INSERT INTO docs(rowid, title, body) VALUES (42, 'Answer', 'fox answer')",
+	)
+	if got := queryAll(t, db, "SELECT rowid FROM docs WHERE docs MATCH 'fox' ORDER BY rowid"); got != "1|3|42" {
+		t.Errorf("expected explicit rowid 42 to be indexed, got %q", got)
+	}
+	for _, sql := range []string{
+		"INSERT INTO docs(docs, rowid, title, body) VALUES ('delete', 1, '', '')",
+		"INSERT INTO docs(docs) VALUES ('bogus')",
+		"INSERT INTO docs(rowid, title) VALUES (42, 'duplicate')",
+	} {
+		if _, err := db.Exec(sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
+
+// camelTokens splits identifiers on case changes, digits and underscores, and
+// adds "database" as a synonym of "db".
+func camelTokens(text string) []Token {
+	var toks []Token
+	emit := func(start, end int) {
+		term := strings.ToLower(text[start:end])
+		toks = append(toks, Token{Term: term, Start: start, End: end})
+		if term == "db" {
+			toks = append(toks, Token{Term: "database", Start: start, End: end, Colocated: true})
+		}
+	}
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		var c byte
+		if i < len(text) {
+			c = text[i]
+		}
+		isUpper := c >= 'A' && c <= 'Z'
+		isWord := isUpper || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+		boundary := !isWord || (isUpper && start >= 0 && text[i-1] >= 'a' && text[i-1] <= 'z')
+		if boundary && start >= 0 {
+			emit(start, i)
+			start = -1
+		}
+		if isWord && start < 0 {
+			start = i
+		}
+	}
+	return toks
+}
+
+func TestFTS5CustomTokenizer(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE VIRTUAL TABLE code USING fts5(ident, tokenize='camel')"); err == nil ||
+		!strings.Contains(err.Error(), "no such tokenizer: camel") {
+		t.Fatalf("expected unknown tokenizer error, got %v", err)
+	}
+	if err := db.RegisterTokenizer("camel", camelTokens); err != nil {
+		t.Fatalf("RegisterTokenizer: %v", err)
+	}
+	execOK(t, db,
+		"CREATE VIRTUAL TABLE code USING fts5(ident, tokenize='camel')",
+		"INSERT INTO code VALUES ('parseHttpRequest')",
+		"INSERT INTO code VALUES ('open_db_conn')",
+		"INSERT INTO code VALUES ('requestParser')",
+	)
+	ids := func(query string) string {
+		t.Helper()
+		rows, err := db.Query("SELECT rowid FROM code WHERE code MATCH '" + query + "' ORDER BY rowid")
+		if err != nil {
+			t.Fatalf("MATCH %q: %v", query, err)
+		}
+		var out []string
+		for _, row := range rows.Data {
+			out = append(out, fmt.Sprint(row[0]))
+		}
+		return strings.Join(out, " ")
+	}
+	for _, tc := range []struct{ query, want string }{
+		{"request", "1 3"},
+		{"http", "1"},
+		{`"httpRequest"`, "1"},
+		{`"requestHttp"`, ""},
+		{"database", "2"},
+		{`"open database conn"`, "2"},
+		{"NEAR(open conn, 1)", "2"},
+	} {
+		if got := ids(tc.query); got != tc.want {
+			t.Errorf("MATCH %q: expected %q, got %q", tc.query, tc.want, got)
+		}
+	}
+
+	rows, err := db.Query("SELECT highlight(code, 0, '[', ']') FROM code WHERE code MATCH 'database'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Data) != 1 || rows.Data[0][0] != "open_[db]_conn" {
+		t.Errorf("highlight of synonym: got %v", rows.Data)
+	}
+}
+
+func TestFTS5NgramTokenizers(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE VIRTUAL TABLE tri USING fts5(name, tokenize='trigram')",
+		"INSERT INTO tri VALUES ('Hello World')",
+		"INSERT INTO tri VALUES ('yellow submarine')",
+		"CREATE VIRTUAL TABLE cjk USING fts5(name, tokenize='ngram 2')",
+		"INSERT INTO cjk VALUES ('苹果手机壳')",
+		"INSERT INTO cjk VALUES ('华为手机')",
+		"INSERT INTO cjk VALUES ('手表')",
+	)
+	ids := func(table, query string) string {
+		t.Helper()
+		rows, err := db.Query("SELECT rowid FROM " + table + " WHERE " + table + " MATCH '" + query + "' ORDER BY rowid")
+		if err != nil {
+			t.Fatalf("MATCH %q: %v", query, err)
+		}
+		var out []string
+		for _, row := range rows.Data {
+			out = append(out, fmt.Sprint(row[0]))
+		}
+		return strings.Join(out, " ")
+	}
+	for _, tc := range []struct{ table, query, want string }{
+		{"tri", "ello", "1 2"},
+		{"tri", "ELLO", "1 2"},
+		{"tri", `"o wor"`, "1"},
+		{"tri", "marin", "2"},
+		{"tri", "mariner", ""},
+		{"cjk", "手机", "1 2"},
+		{"cjk", "手机壳", "1"},
+		{"cjk", "果手", "1"},
+		{"cjk", "手表", "3"},
+		{"cjk", "华为手表", ""},
+	} {
+		if got := ids(tc.table, tc.query); got != tc.want {
+			t.Errorf("%s MATCH %q: expected %q, got %q", tc.table, tc.query, tc.want, got)
+		}
+	}
+
+	rows, err := db.Query(`SELECT highlight(tri, 0, '[', ']') FROM tri WHERE tri MATCH '"o wor"'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Data) != 1 || rows.Data[0][0] != "Hell[o Wor]ld" {
+		t.Errorf("trigram highlight: got %v", rows.Data)
+	}
+
+	for _, bad := range []string{"trigram case_sensitive 2", "ngram 0", "ngram x"} {
+		if _, err := db.Exec("CREATE VIRTUAL TABLE bad USING fts5(x, tokenize='" + bad + "')"); err == nil {
+			t.Errorf("tokenize=%q: expected an error", bad)
+		}
+	}
+}
+
+func TestFTS5IndexSavedWithRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fts.db")
+	calls := 0
+	counting := func(text string) []Token {
+		calls++
+		return camelTokens(text)
+	}
+	db, _ := Open(path)
+	if err := db.RegisterTokenizer("camel", counting); err != nil {
+		t.Fatal(err)
+	}
+	execOK(t, db, "CREATE VIRTUAL TABLE code USING fts5(ident, tokenize='camel')")
+	for i := 0; i < 200; i++ {
+		execOK(t, db, fmt.Sprintf("INSERT INTO code VALUES ('openDbConn_%d')", i))
+	}
+	execOK(t, db, "INSERT INTO code VALUES ('parseHttpRequest')")
+	db.Close()
+
+	// Opening loads the postings; only the queries are tokenized
+	db, _ = Open(path)
+	calls = 0
+	if err := db.RegisterTokenizer("camel", counting); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryAll(t, db, "SELECT count(*) FROM code WHERE code MATCH 'database'"); got != "200" {
+		t.Errorf("MATCH database: got %s", got)
+	}
+	if got := queryAll(t, db, "SELECT rowid FROM code WHERE code MATCH 'http request'"); got != "201" {
+		t.Errorf("MATCH http request: got %s", got)
+	}
+	if calls != 3 {
+		t.Errorf("tokenized %d texts after reopening, expected just the 3 query terms", calls)
+	}
+	execOK(t, db, "INSERT INTO code(code) VALUES ('optimize')")
+	if calls != 3 {
+		t.Errorf("optimize tokenized %d texts", calls-3)
+	}
+	execOK(t, db, "INSERT INTO code(code) VALUES ('integrity-check')")
+
+	// A replayed log re-tokenizes only the rows it changed
+	if got := queryAll(t, db, "PRAGMA journal_mode = WAL"); got != "WAL" {
+		t.Fatalf("journal_mode = %s", got)
+	}
+	execOK(t, db,
+		"DELETE FROM code WHERE rowid = 201",
+		"UPDATE code SET ident = 'readHttpRequest' WHERE rowid = 1")
+	crashed, err := Open(crashCopy(t, path))
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crashed.Close()
+	calls = 0
+	if err := crashed.RegisterTokenizer("camel", counting); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryAll(t, crashed, "SELECT rowid FROM code WHERE code MATCH 'request'"); got != "1" {
+		t.Errorf("MATCH request after replay: got %s", got)
+	}
+	if got := queryAll(t, crashed, "SELECT count(*) FROM code WHERE code MATCH 'conn'"); got != "199" {
+		t.Errorf("MATCH conn after replay: got %s", got)
+	}
+	// The deleted row, the old and new text of the updated one, two queries
+	if calls != 5 {
+		t.Errorf("tokenized %d texts after replaying the log, expected 5", calls)
+	}
+	execOK(t, crashed, "INSERT INTO code(code) VALUES ('integrity-check')")
+}
@@ -0,0 +1,76 @@
+package sqlvibe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// execOK runs each statement and fails the test on the first error.
+func execOK(t *testing.T, db *Database, stmts ...string) {
+	t.Helper()
+	for _, sql := range stmts {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+}
+
+// queryAll runs sql and returns its rows joined by "|", each row's values
+// joined by ",".
+func queryAll(t *testing.T, db *Database, sql string) string {
+	t.Helper()
+	rows, err := db.Query(sql)
+	if err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+	var out []string
+	for _, r := range rows.Data {
+		out = append(out, joinRow(r))
+	}
+	return strings.Join(out, "|")
+}
+
+// joinRow formats one result row as queryAll does.
+func joinRow(r []interface{}) string {
+	vals := make([]string, len(r))
+	for i, v := range r {
+		vals[i] = fmt.Sprint(v)
+	}
+	return strings.Join(vals, ",")
+}
+
+// reopen closes db and opens the database file at path again.
+func reopen(t *testing.T, db *Database, path string) *Database {
+	t.Helper()
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	return db
+}
+
+// crashCopy copies a database file and its log, if it has one, as a crash
+// would leave them, while db is still open.
+func crashCopy(t *testing.T, path string) string {
+	t.Helper()
+	dst := filepath.Join(t.TempDir(), "crashed.db")
+	for _, suffix := range []string{"", "-wal"} {
+		b, err := os.ReadFile(path + suffix)
+		if suffix != "" && os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("read %s: %v", path+suffix, err)
+		}
+		if err := os.WriteFile(dst+suffix, b, 0o644); err != nil {
+			t.Fatalf("write %s: %v", dst+suffix, err)
+		}
+	}
+	return dst
+}
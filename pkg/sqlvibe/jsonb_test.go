@@ -0,0 +1,97 @@
+package sqlvibe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONBRoundTrip(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE ev (id INTEGER PRIMARY KEY, payload BLOB)",
+		`INSERT INTO ev (payload) VALUES (jsonb('{"kind":"click","a":{"b":5},"price":1.50,"tags":["x","y"]}'))`,
+		`INSERT INTO ev (payload) VALUES (jsonb('{"kind":"view","a":{"b":7},"price":2e3,"tags":[]}'))`)
+
+	// Key order and number spelling survive the conversion
+	got := queryAll(t, db, "SELECT typeof(payload), json(payload) FROM ev WHERE id = 1")
+	if want := `blob,{"kind":"click","a":{"b":5},"price":1.50,"tags":["x","y"]}`; got != want {
+		t.Errorf("stored payload = %s, want %s", got, want)
+	}
+
+	got = queryAll(t, db, `SELECT json(jsonb('[1, "two", null, true, {"k": -0.5}]'))`)
+	if got != `[1,"two",null,true,{"k":-0.5}]` {
+		t.Errorf("unexpected round trip: %s", got)
+	}
+	if got := queryAll(t, db, "SELECT jsonb('not json')"); got != "<nil>" {
+		t.Errorf("expected NULL for invalid JSON, got %s", got)
+	}
+}
+
+func TestJSONBExtract(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE ev (id INTEGER PRIMARY KEY, payload BLOB)",
+		`INSERT INTO ev (payload) VALUES (jsonb('{"kind":"click","a":{"b":5},"price":1.50,"tags":["x","y"]}'))`,
+		`INSERT INTO ev (payload) VALUES (jsonb('{"kind":"view","a":{"b":7},"price":2e3,"tags":[]}'))`)
+
+	got := queryAll(t, db, `SELECT json_extract(payload, '$.a.b'), payload ->> '$.kind',
+		json(payload -> '$.tags'), json_extract(payload, '$.tags[#-1]') FROM ev WHERE id = 1`)
+	if got != `5,click,["x","y"],y` {
+		t.Errorf("unexpected extract results: %s", got)
+	}
+
+	got = queryAll(t, db, "SELECT typeof(jsonb_extract(payload, '$.a')), json(jsonb_extract(payload, '$.a')), jsonb_extract(payload, '$.kind') FROM ev WHERE id = 1")
+	if got != `blob,{"b":5},click` {
+		t.Errorf("unexpected jsonb_extract results: %s", got)
+	}
+
+	got = queryAll(t, db, "SELECT key FROM json_each((SELECT payload FROM ev WHERE id = 1)) ORDER BY key")
+	if got != "a|kind|price|tags" {
+		t.Errorf("json_each keys = %s", got)
+	}
+}
+
+func TestJSONBModify(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE ev (id INTEGER PRIMARY KEY, payload BLOB)",
+		`INSERT INTO ev (payload) VALUES (jsonb('{"kind":"click","a":{"b":5},"price":1.50,"tags":["x","y"]}'))`,
+		`INSERT INTO ev (payload) VALUES (jsonb('{"kind":"view","a":{"b":7},"price":2e3,"tags":[]}'))`)
+
+	execOK(t, db,
+		"UPDATE ev SET payload = jsonb_set(payload, '$.a.b', 9) WHERE id = 1",
+		"UPDATE ev SET payload = jsonb_remove(payload, '$.tags') WHERE id = 1",
+		"UPDATE ev SET payload = jsonb_insert(payload, '$.kind', 'other', '$.user', 'ann') WHERE id = 1",
+	)
+	got := queryAll(t, db, "SELECT typeof(payload), json(payload) FROM ev WHERE id = 1")
+	if got != `blob,{"kind":"click","a":{"b":9},"price":1.50,"user":"ann"}` {
+		t.Errorf("unexpected payload after update: %s", got)
+	}
+
+	got = queryAll(t, db, `SELECT json(jsonb_replace(payload, '$.tags[0]', jsonb('{"k":null}'), '$.tags[#]', 1)),
+		json(jsonb_set(payload, '$.tags[#]', 'z')) FROM ev WHERE id = 2`)
+	if got != `{"kind":"view","a":{"b":7},"price":2e3,"tags":[]},{"kind":"view","a":{"b":7},"price":2e3,"tags":["z"]}` {
+		t.Errorf("unexpected edit results: %s", got)
+	}
+}
+
+func TestJSONBExpressionIndex(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE ev (id INTEGER PRIMARY KEY, payload BLOB)",
+		`INSERT INTO ev (payload) VALUES (jsonb('{"kind":"click","a":{"b":5},"price":1.50,"tags":["x","y"]}'))`,
+		`INSERT INTO ev (payload) VALUES (jsonb('{"kind":"view","a":{"b":7},"price":2e3,"tags":[]}'))`)
+
+	execOK(t, db, "CREATE INDEX ev_ab ON ev (json_extract(payload, '$.a.b'))")
+	sql := "SELECT id FROM ev WHERE json_extract(payload, '$.a.b') = 7"
+	if plan := queryAll(t, db, "EXPLAIN QUERY PLAN "+sql); !strings.Contains(plan, "USING INDEX ev_ab") {
+		t.Errorf("expected the expression index to be used, got %q", plan)
+	}
+	if got := queryAll(t, db, sql); got != "2" {
+		t.Errorf("expected event 2, got %s", got)
+	}
+}
@@ -0,0 +1,307 @@
+package sqlvibe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// JSONImportOptions controls how ImportJSON behaves.
+type JSONImportOptions struct {
+	// CreateTable creates the table if it does not exist, with a column per
+	// key of the first rows, in the order the keys first appear, typed
+	// INTEGER, REAL, BOOLEAN, DATE, DATETIME, JSON or TEXT as their values
+	// fit.
+	CreateTable bool
+	// SampleRows is how many rows CreateTable infers columns from
+	// (default 1000).
+	SampleRows int
+	// MaxRejects is the number of rows that may violate a constraint before
+	// the import fails, as for BulkInsert.
+	MaxRejects int
+}
+
+// ImportJSON reads JSON objects from r and inserts a row for each into
+// table through BulkInsert. The input is either an array of objects or a
+// sequence of them, as in NDJSON. Keys name columns, case-insensitively;
+// a column without a key is NULL and a key without a column fails the
+// import. Numbers are stored as INTEGER or REAL, booleans as 1 and 0,
+// strings as TEXT and nested objects and arrays as JSON text. Returns the
+// number of rows inserted.
+func (db *Database) ImportJSON(table string, r io.Reader, opts JSONImportOptions) (int, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+	in := &jsonInput{dec: json.NewDecoder(br)}
+	in.dec.UseNumber()
+	if err := in.sample(opts.SampleRows); err != nil {
+		return 0, fmt.Errorf("ImportJSON: %w", err)
+	}
+	if len(in.pending) == 0 {
+		return 0, nil
+	}
+
+	var cols []string
+	if existing, err := db.GetColumns(table); err == nil {
+		for _, c := range existing {
+			cols = append(cols, c.Name)
+		}
+	} else if opts.CreateTable {
+		cols = in.keys
+		if err := db.createJSONTable(table, cols, in.pending); err != nil {
+			return 0, fmt.Errorf("ImportJSON: %w", err)
+		}
+	} else {
+		return 0, fmt.Errorf("ImportJSON: %w", err)
+	}
+	in.cols = make(map[string]int, len(cols))
+	for i, c := range cols {
+		in.cols[strings.ToLower(c)] = i
+	}
+	in.row = make([]interface{}, len(cols))
+
+	res, err := db.BulkInsert(table, cols, in, BulkOptions{MaxRejects: opts.MaxRejects})
+	if in.err != nil {
+		return res.Inserted, fmt.Errorf("ImportJSON: record %d: %w", in.n, in.err)
+	}
+	if err != nil {
+		if n := len(res.Rejected); n > 0 {
+			last := res.Rejected[n-1]
+			return res.Inserted, fmt.Errorf("ImportJSON: record %d: %w", last.Row+1, last.Err)
+		}
+		return res.Inserted, fmt.Errorf("ImportJSON: %w", err)
+	}
+	return res.Inserted, nil
+}
+
+// jsonText is a nested object or array, compacted, until stored as text.
+type jsonText string
+
+// jsonObject is an object's members in the order they appear, with values
+// converted as ImportJSON stores them.
+type jsonObject struct {
+	keys []string
+	vals []interface{}
+}
+
+// jsonInput is the RowSource of ImportJSON: the objects of a JSON decoder,
+// after those read ahead to sample column names and types.
+type jsonInput struct {
+	dec     *json.Decoder
+	inArray bool // the objects are elements of an array
+	started bool // the first token has been read
+	pending []jsonObject
+	keys    []string // keys of the pending objects, in first-seen order
+	cols    map[string]int
+	n       int // objects returned so far
+	row     []interface{}
+	err     error
+}
+
+// read returns the next object, or io.EOF after the last one.
+func (in *jsonInput) read() (jsonObject, error) {
+	var obj jsonObject
+	if !in.started {
+		in.started = true
+		tok, err := in.dec.Token()
+		if err != nil {
+			return obj, err
+		}
+		if tok == json.Delim('[') {
+			in.inArray = true
+		} else if tok != json.Delim('{') {
+			return obj, fmt.Errorf("expected an object or an array of objects, found %v", tok)
+		} else {
+			return in.readMembers()
+		}
+	}
+	if in.inArray && !in.dec.More() {
+		if _, err := in.dec.Token(); err != nil { // the closing ']'
+			return obj, err
+		}
+		if _, err := in.dec.Token(); err != io.EOF {
+			return obj, fmt.Errorf("unexpected data after the array")
+		}
+		return obj, io.EOF
+	}
+	tok, err := in.dec.Token()
+	if err != nil {
+		return obj, err
+	}
+	if tok != json.Delim('{') {
+		return obj, fmt.Errorf("expected an object, found %v", tok)
+	}
+	return in.readMembers()
+}
+
+// readMembers reads the members of an object whose '{' has been read.
+func (in *jsonInput) readMembers() (jsonObject, error) {
+	var obj jsonObject
+	for in.dec.More() {
+		tok, err := in.dec.Token()
+		if err != nil {
+			return obj, err
+		}
+		var raw json.RawMessage
+		if err := in.dec.Decode(&raw); err != nil {
+			return obj, err
+		}
+		v, err := jsonValue(raw)
+		if err != nil {
+			return obj, err
+		}
+		obj.keys = append(obj.keys, tok.(string))
+		obj.vals = append(obj.vals, v)
+	}
+	_, err := in.dec.Token() // the closing '}'
+	return obj, err
+}
+
+// sample reads up to n objects (default 1000) ahead, noting their keys.
+func (in *jsonInput) sample(n int) error {
+	if n <= 0 {
+		n = 1000
+	}
+	seen := make(map[string]bool)
+	for len(in.pending) < n {
+		obj, err := in.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %w", len(in.pending)+1, err)
+		}
+		for _, k := range obj.keys {
+			if !seen[strings.ToLower(k)] {
+				seen[strings.ToLower(k)] = true
+				in.keys = append(in.keys, k)
+			}
+		}
+		in.pending = append(in.pending, obj)
+	}
+	return nil
+}
+
+// Next implements RowSource.
+func (in *jsonInput) Next() ([]interface{}, error) {
+	var obj jsonObject
+	if len(in.pending) > 0 {
+		obj, in.pending = in.pending[0], in.pending[1:]
+	} else {
+		var err error
+		if obj, err = in.read(); err != nil {
+			if err != io.EOF {
+				in.err = err
+				in.n++
+			}
+			return nil, io.EOF
+		}
+	}
+	in.n++
+	clear(in.row)
+	for i, k := range obj.keys {
+		c, ok := in.cols[strings.ToLower(k)]
+		if !ok {
+			in.err = fmt.Errorf("no column named %s", k)
+			return nil, io.EOF
+		}
+		if t, ok := obj.vals[i].(jsonText); ok {
+			in.row[c] = string(t)
+		} else {
+			in.row[c] = obj.vals[i]
+		}
+	}
+	return in.row, nil
+}
+
+// jsonValue converts a JSON value to the value ImportJSON stores: nil,
+// bool, int64, float64 (for numbers beyond int64 too), string or, for
+// objects and arrays, jsonText.
+func jsonValue(raw json.RawMessage) (interface{}, error) {
+	switch raw[0] {
+	case '{', '[':
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return nil, err
+		}
+		return jsonText(buf.String()), nil
+	case 'n':
+		return nil, nil
+	case 't':
+		return true, nil
+	case 'f':
+		return false, nil
+	case '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	}
+	if i, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+		return i, nil
+	}
+	return strconv.ParseFloat(string(raw), 64)
+}
+
+// createJSONTable creates table, if it does not exist, with columns cols
+// typed after their values in sample.
+func (db *Database) createJSONTable(table string, cols []string, sample []jsonObject) error {
+	defs := make([]string, len(cols))
+	for i, c := range cols {
+		var vals []interface{}
+		for _, obj := range sample {
+			for j, k := range obj.keys {
+				if strings.EqualFold(k, c) && obj.vals[j] != nil {
+					vals = append(vals, obj.vals[j])
+				}
+			}
+		}
+		defs[i] = quoteIdent(c) + " " + inferJSONType(vals)
+	}
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + quoteIdent(table) + " (" + strings.Join(defs, ", ") + ")")
+	return err
+}
+
+// inferJSONType returns the declared type of a column holding vals, as
+// jsonValue converts them: INTEGER or REAL for numbers, BOOLEAN for
+// booleans, JSON for objects and arrays, DATE or DATETIME for strings that
+// are all times and TEXT otherwise.
+func inferJSONType(vals []interface{}) string {
+	var ints, reals, bools, nested int
+	var strs []string
+	for _, v := range vals {
+		switch v := v.(type) {
+		case int64:
+			ints++
+		case float64:
+			reals++
+		case bool:
+			bools++
+		case jsonText:
+			nested++
+		case string:
+			strs = append(strs, v)
+		}
+	}
+	switch n := len(vals); {
+	case n == 0:
+		return "TEXT"
+	case ints == n:
+		return "INTEGER"
+	case ints+reals == n:
+		return "REAL"
+	case bools == n:
+		return "BOOLEAN"
+	case nested == n:
+		return "JSON"
+	case len(strs) == n:
+		if t := inferColumnType(strs); t == "DATE" || t == "DATETIME" {
+			return t
+		}
+	}
+	return "TEXT"
+}
@@ -0,0 +1,127 @@
+package sqlvibe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExportJSON(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE t (z INTEGER, a TEXT, doc JSON, b BLOB, r REAL)",
+		`INSERT INTO t VALUES (1, 'x<y', '{"k": [1, 2]}', X'00ff', 1.5)`,
+		`INSERT INTO t VALUES (2, NULL, 'not json', NULL, NULL)`)
+
+	tests := []struct {
+		opts JSONExportOptions
+		want string
+	}{
+		{JSONExportOptions{}, "[\n" +
+			`{"z":1,"a":"x<y","doc":"{\"k\": [1, 2]}","b":"AP8=","r":1.5},` + "\n" +
+			`{"z":2,"a":null,"doc":"not json","b":null,"r":null}` + "\n]\n"},
+		{JSONExportOptions{Format: JSONFormatLines, ParseJSON: true},
+			`{"z":1,"a":"x<y","doc":{"k":[1,2]},"b":"AP8=","r":1.5}` + "\n" +
+				`{"z":2,"a":null,"doc":"not json","b":null,"r":null}` + "\n"},
+		{JSONExportOptions{Format: JSONFormatArrays}, "[\n" +
+			`["z","a","doc","b","r"],` + "\n" +
+			`[1,"x<y","{\"k\": [1, 2]}","AP8=",1.5],` + "\n" +
+			`[2,null,"not json",null,null]` + "\n]\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := db.ExportJSON(&buf, "SELECT * FROM t ORDER BY z", tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%+v:\n%s\nwant:\n%s", tt.opts, buf.String(), tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	opts := JSONExportOptions{ParseJSON: true, JSONColumns: []string{"J"}}
+	if err := db.ExportJSON(&buf, `SELECT '[true]' AS j FROM t WHERE z > 5`, opts); err != nil || buf.String() != "[]\n" {
+		t.Errorf("empty result = %q, %v", buf.String(), err)
+	}
+	buf.Reset()
+	if err := db.ExportJSON(&buf, `SELECT '[true]' AS j`, opts); err != nil || buf.String() != "[\n{\"j\":[true]}\n]\n" {
+		t.Errorf("JSONColumns = %q, %v", buf.String(), err)
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	ndjson := `{"id": 1, "name": "ann", "score": 9.5, "tags": ["a", "b"], "ok": true, "born": "2000-01-02"}
+{"id": 2, "Name": "bob", "score": 7, "tags": {"x": 1}, "ok": false, "born": null}
+
+{"id": 3, "extra": null}
+`
+	n, err := db.ImportJSON("people", strings.NewReader(ndjson), JSONImportOptions{CreateTable: true})
+	if err != nil || n != 3 {
+		t.Fatalf("ImportJSON = %d, %v", n, err)
+	}
+	cols, _ := db.GetColumns("people")
+	var decl []string
+	for _, c := range cols {
+		decl = append(decl, c.Name+" "+c.Type)
+	}
+	if got := strings.Join(decl, ", "); got != "id INTEGER, name TEXT, score REAL, tags JSON, ok BOOLEAN, born DATE, extra TEXT" {
+		t.Errorf("columns: %s", got)
+	}
+	got := queryAll(t, db, "SELECT id, name, score, tags, ok, born, extra FROM people ORDER BY id")
+	want := `1,ann,9.5,["a","b"],1,2000-01-02,<nil>|2,bob,7,{"x":1},0,<nil>,<nil>|3,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>`
+	if got != want {
+		t.Errorf("rows:\n%s\nwant:\n%s", got, want)
+	}
+	if got := queryAll(t, db, "SELECT json_extract(tags, '$[1]') FROM people WHERE id = 1"); got != "b" {
+		t.Errorf("json_extract: %s", got)
+	}
+
+	// An array of objects into the existing table
+	n, err = db.ImportJSON("people", strings.NewReader(`[{"id": 4, "score": 12345678901234567890}, {"ID": 5}]`), JSONImportOptions{})
+	if err != nil || n != 2 {
+		t.Fatalf("ImportJSON array = %d, %v", n, err)
+	}
+	if got := queryAll(t, db, "SELECT typeof(score) FROM people WHERE id = 4"); got != "real" {
+		t.Errorf("large number stored as %s", got)
+	}
+	if n, err := db.ImportJSON("people", strings.NewReader("[]"), JSONImportOptions{}); err != nil || n != 0 {
+		t.Errorf("empty array = %d, %v", n, err)
+	}
+}
+
+func TestImportJSONErrors(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT NOT NULL)")
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`{"id": 1, "name": "a"}` + "\n" + `{"id": 2, "nope": 1}`, "record 2: no column named nope"},
+		{`{"id": 1, "name": "a"}` + "\n" + `{"id": 1, "name": "b"}`, "record 2: UNIQUE constraint failed"},
+		{`{"id": 1, "name": "a"} {"id": 2,`, "record 2:"},
+		{`[1, 2]`, "record 1: expected an object"},
+		{`"text"`, "expected an object or an array of objects"},
+		{`[{"id": 1, "name": "a"}] {}`, "unexpected data after the array"},
+	}
+	for _, tt := range tests {
+		execOK(t, db, "DELETE FROM t")
+		if _, err := db.ImportJSON("t", strings.NewReader(tt.in), JSONImportOptions{}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.in, err, tt.want)
+		}
+	}
+	if _, err := db.ImportJSON("missing", strings.NewReader(`{"a": 1}`), JSONImportOptions{}); err == nil {
+		t.Error("ImportJSON into a missing table without CreateTable succeeded")
+	}
+
+	execOK(t, db, "DELETE FROM t")
+	n, err := db.ImportJSON("t", strings.NewReader(`{"id": 1, "name": "a"} {"id": 2} {"id": 3, "name": "c"}`), JSONImportOptions{MaxRejects: 1})
+	if err != nil || n != 2 {
+		t.Errorf("ImportJSON with MaxRejects = %d, %v", n, err)
+	}
+}
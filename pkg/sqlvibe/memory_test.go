@@ -0,0 +1,120 @@
+package sqlvibe
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func memoryStats(t *testing.T, db *Database) map[string]int64 {
+	t.Helper()
+	rows, err := db.Query("PRAGMA memory_stats")
+	if err != nil {
+		t.Fatalf("PRAGMA memory_stats: %v", err)
+	}
+	stats := map[string]int64{}
+	for _, r := range rows.Data {
+		stats[r[0].(string)] = r[1].(int64)
+	}
+	return stats
+}
+
+func TestMemoryStatsCompactRows(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	execOK(t, db, "CREATE TABLE m (id INTEGER PRIMARY KEY, name TEXT, city TEXT, qty INTEGER, price REAL)")
+	for b := 0; b < 4; b++ {
+		var vals []string
+		for i := b * 500; i < (b+1)*500; i++ {
+			vals = append(vals, fmt.Sprintf("('user%d', 'city%d', %d, %d.5)", i, i%10, i, i))
+		}
+		execOK(t, db, "INSERT INTO m (name, city, qty, price) VALUES "+strings.Join(vals, ","))
+	}
+
+	stats := memoryStats(t, db)
+	if stats["total_rows"] != 2000 || stats["table_count"] != 1 {
+		t.Fatalf("unexpected row and table counts: %v", stats)
+	}
+	if stats["cell_count"] < 2000*5 {
+		t.Errorf("cell_count = %d, want at least one cell per column", stats["cell_count"])
+	}
+	// Positional rows hold 24-byte cells: no per-row hash table or key strings,
+	// and short strings stay inline
+	if perCell := stats["row_bytes"] / stats["cell_count"]; perCell > 40 {
+		t.Errorf("rows take %d bytes per cell", perCell)
+	}
+	if stats["bytes_per_row"] != stats["row_bytes"]/stats["total_rows"] {
+		t.Errorf("bytes_per_row %d disagrees with row_bytes %d", stats["bytes_per_row"], stats["row_bytes"])
+	}
+	if stats["row_layouts"] < 1 || stats["layout_bytes"] <= 0 {
+		t.Errorf("unexpected layout stats: %v", stats)
+	}
+
+	// Rows keep working as their columns change
+	execOK(t, db,
+		"ALTER TABLE m ADD COLUMN note TEXT DEFAULT 'n/a'",
+		"ALTER TABLE m RENAME COLUMN city TO town",
+		"UPDATE m SET note = 'first' WHERE id = 1",
+	)
+	if got := queryAll(t, db, "SELECT id, name, town, qty, price, note FROM m WHERE id IN (1, 2000) ORDER BY id"); got != "1,user0,city0,0,0.5,first|2000,user1999,city9,1999,1999.5,n/a" {
+		t.Errorf("unexpected rows after ALTER TABLE: %s", got)
+	}
+	if got := queryAll(t, db, "SELECT town, count(*) FROM m GROUP BY town ORDER BY town LIMIT 2"); got != "city0,200|city1,200" {
+		t.Errorf("unexpected grouping after rename: %s", got)
+	}
+	if after := memoryStats(t, db); after["cell_count"] <= stats["cell_count"] {
+		t.Errorf("cell_count did not grow with the added column: %d -> %d", stats["cell_count"], after["cell_count"])
+	}
+
+	// Result rows of finished queries and the rows of dropped tables give
+	// their layouts back
+	before := memoryStats(t, db)["row_layouts"]
+	for i := 0; i < 300; i++ {
+		queryAll(t, db, fmt.Sprintf("SELECT qty AS q%d, name AS n%d FROM m WHERE id < 3", i, i))
+	}
+	if after := memoryStats(t, db)["row_layouts"]; after > before {
+		t.Errorf("row_layouts grew from %d to %d over ad-hoc queries", before, after)
+	}
+	execOK(t, db, "DROP TABLE m")
+	if after := memoryStats(t, db)["row_layouts"]; after >= before {
+		t.Errorf("row_layouts did not shrink after DROP TABLE: %d -> %d", before, after)
+	}
+}
+
+func TestProjectionReadsCellsByPosition(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	// Ten columns, so finding one by name would hash the layout
+	var cols, vals []string
+	for i := 0; i < 10; i++ {
+		cols = append(cols, fmt.Sprintf("c%d INTEGER", i))
+	}
+	for r := 1; r <= 3; r++ {
+		var v []string
+		for i := 0; i < 10; i++ {
+			v = append(v, fmt.Sprint(r*100+i))
+		}
+		vals = append(vals, "("+strings.Join(v, ",")+")")
+	}
+	execOK(t, db,
+		"CREATE TABLE w ("+strings.Join(cols, ", ")+")",
+		"INSERT INTO w VALUES "+strings.Join(vals, ","),
+		"CREATE TABLE u (c0 INTEGER, tag TEXT)",
+		"INSERT INTO u VALUES (100, 'one'), (300, 'three')",
+	)
+	if got := queryAll(t, db, "SELECT c9, c0, W.C5, w.c3, C7, c1 + c2, NULL FROM w ORDER BY c0 LIMIT 2"); got != "109,100,105,103,107,203,<nil>|209,200,205,203,207,403,<nil>" {
+		t.Errorf("unexpected projection: %s", got)
+	}
+	if got := queryAll(t, db, "SELECT u.tag, w.c9, c8 FROM w JOIN u ON u.c0 = w.c0 ORDER BY w.c0"); got != "one,109,108|three,309,308" {
+		t.Errorf("unexpected join projection: %s", got)
+	}
+	if got := queryAll(t, db, "SELECT * FROM u JOIN w ON u.c0 = w.c0 WHERE u.tag = 'three'"); !strings.HasPrefix(got, "300,three,300,301,") || !strings.HasSuffix(got, ",309") {
+		t.Errorf("unexpected star join: %s", got)
+	}
+}
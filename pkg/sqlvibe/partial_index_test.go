@@ -0,0 +1,174 @@
+package sqlvibe
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPartialUniqueIndexSoftDelete(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, deleted_at TEXT)",
+		"CREATE UNIQUE INDEX users_email_live ON users(email) WHERE deleted_at IS NULL",
+		"INSERT INTO users (email) VALUES ('a@x'), ('b@x')")
+
+	if _, err := db.Exec("INSERT INTO users (email) VALUES ('a@x')"); err == nil ||
+		!strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
+		t.Fatalf("expected UNIQUE error for live duplicate, got %v", err)
+	}
+	if _, err := db.Exec("UPDATE users SET deleted_at = '2024-01-01' WHERE id = 1"); err != nil {
+		t.Fatalf("Soft delete error: %v", err)
+	}
+	// Only live rows are covered, so the address can be reused
+	if _, err := db.Exec("INSERT INTO users (email) VALUES ('a@x')"); err != nil {
+		t.Fatalf("Insert after soft delete: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (email, deleted_at) VALUES ('a@x', '2024-02-01')"); err != nil {
+		t.Fatalf("Insert of deleted duplicate: %v", err)
+	}
+	// Restoring the old row would make two live duplicates
+	if _, err := db.Exec("UPDATE users SET deleted_at = NULL WHERE id = 1"); err == nil {
+		t.Fatal("expected UNIQUE error when restoring a soft-deleted duplicate")
+	}
+	rows, _ := db.Query("SELECT id FROM users WHERE deleted_at IS NULL ORDER BY id")
+	if len(rows.Data) != 2 || rows.Data[0][0] != int64(2) || rows.Data[1][0] != int64(3) {
+		t.Errorf("expected live rows 2 and 3, got %v", rows.Data)
+	}
+
+	if _, err := db.Exec("CREATE UNIQUE INDEX users_email_all ON users(email)"); err == nil {
+		t.Error("expected CREATE UNIQUE INDEX to fail over existing duplicates")
+	}
+}
+
+func TestExpressionUniqueIndex(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	db.Exec("CREATE TABLE accounts (id INTEGER PRIMARY KEY, email TEXT)")
+	db.Exec("INSERT INTO accounts (email) VALUES ('Ann@x'), (NULL), (NULL)")
+	if _, err := db.Exec("CREATE UNIQUE INDEX accounts_email_ci ON accounts(lower(email))"); err != nil {
+		t.Fatalf("Create index error: %v", err)
+	}
+
+	tests := []struct {
+		sql     string
+		wantErr bool
+	}{
+		{"INSERT INTO accounts (email) VALUES ('ANN@X')", true},
+		{"INSERT INTO accounts (email) VALUES ('bob@x')", false},
+		{"INSERT INTO accounts (email) VALUES (NULL)", false},
+		{"UPDATE accounts SET email = 'BOB@x' WHERE id = 1", true},
+		{"UPDATE accounts SET email = 'ann@x' WHERE id = 1", false},
+		{"INSERT OR IGNORE INTO accounts (email) VALUES ('Bob@X')", false},
+	}
+	for _, tt := range tests {
+		_, err := db.Exec(tt.sql)
+		if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "index 'accounts_email_ci'")) {
+			t.Errorf("%s: expected unique index error, got %v", tt.sql, err)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.sql, err)
+		}
+	}
+
+	rows, _ := db.Query("SELECT id FROM accounts WHERE lower(email) = 'bob@x'")
+	if len(rows.Data) != 1 || rows.Data[0][0] != int64(4) {
+		t.Errorf("expected row 4 through the expression index, got %v", rows.Data)
+	}
+
+	rows, _ = db.Query("PRAGMA index_info(accounts_email_ci)")
+	if len(rows.Data) != 1 || rows.Data[0][1] != int64(-2) || rows.Data[0][2] != nil {
+		t.Errorf("expected an expression key term, got %v", rows.Data)
+	}
+}
+
+func TestUniqueIndexKeysFollowWrites(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE u (id INTEGER PRIMARY KEY, email TEXT, deleted_at TEXT)",
+		"CREATE UNIQUE INDEX u_live ON u(lower(email)) WHERE deleted_at IS NULL")
+	vals := make([]string, 5000)
+	for i := range vals {
+		vals[i] = fmt.Sprintf("('E%d')", i+1)
+	}
+	execOK(t, db, "INSERT INTO u (email) VALUES "+strings.Join(vals, ", "))
+
+	tests := []struct {
+		sql     string
+		wantErr bool
+	}{
+		{"INSERT INTO u (email) VALUES ('e5000')", true},
+		{"UPDATE u SET email = 'e1' WHERE id = 2", true},
+		{"UPDATE u SET email = 'e2' WHERE id = 2", false},
+		// Fails on its second row; the first is put back
+		{"UPDATE u SET email = 'x' WHERE id < 5", true},
+		{"INSERT INTO u (email) VALUES ('e1')", true},
+		{"INSERT INTO u (email) VALUES ('x')", false},
+		{"INSERT INTO u (email) VALUES ('X')", true},
+		{"UPDATE u SET deleted_at = 'now' WHERE email = 'x'", false},
+		{"INSERT INTO u (email) VALUES ('X')", false},
+		{"UPDATE u SET email = 'y' WHERE email = 'X'", false},
+		{"INSERT INTO u (email) VALUES ('x')", false},
+		{"INSERT INTO u (email) VALUES ('Y')", true},
+	}
+	for _, tt := range tests {
+		_, err := db.Exec(tt.sql)
+		if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "index 'u_live'")) {
+			t.Errorf("%s: expected unique index error, got %v", tt.sql, err)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.sql, err)
+		}
+	}
+	if got := queryAll(t, db, "SELECT count(*), count(deleted_at) FROM u"); got != "5003,1" {
+		t.Errorf("row counts = %s", got)
+	}
+}
+
+func TestPartialIndexQueryPlan(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, deleted_at TEXT)",
+		"CREATE UNIQUE INDEX users_email_live ON users(email) WHERE deleted_at IS NULL",
+		"INSERT INTO users (email) VALUES ('a@x'), ('b@x')")
+
+	db.Exec("CREATE INDEX users_email_lower ON users(lower(email))")
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM users WHERE email = 'a@x'", "SCAN users"},
+		{"SELECT * FROM users WHERE email = 'a@x' AND deleted_at IS NULL",
+			"SEARCH users USING INDEX users_email_live (email=?)"},
+		{"SELECT * FROM users u WHERE u.deleted_at IS NULL AND u.email = 'a@x'",
+			"SEARCH users USING INDEX users_email_live (email=?)"},
+		{"SELECT * FROM users WHERE LOWER(email) = 'a@x'",
+			"SEARCH users USING INDEX users_email_lower (lower(email)=?)"},
+		{"SELECT * FROM users WHERE deleted_at IS NULL", "SCAN users"},
+	}
+	for _, tt := range tests {
+		if got := queryAll(t, db, "EXPLAIN QUERY PLAN "+tt.sql); got != "2,0,0,"+tt.want {
+			t.Errorf("%s: expected plan %q, got %q", tt.sql, tt.want, got)
+		}
+	}
+
+	// The index is kept current as rows change
+	db.Exec("UPDATE users SET deleted_at = 'now' WHERE id = 1")
+	db.Exec("INSERT INTO users (email) VALUES ('a@x')")
+	rows, _ := db.Query("SELECT id FROM users WHERE email = 'a@x' AND deleted_at IS NULL")
+	if len(rows.Data) != 1 || rows.Data[0][0] != int64(3) {
+		t.Errorf("expected live row 3, got %v", rows.Data)
+	}
+
+	rows, _ = db.Query("PRAGMA index_list(users)")
+	partial := map[string]int64{}
+	for _, row := range rows.Data {
+		partial[row[1].(string)] = row[4].(int64)
+	}
+	if partial["users_email_live"] != 1 || partial["users_email_lower"] != 0 {
+		t.Errorf("unexpected partial flags %v", partial)
+	}
+}
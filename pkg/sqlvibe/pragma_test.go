@@ -27,6 +27,30 @@ func TestPragmaTableInfo(t *testing.T) {
 	}
 }
 
+func TestPragmaTableInfoDeclaredTypes(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	db.Exec("CREATE TABLE t (a DOUBLE PRECISION, b VARCHAR(10) NOT NULL, c UNSIGNED BIG INT DEFAULT 3, d DECIMAL(10, 2), e)")
+	if _, err := db.Exec("ALTER TABLE t ADD COLUMN f LONG VARCHAR(20)"); err != nil {
+		t.Fatalf("Alter error: %v", err)
+	}
+
+	rows, err := db.Query("PRAGMA table_info(t)")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	expected := []string{"DOUBLE PRECISION", "VARCHAR(10)", "UNSIGNED BIG INT", "DECIMAL(10, 2)", "", "LONG VARCHAR(20)"}
+	if len(rows.Data) != len(expected) {
+		t.Fatalf("expected %d columns, got %d", len(expected), len(rows.Data))
+	}
+	for i, row := range rows.Data {
+		if row[2] != expected[i] {
+			t.Errorf("column %v: expected type %q, got %v", row[1], expected[i], row[2])
+		}
+	}
+}
+
 func TestPragmaIndexList(t *testing.T) {
 	db, _ := Open(":memory:")
 	defer db.Close()
@@ -0,0 +1,165 @@
+package sqlvibe
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	cgo "github.com/cyw0ng95/sqlvibe/pkg/sqlvibe/cgo"
+)
+
+// ErrBusy is returned when a connection to a shared database waits longer
+// than its busy timeout for another connection's transaction to end.
+var ErrBusy = errors.New("database is locked")
+
+// sharedDBs holds the in-memory databases opened with cache=shared, by
+// name. Guarded by sharedMu, as are their refs.
+var (
+	sharedMu  sync.Mutex
+	sharedDBs = map[string]*sharedDB{}
+)
+
+// sharedDB is one in-memory engine database that several connections use.
+// Statements run one at a time, and a connection with an open transaction
+// holds the database until the transaction ends: other connections wait
+// for it up to the busy timeout, as for a file database's write lock, so
+// no connection sees another's uncommitted changes.
+type sharedDB struct {
+	name string
+	cdb  *cgo.DB
+	refs int
+
+	stmt  sync.Mutex    // held while a statement runs
+	mu    sync.Mutex    // guards owner and ended
+	owner *Database     // connection whose transaction is open, if any
+	ended chan struct{} // closed when owner's transaction ends
+}
+
+// openShared attaches to the shared in-memory database called name,
+// creating it for the first connection.
+func openShared(name string) (*sharedDB, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if s, ok := sharedDBs[name]; ok {
+		s.refs++
+		return s, nil
+	}
+	cdb, err := cgo.OpenV2(":memory:", cgo.OpenReadWrite|cgo.OpenCreate|cgo.OpenMemory)
+	if err != nil {
+		return nil, err
+	}
+	if err := registerTableFunctions(cdb); err != nil {
+		cdb.Close()
+		return nil, err
+	}
+	s := &sharedDB{name: name, cdb: cdb, refs: 1}
+	sharedDBs[name] = s
+	return s, nil
+}
+
+// detach drops db's reference, rolling back a transaction it left open.
+// The last connection to go closes the database and forgets its name.
+func (s *sharedDB) detach(db *Database) error {
+	var err error
+	s.mu.Lock()
+	owned := s.owner == db
+	s.mu.Unlock()
+	if owned {
+		s.stmt.Lock()
+		_, err = s.cdb.Exec("ROLLBACK")
+		s.end(db)
+		s.stmt.Unlock()
+	}
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if s.refs--; s.refs > 0 {
+		return err
+	}
+	delete(sharedDBs, s.name)
+	if cerr := s.cdb.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// acquire waits until db may run a statement: no statement is running and
+// no other connection's transaction is open.
+func (s *sharedDB) acquire(db *Database) error {
+	var deadline <-chan time.Time
+	for {
+		s.stmt.Lock()
+		s.mu.Lock()
+		if s.owner == nil || s.owner == db {
+			s.mu.Unlock()
+			return nil
+		}
+		ended := s.ended
+		s.mu.Unlock()
+		if deadline == nil {
+			deadline = time.After(s.busyTimeout())
+		}
+		s.stmt.Unlock()
+		select {
+		case <-ended:
+		case <-deadline:
+			return ErrBusy
+		}
+	}
+}
+
+// release ends the statement acquire allowed, noting whether it left db
+// in a transaction.
+func (s *sharedDB) release(db *Database) {
+	if s.cdb.InTransaction() {
+		s.mu.Lock()
+		if s.owner == nil {
+			s.owner = db
+			s.ended = make(chan struct{})
+		}
+		s.mu.Unlock()
+	} else {
+		s.end(db)
+	}
+	s.stmt.Unlock()
+}
+
+// end clears db's ownership, waking the connections waiting for it.
+func (s *sharedDB) end(db *Database) {
+	s.mu.Lock()
+	if s.owner == db {
+		s.owner = nil
+		close(s.ended)
+	}
+	s.mu.Unlock()
+}
+
+// busyTimeout is how long to wait for another connection's transaction,
+// PRAGMA busy_timeout of the database.
+func (s *sharedDB) busyTimeout() time.Duration {
+	rows, err := s.cdb.Query("PRAGMA busy_timeout")
+	if err != nil {
+		return 0
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0
+	}
+	ms, _ := rows.Get(0).(int64)
+	return time.Duration(ms) * time.Millisecond
+}
+
+// lock serialises db's use of a shared database with the other
+// connections to it; see sharedDB. It does nothing for a private database.
+func (db *Database) lock() error {
+	if db.shared == nil {
+		return nil
+	}
+	return db.shared.acquire(db)
+}
+
+// unlock ends what lock began.
+func (db *Database) unlock() {
+	if db.shared != nil {
+		db.shared.release(db)
+	}
+}
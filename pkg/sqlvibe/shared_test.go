@@ -0,0 +1,79 @@
+package sqlvibe
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func openDSN(t *testing.T, dsn string) *Database {
+	t.Helper()
+	cfg, err := ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("ParseDSN(%q): %v", dsn, err)
+	}
+	db, err := OpenConfig(cfg)
+	if err != nil {
+		t.Fatalf("OpenConfig(%q): %v", dsn, err)
+	}
+	return db
+}
+
+func TestSharedMemory(t *testing.T) {
+	a := openDSN(t, "file:shared_a?mode=memory&cache=shared")
+	b := openDSN(t, "file:shared_a?mode=memory&cache=shared&_busy_timeout=50")
+	other := openDSN(t, "file:shared_b?mode=memory&cache=shared")
+	defer other.Close()
+
+	execOK(t, a, "CREATE TABLE t (x)", "INSERT INTO t VALUES (1)")
+	if got := queryAll(t, b, "SELECT x FROM t"); got != "1" {
+		t.Errorf("second connection sees %q", got)
+	}
+	if got := queryAll(t, other, "SELECT name FROM sqlite_master"); got != "" {
+		t.Errorf("a differently named database has tables %q", got)
+	}
+
+	// b cannot see, or wait out, a's uncommitted insert
+	execOK(t, a, "BEGIN", "INSERT INTO t VALUES (2)")
+	if _, err := b.Query("SELECT count(*) FROM t"); !errors.Is(err, ErrBusy) {
+		t.Errorf("query during another transaction: %v", err)
+	}
+	// PRAGMAs belong to the database, which only a may use for now
+	execOK(t, a, "PRAGMA busy_timeout = 5000")
+	done := make(chan string)
+	go func() {
+		rows, err := b.Query("SELECT count(*) FROM t")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- joinRow(rows.Data[0])
+	}()
+	time.Sleep(20 * time.Millisecond)
+	execOK(t, a, "INSERT INTO t VALUES (3)", "COMMIT")
+	if got := <-done; got != "3" {
+		t.Errorf("count after commit = %s, want 3", got)
+	}
+
+	// Closing a connection rolls back its open transaction
+	execOK(t, b, "BEGIN", "DELETE FROM t")
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := queryAll(t, a, "SELECT count(*) FROM t"); got != "3" {
+		t.Errorf("count after close = %s, want 3", got)
+	}
+
+	// The database lives while any connection does
+	c := openDSN(t, "file:shared_a?mode=memory&cache=shared")
+	a.Close()
+	if got := queryAll(t, c, "SELECT count(*) FROM t"); got != "3" {
+		t.Errorf("count with one connection left = %s", got)
+	}
+	c.Close()
+	d := openDSN(t, "file:shared_a?mode=memory&cache=shared")
+	defer d.Close()
+	if got := queryAll(t, d, "SELECT name FROM sqlite_master"); got != "" {
+		t.Errorf("the database outlived its last connection: %q", got)
+	}
+}
@@ -0,0 +1,128 @@
+package sqlvibe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONTable(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+
+	got := queryAll(t, db, `SELECT jt.n, jt.id, jt.name, jt.tag FROM JSON_TABLE(
+		'{"items":[{"id":1,"name":"pen","tags":["red","blue"]},{"id":2,"name":"ink"}]}', '$.items[*]'
+		COLUMNS (n FOR ORDINALITY, id INT PATH '$.id', name TEXT PATH '$.name',
+			NESTED PATH '$.tags[*]' COLUMNS (tag TEXT PATH '$'))) AS jt`)
+	if got != "1,1,pen,red|1,1,pen,blue|2,2,ink,<nil>" {
+		t.Errorf("unexpected JSON_TABLE rows: %s", got)
+	}
+
+	got = queryAll(t, db, `SELECT * FROM JSON_TABLE('[{"x":1,"y":{"z":2}},{"x":"2"}]', '$[*]'
+		COLUMNS (x INT, y JSON, has_z INT EXISTS PATH '$.y.z'))`)
+	if got != `1,{"z":2},1|2,<nil>,0` {
+		t.Errorf("unexpected column kinds: %s", got)
+	}
+
+	_, err := db.Query(`SELECT * FROM JSON_TABLE('{}', '$.a[' COLUMNS (x INT))`)
+	if err == nil || !strings.Contains(err.Error(), "JSON path") {
+		t.Errorf("expected a path syntax error, got %v", err)
+	}
+}
+
+func TestJSONTableLateral(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, doc TEXT)",
+		`INSERT INTO orders (doc) VALUES ('{"items":[{"id":1,"name":"pen","price":2.5,"tags":["red","blue"]},{"id":2,"name":"ink","price":12,"tags":[]}]}')`,
+		`INSERT INTO orders (doc) VALUES ('{"items":[{"id":3,"name":"pad","price":40}]}')`)
+
+	got := queryAll(t, db, `SELECT o.id, jt.name FROM orders o,
+		JSON_TABLE(o.doc, '$.items[*]' COLUMNS (name TEXT PATH '$.name')) jt ORDER BY jt.name`)
+	if got != "1,ink|2,pad|1,pen" {
+		t.Errorf("unexpected comma join rows: %s", got)
+	}
+
+	got = queryAll(t, db, `SELECT o.id, sum(jt.price) FROM orders o CROSS JOIN LATERAL
+		JSON_TABLE(o.doc, '$.items[*] ? (@.price > 10)' COLUMNS (price REAL PATH '$.price')) AS jt
+		GROUP BY o.id ORDER BY o.id`)
+	if got != "1,12|2,40" {
+		t.Errorf("unexpected filtered lateral rows: %s", got)
+	}
+}
+
+func TestJSONValue(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, doc TEXT)",
+		`INSERT INTO orders (doc) VALUES ('{"items":[{"id":1,"name":"pen","price":2.5,"tags":["red","blue"]},{"id":2,"name":"ink","price":12,"tags":[]}]}')`,
+		`INSERT INTO orders (doc) VALUES ('{"items":[{"id":3,"name":"pad","price":40}]}')`)
+
+	got := queryAll(t, db, `SELECT JSON_VALUE(doc, '$.items[0].name'),
+		JSON_VALUE(doc, '$.items[1].price' RETURNING INTEGER),
+		JSON_VALUE(doc, '$.items[0].name' RETURNING INTEGER DEFAULT -1 ON ERROR),
+		JSON_VALUE(doc, '$.items[0].missing' DEFAULT 'none' ON EMPTY),
+		JSON_VALUE(doc, '$.items[0].tags') FROM orders WHERE id = 1`)
+	if got != "pen,12,-1,none,<nil>" {
+		t.Errorf("unexpected JSON_VALUE results: %s", got)
+	}
+
+	_, err := db.Query(`SELECT JSON_VALUE('{"p":"x"}', '$.p' RETURNING INTEGER ERROR ON ERROR)`)
+	if err == nil || !strings.Contains(err.Error(), "integer") {
+		t.Errorf("expected a conversion error, got %v", err)
+	}
+}
+
+func TestJSONQueryAndExists(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, doc TEXT)",
+		`INSERT INTO orders (doc) VALUES ('{"items":[{"id":1,"name":"pen","price":2.5,"tags":["red","blue"]},{"id":2,"name":"ink","price":12,"tags":[]}]}')`,
+		`INSERT INTO orders (doc) VALUES ('{"items":[{"id":3,"name":"pad","price":40}]}')`)
+
+	got := queryAll(t, db, `SELECT JSON_QUERY(doc, '$.items[*].id' WITH WRAPPER),
+		JSON_QUERY(doc, '$.items[0].tags' WITH CONDITIONAL WRAPPER),
+		JSON_QUERY(doc, '$.items[0].name' WITH CONDITIONAL WRAPPER),
+		JSON_QUERY(doc, '$.items[0].name' OMIT QUOTES),
+		JSON_QUERY(doc, '$.nothing' EMPTY ARRAY ON EMPTY),
+		JSON_QUERY(doc, '$.items[*] ? (@.price > 10).name' WITH WRAPPER) FROM orders WHERE id = 1`)
+	want := `[1,2],["red","blue"],["pen"],pen,[],["ink"]`
+	if got != want {
+		t.Errorf("JSON_QUERY results = %s, want %s", got, want)
+	}
+
+	got = queryAll(t, db, `SELECT id FROM orders
+		WHERE JSON_EXISTS(doc, '$.items[*] ? (@.name starts with "pa" && @.price >= 40)')`)
+	if got != "2" {
+		t.Errorf("unexpected JSON_EXISTS rows: %s", got)
+	}
+	got = queryAll(t, db, `SELECT JSON_EXISTS('{"a":1}', '$.b'), JSON_EXISTS('{"a":1}', 'strict $.a.b'),
+		JSON_EXISTS('{"a":1}', 'strict $.a.b' UNKNOWN ON ERROR)`)
+	if got != "0,0,<nil>" {
+		t.Errorf("unexpected JSON_EXISTS results: %s", got)
+	}
+}
+
+func TestJSONArrowOperators(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, doc TEXT)",
+		`INSERT INTO orders (doc) VALUES ('{"items":[{"id":1,"name":"pen","price":2.5,"tags":["red","blue"]},{"id":2,"name":"ink","price":12,"tags":[]}]}')`,
+		`INSERT INTO orders (doc) VALUES ('{"items":[{"id":3,"name":"pad","price":40}]}')`)
+
+	got := queryAll(t, db, `SELECT doc -> '$.items[0].name', doc -> 'items' -> 0 ->> 'name',
+		doc -> 'items' ->> -1, '[1,2,3]' ->> 1, '{"ok":true}' ->> 'ok', typeof('[1.5]' ->> 0)
+		FROM orders WHERE id = 2`)
+	want := `"pad",pad,{"id":3,"name":"pad","price":40},2,1,real`
+	if got != want {
+		t.Errorf("arrow results = %s, want %s", got, want)
+	}
+
+	got = queryAll(t, db, "SELECT id FROM orders WHERE doc -> 'items' -> 0 ->> 'price' = 40")
+	if got != "2" {
+		t.Errorf("expected order 2, got %s", got)
+	}
+}
@@ -0,0 +1,263 @@
+package sqlvibe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func storageInfo(t *testing.T, db *Database, table string) map[string]interface{} {
+	t.Helper()
+	rows, err := db.Query("PRAGMA storage_info")
+	if err != nil {
+		t.Fatalf("PRAGMA storage_info: %v", err)
+	}
+	for _, r := range rows.Data {
+		if r[0] == table {
+			info := map[string]interface{}{}
+			for i, c := range rows.Columns {
+				info[c] = r[i]
+			}
+			return info
+		}
+	}
+	t.Fatalf("no storage_info row for %s", table)
+	return nil
+}
+
+func TestStoragePersistsDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "persist.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	execOK(t, db,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, score REAL, avatar BLOB)",
+		"CREATE UNIQUE INDEX users_name ON users(name)",
+		"CREATE TABLE log (msg TEXT)",
+		"CREATE TRIGGER users_ins AFTER INSERT ON users BEGIN INSERT INTO log VALUES ('added ' || NEW.name); END",
+		"CREATE VIEW top AS SELECT name FROM users WHERE score > 5",
+		"INSERT INTO users (name, score, avatar) VALUES ('ann', 9.5, x'00ff'), ('bob', NULL, NULL), ('cy', -3, x'')",
+		"DELETE FROM users WHERE name = 'bob'",
+	)
+	db = reopen(t, db, path)
+	defer db.Close()
+
+	if got := queryAll(t, db, "SELECT id, name, score, hex(avatar) FROM users ORDER BY id"); got != "1,ann,9.5,00FF|3,cy,-3," {
+		t.Errorf("unexpected rows after reopen: %s", got)
+	}
+	if got := queryAll(t, db, "SELECT msg FROM log ORDER BY msg"); got != "added ann|added bob|added cy" {
+		t.Errorf("unexpected trigger rows: %s", got)
+	}
+	if got := queryAll(t, db, "SELECT * FROM top"); got != "ann" {
+		t.Errorf("unexpected view rows: %s", got)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('ann')"); err == nil {
+		t.Error("expected the unique index to survive reopening")
+	}
+	execOK(t, db, "INSERT INTO users (name) VALUES ('dee')")
+	if got := queryAll(t, db, "SELECT id FROM users WHERE name = 'dee'"); got != "4" {
+		t.Errorf("expected rowids to continue after reopen, got %s", got)
+	}
+	if got := queryAll(t, db, "SELECT msg FROM log WHERE msg = 'added dee'"); got != "added dee" {
+		t.Errorf("expected the trigger to fire after reopen, got %s", got)
+	}
+}
+
+func TestStorageTableCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	execOK(t, db,
+		"CREATE TABLE archive (id INTEGER PRIMARY KEY, body TEXT) WITH (compression='zstd')",
+		"CREATE TABLE plain (id INTEGER PRIMARY KEY, body TEXT)",
+	)
+	for i := 0; i < 300; i++ {
+		execOK(t, db,
+			"INSERT INTO archive (body) VALUES ('status=OK component=billing message=nightly archive job completed')",
+			"INSERT INTO plain (body) VALUES ('status=OK component=billing message=nightly archive job completed')")
+	}
+
+	info := storageInfo(t, db, "archive")
+	if info["compression"] != "ZSTD" {
+		t.Errorf("archive compression = %v, want ZSTD", info["compression"])
+	}
+	if ratio := info["compression_ratio"].(float64); ratio < 5 {
+		t.Errorf("expected repetitive text to compress well, ratio %.2f", ratio)
+	}
+	if info["stored_bytes"].(int64) >= info["raw_bytes"].(int64) {
+		t.Errorf("stored %v bytes of %v raw", info["stored_bytes"], info["raw_bytes"])
+	}
+	plain := storageInfo(t, db, "plain")
+	if plain["compression"] != "NONE" || plain["compression_ratio"].(float64) > 1 {
+		t.Errorf("uncompressed table reports %v at ratio %v", plain["compression"], plain["compression_ratio"])
+	}
+
+	db = reopen(t, db, path)
+	defer db.Close()
+	if got := queryAll(t, db, "SELECT count(*), count(DISTINCT body), max(id) FROM archive"); got != "300,1,300" {
+		t.Errorf("unexpected archive contents after reopen: %s", got)
+	}
+	if storageInfo(t, db, "archive")["compression"] != "ZSTD" {
+		t.Error("expected the table codec to survive reopening")
+	}
+
+	if _, err := db.Exec("CREATE TABLE bad (a TEXT) WITH (compression='snappy')"); err == nil {
+		t.Error("expected an error for an unsupported codec")
+	}
+	if _, err := db.Exec("CREATE TABLE bad (a TEXT) WITH (fillfactor=70)"); err == nil {
+		t.Error("expected an error for an unknown storage parameter")
+	}
+}
+
+func TestStorageDefaultCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "default.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, algo := range []string{"NONE", "RLE", "LZ4", "ZSTD", "GZIP"} {
+		if _, err := db.Query("PRAGMA compression = '" + algo + "'"); err != nil {
+			t.Fatalf("PRAGMA compression = %s: %v", algo, err)
+		}
+	}
+	if _, err := db.Query("PRAGMA compression = 'SNAPPY'"); err == nil {
+		t.Error("expected an error for an unknown compression algorithm")
+	}
+	execOK(t, db,
+		"PRAGMA compression = rle",
+		"CREATE TABLE pad (v TEXT)",
+		"INSERT INTO pad VALUES ('"+strings.Repeat("-", 2000)+"')",
+	)
+	info := storageInfo(t, db, "pad")
+	if info["compression"] != "RLE" || info["compression_ratio"].(float64) < 10 {
+		t.Errorf("RLE default reports %v at ratio %v", info["compression"], info["compression_ratio"])
+	}
+
+	db = reopen(t, db, path)
+	defer db.Close()
+	if got := queryAll(t, db, "PRAGMA compression"); got != "RLE" {
+		t.Errorf("PRAGMA compression after reopen = %s, want RLE", got)
+	}
+	if got := queryAll(t, db, "SELECT length(v) FROM pad"); got != "2000" {
+		t.Errorf("unexpected value after reopen: %s", got)
+	}
+}
+
+func TestStorageRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	execOK(t, db, "CREATE TABLE t (v TEXT)", "INSERT INTO t VALUES ('hello')")
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if db, err := Open(path); err == nil {
+		db.Close()
+		t.Error("expected opening a corrupt file to fail")
+	}
+
+	if err := os.WriteFile(path, []byte("not a database at all"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if db, err := Open(path); err == nil {
+		db.Close()
+		t.Error("expected opening a foreign file to fail")
+	}
+}
+
+func TestStorageSkipsRewriteAfterReads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reads.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	execOK(t, db, "CREATE TABLE t (a INTEGER)", "INSERT INTO t VALUES (1)")
+	db = reopen(t, db, path)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Statements that change nothing leave the file as it is
+	execOK(t, db, "SELECT count(*) FROM t", "UPDATE t SET a = 2 WHERE a > 5", "DELETE FROM t WHERE a IS NULL", "BEGIN", "COMMIT")
+	queryAll(t, db, "SELECT a FROM t")
+	db = reopen(t, db, path)
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("database file was rewritten although no row changed")
+	}
+
+	execOK(t, db, "UPDATE t SET a = 2")
+	db = reopen(t, db, path)
+	defer db.Close()
+	if after, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("database file was not rewritten after an UPDATE")
+	}
+	if got := queryAll(t, db, "SELECT a FROM t"); got != "2" {
+		t.Errorf("unexpected rows after reopen: %s", got)
+	}
+}
+
+func TestStorageCommitIsDurableWithoutWAL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "durable.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	// A copy of the file taken while db is open stands in for a crash
+	crashCopy := func() *Database {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp := filepath.Join(dir, "crash.db")
+		if err := os.WriteFile(cp, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		c, err := Open(cp)
+		if err != nil {
+			t.Fatalf("open crash copy: %v", err)
+		}
+		return c
+	}
+
+	execOK(t, db, "PRAGMA journal_mode = DELETE",
+		"CREATE TABLE t (a INTEGER)", "INSERT INTO t VALUES (1)", "BEGIN", "INSERT INTO t VALUES (2)")
+	c := crashCopy()
+	if got := queryAll(t, c, "SELECT a FROM t"); got != "1" {
+		t.Errorf("after autocommit, with a transaction open: %s", got)
+	}
+	c.Close()
+
+	execOK(t, db, "COMMIT")
+	c = crashCopy()
+	if got := queryAll(t, c, "SELECT a FROM t ORDER BY a"); got != "1|2" {
+		t.Errorf("after COMMIT: %s", got)
+	}
+	c.Close()
+}
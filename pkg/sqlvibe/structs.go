@@ -0,0 +1,143 @@
+package sqlvibe
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// structFields caches fieldIndexes by struct type.
+var structFields sync.Map // reflect.Type -> map[string][]int
+
+// fieldIndexes maps the upper-cased column name of each field of struct
+// type t to its index path. A field's column is its `db:"name"` tag, or
+// its name when untagged; `db:"-"` skips it. Fields of embedded structs
+// are promoted, shallower fields winning as in Go.
+func fieldIndexes(t reflect.Type) map[string][]int {
+	if m, ok := structFields.Load(t); ok {
+		return m.(map[string][]int)
+	}
+	m := make(map[string][]int)
+	depth := make(map[string]int)
+	var walk func(t reflect.Type, prefix []int)
+	walk = func(t reflect.Type, prefix []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag, tagged := f.Tag.Lookup("db")
+			if tag == "-" {
+				continue
+			}
+			index := append(append([]int(nil), prefix...), i)
+			ft := f.Type
+			if f.Anonymous && !tagged {
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct && ft != timeType {
+					walk(ft, index)
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			name := f.Name
+			if tag = strings.Split(tag, ",")[0]; tag != "" {
+				name = tag
+			}
+			key := strings.ToUpper(name)
+			if d, seen := depth[key]; seen && d <= len(index) {
+				continue
+			}
+			m[key] = index
+			depth[key] = len(index)
+		}
+	}
+	walk(t, nil)
+	structFields.Store(t, m)
+	return m
+}
+
+// ScanStruct copies the current row into the struct dst points to,
+// matching columns to fields by `db` tag or, failing that, by field name,
+// case-insensitively. A column with no matching field is an error; fields
+// with no column are left as they were.
+func (r *Rows) ScanStruct(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ScanStruct: destination must be a non-nil pointer to a struct, not %T", dst)
+	}
+	v = v.Elem()
+	fields := fieldIndexes(v.Type())
+	dest := make([]interface{}, len(r.Columns))
+	for i, col := range r.Columns {
+		index, ok := fields[strings.ToUpper(col)]
+		if !ok {
+			return fmt.Errorf("ScanStruct: no field of %s for column %q", v.Type(), col)
+		}
+		f, err := fieldByIndexAlloc(v, index)
+		if err != nil {
+			return fmt.Errorf("ScanStruct: column %q: %w", col, err)
+		}
+		dest[i] = f.Addr().Interface()
+	}
+	return r.Scan(dest...)
+}
+
+// fieldByIndexAlloc is reflect.Value.FieldByIndex, allocating nil embedded
+// struct pointers on the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// QueryStructs runs a query with positional parameters and scans every row
+// into a T, which must be a struct type; see Rows.ScanStruct.
+func QueryStructs[T any](db *Database, query string, args ...interface{}) ([]T, error) {
+	rows, err := db.QueryWithParams(query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]T, 0, len(rows.Data))
+	for rows.Next() {
+		var v T
+		if err := rows.ScanStruct(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// QueryOne runs a query with positional parameters and scans its first row
+// into a T. It returns sql.ErrNoRows when the query has no rows.
+func QueryOne[T any](db *Database, query string, args ...interface{}) (T, error) {
+	var v T
+	rows, err := db.QueryWithParams(query, args)
+	if err != nil {
+		return v, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return v, err
+		}
+		return v, sql.ErrNoRows
+	}
+	err = rows.ScanStruct(&v)
+	return v, err
+}
@@ -0,0 +1,187 @@
+package sqlvibe
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// TimeFormat selects how time.Time parameters are stored. Every format is
+// one the engine's date functions read back: TEXT directly, numbers as a
+// julian day or with the 'unixepoch' modifier.
+type TimeFormat int
+
+const (
+	// TimeFormatText stores ISO-8601 text, "2006-01-02 15:04:05.999999999-07:00".
+	TimeFormatText TimeFormat = iota
+	// TimeFormatUnix stores INTEGER seconds since 1970-01-01 UTC.
+	TimeFormatUnix
+	// TimeFormatUnixMilli stores INTEGER milliseconds since 1970-01-01 UTC.
+	TimeFormatUnixMilli
+	// TimeFormatJulianDay stores a REAL julian day number.
+	TimeFormatJulianDay
+)
+
+var timeFormatNames = map[TimeFormat]string{
+	TimeFormatText:      "text",
+	TimeFormatUnix:      "unix",
+	TimeFormatUnixMilli: "unixmilli",
+	TimeFormatJulianDay: "julianday",
+}
+
+func (f TimeFormat) String() string {
+	if name, ok := timeFormatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("TimeFormat(%d)", int(f))
+}
+
+// ParseTimeFormat parses the name of a TimeFormat, as the _time_format DSN
+// option gives it: "text" (or "iso8601"), "unix", "unixmilli" or
+// "julianday" (or "julian").
+func ParseTimeFormat(s string) (TimeFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "text", "iso8601":
+		return TimeFormatText, nil
+	case "unix", "unixepoch":
+		return TimeFormatUnix, nil
+	case "unixmilli", "unix_ms":
+		return TimeFormatUnixMilli, nil
+	case "julianday", "julian":
+		return TimeFormatJulianDay, nil
+	}
+	return 0, fmt.Errorf("unknown time format %q", s)
+}
+
+// timeFormats are the layouts TEXT is parsed with when read as a time,
+// most specific first. timeFormats[0] is the layout TimeFormatText writes.
+var timeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// julianUnixEpoch is the julian day of 1970-01-01 00:00:00 UTC.
+const julianUnixEpoch = 2440587.5
+
+// timeOptions says how times are written and read back. The zero value
+// writes text in UTC.
+type timeOptions struct {
+	format TimeFormat
+	loc    *time.Location // nil means UTC
+}
+
+func (o timeOptions) location() *time.Location {
+	if o.loc == nil {
+		return time.UTC
+	}
+	return o.loc
+}
+
+// bind returns t as o.format stores it. Text is written in o's location,
+// so stored timestamps compare as text in the order they occurred.
+func (o timeOptions) bind(t time.Time) interface{} {
+	switch o.format {
+	case TimeFormatUnix:
+		return t.Unix()
+	case TimeFormatUnixMilli:
+		return t.UnixMilli()
+	case TimeFormatJulianDay:
+		return julianUnixEpoch + float64(t.UnixNano())/float64(24*time.Hour)
+	}
+	return t.In(o.location()).Format(timeFormats[0])
+}
+
+// parse reads a column value as a time in o's location: TEXT in one of
+// timeFormats, zone-less text taken to be in that location and a trailing
+// "Z" meaning UTC; INTEGER as seconds, or milliseconds under
+// TimeFormatUnixMilli; REAL as a julian day under TimeFormatJulianDay and
+// fractional seconds otherwise.
+func (o timeOptions) parse(src interface{}) (time.Time, error) {
+	loc := o.location()
+	switch v := src.(type) {
+	case time.Time:
+		return v.In(loc), nil
+	case int64:
+		if o.format == TimeFormatUnixMilli {
+			return time.UnixMilli(v).In(loc), nil
+		}
+		return time.Unix(v, 0).In(loc), nil
+	case float64:
+		if o.format == TimeFormatJulianDay {
+			return time.Unix(0, int64(math.Round((v-julianUnixEpoch)*float64(24*time.Hour)))).In(loc), nil
+		}
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))).In(loc), nil
+	case []byte:
+		return o.parse(string(v))
+	case string:
+		s := strings.TrimSpace(v)
+		in := loc
+		if strings.HasSuffix(s, "Z") || strings.HasSuffix(s, "z") {
+			s, in = s[:len(s)-1], time.UTC
+		}
+		for _, layout := range timeFormats {
+			if t, err := time.ParseInLocation(layout, s, in); err == nil {
+				return t.In(loc), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("converting %q to time.Time: unrecognized format", v)
+	case nil:
+		return time.Time{}, fmt.Errorf("converting NULL to time.Time is unsupported")
+	}
+	return time.Time{}, fmt.Errorf("converting %T to time.Time is unsupported", src)
+}
+
+// stores reports whether src is a value o writes times as: text, or a
+// number under a numeric format. Numbers in a DATE column under the text
+// format, such as CAST('2024-01-15' AS DATE), stay numbers.
+func (o timeOptions) stores(src interface{}) bool {
+	switch src.(type) {
+	case string, []byte:
+		return true
+	case int64, float64:
+		return o.format != TimeFormatText
+	}
+	return false
+}
+
+// isTimeType reports whether a declared type names a date or time column,
+// whose values scan into an interface{} as time.Time.
+func isTimeType(decl string) bool {
+	switch (ColumnType{DeclType: decl}).DatabaseTypeName() {
+	case "DATE", "DATETIME", "TIMESTAMP":
+		return true
+	}
+	return false
+}
+
+// SetTimeFormat sets how time.Time parameters are stored; the default is
+// TimeFormatText. Numbers read back as times are taken to be in the same
+// format.
+func (db *Database) SetTimeFormat(f TimeFormat) {
+	o := db.timeOptions()
+	o.format = f
+	db.timeOpts.Store(&o)
+}
+
+// SetLocation sets the location times are written in as text and read
+// back in, and that zone-less text is taken to be in. The default, nil, is
+// UTC.
+func (db *Database) SetLocation(loc *time.Location) {
+	o := db.timeOptions()
+	o.loc = loc
+	db.timeOpts.Store(&o)
+}
+
+func (db *Database) timeOptions() timeOptions {
+	if o := db.timeOpts.Load(); o != nil {
+		return *o
+	}
+	return timeOptions{}
+}
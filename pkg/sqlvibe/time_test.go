@@ -0,0 +1,112 @@
+package sqlvibe
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeFormats(t *testing.T) {
+	when := time.Date(2024, 3, 5, 6, 7, 8, 250e6, time.FixedZone("CET", 3600))
+	cases := []struct {
+		format TimeFormat
+		stored string
+	}{
+		{TimeFormatText, "2024-03-05 05:07:08.25+00:00"},
+		{TimeFormatUnix, "1709615228"},
+		{TimeFormatUnixMilli, "1709615228250"},
+		{TimeFormatJulianDay, "2.4603747132e+06"},
+	}
+	for _, c := range cases {
+		t.Run(c.format.String(), func(t *testing.T) {
+			db, err := Open(":memory:")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer db.Close()
+			db.SetTimeFormat(c.format)
+			execOK(t, db, "CREATE TABLE ev (at DATETIME, note TEXT)")
+			if _, err := db.ExecWithParams("INSERT INTO ev VALUES (?, 'x')", []interface{}{when}); err != nil {
+				t.Fatalf("insert: %v", err)
+			}
+			if c.format != TimeFormatJulianDay {
+				if got := queryAll(t, db, "SELECT at FROM ev"); got != c.stored {
+					t.Errorf("stored %s, want %s", got, c.stored)
+				}
+			}
+			// The engine's date functions read every format back
+			mods := map[TimeFormat]string{TimeFormatUnix: ", 'unixepoch'"}
+			if c.format != TimeFormatUnixMilli {
+				if got := queryAll(t, db, "SELECT datetime(at"+mods[c.format]+") FROM ev"); got != "2024-03-05 05:07:08" {
+					t.Errorf("datetime(at) = %s", got)
+				}
+			}
+
+			var back time.Time
+			rows, _ := db.Query("SELECT at FROM ev")
+			rows.Next()
+			if err := rows.Scan(&back); err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			tolerance := time.Duration(0)
+			switch c.format {
+			case TimeFormatUnix:
+				tolerance = time.Second
+			case TimeFormatJulianDay:
+				tolerance = time.Millisecond
+			}
+			if back.Sub(when).Abs() > tolerance || back.Location() != time.UTC {
+				t.Errorf("scanned %v, want %v", back, when)
+			}
+			// A DATETIME column scans into an interface{} as a time.Time
+			var at, note interface{}
+			rows, _ = db.Query("SELECT at, note FROM ev")
+			rows.Next()
+			if err := rows.Scan(&at, &note); err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if _, ok := at.(time.Time); !ok {
+				t.Errorf("DATETIME column scanned as %T", at)
+			}
+			if note != "x" {
+				t.Errorf("TEXT column scanned as %#v", note)
+			}
+		})
+	}
+}
+
+func TestTimeLocation(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	tokyo := time.FixedZone("JST", 9*3600)
+	db.SetLocation(tokyo)
+	execOK(t, db, "CREATE TABLE ev (at TIMESTAMP, day DATE)",
+		"INSERT INTO ev VALUES ('2024-03-05 09:00:00', '2024-03-05')")
+	when := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	if _, err := db.ExecWithParams("INSERT INTO ev VALUES (?, NULL)", []interface{}{when}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	// Written in the location, with its offset
+	if got := queryAll(t, db, "SELECT at FROM ev WHERE day IS NULL"); got != "2024-03-05 09:00:00+09:00" {
+		t.Errorf("stored %s", got)
+	}
+	// Zone-less text is taken to be in the location
+	var at time.Time
+	var day interface{}
+	rows, _ := db.Query("SELECT at, day FROM ev WHERE day IS NOT NULL")
+	rows.Next()
+	if err := rows.Scan(&at, &day); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !at.Equal(when) || at.Location() != tokyo {
+		t.Errorf("at = %v, want %v in JST", at, when)
+	}
+	if d, ok := day.(time.Time); !ok || d.Day() != 5 || d.Location() != tokyo {
+		t.Errorf("day = %#v", day)
+	}
+	if _, err := ParseTimeFormat("fortnights"); err == nil {
+		t.Error("ParseTimeFormat accepted an unknown format")
+	}
+}
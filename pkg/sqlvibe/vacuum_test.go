@@ -0,0 +1,276 @@
+package sqlvibe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fillRows inserts n rows into a new table.
+func fillRows(t *testing.T, db *Database, table string, n int) {
+	t.Helper()
+	execOK(t, db, "CREATE TABLE "+table+" (id INTEGER PRIMARY KEY, body TEXT)")
+	for b := 0; b < n; b += 500 {
+		var vals []string
+		for i := b; i < b+500 && i < n; i++ {
+			vals = append(vals, fmt.Sprintf("('%s')", strings.Repeat("x", 40+i%7)))
+		}
+		execOK(t, db, "INSERT INTO "+table+" (body) VALUES "+strings.Join(vals, ","))
+	}
+}
+
+// fillAndDelete inserts n rows into a new table and deletes all but every
+// tenth.
+func fillAndDelete(t *testing.T, db *Database, table string, n int) {
+	t.Helper()
+	fillRows(t, db, table, n)
+	execOK(t, db, "DELETE FROM "+table+" WHERE id % 10 <> 0")
+}
+
+// freePages reads PRAGMA freelist_count.
+func freePages(t *testing.T, db *Database) int {
+	t.Helper()
+	got := queryAll(t, db, "PRAGMA freelist_count")
+	n, err := strconv.Atoi(got)
+	if err != nil {
+		t.Fatalf("PRAGMA freelist_count = %q", got)
+	}
+	return n
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Size()
+}
+
+func TestVacuumCompactsTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vacuum.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	execOK(t, db, "PRAGMA journal_mode = DELETE")
+	if got := freePages(t, db); got != 0 {
+		t.Errorf("freelist_count of an empty database = %d", got)
+	}
+	fillAndDelete(t, db, "t", 3000)
+	free := freePages(t, db)
+	if free == 0 {
+		t.Fatal("deleting rows left no free pages")
+	}
+	size := fileSize(t, path)
+	const lookup = "SELECT group_concat(id) FROM t WHERE body = 'xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx'"
+	execOK(t, db, "CREATE INDEX t_body ON t(body)")
+	before := queryAll(t, db, lookup)
+	execOK(t, db, "VACUUM")
+	if got := freePages(t, db); got != 0 {
+		t.Errorf("freelist_count after VACUUM = %d, was %d", got, free)
+	}
+	if got := fileSize(t, path); got >= size {
+		t.Errorf("VACUUM left the file at %d bytes, was %d", got, size)
+	}
+	if got := queryAll(t, db, "SELECT count(*), min(id), max(id) FROM t"); got != "300,10,3000" {
+		t.Errorf("unexpected rows after VACUUM: %s", got)
+	}
+	if got := queryAll(t, db, lookup); got != before || got == "" {
+		t.Errorf("index lookup after VACUUM = %s, before %s", got, before)
+	}
+	// VACUUM writes the file at once
+	copied, err := Open(crashCopy(t, path))
+	if err != nil {
+		t.Fatalf("open vacuumed file: %v", err)
+	}
+	defer copied.Close()
+	if got := queryAll(t, copied, "SELECT count(*) FROM t"); got != "300" {
+		t.Errorf("unexpected rows in the vacuumed file: %s", got)
+	}
+
+	for _, sql := range []string{"VACUUM other", "VACUUM main extra", "VACUUM INTO 42"} {
+		if _, err := db.Exec(sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+	execOK(t, db, "BEGIN")
+	if _, err := db.Exec("VACUUM"); err == nil || !strings.Contains(err.Error(), "within a transaction") {
+		t.Errorf("VACUUM in a transaction: %v", err)
+	}
+	execOK(t, db, "ROLLBACK", "VACUUM main")
+}
+
+func TestFreelistKeptInFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "free.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	execOK(t, db, "PRAGMA journal_mode = DELETE", "PRAGMA auto_vacuum = INCREMENTAL")
+	fillRows(t, db, "t", 1500)
+	full := fileSize(t, path)
+	execOK(t, db, "DELETE FROM t WHERE id > 100")
+	if got := fileSize(t, path); got < full {
+		t.Errorf("deleting rows shrank the file from %d to %d bytes", full, got)
+	}
+	free := freePages(t, db)
+	if free < 4 {
+		t.Fatalf("freelist_count = %d after deleting 1400 of 1500 rows", free)
+	}
+	db.Close()
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	if got := freePages(t, db); got != free {
+		t.Errorf("freelist_count after reopening = %d, was %d", got, free)
+	}
+	execOK(t, db, "PRAGMA incremental_vacuum(2)")
+	if got := freePages(t, db); got != free-2 {
+		t.Errorf("freelist_count after incremental_vacuum(2) = %d, want %d", got, free-2)
+	}
+	shrunk := fileSize(t, path)
+	if shrunk >= full {
+		t.Errorf("incremental_vacuum(2) left the file at %d bytes, was %d", shrunk, full)
+	}
+
+	// New rows take free pages before the file grows
+	row := "('" + strings.Repeat("y", 45) + "')"
+	execOK(t, db, "INSERT INTO t (body) VALUES "+strings.Repeat(row+",", 299)+row)
+	if got := freePages(t, db); got >= free-2 || got == 0 {
+		t.Errorf("freelist_count after refilling = %d, was %d", got, free-2)
+	}
+	if got := fileSize(t, path); got > shrunk+64 {
+		t.Errorf("refilling free pages grew the file from %d to %d bytes", shrunk, got)
+	}
+
+	// In WAL mode the pages a commit frees count before the checkpoint
+	execOK(t, db, "PRAGMA journal_mode = WAL", "PRAGMA incremental_vacuum")
+	if got := freePages(t, db); got != 0 {
+		t.Errorf("freelist_count after incremental_vacuum = %d", got)
+	}
+	execOK(t, db, "DELETE FROM t WHERE id > 50")
+	free = freePages(t, db)
+	if free == 0 {
+		t.Fatal("deleting rows in WAL mode left no free pages")
+	}
+	copied, err := Open(crashCopy(t, path))
+	if err != nil {
+		t.Fatalf("open crashed copy: %v", err)
+	}
+	defer copied.Close()
+	if got := freePages(t, copied); got != free {
+		t.Errorf("freelist_count after replaying the log = %d, want %d", got, free)
+	}
+	if got := queryAll(t, copied, "SELECT count(*) FROM t"); got != "50" {
+		t.Errorf("unexpected rows after replaying the log: %s", got)
+	}
+}
+
+func TestVacuumInto(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "source.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	execOK(t, db, "PRAGMA journal_mode = DELETE")
+	fillAndDelete(t, db, "t", 1000)
+	execOK(t, db,
+		"CREATE VIEW tens AS SELECT id FROM t WHERE id % 100 = 0",
+		"BEGIN",
+		"INSERT INTO t (body) VALUES ('uncommitted')",
+	)
+	dir := t.TempDir()
+	target := filepath.Join(dir, "it's.db")
+	if _, err := db.Exec("VACUUM INTO '" + strings.ReplaceAll(target, "'", "''") + "'"); err == nil {
+		t.Error("expected VACUUM INTO to fail inside a transaction")
+	}
+	execOK(t, db, "ROLLBACK")
+	fillAndDelete(t, db, "u", 3000)
+	free := freePages(t, db)
+	execOK(t, db, "VACUUM INTO '"+strings.ReplaceAll(target, "'", "''")+"'")
+	if _, err := db.Exec("VACUUM main INTO '" + strings.ReplaceAll(target, "'", "''") + "'"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("VACUUM INTO an existing file: %v", err)
+	}
+
+	copied, err := Open(target)
+	if err != nil {
+		t.Fatalf("open VACUUM INTO copy: %v", err)
+	}
+	defer copied.Close()
+	if got := queryAll(t, copied, "SELECT count(*), max(id), (SELECT count(*) FROM u) FROM t"); got != "100,1000,300" {
+		t.Errorf("unexpected rows in the copy: %s", got)
+	}
+	if got := queryAll(t, copied, "SELECT count(*) FROM tens"); got != "10" {
+		t.Errorf("unexpected view in the copy: %s", got)
+	}
+	if got := freePages(t, copied); got != 0 {
+		t.Errorf("freelist_count of the copy = %d", got)
+	}
+	// The source is left as it was
+	if got := freePages(t, db); got != free || free == 0 {
+		t.Errorf("freelist_count of the source went from %d to %d", free, got)
+	}
+}
+
+func TestAutoVacuum(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "auto.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	execOK(t, db, "PRAGMA journal_mode = DELETE", "PRAGMA auto_vacuum = INCREMENTAL")
+	if got := queryAll(t, db, "PRAGMA auto_vacuum"); got != "2" {
+		t.Errorf("auto_vacuum = %s, want 2", got)
+	}
+	fillAndDelete(t, db, "t", 3000)
+	free := freePages(t, db)
+	if free < 4 {
+		t.Fatalf("freelist_count = %d after deletes", free)
+	}
+	execOK(t, db, "PRAGMA incremental_vacuum(3)")
+	if got := freePages(t, db); got != free-3 {
+		t.Errorf("freelist_count after incremental_vacuum(3) = %d, want %d", got, free-3)
+	}
+	execOK(t, db, "PRAGMA incremental_vacuum")
+	if got := freePages(t, db); got != 0 {
+		t.Errorf("freelist_count after incremental_vacuum = %d", got)
+	}
+
+	execOK(t, db, "PRAGMA auto_vacuum = FULL", "DELETE FROM t WHERE id > 1000")
+	if got := queryAll(t, db, "PRAGMA auto_vacuum"); got != "1" {
+		t.Errorf("auto_vacuum = %s, want 1", got)
+	}
+	if got := freePages(t, db); got != 0 {
+		t.Errorf("freelist_count with auto_vacuum=FULL = %d", got)
+	}
+
+	execOK(t, db, "PRAGMA auto_vacuum = NONE")
+	fillAndDelete(t, db, "u", 3000)
+	free = freePages(t, db)
+	execOK(t, db, "PRAGMA incremental_vacuum")
+	if got := freePages(t, db); got != free || free == 0 {
+		t.Errorf("incremental_vacuum reclaimed pages without auto_vacuum=INCREMENTAL: %d -> %d", free, got)
+	}
+	if got := queryAll(t, db, "SELECT count(*) FROM u"); got != "300" {
+		t.Errorf("unexpected rows: %s", got)
+	}
+
+	// An in-memory database has no file to keep free pages in
+	mem, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer mem.Close()
+	fillAndDelete(t, mem, "t", 3000)
+	if got := freePages(t, mem); got != 0 {
+		t.Errorf("freelist_count of an in-memory database = %d", got)
+	}
+}
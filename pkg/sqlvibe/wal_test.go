@@ -0,0 +1,295 @@
+package sqlvibe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWALReplaysCommitsAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.db")
+	db, _ := Open(path)
+	if got := queryAll(t, db, "PRAGMA journal_mode = WAL"); got != "WAL" {
+		t.Fatalf("journal_mode = %s", got)
+	}
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE kv (k TEXT PRIMARY KEY, v INTEGER)",
+		"CREATE INDEX kv_v ON kv(v)",
+		"INSERT INTO kv VALUES ('a', 1), ('b', 2), ('c', 3)",
+		"UPDATE kv SET v = 20 WHERE k = 'b'",
+		"DELETE FROM kv WHERE k = 'a'",
+		"BEGIN",
+		"INSERT INTO kv VALUES ('d', 4)",
+		"ALTER TABLE kv ADD COLUMN note TEXT",
+		"COMMIT",
+		"CREATE TABLE gone (x)",
+		"INSERT INTO gone VALUES (1)",
+		"DROP TABLE gone",
+	)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO kv VALUES ('e', 5, 'api')"); err != nil {
+		t.Fatalf("tx insert: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	// Uncommitted work is not logged
+	execOK(t, db, "BEGIN", "INSERT INTO kv VALUES ('z', 26, NULL)")
+
+	if info, err := os.Stat(path + "-wal"); err != nil || info.Size() <= 32 {
+		t.Fatalf("expected frames in the log: %v", err)
+	}
+	crashed, err := Open(crashCopy(t, path))
+	if err != nil {
+		t.Fatalf("open after crash: %v", err)
+	}
+	defer crashed.Close()
+	if got := queryAll(t, crashed, "SELECT k, v, note FROM kv ORDER BY k"); got != "b,20,<nil>|c,3,<nil>|d,4,<nil>|e,5,api" {
+		t.Errorf("unexpected rows after replay: %s", got)
+	}
+	if got := queryAll(t, crashed, "SELECT name FROM sqlite_master WHERE name IN ('gone', 'kv_v')"); got != "kv_v" {
+		t.Errorf("unexpected catalog after replay: %s", got)
+	}
+	if got := queryAll(t, crashed, "PRAGMA journal_mode"); got != "WAL" {
+		t.Errorf("journal_mode after replay = %s", got)
+	}
+	execOK(t, crashed, "INSERT INTO kv VALUES ('f', 6, NULL)")
+	if got := queryAll(t, crashed, "SELECT count(*) FROM kv"); got != "5" {
+		t.Errorf("unexpected count after writing to the recovered database: %s", got)
+	}
+}
+
+func TestNewFileStartsInWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got := queryAll(t, db, "PRAGMA journal_mode"); got != "WAL" {
+		t.Errorf("journal_mode of a new file = %s", got)
+	}
+	execOK(t, db, "CREATE TABLE t (a)", "INSERT INTO t VALUES (1)")
+	crashed, err := Open(crashCopy(t, path))
+	if err != nil {
+		t.Fatalf("open after crash: %v", err)
+	}
+	if got := queryAll(t, crashed, "SELECT a FROM t"); got != "1" {
+		t.Errorf("unexpected rows after replay: %s", got)
+	}
+	crashed.Close()
+
+	// A file switched out of WAL mode stays out of it
+	execOK(t, db, "PRAGMA journal_mode = DELETE")
+	db = reopen(t, db, path)
+	defer db.Close()
+	if got := queryAll(t, db, "PRAGMA journal_mode"); got != "DELETE" {
+		t.Errorf("journal_mode after reopening = %s", got)
+	}
+	if _, err := os.Stat(path + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("log of a DELETE mode database: %v", err)
+	}
+}
+
+func TestWALDiscardsTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.db")
+	db, _ := Open(path)
+	if got := queryAll(t, db, "PRAGMA journal_mode = WAL"); got != "WAL" {
+		t.Fatalf("journal_mode = %s", got)
+	}
+	defer db.Close()
+	execOK(t, db, "CREATE TABLE t (n INTEGER)")
+	for _, n := range []string{"1", "2", "3"} {
+		execOK(t, db, "INSERT INTO t VALUES ("+n+")")
+	}
+	before, err := os.Stat(path + "-wal")
+	if err != nil {
+		t.Fatalf("stat log: %v", err)
+	}
+	execOK(t, db, "INSERT INTO t VALUES (4)")
+
+	// The last frame is cut short
+	torn := crashCopy(t, path)
+	log, _ := os.ReadFile(torn + "-wal")
+	cut := before.Size() + (int64(len(log))-before.Size())/2
+	if err := os.WriteFile(torn+"-wal", log[:cut], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	crashed, err := Open(torn)
+	if err != nil {
+		t.Fatalf("open with a torn log: %v", err)
+	}
+	if got := queryAll(t, crashed, "SELECT group_concat(n) FROM t"); got != "1,2,3" {
+		t.Errorf("unexpected rows with a torn tail: %s", got)
+	}
+	if info, _ := os.Stat(torn + "-wal"); info.Size() != before.Size() {
+		t.Errorf("torn tail not truncated: %d bytes, want %d", info.Size(), before.Size())
+	}
+	crashed.Close()
+
+	// The last frame is complete but corrupt
+	bad := crashCopy(t, path)
+	log, _ = os.ReadFile(bad + "-wal")
+	log[len(log)-12] ^= 0xFF
+	if err := os.WriteFile(bad+"-wal", log, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	crashed, err = Open(bad)
+	if err != nil {
+		t.Fatalf("open with a corrupt frame: %v", err)
+	}
+	defer crashed.Close()
+	if got := queryAll(t, crashed, "SELECT group_concat(n) FROM t"); got != "1,2,3" {
+		t.Errorf("unexpected rows with a corrupt frame: %s", got)
+	}
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.db")
+	db, _ := Open(path)
+	if got := queryAll(t, db, "PRAGMA journal_mode = WAL"); got != "WAL" {
+		t.Fatalf("journal_mode = %s", got)
+	}
+	execOK(t, db,
+		"CREATE TABLE t (n INTEGER)",
+		"INSERT INTO t VALUES (1)",
+		"INSERT INTO t VALUES (2)",
+	)
+	if got := queryAll(t, db, "PRAGMA wal_checkpoint(PASSIVE)"); got != "0,3,3" {
+		t.Errorf("passive checkpoint = %s", got)
+	}
+	if info, _ := os.Stat(path + "-wal"); info.Size() != 32 {
+		t.Errorf("log after a passive checkpoint is %d bytes, want its header", info.Size())
+	}
+	execOK(t, db, "INSERT INTO t VALUES (3)")
+	if got := queryAll(t, db, "PRAGMA wal_checkpoint(TRUNCATE)"); got != "0,1,1" {
+		t.Errorf("truncate checkpoint = %s", got)
+	}
+	if info, _ := os.Stat(path + "-wal"); info.Size() != 0 {
+		t.Errorf("log after a truncate checkpoint is %d bytes", info.Size())
+	}
+	// The main file alone now holds everything
+	copied, err := Open(crashCopy(t, path))
+	if err != nil {
+		t.Fatalf("open checkpointed copy: %v", err)
+	}
+	if got := queryAll(t, copied, "SELECT group_concat(n) FROM t"); got != "1,2,3" {
+		t.Errorf("unexpected rows after checkpoint: %s", got)
+	}
+	copied.Close()
+
+	// A stale log from before a checkpoint is not replayed again
+	execOK(t, db, "DELETE FROM t WHERE n = 1")
+	stale, _ := os.ReadFile(path + "-wal")
+	execOK(t, db, "PRAGMA wal_checkpoint(FULL)", "INSERT INTO t VALUES (1)")
+	old := crashCopy(t, path)
+	if err := os.WriteFile(old+"-wal", stale, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	copied, err = Open(old)
+	if err != nil {
+		t.Fatalf("open with a stale log: %v", err)
+	}
+	if got := queryAll(t, copied, "SELECT group_concat(n) FROM t"); got != "2,3" {
+		t.Errorf("unexpected rows with a stale log: %s", got)
+	}
+	copied.Close()
+
+	execOK(t, db, "PRAGMA wal_autocheckpoint = 2", "INSERT INTO t VALUES (4)")
+	if info, _ := os.Stat(path + "-wal"); info.Size() != 32 {
+		t.Errorf("log not checkpointed automatically: %d bytes", info.Size())
+	}
+
+	db = reopen(t, db, path)
+	if _, err := os.Stat(path + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("log left behind after close: %v", err)
+	}
+	if got := queryAll(t, db, "PRAGMA journal_mode"); got != "WAL" {
+		t.Errorf("journal_mode after reopen = %s", got)
+	}
+	if got := queryAll(t, db, "SELECT group_concat(n) FROM t"); got != "2,3,1,4" {
+		t.Errorf("unexpected rows after reopen: %s", got)
+	}
+	if got := queryAll(t, db, "PRAGMA journal_mode = DELETE"); got != "DELETE" {
+		t.Errorf("journal_mode = %s", got)
+	}
+	if _, err := os.Stat(path + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("log left behind after leaving WAL mode: %v", err)
+	}
+	if got := queryAll(t, db, "PRAGMA wal_checkpoint"); got != "0,0,0" {
+		t.Errorf("checkpoint outside WAL mode = %s", got)
+	}
+	db = reopen(t, db, path)
+	defer db.Close()
+	if got := queryAll(t, db, "PRAGMA journal_mode"); got == "WAL" {
+		t.Errorf("journal_mode after leaving WAL mode = %s", got)
+	}
+}
+
+func TestWALFramesHoldWrittenRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	fillRows(t, db, "t", 5000)
+	execOK(t, db,
+		"CREATE TABLE kv (k TEXT PRIMARY KEY, v INTEGER) WITHOUT ROWID",
+		"INSERT INTO kv VALUES ('a', 1), ('m', 2), ('z', 3)",
+		"PRAGMA wal_checkpoint(TRUNCATE)",
+	)
+	logSize := func() int64 {
+		info, err := os.Stat(path + "-wal")
+		if err != nil {
+			return 0
+		}
+		return info.Size()
+	}
+	// Each commit logs the rows it wrote, not the tables they are in
+	for _, sql := range []string{
+		"UPDATE t SET body = 'middle' WHERE id = 2500",
+		"DELETE FROM t WHERE id = 100",
+		"INSERT INTO t (body) VALUES ('new')",
+		"INSERT INTO kv VALUES ('b', 4)",
+		"INSERT INTO kv VALUES ('m', 5) ON CONFLICT(k) DO UPDATE SET v = 5",
+		"CREATE INDEX t_body ON t(body)",
+	} {
+		before := logSize()
+		execOK(t, db, sql)
+		if grew := logSize() - before; grew == 0 || grew > 1000 {
+			t.Errorf("%s: log grew by %d bytes", sql, grew)
+		}
+	}
+	execOK(t, db,
+		"BEGIN",
+		"UPDATE t SET body = 'kept' WHERE id = 4000",
+		"SAVEPOINT s",
+		"DELETE FROM t WHERE id < 50",
+		"ROLLBACK TO s",
+		"INSERT INTO kv VALUES ('c', 6)",
+		"COMMIT",
+		"BEGIN",
+		"DELETE FROM t WHERE id > 10",
+		"ROLLBACK",
+		"ALTER TABLE kv ADD COLUMN note TEXT DEFAULT 'x'",
+		"UPDATE kv SET note = 'y' WHERE k = 'z'",
+	)
+
+	crashed, err := Open(crashCopy(t, path))
+	if err != nil {
+		t.Fatalf("open after crash: %v", err)
+	}
+	defer crashed.Close()
+	for _, sql := range []string{
+		"SELECT count(*), group_concat(id || ':' || body) FROM t",
+		"SELECT group_concat(k || v || note) FROM kv",
+	} {
+		if got, want := queryAll(t, crashed, sql), queryAll(t, db, sql); got != want {
+			t.Errorf("%s after replay:\n got %.200s\nwant %.200s", sql, got, want)
+		}
+	}
+}
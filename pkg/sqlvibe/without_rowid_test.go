@@ -0,0 +1,138 @@
+package sqlvibe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWithoutRowidOrderedByPrimaryKey(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE kv (tenant INTEGER, k TEXT, v TEXT, PRIMARY KEY (tenant, k)) WITHOUT ROWID",
+		"INSERT INTO kv VALUES (2, 'b', 'x'), (1, 'z', 'y'), (1, 'a', 'w'), (2, 'a', 'q')")
+
+	if got := queryAll(t, db, "SELECT tenant, k FROM kv"); got != "1,a|1,z|2,a|2,b" {
+		t.Errorf("expected rows in key order, got %s", got)
+	}
+
+	if _, err := db.Exec("UPDATE kv SET k = 'c' WHERE tenant = 2 AND k = 'a'"); err != nil {
+		t.Fatalf("Update error: %v", err)
+	}
+	if got := queryAll(t, db, "SELECT tenant, k FROM kv"); got != "1,a|1,z|2,b|2,c" {
+		t.Errorf("expected rows re-sorted after key update, got %s", got)
+	}
+}
+
+func TestWithoutRowidPrimaryKeyLookup(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE kv (tenant INTEGER, k TEXT, v TEXT, PRIMARY KEY (tenant, k)) WITHOUT ROWID",
+		"INSERT INTO kv VALUES (2, 'b', 'x'), (1, 'z', 'y'), (1, 'a', 'w'), (2, 'a', 'q')")
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT tenant, k FROM kv WHERE tenant = 1", "1,a|1,z"},
+		{"SELECT tenant, k FROM kv WHERE tenant = '2' AND k = 'b'", "2,b"},
+		{"SELECT tenant, k FROM kv x WHERE x.tenant = 2 AND x.k > 'a'", "2,b"},
+		{"SELECT tenant, k FROM kv WHERE tenant = 1 OR k = 'b'", "1,a|1,z|2,b"},
+		{"SELECT tenant, k FROM kv WHERE k = 'a'", "1,a|2,a"},
+	}
+	for _, tt := range tests {
+		if got := queryAll(t, db, tt.sql); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.sql, tt.want, got)
+		}
+	}
+}
+
+func TestWithoutRowidConstraints(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE kv (tenant INTEGER, k TEXT, v TEXT, PRIMARY KEY (tenant, k)) WITHOUT ROWID",
+		"INSERT INTO kv VALUES (2, 'b', 'x'), (1, 'z', 'y'), (1, 'a', 'w'), (2, 'a', 'q')")
+
+	_, err := db.Exec("INSERT INTO kv VALUES (1, 'a', 'dup')")
+	if err == nil || !strings.Contains(err.Error(), "UNIQUE constraint failed: kv.tenant, kv.k") {
+		t.Errorf("expected key conflict, got %v", err)
+	}
+	_, err = db.Exec("INSERT INTO kv (tenant, v) VALUES (1, 'nokey')")
+	if err == nil || !strings.Contains(err.Error(), "NOT NULL constraint failed: kv.k") {
+		t.Errorf("expected NOT NULL error, got %v", err)
+	}
+	if _, err := db.Exec("UPDATE kv SET k = 'b' WHERE tenant = 2 AND k = 'a'"); err == nil {
+		t.Error("expected key conflict on update")
+	}
+	if got := queryAll(t, db, "SELECT tenant, k FROM kv"); got != "1,a|1,z|2,a|2,b" {
+		t.Errorf("failed update should leave table unchanged, got %s", got)
+	}
+
+	if _, err := db.Exec("CREATE TABLE nopk (a INT) WITHOUT ROWID"); err == nil {
+		t.Error("expected PRIMARY KEY missing error")
+	}
+	if _, err := db.Exec("CREATE TABLE ai (a INTEGER PRIMARY KEY AUTOINCREMENT) WITHOUT ROWID"); err == nil {
+		t.Error("expected AUTOINCREMENT error")
+	}
+}
+
+func TestWithoutRowidHasNoRowid(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE kv (tenant INTEGER, k TEXT, v TEXT, PRIMARY KEY (tenant, k)) WITHOUT ROWID",
+		"INSERT INTO kv VALUES (2, 'b', 'x'), (1, 'z', 'y'), (1, 'a', 'w'), (2, 'a', 'q')")
+
+	if _, err := db.Query("SELECT rowid, k FROM kv"); err == nil || !strings.Contains(err.Error(), "no such column: rowid") {
+		t.Errorf("expected no such column error, got %v", err)
+	}
+
+	rows, err := db.Query("INSERT INTO kv VALUES (0, 'm', 'n') RETURNING k")
+	if err != nil {
+		t.Fatalf("Insert RETURNING error: %v", err)
+	}
+	if len(rows.Data) != 1 || rows.Data[0][0] != "m" {
+		t.Errorf("expected RETURNING to report the new row, got %v", rows.Data)
+	}
+	rows, err = db.Query("DELETE FROM kv WHERE tenant = 1 RETURNING k")
+	if err != nil {
+		t.Fatalf("Delete RETURNING error: %v", err)
+	}
+	if len(rows.Data) != 2 {
+		t.Errorf("expected 2 deleted rows, got %v", rows.Data)
+	}
+}
+
+func TestWithoutRowidPragmas(t *testing.T) {
+	db, _ := Open(":memory:")
+	defer db.Close()
+	execOK(t, db,
+		"CREATE TABLE kv (tenant INTEGER, k TEXT, v TEXT, PRIMARY KEY (tenant, k)) WITHOUT ROWID",
+		"INSERT INTO kv VALUES (2, 'b', 'x'), (1, 'z', 'y'), (1, 'a', 'w'), (2, 'a', 'q')")
+	db.Exec("CREATE INDEX kv_v ON kv(v)")
+
+	rows, err := db.Query("PRAGMA table_list")
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	if len(rows.Data) != 1 || rows.Data[0][4] != int64(1) {
+		t.Errorf("expected wr=1, got %v", rows.Data)
+	}
+
+	rows, _ = db.Query("PRAGMA index_list(kv)")
+	if len(rows.Data) != 2 || rows.Data[0][1] != "sqlite_autoindex_kv_1" || rows.Data[0][3] != "pk" {
+		t.Errorf("expected clustered primary key index, got %v", rows.Data)
+	}
+
+	rows, _ = db.Query("PRAGMA index_xinfo(sqlite_autoindex_kv_1)")
+	if len(rows.Data) != 3 || rows.Data[2][2] != "v" || rows.Data[2][5] != int64(0) {
+		t.Errorf("unexpected primary key index_xinfo: %v", rows.Data)
+	}
+
+	rows, _ = db.Query("PRAGMA index_xinfo(kv_v)")
+	if len(rows.Data) != 3 || rows.Data[1][2] != "tenant" || rows.Data[2][2] != "k" {
+		t.Errorf("expected primary key columns as auxiliary index columns, got %v", rows.Data)
+	}
+}
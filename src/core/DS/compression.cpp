@@ -1,94 +1,154 @@
 #include "compression.h"
 #include <cstring>
 #include <algorithm>
+#include <vector>
 
 // Simple LZ4-style compression (simplified for demonstration)
 // In production, use the actual LZ4 library
+//
+// Stream layout: a 4-byte little-endian original length, then tokens.
+// A token with the high bit set is a literal run of (token & 0x7F) bytes
+// that follow it; any other token is a match of that many bytes (4..127)
+// followed by a 2-byte little-endian offset back into the output.
 
 namespace {
 
+const size_t kMinMatch = 4;
+const size_t kMaxMatch = 127;
+const size_t kMaxLiteral = 127;
+const size_t kWindow = 65535;
+const int kHashBits = 15;
+
 // Fast copy
 inline void fast_copy(const uint8_t* src, uint8_t* dst, size_t len) {
     std::memcpy(dst, src, len);
 }
 
-// Find match in sliding window
-size_t find_match(
-    const uint8_t* data,
-    size_t pos,
-    size_t size,
-    size_t* match_pos,
-    size_t window_size = 64 * 1024
-) {
-    size_t window_start = (pos > window_size) ? pos - window_size : 0;
-    size_t max_len = 0;
-    
-    for (size_t i = window_start; i < pos; i++) {
-        size_t len = 0;
-        while (pos + len < size && data[i + len] == data[pos + len] && len < 4096) {
-            len++;
-        }
-        if (len > max_len) {
-            max_len = len;
-            *match_pos = i;
+inline uint32_t hash4(const uint8_t* p) {
+    uint32_t v;
+    std::memcpy(&v, p, 4);
+    return (v * 2654435761u) >> (32 - kHashBits);
+}
+
+inline void put_header(uint8_t* output, size_t input_size) {
+    output[0] = static_cast<uint8_t>(input_size & 0xFF);
+    output[1] = static_cast<uint8_t>((input_size >> 8) & 0xFF);
+    output[2] = static_cast<uint8_t>((input_size >> 16) & 0xFF);
+    output[3] = static_cast<uint8_t>((input_size >> 24) & 0xFF);
+}
+
+inline uint32_t get_header(const uint8_t* input) {
+    return input[0] | (input[1] << 8) | (input[2] << 16) | ((uint32_t)input[3] << 24);
+}
+
+// Hash-chain match finder: walks at most depth earlier positions that
+// share the hash of the next 4 bytes and returns the longest match.
+class MatchFinder {
+public:
+    MatchFinder(const uint8_t* data, size_t size, int depth)
+        : data_(data), size_(size), depth_(depth),
+          head_(size_t(1) << kHashBits, -1), prev_(size, -1) {}
+
+    size_t find(size_t pos, size_t* match_pos) const {
+        if (pos + kMinMatch > size_) return 0;
+        size_t limit = std::min(kMaxMatch, size_ - pos);
+        size_t best = 0;
+        int64_t cand = head_[hash4(data_ + pos)];
+        for (int n = 0; cand >= 0 && n < depth_; n++) {
+            size_t c = static_cast<size_t>(cand);
+            if (pos - c > kWindow) break;
+            size_t len = 0;
+            while (len < limit && data_[c + len] == data_[pos + len]) len++;
+            if (len > best) {
+                best = len;
+                *match_pos = c;
+                if (len == limit) break;
+            }
+            cand = prev_[c];
         }
+        return best >= kMinMatch ? best : 0;
     }
-    
-    return max_len;
-}
 
-} // anonymous namespace
+    void insert(size_t pos) {
+        if (pos + kMinMatch > size_) return;
+        uint32_t h = hash4(data_ + pos);
+        prev_[pos] = head_[h];
+        head_[h] = static_cast<int64_t>(pos);
+    }
 
-extern "C" {
+private:
+    const uint8_t* data_;
+    size_t size_;
+    int depth_;
+    std::vector<int64_t> head_;
+    std::vector<int64_t> prev_;
+};
 
-int svdb_lz4_compress(
-    const uint8_t* input,
-    size_t input_size,
-    uint8_t* output,
-    size_t output_size
-) {
-    if (input_size == 0 || output_size < 4) {
+int lz_compress(const uint8_t* input, size_t input_size,
+                uint8_t* output, size_t output_size, int depth) {
+    if (input_size == 0 || input_size > 0xFFFFFFFFu || output_size < 4) {
         return 0;
     }
-
-    // Write original length as 4-byte little-endian header
-    output[0] = static_cast<uint8_t>(input_size & 0xFF);
-    output[1] = static_cast<uint8_t>((input_size >> 8) & 0xFF);
-    output[2] = static_cast<uint8_t>((input_size >> 16) & 0xFF);
-    output[3] = static_cast<uint8_t>((input_size >> 24) & 0xFF);
-    
+    put_header(output, input_size);
     size_t out_pos = 4;
     size_t in_pos = 0;
+    size_t lit_start = 0;
+    MatchFinder finder(input, input_size, depth);
 
-    while (in_pos < input_size) {
-        // Check if we have enough output space
-        if (out_pos + 16 >= output_size) {
-            return 0;
+    auto flush_literals = [&](size_t end) -> bool {
+        while (lit_start < end) {
+            size_t len = std::min(kMaxLiteral, end - lit_start);
+            if (out_pos + 1 + len > output_size) return false;
+            output[out_pos++] = static_cast<uint8_t>(len | 0x80);
+            fast_copy(input + lit_start, output + out_pos, len);
+            out_pos += len;
+            lit_start += len;
         }
+        return true;
+    };
 
+    while (in_pos < input_size) {
         size_t match_pos = 0;
-        size_t match_len = find_match(input, in_pos, input_size, &match_pos);
-
-        if (match_len >= 4) {
-            // Encode match: [offset:2 bytes][length:1 byte]
-            size_t offset = in_pos - match_pos;
-            output[out_pos++] = static_cast<uint8_t>(match_len);
-            output[out_pos++] = static_cast<uint8_t>(offset & 0xFF);
-            output[out_pos++] = static_cast<uint8_t>((offset >> 8) & 0xFF);
-            in_pos += match_len;
-        } else {
-            // Copy literal
-            size_t literal_len = std::min(size_t(64), input_size - in_pos);
-            output[out_pos++] = static_cast<uint8_t>(literal_len | 0x80);
-            fast_copy(input + in_pos, output + out_pos, literal_len);
-            out_pos += literal_len;
-            in_pos += literal_len;
+        size_t match_len = finder.find(in_pos, &match_pos);
+        if (match_len == 0) {
+            finder.insert(in_pos++);
+            continue;
+        }
+        if (!flush_literals(in_pos) || out_pos + 3 > output_size) {
+            return 0;
         }
+        size_t offset = in_pos - match_pos;
+        output[out_pos++] = static_cast<uint8_t>(match_len);
+        output[out_pos++] = static_cast<uint8_t>(offset & 0xFF);
+        output[out_pos++] = static_cast<uint8_t>((offset >> 8) & 0xFF);
+        for (size_t i = 0; i < match_len; i++) finder.insert(in_pos + i);
+        in_pos += match_len;
+        lit_start = in_pos;
     }
-
+    if (!flush_literals(input_size)) return 0;
     return static_cast<int>(out_pos);
 }
 
+// Chain depth for a ZSTD/GZIP-style level: deeper search, better ratio
+int level_depth(int level) {
+    if (level < 1) level = 1;
+    if (level > 22) level = 22;
+    return 8 + level * 16;
+}
+
+} // anonymous namespace
+
+extern "C" {
+
+int svdb_lz4_compress(
+    const uint8_t* input,
+    size_t input_size,
+    uint8_t* output,
+    size_t output_size
+) {
+    return lz_compress(input, input_size, output, output_size, 4);
+}
+
 int svdb_lz4_decompress(
     const uint8_t* input,
     size_t input_size,
@@ -100,7 +160,7 @@ int svdb_lz4_decompress(
     }
 
     // Read original length from 4-byte little-endian header
-    uint32_t orig_len = input[0] | (input[1] << 8) | (input[2] << 16) | (input[3] << 24);
+    uint32_t orig_len = get_header(input);
     if (orig_len > output_size) {
         return 0;
     }
@@ -122,14 +182,14 @@ int svdb_lz4_decompress(
             out_pos += literal_len;
         } else {
             // Match
-            if (in_pos + 2 >= input_size) {
+            if (in_pos + 2 > input_size) {
                 return 0;
             }
             size_t match_len = token;
             size_t offset = input[in_pos] | (input[in_pos + 1] << 8);
             in_pos += 2;
 
-            if (out_pos < offset || out_pos + match_len > orig_len) {
+            if (offset == 0 || out_pos < offset || out_pos + match_len > orig_len) {
                 return 0;
             }
 
@@ -141,15 +201,16 @@ int svdb_lz4_decompress(
         }
     }
 
-    return static_cast<int>(out_pos);
+    return out_pos == orig_len ? static_cast<int>(out_pos) : 0;
 }
 
 size_t svdb_lz4_compress_bound(size_t input_size) {
-    // LZ4 worst case: input_size + input_size/256 + 16
-    return input_size + (input_size >> 8) + 16;
+    // Worst case: all literals, one token per 127 bytes, plus the header
+    return input_size + input_size / kMaxLiteral + 16;
 }
 
 // ZSTD compression (simplified - in production use actual ZSTD library)
+// Same token stream as LZ4; the level buys a deeper match search.
 int svdb_zstd_compress(
     const uint8_t* input,
     size_t input_size,
@@ -157,10 +218,8 @@ int svdb_zstd_compress(
     size_t output_size,
     int compression_level
 ) {
-    // For now, use LZ4 compression
-    // In production, integrate actual ZSTD library
-    (void)compression_level;
-    return svdb_lz4_compress(input, input_size, output, output_size);
+    return lz_compress(input, input_size, output, output_size,
+                       level_depth(compression_level));
 }
 
 int svdb_zstd_decompress(
@@ -169,7 +228,6 @@ int svdb_zstd_decompress(
     uint8_t* output,
     size_t output_size
 ) {
-    // For now, use LZ4 decompression
     return svdb_lz4_decompress(input, input_size, output, output_size);
 }
 
@@ -177,4 +235,118 @@ int svdb_zstd_default_compression_level(void) {
     return 3;  // Default compression level
 }
 
+// GZIP compression (simplified - in production use zlib)
+// Same token stream as LZ4 with a deflate-like search depth.
+int svdb_gzip_compress(
+    const uint8_t* input,
+    size_t input_size,
+    uint8_t* output,
+    size_t output_size,
+    int compression_level
+) {
+    return lz_compress(input, input_size, output, output_size,
+                       level_depth(compression_level));
+}
+
+int svdb_gzip_decompress(
+    const uint8_t* input,
+    size_t input_size,
+    uint8_t* output,
+    size_t output_size
+) {
+    return svdb_lz4_decompress(input, input_size, output, output_size);
+}
+
+int svdb_gzip_default_compression_level(void) {
+    return 6;  // Default compression level
+}
+
+// RLE compression: a 4-byte original length, then runs. A control byte
+// below 0x80 copies (byte + 1) literal bytes; any other repeats the next
+// byte (byte & 0x7F) + 3 times.
+int svdb_rle_compress(
+    const uint8_t* input,
+    size_t input_size,
+    uint8_t* output,
+    size_t output_size
+) {
+    if (input_size == 0 || input_size > 0xFFFFFFFFu || output_size < 4) {
+        return 0;
+    }
+    put_header(output, input_size);
+    size_t out_pos = 4;
+    size_t in_pos = 0;
+    size_t lit_start = 0;
+
+    auto flush_literals = [&](size_t end) -> bool {
+        while (lit_start < end) {
+            size_t len = std::min(size_t(128), end - lit_start);
+            if (out_pos + 1 + len > output_size) return false;
+            output[out_pos++] = static_cast<uint8_t>(len - 1);
+            fast_copy(input + lit_start, output + out_pos, len);
+            out_pos += len;
+            lit_start += len;
+        }
+        return true;
+    };
+
+    while (in_pos < input_size) {
+        size_t run = 1;
+        while (in_pos + run < input_size && run < 130 && input[in_pos + run] == input[in_pos]) {
+            run++;
+        }
+        if (run < 3) {
+            in_pos += run;
+            continue;
+        }
+        if (!flush_literals(in_pos) || out_pos + 2 > output_size) {
+            return 0;
+        }
+        output[out_pos++] = static_cast<uint8_t>(0x80 | (run - 3));
+        output[out_pos++] = input[in_pos];
+        in_pos += run;
+        lit_start = in_pos;
+    }
+    if (!flush_literals(input_size)) return 0;
+    return static_cast<int>(out_pos);
+}
+
+int svdb_rle_decompress(
+    const uint8_t* input,
+    size_t input_size,
+    uint8_t* output,
+    size_t output_size
+) {
+    if (input_size < 4 || output_size == 0) {
+        return 0;
+    }
+    uint32_t orig_len = get_header(input);
+    if (orig_len > output_size) {
+        return 0;
+    }
+    size_t in_pos = 4;
+    size_t out_pos = 0;
+    while (in_pos < input_size && out_pos < orig_len) {
+        uint8_t ctl = input[in_pos++];
+        if (ctl & 0x80) {
+            size_t run = (ctl & 0x7F) + 3;
+            if (in_pos >= input_size || out_pos + run > orig_len) return 0;
+            std::memset(output + out_pos, input[in_pos++], run);
+            out_pos += run;
+        } else {
+            size_t len = size_t(ctl) + 1;
+            if (in_pos + len > input_size || out_pos + len > orig_len) return 0;
+            fast_copy(input + in_pos, output + out_pos, len);
+            in_pos += len;
+            out_pos += len;
+        }
+    }
+    return out_pos == orig_len ? static_cast<int>(out_pos) : 0;
+}
+
+size_t svdb_rle_compress_bound(size_t input_size) {
+    // Worst case: all literals, one control byte per 128 bytes
+    return input_size + input_size / 128 + 16;
+}
+
 } // extern "C"
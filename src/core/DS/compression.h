@@ -51,6 +51,49 @@ int svdb_zstd_decompress(
 // Get recommended ZSTD compression level
 int svdb_zstd_default_compression_level(void);
 
+// GZIP Compression
+// Returns compressed size, or 0 on error
+int svdb_gzip_compress(
+    const uint8_t* input,
+    size_t input_size,
+    uint8_t* output,
+    size_t output_size,
+    int compression_level
+);
+
+// GZIP Decompression
+// Returns decompressed size, or 0 on error
+int svdb_gzip_decompress(
+    const uint8_t* input,
+    size_t input_size,
+    uint8_t* output,
+    size_t output_size
+);
+
+// Get recommended GZIP compression level
+int svdb_gzip_default_compression_level(void);
+
+// RLE Compression
+// Returns compressed size, or 0 on error
+int svdb_rle_compress(
+    const uint8_t* input,
+    size_t input_size,
+    uint8_t* output,
+    size_t output_size
+);
+
+// RLE Decompression
+// Returns decompressed size, or 0 on error
+int svdb_rle_decompress(
+    const uint8_t* input,
+    size_t input_size,
+    uint8_t* output,
+    size_t output_size
+);
+
+// Get maximum RLE compressed size
+size_t svdb_rle_compress_bound(size_t input_size);
+
 #ifdef __cplusplus
 }
 #endif
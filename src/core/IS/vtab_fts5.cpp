@@ -17,8 +17,12 @@ FTS5Index::~FTS5Index() {
     Clear();
 }
 
-std::vector<std::string> FTS5Index::Tokenize(const std::string& text) const {
-    std::vector<std::string> tokens;
+std::vector<FTS5Index::Token> FTS5Index::Tokenize(const std::string& text) const {
+    if (tokenize_) {
+        return tokenize_(text);
+    }
+
+    std::vector<Token> tokens;
     std::string current;
     
     for (size_t i = 0; i < text.size(); i++) {
@@ -30,14 +34,14 @@ std::vector<std::string> FTS5Index::Tokenize(const std::string& text) const {
             current += c;
         } else {
             if (!current.empty()) {
-                tokens.push_back(current);
+                tokens.push_back(Token{current, static_cast<int>(tokens.size())});
                 current.clear();
             }
         }
     }
     
     if (!current.empty()) {
-        tokens.push_back(current);
+        tokens.push_back(Token{current, static_cast<int>(tokens.size())});
     }
     
     return tokens;
@@ -48,6 +52,10 @@ int FTS5Index::Insert(int64_t doc_id, const std::vector<std::string>& values) {
         return -1;
     }
     
+    if (Contains(doc_id)) {
+        Delete(doc_id);
+    }
+
     try {
         int total_tokens = 0;
         std::vector<std::string> columns;
@@ -57,12 +65,12 @@ int FTS5Index::Insert(int64_t doc_id, const std::vector<std::string>& values) {
             columns.push_back(text);
             
             // Tokenize and add to index
-            std::vector<std::string> tokens = Tokenize(text);
+            std::vector<Token> tokens = Tokenize(text);
             total_tokens += static_cast<int>(tokens.size());
             
             for (const auto& token : tokens) {
-                posting_list_[token].insert(doc_id);
-                term_freq_[token][doc_id]++;
+                posting_list_[token.term].insert(doc_id);
+                term_hits_[token.term][doc_id].push_back(Hit{col, token.pos});
             }
         }
         
@@ -77,23 +85,36 @@ int FTS5Index::Insert(int64_t doc_id, const std::vector<std::string>& values) {
 }
 
 int FTS5Index::Delete(int64_t doc_id) {
+    auto doc_it = doc_columns_.find(doc_id);
+    if (doc_it == doc_columns_.end()) {
+        return -1;
+    }
+
     try {
-        // Remove from posting lists
-        for (auto& pair : term_freq_) {
-            const std::string& term = pair.first;
-            auto& doc_freq = pair.second;
-            
-            if (doc_freq.find(doc_id) != doc_freq.end()) {
-                doc_freq.erase(doc_id);
-                if (posting_list_.find(term) != posting_list_.end()) {
-                    posting_list_[term].erase(doc_id);
+        // Only the document's own terms can reference it
+        for (const auto& text : doc_it->second) {
+            for (const auto& token : Tokenize(text)) {
+                const std::string& term = token.term;
+                auto th_it = term_hits_.find(term);
+                if (th_it != term_hits_.end()) {
+                    th_it->second.erase(doc_id);
+                    if (th_it->second.empty()) {
+                        term_hits_.erase(th_it);
+                    }
+                }
+                auto pl_it = posting_list_.find(term);
+                if (pl_it != posting_list_.end()) {
+                    pl_it->second.erase(doc_id);
+                    if (pl_it->second.empty()) {
+                        posting_list_.erase(pl_it);
+                    }
                 }
             }
         }
         
         // Remove doc metadata
         doc_lengths_.erase(doc_id);
-        doc_columns_.erase(doc_id);
+        doc_columns_.erase(doc_it);
         doc_count_--;
         
         return 0;
@@ -104,7 +125,7 @@ int FTS5Index::Delete(int64_t doc_id) {
 
 void FTS5Index::Clear() {
     posting_list_.clear();
-    term_freq_.clear();
+    term_hits_.clear();
     doc_lengths_.clear();
     doc_columns_.clear();
     doc_count_ = 0;
@@ -140,6 +161,25 @@ std::vector<int64_t> FTS5Index::SearchPrefix(const std::string& prefix) const {
     return results;
 }
 
+std::vector<std::string> FTS5Index::TermsWithPrefix(const std::string& prefix) const {
+    std::vector<std::string> terms;
+    for (const auto& pair : term_hits_) {
+        if (pair.first.compare(0, prefix.size(), prefix) == 0) {
+            terms.push_back(pair.first);
+        }
+    }
+    return terms;
+}
+
+const std::vector<FTS5Index::Hit>* FTS5Index::Hits(const std::string& term, int64_t doc_id) const {
+    auto th_it = term_hits_.find(term);
+    if (th_it == term_hits_.end()) {
+        return nullptr;
+    }
+    auto doc_it = th_it->second.find(doc_id);
+    return doc_it != th_it->second.end() ? &doc_it->second : nullptr;
+}
+
 int FTS5Index::DocLength(int64_t doc_id) const {
     auto it = doc_lengths_.find(doc_id);
     if (it != doc_lengths_.end()) {
@@ -149,14 +189,8 @@ int FTS5Index::DocLength(int64_t doc_id) const {
 }
 
 int FTS5Index::TermFreq(int64_t doc_id, const std::string& term) const {
-    auto tf_it = term_freq_.find(term);
-    if (tf_it != term_freq_.end()) {
-        auto doc_it = tf_it->second.find(doc_id);
-        if (doc_it != tf_it->second.end()) {
-            return doc_it->second;
-        }
-    }
-    return 0;
+    const std::vector<Hit>* hits = Hits(term, doc_id);
+    return hits ? static_cast<int>(hits->size()) : 0;
 }
 
 int FTS5Index::DocFreq(const std::string& term) const {
@@ -181,6 +215,41 @@ const std::string& FTS5Index::GetColumn(int64_t doc_id, int col) const {
     return empty;
 }
 
+bool FTS5Index::SameContents(const FTS5Index& other) const {
+    return doc_count_ == other.doc_count_ &&
+           doc_lengths_ == other.doc_lengths_ &&
+           doc_columns_ == other.doc_columns_ &&
+           term_hits_ == other.term_hits_ &&
+           posting_list_ == other.posting_list_;
+}
+
+void FTS5Index::Restore(int64_t doc_id, std::vector<std::string> values, int length) {
+    if (!Contains(doc_id)) {
+        doc_count_++;
+    }
+    doc_lengths_[doc_id] = length;
+    doc_columns_[doc_id] = std::move(values);
+}
+
+void FTS5Index::RestorePosting(const std::string& term, int64_t doc_id, std::vector<Hit> hits) {
+    posting_list_[term].insert(doc_id);
+    term_hits_[term][doc_id] = std::move(hits);
+}
+
+void FTS5Index::Compact() {
+    // rehash(0) shrinks each table to what its entries need
+    for (auto& pair : posting_list_) {
+        pair.second.rehash(0);
+    }
+    for (auto& pair : term_hits_) {
+        pair.second.rehash(0);
+    }
+    posting_list_.rehash(0);
+    term_hits_.rehash(0);
+    doc_lengths_.rehash(0);
+    doc_columns_.rehash(0);
+}
+
 double FTS5Index::AvgDocLength() const {
     if (doc_count_ == 0) {
         return 0.0;
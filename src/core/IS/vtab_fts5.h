@@ -5,6 +5,7 @@
 
 #include "vtab_registry.h"
 #include <cstdint>
+#include <functional>
 #include <string>
 #include <vector>
 #include <unordered_map>
@@ -17,10 +18,30 @@ namespace svdb {
  */
 class FTS5Index {
 public:
+    /* An index term and its position; colocated tokens share a position */
+    struct Token {
+        std::string term;
+        int pos;
+    };
+
+    /* Splits column text into tokens */
+    using TokenizeFn = std::function<std::vector<Token>(const std::string&)>;
+
+    /* Where a term occurs in a document */
+    struct Hit {
+        int col;
+        int pos;
+        bool operator==(const Hit& o) const { return col == o.col && pos == o.pos; }
+        bool operator<(const Hit& o) const { return col != o.col ? col < o.col : pos < o.pos; }
+    };
+
     FTS5Index(int column_count);
     ~FTS5Index();
     
-    // Document operations
+    // Replace the built-in ASCII tokenizer
+    void SetTokenizer(TokenizeFn fn) { tokenize_ = std::move(fn); }
+
+    // Document operations (Insert replaces an existing document)
     int Insert(int64_t doc_id, const std::vector<std::string>& values);
     int Delete(int64_t doc_id);
     void Clear();
@@ -28,9 +49,14 @@ public:
     // Query operations
     std::vector<int64_t> Search(const std::string& term) const;
     std::vector<int64_t> SearchPrefix(const std::string& prefix) const;
+    std::vector<std::string> TermsWithPrefix(const std::string& prefix) const;
+
+    // Positions of term in a document, in column and position order; null if none
+    const std::vector<Hit>* Hits(const std::string& term, int64_t doc_id) const;
     
     // Statistics for BM25
     int DocCount() const { return doc_count_; }
+    bool Contains(int64_t doc_id) const { return doc_lengths_.count(doc_id) != 0; }
     int DocLength(int64_t doc_id) const;
     int TermFreq(int64_t doc_id, const std::string& term) const;
     int DocFreq(const std::string& term) const;
@@ -43,17 +69,33 @@ public:
     // Iterators for BM25 calculation
     const std::unordered_map<int64_t, int>& GetDocLengths() const { return doc_lengths_; }
 
+    // True if both indexes hold the same documents, terms and postings
+    bool SameContents(const FTS5Index& other) const;
+
+    // term -> doc_id -> positions, for saving the index
+    const std::unordered_map<std::string, std::unordered_map<int64_t, std::vector<Hit>>>& GetTermHits() const {
+        return term_hits_;
+    }
+
+    // Load a saved document and its postings without tokenizing its text
+    void Restore(int64_t doc_id, std::vector<std::string> values, int length);
+    void RestorePosting(const std::string& term, int64_t doc_id, std::vector<Hit> hits);
+
+    // Release the space deleted documents left in the term tables
+    void Compact();
+
 private:
-    std::vector<std::string> Tokenize(const std::string& text) const;
+    std::vector<Token> Tokenize(const std::string& text) const;
     
     int column_count_;
     int doc_count_;
+    TokenizeFn tokenize_;
     
     // term -> set of doc_ids
     std::unordered_map<std::string, std::unordered_set<int64_t>> posting_list_;
     
-    // term -> doc_id -> frequency
-    std::unordered_map<std::string, std::unordered_map<int64_t, int>> term_freq_;
+    // term -> doc_id -> positions; their count is the term frequency
+    std::unordered_map<std::string, std::unordered_map<int64_t, std::vector<Hit>>> term_hits_;
     
     // doc_id -> total token count
     std::unordered_map<int64_t, int> doc_lengths_;
@@ -114,6 +114,14 @@ static std::vector<std::string> read_ident_list(const std::string& sql, size_t&
     return result;
 }
 
+/* True when the value token ending at pos is complete, i.e. it is not
+ * followed by an operator that makes it part of an expression. */
+static bool value_ends_at(const std::string& sql, size_t pos) {
+    pos = skip_ws(sql, pos);
+    return pos >= sql.size() || sql[pos] == ',' || sql[pos] == ')' || sql[pos] == ';' ||
+           isalpha((unsigned char)sql[pos]);
+}
+
 /* Read a value token: quoted string, number, or keyword NULL/TRUE/FALSE. */
 static std::string read_value(const std::string& sql, size_t& pos) {
     pos = skip_ws(sql, pos);
@@ -139,7 +147,8 @@ static std::string read_value(const std::string& sql, size_t& pos) {
             }
         }
         /* Return the full quoted literal, e.g. "'hello'" */
-        return sql.substr(start, pos - start);
+        if (value_ends_at(sql, pos)) return sql.substr(start, pos - start);
+        pos = start; /* literal is part of a larger expression */
     }
     /* Number (possibly negative), including scientific notation like 1.23e-10 */
     if (isdigit(c) || (c == '-' && pos + 1 < sql.size() && isdigit((unsigned char)sql[pos+1]))) {
@@ -157,7 +166,8 @@ static std::string read_value(const std::string& sql, size_t& pos) {
                 pos = e_pos; /* not valid scientific notation, back up to before 'e' */
             }
         }
-        return sql.substr(start, pos - start);
+        if (value_ends_at(sql, pos)) return sql.substr(start, pos - start);
+        pos = start;
     }
     /* NULL/TRUE/FALSE/DEFAULT */
     if (isalpha(c)) {
@@ -27,6 +27,7 @@ static int infix_precedence(const std::string& sql, size_t pos) {
     /* Two-char operators */
     if (pos + 1 < sql.size()) {
         char c2 = sql[pos + 1];
+        if (c == '-' && c2 == '>') return 8; /* JSON -> and ->> */
         if ((c == '<' && c2 == '=') || (c == '>' && c2 == '=') ||
             (c == '!' && c2 == '=') || (c == '<' && c2 == '>')) return 4;
         if (c == '|' && c2 == '|') return 5; /* string concat */
@@ -53,6 +54,11 @@ static std::string consume_infix_op(const std::string& sql, size_t& pos) {
     /* Two-char operators */
     if (pos + 1 < sql.size()) {
         char c2 = sql[pos + 1];
+        if (c == '-' && c2 == '>') {
+            bool text = pos + 2 < sql.size() && sql[pos + 2] == '>';
+            pos += text ? 3 : 2;
+            return text ? "->>" : "->";
+        }
         if ((c == '<' && c2 == '=') || (c == '>' && c2 == '=') ||
             (c == '!' && c2 == '=') || (c == '<' && c2 == '>') ||
             (c == '|' && c2 == '|')) {
@@ -68,7 +68,13 @@ extern "C" svdb_ast_node_t* svdb_parser_parse_select(svdb_parser_t* parser,
             int depth = 0;
             while (pos < s.size()) {
                 char c = s[pos];
-                if (c == '(') { ++depth; ++pos; }
+                if (c == '\'' || c == '"' || c == '`') {
+                    /* Quoted text may hold commas, parentheses and FROM */
+                    ++pos;
+                    while (pos < s.size() && s[pos] != c) ++pos;
+                    if (pos < s.size()) ++pos;
+                }
+                else if (c == '(') { ++depth; ++pos; }
                 else if (c == ')') {
                     if (depth == 0) break;
                     --depth; ++pos;
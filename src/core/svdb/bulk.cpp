@@ -0,0 +1,493 @@
+/*
+ * bulk.cpp — bulk loading: the svdb_bulk_* API and COPY ... FROM
+ *
+ * A bulk load stores rows given as values, without SQL text to parse or
+ * statements to plan. Rows are checked against the table's constraints a
+ * chunk at a time, with the uniqueness keys of the stored rows kept in hash
+ * sets between chunks (see bulk_insert in exec.cpp), and each chunk commits
+ * as one statement. COPY reads a CSV file through the same path; unlike the
+ * API it loads the whole file as one statement.
+ */
+#include "svdb.h"
+#include "svdb_bulk.h"
+#include "svdb_storage.h"
+#include "svdb_util.h"
+#include <cerrno>
+#include <cstdio>
+#include <cstring>
+#include <mutex>
+#include <new>
+
+/* Implemented in exec.cpp */
+bool svdb_write_refused(svdb_db_t *db, const std::string &sql);
+
+/* Implemented in query.cpp */
+IndexEntries &svdb_index_build(svdb_db_t *db, const std::string &iname);
+
+struct svdb_bulk_s {
+    svdb_db_t *db;
+    BulkLoad load;
+    std::vector<BulkReject> rejects;
+};
+
+namespace {
+
+/* Rows COPY hands to bulk_insert at a time */
+const size_t COPY_CHUNK = 10000;
+
+/* ── COPY statement ─────────────────────────────────────────────── */
+
+struct CopyToken {
+    char kind = 0;     /* 'w' word, 's' string, 'i' quoted identifier, 'p' punctuation, 0 end */
+    std::string text;  /* a word upper-cased, a string or identifier unquoted */
+    std::string raw;   /* a word as written */
+};
+
+CopyToken copy_token(const std::string &sql, size_t &pos) {
+    CopyToken t;
+    while (pos < sql.size() && isspace((unsigned char)sql[pos])) ++pos;
+    if (pos >= sql.size()) return t;
+    char c = sql[pos];
+    if (c == '\'' || c == '"' || c == '`') {
+        t.kind = c == '\'' ? 's' : 'i';
+        for (++pos; pos < sql.size(); ++pos) {
+            if (sql[pos] != c) { t.text += sql[pos]; continue; }
+            if (pos + 1 < sql.size() && sql[pos + 1] == c) { t.text += c; ++pos; continue; }
+            ++pos;
+            return t;
+        }
+        t.kind = 'p'; /* unterminated: a syntax error */
+        t.text = std::string(1, c);
+        return t;
+    }
+    if (isalnum((unsigned char)c) || c == '_' || c == '.' || c == '/' || c == '-') {
+        size_t start = pos;
+        while (pos < sql.size() && (isalnum((unsigned char)sql[pos]) || sql[pos] == '_' ||
+                                    sql[pos] == '.' || sql[pos] == '/' || sql[pos] == '-'))
+            ++pos;
+        t.kind = 'w';
+        t.raw = sql.substr(start, pos - start);
+        t.text = svdb_str_upper(t.raw);
+        return t;
+    }
+    ++pos;
+    t.kind = 'p';
+    t.text = std::string(1, c);
+    return t;
+}
+
+bool is_punct(const CopyToken &t, char c) {
+    return t.kind == 'p' && t.text[0] == c;
+}
+
+/* A table or column name */
+bool copy_name(const CopyToken &t, std::string &out) {
+    if (t.kind == 'i') { out = t.text; return true; }
+    if (t.kind == 'w') { out = t.raw; return true; }
+    return false;
+}
+
+struct CopyStmt {
+    std::string table;
+    std::vector<std::string> cols;
+    std::string path;
+    bool header = false;
+    char delim = ',';
+    char quote = '"';
+    std::string null_text;  /* an unquoted field equal to it is NULL */
+};
+
+std::string syntax_error(const CopyToken &t) {
+    if (t.kind == 0) return "incomplete input";
+    return "near \"" + (t.kind == 'w' ? t.raw : t.text) + "\": syntax error";
+}
+
+/* The one-character value of DELIMITER or QUOTE */
+bool copy_char_option(const std::string &name, const CopyToken &v, char &out, std::string &err) {
+    if (v.kind != 's' || v.text.size() != 1) {
+        err = "COPY " + name + " must be a single one-byte character";
+        return false;
+    }
+    out = v.text[0];
+    return true;
+}
+
+/* One option of the WITH list or of the legacy syntax. pos is past name and
+ * left past the option's value, if it takes one. */
+bool copy_option(const std::string &sql, size_t &pos, const std::string &name, CopyStmt &st,
+                 std::string &err) {
+    size_t save = pos;
+    CopyToken v = copy_token(sql, pos);
+    if (v.kind == 'w' && v.text == "AS" && name != "FORMAT" && name != "HEADER") {
+        save = pos;
+        v = copy_token(sql, pos);
+    }
+    if (name == "FORMAT") {
+        if (v.kind != 'w' && v.kind != 's') { err = syntax_error(v); return false; }
+        if (svdb_str_upper(v.text) != "CSV") {
+            err = "COPY format " + (v.kind == 'w' ? v.raw : v.text) + " is not supported";
+            return false;
+        }
+        return true;
+    }
+    if (name == "CSV") { pos = save; return true; }
+    if (name == "HEADER") {
+        std::string b = svdb_str_upper(v.text);
+        if ((v.kind == 'w' || v.kind == 's') &&
+            (b == "TRUE" || b == "ON" || b == "1" || b == "FALSE" || b == "OFF" || b == "0")) {
+            st.header = b == "TRUE" || b == "ON" || b == "1";
+        } else {
+            pos = save;
+            st.header = true;
+        }
+        return true;
+    }
+    if (name == "DELIMITER") return copy_char_option(name, v, st.delim, err);
+    if (name == "QUOTE") return copy_char_option(name, v, st.quote, err);
+    if (name == "NULL") {
+        if (v.kind != 's') { err = syntax_error(v); return false; }
+        st.null_text = v.text;
+        return true;
+    }
+    err = "COPY option " + name + " is not supported";
+    return false;
+}
+
+/* Parse COPY table [(col, ...)] FROM 'path' [[WITH] (option [value], ...)]
+ * or with the options listed bare, as in COPY t FROM 'f' CSV HEADER */
+bool copy_parse(const std::string &sql, CopyStmt &st, std::string &err) {
+    size_t pos = 0;
+    copy_token(sql, pos); /* COPY */
+    CopyToken t = copy_token(sql, pos);
+    if (!copy_name(t, st.table)) { err = syntax_error(t); return false; }
+    t = copy_token(sql, pos);
+    if (is_punct(t, '(')) {
+        for (;;) {
+            t = copy_token(sql, pos);
+            std::string col;
+            if (!copy_name(t, col)) { err = syntax_error(t); return false; }
+            st.cols.push_back(col);
+            t = copy_token(sql, pos);
+            if (is_punct(t, ')')) break;
+            if (!is_punct(t, ',')) { err = syntax_error(t); return false; }
+        }
+        t = copy_token(sql, pos);
+    }
+    if (t.kind == 'w' && t.text == "TO") {
+        err = "COPY TO is not supported";
+        return false;
+    }
+    if (t.kind != 'w' || t.text != "FROM") { err = syntax_error(t); return false; }
+    t = copy_token(sql, pos);
+    if (t.kind == 'w' && (t.text == "STDIN" || t.text == "PROGRAM")) {
+        err = "COPY FROM " + t.text + " is not supported";
+        return false;
+    }
+    if (t.kind != 's') { err = "COPY FROM requires a file name"; return false; }
+    st.path = t.text;
+
+    t = copy_token(sql, pos);
+    if (t.kind == 'w' && t.text == "WITH") t = copy_token(sql, pos);
+    if (is_punct(t, '(')) {
+        for (;;) {
+            t = copy_token(sql, pos);
+            if (t.kind != 'w') { err = syntax_error(t); return false; }
+            if (!copy_option(sql, pos, t.text, st, err)) return false;
+            t = copy_token(sql, pos);
+            if (is_punct(t, ')')) break;
+            if (!is_punct(t, ',')) { err = syntax_error(t); return false; }
+        }
+        t = copy_token(sql, pos);
+    } else {
+        while (t.kind == 'w') {
+            if (!copy_option(sql, pos, t.text, st, err)) return false;
+            t = copy_token(sql, pos);
+        }
+    }
+    if (is_punct(t, ';')) t = copy_token(sql, pos);
+    if (t.kind != 0) { err = syntax_error(t); return false; }
+    if (st.delim == st.quote || st.delim == '\n' || st.delim == '\r') {
+        err = "COPY delimiter cannot be a quote or line break";
+        return false;
+    }
+    return true;
+}
+
+/* ── CSV reading ────────────────────────────────────────────────── */
+
+/* Reads the records of a CSV file (RFC 4180: quoted fields may hold the
+ * delimiter, line breaks and doubled quotes; CRLF or LF line ends; an
+ * optional UTF-8 byte order mark) */
+class CsvReader {
+public:
+    CsvReader(FILE *f, char delim, char quote) : f_(f), delim_(delim), quote_(quote) {}
+
+    /* Read the next record into fields, with quoted[i] set for fields that
+     * were quoted. False at the end of the file or on an error. */
+    bool next(std::vector<std::string> &fields, std::vector<bool> &quoted) {
+        fields.clear();
+        quoted.clear();
+        int c = get();
+        if (c == EOF) return false;
+        if (first_) {
+            first_ = false;
+            if (c == 0xEF) {
+                int c2 = get(), c3 = get();
+                if (c2 == 0xBB && c3 == 0xBF) c = get();
+                else { unget(c3); unget(c2); }
+            }
+            if (c == EOF) return false;
+        }
+        line_ = next_line_;
+        std::string field;
+        bool in_quotes = false, was_quoted = false;
+        for (;; c = get()) {
+            if (in_quotes) {
+                if (c == EOF) {
+                    error_ = "unterminated quoted field";
+                    return false;
+                }
+                if (c == quote_) {
+                    int n = get();
+                    if (n == quote_) { field += (char)quote_; continue; }
+                    unget(n);
+                    in_quotes = false;
+                    continue;
+                }
+                if (c == '\n') ++next_line_;
+                field += (char)c;
+                continue;
+            }
+            if (c == quote_ && field.empty() && !was_quoted) {
+                in_quotes = was_quoted = true;
+                continue;
+            }
+            if (c == delim_) {
+                fields.push_back(std::move(field));
+                quoted.push_back(was_quoted);
+                field.clear();
+                was_quoted = false;
+                continue;
+            }
+            if (c == '\r') {
+                int n = get();
+                if (n != '\n') unget(n);
+                c = '\n';
+            }
+            if (c == '\n' || c == EOF) {
+                if (c == '\n') ++next_line_;
+                fields.push_back(std::move(field));
+                quoted.push_back(was_quoted);
+                return true;
+            }
+            field += (char)c;
+        }
+    }
+
+    int64_t line() const { return line_; }  /* line the last record started on */
+    const std::string &error() const { return error_; }
+
+private:
+    int get() {
+        if (!pushed_.empty()) { int c = pushed_.back(); pushed_.pop_back(); return c; }
+        return getc(f_);
+    }
+    void unget(int c) { if (c != EOF) pushed_.push_back(c); }
+
+    FILE *f_;
+    char delim_, quote_;
+    bool first_ = true;
+    int64_t line_ = 0, next_line_ = 1;
+    std::vector<int> pushed_;
+    std::string error_;
+};
+
+/* Load the records of f into b a chunk at a time, counting the rows stored
+ * in loaded. A rejected row fails the load, with its line in the message. */
+svdb_code_t copy_load(svdb_db_t *db, const CopyStmt &st, BulkLoad &b, FILE *f, int64_t &loaded) {
+    CsvReader rd(f, st.delim, st.quote);
+    std::vector<std::string> fields;
+    std::vector<bool> quoted;
+    if (st.header && !rd.next(fields, quoted) && !rd.error().empty()) {
+        db->last_error = "COPY " + b.table + ": line 1: " + rd.error();
+        return SVDB_ERR;
+    }
+    std::vector<std::vector<SvdbVal>> rows;
+    std::vector<int64_t> lines;
+    std::vector<BulkReject> rejects;
+    bool more = true;
+    while (more) {
+        rows.clear();
+        lines.clear();
+        while (rows.size() < COPY_CHUNK && (more = rd.next(fields, quoted))) {
+            std::vector<SvdbVal> vals(fields.size());
+            for (size_t i = 0; i < fields.size(); ++i) {
+                if (!quoted[i] && fields[i] == st.null_text) continue;
+                vals[i].type = SVDB_TYPE_TEXT;
+                vals[i].sval = std::move(fields[i]);
+            }
+            rows.push_back(std::move(vals));
+            lines.push_back(rd.line());
+        }
+        if (!rd.error().empty()) {
+            db->last_error = "COPY " + b.table + ": line " + std::to_string(rd.line()) + ": " + rd.error();
+            return SVDB_ERR;
+        }
+        if (rows.empty()) break;
+        rejects.clear();
+        if (bulk_insert(db, b, rows, 0, 0, rejects) != SVDB_OK) {
+            int64_t line = rejects.empty() ? rd.line() : lines[(size_t)rejects.front().row];
+            db->last_error = "COPY " + b.table + ": line " + std::to_string(line) + ": " + db->last_error;
+            return SVDB_ERR;
+        }
+        loaded += db->rows_affected;
+    }
+    return SVDB_OK;
+}
+
+} // namespace
+
+svdb_code_t copy_exec(svdb_db_t *db, const std::string &sql, svdb_result_t *res) {
+    CopyStmt st;
+    std::string err;
+    if (!copy_parse(sql, st, err)) {
+        db->last_error = err;
+        return SVDB_ERR;
+    }
+    BulkLoad b;
+    if (!bulk_open(db, st.table, st.cols, b)) return SVDB_ERR;
+    b.defer_sort = true;
+    FILE *f = fopen(st.path.c_str(), "rb");
+    if (!f) {
+        db->last_error = "COPY " + b.table + ": cannot open '" + st.path + "': " + strerror(errno);
+        return SVDB_ERR;
+    }
+    auto &data = db->data[b.table];
+    size_t nrows = data.size();
+    int64_t rowid = db->rowid_counter[b.table];
+    int64_t last_rowid = db->last_insert_rowid;
+    int64_t loaded = 0;
+    svdb_code_t rc = copy_load(db, st, b, f, loaded);
+    fclose(f);
+    if (rc != SVDB_OK) {
+        /* The rows loaded so far are at the end of the table, unsorted */
+        data.erase(data.begin() + (ptrdiff_t)nrows, data.end());
+        ++db->data_gen;
+        storage_note_kept(db);
+        db->rowid_counter[b.table] = rowid;
+        db->last_insert_rowid = last_rowid;
+        db->rows_affected = 0;
+        return rc;
+    }
+    auto oit = db->table_opts.find(b.table);
+    auto pit = db->primary_keys.find(b.table);
+    if (oit != db->table_opts.end() && oit->second.without_rowid && pit != db->primary_keys.end()) {
+        std::stable_sort(data.begin(), data.end(), [&](const Row &x, const Row &y) {
+            return svdb_row_key_cmp(x, y, pit->second) < 0;
+        });
+        ++db->data_gen;
+        storage_note_rows(db, b.table, 0, 0);
+    }
+    db->rows_affected = loaded;
+    if (res) {
+        res->code = SVDB_OK;
+        res->rows_affected = loaded;
+        res->last_insert_rowid = db->last_insert_rowid;
+    }
+    return SVDB_OK;
+}
+
+extern "C" {
+
+svdb_code_t svdb_bulk_begin(svdb_db_t *db, const char *table, const char *const *cols, int ncols,
+                            svdb_bulk_t **bulk) {
+    if (!db || !table || !bulk) return SVDB_ERR;
+    *bulk = nullptr;
+    std::lock_guard<std::mutex> lk(db->mu);
+    db->last_error.clear();
+    if (svdb_write_refused(db, "INSERT")) return SVDB_READONLY;
+    std::vector<std::string> names;
+    for (int i = 0; i < ncols; ++i) names.push_back(cols[i]);
+    svdb_bulk_t *b = new (std::nothrow) svdb_bulk_t();
+    if (!b) return SVDB_NOMEM;
+    b->db = db;
+    if (!bulk_open(db, table, names, b->load)) {
+        delete b;
+        return SVDB_ERR;
+    }
+    *bulk = b;
+    return SVDB_OK;
+}
+
+int svdb_bulk_column_count(svdb_bulk_t *bulk) {
+    return bulk ? (int)bulk->load.cols.size() : 0;
+}
+
+svdb_code_t svdb_bulk_insert(svdb_bulk_t *bulk, const svdb_val_t *vals, int nrows, int64_t max_rejects,
+                             svdb_result_t *res) {
+    if (!bulk) return SVDB_ERR;
+    svdb_db_t *db = bulk->db;
+    if (res) { res->code = SVDB_OK; res->errmsg = ""; res->rows_affected = 0; res->last_insert_rowid = 0; }
+    size_t ncols = bulk->load.cols.size();
+    std::vector<std::vector<SvdbVal>> rows((size_t)nrows, std::vector<SvdbVal>(ncols));
+    for (size_t r = 0; r < rows.size(); ++r) {
+        for (size_t c = 0; c < ncols; ++c) {
+            const svdb_val_t &v = vals[r * ncols + c];
+            SvdbVal &out = rows[r][c];
+            out.type = v.type;
+            if (v.type == SVDB_TYPE_INT) out.ival = v.ival;
+            else if (v.type == SVDB_TYPE_REAL) out.rval = v.rval;
+            else if (v.type == SVDB_TYPE_TEXT || v.type == SVDB_TYPE_BLOB) out.sval.assign(v.sval ? v.sval : "", v.slen);
+        }
+    }
+
+    std::lock_guard<std::mutex> lk(db->mu);
+    bulk->rejects.clear();
+    svdb_code_t rc;
+    if (svdb_write_refused(db, "INSERT")) {
+        rc = SVDB_READONLY;
+    } else {
+        rc = bulk_insert(db, bulk->load, rows, 0, max_rejects, bulk->rejects);
+        std::string err;
+        if (!storage_commit(db, err)) {
+            db->last_error = "disk I/O error: " + err;
+            rc = SVDB_ERR;
+        }
+    }
+    if (res) {
+        res->code = rc;
+        res->errmsg = db->last_error.c_str();
+        if (rc == SVDB_OK) {
+            res->rows_affected = db->rows_affected;
+            res->last_insert_rowid = db->last_insert_rowid;
+        }
+    }
+    return rc;
+}
+
+int svdb_bulk_reject_count(svdb_bulk_t *bulk) {
+    return bulk ? (int)bulk->rejects.size() : 0;
+}
+
+const char *svdb_bulk_reject(svdb_bulk_t *bulk, int i, int64_t *row) {
+    if (!bulk || i < 0 || (size_t)i >= bulk->rejects.size()) return nullptr;
+    if (row) *row = bulk->rejects[(size_t)i].row;
+    return bulk->rejects[(size_t)i].reason.c_str();
+}
+
+svdb_code_t svdb_bulk_build_indexes(svdb_bulk_t *bulk) {
+    if (!bulk) return SVDB_ERR;
+    svdb_db_t *db = bulk->db;
+    std::lock_guard<std::mutex> lk(db->mu);
+    for (const auto &kv : db->indexes)
+        if (kv.second.table == bulk->load.table && !kv.second.columns.empty())
+            svdb_index_build(db, kv.first);
+    return SVDB_OK;
+}
+
+svdb_code_t svdb_bulk_close(svdb_bulk_t *bulk) {
+    delete bulk;
+    return SVDB_OK;
+}
+
+} /* extern "C" */
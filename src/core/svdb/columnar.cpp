@@ -0,0 +1,1033 @@
+/*
+ * columnar.cpp — COLUMNAR tables (CREATE TABLE ... USING COLUMNAR)
+ *
+ * A COLUMNAR table keeps its rows in db->data like any other table, so every
+ * statement works on it unchanged. Scans read its column image instead: one
+ * typed array per column (int64, double, or dictionary codes for text), a
+ * null bitmap, and for columns of low cardinality a roaring bitmap of the
+ * rows holding each distinct value. The first scan builds the image; after
+ * that INSERT, UPDATE and DELETE patch it row by row as they write
+ * (columnar_note_row/columnar_note_delete), including its bitmap indexes.
+ * A value of another type than its column holds reloads just that column,
+ * and an image that missed a write, to any table, is rebuilt by the next
+ * scan.
+ *
+ * Predicates evaluate to row bitmaps combined with the DS bitmap kernels,
+ * equality and IN on indexed columns come straight from the roaring bitmaps,
+ * and SUM/AVG/MIN/MAX run over the selected values with the DS vector
+ * kernels (DS/simd.h). Anything the image cannot answer exactly as the row
+ * executor would is declined and left to it.
+ */
+#include "svdb_columnar.h"
+#include "svdb_util.h"
+#include "../DS/roaring.h"
+#include "../DS/simd.h"
+#include <algorithm>
+#include <cerrno>
+#include <cstdlib>
+#include <cstring>
+#include <memory>
+
+namespace {
+
+enum ColKind { KIND_NULL, KIND_INT, KIND_REAL, KIND_TEXT, KIND_MIXED };
+
+/* Columns with at most this many distinct values, and at most one per two
+ * rows, get a bitmap index */
+const size_t kIndexMaxDistinct = 1024;
+const uint32_t kNullCode = UINT32_MAX;
+
+struct RoaringFree {
+    void operator()(svdb_roaring_bitmap_t *rb) const { svdb_roaring_free(rb); }
+};
+using Roaring = std::unique_ptr<svdb_roaring_bitmap_t, RoaringFree>;
+
+/* Row bitmap: bit i of word i/64 stands for row i */
+using Bits = std::vector<uint64_t>;
+
+size_t words_for(size_t n) { return (n + 63) / 64; }
+
+void bit_set(Bits &b, size_t i) { b[i >> 6] |= 1ULL << (i & 63); }
+void bit_clear(Bits &b, size_t i) { b[i >> 6] &= ~(1ULL << (i & 63)); }
+bool bit_test(const Bits &b, size_t i) { return (b[i >> 6] >> (i & 63)) & 1; }
+
+/* Rows 0..n-1 */
+Bits all_rows(size_t n) {
+    Bits b(words_for(n), ~0ULL);
+    if (n & 63) b.back() = (1ULL << (n & 63)) - 1;
+    return b;
+}
+
+/* Call fn(row) for every row in b, in order */
+template <typename Fn>
+void for_each_row(const Bits &b, Fn fn) {
+    for (size_t w = 0; w < b.size(); ++w) {
+        uint64_t word = b[w];
+        while (word) {
+            fn(w * 64 + (size_t)__builtin_ctzll(word));
+            word &= word - 1;
+        }
+    }
+}
+
+void add_roaring(Bits &b, svdb_roaring_bitmap_t *rb) {
+    size_t count = 0;
+    uint32_t *vals = svdb_roaring_to_array(rb, &count);
+    for (size_t i = 0; i < count; ++i) bit_set(b, vals[i]);
+    free(vals);
+}
+
+} // namespace
+
+struct ColumnarColumn {
+    std::string name;
+    ColKind kind = KIND_NULL;
+    std::vector<int64_t> ints;          /* KIND_INT; 0 where NULL */
+    std::vector<double> reals;          /* KIND_REAL; 0 where NULL */
+    std::vector<uint32_t> codes;        /* KIND_TEXT; index into dict, kNullCode where NULL */
+    std::vector<std::string> dict;      /* KIND_TEXT: distinct values, first seen first */
+    std::unordered_map<std::string, uint32_t> dict_code;
+    Bits nulls;                         /* rows holding NULL */
+    size_t null_count = 0;
+    /* Bitmap index: rows holding each distinct value */
+    bool indexed = false;
+    std::unordered_map<int64_t, Roaring> int_rows;  /* KIND_INT */
+    std::vector<Roaring> text_rows;                 /* KIND_TEXT, parallel to dict */
+    size_t indexed_at = 0;                          /* rows when the index was last decided */
+};
+
+/* Column image of a COLUMNAR table as of db->data_gen == gen */
+struct ColumnarImage {
+    uint64_t gen = 0;
+    size_t nrows = 0;
+    std::vector<ColumnarColumn> cols;   /* in col_order */
+};
+
+namespace {
+
+/* Columns with more distinct values than this among n rows get no index */
+size_t max_distinct(size_t n) { return std::min(kIndexMaxDistinct, n / 2); }
+
+/* Decide whether column c of an n-row image gets a bitmap index, and build
+ * it from the column's values if so */
+void index_column(ColumnarColumn &c, size_t n) {
+    c.indexed = false;
+    c.int_rows.clear();
+    c.text_rows.clear();
+    c.indexed_at = n;
+    size_t limit = max_distinct(n);
+    if (c.kind == KIND_INT) {
+        for (size_t i = 0; i < n; ++i) {
+            if (bit_test(c.nulls, i)) continue;
+            Roaring &rb = c.int_rows[c.ints[i]];
+            if (!rb) {
+                if (c.int_rows.size() > limit) { c.int_rows.clear(); return; }
+                rb.reset(svdb_roaring_create());
+            }
+            svdb_roaring_add(rb.get(), (uint32_t)i);
+        }
+        c.indexed = true;
+    } else if (c.kind == KIND_TEXT && c.dict.size() <= limit) {
+        for (size_t k = 0; k < c.dict.size(); ++k) c.text_rows.emplace_back(svdb_roaring_create());
+        for (size_t i = 0; i < n; ++i)
+            if (c.codes[i] != kNullCode) svdb_roaring_add(c.text_rows[c.codes[i]].get(), (uint32_t)i);
+        c.indexed = true;
+    }
+}
+
+void build_column(ColumnarColumn &c, const std::vector<Row> &rows) {
+    size_t n = rows.size();
+    std::vector<const SvdbVal *> vals(n, nullptr);
+    bool has_int = false, has_real = false, has_text = false, has_other = false;
+    c.nulls.assign(words_for(n), 0);
+    /* Rows of a table share a layout or a few: resolve the column once per layout */
+    const RowLayout *layout = nullptr;
+    int pos = -1;
+    for (size_t i = 0; i < n; ++i) {
+        if (rows[i].layout() != layout) {
+            layout = rows[i].layout();
+            pos = layout->find(c.name);
+        }
+        const SvdbVal *v = pos < 0 ? nullptr : &rows[i].at_pos((size_t)pos);
+        if (!v || v->type == SVDB_TYPE_NULL) {
+            bit_set(c.nulls, i);
+            ++c.null_count;
+            continue;
+        }
+        vals[i] = v;
+        switch (v->type) {
+        case SVDB_TYPE_INT:  has_int = true; break;
+        case SVDB_TYPE_REAL: has_real = true; break;
+        case SVDB_TYPE_TEXT: has_text = true; break;
+        default:             has_other = true; break;
+        }
+    }
+    int kinds = has_int + has_real + has_text + has_other;
+    if (kinds == 0) c.kind = KIND_NULL;
+    else if (kinds > 1 || has_other) c.kind = KIND_MIXED;
+    else if (has_int) c.kind = KIND_INT;
+    else if (has_real) c.kind = KIND_REAL;
+    else c.kind = KIND_TEXT;
+
+    if (c.kind == KIND_INT) {
+        c.ints.assign(n, 0);
+        for (size_t i = 0; i < n; ++i)
+            if (vals[i]) c.ints[i] = vals[i]->ival;
+    } else if (c.kind == KIND_REAL) {
+        c.reals.assign(n, 0.0);
+        for (size_t i = 0; i < n; ++i)
+            if (vals[i]) c.reals[i] = vals[i]->rval;
+    } else if (c.kind == KIND_TEXT) {
+        c.codes.assign(n, kNullCode);
+        for (size_t i = 0; i < n; ++i) {
+            if (!vals[i]) continue;
+            auto ins = c.dict_code.emplace(vals[i]->sval, (uint32_t)c.dict.size());
+            if (ins.second) c.dict.push_back(vals[i]->sval);
+            c.codes[i] = ins.first->second;
+        }
+    }
+    index_column(c, n);
+}
+
+/* Reload column c from the rows, e.g. once a value changed its kind */
+void reload_column(ColumnarColumn &c, const std::vector<Row> &rows) {
+    std::string name = std::move(c.name);
+    c = ColumnarColumn();
+    c.name = std::move(name);
+    build_column(c, rows);
+}
+
+/* Store v (nullptr for a missing column) as row i of an image of n rows;
+ * added says row i is new, appended as the last row. Returns false, storing
+ * nothing, when v does not fit the kind of the column. */
+bool column_put(ColumnarColumn &c, size_t i, size_t n, bool added, const SvdbVal *v) {
+    bool null = !v || v->type == SVDB_TYPE_NULL;
+    if (!null && c.kind != KIND_MIXED &&
+        !(c.kind == KIND_INT && v->type == SVDB_TYPE_INT) &&
+        !(c.kind == KIND_REAL && v->type == SVDB_TYPE_REAL) &&
+        !(c.kind == KIND_TEXT && v->type == SVDB_TYPE_TEXT)) return false;
+
+    if (added) {
+        /* Start the new row out as NULL */
+        c.nulls.resize(words_for(n), 0);
+        if (c.kind == KIND_INT) c.ints.push_back(0);
+        else if (c.kind == KIND_REAL) c.reals.push_back(0.0);
+        else if (c.kind == KIND_TEXT) c.codes.push_back(kNullCode);
+        bit_set(c.nulls, i);
+        ++c.null_count;
+    }
+    /* Take the old value out */
+    if (bit_test(c.nulls, i)) {
+        bit_clear(c.nulls, i);
+        --c.null_count;
+    } else if (c.indexed && c.kind == KIND_INT) {
+        auto it = c.int_rows.find(c.ints[i]);
+        svdb_roaring_remove(it->second.get(), (uint32_t)i);
+        if (svdb_roaring_cardinality(it->second.get()) == 0) c.int_rows.erase(it);
+    } else if (c.indexed && c.kind == KIND_TEXT) {
+        svdb_roaring_remove(c.text_rows[c.codes[i]].get(), (uint32_t)i);
+    }
+
+    if (null) {
+        bit_set(c.nulls, i);
+        ++c.null_count;
+        if (c.kind == KIND_INT) c.ints[i] = 0;
+        else if (c.kind == KIND_REAL) c.reals[i] = 0.0;
+        else if (c.kind == KIND_TEXT) c.codes[i] = kNullCode;
+        return true;
+    }
+    size_t limit = max_distinct(n);
+    if (c.kind == KIND_INT) {
+        c.ints[i] = v->ival;
+        if (c.indexed) {
+            Roaring &rb = c.int_rows[v->ival];
+            if (!rb) {
+                if (c.int_rows.size() > limit) { c.indexed = false; c.int_rows.clear(); return true; }
+                rb.reset(svdb_roaring_create());
+            }
+            svdb_roaring_add(rb.get(), (uint32_t)i);
+        }
+    } else if (c.kind == KIND_REAL) {
+        c.reals[i] = v->rval;
+    } else if (c.kind == KIND_TEXT) {
+        auto ins = c.dict_code.emplace(v->sval, (uint32_t)c.dict.size());
+        if (ins.second) c.dict.push_back(v->sval);
+        c.codes[i] = ins.first->second;
+        if (c.indexed) {
+            if (c.dict.size() > limit) { c.indexed = false; c.text_rows.clear(); return true; }
+            if (ins.second) c.text_rows.emplace_back(svdb_roaring_create());
+            svdb_roaring_add(c.text_rows[c.codes[i]].get(), (uint32_t)i);
+        }
+    }
+    return true;
+}
+
+/* Drop the rows at the sorted positions gone from column c of an image of
+ * n rows, keeping the rest in order, and unused text from the dictionary */
+void column_erase(ColumnarColumn &c, const std::vector<size_t> &gone, size_t n) {
+    Bits nulls(words_for(n - gone.size()), 0);
+    std::vector<uint32_t> remap(c.dict.size(), kNullCode);
+    std::vector<std::string> dict;
+    size_t out = 0, g = 0;
+    c.null_count = 0;
+    for (size_t i = 0; i < n; ++i) {
+        if (g < gone.size() && gone[g] == i) { ++g; continue; }
+        if (bit_test(c.nulls, i)) { bit_set(nulls, out); ++c.null_count; }
+        if (c.kind == KIND_INT) c.ints[out] = c.ints[i];
+        else if (c.kind == KIND_REAL) c.reals[out] = c.reals[i];
+        else if (c.kind == KIND_TEXT && c.codes[i] != kNullCode) {
+            uint32_t &code = remap[c.codes[i]];
+            if (code == kNullCode) { code = (uint32_t)dict.size(); dict.push_back(std::move(c.dict[c.codes[i]])); }
+            c.codes[out] = code;
+        } else if (c.kind == KIND_TEXT) {
+            c.codes[out] = kNullCode;
+        }
+        ++out;
+    }
+    c.nulls = std::move(nulls);
+    if (c.kind == KIND_INT) c.ints.resize(out);
+    else if (c.kind == KIND_REAL) c.reals.resize(out);
+    else if (c.kind == KIND_TEXT) {
+        c.codes.resize(out);
+        c.dict = std::move(dict);
+        c.dict_code.clear();
+        for (size_t k = 0; k < c.dict.size(); ++k) c.dict_code[c.dict[k]] = (uint32_t)k;
+    }
+    index_column(c, out);
+}
+
+std::shared_ptr<ColumnarImage> image_for(svdb_db_t *db, const std::string &table) {
+    std::shared_ptr<ColumnarImage> &img = db->columnar[table];
+    if (img && img->gen == db->data_gen) return img;
+    img = std::make_shared<ColumnarImage>();
+    img->gen = db->data_gen;
+    const std::vector<Row> &rows = db->data[table];
+    img->nrows = rows.size();
+    for (auto &name : db->col_order[table]) {
+        img->cols.emplace_back();
+        img->cols.back().name = name;
+        build_column(img->cols.back(), rows);
+    }
+    return img;
+}
+
+/* ---- Query parsing ------------------------------------------------------ */
+
+enum TokKind { TOK_WORD, TOK_NUM, TOK_STR, TOK_OP, TOK_END };
+
+struct Token {
+    TokKind kind;
+    std::string text;   /* words upper-cased in up, strings unquoted */
+    std::string up;
+    size_t start, end;
+};
+
+bool tokenize(const std::string &s, std::vector<Token> &out) {
+    size_t i = 0;
+    while (i < s.size()) {
+        char c = s[i];
+        if (isspace((unsigned char)c)) { ++i; continue; }
+        Token t;
+        t.start = i;
+        if (isalpha((unsigned char)c) || c == '_') {
+            while (i < s.size() && (isalnum((unsigned char)s[i]) || s[i] == '_')) ++i;
+            t.kind = TOK_WORD;
+            t.text = s.substr(t.start, i - t.start);
+        } else if (isdigit((unsigned char)c) || (c == '.' && i + 1 < s.size() && isdigit((unsigned char)s[i + 1]))) {
+            while (i < s.size() && (isdigit((unsigned char)s[i]) || s[i] == '.')) ++i;
+            if (i < s.size() && (s[i] == 'e' || s[i] == 'E')) {
+                ++i;
+                if (i < s.size() && (s[i] == '+' || s[i] == '-')) ++i;
+                while (i < s.size() && isdigit((unsigned char)s[i])) ++i;
+            }
+            if (i < s.size() && (isalpha((unsigned char)s[i]) || s[i] == '_')) return false;
+            t.kind = TOK_NUM;
+            t.text = s.substr(t.start, i - t.start);
+        } else if (c == '\'') {
+            ++i;
+            while (true) {
+                if (i >= s.size()) return false;
+                if (s[i] == '\'') {
+                    if (i + 1 < s.size() && s[i + 1] == '\'') { t.text += '\''; i += 2; continue; }
+                    ++i;
+                    break;
+                }
+                t.text += s[i++];
+            }
+            t.kind = TOK_STR;
+        } else {
+            static const char *ops2[] = {"<=", ">=", "<>", "!=", "==", nullptr};
+            t.kind = TOK_OP;
+            for (const char **op = ops2; *op; ++op)
+                if (s.compare(i, 2, *op) == 0) { t.text = *op; break; }
+            if (t.text.empty()) {
+                if (!strchr("(),.*=<>+-;", c)) return false;
+                t.text = std::string(1, c);
+            }
+            i += t.text.size();
+        }
+        t.end = i;
+        t.up = t.kind == TOK_WORD ? svdb_str_upper(t.text) : t.text;
+        out.push_back(t);
+    }
+    Token end;
+    end.kind = TOK_END;
+    end.start = end.end = s.size();
+    out.push_back(end);
+    return true;
+}
+
+struct Lit {
+    bool text = false;
+    bool is_int = false;
+    int64_t i = 0;
+    double d = 0;
+    std::string s;
+};
+
+enum PredOp { OP_EQ, OP_NE, OP_LT, OP_LE, OP_GT, OP_GE, OP_ISNULL, OP_NOTNULL, OP_BETWEEN, OP_IN };
+
+struct Pred {
+    size_t col;
+    PredOp op;
+    std::vector<Lit> lits;
+};
+
+enum ItemFn { FN_COL, FN_COUNT_STAR, FN_COUNT, FN_SUM, FN_AVG, FN_MIN, FN_MAX };
+
+struct Item {
+    ItemFn fn;
+    size_t col = 0;
+    std::string name;
+};
+
+struct Plan {
+    std::string table;
+    std::vector<Item> items;
+    std::vector<Pred> preds;
+    bool grouped = false;
+    size_t group_col = 0;
+    bool ordered = false;
+    bool desc = false;
+    int64_t limit = -1;
+    int64_t offset = 0;
+    bool has_agg = false;
+};
+
+struct Parser {
+    svdb_db_t *db;
+    const std::string &sql;
+    std::vector<Token> toks;
+    size_t p = 0;
+    std::string alias;
+    const std::vector<std::string> *cols = nullptr;
+
+    Parser(svdb_db_t *d, const std::string &s) : db(d), sql(s) {}
+
+    const Token &peek(size_t k = 0) const { return toks[std::min(p + k, toks.size() - 1)]; }
+    bool word(const char *w) {
+        if (peek().kind == TOK_WORD && peek().up == w) { ++p; return true; }
+        return false;
+    }
+    bool op(const char *o) {
+        if (peek().kind == TOK_OP && peek().text == o) { ++p; return true; }
+        return false;
+    }
+
+    /* col, or table.col / alias.col */
+    bool colref(size_t &col, std::string &written) {
+        if (peek().kind != TOK_WORD) return false;
+        std::string first = peek().text;
+        ++p;
+        if (op(".")) {
+            std::string q = svdb_str_upper(first);
+            if (q != svdb_str_upper(alias) && q != svdb_str_upper(plan_table)) return false;
+            if (peek().kind != TOK_WORD) return false;
+            first = peek().text;
+            ++p;
+        }
+        std::string up = svdb_str_upper(first);
+        for (size_t i = 0; i < cols->size(); ++i) {
+            if (svdb_str_upper((*cols)[i]) == up) { col = i; written = first; return true; }
+        }
+        return false;
+    }
+
+    bool literal(Lit &lit) {
+        bool neg = false;
+        if (op("-")) neg = true;
+        else op("+");
+        const Token &t = peek();
+        if (t.kind == TOK_STR && !neg) {
+            lit.text = true;
+            lit.s = t.text;
+            ++p;
+            return true;
+        }
+        if (t.kind != TOK_NUM) return false;
+        std::string num = (neg ? "-" : "") + t.text;
+        ++p;
+        if (num.find_first_of(".eE") == std::string::npos) {
+            errno = 0;
+            char *endp = nullptr;
+            long long v = strtoll(num.c_str(), &endp, 10);
+            if (errno != 0 || *endp) return false;
+            lit.is_int = true;
+            lit.i = v;
+            lit.d = (double)v;
+        } else {
+            char *endp = nullptr;
+            lit.d = strtod(num.c_str(), &endp);
+            if (*endp) return false;
+        }
+        return true;
+    }
+
+    bool pred(Pred &pr) {
+        std::string written;
+        if (!colref(pr.col, written)) return false;
+        if (word("IS")) {
+            pr.op = word("NOT") ? OP_NOTNULL : OP_ISNULL;
+            return word("NULL");
+        }
+        if (word("BETWEEN")) {
+            pr.op = OP_BETWEEN;
+            Lit lo, hi;
+            if (!literal(lo) || !word("AND") || !literal(hi)) return false;
+            pr.lits = {lo, hi};
+            return true;
+        }
+        if (word("IN")) {
+            pr.op = OP_IN;
+            if (!op("(")) return false;
+            do {
+                Lit l;
+                if (!literal(l)) return false;
+                pr.lits.push_back(l);
+            } while (op(","));
+            return op(")");
+        }
+        static const struct { const char *text; PredOp op; } ops[] = {
+            {"=", OP_EQ}, {"==", OP_EQ}, {"!=", OP_NE}, {"<>", OP_NE},
+            {"<", OP_LT}, {"<=", OP_LE}, {">", OP_GT}, {">=", OP_GE},
+        };
+        for (auto &o : ops) {
+            if (op(o.text)) {
+                pr.op = o.op;
+                Lit l;
+                if (!literal(l)) return false;
+                pr.lits = {l};
+                return true;
+            }
+        }
+        return false;
+    }
+
+    bool item(Item &it) {
+        size_t start = peek().start;
+        if (op("*")) {
+            it.fn = FN_COL;
+            it.name = "*";
+            return true;
+        }
+        static const struct { const char *name; ItemFn fn; } aggs[] = {
+            {"COUNT", FN_COUNT}, {"SUM", FN_SUM}, {"AVG", FN_AVG}, {"MIN", FN_MIN}, {"MAX", FN_MAX},
+        };
+        bool is_agg = false;
+        if (peek().kind == TOK_WORD && peek(1).kind == TOK_OP && peek(1).text == "(") {
+            for (auto &a : aggs) {
+                if (peek().up != a.name) continue;
+                p += 2;
+                it.fn = a.fn;
+                if (it.fn == FN_COUNT && op("*")) {
+                    it.fn = FN_COUNT_STAR;
+                } else {
+                    std::string written;
+                    if (!colref(it.col, written)) return false;
+                }
+                if (!op(")")) return false;
+                is_agg = true;
+                break;
+            }
+            if (!is_agg) return false;
+            it.name = svdb_str_trim(sql.substr(start, toks[p - 1].end - start));
+        } else {
+            it.fn = FN_COL;
+            if (!colref(it.col, it.name)) return false;
+        }
+        if (word("AS")) {
+            if (peek().kind != TOK_WORD) return false;
+            it.name = peek().text;
+            ++p;
+        }
+        return true;
+    }
+
+    bool integer(int64_t &v) {
+        if (peek().kind != TOK_NUM || peek().text.find_first_of(".eE") != std::string::npos) return false;
+        v = strtoll(peek().text.c_str(), nullptr, 10);
+        ++p;
+        return true;
+    }
+
+    std::string plan_table;
+
+    bool parse(Plan &plan) {
+        if (!tokenize(sql, toks) || !word("SELECT")) return false;
+        /* The FROM clause first: column references resolve against its table */
+        size_t from = std::string::npos;
+        int depth = 0;
+        for (size_t i = p; i < toks.size(); ++i) {
+            if (toks[i].kind == TOK_OP && toks[i].text == "(") ++depth;
+            else if (toks[i].kind == TOK_OP && toks[i].text == ")") --depth;
+            else if (depth == 0 && toks[i].kind == TOK_WORD && toks[i].up == "FROM") { from = i; break; }
+        }
+        if (from == std::string::npos || toks[from + 1].kind != TOK_WORD) return false;
+        auto sit = find_table_case_insensitive(db->schema, toks[from + 1].text);
+        if (sit == db->schema.end()) return false;
+        plan.table = plan_table = sit->first;
+        auto oit = db->table_opts.find(plan.table);
+        if (oit == db->table_opts.end() || !oit->second.columnar) return false;
+        auto cit = db->col_order.find(plan.table);
+        if (cit == db->col_order.end() || !db->data.count(plan.table)) return false;
+        cols = &cit->second;
+        size_t after = from + 2;
+        if (toks[after].kind == TOK_WORD && toks[after].up == "AS") ++after;
+        if (toks[after].kind == TOK_WORD) {
+            static const char *clauses[] = {"WHERE", "GROUP", "ORDER", "LIMIT", nullptr};
+            bool clause = false;
+            for (const char **c = clauses; *c; ++c) clause = clause || toks[after].up == *c;
+            if (!clause) { alias = toks[after].text; ++after; }
+            else if (after != from + 2) return false;
+        }
+
+        do {
+            Item it;
+            if (!item(it)) return false;
+            if (it.fn == FN_COL && it.name == "*") {
+                for (size_t i = 0; i < cols->size(); ++i) plan.items.push_back({FN_COL, i, (*cols)[i]});
+            } else {
+                plan.has_agg = plan.has_agg || it.fn != FN_COL;
+                plan.items.push_back(it);
+            }
+        } while (op(","));
+        if (p != from) return false;
+        p = after;
+
+        if (word("WHERE")) {
+            do {
+                Pred pr;
+                if (!pred(pr)) return false;
+                plan.preds.push_back(pr);
+            } while (word("AND"));
+        }
+        if (word("GROUP")) {
+            std::string written;
+            if (!word("BY") || !colref(plan.group_col, written)) return false;
+            plan.grouped = true;
+        }
+        if (word("ORDER")) {
+            size_t col;
+            std::string written;
+            if (!word("BY") || !colref(col, written) || !plan.grouped || col != plan.group_col) return false;
+            plan.ordered = true;
+            if (word("DESC")) plan.desc = true;
+            else word("ASC");
+        }
+        if (word("LIMIT")) {
+            if (!integer(plan.limit)) return false;
+            if (op(",")) {
+                plan.offset = plan.limit;
+                if (!integer(plan.limit)) return false;
+            } else if (word("OFFSET")) {
+                if (!integer(plan.offset)) return false;
+            }
+        }
+        op(";");
+        if (peek().kind != TOK_END) return false;
+
+        /* Shapes the row executor answers differently are left to it */
+        for (auto &it : plan.items) {
+            if (plan.grouped && it.fn == FN_COL && it.col != plan.group_col) return false;
+            if (!plan.grouped && plan.has_agg && it.fn == FN_COL) return false;
+        }
+        if (plan.has_agg && !plan.grouped && plan.limit >= 0) return false;
+        return true;
+    }
+};
+
+/* ---- Evaluation --------------------------------------------------------- */
+
+bool lit_fits(const ColumnarColumn &c, const Lit &l) {
+    if (c.kind == KIND_TEXT) return l.text;
+    if (c.kind == KIND_INT || c.kind == KIND_REAL) return !l.text;
+    return c.kind == KIND_NULL;
+}
+
+/* True if the image can evaluate pr exactly like the row executor */
+bool pred_ok(const ColumnarImage &img, const Pred &pr) {
+    const ColumnarColumn &c = img.cols[pr.col];
+    if (pr.op == OP_ISNULL || pr.op == OP_NOTNULL) return true;
+    if (c.kind == KIND_MIXED) return false;
+    for (auto &l : pr.lits)
+        if (!lit_fits(c, l)) return false;
+    return true;
+}
+
+bool uses_index(const ColumnarImage &img, const Pred &pr) {
+    return (pr.op == OP_EQ || pr.op == OP_IN) && img.cols[pr.col].indexed;
+}
+
+template <typename T>
+bool cmp(PredOp op, const T &v, const T &a, const T &b) {
+    switch (op) {
+    case OP_EQ: return v == a;
+    case OP_NE: return v != a;
+    case OP_LT: return v < a;
+    case OP_LE: return v <= a;
+    case OP_GT: return v > a;
+    case OP_GE: return v >= a;
+    case OP_BETWEEN: return v >= a && v <= b;
+    default: return false;
+    }
+}
+
+bool int_matches(const Pred &pr, int64_t v) {
+    if (pr.op == OP_IN) {
+        for (auto &l : pr.lits)
+            if (l.is_int ? v == l.i : (double)v == l.d) return true;
+        return false;
+    }
+    const Lit &a = pr.lits[0];
+    const Lit &b = pr.lits.size() > 1 ? pr.lits[1] : a;
+    if (a.is_int && b.is_int) return cmp(pr.op, v, a.i, b.i);
+    return cmp(pr.op, (double)v, a.d, b.d);
+}
+
+bool real_matches(const Pred &pr, double v) {
+    if (pr.op == OP_IN) {
+        for (auto &l : pr.lits)
+            if (v == l.d) return true;
+        return false;
+    }
+    return cmp(pr.op, v, pr.lits[0].d, pr.lits.size() > 1 ? pr.lits[1].d : pr.lits[0].d);
+}
+
+bool text_matches(const Pred &pr, const std::string &v) {
+    if (pr.op == OP_IN) {
+        for (auto &l : pr.lits)
+            if (v == l.s) return true;
+        return false;
+    }
+    return cmp(pr.op, v, pr.lits[0].s, pr.lits.size() > 1 ? pr.lits[1].s : pr.lits[0].s);
+}
+
+Bits eval_pred(const ColumnarImage &img, const Pred &pr) {
+    const ColumnarColumn &c = img.cols[pr.col];
+    size_t n = img.nrows;
+    Bits out(words_for(n), 0);
+    if (pr.op == OP_ISNULL) return c.nulls;
+    if (pr.op == OP_NOTNULL) {
+        out = c.nulls;
+        svdb_bitmap_not(out.data(), out.size());
+        Bits all = all_rows(n);
+        svdb_bitmap_and(out.data(), all.data(), out.size());
+        return out;
+    }
+    if (uses_index(img, pr)) {
+        for (auto &l : pr.lits) {
+            if (c.kind == KIND_INT) {
+                if (!l.is_int && (double)(int64_t)l.d != l.d) continue;
+                auto it = c.int_rows.find(l.is_int ? l.i : (int64_t)l.d);
+                if (it != c.int_rows.end()) add_roaring(out, it->second.get());
+            } else {
+                auto it = c.dict_code.find(l.s);
+                if (it != c.dict_code.end()) add_roaring(out, c.text_rows[it->second].get());
+            }
+        }
+        return out;
+    }
+    switch (c.kind) {
+    case KIND_INT:
+        for (size_t i = 0; i < n; ++i)
+            if (int_matches(pr, c.ints[i])) bit_set(out, i);
+        break;
+    case KIND_REAL:
+        for (size_t i = 0; i < n; ++i)
+            if (real_matches(pr, c.reals[i])) bit_set(out, i);
+        break;
+    case KIND_TEXT: {
+        /* Decide once per distinct value, then map the codes */
+        std::vector<char> hit(c.dict.size());
+        for (size_t k = 0; k < c.dict.size(); ++k) hit[k] = text_matches(pr, c.dict[k]);
+        for (size_t i = 0; i < n; ++i)
+            if (c.codes[i] != kNullCode && hit[c.codes[i]]) bit_set(out, i);
+        return out;
+    }
+    default:
+        return out;
+    }
+    /* NULL never compares true */
+    Bits notnull = c.nulls;
+    svdb_bitmap_not(notnull.data(), notnull.size());
+    svdb_bitmap_and(out.data(), notnull.data(), out.size());
+    return out;
+}
+
+bool agg_ok(const ColumnarColumn &c, ItemFn fn) {
+    switch (fn) {
+    case FN_SUM:
+    case FN_AVG: return c.kind == KIND_INT || c.kind == KIND_REAL || c.kind == KIND_NULL;
+    case FN_MIN:
+    case FN_MAX: return c.kind != KIND_MIXED;
+    default:     return true;
+    }
+}
+
+SvdbVal int_val(int64_t v) { SvdbVal r; r.type = SVDB_TYPE_INT; r.ival = v; return r; }
+SvdbVal real_val(double v) { SvdbVal r; r.type = SVDB_TYPE_REAL; r.rval = v; return r; }
+SvdbVal text_val(const std::string &v) { SvdbVal r; r.type = SVDB_TYPE_TEXT; r.sval = v; return r; }
+
+/* The value of column c at row i */
+SvdbVal cell(svdb_db_t *db, const std::string &table, const ColumnarColumn &c, size_t i) {
+    switch (c.kind) {
+    case KIND_INT:  return (c.nulls[i >> 6] >> (i & 63)) & 1 ? SvdbVal{} : int_val(c.ints[i]);
+    case KIND_REAL: return (c.nulls[i >> 6] >> (i & 63)) & 1 ? SvdbVal{} : real_val(c.reals[i]);
+    case KIND_TEXT: return c.codes[i] == kNullCode ? SvdbVal{} : text_val(c.dict[c.codes[i]]);
+    case KIND_MIXED: {
+        const Row &row = db->data[table][i];
+        auto it = row.find(c.name);
+        return it == row.end() ? SvdbVal{} : it->second;
+    }
+    default: return SvdbVal{};
+    }
+}
+
+/* Aggregate fn of column c over rows (sorted row numbers) */
+SvdbVal aggregate(const ColumnarImage &img, const Item &it, const std::vector<uint32_t> &rows) {
+    if (it.fn == FN_COUNT_STAR) return int_val((int64_t)rows.size());
+    const ColumnarColumn &c = img.cols[it.col];
+    bool dense = rows.size() == img.nrows && c.null_count == 0;
+    std::vector<uint32_t> present;
+    if (!dense) {
+        present.reserve(rows.size());
+        for (uint32_t r : rows)
+            if (!((c.nulls[r >> 6] >> (r & 63)) & 1)) present.push_back(r);
+    }
+    size_t count = dense ? rows.size() : present.size();
+    if (it.fn == FN_COUNT) return int_val((int64_t)count);
+    if (count == 0 || c.kind == KIND_NULL) return SvdbVal{};
+
+    if (c.kind == KIND_INT) {
+        std::vector<int64_t> gathered;
+        const int64_t *v = c.ints.data();
+        if (!dense) {
+            gathered.reserve(count);
+            for (uint32_t r : present) gathered.push_back(c.ints[r]);
+            v = gathered.data();
+        }
+        switch (it.fn) {
+        case FN_SUM: return int_val(svdb_vector_sum_int64(v, count));
+        case FN_AVG: return real_val((double)svdb_vector_sum_int64(v, count) / (double)count);
+        case FN_MIN: return int_val(svdb_vector_min_int64(v, count));
+        default:     return int_val(svdb_vector_max_int64(v, count));
+        }
+    }
+    if (c.kind == KIND_REAL) {
+        std::vector<double> gathered;
+        const double *v = c.reals.data();
+        if (!dense) {
+            gathered.reserve(count);
+            for (uint32_t r : present) gathered.push_back(c.reals[r]);
+            v = gathered.data();
+        }
+        switch (it.fn) {
+        case FN_SUM: return real_val(svdb_vector_sum_double(v, count));
+        case FN_AVG: return real_val(svdb_vector_sum_double(v, count) / (double)count);
+        case FN_MIN: return real_val(*std::min_element(v, v + count));
+        default:     return real_val(*std::max_element(v, v + count));
+        }
+    }
+    /* MIN/MAX of text: compare each distinct value once */
+    std::vector<char> seen(c.dict.size());
+    if (dense) {
+        for (uint32_t code : c.codes) seen[code] = 1;
+    } else {
+        for (uint32_t r : present) seen[c.codes[r]] = 1;
+    }
+    const std::string *best = nullptr;
+    for (size_t k = 0; k < c.dict.size(); ++k) {
+        if (!seen[k]) continue;
+        if (!best || (it.fn == FN_MIN ? c.dict[k] < *best : c.dict[k] > *best)) best = &c.dict[k];
+    }
+    return text_val(*best);
+}
+
+/* Order of group keys for ORDER BY: NULL first, then by value */
+bool key_less(const SvdbVal &a, const SvdbVal &b) {
+    if (a.type == SVDB_TYPE_NULL || b.type == SVDB_TYPE_NULL)
+        return a.type == SVDB_TYPE_NULL && b.type != SVDB_TYPE_NULL;
+    if (a.type == SVDB_TYPE_TEXT) return a.sval < b.sval;
+    return a.ival < b.ival;
+}
+
+/* Check plan against the image; false if the row executor must run it */
+bool plan_ok(const ColumnarImage &img, const Plan &plan) {
+    for (auto &pr : plan.preds)
+        if (!pred_ok(img, pr)) return false;
+    for (auto &it : plan.items)
+        if (it.fn != FN_COL && it.fn != FN_COUNT_STAR && !agg_ok(img.cols[it.col], it.fn)) return false;
+    if (plan.grouped) {
+        ColKind k = img.cols[plan.group_col].kind;
+        if (k != KIND_INT && k != KIND_TEXT && k != KIND_NULL) return false;
+    }
+    return img.nrows <= UINT32_MAX;
+}
+
+void run(svdb_db_t *db, const ColumnarImage &img, const Plan &plan, svdb_rows_t *r) {
+    for (auto &it : plan.items) r->col_names.push_back(it.name);
+    size_t n = img.nrows;
+    Bits sel = all_rows(n);
+    for (auto &pr : plan.preds) {
+        Bits m = eval_pred(img, pr);
+        svdb_bitmap_and(sel.data(), m.data(), sel.size());
+    }
+
+    std::vector<std::vector<SvdbVal>> out;
+    if (!plan.has_agg && !plan.grouped) {
+        int64_t skip = plan.offset;
+        for_each_row(sel, [&](size_t i) {
+            if (plan.limit >= 0 && (int64_t)out.size() >= plan.limit) return;
+            if (skip > 0) { --skip; return; }
+            std::vector<SvdbVal> row;
+            for (auto &it : plan.items) row.push_back(cell(db, plan.table, img.cols[it.col], i));
+            out.push_back(std::move(row));
+        });
+        r->rows = std::move(out);
+        return;
+    }
+
+    /* Groups in order of first appearance, as the row executor returns them */
+    std::vector<std::vector<uint32_t>> groups;
+    std::vector<SvdbVal> keys;
+    if (!plan.grouped) {
+        groups.emplace_back();
+        groups.back().reserve(svdb_bitmap_popcount(sel.data(), sel.size()));
+        for_each_row(sel, [&](size_t i) { groups.back().push_back((uint32_t)i); });
+    } else {
+        const ColumnarColumn &g = img.cols[plan.group_col];
+        std::unordered_map<int64_t, size_t> int_group;
+        std::vector<size_t> text_group(g.dict.size(), SIZE_MAX);
+        size_t null_group = SIZE_MAX;
+        for_each_row(sel, [&](size_t i) {
+            size_t *slot;
+            if ((g.nulls[i >> 6] >> (i & 63)) & 1) slot = &null_group;
+            else if (g.kind == KIND_INT) slot = &int_group.emplace(g.ints[i], SIZE_MAX).first->second;
+            else slot = &text_group[g.codes[i]];
+            if (*slot == SIZE_MAX) {
+                *slot = groups.size();
+                groups.emplace_back();
+                keys.push_back(cell(db, plan.table, g, i));
+            }
+            groups[*slot].push_back((uint32_t)i);
+        });
+    }
+    std::vector<size_t> order(groups.size());
+    for (size_t k = 0; k < order.size(); ++k) order[k] = k;
+    if (plan.ordered) {
+        std::stable_sort(order.begin(), order.end(), [&](size_t a, size_t b) {
+            return plan.desc ? key_less(keys[b], keys[a]) : key_less(keys[a], keys[b]);
+        });
+    }
+    int64_t skip = plan.offset;
+    for (size_t k : order) {
+        if (plan.limit >= 0 && (int64_t)out.size() >= plan.limit) break;
+        if (skip > 0) { --skip; continue; }
+        std::vector<SvdbVal> row;
+        for (auto &it : plan.items)
+            row.push_back(it.fn == FN_COL ? keys[k] : aggregate(img, it, groups[k]));
+        out.push_back(std::move(row));
+    }
+    r->rows = std::move(out);
+}
+
+bool any_columnar(svdb_db_t *db) {
+    for (auto &kv : db->table_opts)
+        if (kv.second.columnar) return true;
+    return false;
+}
+
+} // namespace
+
+bool columnar_try_select(svdb_db_t *db, const std::string &sql, svdb_rows_t **rows_out) {
+    if (!any_columnar(db)) return false;
+    Plan plan;
+    Parser parser(db, sql);
+    if (!parser.parse(plan)) return false;
+    std::shared_ptr<ColumnarImage> img = image_for(db, plan.table);
+    if (!plan_ok(*img, plan)) return false;
+    svdb_rows_t *r = new (std::nothrow) svdb_rows_t();
+    if (!r) return false;
+    run(db, *img, plan, r);
+    *rows_out = r;
+    return true;
+}
+
+bool columnar_explain(svdb_db_t *db, const std::string &sql, std::string &detail) {
+    if (!any_columnar(db)) return false;
+    Plan plan;
+    Parser parser(db, sql);
+    if (!parser.parse(plan)) return false;
+    std::shared_ptr<ColumnarImage> img = image_for(db, plan.table);
+    if (!plan_ok(*img, plan)) return false;
+    detail = "SCAN " + plan.table + " USING COLUMNAR";
+    std::vector<std::string> indexed;
+    for (auto &pr : plan.preds) {
+        const std::string &name = img->cols[pr.col].name;
+        if (uses_index(*img, pr) && std::find(indexed.begin(), indexed.end(), name) == indexed.end())
+            indexed.push_back(name);
+    }
+    for (size_t i = 0; i < indexed.size(); ++i)
+        detail += (i ? ", " : " (bitmap index on ") + indexed[i];
+    if (!indexed.empty()) detail += ")";
+    return true;
+}
+
+void columnar_forget(svdb_db_t *db, const std::string &table) {
+    db->columnar.erase(table);
+}
+
+void columnar_note_row(svdb_db_t *db, const std::string &table, size_t pos) {
+    for (auto &kv : db->columnar) {
+        ColumnarImage *img = kv.second.get();
+        if (!img || img->gen + 1 != db->data_gen) continue;
+        img->gen = db->data_gen;
+        if (kv.first != table) continue;
+        const std::vector<Row> &rows = db->data[table];
+        bool added = pos == img->nrows && rows.size() == img->nrows + 1;
+        if (!added && !(pos < img->nrows && rows.size() == img->nrows)) { img->gen = 0; continue; }
+        size_t n = rows.size();
+        const Row &row = rows[pos];
+        for (auto &c : img->cols) {
+            int p = row.layout()->find(c.name);
+            if (!column_put(c, pos, n, added, p < 0 ? nullptr : &row.at_pos((size_t)p)))
+                reload_column(c, rows);
+            else if (!c.indexed && n >= 2 * std::max<size_t>(c.indexed_at, 1))
+                index_column(c, n);
+        }
+        img->nrows = n;
+    }
+}
+
+void columnar_note_delete(svdb_db_t *db, const std::string &table, const std::vector<size_t> &gone) {
+    for (auto &kv : db->columnar) {
+        ColumnarImage *img = kv.second.get();
+        if (!img || img->gen + 1 != db->data_gen) continue;
+        img->gen = db->data_gen;
+        if (kv.first != table) continue;
+        size_t n = db->data[table].size();
+        if (n + gone.size() != img->nrows) { img->gen = 0; continue; }
+        for (auto &c : img->cols) column_erase(c, gone, img->nrows);
+        img->nrows = n;
+    }
+}
@@ -1,5 +1,6 @@
 #include "svdb.h"
 #include "svdb_types.h"
+#include "svdb_storage.h"
 #include <cstring>
 #include <cstdlib>
 #include <cerrno>
@@ -21,19 +22,36 @@ static bool path_accessible(const char *path) {
 extern "C" {
 
 svdb_code_t svdb_open(const char *path, svdb_db_t **db) {
+    return svdb_open_v2(path, SVDB_OPEN_READWRITE | SVDB_OPEN_CREATE, db);
+}
+
+svdb_code_t svdb_open_v2(const char *path, int flags, svdb_db_t **db) {
     if (!path || !db) return SVDB_ERR;
+    if (flags & SVDB_OPEN_MEMORY) path = ":memory:";
     if (!path_accessible(path)) return SVDB_ERR;
+    struct stat st;
+    if (!(flags & SVDB_OPEN_CREATE) && strcmp(path, ":memory:") != 0 && stat(path, &st) != 0)
+        return SVDB_NOTFOUND;
     svdb_db_t *d = new (std::nothrow) svdb_db_t();
     if (!d) return SVDB_NOMEM;
     d->path = path;
+    d->read_only = (flags & SVDB_OPEN_READONLY) != 0;
+    std::string err;
+    if (!storage_load(d, err)) {
+        delete d;
+        return SVDB_CORRUPT;
+    }
     *db = d;
     return SVDB_OK;
 }
 
 svdb_code_t svdb_close(svdb_db_t *db) {
     if (!db) return SVDB_ERR;
+    svdb_code_t rc = SVDB_OK;
+    std::string err;
+    if (!storage_close(db, err)) rc = SVDB_ERR;
     delete db;
-    return SVDB_OK;
+    return rc;
 }
 
 const char *svdb_errmsg(svdb_db_t *db) {
@@ -0,0 +1,534 @@
+/*
+ * describe.cpp — types and origins of result columns
+ *
+ * After a SELECT runs, its select list is matched up with the FROM clause to
+ * tell, for each result column, the table column it reads (through views,
+ * subqueries and CTEs), that column's declared type, and whether it can be
+ * NULL. This is what sqlite3_column_decltype(), sqlite3_column_table_name()
+ * and sqlite3_column_origin_name() report. A result column computed by an
+ * expression has no origin; CAST, literals and count() still give a type.
+ */
+#include "svdb_describe.h"
+#include "svdb_util.h"
+#include <cctype>
+#include <map>
+
+namespace {
+
+/* Views and subqueries nest at most this deep */
+const int kMaxDepth = 16;
+
+enum TokKind { TK_IDENT, TK_QUOTED, TK_STRING, TK_NUMBER, TK_BLOB, TK_PUNCT, TK_END };
+
+struct Tok {
+    TokKind kind;
+    std::string text;   /* identifier or string contents; punctuation as is */
+    std::string upper;  /* upper-cased text of TK_IDENT */
+};
+
+std::vector<Tok> tokenize(const std::string &sql) {
+    std::vector<Tok> out;
+    size_t i = 0, n = sql.size();
+    while (i < n) {
+        unsigned char c = (unsigned char)sql[i];
+        if (isspace(c)) { ++i; continue; }
+        if (c == '-' && i + 1 < n && sql[i + 1] == '-') {
+            while (i < n && sql[i] != '\n') ++i;
+            continue;
+        }
+        if (c == '/' && i + 1 < n && sql[i + 1] == '*') {
+            size_t e = sql.find("*/", i + 2);
+            i = e == std::string::npos ? n : e + 2;
+            continue;
+        }
+        if ((c == 'x' || c == 'X') && i + 1 < n && sql[i + 1] == '\'') {
+            size_t e = sql.find('\'', i + 2);
+            if (e == std::string::npos) e = n;
+            out.push_back({TK_BLOB, sql.substr(i + 2, e - i - 2), ""});
+            i = e + 1;
+            continue;
+        }
+        if (c == '\'' || c == '"' || c == '`' || c == '[') {
+            char close = c == '[' ? ']' : (char)c;
+            std::string text;
+            size_t j = i + 1;
+            for (; j < n; ++j) {
+                if (sql[j] != close) { text += sql[j]; continue; }
+                if (close != ']' && j + 1 < n && sql[j + 1] == close) { text += close; ++j; continue; }
+                break;
+            }
+            out.push_back({c == '\'' ? TK_STRING : TK_QUOTED, text, ""});
+            i = j + 1;
+            continue;
+        }
+        if (isdigit(c) || (c == '.' && i + 1 < n && isdigit((unsigned char)sql[i + 1]))) {
+            size_t j = i;
+            while (j < n && (isalnum((unsigned char)sql[j]) || sql[j] == '.' ||
+                             ((sql[j] == '+' || sql[j] == '-') && (sql[j - 1] == 'e' || sql[j - 1] == 'E'))))
+                ++j;
+            out.push_back({TK_NUMBER, sql.substr(i, j - i), ""});
+            i = j;
+            continue;
+        }
+        if (isalpha(c) || c == '_' || c >= 0x80) {
+            size_t j = i;
+            while (j < n && (isalnum((unsigned char)sql[j]) || sql[j] == '_' || sql[j] == '$' ||
+                             (unsigned char)sql[j] >= 0x80))
+                ++j;
+            std::string text = sql.substr(i, j - i);
+            out.push_back({TK_IDENT, text, svdb_str_upper(text)});
+            i = j;
+            continue;
+        }
+        /* Two-character operators are kept whole so "<=" is never split */
+        static const char *ops[] = {"||", "<=", ">=", "<>", "!=", "==", "<<", ">>", "->", nullptr};
+        std::string punct(1, (char)c);
+        for (const char **op = ops; *op; ++op)
+            if (sql.compare(i, 2, *op) == 0) { punct = *op; break; }
+        out.push_back({TK_PUNCT, punct, ""});
+        i += punct.size();
+    }
+    out.push_back({TK_END, "", ""});
+    return out;
+}
+
+bool is_kw(const Tok &t, const char *kw) {
+    return t.kind == TK_IDENT && t.upper == kw;
+}
+
+bool is_punct(const Tok &t, const char *p) {
+    return t.kind == TK_PUNCT && t.text == p;
+}
+
+bool is_name(const Tok &t) {
+    return t.kind == TK_IDENT || t.kind == TK_QUOTED;
+}
+
+/* Words that end a FROM item or a select list at the top level */
+bool is_clause_kw(const Tok &t) {
+    static const char *kws[] = {"FROM", "WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "WINDOW",
+                                "UNION", "INTERSECT", "EXCEPT", "ON", "USING", "JOIN", "NATURAL",
+                                "LEFT", "RIGHT", "FULL", "INNER", "CROSS", "OUTER", "OFFSET",
+                                "RETURNING", nullptr};
+    if (t.kind != TK_IDENT) return false;
+    for (const char **k = kws; *k; ++k)
+        if (t.upper == *k) return true;
+    return false;
+}
+
+/* Index just past the parenthesis matching the one at i */
+size_t skip_parens(const std::vector<Tok> &toks, size_t i) {
+    int depth = 0;
+    for (; toks[i].kind != TK_END; ++i) {
+        if (is_punct(toks[i], "(")) ++depth;
+        else if (is_punct(toks[i], ")") && --depth == 0) return i + 1;
+    }
+    return i;
+}
+
+/* Column types as CREATE TABLE wrote them, e.g. "VARCHAR(40)", by
+ * upper-cased column name. The schema keeps only the type's words. */
+std::map<std::string, std::string> declared_types(const std::string &create_sql) {
+    std::map<std::string, std::string> out;
+    std::vector<Tok> toks = tokenize(create_sql);
+    size_t i = 0;
+    while (toks[i].kind != TK_END && !is_punct(toks[i], "(")) ++i;
+    if (toks[i].kind == TK_END) return out;
+    ++i;
+    static const char *stop[] = {"CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK", "DEFAULT",
+                                 "COLLATE", "REFERENCES", "GENERATED", "AS", nullptr};
+    while (toks[i].kind != TK_END) {
+        /* One column definition or table constraint, up to a top-level comma */
+        size_t b = i;
+        int depth = 0;
+        for (; toks[i].kind != TK_END; ++i) {
+            if (is_punct(toks[i], "(")) ++depth;
+            else if (is_punct(toks[i], ")")) { if (depth == 0) break; --depth; }
+            else if (depth == 0 && is_punct(toks[i], ",")) break;
+        }
+        size_t e = i;
+        if (toks[i].kind != TK_END) ++i;
+        if (e <= b || !is_name(toks[b])) continue;
+        if (toks[b].kind == TK_IDENT && (toks[b].upper == "CONSTRAINT" || toks[b].upper == "PRIMARY" ||
+                                         toks[b].upper == "UNIQUE" || toks[b].upper == "CHECK" ||
+                                         toks[b].upper == "FOREIGN"))
+            continue;
+        std::string type;
+        size_t k = b + 1;
+        for (; k < e && toks[k].kind == TK_IDENT; ++k) {
+            bool is_stop = false;
+            for (const char **w = stop; *w; ++w)
+                if (toks[k].upper == *w) { is_stop = true; break; }
+            if (is_stop) break;
+            if (!type.empty()) type += " ";
+            type += toks[k].text;
+        }
+        if (!type.empty() && k < e && is_punct(toks[k], "(")) {
+            size_t end = skip_parens(toks, k);
+            for (; k < end; ++k) {
+                if (toks[k].kind == TK_STRING) type += "'" + toks[k].text + "'";
+                else type += toks[k].text;
+                if (is_punct(toks[k], ",")) type += " ";
+            }
+        }
+        if (!type.empty()) out[svdb_str_upper(toks[b].text)] = type;
+    }
+    return out;
+}
+
+/* A column a source of a FROM clause offers */
+struct SourceCol {
+    std::string name;
+    SvdbColumnMeta meta;
+};
+
+struct Source {
+    std::string alias;  /* name the query refers to it by */
+    std::vector<SourceCol> cols;
+    bool outer = false; /* the NULL side of an outer join */
+};
+
+using CteMap = std::map<std::string, std::vector<SourceCol>>;
+
+struct Describer {
+    svdb_db_t *db;
+
+    std::vector<SourceCol> select(const std::vector<Tok> &toks, size_t &i, CteMap ctes, int depth);
+    bool table_cols(const std::string &name, const CteMap &ctes, int depth, std::vector<SourceCol> &out);
+    SourceCol item(const std::vector<Tok> &toks, size_t b, size_t e, const std::vector<Source> &from);
+};
+
+/* The columns of a table, view or CTE called name */
+bool Describer::table_cols(const std::string &name, const CteMap &ctes, int depth,
+                           std::vector<SourceCol> &out) {
+    auto cte = ctes.find(svdb_str_upper(name));
+    if (cte != ctes.end()) {
+        out = cte->second;
+        return true;
+    }
+    auto sc = find_table_case_insensitive(db->schema, name);
+    if (sc == db->schema.end()) return false;
+    const std::string &table = sc->first;
+    auto cs = db->create_sql.find(table);
+    if (cs != db->create_sql.end()) {
+        std::vector<Tok> toks = tokenize(cs->second);
+        if (toks.size() > 2 && is_kw(toks[0], "CREATE")) {
+            size_t i = 1;
+            if (is_kw(toks[i], "TEMP") || is_kw(toks[i], "TEMPORARY")) ++i;
+            if (is_kw(toks[i], "VIEW")) {
+                /* The view's columns are those of its SELECT, renamed by
+                 * an explicit column list */
+                std::vector<std::string> names;
+                for (; toks[i].kind != TK_END && !is_kw(toks[i], "AS"); ++i) {
+                    if (!is_punct(toks[i], "(")) continue;
+                    for (++i; toks[i].kind != TK_END && !is_punct(toks[i], ")"); ++i)
+                        if (is_name(toks[i])) names.push_back(toks[i].text);
+                }
+                if (toks[i].kind == TK_END || depth >= kMaxDepth) return false;
+                ++i;
+                out = select(toks, i, CteMap(), depth + 1);
+                for (size_t k = 0; k < names.size() && k < out.size(); ++k) out[k].name = names[k];
+                return true;
+            }
+        }
+    }
+    std::map<std::string, std::string> declared;
+    if (cs != db->create_sql.end()) declared = declared_types(cs->second);
+    auto co = db->col_order.find(table);
+    auto opts = db->table_opts.find(table);
+    bool without_rowid = opts != db->table_opts.end() && opts->second.without_rowid;
+    if (co == db->col_order.end()) return true;
+    for (auto &col : co->second) {
+        SourceCol c;
+        c.name = col;
+        c.meta.table = table;
+        c.meta.origin = col;
+        auto cd = sc->second.find(col);
+        if (cd != sc->second.end()) {
+            auto dt = declared.find(svdb_str_upper(col));
+            c.meta.decl_type = dt != declared.end() ? dt->second : cd->second.type;
+            bool rowid_alias = cd->second.primary_key && svdb_str_upper(cd->second.type) == "INTEGER";
+            bool not_null = cd->second.not_null || rowid_alias || (cd->second.primary_key && without_rowid);
+            c.meta.nullable = not_null ? 0 : 1;
+        } else {
+            c.meta.nullable = 1;
+        }
+        out.push_back(c);
+    }
+    return true;
+}
+
+/* What the select item toks[b, e) reads */
+SourceCol Describer::item(const std::vector<Tok> &toks, size_t b, size_t e,
+                          const std::vector<Source> &from) {
+    SourceCol out;
+    /* An alias names the column: "expr AS name" or "expr name" */
+    if (e - b >= 2 && is_name(toks[e - 1]) && !is_clause_kw(toks[e - 1])) {
+        const Tok &prev = toks[e - 2];
+        if (is_kw(prev, "AS")) {
+            out.name = toks[e - 1].text;
+            e -= 2;
+        } else if (is_name(prev) || prev.kind == TK_STRING || prev.kind == TK_NUMBER || is_punct(prev, ")")) {
+            if (!(e - b == 3 && is_punct(toks[b + 1], "."))) {
+                out.name = toks[e - 1].text;
+                e -= 1;
+            }
+        }
+    }
+    while (e - b >= 2 && is_punct(toks[b], "(") && skip_parens(toks, b) == e) { ++b; --e; }
+    if (e <= b) return out;
+
+    /* A column, bare or qualified */
+    std::string qual, col;
+    if (e - b == 1 && is_name(toks[b])) col = toks[b].text;
+    else if (e - b == 3 && is_name(toks[b]) && is_punct(toks[b + 1], ".") && is_name(toks[b + 2])) {
+        qual = toks[b].text;
+        col = toks[b + 2].text;
+    }
+    if (!col.empty()) {
+        if (out.name.empty()) out.name = col;
+        std::string ucol = svdb_str_upper(col), uqual = svdb_str_upper(qual);
+        for (auto &src : from) {
+            if (!qual.empty() && svdb_str_upper(src.alias) != uqual) continue;
+            for (auto &c : src.cols) {
+                if (svdb_str_upper(c.name) != ucol) continue;
+                out.meta = c.meta;
+                if (src.outer) out.meta.nullable = 1;
+                return out;
+            }
+        }
+        /* The rowid of a table that has no column by that name */
+        if ((ucol == "ROWID" || ucol == "_ROWID_" || ucol == "OID") && !from.empty()) {
+            for (auto &src : from) {
+                if (!qual.empty() && svdb_str_upper(src.alias) != uqual) continue;
+                if (src.cols.empty() || src.cols[0].meta.table.empty()) break;
+                out.meta.decl_type = "INTEGER";
+                out.meta.table = src.cols[0].meta.table;
+                out.meta.origin = "rowid";
+                out.meta.nullable = src.outer ? 1 : 0;
+                break;
+            }
+        }
+        return out;
+    }
+
+    if (out.name.empty())
+        for (size_t k = b; k < e; ++k) out.name += toks[k].text;
+    const Tok &first = toks[b];
+    if (e - b == 1 || (e - b == 2 && (is_punct(first, "-") || is_punct(first, "+")))) {
+        const Tok &lit = toks[e - 1];
+        if (lit.kind == TK_NUMBER) {
+            bool real = lit.text.find_first_of(".eE") != std::string::npos &&
+                        lit.text.compare(0, 2, "0x") != 0 && lit.text.compare(0, 2, "0X") != 0;
+            out.meta.decl_type = real ? "REAL" : "INTEGER";
+            out.meta.nullable = 0;
+        } else if (lit.kind == TK_STRING && e - b == 1) {
+            out.meta.decl_type = "TEXT";
+            out.meta.nullable = 0;
+        } else if (lit.kind == TK_BLOB && e - b == 1) {
+            out.meta.decl_type = "BLOB";
+            out.meta.nullable = 0;
+        } else if (is_kw(lit, "NULL") && e - b == 1) {
+            out.meta.nullable = 1;
+        }
+        return out;
+    }
+    if (first.kind == TK_IDENT && e - b >= 3 && is_punct(toks[b + 1], "(") && skip_parens(toks, b + 1) == e) {
+        if (first.upper == "COUNT") {
+            out.meta.decl_type = "INTEGER";
+            out.meta.nullable = 0;
+        } else if (first.upper == "CAST") {
+            /* CAST(expr AS type): the type is everything after the last
+             * top-level AS */
+            size_t as = 0;
+            int depth = 0;
+            for (size_t k = b + 2; k + 1 < e; ++k) {
+                if (is_punct(toks[k], "(")) ++depth;
+                else if (is_punct(toks[k], ")")) --depth;
+                else if (depth == 0 && is_kw(toks[k], "AS")) as = k;
+            }
+            if (as) {
+                std::string type;
+                for (size_t k = as + 1; k + 1 < e; ++k) {
+                    bool glue = is_punct(toks[k], "(") || is_punct(toks[k], ")") || is_punct(toks[k], ",") ||
+                                (k > as + 1 && (is_punct(toks[k - 1], "(") || is_punct(toks[k - 1], ",")));
+                    if (!type.empty() && !glue) type += " ";
+                    type += toks[k].text;
+                }
+                out.meta.decl_type = type;
+            }
+        }
+    }
+    return out;
+}
+
+/* Describe the SELECT starting at toks[i], leaving i past it */
+std::vector<SourceCol> Describer::select(const std::vector<Tok> &toks, size_t &i, CteMap ctes, int depth) {
+    std::vector<SourceCol> result;
+    if (is_kw(toks[i], "WITH")) {
+        ++i;
+        if (is_kw(toks[i], "RECURSIVE")) ++i;
+        while (is_name(toks[i])) {
+            std::string name = svdb_str_upper(toks[i].text);
+            std::vector<std::string> names;
+            ++i;
+            if (is_punct(toks[i], "(")) {
+                size_t end = skip_parens(toks, i);
+                for (size_t k = i + 1; k + 1 < end; ++k)
+                    if (is_name(toks[k])) names.push_back(toks[k].text);
+                i = end;
+            }
+            if (!is_kw(toks[i], "AS")) return result;
+            ++i;
+            if (is_kw(toks[i], "NOT")) ++i;
+            if (is_kw(toks[i], "MATERIALIZED")) ++i;
+            if (!is_punct(toks[i], "(")) return result;
+            size_t end = skip_parens(toks, i);
+            size_t k = i + 1;
+            std::vector<SourceCol> cols;
+            if (depth < kMaxDepth) cols = select(toks, k, ctes, depth + 1);
+            for (size_t c = 0; c < names.size() && c < cols.size(); ++c) cols[c].name = names[c];
+            ctes[name] = cols;
+            i = end;
+            if (!is_punct(toks[i], ",")) break;
+            ++i;
+        }
+    }
+    if (is_kw(toks[i], "VALUES")) return result;
+    if (!is_kw(toks[i], "SELECT")) return result;
+    ++i;
+    if (is_kw(toks[i], "DISTINCT") || is_kw(toks[i], "ALL")) ++i;
+
+    /* Select items, split at top-level commas */
+    std::vector<std::pair<size_t, size_t>> items;
+    size_t start = i;
+    int depth_p = 0;
+    for (; toks[i].kind != TK_END; ++i) {
+        const Tok &t = toks[i];
+        if (is_punct(t, "(")) ++depth_p;
+        else if (is_punct(t, ")")) { if (depth_p == 0) break; --depth_p; }
+        else if (depth_p == 0 && (is_punct(t, ",") || is_punct(t, ";"))) {
+            items.push_back({start, i});
+            if (is_punct(t, ";")) break;
+            start = i + 1;
+        } else if (depth_p == 0 && t.kind == TK_IDENT &&
+                   (t.upper == "FROM" || t.upper == "WHERE" || t.upper == "GROUP" || t.upper == "HAVING" ||
+                    t.upper == "ORDER" || t.upper == "LIMIT" || t.upper == "WINDOW" || t.upper == "UNION" ||
+                    t.upper == "INTERSECT" || t.upper == "EXCEPT"))
+            break;
+    }
+    if (start < i && (items.empty() || items.back().second != i)) items.push_back({start, i});
+
+    /* FROM sources and their joins */
+    std::vector<Source> from;
+    if (is_kw(toks[i], "FROM")) {
+        ++i;
+        bool next_outer = false;
+        while (toks[i].kind != TK_END && !is_punct(toks[i], ")") && !is_punct(toks[i], ";")) {
+            Source src;
+            bool known = false;
+            if (is_punct(toks[i], "(")) {
+                size_t end = skip_parens(toks, i);
+                size_t k = i + 1;
+                if ((is_kw(toks[k], "SELECT") || is_kw(toks[k], "WITH")) && depth < kMaxDepth) {
+                    src.cols = select(toks, k, ctes, depth + 1);
+                    known = true;
+                }
+                i = end;
+            } else if (is_name(toks[i])) {
+                std::string name = toks[i].text;
+                ++i;
+                if (is_punct(toks[i], ".") && is_name(toks[i + 1])) {
+                    name = toks[i + 1].text; /* schema-qualified */
+                    i += 2;
+                }
+                src.alias = name;
+                if (is_punct(toks[i], "(")) i = skip_parens(toks, i); /* table-valued function */
+                else known = table_cols(name, ctes, depth, src.cols);
+            } else {
+                break;
+            }
+            if (is_kw(toks[i], "AS")) ++i;
+            if (is_name(toks[i]) && !is_clause_kw(toks[i]) && !is_kw(toks[i], "NOT") &&
+                !is_kw(toks[i], "INDEXED")) {
+                src.alias = toks[i].text;
+                ++i;
+            }
+            src.outer = next_outer;
+            if (!known) src.cols.clear();
+            from.push_back(src);
+
+            /* Skip ON/USING/INDEXED BY up to the next join or clause */
+            next_outer = false;
+            bool more = false;
+            int dp = 0;
+            for (; toks[i].kind != TK_END; ++i) {
+                const Tok &t = toks[i];
+                if (is_punct(t, "(")) { ++dp; continue; }
+                if (is_punct(t, ")")) { if (dp == 0) break; --dp; continue; }
+                if (dp > 0) continue;
+                if (is_punct(t, ",")) { more = true; ++i; break; }
+                if (is_punct(t, ";")) break;
+                if (t.kind != TK_IDENT) continue;
+                if (t.upper == "WHERE" || t.upper == "GROUP" || t.upper == "HAVING" || t.upper == "ORDER" ||
+                    t.upper == "LIMIT" || t.upper == "WINDOW" || t.upper == "UNION" ||
+                    t.upper == "INTERSECT" || t.upper == "EXCEPT")
+                    break;
+                if (t.upper == "LEFT") next_outer = true;
+                if (t.upper == "RIGHT" || t.upper == "FULL") {
+                    for (auto &s : from) s.outer = true;
+                    next_outer = t.upper == "FULL";
+                }
+                if (t.upper == "JOIN") { more = true; ++i; break; }
+            }
+            if (!more) break;
+        }
+    }
+
+    for (auto &it : items) {
+        size_t b = it.first, e = it.second;
+        if (e - b == 1 && is_punct(toks[b], "*")) {
+            for (auto &src : from)
+                for (auto c : src.cols) {
+                    if (src.outer) c.meta.nullable = 1;
+                    result.push_back(c);
+                }
+        } else if (e - b == 3 && is_name(toks[b]) && is_punct(toks[b + 1], ".") && is_punct(toks[b + 2], "*")) {
+            std::string q = svdb_str_upper(toks[b].text);
+            for (auto &src : from) {
+                if (svdb_str_upper(src.alias) != q) continue;
+                for (auto c : src.cols) {
+                    if (src.outer) c.meta.nullable = 1;
+                    result.push_back(c);
+                }
+            }
+        } else {
+            result.push_back(item(toks, b, e, from));
+        }
+    }
+
+    /* Skip the rest of this SELECT; a compound's later parts may add NULLs */
+    int dp = 0;
+    for (; toks[i].kind != TK_END; ++i) {
+        if (is_punct(toks[i], "(")) ++dp;
+        else if (is_punct(toks[i], ")")) { if (dp == 0) break; --dp; }
+        else if (dp == 0 && (is_kw(toks[i], "UNION") || is_kw(toks[i], "INTERSECT") || is_kw(toks[i], "EXCEPT"))) {
+            for (auto &c : result)
+                if (c.meta.nullable == 0) c.meta.nullable = -1;
+        }
+    }
+    return result;
+}
+
+} // namespace
+
+void describe_columns(svdb_db_t *db, const std::string &sql, svdb_rows_t *rows) {
+    rows->col_meta.assign(rows->col_names.size(), SvdbColumnMeta());
+    std::vector<Tok> toks = tokenize(sql);
+    if (!is_kw(toks[0], "SELECT") && !is_kw(toks[0], "WITH")) return;
+    Describer d{db};
+    size_t i = 0;
+    std::vector<SourceCol> cols = d.select(toks, i, CteMap(), 0);
+    if (cols.size() != rows->col_names.size()) return;
+    for (size_t k = 0; k < cols.size(); ++k) rows->col_meta[k] = cols[k].meta;
+}
@@ -9,6 +9,11 @@
 #include "svdb.h"
 #include "svdb_types.h"
 #include "svdb_util.h"
+#include "svdb_fts5.h"
+#include "svdb_storage.h"
+#include "svdb_columnar.h"
+#include "svdb_vacuum.h"
+#include "svdb_bulk.h"
 #include "../SF/svdb_assert.h"
 #include "QP/parser.h"
 
@@ -127,6 +132,87 @@ static std::string first_keyword(const std::string &sql) {
     return str_upper(kw);
 }
 
+/* Split s on top-level commas */
+static std::vector<std::string> split_top_level(const std::string &s) {
+    std::vector<std::string> out;
+    int depth = 0; char quote = 0;
+    size_t start = 0;
+    for (size_t i = 0; i <= s.size(); ++i) {
+        char c = i < s.size() ? s[i] : ',';
+        if (quote) { if (c == quote) quote = 0; continue; }
+        if (c == '\'' || c == '"' || c == '`') { quote = c; continue; }
+        if (c == '(') ++depth;
+        else if (c == ')') { if (depth > 0) --depth; }
+        else if (c == ',' && depth == 0) {
+            std::string item = str_trim(s.substr(start, i - start));
+            if (!item.empty()) out.push_back(item);
+            start = i + 1;
+        }
+    }
+    return out;
+}
+
+/* Parse an optional "[NOT] DEFERRABLE [INITIALLY DEFERRED|IMMEDIATE]" clause
+ * at pos, the tail of a REFERENCES clause, into fk */
+static void parse_fk_deferrable(const std::string &sql, size_t &pos, FKDef &fk) {
+    auto word_at = [&](size_t from, size_t &end) {
+        while (from < sql.size() && isspace((unsigned char)sql[from])) ++from;
+        end = from;
+        while (end < sql.size() && isalpha((unsigned char)sql[end])) ++end;
+        return str_upper(sql.substr(from, end - from));
+    };
+    size_t end, end2;
+    std::string w = word_at(pos, end);
+    bool negated = w == "NOT";
+    if (negated) w = word_at(end, end);
+    if (w != "DEFERRABLE") return;
+    pos = end;
+    fk.deferrable = !negated;
+    if (word_at(pos, end) != "INITIALLY") return;
+    w = word_at(end, end2);
+    if (w != "DEFERRED" && w != "IMMEDIATE") return;
+    pos = end2;
+    fk.initially_deferred = fk.deferrable && w == "DEFERRED";
+}
+
+/* Read a column's declared type at pos: empty, or several words such as
+ * DOUBLE PRECISION, with an optional size such as VARCHAR(255) kept as
+ * written. The words are upper-cased; pos ends after the type. */
+static std::string parse_decl_type(const std::string &sql, size_t &pos) {
+    std::string col_type;
+    while (pos < sql.size()) {
+        size_t ws = pos;
+        while (pos < sql.size() && (isalnum((unsigned char)sql[pos]) || sql[pos] == '_')) ++pos;
+        std::string word = str_upper(sql.substr(ws, pos - ws));
+        if (word.empty() || word == "CONSTRAINT" || word == "PRIMARY" || word == "NOT" ||
+            word == "NULL" || word == "UNIQUE" || word == "CHECK" || word == "DEFAULT" ||
+            word == "COLLATE" || word == "REFERENCES" || word == "GENERATED" || word == "AS") {
+            pos = ws;
+            break;
+        }
+        if (!col_type.empty()) col_type += " ";
+        col_type += word;
+        size_t after = pos;
+        while (after < sql.size() && isspace((unsigned char)sql[after])) ++after;
+        if (after >= sql.size() || !(isalpha((unsigned char)sql[after]) || sql[after] == '_')) break;
+        pos = after;
+    }
+    size_t after = pos;
+    while (after < sql.size() && isspace((unsigned char)sql[after])) ++after;
+    if (!col_type.empty() && after < sql.size() && sql[after] == '(') {
+        size_t start = after;
+        int pd = 1; ++after;
+        while (after < sql.size() && pd > 0) {
+            if (sql[after] == '(') ++pd;
+            else if (sql[after] == ')') --pd;
+            ++after;
+        }
+        col_type += sql.substr(start, after - start);
+        pos = after;
+    }
+    return col_type;
+}
+
 /* Parse column definitions from CREATE TABLE sql (after the opening '(').
  * Fills schema ColDef and col_order for the table.
  * Returns number of column-level PRIMARY KEY declarations (for validation). */
@@ -312,6 +398,7 @@ static int parse_column_defs(const std::string &sql, size_t pos,
                     else if (w2 == "UPDATE") parse_fk_action(w2, fk.on_update);
                     else break;
                 }
+                parse_fk_deferrable(sql, pos, fk);
                 out_fks->push_back(fk);
             }
             while (pos < sql.size() && sql[pos] != ',' && sql[pos] != ')') ++pos;
@@ -337,24 +424,7 @@ static int parse_column_defs(const std::string &sql, size_t pos,
         /* Skip whitespace */
         while (pos < sql.size() && isspace((unsigned char)sql[pos])) ++pos;
 
-        /* Read type (may be empty) */
-        std::string col_type;
-        while (pos < sql.size() && (isalnum((unsigned char)sql[pos]) || sql[pos] == '_')) {
-            col_type += (char)toupper((unsigned char)sql[pos]);
-            ++pos;
-        }
-        /* Handle type with precision e.g. VARCHAR(255) */
-        while (pos < sql.size() && isspace((unsigned char)sql[pos])) ++pos;
-        if (pos < sql.size() && sql[pos] == '(') {
-            int pd = 1; ++pos;
-            while (pos < sql.size() && pd > 0) {
-                if (sql[pos] == '(') ++pd;
-                else if (sql[pos] == ')') --pd;
-                ++pos;
-            }
-        }
-        if (col_type.empty()) col_type = "TEXT";
-
+        std::string col_type = parse_decl_type(sql, pos);
         ColDef cd;
         cd.type = col_type;
         cd.not_null = false;
@@ -461,6 +531,7 @@ static int parse_column_defs(const std::string &sql, size_t pos,
                         else if (evt == "UPDATE") fk.on_update = act;
                         else break;
                     }
+                    parse_fk_deferrable(sql, pos, fk);
                     out_fks->push_back(fk);
                 }
             } else if (ckw == "CHECK") {
@@ -493,6 +564,76 @@ static int parse_column_defs(const std::string &sql, size_t pos,
     return column_pk_count;
 }
 
+/* Parse the storage parameters of WITH (name = value, ...) into opts */
+static bool parse_with_options(const std::string &list, TableOpts &opts, std::string &err) {
+    for (auto &item : split_top_level(list)) {
+        size_t eq = item.find('=');
+        std::string name = str_upper(str_trim(item.substr(0, eq)));
+        std::string val = eq == std::string::npos ? "" : str_trim(item.substr(eq + 1));
+        if (val.size() >= 2 && (val.front() == '\'' || val.front() == '"') && val.back() == val.front())
+            val = val.substr(1, val.size() - 2);
+        if (name == "COMPRESSION" && eq != std::string::npos) {
+            if (storage_codec_id(val) < 0) {
+                err = "unknown compression algorithm: " + val;
+                return false;
+            }
+            opts.compression = str_upper(val);
+        } else {
+            err = "unknown table option: " + str_trim(item);
+            return false;
+        }
+    }
+    return true;
+}
+
+/* Parse the table options that follow the column list of a CREATE TABLE,
+ * e.g. "CREATE TABLE t(a INT) STRICT, WITHOUT ROWID",
+ * "... WITH (compression='zstd')" or "... USING COLUMNAR". Returns false with err set for an
+ * unknown option. */
+static bool parse_table_options(const std::string &sql, TableOpts &opts, std::string &err) {
+    size_t pos = sql.find('(');
+    if (pos == std::string::npos) return true;
+    int depth = 0;
+    char quote = 0;
+    for (; pos < sql.size(); ++pos) {
+        char c = sql[pos];
+        if (quote) { if (c == quote) quote = 0; continue; }
+        if (c == '\'' || c == '"' || c == '`') { quote = c; continue; }
+        if (c == '(') ++depth;
+        else if (c == ')' && --depth == 0) break;
+    }
+    if (pos >= sql.size()) return true;
+    std::string rest = sql.substr(pos + 1);
+    rest = rest.substr(0, rest.find(';'));
+    std::vector<std::string> parts = split_top_level(rest);
+    for (size_t i = 0; i < parts.size(); ++i) {
+        std::string raw = str_trim(parts[i]);
+        std::string opt = str_upper(raw);
+        /* Collapse internal whitespace so "WITHOUT   ROWID" compares equal */
+        std::string norm;
+        for (char c : opt) {
+            if (isspace((unsigned char)c)) { if (!norm.empty() && norm.back() != ' ') norm += ' '; }
+            else norm += c;
+        }
+        if (norm == "STRICT") {
+            opts.strict = true;
+        } else if (norm == "WITHOUT ROWID") {
+            opts.without_rowid = true;
+        } else if (norm == "USING COLUMNAR" || norm == "USING ROW") {
+            opts.columnar = norm == "USING COLUMNAR";
+        } else if (norm.compare(0, 4, "WITH") == 0 && raw.find('(') != std::string::npos &&
+                   str_trim(raw.substr(4, raw.find('(') - 4)).empty() && raw.back() == ')') {
+            size_t open = raw.find('(');
+            if (!parse_with_options(raw.substr(open + 1, raw.size() - open - 2), opts, err))
+                return false;
+        } else {
+            err = "unknown table option: " + raw;
+            return false;
+        }
+    }
+    return true;
+}
+
 /* Evaluate a simple WHERE expression against a single row.
  * Supports: col = 'val', col = num, col != val, col > num, col < num.
  * Returns true if row matches (or no WHERE given). */
@@ -705,7 +846,7 @@ static SvdbVal parse_literal(const std::string &v) {
         sv.type = SVDB_TYPE_TEXT;
         sv.sval = v.size() >= 2 ? v.substr(1, v.size() - 2) : "";
         /* unescape '' → ' */
-        std::string &s = sv.sval;
+        std::string s = sv.sval;
         std::string out;
         for (size_t i = 0; i < s.size(); ++i) {
             if (s[i] == '\'' && i + 1 < s.size() && s[i+1] == '\'') { out += '\''; ++i; }
@@ -732,6 +873,29 @@ static SvdbVal parse_literal(const std::string &v) {
     return sv;
 }
 
+/* True when a VALUES item is a bare literal that parse_literal handles
+ * exactly; anything else (function calls, operators) must be evaluated. */
+static bool is_plain_literal(const std::string &v) {
+    if (v.empty()) return true;
+    std::string vu = str_upper(v);
+    if (vu == "NULL" || vu == "TRUE" || vu == "FALSE") return true;
+    size_t i = 0;
+    if ((v[0] == 'x' || v[0] == 'X') && v.size() >= 3 && v[1] == '\'') i = 1;
+    if (v[i] == '\'') {
+        for (++i; i < v.size(); ++i) {
+            if (v[i] != '\'') continue;
+            if (i + 1 < v.size() && v[i+1] == '\'') { ++i; continue; }
+            return i + 1 == v.size();
+        }
+        return false;
+    }
+    if (v[0] == '-' || v[0] == '+') ++i;
+    if (i >= v.size()) return false;
+    char *end = nullptr;
+    strtod(v.c_str() + i, &end);
+    return end && *end == '\0' && end != v.c_str() + i;
+}
+
 /* Evaluate a simple expression for UPDATE SET clause values.
  * Handles: column refs, integer/float/string/NULL literals, arithmetic ops.
  * E.g. "v + 1", "price * 1.1", "col", "'hello'" */
@@ -939,8 +1103,243 @@ static bool eval_check_constraint(const std::string &expr, const Row &row,
     return true; /* unparseable expression — allow by default */
 }
 
+/* Convert the values of row to the affinity of their declared column types.
+ * In a STRICT table a value that cannot be stored losslessly in its column
+ * type is rejected instead. */
+static svdb_code_t apply_column_affinity(svdb_db_t *db, const std::string &tname, Row &row) {
+    auto sit = db->schema.find(tname);
+    if (sit == db->schema.end()) return SVDB_OK;
+    auto oit = db->table_opts.find(tname);
+    bool strict = oit != db->table_opts.end() && oit->second.strict;
+    for (const auto &cn : db->col_order[tname]) {
+        auto rit = row.find(cn);
+        auto cit = sit->second.find(cn);
+        if (rit == row.end() || cit == sit->second.end()) continue;
+        if (!strict) {
+            svdb_apply_affinity(rit->second, svdb_affinity_of(cit->second.type));
+        } else if (!svdb_strict_coerce(rit->second, cit->second.type)) {
+            db->last_error = std::string("cannot store ") + svdb_type_name(rit->second.type) +
+                             " value in " + str_upper(cit->second.type) + " column " +
+                             tname + "." + cn;
+            return SVDB_ERR;
+        }
+    }
+    return SVDB_OK;
+}
+
+/* ── WITHOUT ROWID (clustered) tables ───────────────────────────
+ * Rows of a WITHOUT ROWID table carry no rowid and db->data keeps them
+ * sorted by primary key, so key lookups are binary searches. */
+
+/* Primary key columns of a WITHOUT ROWID table, nullptr for rowid tables */
+static const std::vector<std::string> *clustered_key(svdb_db_t *db, const std::string &tname) {
+    auto oit = db->table_opts.find(tname);
+    if (oit == db->table_opts.end() || !oit->second.without_rowid) return nullptr;
+    auto pit = db->primary_keys.find(tname);
+    return pit == db->primary_keys.end() ? nullptr : &pit->second;
+}
+
+/* "UNIQUE constraint failed: t.a, t.b" for a key conflict */
+static std::string key_conflict_error(const std::string &tname, const std::vector<std::string> &key) {
+    std::string cols;
+    for (const auto &k : key) cols += (cols.empty() ? "" : ", ") + tname + "." + k;
+    return "UNIQUE constraint failed: " + cols;
+}
+
+/* Position of the row whose key equals row's key, or -1 if there is none */
+static int clustered_find(svdb_db_t *db, const std::string &tname,
+                          const std::vector<std::string> &key, const Row &row) {
+    auto &rows = db->data[tname];
+    auto it = std::lower_bound(rows.begin(), rows.end(), row,
+        [&](const Row &a, const Row &b) { return svdb_row_key_cmp(a, b, key) < 0; });
+    if (it != rows.end() && svdb_row_key_cmp(*it, row, key) == 0) return (int)(it - rows.begin());
+    return -1;
+}
+
+/* Record for the WAL that the row at pos of tname was changed in place,
+ * right after the write moved data_gen */
+static void note_row_written(svdb_db_t *db, const std::string &tname, size_t pos) {
+    storage_note_rows(db, tname, pos, db->data[tname].size() - 1 - pos);
+}
+
+/* Insert row at its key position */
+static void clustered_insert(svdb_db_t *db, const std::string &tname,
+                             const std::vector<std::string> &key, Row row) {
+    auto &rows = db->data[tname];
+    auto it = std::upper_bound(rows.begin(), rows.end(), row,
+        [&](const Row &a, const Row &b) { return svdb_row_key_cmp(a, b, key) < 0; });
+    size_t at = (size_t)(it - rows.begin());
+    rows.insert(it, std::move(row));
+    ++db->data_gen;
+    storage_note_rows(db, tname, at, rows.size() - 1 - at);
+}
+
+/* Restore key order after rows were changed in place. Returns false (with
+ * db->last_error set) when two rows now share a key. */
+static bool clustered_resort(svdb_db_t *db, const std::string &tname,
+                             const std::vector<std::string> &key) {
+    auto &rows = db->data[tname];
+    std::stable_sort(rows.begin(), rows.end(),
+        [&](const Row &a, const Row &b) { return svdb_row_key_cmp(a, b, key) < 0; });
+    ++db->data_gen;
+    storage_note_rows(db, tname, 0, 0);
+    for (size_t i = 1; i < rows.size(); ++i) {
+        if (svdb_row_key_cmp(rows[i-1], rows[i], key) == 0) {
+            db->last_error = key_conflict_error(tname, key);
+            return false;
+        }
+    }
+    return true;
+}
+
 /* ── DDL handlers ───────────────────────────────────────────────── */
 
+/* ── Index keys ─────────────────────────────────────────────────── */
+
+/* Index key terms are stored as the column name for plain columns and as the
+ * expression text otherwise */
+static bool index_term_is_column(svdb_db_t *db, const IndexDef &idx, const std::string &term) {
+    auto sit = db->schema.find(idx.table);
+    return sit != db->schema.end() && sit->second.count(term) > 0;
+}
+
+/* Whether row is part of idx (always true unless idx is a partial index) */
+static bool index_covers_row(svdb_db_t *db, const IndexDef &idx, const Row &row) {
+    if (idx.where.empty()) return true;
+    SvdbWhereTables where_tables{{{idx.table, idx.table}}, {}};
+    SvdbWhereTablesScope where_scope(&where_tables);
+    return svdb_eval_where_in_row(idx.where, row, db->col_order[idx.table]);
+}
+
+/* Evaluate the key of row in idx. Returns false if any part is NULL: such
+ * entries never collide in a UNIQUE index. */
+static bool index_key_of(svdb_db_t *db, const IndexDef &idx, const Row &row,
+                         std::vector<SvdbVal> &key) {
+    key.clear();
+    for (const auto &term : idx.columns) {
+        SvdbVal v;
+        if (index_term_is_column(db, idx, term)) {
+            auto it = row.find(term);
+            if (it != row.end()) v = it->second;
+        } else {
+            v = svdb_eval_expr_in_row(term, row, db->col_order[idx.table]);
+        }
+        if (v.type == SVDB_TYPE_NULL) return false;
+        key.push_back(v);
+    }
+    return true;
+}
+
+/* UNIQUE indexes on plain columns are enforced through unique_constraints;
+ * partial and expression indexes are checked by check_unique_indexes */
+static bool index_is_plain(svdb_db_t *db, const IndexDef &idx) {
+    if (!idx.where.empty()) return false;
+    for (const auto &term : idx.columns)
+        if (!index_term_is_column(db, idx, term)) return false;
+    return true;
+}
+
+static std::string unique_index_error(svdb_db_t *db, const std::string &iname, const IndexDef &idx) {
+    for (const auto &term : idx.columns)
+        if (!index_term_is_column(db, idx, term))
+            return "UNIQUE constraint failed: index '" + iname + "'";
+    return key_conflict_error(idx.table, idx.columns);
+}
+
+/* Append v to a uniqueness key. Values of different types never collide
+ * unless numeric is set, when numbers are encoded by value. */
+static void unique_key_part(std::string &out, const SvdbVal &v, bool numeric) {
+    int64_t i = v.ival;
+    double r = v.rval == 0.0 ? 0.0 : v.rval; /* -0.0 == 0.0 */
+    switch (v.type) {
+    case SVDB_TYPE_INT:
+        out += 'i'; out.append((const char *)&i, sizeof i);
+        return;
+    case SVDB_TYPE_REAL:
+        if (numeric && svdb_real_is_integral(r)) {
+            i = (int64_t)r;
+            out += 'i'; out.append((const char *)&i, sizeof i);
+        } else {
+            out += 'r'; out.append((const char *)&r, sizeof r);
+        }
+        return;
+    default: {
+        uint64_t n = v.sval.size();
+        out += v.type == SVDB_TYPE_BLOB ? 'b' : 't';
+        out.append((const char *)&n, sizeof n);
+        out += v.sval;
+        return;
+    }
+    }
+}
+
+/* The encoded key of row in idx, false if it has none there: a key part is
+ * NULL or, for a partial index, the row is not covered */
+static bool unique_index_key(svdb_db_t *db, const IndexDef &idx, const Row &row, std::string &out) {
+    std::vector<SvdbVal> key;
+    out.clear();
+    if (!index_key_of(db, idx, row, key) || !index_covers_row(db, idx, row)) return false;
+    for (const auto &v : key) unique_key_part(out, v, true);
+    return true;
+}
+
+/* The key counts of UNIQUE index iname, rebuilt if rows changed since */
+static const UniqueKeys &unique_index_keys(svdb_db_t *db, const std::string &iname,
+                                           const IndexDef &idx) {
+    UniqueKeys &uk = db->unique_keys[iname];
+    if (uk.gen != db->data_gen) {
+        uk.counts.clear();
+        std::string key;
+        for (const auto &r : db->data[idx.table])
+            if (unique_index_key(db, idx, r, key)) ++uk.counts[key];
+        uk.gen = db->data_gen;
+    }
+    return uk;
+}
+
+/* Check row against the partial and expression UNIQUE indexes of tname. self
+ * is the stored row when checking an UPDATE; it never conflicts with itself. */
+static svdb_code_t check_unique_indexes(svdb_db_t *db, const std::string &tname,
+                                        const Row &row, const Row *self = nullptr) {
+    std::string key, own;
+    for (const auto &kv : db->indexes) {
+        const IndexDef &idx = kv.second;
+        if (!idx.unique || idx.table != tname || index_is_plain(db, idx)) continue;
+        if (!unique_index_key(db, idx, row, key)) continue;
+        const UniqueKeys &uk = unique_index_keys(db, kv.first, idx);
+        auto it = uk.counts.find(key);
+        uint32_t n = it == uk.counts.end() ? 0 : it->second;
+        if (n && self && (self == &row || (unique_index_key(db, idx, *self, own) && own == key))) --n;
+        if (n) {
+            db->last_error = unique_index_error(db, kv.first, idx);
+            return SVDB_ERR;
+        }
+    }
+    return SVDB_OK;
+}
+
+/* Fold a write of one row of tname into the UNIQUE index key counts: old is
+ * its previous content (nullptr for an insert), row the new one. Call right
+ * after the write moved data_gen; counts that also missed some other change
+ * are dropped and rebuilt by the next check. */
+static void unique_indexes_note(svdb_db_t *db, const std::string &tname, const Row *old, const Row &row) {
+    std::string key;
+    for (const auto &kv : db->indexes) {
+        const IndexDef &idx = kv.second;
+        if (!idx.unique || idx.table != tname || index_is_plain(db, idx)) continue;
+        auto uit = db->unique_keys.find(kv.first);
+        if (uit == db->unique_keys.end()) continue;
+        UniqueKeys &uk = uit->second;
+        if (uk.gen + 1 != db->data_gen) { uk.gen = 0; continue; }
+        if (old && unique_index_key(db, idx, *old, key)) {
+            auto it = uk.counts.find(key);
+            if (it != uk.counts.end() && --it->second == 0) uk.counts.erase(it);
+        }
+        if (unique_index_key(db, idx, row, key)) ++uk.counts[key];
+        uk.gen = db->data_gen;
+    }
+}
+
 static svdb_code_t do_create_table(svdb_db_t *db, const std::string &sql) {
     /* Use parser to get table name and column names */
     svdb_parser_t *p = svdb_parser_create(sql.c_str(), sql.size());
@@ -981,6 +1380,8 @@ static svdb_code_t do_create_table(svdb_db_t *db, const std::string &sql) {
     std::vector<std::vector<std::string>> uniqs;
     CheckList checks;
     std::vector<FKDef> fks;
+    TableOpts opts;
+    opts.columnar = db->storage_mode == "COLUMNAR";
     int column_pk_count = 0;
     
     if (!is_ctas) {
@@ -990,6 +1391,38 @@ static svdb_code_t do_create_table(svdb_db_t *db, const std::string &sql) {
             db->last_error = "duplicate column name: " + order.back();
             return SVDB_ERR;
         }
+        if (!parse_table_options(sql, opts, db->last_error)) return SVDB_ERR;
+        if (opts.strict) {
+            for (const auto &cn : order) {
+                const std::string &ct = td[cn].type;
+                if (ct.empty()) {
+                    db->last_error = "missing datatype for " + tname + "." + cn;
+                    return SVDB_ERR;
+                }
+                if (!svdb_strict_type_ok(ct)) {
+                    db->last_error = "unknown datatype for " + tname + "." + cn + ": \"" + ct + "\"";
+                    return SVDB_ERR;
+                }
+            }
+        }
+        if (opts.without_rowid) {
+            if (pks.empty()) {
+                db->last_error = "PRIMARY KEY missing on table " + tname;
+                return SVDB_ERR;
+            }
+            for (const auto &cn : pks) {
+                if (!td.count(cn)) {
+                    db->last_error = "no such column: " + cn;
+                    return SVDB_ERR;
+                }
+                if (td[cn].auto_increment) {
+                    db->last_error = "AUTOINCREMENT not allowed on WITHOUT ROWID tables";
+                    return SVDB_ERR;
+                }
+                /* The key identifies the row, so it can never be NULL */
+                td[cn].not_null = true;
+            }
+        }
     }
     /* For CREATE TABLE AS SELECT, td and order remain empty - will be populated by executing the SELECT */
 
@@ -1018,6 +1451,8 @@ static svdb_code_t do_create_table(svdb_db_t *db, const std::string &sql) {
     if (!uniqs.empty()) db->unique_constraints[tname] = uniqs;
     if (!checks.empty()) db->check_constraints[tname] = checks;
     if (!fks.empty()) db->fk_constraints[tname] = fks;
+    if (opts.strict || opts.without_rowid || !opts.compression.empty() || opts.columnar)
+        db->table_opts[tname] = opts;
     
     /* For CREATE TABLE AS SELECT, execute the SELECT and populate the table */
     if (is_ctas) {
@@ -1089,21 +1524,31 @@ static svdb_code_t do_create_index(svdb_db_t *db, const std::string &sql, bool u
     while (pos < su.size() && isspace((unsigned char)su[pos])) ++pos;
     /* Skip IF NOT EXISTS */
     if (su.substr(pos, 13) == "IF NOT EXISTS") { pos += 13; while (pos < su.size() && isspace((unsigned char)su[pos])) ++pos; }
-    /* Read index name */
-    size_t ns = pos;
-    while (pos < su.size() && !isspace((unsigned char)su[pos]) && su[pos] != '(') ++pos;
-    std::string iname = sql.substr(ns, pos - ns);
+    /* Read a name, quoted or up to a space or '(' */
+    auto read_name = [&]() {
+        size_t ns = pos;
+        if (pos < sql.size() && (sql[pos] == '"' || sql[pos] == '`' || sql[pos] == '[')) {
+            char close = sql[pos] == '[' ? ']' : sql[pos];
+            pos = sql.find(close, pos + 1);
+            pos = pos == std::string::npos ? sql.size() : pos + 1;
+        } else {
+            while (pos < su.size() && !isspace((unsigned char)su[pos]) && su[pos] != '(') ++pos;
+        }
+        return sql.substr(ns, pos - ns);
+    };
+    std::string iname = read_name();
+    if (is_quoted_identifier(iname)) iname = iname.substr(1, iname.size() - 2);
     /* Check for ON */
     while (pos < su.size() && su[pos] != 'O') ++pos;
     if (su.substr(pos, 2) != "ON") return SVDB_ERR;
     pos += 2;
     while (pos < su.size() && isspace((unsigned char)su[pos])) ++pos;
-    /* Read table name */
-    size_t ts = pos;
-    while (pos < su.size() && !isspace((unsigned char)su[pos]) && su[pos] != '(') ++pos;
-    std::string tname = sql.substr(ts, pos - ts);
+    std::string tname = read_name();
     /* Check table exists (case-insensitive for unquoted identifiers) */
     std::string resolved_tname = resolve_table_name(db, tname);
+    if (resolved_tname.empty() && is_quoted_identifier(tname) &&
+        db->schema.count(tname.substr(1, tname.size() - 2)))
+        resolved_tname = tname.substr(1, tname.size() - 2);
     if (resolved_tname.empty()) { db->last_error = "no such table: " + tname; return SVDB_ERR; }
     /* Check duplicate index name */
     if (db->indexes.count(iname)) {
@@ -1111,23 +1556,89 @@ static svdb_code_t do_create_index(svdb_db_t *db, const std::string &sql, bool u
         db->last_error = "index " + iname + " already exists";
         return SVDB_ERR;
     }
-    /* Read column list */
-    size_t paren = sql.find('(', pos);
-    size_t rparen = sql.rfind(')');
+    /* Key terms: the parenthesized list, then an optional WHERE predicate */
     IndexDef idef; idef.table = resolved_tname; idef.unique = unique;
-    if (paren != std::string::npos && rparen != std::string::npos) {
-        std::string cols_str = sql.substr(paren + 1, rparen - paren - 1);
-        std::istringstream ss(cols_str);
-        std::string col;
-        while (std::getline(ss, col, ',')) {
-            col = str_trim(col);
-            if (!col.empty()) idef.columns.push_back(col);
+    idef.sql = str_trim(sql);
+    while (!idef.sql.empty() && idef.sql.back() == ';') idef.sql = str_trim(idef.sql.substr(0, idef.sql.size() - 1));
+    size_t paren = sql.find('(', pos);
+    if (paren != std::string::npos) {
+        int depth = 0; char quote = 0;
+        size_t rparen = std::string::npos;
+        for (size_t i = paren; i < sql.size() && rparen == std::string::npos; ++i) {
+            char c = sql[i];
+            if (quote) { if (c == quote) quote = 0; continue; }
+            if (c == '\'' || c == '"' || c == '`') quote = c;
+            else if (c == '(') ++depth;
+            else if (c == ')' && --depth == 0) rparen = i;
+        }
+        if (rparen == std::string::npos) {
+            db->last_error = "incomplete input";
+            return SVDB_ERR;
+        }
+        const auto &td = db->schema[resolved_tname];
+        for (std::string term : split_top_level(sql.substr(paren + 1, rparen - paren - 1))) {
+            /* Sort order does not matter to an unordered index */
+            std::string tu = str_upper(term);
+            for (const char *dir : {" ASC", " DESC"}) {
+                size_t dl = strlen(dir);
+                if (tu.size() > dl && tu.compare(tu.size() - dl, dl, dir) == 0) {
+                    term = str_trim(term.substr(0, term.size() - dl));
+                    break;
+                }
+            }
+            std::string col = is_quoted_identifier(term) ? term.substr(1, term.size() - 2) : term;
+            bool bare = !col.empty();
+            for (char c : col) if (!isalnum((unsigned char)c) && c != '_') bare = false;
+            std::string found;
+            for (const auto &kv : td)
+                if (kv.first == col || (!is_quoted_identifier(term) && str_upper(kv.first) == str_upper(col)))
+                    found = kv.first;
+            if (!found.empty()) idef.columns.push_back(found);
+            else if (bare || is_quoted_identifier(term)) {
+                db->last_error = "no such column: " + col;
+                return SVDB_ERR;
+            } else idef.columns.push_back(term);
+        }
+        std::string rest = str_trim(sql.substr(rparen + 1));
+        while (!rest.empty() && (rest.back() == ';' || isspace((unsigned char)rest.back()))) rest.pop_back();
+        if (!rest.empty()) {
+            if (str_upper(rest.substr(0, 6)) != "WHERE " || str_trim(rest.substr(6)).empty()) {
+                db->last_error = "near \"" + rest + "\": syntax error";
+                return SVDB_ERR;
+            }
+            idef.where = str_trim(rest.substr(6));
         }
     }
-    db->indexes[iname] = idef;
-    /* If unique index, also register in unique_constraints for enforcement at INSERT */
+
+    /* A UNIQUE index can't be built over rows that already collide */
     if (unique && !idef.columns.empty()) {
-        db->unique_constraints[tname].push_back(idef.columns);
+        std::vector<std::vector<SvdbVal>> keys;
+        std::vector<SvdbVal> key;
+        svdb_set_query_db(db);
+        for (const auto &row : db->data[resolved_tname])
+            if (index_key_of(db, idef, row, key) && index_covers_row(db, idef, row)) keys.push_back(key);
+        svdb_set_query_db(nullptr);
+        auto key_cmp = [](const std::vector<SvdbVal> &a, const std::vector<SvdbVal> &b) {
+            for (size_t i = 0; i < a.size(); ++i) {
+                int c = svdb_val_order(a[i], b[i]);
+                if (c != 0) return c;
+            }
+            return 0;
+        };
+        std::sort(keys.begin(), keys.end(),
+                  [&](const std::vector<SvdbVal> &a, const std::vector<SvdbVal> &b) { return key_cmp(a, b) < 0; });
+        for (size_t i = 1; i < keys.size(); ++i) {
+            if (key_cmp(keys[i-1], keys[i]) == 0) {
+                db->last_error = unique_index_error(db, iname, idef);
+                return SVDB_ERR;
+            }
+        }
+    }
+
+    db->indexes[iname] = idef;
+    /* A unique index on plain columns is enforced at INSERT like a UNIQUE constraint */
+    if (unique && !idef.columns.empty() && index_is_plain(db, idef)) {
+        db->unique_constraints[resolved_tname].push_back(idef.columns);
     }
     return SVDB_OK;
 }
@@ -1143,6 +1654,7 @@ static svdb_code_t do_drop_index(svdb_db_t *db, const std::string &sql) {
     size_t ns = pos;
     while (pos < su.size() && !isspace((unsigned char)su[pos])) ++pos;
     std::string iname = sql.substr(ns, pos - ns);
+    if (is_quoted_identifier(iname)) iname = iname.substr(1, iname.size() - 2);
     if (!db->indexes.count(iname)) {
         if (if_exists) return SVDB_OK;
         db->last_error = "no such index: " + iname;
@@ -1152,14 +1664,82 @@ static svdb_code_t do_drop_index(svdb_db_t *db, const std::string &sql) {
     auto &uconstr = db->unique_constraints;
     std::string dt = db->indexes[iname].table;
     std::vector<std::string> ucols = db->indexes[iname].columns;
+    bool registered = db->indexes[iname].unique && index_is_plain(db, db->indexes[iname]);
     db->indexes.erase(iname);
-    if (!ucols.empty() && uconstr.count(dt)) {
+    db->index_cache.erase(iname);
+    db->unique_keys.erase(iname);
+    if (registered && !ucols.empty() && uconstr.count(dt)) {
         auto &uc = uconstr[dt];
         uc.erase(std::remove(uc.begin(), uc.end(), ucols), uc.end());
     }
     return SVDB_OK;
 }
 
+/* CREATE VIRTUAL TABLE [IF NOT EXISTS] name USING module(args). fts5 is the
+ * only module backed by the engine. */
+static svdb_code_t do_create_virtual_table(svdb_db_t *db, const std::string &sql) {
+    std::string su = str_upper(sql);
+    size_t p = su.find("TABLE") + 5;
+    auto skip_ws = [&]() { while (p < su.size() && isspace((unsigned char)su[p])) ++p; };
+    skip_ws();
+    bool if_not_exists = su.compare(p, 13, "IF NOT EXISTS") == 0;
+    if (if_not_exists) { p += 13; skip_ws(); }
+
+    std::string tname;
+    if (p < sql.size() && (sql[p] == '"' || sql[p] == '`')) {
+        char q = sql[p++]; size_t s = p;
+        while (p < sql.size() && sql[p] != q) ++p;
+        tname = sql.substr(s, p - s);
+        if (p < sql.size()) ++p;
+    } else {
+        size_t s = p;
+        while (p < sql.size() && (isalnum((unsigned char)sql[p]) || sql[p] == '_')) ++p;
+        tname = sql.substr(s, p - s);
+    }
+    skip_ws();
+    if (tname.empty() || su.compare(p, 5, "USING") != 0) {
+        db->last_error = "near \"" + sql.substr(p, sql.find_first_of(" \t\n(", p) - p) + "\": syntax error";
+        return SVDB_ERR;
+    }
+    p += 5;
+    skip_ws();
+    size_t ms = p;
+    while (p < sql.size() && (isalnum((unsigned char)sql[p]) || sql[p] == '_')) ++p;
+    std::string module = sql.substr(ms, p - ms);
+    skip_ws();
+    std::string args;
+    if (p < sql.size() && sql[p] == '(') {
+        size_t close = sql.rfind(')');
+        if (close == std::string::npos || close < p) {
+            db->last_error = "incomplete input";
+            return SVDB_ERR;
+        }
+        args = sql.substr(p + 1, close - p - 1);
+    }
+
+    if (contains_table_case_insensitive(db->schema, tname)) {
+        if (if_not_exists) return SVDB_OK;
+        db->last_error = "table " + tname + " already exists";
+        return SVDB_ERR;
+    }
+    if (str_upper(module) != "FTS5") {
+        db->last_error = "no such module: " + module;
+        return SVDB_ERR;
+    }
+
+    Fts5Table ft;
+    if (!fts5_parse_args(db, split_top_level(args), ft, db->last_error)) return SVDB_ERR;
+    TableDef td;
+    for (const auto &cn : ft.columns) td[cn] = ColDef{};
+    db->schema[tname]        = td;
+    db->col_order[tname]     = ft.columns;
+    db->data[tname]          = {};
+    db->rowid_counter[tname] = 0;
+    db->create_sql[tname]    = sql;
+    db->fts5[tname]          = ft;
+    return SVDB_OK;
+}
+
 static svdb_code_t do_drop_table(svdb_db_t *db, const std::string &sql) {
     svdb_parser_t *p = svdb_parser_create(sql.c_str(), sql.size());
     if (!p) return SVDB_NOMEM;
@@ -1183,10 +1763,206 @@ static svdb_code_t do_drop_table(svdb_db_t *db, const std::string &sql) {
     db->schema.erase(resolved_tname);
     db->col_order.erase(resolved_tname);
     db->data.erase(resolved_tname);
+    storage_note_table(db, resolved_tname);
     db->rowid_counter.erase(resolved_tname);
+    db->table_opts.erase(resolved_tname);
+    db->primary_keys.erase(resolved_tname);
+    db->unique_constraints.erase(resolved_tname);
+    db->fts5.erase(resolved_tname);
+    columnar_forget(db, resolved_tname);
+    for (auto it = db->indexes.begin(); it != db->indexes.end(); ) {
+        if (it->second.table == resolved_tname) {
+            db->index_cache.erase(it->first);
+            db->unique_keys.erase(it->first);
+            it = db->indexes.erase(it);
+        } else {
+            ++it;
+        }
+    }
     return SVDB_OK;
 }
 
+/* ── Stored CREATE TABLE text ───────────────────────────────────── */
+
+static bool is_sql_quote(char c) { return c == '\'' || c == '"' || c == '`' || c == '['; }
+
+/* Offset just past the quoted name or string literal starting at sql[i] */
+static size_t skip_sql_quoted(const std::string &sql, size_t i) {
+    char close = sql[i] == '[' ? ']' : sql[i];
+    for (++i; i < sql.size(); ++i) {
+        if (sql[i] != close) continue;
+        if (close != ']' && i + 1 < sql.size() && sql[i + 1] == close) { ++i; continue; }
+        return i + 1;
+    }
+    return i;
+}
+
+/* The column list of a CREATE TABLE statement: each column definition or
+ * table constraint as trimmed [begin, end) offsets, and the offset of the
+ * closing parenthesis. False if sql has no column list. */
+static bool create_table_parts(const std::string &sql, std::vector<std::pair<size_t, size_t>> &parts,
+                               size_t &close) {
+    size_t i = 0;
+    while (i < sql.size() && sql[i] != '(') i = is_sql_quote(sql[i]) ? skip_sql_quoted(sql, i) : i + 1;
+    if (i >= sql.size()) return false;
+    size_t b = ++i;
+    int depth = 0;
+    while (i < sql.size()) {
+        char c = sql[i];
+        if (is_sql_quote(c)) { i = skip_sql_quoted(sql, i); continue; }
+        if (c == '-' && i + 1 < sql.size() && sql[i + 1] == '-') {
+            while (i < sql.size() && sql[i] != '\n') ++i;
+            continue;
+        }
+        if (c == '/' && i + 1 < sql.size() && sql[i + 1] == '*') {
+            size_t e = sql.find("*/", i + 2);
+            i = e == std::string::npos ? sql.size() : e + 2;
+            continue;
+        }
+        if (c == '(') {
+            ++depth;
+        } else if (c == ')' && depth > 0) {
+            --depth;
+        } else if (c == ',' || c == ')') {
+            if (depth == 0) {
+                size_t s = b, e = i;
+                while (s < e && isspace((unsigned char)sql[s])) ++s;
+                while (e > s && isspace((unsigned char)sql[e - 1])) --e;
+                if (e > s) parts.push_back({s, e});
+                if (c == ')') { close = i; return true; }
+                b = i + 1;
+            }
+        }
+        ++i;
+    }
+    return false;
+}
+
+/* The name at sql[i], unquoted, with its end offset in end */
+static std::string sql_name_at(const std::string &sql, size_t i, size_t &end) {
+    if (i < sql.size() && is_sql_quote(sql[i]) && sql[i] != '\'') {
+        end = skip_sql_quoted(sql, i);
+        char close = sql[i] == '[' ? ']' : sql[i];
+        std::string name;
+        for (size_t k = i + 1; k + 1 < end; ++k) {
+            name += sql[k];
+            if (sql[k] == close && close != ']') ++k;
+        }
+        return name;
+    }
+    end = i;
+    while (end < sql.size() && (isalnum((unsigned char)sql[end]) || sql[end] == '_' ||
+                                (unsigned char)sql[end] >= 0x80))
+        ++end;
+    return sql.substr(i, end - i);
+}
+
+/* The column a part of create_table_parts defines, "" for a table constraint */
+static std::string create_part_column(const std::string &sql, std::pair<size_t, size_t> part) {
+    size_t end;
+    std::string name = sql_name_at(sql, part.first, end);
+    if (sql[part.first] == '"' || sql[part.first] == '`' || sql[part.first] == '[') return name;
+    std::string u = str_upper(name);
+    if (u == "CONSTRAINT" || u == "PRIMARY" || u == "UNIQUE" || u == "CHECK" || u == "FOREIGN") return "";
+    return name;
+}
+
+/* Render an identifier, quoting it only when it isn't a plain word */
+static std::string sql_ident(const std::string &name) {
+    bool plain = !name.empty() && !isdigit((unsigned char)name[0]);
+    for (char c : name) if (!isalnum((unsigned char)c) && c != '_') plain = false;
+    if (plain) return name;
+    std::string out = "\"";
+    for (char c : name) { out += c; if (c == '"') out += '"'; }
+    return out + "\"";
+}
+
+/* Add def, a column definition (as_column) or table constraint, to the
+ * stored CREATE TABLE text of tname: a column after the last column, a
+ * constraint at the end */
+static void create_sql_add(svdb_db_t *db, const std::string &tname, const std::string &def, bool as_column) {
+    auto cs = db->create_sql.find(tname);
+    std::vector<std::pair<size_t, size_t>> parts;
+    size_t close = 0;
+    if (cs == db->create_sql.end() || !create_table_parts(cs->second, parts, close)) return;
+    size_t at = parts.empty() ? close : parts.back().second;
+    if (as_column)
+        for (const auto &pt : parts)
+            if (!create_part_column(cs->second, pt).empty()) at = pt.second;
+    cs->second.insert(at, (parts.empty() ? "" : ", ") + def);
+}
+
+/* Remove the definition of column col from the stored CREATE TABLE text */
+static void create_sql_drop_column(svdb_db_t *db, const std::string &tname, const std::string &col) {
+    auto cs = db->create_sql.find(tname);
+    std::vector<std::pair<size_t, size_t>> parts;
+    size_t close = 0;
+    if (cs == db->create_sql.end() || !create_table_parts(cs->second, parts, close)) return;
+    for (size_t k = 0; k < parts.size(); ++k) {
+        if (str_upper(create_part_column(cs->second, parts[k])) != str_upper(col)) continue;
+        /* Take the comma before it, or after it for the first part */
+        size_t b = k > 0 ? parts[k - 1].second : parts[k].first;
+        size_t e = k > 0 || k + 1 == parts.size() ? parts[k].second : parts[k + 1].first;
+        cs->second.erase(b, e - b);
+        return;
+    }
+}
+
+/* Rename column from to to in the stored CREATE TABLE text, where it is
+ * defined and wherever the column list names it */
+static void create_sql_rename_column(svdb_db_t *db, const std::string &tname, const std::string &from,
+                                     const std::string &to) {
+    auto cs = db->create_sql.find(tname);
+    std::vector<std::pair<size_t, size_t>> parts;
+    size_t close = 0;
+    if (cs == db->create_sql.end() || !create_table_parts(cs->second, parts, close) || parts.empty()) return;
+    std::string &sql = cs->second;
+    std::string fu = str_upper(from), out;
+    size_t i = parts.front().first, done = i;
+    while (i < close) {
+        char c = sql[i];
+        if (c == '\'') { i = skip_sql_quoted(sql, i); continue; }
+        if (isdigit((unsigned char)c)) {
+            while (i < close && (isalnum((unsigned char)sql[i]) || sql[i] == '.')) ++i;
+            continue;
+        }
+        if (!is_sql_quote(c) && !isalpha((unsigned char)c) && c != '_' && (unsigned char)c < 0x80) { ++i; continue; }
+        size_t end;
+        std::string name = sql_name_at(sql, i, end);
+        if (end == i) { ++i; continue; }
+        if (str_upper(name) == fu) {
+            out += sql.substr(done, i - done) + sql_ident(to);
+            done = end;
+        }
+        i = end;
+    }
+    sql = sql.substr(0, parts.front().first) + out + sql.substr(done);
+}
+
+/* Move the stored CREATE TABLE text of from to to, with the new name */
+static void create_sql_rename_table(svdb_db_t *db, const std::string &from, const std::string &to) {
+    auto cs = db->create_sql.find(from);
+    if (cs == db->create_sql.end()) return;
+    std::string sql = cs->second;
+    db->create_sql.erase(cs);
+    /* CREATE [TEMP] TABLE [IF NOT EXISTS] name */
+    size_t i = 0;
+    while (i < sql.size()) {
+        while (i < sql.size() && isspace((unsigned char)sql[i])) ++i;
+        size_t end;
+        std::string w = str_upper(sql_name_at(sql, i, end));
+        if (end == i) break;
+        bool kw = w == "CREATE" || w == "TEMP" || w == "TEMPORARY" || w == "VIRTUAL" || w == "TABLE" ||
+                  w == "IF" || w == "NOT" || w == "EXISTS";
+        if (is_sql_quote(sql[i]) || !kw) {
+            sql.replace(i, end - i, sql_ident(to));
+            break;
+        }
+        i = end;
+    }
+    db->create_sql[to] = sql;
+}
+
 static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
     /* Handles:
      * ALTER TABLE t ADD [COLUMN] col type
@@ -1230,6 +2006,16 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
     while (p < su.size() && isalpha((unsigned char)su[p])) ++p;
     std::string action = su.substr(ap, p - ap);
 
+    /* FTS5 tables can only be renamed */
+    if (db->fts5.count(tname)) {
+        size_t q = p;
+        while (q < su.size() && isspace((unsigned char)su[q])) ++q;
+        if (action != "RENAME" || su.compare(q, 3, "TO ") != 0) {
+            db->last_error = "virtual table \"" + tname + "\" may not be altered";
+            return SVDB_ERR;
+        }
+    }
+
     if (action == "RENAME") {
         while (p < su.size() && isspace((unsigned char)su[p])) ++p;
         size_t np = p;
@@ -1299,10 +2085,22 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
             db->schema[tname][new_col] = db->schema[tname][old_col];
             db->schema[tname].erase(old_col);
             for (auto &cn : db->col_order[tname]) if (cn == old_col) { cn = new_col; break; }
+            auto pk_it = db->primary_keys.find(tname);
+            if (pk_it != db->primary_keys.end())
+                for (auto &cn : pk_it->second) if (cn == old_col) { cn = new_col; break; }
             for (auto &row : db->data[tname]) {
                 auto it = row.find(old_col);
                 if (it != row.end()) { row[new_col] = it->second; row.erase(it); }
             }
+            storage_note_table(db, tname);
+            for (auto &kv : db->indexes)
+                if (kv.second.table == tname)
+                    for (auto &cn : kv.second.columns) if (cn == old_col) cn = new_col;
+            auto uc_it = db->unique_constraints.find(tname);
+            if (uc_it != db->unique_constraints.end())
+                for (auto &ucols : uc_it->second)
+                    for (auto &cn : ucols) if (cn == old_col) cn = new_col;
+            create_sql_rename_column(db, tname, old_col, new_col);
             return SVDB_OK;
         } else {
             /* RENAME TO new_name */
@@ -1324,6 +2122,33 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
             db->rowid_counter[new_name] = db->rowid_counter[tname];
             db->schema.erase(tname); db->col_order.erase(tname);
             db->data.erase(tname);   db->rowid_counter.erase(tname);
+            storage_note_table(db, tname);
+            storage_note_table(db, new_name);
+            auto oit = db->table_opts.find(tname);
+            if (oit != db->table_opts.end()) {
+                db->table_opts[new_name] = oit->second;
+                db->table_opts.erase(tname);
+            }
+            columnar_forget(db, tname);
+            auto pk_it = db->primary_keys.find(tname);
+            if (pk_it != db->primary_keys.end()) {
+                db->primary_keys[new_name] = pk_it->second;
+                db->primary_keys.erase(tname);
+            }
+            auto uc_it = db->unique_constraints.find(tname);
+            if (uc_it != db->unique_constraints.end()) {
+                db->unique_constraints[new_name] = uc_it->second;
+                db->unique_constraints.erase(tname);
+            }
+            auto fts_it = db->fts5.find(tname);
+            if (fts_it != db->fts5.end()) {
+                Fts5Table ft = fts_it->second;
+                db->fts5.erase(fts_it);
+                db->fts5[new_name] = ft;
+            }
+            for (auto &kv : db->indexes)
+                if (kv.second.table == tname) kv.second.table = new_name;
+            create_sql_rename_table(db, tname, new_name);
             return SVDB_OK;
         }
     } else if (action == "DROP") {
@@ -1353,13 +2178,18 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
         if (!db->schema[tname].count(col_name)) {
             db->last_error = "no such column: " + col_name; return SVDB_ERR;
         }
-        if (db->schema[tname][col_name].primary_key) {
+        auto pk_it = db->primary_keys.find(tname);
+        if (db->schema[tname][col_name].primary_key ||
+            (pk_it != db->primary_keys.end() &&
+             std::find(pk_it->second.begin(), pk_it->second.end(), col_name) != pk_it->second.end())) {
             db->last_error = "cannot drop PRIMARY KEY column: " + col_name; return SVDB_ERR;
         }
         db->schema[tname].erase(col_name);
         auto &co = db->col_order[tname];
         co.erase(std::remove(co.begin(), co.end(), col_name), co.end());
         for (auto &row : db->data[tname]) row.erase(col_name);
+        storage_note_table(db, tname);
+        create_sql_drop_column(db, tname, col_name);
         return SVDB_OK;
     } else if (action == "ADD") {
         /* ADD [COLUMN] col type  OR  ADD CONSTRAINT name ... */
@@ -1367,6 +2197,12 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
         size_t np = p;
         while (p < su.size() && isalpha((unsigned char)su[p])) ++p;
         std::string next = su.substr(np, p - np);
+        /* The definition as written, for the stored CREATE TABLE text */
+        auto def_from = [&](size_t b) {
+            std::string d = str_trim(sql.substr(b));
+            while (!d.empty() && (d.back() == ';' || isspace((unsigned char)d.back()))) d.pop_back();
+            return d;
+        };
 
         if (next == "CONSTRAINT") {
             /* ADD CONSTRAINT name UNIQUE(cols) / CHECK(expr) */
@@ -1393,7 +2229,10 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
                         while (p < sql.size() && isspace((unsigned char)sql[p])) ++p;
                         if (p < sql.size() && sql[p] == ',') ++p;
                     }
-                    if (!ucols.empty()) db->unique_constraints[tname].push_back(ucols);
+                    if (!ucols.empty()) {
+                        db->unique_constraints[tname].push_back(ucols);
+                        create_sql_add(db, tname, def_from(np), false);
+                    }
                 }
                 return SVDB_OK;
             } else if (ctype2 == "CHECK") {
@@ -1406,6 +2245,7 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
                     }
                     std::string chk = sql.substr(s5, p - s5);
                     db->check_constraints[tname].push_back(chk);
+                    create_sql_add(db, tname, def_from(np), false);
                 }
                 return SVDB_OK;
             }
@@ -1418,6 +2258,7 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
 
         /* ADD col type */
         while (p < sql.size() && isspace((unsigned char)sql[p])) ++p;
+        size_t def_start = p;
         std::string col_name;
         if (p < sql.size() && (sql[p] == '"' || sql[p] == '`')) {
             char q = sql[p++]; size_t s3 = p;
@@ -1430,11 +2271,13 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
         }
         if (col_name.empty()) { db->last_error = "ADD COLUMN: missing column name"; return SVDB_ERR; }
         while (p < sql.size() && isspace((unsigned char)sql[p])) ++p;
-        std::string col_type;
-        while (p < sql.size() && (isalnum((unsigned char)sql[p]) || sql[p] == '_')) {
-            col_type += (char)toupper((unsigned char)sql[p]); ++p;
+        std::string col_type = parse_decl_type(sql, p);
+        auto oit = db->table_opts.find(tname);
+        if (oit != db->table_opts.end() && oit->second.strict && !svdb_strict_type_ok(col_type)) {
+            db->last_error = col_type.empty() ? "missing datatype for " + tname + "." + col_name
+                : "unknown datatype for " + tname + "." + col_name + ": \"" + col_type + "\"";
+            return SVDB_ERR;
         }
-        if (col_type.empty()) col_type = "TEXT";
         ColDef cd; cd.type = col_type;
         /* Parse optional constraints: NOT NULL, DEFAULT */
         while (p < sql.size()) {
@@ -1477,13 +2320,21 @@ static svdb_code_t do_alter_table(svdb_db_t *db, const std::string &sql) {
         db->schema[tname][col_name] = cd;
         db->col_order[tname].push_back(col_name);
         /* Set existing rows: use default value if provided, otherwise NULL */
+        storage_note_table(db, tname);
         for (auto &row : db->data[tname]) {
             if (!cd.default_val.empty()) {
                 row[col_name] = svdb_eval_expr_in_row(cd.default_val, row, {});
             } else {
                 row[col_name] = SvdbVal{};
             }
+            if (apply_column_affinity(db, tname, row) != SVDB_OK) {
+                for (auto &r : db->data[tname]) r.erase(col_name);
+                db->schema[tname].erase(col_name);
+                db->col_order[tname].pop_back();
+                return SVDB_ERR;
+            }
         }
+        create_sql_add(db, tname, def_from(def_start), true);
         return SVDB_OK;
     }
     return SVDB_OK;
@@ -1633,6 +2484,8 @@ static svdb_code_t do_create_trigger(svdb_db_t *db, const std::string &sql) {
     td.table     = trig_table;
     td.when_expr = when_expr;
     td.body      = body;
+    td.sql       = str_trim(sql);
+    while (!td.sql.empty() && td.sql.back() == ';') td.sql = str_trim(td.sql.substr(0, td.sql.size() - 1));
     db->triggers[trig_name] = td;
     return SVDB_OK;
 }
@@ -1783,25 +2636,218 @@ static svdb_code_t fire_triggers(svdb_db_t *db, TriggerTiming timing, TriggerEve
 }
 
 
-/* ── DML handlers ───────────────────────────────────────────────── */
+/* ── Foreign keys ───────────────────────────────────────────────── */
 
-static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
-                              svdb_result_t *res) {
-    svdb_assert(db != nullptr);
-    svdb_assert(!sql.empty());
-    /* Handle INSERT INTO t DEFAULT VALUES */
-    std::string su_pre = str_upper(sql);
-    if (su_pre.find("DEFAULT VALUES") != std::string::npos) {
-        /* Extract table name */
-        size_t into_pos = su_pre.find("INTO");
-        if (into_pos == std::string::npos) into_pos = su_pre.find("INSERT") + 6;
-        else into_pos += 4;
-        while (into_pos < sql.size() && isspace((unsigned char)sql[into_pos])) ++into_pos;
-        size_t ts = into_pos;
-        while (into_pos < sql.size() && (isalnum((unsigned char)sql[into_pos]) || sql[into_pos] == '_')) ++into_pos;
-        std::string tname2 = sql.substr(ts, into_pos - ts);
+/* Helper: compare two SvdbVal for FK matching (ignoring NULL) */
+static bool fk_vals_equal(const SvdbVal &a, const SvdbVal &b) {
+    if (a.type == SVDB_TYPE_NULL || b.type == SVDB_TYPE_NULL) return false;
+    if (a.type != b.type) return false;
+    switch (a.type) {
+        case SVDB_TYPE_INT:  return a.ival == b.ival;
+        case SVDB_TYPE_REAL: return a.rval == b.rval;
+        case SVDB_TYPE_TEXT: return a.sval == b.sval;
+        default: return false;
+    }
+}
 
-        /* Case-insensitive table lookup with schema prefix support */
+/* Whether the parent table of fk holds a row whose key equals v. A parent
+ * table that does not exist is not treated as a violation. */
+static bool fk_parent_exists(svdb_db_t *db, const FKDef &fk, const SvdbVal &v) {
+    std::string parent = resolve_table_name(db, fk.parent_table);
+    if (parent.empty() || !db->data.count(parent)) return true;
+    /* REFERENCES t without a column list means t's primary key */
+    std::string pcol = fk.parent_col;
+    if (pcol.empty()) {
+        auto pk_it = db->primary_keys.find(parent);
+        if (pk_it != db->primary_keys.end() && !pk_it->second.empty()) pcol = pk_it->second[0];
+        for (const auto &kv : db->schema[parent])
+            if (pcol.empty() && kv.second.primary_key) pcol = kv.first;
+        if (pcol.empty()) pcol = fk.child_col;
+    }
+    for (const auto &prow : db->data.at(parent)) {
+        auto pit = prow.find(pcol);
+        if (pit != prow.end() && fk_vals_equal(pit->second, v)) return true;
+    }
+    return false;
+}
+
+/* Whether a violation of fk is checked at COMMIT rather than at once */
+static bool fk_deferred(svdb_db_t *db, const FKDef &fk) {
+    if (!db->in_transaction) return false;
+    if (db->defer_foreign_keys) return true;
+    if (!fk.deferrable) return false;
+    if (db->constraint_mode != CONSTRAINTS_DEFAULT) return db->constraint_mode == CONSTRAINTS_DEFERRED;
+    return fk.initially_deferred;
+}
+
+/* Report a violation of fk on child table tname, or note it for COMMIT */
+static svdb_code_t fk_violation(svdb_db_t *db, const std::string &tname, const FKDef &fk) {
+    if (fk_deferred(db, fk)) {
+        db->fk_pending.insert(tname);
+        return SVDB_OK;
+    }
+    db->last_error = "FOREIGN KEY constraint failed";
+    return SVDB_ERR;
+}
+
+/* Check the foreign keys of a row of child table tname. For an UPDATE, old
+ * is the previous row; references it did not change are not checked again. */
+static svdb_code_t fk_check_row(svdb_db_t *db, const std::string &tname, const Row &row,
+                                const Row *old = nullptr) {
+    auto fit = db->fk_constraints.find(tname);
+    if (!db->foreign_keys_enabled || fit == db->fk_constraints.end()) return SVDB_OK;
+    for (const auto &fk : fit->second) {
+        auto cit = row.find(fk.child_col);
+        if (cit == row.end() || cit->second.type == SVDB_TYPE_NULL) continue; /* NULL never violates */
+        if (old) {
+            auto oit = old->find(fk.child_col);
+            if (oit != old->end() && fk_vals_equal(oit->second, cit->second)) continue;
+        }
+        if (!fk_parent_exists(db, fk, cit->second) && fk_violation(db, tname, fk) != SVDB_OK)
+            return SVDB_ERR;
+    }
+    return SVDB_OK;
+}
+
+/* Rows of tname that violate its foreign keys, as {row index, FK index}.
+ * Used by COMMIT and PRAGMA foreign_key_check. */
+std::vector<std::pair<size_t, size_t>> svdb_fk_violations(svdb_db_t *db, const std::string &tname) {
+    std::vector<std::pair<size_t, size_t>> out;
+    auto fit = db->fk_constraints.find(tname);
+    auto dit = db->data.find(tname);
+    if (fit == db->fk_constraints.end() || dit == db->data.end()) return out;
+    for (size_t ri = 0; ri < dit->second.size(); ++ri) {
+        for (size_t fi = 0; fi < fit->second.size(); ++fi) {
+            const FKDef &fk = fit->second[fi];
+            auto cit = dit->second[ri].find(fk.child_col);
+            if (cit == dit->second[ri].end() || cit->second.type == SVDB_TYPE_NULL) continue;
+            if (!fk_parent_exists(db, fk, cit->second)) out.push_back({ri, fi});
+        }
+    }
+    return out;
+}
+
+/* Check the FK violations deferred during the transaction */
+static svdb_code_t fk_check_pending(svdb_db_t *db) {
+    if (db->foreign_keys_enabled) {
+        for (const auto &tname : db->fk_pending) {
+            if (!svdb_fk_violations(db, tname).empty()) {
+                db->last_error = "FOREIGN KEY constraint failed";
+                return SVDB_ERR;
+            }
+        }
+    }
+    db->fk_pending.clear();
+    return SVDB_OK;
+}
+
+/* Reset the per-transaction state once a transaction commits or rolls back */
+static void tx_finish(svdb_db_t *db) {
+    db->in_transaction = false;
+    db->fk_pending.clear();
+    db->defer_foreign_keys = false;
+    db->constraint_mode = CONSTRAINTS_DEFAULT;
+}
+
+/* Put back the rows tx started from and end the transaction */
+static void tx_rollback(svdb_db_t *db, const svdb_tx_t *tx) {
+    db->data          = tx->data_snapshot;
+    db->rowid_counter = tx->rowid_snapshot;
+    ++db->data_gen;
+    storage_note_kept(db);
+    tx_finish(db);
+}
+
+/* ── DML handlers ───────────────────────────────────────────────── */
+
+/* Move an explicit rowid (INSERT INTO t(rowid, ...)) into the rowid column of
+ * row. assigned is set when the row got its rowid this way; a non-integer or
+ * already used rowid is an error. */
+static svdb_code_t take_explicit_rowid(svdb_db_t *db, const std::string &tname, Row &row,
+                                       bool &assigned) {
+    assigned = false;
+    for (auto it = row.begin(); it != row.end(); ++it) {
+        std::string u = str_upper(it->first);
+        if ((u != "ROWID" && u != "OID" && u != "_ROWID_") || db->schema[tname].count(it->first))
+            continue;
+        SvdbVal v = it->second;
+        row.erase(it);
+        if (v.type == SVDB_TYPE_NULL) return SVDB_OK;
+        if (v.type == SVDB_TYPE_TEXT) svdb_parse_numeric_text(v.sval, v);
+        if (v.type == SVDB_TYPE_REAL && svdb_real_is_integral(v.rval)) {
+            v.type = SVDB_TYPE_INT;
+            v.ival = (int64_t)v.rval;
+        }
+        if (v.type != SVDB_TYPE_INT) {
+            db->last_error = "datatype mismatch";
+            return SVDB_ERR;
+        }
+        for (const Row &r : db->data[tname]) {
+            auto rit = r.find(SVDB_ROWID_COLUMN);
+            if (rit != r.end() && rit->second.ival == v.ival) {
+                db->last_error = "UNIQUE constraint failed: " + tname + ".rowid";
+                return SVDB_ERR;
+            }
+        }
+        row[SVDB_ROWID_COLUMN] = v;
+        if (v.ival > db->rowid_counter[tname]) db->rowid_counter[tname] = v.ival;
+        assigned = true;
+        return SVDB_OK;
+    }
+    return SVDB_OK;
+}
+
+/* INSERT INTO t(t[, rowid, ...]) VALUES('command', ...) on an FTS5 table */
+static svdb_code_t fts5_insert_command(svdb_db_t *db, const std::string &tname,
+                                       svdb_ast_node_t *ast, svdb_result_t *res) {
+    Fts5Table &ft = db->fts5[tname];
+    int ncols = svdb_ast_get_column_count(ast);
+    for (int ri = 0; ri < svdb_ast_get_value_row_count(ast); ++ri) {
+        SvdbVal cmd, rowid;
+        for (int ci = 0; ci < ncols && ci < svdb_ast_get_value_count(ast, ri); ++ci) {
+            std::string cu = str_upper(svdb_ast_get_column(ast, ci));
+            SvdbVal v = parse_literal(svdb_ast_get_value(ast, ri, ci));
+            if (ci == 0) cmd = v;
+            else if (cu == "ROWID" || cu == "OID" || cu == "_ROWID_") rowid = v;
+        }
+        if (rowid.type == SVDB_TYPE_REAL && svdb_real_is_integral(rowid.rval)) {
+            rowid.type = SVDB_TYPE_INT;
+            rowid.ival = (int64_t)rowid.rval;
+        }
+        std::string err, name = val_to_str_exec(cmd);
+        if (!fts5_command(db, tname, ft, name, rowid, err)) {
+            db->last_error = err;
+            return SVDB_ERR;
+        }
+        /* 'delete', 'delete-all' and 'rebuild' change the documents */
+        std::string cu = str_upper(name);
+        if (cu == "DELETE" || cu == "DELETE-ALL" || cu == "REBUILD") {
+            ++db->data_gen;
+            storage_note_rows(db, tname, 0, 0);
+        }
+    }
+    db->rows_affected = 0;
+    if (res) { res->code = SVDB_OK; res->rows_affected = 0; res->last_insert_rowid = db->last_insert_rowid; }
+    return SVDB_OK;
+}
+
+static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
+                              svdb_result_t *res) {
+    svdb_assert(db != nullptr);
+    svdb_assert(!sql.empty());
+    /* Handle INSERT INTO t DEFAULT VALUES */
+    std::string su_pre = str_upper(sql);
+    if (su_pre.find("DEFAULT VALUES") != std::string::npos) {
+        /* Extract table name */
+        size_t into_pos = su_pre.find("INTO");
+        if (into_pos == std::string::npos) into_pos = su_pre.find("INSERT") + 6;
+        else into_pos += 4;
+        while (into_pos < sql.size() && isspace((unsigned char)sql[into_pos])) ++into_pos;
+        size_t ts = into_pos;
+        while (into_pos < sql.size() && (isalnum((unsigned char)sql[into_pos]) || sql[into_pos] == '_')) ++into_pos;
+        std::string tname2 = sql.substr(ts, into_pos - ts);
+
+        /* Case-insensitive table lookup with schema prefix support */
         std::string resolved_tname2 = resolve_table_name(db, tname2);
         if (resolved_tname2.empty()) { db->last_error = "no such table: " + tname2; return SVDB_ERR; }
         
@@ -1814,6 +2860,25 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
             else
                 row[cn] = SvdbVal{};
         }
+        if (apply_column_affinity(db, resolved_tname2, row) != SVDB_OK) return SVDB_ERR;
+        if (check_unique_indexes(db, resolved_tname2, row) != SVDB_OK) return SVDB_ERR;
+        if (const auto *key = clustered_key(db, resolved_tname2)) {
+            for (const auto &cn2 : *key) {
+                if (row[cn2].type == SVDB_TYPE_NULL) {
+                    db->last_error = "NOT NULL constraint failed: " + resolved_tname2 + "." + cn2;
+                    return SVDB_ERR;
+                }
+            }
+            if (clustered_find(db, resolved_tname2, *key, row) >= 0) {
+                db->last_error = key_conflict_error(resolved_tname2, *key);
+                return SVDB_ERR;
+            }
+            clustered_insert(db, resolved_tname2, *key, row);
+            unique_indexes_note(db, resolved_tname2, nullptr, row);
+            db->rows_affected = 1;
+            if (res) { res->code = SVDB_OK; res->rows_affected = 1; res->last_insert_rowid = db->last_insert_rowid; }
+            return SVDB_OK;
+        }
         /* Auto-assign INTEGER PRIMARY KEY if not set */
         for (const auto &cn2 : col_order2) {
             auto cdit = db->schema[resolved_tname2].find(cn2);
@@ -1830,6 +2895,10 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
         db->rowid_counter[resolved_tname2]++;
         row[SVDB_ROWID_COLUMN] = SvdbVal{SVDB_TYPE_INT, db->rowid_counter[resolved_tname2], 0.0, {}};
         db->data[resolved_tname2].push_back(row);
+        ++db->data_gen;
+        unique_indexes_note(db, resolved_tname2, nullptr, row);
+        columnar_note_row(db, resolved_tname2, db->data[resolved_tname2].size() - 1);
+        storage_note_rows(db, resolved_tname2, db->data[resolved_tname2].size() - 1, 0);
         db->rows_affected = 1; db->last_insert_rowid = db->rowid_counter[tname2];
         if (res) { res->code = SVDB_OK; res->rows_affected = 1; res->last_insert_rowid = db->last_insert_rowid; }
         return SVDB_OK;
@@ -1857,6 +2926,13 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
     }
     tname = resolved_tname; /* use canonical name for all subsequent operations */
 
+    if (ncols > 0 && db->fts5.count(tname) &&
+        str_upper(svdb_ast_get_column(ast, 0)) == str_upper(tname)) {
+        svdb_code_t rc = fts5_insert_command(db, tname, ast, res);
+        svdb_ast_node_free(ast); svdb_parser_destroy(p);
+        return rc;
+    }
+
     const auto &col_order = db->col_order[resolved_tname];
 
     /* ── INSERT ... SELECT ─────────────────────────────────────────── */
@@ -1892,7 +2968,8 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
                 svdb_ast_node_free(ast); svdb_parser_destroy(p);
                 return rc2;
             }
-            int64_t inserted2 = 0;
+            /* Build every row first so a STRICT type error leaves the table untouched */
+            std::vector<Row> staged;
             for (const auto &sel_row : sel_rows->rows) {
                 Row row2;
                 for (size_t ci = 0; ci < ins_cols2.size() && ci < sel_row.size(); ++ci)
@@ -1906,14 +2983,71 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
                         else row2[cn] = SvdbVal{};
                     }
                 }
-                db->rowid_counter[resolved_tname]++;
-                row2[SVDB_ROWID_COLUMN] = SvdbVal{SVDB_TYPE_INT, db->rowid_counter[resolved_tname], 0.0, {}};
-                db->data[resolved_tname].push_back(row2);
-                ++inserted2;
+                if (apply_column_affinity(db, resolved_tname, row2) != SVDB_OK) {
+                    delete sel_rows;
+                    svdb_ast_node_free(ast); svdb_parser_destroy(p);
+                    return SVDB_ERR;
+                }
+                staged.push_back(std::move(row2));
             }
             delete sel_rows;
+            int64_t inserted2 = 0;
+            if (const auto *key = clustered_key(db, resolved_tname)) {
+                for (auto &row2 : staged) {
+                    std::string err;
+                    for (const auto &kc : *key)
+                        if (row2[kc].type == SVDB_TYPE_NULL) { err = "NOT NULL constraint failed: " + resolved_tname + "." + kc; break; }
+                    if (err.empty() && clustered_find(db, resolved_tname, *key, row2) >= 0)
+                        err = key_conflict_error(resolved_tname, *key);
+                    if (err.empty() && check_unique_indexes(db, resolved_tname, row2) != SVDB_OK)
+                        err = db->last_error;
+                    if (!err.empty()) {
+                        /* Take back the rows this statement already added */
+                        for (size_t k = 0; k < (size_t)inserted2; ++k) {
+                            int pos = clustered_find(db, resolved_tname, *key, staged[k]);
+                            if (pos >= 0) db->data[resolved_tname].erase(db->data[resolved_tname].begin() + pos);
+                        }
+                        ++db->data_gen;
+                        storage_note_kept(db);
+                        db->last_error = err;
+                        svdb_ast_node_free(ast); svdb_parser_destroy(p);
+                        return SVDB_ERR;
+                    }
+                    clustered_insert(db, resolved_tname, *key, row2);
+                    unique_indexes_note(db, resolved_tname, nullptr, row2);
+                    ++inserted2;
+                }
+                db->rows_affected = inserted2;
+                if (res) { res->code = SVDB_OK; res->rows_affected = inserted2; res->last_insert_rowid = db->last_insert_rowid; }
+                svdb_ast_node_free(ast); svdb_parser_destroy(p);
+                return SVDB_OK;
+            }
+            int64_t rowid_base = db->rowid_counter[resolved_tname];
+            for (auto &row2 : staged) {
+                bool explicit_rowid = false;
+                if (check_unique_indexes(db, resolved_tname, row2) != SVDB_OK ||
+                    take_explicit_rowid(db, resolved_tname, row2, explicit_rowid) != SVDB_OK) {
+                    auto &tdata = db->data[resolved_tname];
+                    tdata.erase(tdata.end() - inserted2, tdata.end());
+                    ++db->data_gen;
+                    storage_note_kept(db);
+                    db->rowid_counter[resolved_tname] = rowid_base;
+                    svdb_ast_node_free(ast); svdb_parser_destroy(p);
+                    return SVDB_ERR;
+                }
+                if (!explicit_rowid) {
+                    db->rowid_counter[resolved_tname]++;
+                    row2[SVDB_ROWID_COLUMN] = SvdbVal{SVDB_TYPE_INT, db->rowid_counter[resolved_tname], 0.0, {}};
+                }
+                db->last_insert_rowid = row2[SVDB_ROWID_COLUMN].ival;
+                db->data[resolved_tname].push_back(std::move(row2));
+                ++db->data_gen;
+                unique_indexes_note(db, resolved_tname, nullptr, db->data[resolved_tname].back());
+                columnar_note_row(db, resolved_tname, db->data[resolved_tname].size() - 1);
+                storage_note_rows(db, resolved_tname, db->data[resolved_tname].size() - 1, 0);
+                ++inserted2;
+            }
             db->rows_affected = inserted2;
-            db->last_insert_rowid = db->rowid_counter[resolved_tname];
             if (res) { res->code = SVDB_OK; res->rows_affected = inserted2; res->last_insert_rowid = db->last_insert_rowid; }
             svdb_ast_node_free(ast); svdb_parser_destroy(p);
             return SVDB_OK;
@@ -1927,8 +3061,9 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
             ins_cols.push_back(svdb_ast_get_column(ast, i));
         /* Validate: all specified column names must exist in the table */
         for (const auto &ic : ins_cols) {
+            std::string icu = str_upper(ic);
             if (db->schema[resolved_tname].find(ic) == db->schema[resolved_tname].end() &&
-                str_upper(ic) != "ROWID" && str_upper(ic) != "_SVDB_ROWID_") {
+                icu != "ROWID" && icu != "OID" && icu != "_ROWID_" && icu != "_SVDB_ROWID_") {
                 db->last_error = "table " + tname + " has no column named " + ic;
                 svdb_ast_node_free(ast); svdb_parser_destroy(p);
                 return SVDB_ERR;
@@ -1978,7 +3113,9 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
         }
     }
 
+    const std::vector<std::string> *ckey = clustered_key(db, tname);
     int64_t inserted = 0;
+    int64_t last_rowid = db->rowid_counter[tname];
     for (int ri = 0; ri < nrows; ++ri) {
         Row row;
         /* Set defaults first */
@@ -2000,46 +3137,30 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
             if (vstr_upper == "DEFAULT") {
                 db->last_error = "near \"DEFAULT\": syntax error";
                 return SVDB_ERR;
-            } else {
+            } else if (is_plain_literal(vstr)) {
                 row[ins_cols[ci]] = parse_literal(vstr);
+            } else {
+                row[ins_cols[ci]] = svdb_eval_expr_in_row(vstr, row, {});
             }
         }
 
         /* Apply column type affinity coercion (SQLite compatible) */
-        for (int ci = 0; ci < (int)ins_cols.size(); ++ci) {
-            const std::string &col_name = ins_cols[ci];
-            auto cit = db->schema[tname].find(col_name);
-            if (cit == db->schema[tname].end()) continue;
-            std::string ct = str_upper(cit->second.type);
-            auto rit = row.find(col_name);
-            if (rit == row.end() || rit->second.type == SVDB_TYPE_NULL) continue;
-            /* REAL affinity: coerce integer to real; coerce text-number to real */
-            if ((ct == "REAL" || ct == "FLOAT" || ct == "DOUBLE") && rit->second.type == SVDB_TYPE_INT) {
-                rit->second.rval = (double)rit->second.ival;
-                rit->second.type = SVDB_TYPE_REAL;
-            }
-            if ((ct == "REAL" || ct == "FLOAT" || ct == "DOUBLE") && rit->second.type == SVDB_TYPE_TEXT) {
-                const std::string &sv = rit->second.sval;
-                char *endp = nullptr;
-                double dv = strtod(sv.c_str(), &endp);
-                if (endp && endp != sv.c_str() && *endp == '\0') {
-                    rit->second.rval = dv;
-                    rit->second.type = SVDB_TYPE_REAL;
-                }
-            }
-            /* INTEGER affinity: coerce real with no fractional part to integer */
-            if ((ct == "INTEGER" || ct == "INT") && rit->second.type == SVDB_TYPE_REAL) {
-                if (rit->second.rval == (double)(int64_t)rit->second.rval) {
-                    rit->second.ival = (int64_t)rit->second.rval;
-                    rit->second.type = SVDB_TYPE_INT;
-                }
-            }
+        if (apply_column_affinity(db, tname, row) != SVDB_OK) {
+            svdb_ast_node_free(ast); svdb_parser_destroy(p);
+            return SVDB_ERR;
+        }
+        bool explicit_rowid = false;
+        if (!ckey && take_explicit_rowid(db, tname, row, explicit_rowid) != SVDB_OK) {
+            svdb_ast_node_free(ast); svdb_parser_destroy(p);
+            return SVDB_ERR;
         }
 
         /* ── Constraint checks ───────────────────────────────── */
 
-        /* Auto-assign values for INTEGER PRIMARY KEY (AUTOINCREMENT) columns that were omitted */
+        /* Auto-assign values for INTEGER PRIMARY KEY (AUTOINCREMENT) columns that were omitted.
+         * In a WITHOUT ROWID table the key is not a rowid alias and must be given. */
         for (const auto &cn : col_order) {
+            if (ckey) break;
             auto cdit = db->schema[tname].find(cn);
             if (cdit != db->schema[tname].end() && cdit->second.primary_key &&
                 (cdit->second.auto_increment ||
@@ -2095,15 +3216,18 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
         if (db->primary_keys.count(tname)) {
             const auto &pk_cols = db->primary_keys.at(tname);
             if (!pk_cols.empty()) {
-                int ci = check_unique(pk_cols);
+                int ci = ckey ? clustered_find(db, tname, *ckey, row) : check_unique(pk_cols);
                 if (ci >= 0) {
                     if (on_conflict_nothing) { conflict_handled = true; }
                     else if (on_conflict_update) {
                         db->data[tname][ci] = row;
+                        ++db->data_gen;
+                        note_row_written(db, tname, (size_t)ci);
                         ++inserted; conflict_handled = true;
                     } else {
                         /* Report the first PK column in the error message */
-                        db->last_error = "UNIQUE constraint failed: " + tname + "." + pk_cols[0];
+                        db->last_error = ckey ? key_conflict_error(tname, *ckey)
+                                              : "UNIQUE constraint failed: " + tname + "." + pk_cols[0];
                         svdb_ast_node_free(ast); svdb_parser_destroy(p);
                         return SVDB_ERR;
                     }
@@ -2119,6 +3243,8 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
                         if (on_conflict_nothing) { conflict_handled = true; break; }
                         if (on_conflict_update) {
                             db->data[tname][ci] = row;
+                            ++db->data_gen;
+                            note_row_written(db, tname, (size_t)ci);
                             ++inserted; conflict_handled = true; break;
                         }
                         db->last_error = "UNIQUE constraint failed: " + tname + "." + cn;
@@ -2138,6 +3264,8 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
                     if (on_conflict_nothing) { conflict_handled = true; break; }
                     if (on_conflict_update) {
                         db->data[tname][ci] = row;
+                        ++db->data_gen;
+                        note_row_written(db, tname, (size_t)ci);
                         ++inserted; conflict_handled = true; break;
                     }
                     db->last_error = "UNIQUE constraint failed: " + tname;
@@ -2148,6 +3276,13 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
             if (conflict_handled) continue; /* skip or already updated */
         }
 
+        /* Partial and expression UNIQUE indexes */
+        if (check_unique_indexes(db, tname, row) != SVDB_OK) {
+            if (on_conflict_nothing) continue;
+            svdb_ast_node_free(ast); svdb_parser_destroy(p);
+            return SVDB_ERR;
+        }
+
         /* CHECK constraint evaluation */
         if (db->check_constraints.count(tname)) {
             for (const auto &chk : db->check_constraints.at(tname)) {
@@ -2160,49 +3295,30 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
         }
 
         /* FK constraint check */
-        if (db->foreign_keys_enabled && db->fk_constraints.count(tname)) {
-            for (const auto &fk : db->fk_constraints.at(tname)) {
-                auto child_it = row.find(fk.child_col);
-                if (child_it == row.end() || child_it->second.type == SVDB_TYPE_NULL)
-                    continue; /* NULL values don't violate FK */
-                std::string parent_up = str_upper(fk.parent_table);
-                std::string resolved_parent;
-                for (auto &kv : db->schema)
-                    if (str_upper(kv.first) == parent_up) { resolved_parent = kv.first; break; }
-                if (resolved_parent.empty() || !db->data.count(resolved_parent))
-                    continue;
-                bool found = false;
-                for (const auto &prow : db->data.at(resolved_parent)) {
-                    auto pit = prow.find(fk.parent_col);
-                    if (pit == prow.end()) continue;
-                    if (pit->second.type == child_it->second.type) {
-                        bool match = false;
-                        switch (pit->second.type) {
-                            case SVDB_TYPE_INT:  match = (pit->second.ival == child_it->second.ival); break;
-                            case SVDB_TYPE_REAL: match = (pit->second.rval == child_it->second.rval); break;
-                            case SVDB_TYPE_TEXT: match = (pit->second.sval == child_it->second.sval); break;
-                            default: break;
-                        }
-                        if (match) { found = true; break; }
-                    }
-                }
-                if (!found) {
-                    db->last_error = "FOREIGN KEY constraint failed";
-                    svdb_ast_node_free(ast); svdb_parser_destroy(p);
-                    return SVDB_ERR;
-                }
-            }
+        if (fk_check_row(db, tname, row) != SVDB_OK) {
+            svdb_ast_node_free(ast); svdb_parser_destroy(p);
+            return SVDB_ERR;
         }
 
         /* Auto-increment rowid */
-        db->rowid_counter[tname]++;
-        row[SVDB_ROWID_COLUMN] = SvdbVal{SVDB_TYPE_INT, db->rowid_counter[tname], 0.0, {}};
+        if (!ckey && !explicit_rowid) {
+            db->rowid_counter[tname]++;
+            row[SVDB_ROWID_COLUMN] = SvdbVal{SVDB_TYPE_INT, db->rowid_counter[tname], 0.0, {}};
+        }
+        if (!ckey) last_rowid = row[SVDB_ROWID_COLUMN].ival;
 
         /* Fire BEFORE INSERT triggers */
         if (!db->triggers.empty())
             fire_triggers(db, TRIGGER_BEFORE, TRIGGER_INSERT, tname, &row, nullptr);
 
-        db->data[tname].push_back(row);
+        if (ckey) clustered_insert(db, tname, *ckey, row);
+        else {
+            db->data[tname].push_back(row);
+            ++db->data_gen;
+            storage_note_rows(db, tname, db->data[tname].size() - 1, 0);
+        }
+        unique_indexes_note(db, tname, nullptr, row);
+        if (!ckey) columnar_note_row(db, tname, db->data[tname].size() - 1);
         ++inserted;
 
         /* Fire AFTER INSERT triggers */
@@ -2210,8 +3326,14 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
             fire_triggers(db, TRIGGER_AFTER, TRIGGER_INSERT, tname, &row, nullptr);
     }
 
+    /* An upsert may have rewritten a row's key in place */
+    if (ckey && on_conflict_update && !clustered_resort(db, tname, *ckey)) {
+        svdb_ast_node_free(ast); svdb_parser_destroy(p);
+        return SVDB_ERR;
+    }
+
     db->rows_affected     = inserted;
-    db->last_insert_rowid = db->rowid_counter[tname];
+    if (!ckey) db->last_insert_rowid = last_rowid;
     if (res) {
         res->code              = SVDB_OK;
         res->errmsg            = "";
@@ -2224,15 +3346,57 @@ static svdb_code_t do_insert(svdb_db_t *db, const std::string &sql,
     return SVDB_OK;
 }
 
-/* Helper: compare two SvdbVal for FK matching (ignoring NULL) */
-static bool fk_vals_equal(const SvdbVal &a, const SvdbVal &b) {
-    if (a.type == SVDB_TYPE_NULL || b.type == SVDB_TYPE_NULL) return false;
-    if (a.type != b.type) return false;
-    switch (a.type) {
-        case SVDB_TYPE_INT:  return a.ival == b.ival;
-        case SVDB_TYPE_REAL: return a.rval == b.rval;
-        case SVDB_TYPE_TEXT: return a.sval == b.sval;
-        default: return false;
+/* Apply FK ON UPDATE actions (CASCADE, SET NULL) for rows of 'tname' whose
+ * values changed. updated_pairs holds {old_row, new_row}. */
+static void fk_on_update(svdb_db_t *db, const std::string &tname,
+                         const std::vector<std::pair<Row,Row>> &updated_pairs) {
+    for (auto &kv : db->fk_constraints) {
+        const std::string &child_tname = kv.first;
+        if (!db->data.count(child_tname)) continue;
+        for (const auto &fk : kv.second) {
+            if (str_upper(fk.parent_table) != str_upper(tname)) continue;
+            std::string action = str_upper(fk.on_update);
+            if (action.empty() || action == "NO ACTION" || action == "RESTRICT") continue;
+            if (action == "CASCADE") {
+                for (const auto &pr : updated_pairs) {
+                    const Row &old_row = pr.first;
+                    const Row &new_row = pr.second;
+                    auto old_it = old_row.find(fk.parent_col);
+                    auto new_it = new_row.find(fk.parent_col);
+                    if (old_it == old_row.end() || new_it == new_row.end()) continue;
+                    if (fk_vals_equal(old_it->second, new_it->second)) continue; /* no change */
+                    auto &crows = db->data[child_tname];
+                    for (auto &crow : crows) {
+                        auto cit = crow.find(fk.child_col);
+                        if (cit == crow.end()) continue;
+                        if (fk_vals_equal(cit->second, old_it->second)) {
+                            cit->second = new_it->second;
+                            ++db->data_gen;
+                            note_row_written(db, child_tname, (size_t)(&crow - crows.data()));
+                        }
+                    }
+                }
+            } else if (action == "SET NULL") {
+                for (const auto &pr : updated_pairs) {
+                    const Row &old_row = pr.first;
+                    const Row &new_row = pr.second;
+                    auto old_it = old_row.find(fk.parent_col);
+                    auto new_it = new_row.find(fk.parent_col);
+                    if (old_it == old_row.end() || new_it == new_row.end()) continue;
+                    if (fk_vals_equal(old_it->second, new_it->second)) continue;
+                    auto &crows = db->data[child_tname];
+                    for (auto &crow : crows) {
+                        auto cit = crow.find(fk.child_col);
+                        if (cit == crow.end()) continue;
+                        if (fk_vals_equal(cit->second, old_it->second)) {
+                            cit->second = SvdbVal{};
+                            ++db->data_gen;
+                            note_row_written(db, child_tname, (size_t)(&crow - crows.data()));
+                        }
+                    }
+                }
+            }
+        }
     }
 }
 
@@ -2350,6 +3514,10 @@ static svdb_code_t do_update(svdb_db_t *db, const std::string &sql,
         }
         svdb_set_query_db(db);
         int64_t updated = 0;
+        std::vector<std::pair<Row*,Row>> originals; /* restored on a STRICT type error */
+        const std::vector<std::string> *ckey = clustered_key(db, resolved_tname);
+        std::vector<Row> before_update;
+        if (ckey) before_update = db->data[resolved_tname];
         for (auto &trow : db->data[resolved_tname]) {
             for (auto &frow : db->data[from_table]) {
                 /* Build combined row */
@@ -2362,6 +3530,7 @@ static svdb_code_t do_update(svdb_db_t *db, const std::string &sql,
                 }
                 std::vector<std::string> col_order_vec = combined_order;
                 if (where_clause.empty() || eval_where(combined, col_order_vec, where_clause)) {
+                    originals.push_back({&trow, trow});
                     for (auto &asgn : assignments) {
                         std::string simple_col = asgn.first;
                         /* Strip table qualifier if present */
@@ -2370,12 +3539,39 @@ static svdb_code_t do_update(svdb_db_t *db, const std::string &sql,
                         if (trow.count(simple_col))
                             trow[simple_col] = svdb_eval_expr_in_row(asgn.second, combined, col_order_vec);
                     }
+                    ++db->data_gen;
+                    note_row_written(db, resolved_tname, (size_t)(&trow - db->data[resolved_tname].data()));
+                    bool typed = apply_column_affinity(db, resolved_tname, trow) == SVDB_OK;
+                    if (typed) {
+                        unique_indexes_note(db, resolved_tname, &originals.back().second, trow);
+                        columnar_note_row(db, resolved_tname, (size_t)(&trow - db->data[resolved_tname].data()));
+                    }
+                    if (!typed ||
+                        check_unique_indexes(db, resolved_tname, trow, &trow) != SVDB_OK ||
+                        fk_check_row(db, resolved_tname, trow, &originals.back().second) != SVDB_OK) {
+                        for (auto &o : originals) *o.first = o.second;
+                        ++db->data_gen;
+                        storage_note_kept(db);
+                        svdb_set_query_db(nullptr);
+                        svdb_ast_node_free(ast); svdb_parser_destroy(p);
+                        return SVDB_ERR;
+                    }
                     ++updated;
                     break; /* update target row only once (first match) */
                 }
             }
         }
         svdb_set_query_db(nullptr);
+        if (ckey && updated > 0) {
+            /* Restore the original rows if the new keys collide */
+            if (!clustered_resort(db, resolved_tname, *ckey)) {
+                db->data[resolved_tname] = std::move(before_update);
+                ++db->data_gen;
+                storage_note_kept(db);
+                svdb_ast_node_free(ast); svdb_parser_destroy(p);
+                return SVDB_ERR;
+            }
+        }
         db->rows_affected = updated;
         if (res) { res->code = SVDB_OK; res->rows_affected = updated; }
         svdb_ast_node_free(ast); svdb_parser_destroy(p);
@@ -2457,61 +3653,77 @@ static svdb_code_t do_update(svdb_db_t *db, const std::string &sql,
     int64_t updated = 0;
     /* Collect old and new row values (needed for FK ON UPDATE actions) */
     std::vector<std::pair<Row,Row>> updated_pairs; /* {old_row, new_row} */
+    std::vector<size_t> updated_idx;
+    auto &tdata = db->data[resolved_tname];
+    /* Changing the key of a WITHOUT ROWID table moves rows, so keep a copy to
+     * restore if the new keys collide */
+    const std::vector<std::string> *ckey = clustered_key(db, resolved_tname);
+    bool key_changed = false;
+    if (ckey) {
+        for (const auto &asgn : assignments)
+            if (std::find(ckey->begin(), ckey->end(), asgn.first) != ckey->end()) key_changed = true;
+    }
+    std::vector<Row> before_update;
+    if (key_changed) before_update = tdata;
     svdb_set_query_db(db);  /* set thread-local DB context for subquery eval in SET expressions */
-    for (auto &row : db->data[resolved_tname]) {
+    SvdbWhereTables where_tables{{{resolved_tname, resolved_tname}}, {}};
+    SvdbWhereTablesScope where_scope(&where_tables);
+    for (size_t ri = 0; ri < tdata.size(); ++ri) {
+        Row &row = tdata[ri];
         if (!svdb_eval_where_in_row(where_txt, row, col_order)) continue;
         Row old_row = row;
         for (const auto &asgn : assignments)
             row[asgn.first] = svdb_eval_expr_in_row(asgn.second, row, col_order);
+        ++db->data_gen;
+        note_row_written(db, resolved_tname, ri);
+        bool typed = apply_column_affinity(db, resolved_tname, row) == SVDB_OK;
+        if (typed) {
+            unique_indexes_note(db, resolved_tname, &old_row, row);
+            columnar_note_row(db, resolved_tname, ri);
+        }
+        if (!typed ||
+            check_unique_indexes(db, resolved_tname, row, &row) != SVDB_OK ||
+            fk_check_row(db, resolved_tname, row, &old_row) != SVDB_OK) {
+            /* Undo the rows already changed by this statement */
+            row = old_row;
+            for (size_t k = 0; k < updated_idx.size(); ++k)
+                tdata[updated_idx[k]] = updated_pairs[k].first;
+            ++db->data_gen;
+            storage_note_kept(db);
+            svdb_set_query_db(nullptr);
+            svdb_ast_node_free(ast); svdb_parser_destroy(p);
+            return SVDB_ERR;
+        }
         updated_pairs.push_back({old_row, row});
+        updated_idx.push_back(ri);
         ++updated;
     }
     svdb_set_query_db(nullptr);
-
-    /* FK ON UPDATE actions */
-    if (db->foreign_keys_enabled && !updated_pairs.empty()) {
-        for (auto &kv : db->fk_constraints) {
-            const std::string &child_tname = kv.first;
-            if (!db->data.count(child_tname)) continue;
-            for (const auto &fk : kv.second) {
-                if (str_upper(fk.parent_table) != str_upper(resolved_tname)) continue;
-                std::string action = str_upper(fk.on_update);
-                if (action.empty() || action == "NO ACTION" || action == "RESTRICT") continue;
-                if (action == "CASCADE") {
-                    for (const auto &pr : updated_pairs) {
-                        const Row &old_row = pr.first;
-                        const Row &new_row = pr.second;
-                        auto old_it = old_row.find(fk.parent_col);
-                        auto new_it = new_row.find(fk.parent_col);
-                        if (old_it == old_row.end() || new_it == new_row.end()) continue;
-                        if (fk_vals_equal(old_it->second, new_it->second)) continue; /* no change */
-                        for (auto &crow : db->data[child_tname]) {
-                            auto cit = crow.find(fk.child_col);
-                            if (cit == crow.end()) continue;
-                            if (fk_vals_equal(cit->second, old_it->second))
-                                cit->second = new_it->second;
-                        }
-                    }
-                } else if (action == "SET NULL") {
-                    for (const auto &pr : updated_pairs) {
-                        const Row &old_row = pr.first;
-                        const Row &new_row = pr.second;
-                        auto old_it = old_row.find(fk.parent_col);
-                        auto new_it = new_row.find(fk.parent_col);
-                        if (old_it == old_row.end() || new_it == new_row.end()) continue;
-                        if (fk_vals_equal(old_it->second, new_it->second)) continue;
-                        for (auto &crow : db->data[child_tname]) {
-                            auto cit = crow.find(fk.child_col);
-                            if (cit == crow.end()) continue;
-                            if (fk_vals_equal(cit->second, old_it->second))
-                                cit->second = SvdbVal{};
-                        }
-                    }
+    if (key_changed && updated > 0) {
+        bool ok = true;
+        for (const auto &pr : updated_pairs) {
+            for (const auto &kc : *ckey) {
+                auto kit = pr.second.find(kc);
+                if (kit == pr.second.end() || kit->second.type == SVDB_TYPE_NULL) {
+                    db->last_error = "NOT NULL constraint failed: " + resolved_tname + "." + kc;
+                    ok = false; break;
                 }
             }
+            if (!ok) break;
+        }
+        if (!ok || !clustered_resort(db, resolved_tname, *ckey)) {
+            tdata = std::move(before_update);
+            ++db->data_gen;
+            storage_note_kept(db);
+            svdb_ast_node_free(ast); svdb_parser_destroy(p);
+            return SVDB_ERR;
         }
     }
 
+    /* FK ON UPDATE actions */
+    if (db->foreign_keys_enabled && !updated_pairs.empty())
+        fk_on_update(db, resolved_tname, updated_pairs);
+
     db->rows_affected = updated;
     if (res) { res->code = SVDB_OK; res->rows_affected = updated; }
 
@@ -2543,7 +3755,9 @@ static svdb_code_t fk_on_delete(svdb_db_t *db,
             if (str_upper(fk.parent_table) != str_upper(tname)) continue;
 
             std::string action = str_upper(fk.on_delete);
-            /* Empty on_delete defaults to NO ACTION (= RESTRICT at statement level) */
+            /* Empty on_delete defaults to NO ACTION (= RESTRICT at statement
+             * level), which unlike RESTRICT may be deferred to COMMIT */
+            bool deferrable = action != "RESTRICT" && fk_deferred(db, fk);
             if (action.empty() || action == "NO ACTION") action = "RESTRICT";
 
             if (action == "RESTRICT") {
@@ -2554,6 +3768,7 @@ static svdb_code_t fk_on_delete(svdb_db_t *db,
                         auto cit = crow.find(fk.child_col);
                         if (cit == crow.end()) continue;
                         if (fk_vals_equal(cit->second, pit->second)) {
+                            if (deferrable) { db->fk_pending.insert(child_tname); break; }
                             db->last_error = "FOREIGN KEY constraint failed";
                             return SVDB_ERR;
                         }
@@ -2578,6 +3793,8 @@ static svdb_code_t fk_on_delete(svdb_db_t *db,
                     crows.erase(new_end, crows.end());
                 }
                 if (!cascade_deleted.empty()) {
+                    ++db->data_gen;
+                    storage_note_rows(db, child_tname, 0, 0);
                     svdb_code_t rc = fk_on_delete(db, child_tname, cascade_deleted, depth + 1);
                     if (rc != SVDB_OK) return rc;
                 }
@@ -2585,11 +3802,15 @@ static svdb_code_t fk_on_delete(svdb_db_t *db,
                 for (const auto &drow : deleted_rows) {
                     auto pit = drow.find(fk.parent_col);
                     if (pit == drow.end() || pit->second.type == SVDB_TYPE_NULL) continue;
-                    for (auto &crow : db->data[child_tname]) {
+                    auto &crows = db->data[child_tname];
+                    for (auto &crow : crows) {
                         auto cit = crow.find(fk.child_col);
                         if (cit == crow.end()) continue;
-                        if (fk_vals_equal(cit->second, pit->second))
+                        if (fk_vals_equal(cit->second, pit->second)) {
                             cit->second = SvdbVal{};
+                            ++db->data_gen;
+                            note_row_written(db, child_tname, (size_t)(&crow - crows.data()));
+                        }
                     }
                 }
             }
@@ -2676,11 +3897,21 @@ static svdb_code_t do_delete(svdb_db_t *db, const std::string &sql,
                     }
                 }
                 std::vector<Row> new_rows;
+                size_t first = 0, last = 0;
                 for (size_t i = 0; i < trows.size(); ++i) {
-                    if (to_delete[i]) ++deleted;
-                    else new_rows.push_back(trows[i]);
+                    if (!to_delete[i]) {
+                        new_rows.push_back(trows[i]);
+                        continue;
+                    }
+                    if (deleted++ == 0) first = i;
+                    last = i;
                 }
+                size_t nrows = trows.size();
                 db->data[resolved_tname] = std::move(new_rows);
+                if (deleted > 0) {
+                    ++db->data_gen;
+                    storage_note_rows(db, resolved_tname, first, nrows - 1 - last);
+                }
                 db->rows_affected = deleted;
                 if (res) { res->code = SVDB_OK; res->rows_affected = deleted; }
                 return SVDB_OK;
@@ -2694,11 +3925,15 @@ static svdb_code_t do_delete(svdb_db_t *db, const std::string &sql,
 
     /* Collect deleted rows before erasing (needed for FK cascade) */
     std::vector<Row> deleted_rows;
+    std::vector<size_t> gone;  /* their positions, for the column image */
     svdb_set_query_db(db);
+    SvdbWhereTables where_tables{{{resolved_tname, resolved_tname}}, {}};
+    SvdbWhereTablesScope where_scope(&where_tables);
     auto it = rows.begin();
-    while (it != rows.end()) {
+    for (size_t pos = 0; it != rows.end(); ++pos) {
         if (svdb_eval_where_in_row(where_txt, *it, col_order)) {
             deleted_rows.push_back(*it);
+            gone.push_back(pos);
             it = rows.erase(it);
             ++deleted;
         } else {
@@ -2706,6 +3941,11 @@ static svdb_code_t do_delete(svdb_db_t *db, const std::string &sql,
         }
     }
     svdb_set_query_db(nullptr);
+    if (deleted > 0) {
+        ++db->data_gen;
+        columnar_note_delete(db, resolved_tname, gone);
+        storage_note_rows(db, resolved_tname, gone.front(), rows.size() + gone.size() - 1 - gone.back());
+    }
 
     /* FK ON DELETE actions: CASCADE, SET NULL, RESTRICT/NO ACTION (recursive) */
     if (db->foreign_keys_enabled && !deleted_rows.empty()) {
@@ -2717,6 +3957,8 @@ static svdb_code_t do_delete(svdb_db_t *db, const std::string &sql,
                 if (str_upper(fk.parent_table) != str_upper(resolved_tname)) continue;
                 std::string action = str_upper(fk.on_delete);
                 if (!action.empty() && action != "RESTRICT" && action != "NO ACTION") continue;
+                /* RESTRICT is always checked at once; NO ACTION may be deferred */
+                bool deferrable = action != "RESTRICT" && fk_deferred(db, fk);
                 for (const auto &drow : deleted_rows) {
                     auto pit = drow.find(fk.parent_col);
                     if (pit == drow.end() || pit->second.type == SVDB_TYPE_NULL) continue;
@@ -2724,8 +3966,11 @@ static svdb_code_t do_delete(svdb_db_t *db, const std::string &sql,
                         auto cit = crow.find(fk.child_col);
                         if (cit == crow.end()) continue;
                         if (fk_vals_equal(cit->second, pit->second)) {
+                            if (deferrable) { db->fk_pending.insert(child_tname); break; }
                             /* Undo deletes and return error */
                             for (auto &dr : deleted_rows) db->data[resolved_tname].push_back(dr);
+                            ++db->data_gen;
+                            storage_note_rows(db, resolved_tname, gone.front(), 0);
                             db->last_error = "FOREIGN KEY constraint failed";
                             return SVDB_ERR;
                         }
@@ -2738,6 +3983,8 @@ static svdb_code_t do_delete(svdb_db_t *db, const std::string &sql,
         if (fk_rc != SVDB_OK) {
             /* Undo deletes */
             for (auto &dr : deleted_rows) db->data[resolved_tname].push_back(dr);
+            ++db->data_gen;
+            storage_note_rows(db, resolved_tname, gone.front(), 0);
             return fk_rc;
         }
     }
@@ -2754,61 +4001,1126 @@ static svdb_code_t do_delete(svdb_db_t *db, const std::string &sql,
     return SVDB_OK;
 }
 
-/* ── Public API ─────────────────────────────────────────────────── */
+/* ── MERGE ──────────────────────────────────────────────────────── */
+
+/* One WHEN clause of a MERGE statement */
+struct MergeClause {
+    enum Match  { MATCHED, NOT_MATCHED, NOT_MATCHED_BY_SOURCE } match = MATCHED;
+    enum Action { UPDATE, DELETE, INSERT, NOTHING } action = NOTHING;
+    std::string cond;                                     /* optional AND condition */
+    std::vector<std::pair<std::string,std::string>> sets; /* UPDATE SET col = expr */
+    std::vector<std::string> ins_cols;                    /* INSERT (cols); empty = all */
+    std::vector<std::string> ins_vals;                    /* empty = DEFAULT VALUES */
+};
+
+/* Find the first top-level occurrence of the word kw (upper-case) in s at or
+ * after from. Words inside quotes, parentheses and CASE ... END are skipped,
+ * so the WHEN of a CASE expression is not mistaken for a MERGE clause. */
+static size_t merge_find_kw(const std::string &s, const std::string &kw, size_t from) {
+    int depth = 0, case_depth = 0;
+    char quote = 0;
+    for (size_t i = from; i < s.size(); ++i) {
+        char c = s[i];
+        if (quote) { if (c == quote) quote = 0; continue; }
+        if (c == '\'' || c == '"' || c == '`') { quote = c; continue; }
+        if (c == '(') { ++depth; continue; }
+        if (c == ')') { if (depth > 0) --depth; continue; }
+        if (!isalpha((unsigned char)c) && c != '_') continue;
+        if (i > 0 && (isalnum((unsigned char)s[i-1]) || s[i-1] == '_')) continue;
+        size_t e = i;
+        while (e < s.size() && (isalnum((unsigned char)s[e]) || s[e] == '_')) ++e;
+        std::string w = str_upper(s.substr(i, e - i));
+        if (depth == 0) {
+            if (w == "CASE") ++case_depth;
+            else if (w == "END" && case_depth > 0) --case_depth;
+            else if (case_depth == 0 && w == kw) return i;
+        }
+        i = e - 1;
+    }
+    return std::string::npos;
+}
 
-/* Internal exec (no lock - must be called with lock held) */
-static svdb_code_t svdb_exec_internal(svdb_db_t *db, const std::string &sql_in, svdb_result_t *res) {
-    std::string s = strip_sql_comments(sql_in);
-    s = str_trim(s);
-    std::string kw = first_keyword(s);
-    if (kw == "INSERT")       return do_insert(db, s, res);
-    if (kw == "UPDATE")       return do_update(db, s, res);
-    if (kw == "DELETE")       return do_delete(db, s, res);
-    if (kw == "SELECT") {
-        svdb_rows_t *rows = nullptr;
-        svdb_code_t rc = svdb_query_internal(db, s, &rows);
-        if (rows) svdb_rows_close(rows);
-        return rc;
+/* Read a (possibly quoted) identifier at p, advancing p past it */
+static std::string merge_read_ident(const std::string &s, size_t &p) {
+    while (p < s.size() && isspace((unsigned char)s[p])) ++p;
+    if (p >= s.size()) return "";
+    if (s[p] == '"' || s[p] == '`' || s[p] == '[') {
+        char close = s[p] == '[' ? ']' : s[p];
+        size_t st = ++p;
+        while (p < s.size() && s[p] != close) ++p;
+        std::string id = s.substr(st, p - st);
+        if (p < s.size()) ++p;
+        return id;
     }
-    /* Silently accept anything else in trigger bodies */
-    return SVDB_OK;
+    size_t st = p;
+    while (p < s.size() && (isalnum((unsigned char)s[p]) || s[p] == '_')) ++p;
+    return s.substr(st, p - st);
 }
 
-extern "C" {
+/* Read the next bare word at p (upper-cased) without consuming it */
+static std::string merge_peek_word(const std::string &s, size_t p) {
+    while (p < s.size() && isspace((unsigned char)s[p])) ++p;
+    size_t st = p;
+    while (p < s.size() && (isalnum((unsigned char)s[p]) || s[p] == '_')) ++p;
+    return str_upper(s.substr(st, p - st));
+}
 
-svdb_code_t svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res) {
-    svdb_assert_msg(db != nullptr, "svdb_exec: db must not be NULL");
-    svdb_assert_msg(sql != nullptr, "svdb_exec: sql must not be NULL");
-    if (!db || !sql) return SVDB_ERR;
-    if (res) { res->code = SVDB_OK; res->errmsg = ""; res->rows_affected = 0; res->last_insert_rowid = 0; }
+/* Consume the word kw at p if present */
+static bool merge_accept(const std::string &s, size_t &p, const std::string &kw) {
+    if (merge_peek_word(s, p) != kw) return false;
+    while (p < s.size() && isspace((unsigned char)s[p])) ++p;
+    p += kw.size();
+    return true;
+}
 
-    std::lock_guard<std::mutex> lk(db->mu);
-    db->last_error.clear();
-    db->rows_affected = 0;
+/* Render a value as an SQL literal that parse_literal reads back unchanged */
+static std::string sql_literal(const SvdbVal &v) {
+    static const char hex[] = "0123456789ABCDEF";
+    switch (v.type) {
+    case SVDB_TYPE_INT:
+        return std::to_string(v.ival);
+    case SVDB_TYPE_REAL: {
+        char buf[64];
+        snprintf(buf, sizeof(buf), "%.17g", v.rval);
+        std::string r(buf);
+        if (r.find_first_of(".eEn") == std::string::npos) r += ".0";
+        return r;
+    }
+    case SVDB_TYPE_TEXT: {
+        std::string r = "'";
+        for (char c : v.sval) { if (c == '\'') r += '\''; r += c; }
+        return r + "'";
+    }
+    case SVDB_TYPE_BLOB: {
+        std::string r = "X'";
+        for (unsigned char c : v.sval) { r += hex[c >> 4]; r += hex[c & 15]; }
+        return r + "'";
+    }
+    default:
+        return "NULL";
+    }
+}
 
-    std::string s = strip_sql_comments(std::string(sql));
-    s = str_trim(s);
-    std::string kw = first_keyword(s);
+/* Hash key for an equi-join value. Values that may compare equal share a key
+ * (numeric TEXT is keyed as the number); NULL never joins. */
+static bool merge_join_key(const SvdbVal &v, std::string &out) {
+    SvdbVal n = v;
+    if (n.type == SVDB_TYPE_TEXT) svdb_parse_numeric_text(v.sval, n);
+    switch (n.type) {
+    case SVDB_TYPE_NULL: return false;
+    case SVDB_TYPE_INT:  out += "i" + std::to_string(n.ival); break;
+    case SVDB_TYPE_REAL:
+        if (svdb_real_is_integral(n.rval)) { out += "i" + std::to_string((int64_t)n.rval); break; }
+        { char buf[64]; snprintf(buf, sizeof(buf), "r%.17g", n.rval); out += buf; }
+        break;
+    default:
+        out += (n.type == SVDB_TYPE_TEXT ? "t" : "b") + std::to_string(n.sval.size()) + ":" + n.sval;
+        break;
+    }
+    out += '\x1f';
+    return true;
+}
 
-    svdb_code_t rc = SVDB_OK;
-    if (kw == "CREATE") {
-        std::string su = str_upper(s);
-        size_t p = su.find("CREATE") + 6;
-        while (p < su.size() && isspace((unsigned char)su[p])) ++p;
-        size_t s2 = p;
-        while (p < su.size() && isalpha((unsigned char)su[p])) ++p;
-        std::string what = su.substr(s2, p - s2);
-        
-        /* Handle CREATE [TEMP|TEMPORARY] TABLE */
-        if (what == "TEMP" || what == "TEMPORARY") {
-            /* Skip TEMP/TEMPORARY keyword and find TABLE */
-            while (p < su.size() && isspace((unsigned char)su[p])) ++p;
-            s2 = p;
+static svdb_code_t merge_parse_clause(const std::string &seg, MergeClause &mc, std::string &err) {
+    size_t p = 0;
+    merge_accept(seg, p, "WHEN");
+    if (merge_accept(seg, p, "NOT")) {
+        mc.match = MergeClause::NOT_MATCHED;
+        if (!merge_accept(seg, p, "MATCHED")) { err = "MERGE: expected MATCHED after WHEN NOT"; return SVDB_ERR; }
+        if (merge_accept(seg, p, "BY")) {
+            std::string by = merge_peek_word(seg, p);
+            if (by == "SOURCE") mc.match = MergeClause::NOT_MATCHED_BY_SOURCE;
+            else if (by != "TARGET") { err = "MERGE: expected SOURCE or TARGET after NOT MATCHED BY"; return SVDB_ERR; }
+            merge_accept(seg, p, by);
+        }
+    } else if (!merge_accept(seg, p, "MATCHED")) {
+        err = "MERGE: expected MATCHED or NOT MATCHED after WHEN";
+        return SVDB_ERR;
+    }
+    size_t then_pos = merge_find_kw(seg, "THEN", p);
+    if (then_pos == std::string::npos) { err = "MERGE: missing THEN in WHEN clause"; return SVDB_ERR; }
+    std::string cond = str_trim(seg.substr(p, then_pos - p));
+    if (!cond.empty()) {
+        size_t cp = 0;
+        if (!merge_accept(cond, cp, "AND")) { err = "near \"" + cond + "\": syntax error"; return SVDB_ERR; }
+        mc.cond = str_trim(cond.substr(cp));
+    }
+
+    std::string act = str_trim(seg.substr(then_pos + 4));
+    size_t ap = 0;
+    std::string verb = merge_peek_word(act, ap);
+    merge_accept(act, ap, verb);
+    bool matched = mc.match != MergeClause::NOT_MATCHED;
+    if (verb == "UPDATE" && matched) {
+        mc.action = MergeClause::UPDATE;
+        if (!merge_accept(act, ap, "SET")) { err = "MERGE: expected SET after UPDATE"; return SVDB_ERR; }
+        for (const auto &item : split_top_level(act.substr(ap))) {
+            size_t ip = 0;
+            std::string col = merge_read_ident(item, ip);
+            if (ip < item.size() && item[ip] == '.') { ++ip; col = merge_read_ident(item, ip); }
+            while (ip < item.size() && isspace((unsigned char)item[ip])) ++ip;
+            if (col.empty() || ip >= item.size() || item[ip] != '=') {
+                err = "near \"" + item + "\": syntax error";
+                return SVDB_ERR;
+            }
+            mc.sets.push_back({col, str_trim(item.substr(ip + 1))});
+        }
+        if (mc.sets.empty()) { err = "MERGE: UPDATE SET has no assignments"; return SVDB_ERR; }
+    } else if (verb == "DELETE" && matched) {
+        mc.action = MergeClause::DELETE;
+    } else if (verb == "INSERT" && !matched) {
+        mc.action = MergeClause::INSERT;
+        while (ap < act.size() && isspace((unsigned char)act[ap])) ++ap;
+        if (ap < act.size() && act[ap] == '(') {
+            size_t close = act.find(')', ap);
+            if (close == std::string::npos) { err = "MERGE: unterminated INSERT column list"; return SVDB_ERR; }
+            for (const auto &c : split_top_level(act.substr(ap + 1, close - ap - 1))) {
+                size_t cp = 0;
+                mc.ins_cols.push_back(merge_read_ident(c, cp));
+            }
+            ap = close + 1;
+        }
+        if (merge_accept(act, ap, "DEFAULT")) {
+            if (!merge_accept(act, ap, "VALUES")) { err = "MERGE: expected DEFAULT VALUES"; return SVDB_ERR; }
+        } else {
+            if (!merge_accept(act, ap, "VALUES")) { err = "MERGE: expected VALUES in INSERT action"; return SVDB_ERR; }
+            std::string vals = str_trim(act.substr(ap));
+            if (vals.size() < 2 || vals.front() != '(' || vals.back() != ')') {
+                err = "MERGE: expected parenthesized VALUES list";
+                return SVDB_ERR;
+            }
+            mc.ins_vals = split_top_level(vals.substr(1, vals.size() - 2));
+            if (mc.ins_vals.empty()) { err = "near \")\": syntax error"; return SVDB_ERR; }
+            if (!mc.ins_cols.empty() && mc.ins_cols.size() != mc.ins_vals.size()) {
+                err = std::to_string(mc.ins_vals.size()) + " values for " +
+                      std::to_string(mc.ins_cols.size()) + " columns";
+                return SVDB_ERR;
+            }
+        }
+    } else if (verb == "DO" && merge_accept(act, ap, "NOTHING")) {
+        mc.action = MergeClause::NOTHING;
+    } else {
+        err = matched ? "MERGE: WHEN MATCHED allows only UPDATE, DELETE or DO NOTHING"
+                      : "MERGE: WHEN NOT MATCHED allows only INSERT or DO NOTHING";
+        return SVDB_ERR;
+    }
+    if (mc.action != MergeClause::INSERT && mc.action != MergeClause::UPDATE &&
+        !str_trim(act.substr(ap)).empty()) {
+        err = "near \"" + str_trim(act.substr(ap)) + "\": syntax error";
+        return SVDB_ERR;
+    }
+    return SVDB_OK;
+}
+
+/* Whether a and b are the same non-NULL value of the same type, as a
+ * PRIMARY KEY or UNIQUE constraint compares them */
+static bool merge_same_key_val(const SvdbVal &a, const SvdbVal &b) {
+    if (a.type == SVDB_TYPE_NULL || a.type != b.type) return false;
+    switch (a.type) {
+    case SVDB_TYPE_INT:  return a.ival == b.ival;
+    case SVDB_TYPE_REAL: return a.rval == b.rval;
+    default:             return a.sval == b.sval;
+    }
+}
+
+/* Check the row a MERGE update is about to store at position at of tname
+ * against the NOT NULL, CHECK, PRIMARY KEY and UNIQUE constraints INSERT
+ * enforces. A key is only looked up when the update changed one of its
+ * columns. */
+static svdb_code_t merge_check_row(svdb_db_t *db, const std::string &tname, const Row &row,
+                                   const Row &old_row, size_t at) {
+    const auto &td = db->schema[tname];
+    const auto &col_order = db->col_order[tname];
+    const auto *ckey = clustered_key(db, tname);
+    auto val = [](const Row &r, const std::string &c) {
+        auto it = r.find(c);
+        return it == r.end() ? SvdbVal{} : it->second;
+    };
+    for (const auto &cn : col_order) {
+        auto cit = td.find(cn);
+        bool not_null = cit != td.end() && cit->second.not_null;
+        if (!not_null && ckey)
+            not_null = std::find(ckey->begin(), ckey->end(), cn) != ckey->end();
+        if (not_null && val(row, cn).type == SVDB_TYPE_NULL) {
+            db->last_error = "NOT NULL constraint failed: " + tname + "." + cn;
+            return SVDB_ERR;
+        }
+    }
+
+    auto conflicts = [&](const std::vector<std::string> &cols) {
+        bool changed = false;
+        for (const auto &c : cols) {
+            SvdbVal v = val(row, c), o = val(old_row, c);
+            if (v.type == SVDB_TYPE_NULL) return false;
+            if (!merge_same_key_val(v, o)) changed = true;
+        }
+        if (!changed) return false;
+        const auto &rows = db->data[tname];
+        for (size_t i = 0; i < rows.size(); ++i) {
+            if (i == at) continue;
+            bool same = true;
+            for (const auto &c : cols)
+                if (!merge_same_key_val(val(row, c), val(rows[i], c))) { same = false; break; }
+            if (same) return true;
+        }
+        return false;
+    };
+    auto pit = db->primary_keys.find(tname);
+    if (pit != db->primary_keys.end() && !pit->second.empty()) {
+        if (conflicts(pit->second)) {
+            db->last_error = ckey ? key_conflict_error(tname, *ckey)
+                                  : "UNIQUE constraint failed: " + tname + "." + pit->second[0];
+            return SVDB_ERR;
+        }
+    } else {
+        for (const auto &cn : col_order) {
+            auto cit = td.find(cn);
+            if (cit != td.end() && cit->second.primary_key && conflicts({cn})) {
+                db->last_error = "UNIQUE constraint failed: " + tname + "." + cn;
+                return SVDB_ERR;
+            }
+        }
+    }
+    auto uit = db->unique_constraints.find(tname);
+    if (uit != db->unique_constraints.end()) {
+        for (const auto &ucols : uit->second) {
+            if (conflicts(ucols)) {
+                db->last_error = "UNIQUE constraint failed: " + tname;
+                return SVDB_ERR;
+            }
+        }
+    }
+
+    auto chk = db->check_constraints.find(tname);
+    if (chk != db->check_constraints.end()) {
+        for (const auto &expr : chk->second) {
+            if (!eval_check_constraint(expr, row, col_order)) {
+                db->last_error = "CHECK constraint failed: " + tname;
+                return SVDB_ERR;
+            }
+        }
+    }
+    return SVDB_OK;
+}
+
+/* The tables a MERGE into tname may write, to be put back if it fails:
+ * tname, the tables its foreign key actions reach and, if there are
+ * triggers, every table */
+static std::vector<std::string> merge_written_tables(svdb_db_t *db, const std::string &tname) {
+    std::vector<std::string> out{tname};
+    if (!db->triggers.empty()) {
+        for (const auto &kv : db->data)
+            if (kv.first != tname) out.push_back(kv.first);
+        return out;
+    }
+    if (!db->foreign_keys_enabled) return out;
+    auto writes = [](const std::string &action) {
+        std::string a = str_upper(action);
+        return a == "CASCADE" || a == "SET NULL";
+    };
+    for (size_t i = 0; i < out.size(); ++i) {
+        for (const auto &kv : db->fk_constraints) {
+            if (std::find(out.begin(), out.end(), kv.first) != out.end()) continue;
+            for (const auto &fk : kv.second) {
+                if (str_upper(fk.parent_table) == str_upper(out[i]) &&
+                    (writes(fk.on_delete) || writes(fk.on_update))) {
+                    out.push_back(kv.first);
+                    break;
+                }
+            }
+        }
+    }
+    return out;
+}
+
+/* MERGE INTO target [[AS] t] USING source [[AS] s] ON cond
+ *   WHEN MATCHED [AND c] THEN UPDATE SET ... | DELETE | DO NOTHING
+ *   WHEN NOT MATCHED [BY TARGET] [AND c] THEN INSERT [(cols)] VALUES (...) | DO NOTHING
+ *   WHEN NOT MATCHED BY SOURCE [AND c] THEN UPDATE SET ... | DELETE | DO NOTHING
+ *
+ * The source (a table, view or parenthesized query) is joined to the target as
+ * it was before the statement; each source row takes the first WHEN clause
+ * that applies. Updates and deletes go through the same FK actions and
+ * triggers as UPDATE/DELETE, inserts are executed by do_insert; updated rows
+ * are checked against the same constraints as inserted ones. The statement
+ * is atomic: any error restores the tables it wrote. If changed is non-null it receives
+ * the inserted/updated rows and the deleted rows, for RETURNING. */
+svdb_code_t svdb_merge_internal(svdb_db_t *db, const std::string &sql_in,
+                                svdb_result_t *res, std::vector<Row> *changed,
+                                std::string *target_out) {
+    std::string s = str_trim(sql_in);
+    /* RETURNING rows are built by svdb_query; a plain exec discards them */
+    size_t ret_pos = merge_find_kw(s, "RETURNING", 0);
+    if (ret_pos != std::string::npos) s = str_trim(s.substr(0, ret_pos));
+    while (!s.empty() && (s.back() == ';' || isspace((unsigned char)s.back()))) s.pop_back();
+
+    size_t p = 0;
+    merge_accept(s, p, "MERGE");
+    merge_accept(s, p, "INTO");
+    std::string tname_raw = merge_read_ident(s, p);
+    std::string tname = resolve_table_name(db, tname_raw);
+    if (tname.empty()) {
+        db->last_error = "no such table: " + tname_raw;
+        return SVDB_ERR;
+    }
+    std::string talias = tname;
+    if (merge_accept(s, p, "AS")) talias = merge_read_ident(s, p);
+    else if (merge_peek_word(s, p) != "USING") talias = merge_read_ident(s, p);
+    if (!merge_accept(s, p, "USING")) {
+        db->last_error = "MERGE: expected USING";
+        return SVDB_ERR;
+    }
+
+    /* Source: a table/view name or a parenthesized query */
+    std::string src_sql, salias, src_table;
+    while (p < s.size() && isspace((unsigned char)s[p])) ++p;
+    if (p < s.size() && s[p] == '(') {
+        int depth = 0; char quote = 0;
+        size_t st = p;
+        for (; p < s.size(); ++p) {
+            char c = s[p];
+            if (quote) { if (c == quote) quote = 0; continue; }
+            if (c == '\'' || c == '"' || c == '`') { quote = c; continue; }
+            if (c == '(') ++depth;
+            else if (c == ')' && --depth == 0) break;
+        }
+        if (p >= s.size()) {
+            db->last_error = "MERGE: unterminated source query";
+            return SVDB_ERR;
+        }
+        src_sql = str_trim(s.substr(st + 1, p - st - 1));
+        ++p;
+    } else {
+        std::string sname = merge_read_ident(s, p);
+        salias = src_table = sname;
+        src_sql = "SELECT * FROM " + sql_ident(sname);
+    }
+    if (merge_accept(s, p, "AS")) salias = merge_read_ident(s, p);
+    else if (merge_peek_word(s, p) != "ON") salias = merge_read_ident(s, p);
+    if (!merge_accept(s, p, "ON")) {
+        db->last_error = "MERGE: expected ON";
+        return SVDB_ERR;
+    }
+    size_t when_pos = merge_find_kw(s, "WHEN", p);
+    std::string on_cond = str_trim(s.substr(p, when_pos == std::string::npos ? std::string::npos : when_pos - p));
+    if (when_pos == std::string::npos || on_cond.empty()) {
+        db->last_error = when_pos == std::string::npos ? "MERGE: at least one WHEN clause is required"
+                                                       : "MERGE: missing ON condition";
+        return SVDB_ERR;
+    }
+
+    std::vector<MergeClause> clauses;
+    while (when_pos != std::string::npos) {
+        size_t next = merge_find_kw(s, "WHEN", when_pos + 4);
+        MergeClause mc;
+        std::string err;
+        if (merge_parse_clause(s.substr(when_pos, next == std::string::npos ? std::string::npos : next - when_pos),
+                               mc, err) != SVDB_OK) {
+            db->last_error = err;
+            return SVDB_ERR;
+        }
+        clauses.push_back(std::move(mc));
+        when_pos = next;
+    }
+
+    const auto &tschema = db->schema[tname];
+    const std::vector<std::string> tcols = db->col_order[tname];
+    for (const auto &mc : clauses) {
+        for (const auto &st : mc.sets) {
+            if (!tschema.count(st.first)) {
+                db->last_error = "no such column: " + st.first;
+                return SVDB_ERR;
+            }
+        }
+    }
+
+    /* Materialize the source */
+    svdb_rows_t *src_rows = nullptr;
+    if (svdb_query_internal(db, src_sql, &src_rows) != SVDB_OK) {
+        if (src_rows) svdb_rows_close(src_rows);
+        return SVDB_ERR;
+    }
+    std::vector<std::string> scols = src_rows->col_names;
+    std::vector<std::vector<SvdbVal>> srows = std::move(src_rows->rows);
+    svdb_rows_close(src_rows);
+
+    /* Column names visible to the ON condition and the WHEN clauses: target
+     * columns (bare, by table name and by alias), then source columns
+     * (by alias, and bare where they don't clash with the target). */
+    std::vector<std::string> order;
+    for (const auto &c : tcols) {
+        order.push_back(c);
+        order.push_back(tname + "." + c);
+        if (talias != tname) order.push_back(talias + "." + c);
+    }
+    for (const auto &c : scols) {
+        if (!salias.empty()) order.push_back(salias + "." + c);
+        if (!tschema.count(c)) order.push_back(c);
+    }
+    auto add_target = [&](Row &r, const Row *trow) {
+        for (const auto &c : tcols) {
+            SvdbVal v;
+            if (trow) { auto it = trow->find(c); if (it != trow->end()) v = it->second; }
+            r[tname + "." + c] = v;
+            if (talias != tname) r[talias + "." + c] = v;
+            r[c] = v;
+        }
+    };
+    auto add_source = [&](Row &r, const std::vector<SvdbVal> *srow) {
+        for (size_t i = 0; i < scols.size(); ++i) {
+            SvdbVal v = srow && i < srow->size() ? (*srow)[i] : SvdbVal{};
+            if (!salias.empty()) r[salias + "." + scols[i]] = v;
+            if (!tschema.count(scols[i])) r[scols[i]] = v;
+        }
+    };
+
+    /* Equi-join terms (target column = source column) let matching use a hash
+     * lookup instead of evaluating ON for every pair */
+    std::vector<std::pair<std::string,std::string>> join_cols; /* {target col, source key} */
+    {
+        auto resolve = [&](const std::string &ref, bool &is_target, std::string &key) -> bool {
+            size_t dot = ref.find('.');
+            std::string q = dot == std::string::npos ? "" : ref.substr(0, dot);
+            std::string c = dot == std::string::npos ? ref : ref.substr(dot + 1);
+            if (c.empty()) return false;
+            for (char ch : c) if (!isalnum((unsigned char)ch) && ch != '_') return false;
+            bool in_t = tschema.count(c) > 0;
+            bool in_s = std::find(scols.begin(), scols.end(), c) != scols.end();
+            if (q.empty()) {
+                if (in_t) { is_target = true; key = c; return true; }
+                if (in_s) { is_target = false; key = c; return true; }
+                return false;
+            }
+            if (in_t && (str_upper(q) == str_upper(talias) || str_upper(q) == str_upper(tname))) {
+                is_target = true; key = c; return true;
+            }
+            if (in_s && !salias.empty() && str_upper(q) == str_upper(salias)) {
+                is_target = false; key = salias + "." + c; return true;
+            }
+            return false;
+        };
+        std::string rest = on_cond;
+        while (!rest.empty()) {
+            size_t and_pos = merge_find_kw(rest, "AND", 0);
+            std::string term = str_trim(rest.substr(0, and_pos));
+            rest = and_pos == std::string::npos ? "" : rest.substr(and_pos + 3);
+            size_t eq = term.find('=');
+            if (eq == std::string::npos || eq == 0 || term.find_first_of("=<>!", eq + 1) != std::string::npos ||
+                strchr("<>!", term[eq - 1])) continue;
+            bool lt = false, rt = false;
+            std::string lk, rk;
+            if (!resolve(str_trim(term.substr(0, eq)), lt, lk) ||
+                !resolve(str_trim(term.substr(eq + 1)), rt, rk) || lt == rt) continue;
+            if (lt) join_cols.push_back({lk, rk});
+            else    join_cols.push_back({rk, lk});
+        }
+    }
+
+    /* Snapshot for matching and for rollback */
+    std::unordered_map<std::string, std::vector<Row>> data_snap;
+    for (const auto &t : merge_written_tables(db, tname)) data_snap[t] = db->data[t];
+    auto rowid_snap = db->rowid_counter;
+    int64_t last_rowid_snap = db->last_insert_rowid;
+    const std::vector<Row> &target = data_snap[tname];
+    auto fail = [&](svdb_code_t rc) {
+        for (auto &kv : data_snap) db->data[kv.first] = std::move(kv.second);
+        db->rowid_counter = std::move(rowid_snap);
+        db->last_insert_rowid = last_rowid_snap;
+        ++db->data_gen;
+        storage_note_kept(db);
+        svdb_set_query_db(nullptr);
+        return rc;
+    };
+
+    std::unordered_map<std::string, std::vector<size_t>> target_index;
+    if (!join_cols.empty()) {
+        for (size_t ti = 0; ti < target.size(); ++ti) {
+            std::string key;
+            bool ok = true;
+            for (const auto &jc : join_cols) {
+                auto it = target[ti].find(jc.first);
+                if (it == target[ti].end() || !merge_join_key(it->second, key)) { ok = false; break; }
+            }
+            if (ok) target_index[key].push_back(ti);
+        }
+    }
+
+    /* Decide every action against the pre-statement state */
+    struct MergeAction { const MergeClause *clause; size_t target_idx; long source_idx; };
+    std::vector<MergeAction> actions;
+    std::vector<bool> target_matched(target.size(), false), target_acted(target.size(), false);
+    std::vector<size_t> all_targets;
+    if (join_cols.empty())
+        for (size_t ti = 0; ti < target.size(); ++ti) all_targets.push_back(ti);
+    static const std::vector<size_t> no_candidates;
+    svdb_set_query_db(db);
+    SvdbWhereTables where_tables{{{tname, tname}, {talias, tname}}, {}};
+    if (!src_table.empty()) {
+        where_tables.tables.push_back({src_table, src_table});
+        where_tables.tables.push_back({salias, src_table});
+    }
+    SvdbWhereTablesScope where_scope(&where_tables);
+    for (size_t si = 0; si < srows.size(); ++si) {
+        const std::vector<size_t> *cands = &all_targets;
+        if (!join_cols.empty()) {
+            Row probe;
+            add_source(probe, &srows[si]);
+            std::string key;
+            bool ok = true;
+            for (const auto &jc : join_cols) {
+                auto it = probe.find(jc.second);
+                if (it == probe.end() || !merge_join_key(it->second, key)) { ok = false; break; }
+            }
+            auto hit = ok ? target_index.find(key) : target_index.end();
+            cands = hit != target_index.end() ? &hit->second : &no_candidates;
+        }
+        bool any = false;
+        for (size_t ti : *cands) {
+            Row combined;
+            add_target(combined, &target[ti]);
+            add_source(combined, &srows[si]);
+            if (!svdb_eval_where_in_row(on_cond, combined, order)) continue;
+            any = true;
+            target_matched[ti] = true;
+            for (const auto &mc : clauses) {
+                if (mc.match != MergeClause::MATCHED) continue;
+                if (!mc.cond.empty() && !svdb_eval_where_in_row(mc.cond, combined, order)) continue;
+                if (mc.action != MergeClause::NOTHING) {
+                    if (target_acted[ti]) {
+                        db->last_error = "MERGE command cannot affect row a second time";
+                        return fail(SVDB_ERR);
+                    }
+                    target_acted[ti] = true;
+                    actions.push_back({&mc, ti, (long)si});
+                }
+                break;
+            }
+        }
+        if (any) continue;
+        Row combined;
+        add_target(combined, nullptr);
+        add_source(combined, &srows[si]);
+        for (const auto &mc : clauses) {
+            if (mc.match != MergeClause::NOT_MATCHED) continue;
+            if (!mc.cond.empty() && !svdb_eval_where_in_row(mc.cond, combined, order)) continue;
+            if (mc.action != MergeClause::NOTHING) actions.push_back({&mc, 0, (long)si});
+            break;
+        }
+    }
+    for (size_t ti = 0; ti < target.size(); ++ti) {
+        if (target_matched[ti]) continue;
+        Row combined;
+        add_target(combined, &target[ti]);
+        add_source(combined, nullptr);
+        for (const auto &mc : clauses) {
+            if (mc.match != MergeClause::NOT_MATCHED_BY_SOURCE) continue;
+            if (!mc.cond.empty() && !svdb_eval_where_in_row(mc.cond, combined, order)) continue;
+            if (mc.action != MergeClause::NOTHING) actions.push_back({&mc, ti, -1});
+            break;
+        }
+    }
+
+    /* Apply. Target rows are located by rowid, or by key in a WITHOUT ROWID table. */
+    const std::vector<std::string> *ckey = clustered_key(db, tname);
+    auto locate = [&](const Row &orig) -> long {
+        auto &rows = db->data[tname];
+        if (ckey) return clustered_find(db, tname, *ckey, orig);
+        auto rit = orig.find(SVDB_ROWID_COLUMN);
+        if (rit == orig.end()) return -1;
+        for (size_t i = 0; i < rows.size(); ++i) {
+            auto it = rows[i].find(SVDB_ROWID_COLUMN);
+            if (it != rows[i].end() && it->second.ival == rit->second.ival) return (long)i;
+        }
+        return -1;
+    };
+    int64_t affected = 0;
+    for (const auto &a : actions) {
+        svdb_set_query_db(db);
+        const MergeClause &mc = *a.clause;
+        const std::vector<SvdbVal> *srow = a.source_idx >= 0 ? &srows[a.source_idx] : nullptr;
+        if (mc.action == MergeClause::INSERT) {
+            Row combined;
+            add_target(combined, nullptr);
+            add_source(combined, srow);
+            std::string ins = "INSERT INTO " + sql_ident(tname);
+            if (!mc.ins_cols.empty()) {
+                ins += " (";
+                for (size_t i = 0; i < mc.ins_cols.size(); ++i)
+                    ins += (i ? ", " : "") + sql_ident(mc.ins_cols[i]);
+                ins += ")";
+            }
+            Row probe;
+            if (mc.ins_vals.empty()) {
+                ins += " DEFAULT VALUES";
+            } else {
+                const auto &cols = mc.ins_cols.empty() ? tcols : mc.ins_cols;
+                ins += " VALUES (";
+                for (size_t i = 0; i < mc.ins_vals.size(); ++i) {
+                    SvdbVal v = svdb_eval_expr_in_row(mc.ins_vals[i], combined, order);
+                    if (i < cols.size()) probe[cols[i]] = v;
+                    ins += (i ? ", " : "") + sql_literal(v);
+                }
+                ins += ")";
+            }
+            int64_t next_rowid = db->rowid_counter[tname] + 1;
+            if (do_insert(db, ins, nullptr) != SVDB_OK) return fail(SVDB_ERR);
+            if (changed) {
+                auto &rows = db->data[tname];
+                long at = -1;
+                if (ckey) {
+                    if (apply_column_affinity(db, tname, probe) == SVDB_OK)
+                        at = clustered_find(db, tname, *ckey, probe);
+                } else {
+                    for (size_t i = rows.size(); i-- > 0; ) {
+                        auto it = rows[i].find(SVDB_ROWID_COLUMN);
+                        if (it != rows[i].end() && it->second.ival == next_rowid) { at = (long)i; break; }
+                    }
+                }
+                if (at >= 0) changed->push_back(rows[at]);
+            }
+            ++affected;
+            continue;
+        }
+
+        long at = locate(target[a.target_idx]);
+        if (at < 0) continue; /* already removed by a trigger or FK action */
+        auto &rows = db->data[tname];
+        if (mc.action == MergeClause::DELETE) {
+            std::vector<Row> deleted{rows[at]};
+            rows.erase(rows.begin() + at);
+            ++db->data_gen;
+            storage_note_rows(db, tname, (size_t)at, rows.size() - (size_t)at);
+            if (db->foreign_keys_enabled && fk_on_delete(db, tname, deleted) != SVDB_OK)
+                return fail(SVDB_ERR);
+            if (changed) changed->push_back(deleted[0]);
+            ++affected;
+            if (!db->triggers.empty())
+                fire_triggers(db, TRIGGER_AFTER, TRIGGER_DELETE, tname, nullptr, &deleted[0]);
+            continue;
+        }
+
+        /* UPDATE */
+        Row old_row = rows[at];
+        Row combined;
+        add_target(combined, &old_row);
+        add_source(combined, srow);
+        Row new_row = old_row;
+        for (const auto &st : mc.sets)
+            new_row[st.first] = svdb_eval_expr_in_row(st.second, combined, order);
+        if (apply_column_affinity(db, tname, new_row) != SVDB_OK ||
+            merge_check_row(db, tname, new_row, old_row, (size_t)at) != SVDB_OK ||
+            check_unique_indexes(db, tname, new_row, &rows[at]) != SVDB_OK ||
+            fk_check_row(db, tname, new_row, &old_row) != SVDB_OK) return fail(SVDB_ERR);
+        rows[at] = new_row;
+        ++db->data_gen;
+        note_row_written(db, tname, (size_t)at);
+        unique_indexes_note(db, tname, &old_row, new_row);
+        columnar_note_row(db, tname, (size_t)at);
+        if (ckey && svdb_row_key_cmp(old_row, new_row, *ckey) != 0 &&
+            !clustered_resort(db, tname, *ckey)) return fail(SVDB_ERR);
+        if (db->foreign_keys_enabled) fk_on_update(db, tname, {{old_row, new_row}});
+        if (changed) changed->push_back(new_row);
+        ++affected;
+        if (!db->triggers.empty())
+            fire_triggers(db, TRIGGER_AFTER, TRIGGER_UPDATE, tname, &new_row, &old_row);
+    }
+    svdb_set_query_db(nullptr);
+
+    if (target_out) *target_out = tname;
+    db->rows_affected = affected;
+    if (res) {
+        res->code = SVDB_OK;
+        res->rows_affected = affected;
+        res->last_insert_rowid = db->last_insert_rowid;
+    }
+    return SVDB_OK;
+}
+
+/* True, with db->last_error set, if db is read-only (or PRAGMA query_only
+ * is on) and sql would change it. VACUUM INTO only reads the database. */
+bool svdb_write_refused(svdb_db_t *db, const std::string &sql) {
+    if (!db->read_only && !db->query_only_val) return false;
+    std::string s = str_trim(strip_sql_comments(sql));
+    std::string kw = first_keyword(s);
+    static const std::set<std::string> writes = {
+        "INSERT", "UPDATE", "DELETE", "REPLACE", "MERGE", "CREATE", "DROP",
+        "ALTER", "REINDEX", "ANALYZE", "VACUUM", "COPY"};
+    if (!writes.count(kw)) return false;
+    if (kw == "VACUUM" && str_upper(s).find(" INTO ") != std::string::npos) return false;
+    db->last_error = "attempt to write a readonly database";
+    return true;
+}
+
+/* ── Bulk loading ───────────────────────────────────────────────── */
+
+bool bulk_open(svdb_db_t *db, const std::string &table, const std::vector<std::string> &cols,
+               BulkLoad &b) {
+    std::string t = resolve_table_name(db, table);
+    if (t.empty()) {
+        db->last_error = "no such table: " + table;
+        return false;
+    }
+    b.table = t;
+    b.uniques.clear();
+    b.gen = 0;
+    b.cols.clear();
+    if (cols.empty()) {
+        b.cols = db->col_order[t];
+        return true;
+    }
+    const auto &td = db->schema[t];
+    for (const auto &c : cols) {
+        std::string name;
+        if (td.count(c)) {
+            name = c;
+        } else {
+            for (const auto &kv : td)
+                if (str_upper(kv.first) == str_upper(c)) { name = kv.first; break; }
+        }
+        if (name.empty()) {
+            db->last_error = "table " + t + " has no column named " + c;
+            return false;
+        }
+        b.cols.push_back(name);
+    }
+    return true;
+}
+
+/* The key of row under u, false if the row has none: a key part is NULL or,
+ * for a partial index, the row is not covered */
+static bool bulk_unique_key(svdb_db_t *db, const BulkUnique &u, const Row &row, std::string &out) {
+    if (!u.index.empty()) return unique_index_key(db, db->indexes.at(u.index), row, out);
+    out.clear();
+    for (const auto &c : u.cols) {
+        auto it = row.find(c);
+        if (it == row.end() || it->second.type == SVDB_TYPE_NULL) { out.clear(); return false; }
+        unique_key_part(out, it->second, u.numeric);
+    }
+    return true;
+}
+
+/* Collect the uniqueness rules do_insert enforces for b.table, with the keys
+ * of the stored rows */
+static void bulk_load_keys(svdb_db_t *db, BulkLoad &b) {
+    const std::string &t = b.table;
+    const auto *ckey = clustered_key(db, t);
+    b.uniques.clear();
+    auto pit = db->primary_keys.find(t);
+    if (pit != db->primary_keys.end() && !pit->second.empty()) {
+        BulkUnique u;
+        u.cols = pit->second;
+        u.numeric = ckey != nullptr;
+        u.error = ckey ? key_conflict_error(t, *ckey)
+                       : "UNIQUE constraint failed: " + t + "." + pit->second[0];
+        b.uniques.push_back(u);
+    } else {
+        for (const auto &cn : db->col_order[t]) {
+            auto cit = db->schema[t].find(cn);
+            if (cit == db->schema[t].end() || !cit->second.primary_key) continue;
+            BulkUnique u;
+            u.cols = {cn};
+            u.error = "UNIQUE constraint failed: " + t + "." + cn;
+            b.uniques.push_back(u);
+        }
+    }
+    auto uit = db->unique_constraints.find(t);
+    if (uit != db->unique_constraints.end()) {
+        for (const auto &ucols : uit->second) {
+            BulkUnique u;
+            u.cols = ucols;
+            u.error = "UNIQUE constraint failed: " + t;
+            b.uniques.push_back(u);
+        }
+    }
+    for (const auto &kv : db->indexes) {
+        const IndexDef &idx = kv.second;
+        if (!idx.unique || idx.table != t || index_is_plain(db, idx)) continue;
+        BulkUnique u;
+        u.index = kv.first;
+        u.numeric = true;
+        u.error = unique_index_error(db, kv.first, idx);
+        b.uniques.push_back(u);
+    }
+    std::string key;
+    for (const auto &row : db->data[t])
+        for (auto &u : b.uniques)
+            if (bulk_unique_key(db, u, row, key)) u.keys.insert(key);
+}
+
+/* bulk_insert within its statement scope */
+static svdb_code_t bulk_store(svdb_db_t *db, BulkLoad &b, std::vector<std::vector<SvdbVal>> &rows,
+                              int64_t first_row, int64_t max_rejects,
+                              std::vector<BulkReject> &rejects) {
+    const std::string &t = b.table;
+    const auto &col_order = db->col_order[t];
+    const auto &td = db->schema[t];
+    const auto *ckey = clustered_key(db, t);
+    auto chk = db->check_constraints.find(t);
+    int64_t rowid_base = db->rowid_counter[t];
+    int64_t nrejected = 0;
+    std::vector<Row> staged;
+    staged.reserve(rows.size());
+    std::vector<std::string> keys(b.uniques.size());
+
+    for (size_t ri = 0; ri < rows.size(); ++ri) {
+        auto &vals = rows[ri];
+        std::string err;
+        Row row;
+        for (const auto &cn : col_order) {
+            auto dit = td.find(cn);
+            if (dit != td.end() && !dit->second.default_val.empty())
+                row[cn] = svdb_eval_expr_in_row(dit->second.default_val, row, {});
+            else
+                row[cn] = SvdbVal{};
+        }
+        if (vals.size() != b.cols.size())
+            err = std::to_string(vals.size()) + " values for " + std::to_string(b.cols.size()) + " columns";
+        for (size_t ci = 0; ci < vals.size() && ci < b.cols.size(); ++ci)
+            row[b.cols[ci]] = std::move(vals[ci]);
+        if (err.empty() && apply_column_affinity(db, t, row) != SVDB_OK) err = db->last_error;
+
+        /* Omitted INTEGER PRIMARY KEY values come from the rowid counter */
+        for (const auto &cn : col_order) {
+            if (ckey || !err.empty()) break;
+            auto cit = td.find(cn);
+            if (cit != td.end() && cit->second.primary_key &&
+                (cit->second.auto_increment || str_upper(cit->second.type) == "INTEGER") &&
+                row[cn].type == SVDB_TYPE_NULL)
+                row[cn] = SvdbVal{SVDB_TYPE_INT, db->rowid_counter[t] + 1, 0.0, {}};
+        }
+        for (const auto &cn : col_order) {
+            if (!err.empty()) break;
+            auto cit = td.find(cn);
+            bool not_null = cit != td.end() && cit->second.not_null;
+            if (!not_null && ckey)
+                not_null = std::find(ckey->begin(), ckey->end(), cn) != ckey->end();
+            if (not_null && row[cn].type == SVDB_TYPE_NULL)
+                err = "NOT NULL constraint failed: " + t + "." + cn;
+        }
+        for (size_t ui = 0; ui < b.uniques.size() && err.empty(); ++ui) {
+            if (bulk_unique_key(db, b.uniques[ui], row, keys[ui]) && b.uniques[ui].keys.count(keys[ui]))
+                err = b.uniques[ui].error;
+        }
+        if (err.empty() && chk != db->check_constraints.end()) {
+            for (const auto &expr : chk->second) {
+                if (!eval_check_constraint(expr, row, col_order)) {
+                    err = "CHECK constraint failed: " + t;
+                    break;
+                }
+            }
+        }
+        if (err.empty() && fk_check_row(db, t, row) != SVDB_OK) err = db->last_error;
+
+        if (!err.empty()) {
+            rejects.push_back({first_row + (int64_t)ri, err});
+            if (max_rejects >= 0 && ++nrejected > max_rejects) {
+                db->rowid_counter[t] = rowid_base;
+                db->last_error = err;
+                return SVDB_ERR;
+            }
+            continue;
+        }
+        for (size_t ui = 0; ui < b.uniques.size(); ++ui)
+            if (!keys[ui].empty()) b.uniques[ui].keys.insert(keys[ui]);
+        if (!ckey) {
+            db->rowid_counter[t]++;
+            row[SVDB_ROWID_COLUMN] = SvdbVal{SVDB_TYPE_INT, db->rowid_counter[t], 0.0, {}};
+        }
+        staged.push_back(std::move(row));
+    }
+
+    auto &data = db->data[t];
+    data.reserve(data.size() + staged.size());
+    for (auto &row : staged) data.push_back(std::move(row));
+    if (!staged.empty()) {
+        ++db->data_gen;
+        storage_note_rows(db, t, data.size() - staged.size(), 0);
+    }
+    if (ckey && !b.defer_sort) clustered_resort(db, t, *ckey);
+    db->rows_affected = (int64_t)staged.size();
+    if (!ckey && !staged.empty()) db->last_insert_rowid = db->rowid_counter[t];
+    return SVDB_OK;
+}
+
+svdb_code_t bulk_insert(svdb_db_t *db, BulkLoad &b, std::vector<std::vector<SvdbVal>> &rows,
+                        int64_t first_row, int64_t max_rejects, std::vector<BulkReject> &rejects) {
+    db->last_error.clear();
+    db->rows_affected = 0;
+    if (!db->schema.count(b.table)) {
+        db->last_error = "no such table: " + b.table;
+        return SVDB_ERR;
+    }
+    for (const auto &c : b.cols) {
+        if (!db->schema[b.table].count(c)) {
+            db->last_error = "table " + b.table + " has no column named " + c;
+            return SVDB_ERR;
+        }
+    }
+    /* Other statements may have changed the rows or the schema */
+    if (b.gen != db->data_gen) bulk_load_keys(db, b);
+    svdb_code_t rc;
+    {
+        SvdbExecScope scope(db);
+        rc = bulk_store(db, b, rows, first_row, max_rejects, rejects);
+    }
+    /* A failed chunk left keys of rows it did not store */
+    b.gen = rc == SVDB_OK ? db->data_gen : 0;
+    return rc;
+}
+
+/* ── sqlite_sequence ────────────────────────────────────────────── */
+
+/* Whether t has an INTEGER PRIMARY KEY AUTOINCREMENT column */
+static bool has_autoincrement(svdb_db_t *db, const std::string &t) {
+    for (const auto &kv : db->schema[t])
+        if (kv.second.auto_increment) return true;
+    return false;
+}
+
+/* Writes to sqlite_sequence, as a dump restores it:
+ *   DELETE FROM sqlite_sequence
+ *   INSERT INTO sqlite_sequence [(name, seq)] VALUES ('t', n), ...
+ * The counter of an AUTOINCREMENT table is its highest rowid ever used, so
+ * deleting its entry leaves the highest rowid present, and inserting one
+ * raises the counter to n. A counter never goes below a rowid present. */
+static svdb_code_t do_sqlite_sequence(svdb_db_t *db, const std::string &sql, svdb_result_t *res) {
+    std::string su = str_upper(sql);
+    while (!su.empty() && (su.back() == ';' || isspace((unsigned char)su.back()))) su.pop_back();
+    int64_t changed = 0;
+    if (first_keyword(su) == "DELETE") {
+        if (su.find(" WHERE ") != std::string::npos) {
+            db->last_error = "DELETE FROM sqlite_sequence: WHERE is not supported";
+            return SVDB_ERR;
+        }
+        for (auto &kv : db->rowid_counter) {
+            if (!has_autoincrement(db, kv.first)) continue;
+            int64_t top = 0;
+            for (const Row &r : db->data[kv.first]) {
+                auto rit = r.find(SVDB_ROWID_COLUMN);
+                if (rit != r.end() && rit->second.ival > top) top = rit->second.ival;
+            }
+            kv.second = top;
+            ++changed;
+        }
+    } else {
+        size_t vp = su.find("VALUES");
+        if (vp == std::string::npos) {
+            db->last_error = "INSERT INTO sqlite_sequence: expected VALUES";
+            return SVDB_ERR;
+        }
+        for (const std::string &tuple : split_top_level(sql.substr(vp + 6, su.size() - vp - 6))) {
+            std::string t = str_trim(tuple);
+            std::vector<std::string> vals;
+            if (t.size() >= 2 && t.front() == '(' && t.back() == ')')
+                vals = split_top_level(t.substr(1, t.size() - 2));
+            if (vals.size() != 2) {
+                db->last_error = "INSERT INTO sqlite_sequence: expected (name, seq)";
+                return SVDB_ERR;
+            }
+            SvdbVal name = svdb_eval_expr_in_row(vals[0], Row{}, {});
+            SvdbVal seq = svdb_eval_expr_in_row(vals[1], Row{}, {});
+            std::string tname = resolve_table_name(db, name.sval);
+            if (name.type != SVDB_TYPE_TEXT || tname.empty()) {
+                db->last_error = "INSERT INTO sqlite_sequence: no such table: " + name.sval;
+                return SVDB_ERR;
+            }
+            if (seq.type != SVDB_TYPE_INT) {
+                db->last_error = "INSERT INTO sqlite_sequence: seq must be an integer";
+                return SVDB_ERR;
+            }
+            if (seq.ival > db->rowid_counter[tname]) db->rowid_counter[tname] = seq.ival;
+            ++changed;
+        }
+    }
+    if (changed > 0) {
+        ++db->data_gen;
+        storage_note_kept(db);
+    }
+    db->rows_affected = changed;
+    if (res) { res->code = SVDB_OK; res->rows_affected = changed; res->last_insert_rowid = db->last_insert_rowid; }
+    return SVDB_OK;
+}
+
+/* Whether s, whose first keyword is kw, is INSERT INTO or DELETE FROM sqlite_sequence */
+static bool writes_sqlite_sequence(const std::string &kw, const std::string &s) {
+    if (kw != "INSERT" && kw != "DELETE") return false;
+    std::string su = str_upper(s);
+    size_t tp = su.find(kw == "INSERT" ? "INTO" : "FROM");
+    if (tp == std::string::npos) return false;
+    tp += 4;
+    while (tp < su.size() && isspace((unsigned char)su[tp])) ++tp;
+    return su.compare(tp, 15, "SQLITE_SEQUENCE") == 0 &&
+           (tp + 15 == su.size() || !(isalnum((unsigned char)su[tp + 15]) || su[tp + 15] == '_'));
+}
+
+/* Whether a statement with first keyword kw changes the catalog, a saved
+ * setting or, rolling back, the rows. Writes move db->data_gen themselves
+ * where they change rows. */
+static bool changes_catalog(const std::string &kw, const std::string &s) {
+    if (kw == "PRAGMA") return s.find('=') != std::string::npos;
+    return kw == "CREATE" || kw == "DROP" || kw == "ALTER" || kw == "ROLLBACK" ||
+           kw == "VACUUM" || kw == "ANALYZE" || kw == "REINDEX";
+}
+
+/* ── Public API ─────────────────────────────────────────────────── */
+
+/* Internal exec (no lock - must be called with lock held) */
+static svdb_code_t svdb_exec_internal(svdb_db_t *db, const std::string &sql_in, svdb_result_t *res) {
+    std::string s = strip_sql_comments(sql_in);
+    s = str_trim(s);
+    std::string kw = first_keyword(s);
+    if (writes_sqlite_sequence(kw, s)) return do_sqlite_sequence(db, s, res);
+    if (kw == "INSERT")       return do_insert(db, s, res);
+    if (kw == "UPDATE")       return do_update(db, s, res);
+    if (kw == "DELETE")       return do_delete(db, s, res);
+    if (kw == "MERGE")        return svdb_merge_internal(db, s, res, nullptr, nullptr);
+    if (kw == "SELECT") {
+        svdb_rows_t *rows = nullptr;
+        svdb_code_t rc = svdb_query_internal(db, s, &rows);
+        if (rows) svdb_rows_close(rows);
+        return rc;
+    }
+    /* Silently accept anything else in trigger bodies */
+    return SVDB_OK;
+}
+
+
+extern "C" {
+
+/* svdb_exec with db->mu held */
+static svdb_code_t exec_locked(svdb_db_t *db, const char *sql, svdb_result_t *res) {
+    SvdbExecScope scope(db);
+    db->last_error.clear();
+    db->rows_affected = 0;
+
+    std::string s = strip_sql_comments(std::string(sql));
+    s = str_trim(s);
+    std::string kw = first_keyword(s);
+
+    if (svdb_write_refused(db, s)) {
+        if (res) { res->code = SVDB_READONLY; res->errmsg = db->last_error.c_str(); }
+        return SVDB_READONLY;
+    }
+    svdb_code_t rc = SVDB_OK;
+    if (kw == "CREATE") {
+        std::string su = str_upper(s);
+        size_t p = su.find("CREATE") + 6;
+        while (p < su.size() && isspace((unsigned char)su[p])) ++p;
+        size_t s2 = p;
+        while (p < su.size() && isalpha((unsigned char)su[p])) ++p;
+        std::string what = su.substr(s2, p - s2);
+        
+        /* Handle CREATE [TEMP|TEMPORARY] TABLE */
+        if (what == "TEMP" || what == "TEMPORARY") {
+            /* Skip TEMP/TEMPORARY keyword and find TABLE */
+            while (p < su.size() && isspace((unsigned char)su[p])) ++p;
+            s2 = p;
             while (p < su.size() && isalpha((unsigned char)su[p])) ++p;
             what = su.substr(s2, p - s2);
         }
         
         if (what == "TABLE")      rc = do_create_table(db, s);
+        else if (what == "VIRTUAL") rc = do_create_virtual_table(db, s);
         else if (what == "UNIQUE") {
             /* CREATE UNIQUE INDEX ... */
             rc = do_create_index(db, s, true);
@@ -2933,12 +5245,18 @@ svdb_code_t svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res) {
         else                  rc = SVDB_OK;
     } else if (kw == "ALTER") {
         rc = do_alter_table(db, s);
+    } else if (writes_sqlite_sequence(kw, s)) {
+        rc = do_sqlite_sequence(db, s, res);
     } else if (kw == "INSERT") {
         rc = do_insert(db, s, res);
     } else if (kw == "UPDATE") {
         rc = do_update(db, s, res);
     } else if (kw == "DELETE") {
         rc = do_delete(db, s, res);
+    } else if (kw == "MERGE") {
+        rc = svdb_merge_internal(db, s, res, nullptr, nullptr);
+    } else if (kw == "COPY") {
+        rc = copy_exec(db, s, res);
     } else if (kw == "BEGIN") {
         /* SQL-level transaction: create snapshot */
         if (db->in_transaction) {
@@ -2956,10 +5274,12 @@ svdb_code_t svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res) {
         }
     } else if (kw == "COMMIT") {
         if (db->in_transaction && db->sql_tx) {
+            /* Deferred FK violations fail the COMMIT and roll the transaction back */
+            rc = fk_check_pending(db);
+            if (rc != SVDB_OK) tx_rollback(db, db->sql_tx);
+            else tx_finish(db);
             delete db->sql_tx;
             db->sql_tx = nullptr;
-            db->in_transaction = false;
-            rc = SVDB_OK;
         } else {
             rc = SVDB_ERR; /* COMMIT without BEGIN */
         }
@@ -3002,11 +5322,9 @@ svdb_code_t svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res) {
             }
         } else if (db->in_transaction && db->sql_tx) {
             /* Full rollback */
-            db->data          = db->sql_tx->data_snapshot;
-            db->rowid_counter = db->sql_tx->rowid_snapshot;
+            tx_rollback(db, db->sql_tx);
             delete db->sql_tx;
             db->sql_tx = nullptr;
-            db->in_transaction = false;
             rc = SVDB_OK;
         } else {
             rc = SVDB_ERR; /* ROLLBACK without BEGIN */
@@ -3054,6 +5372,28 @@ svdb_code_t svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res) {
             }
         }
         rc = SVDB_OK;
+    } else if (kw == "SET") {
+        /* SET CONSTRAINTS ALL DEFERRED | IMMEDIATE. As in the SQL standard it
+         * only moves DEFERRABLE foreign keys: a NOT DEFERRABLE one (the
+         * default) is still checked at once, and the statement succeeds
+         * without touching it. Outside a transaction it has no effect. */
+        std::string su2 = str_upper(s);
+        while (!su2.empty() && (su2.back() == ';' || isspace((unsigned char)su2.back()))) su2.pop_back();
+        std::istringstream words(su2);
+        std::string w1, w2, w3, w4, extra;
+        words >> w1 >> w2 >> w3 >> w4;
+        if (w2 != "CONSTRAINTS") {
+            rc = SVDB_OK;
+        } else if (w3 != "ALL" || (w4 != "DEFERRED" && w4 != "IMMEDIATE") || (words >> extra)) {
+            db->last_error = "SET CONSTRAINTS: expected ALL DEFERRED or ALL IMMEDIATE";
+            rc = SVDB_ERR;
+        } else if (db->in_transaction) {
+            db->constraint_mode = w4 == "DEFERRED" ? CONSTRAINTS_DEFERRED : CONSTRAINTS_IMMEDIATE;
+            /* Switching to IMMEDIATE checks what was deferred so far */
+            rc = w4 == "IMMEDIATE" ? fk_check_pending(db) : SVDB_OK;
+        }
+    } else if (kw == "VACUUM") {
+        rc = vacuum_exec(db, s);
     } else if (kw == "PRAGMA") {
         /* Route PRAGMA through svdb_query_pragma so SET values are stored */
         svdb_rows_t *rows = nullptr;
@@ -3101,14 +5441,17 @@ svdb_code_t svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res) {
                 svdb_rows_t *rows = nullptr;
                 rc = svdb_query_internal(db, rewritten, &rows);
                 if (rc == SVDB_OK && rows && !new_table.empty()) {
-                    /* Build CREATE TABLE statement from result columns */
+                    /* Build CREATE TABLE statement from result columns; the columns
+                     * are untyped so copied values keep their types */
                     std::string create_sql2 = "CREATE TABLE " + new_table + " (";
                     for (size_t ci = 0; ci < rows->col_names.size(); ++ci) {
                         if (ci) create_sql2 += ", ";
-                        create_sql2 += rows->col_names[ci] + " TEXT";
+                        create_sql2 += rows->col_names[ci];
                     }
                     create_sql2 += ")";
                     do_create_table(db, create_sql2);
+                    ++db->data_gen;
+                    storage_note_kept(db);
                     /* Insert all rows */
                     for (auto &row : rows->rows) {
                         std::string ins = "INSERT INTO " + new_table + " VALUES (";
@@ -3148,6 +5491,10 @@ svdb_code_t svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res) {
             rc = SVDB_OK;
         }
     }
+    if (changes_catalog(kw, s)) {
+        ++db->data_gen;
+        storage_note_kept(db);
+    }
 
     if (rc != SVDB_OK && res) {
         res->code   = rc;
@@ -3156,6 +5503,24 @@ svdb_code_t svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res) {
     return rc;
 }
 
+svdb_code_t svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res) {
+    svdb_assert_msg(db != nullptr, "svdb_exec: db must not be NULL");
+    svdb_assert_msg(sql != nullptr, "svdb_exec: sql must not be NULL");
+    if (!db || !sql) return SVDB_ERR;
+    if (res) { res->code = SVDB_OK; res->errmsg = ""; res->rows_affected = 0; res->last_insert_rowid = 0; }
+
+    std::lock_guard<std::mutex> lk(db->mu);
+    svdb_code_t rc = exec_locked(db, sql, res);
+    /* In WAL mode what the statement committed goes to the log */
+    std::string err;
+    if (!storage_commit(db, err)) {
+        db->last_error = "disk I/O error: " + err;
+        rc = SVDB_ERR;
+        if (res) { res->code = rc; res->errmsg = db->last_error.c_str(); }
+    }
+    return rc;
+}
+
 /* Prepared statement stubs */
 
 svdb_code_t svdb_prepare(svdb_db_t *db, const char *sql, svdb_stmt_t **stmt) {
@@ -3258,24 +5623,37 @@ svdb_code_t svdb_begin(svdb_db_t *db, svdb_tx_t **tx) {
 svdb_code_t svdb_commit(svdb_tx_t *tx) {
     BUG_ON(tx == nullptr);
     if (!tx) return SVDB_ERR;
-    if (tx->db) tx->db->in_transaction = false;
+    svdb_code_t rc = SVDB_OK;
+    if (tx->db) {
+        rc = fk_check_pending(tx->db);
+        if (rc != SVDB_OK) tx_rollback(tx->db, tx);
+        else tx_finish(tx->db);
+        std::string err;
+        if (!storage_commit(tx->db, err)) {
+            tx->db->last_error = "disk I/O error: " + err;
+            rc = SVDB_ERR;
+        }
+    }
     delete tx;
-    return SVDB_OK;
+    return rc;
 }
 
 svdb_code_t svdb_rollback(svdb_tx_t *tx) {
     BUG_ON(tx == nullptr);
     if (!tx) return SVDB_ERR;
     if (tx->db) {
-        /* Restore snapshot */
-        tx->db->data          = tx->data_snapshot;
-        tx->db->rowid_counter = tx->rowid_snapshot;
-        tx->db->in_transaction = false;
+        tx_rollback(tx->db, tx);
     }
     delete tx;
     return SVDB_OK;
 }
 
+int svdb_in_transaction(svdb_db_t *db) {
+    if (!db) return 0;
+    std::lock_guard<std::mutex> lk(db->mu);
+    return db->in_transaction ? 1 : 0;
+}
+
 svdb_code_t svdb_savepoint(svdb_tx_t *tx, const char *name) {
     BUG_ON(tx == nullptr);
     BUG_ON(name == nullptr);
@@ -3316,6 +5694,8 @@ svdb_code_t svdb_rollback_to(svdb_tx_t *tx, const char *name) {
             if (i < (int)tx->sp_data.size()) {
                 tx->db->data          = tx->sp_data[i];
                 tx->db->rowid_counter = tx->sp_rowid[i];
+                ++tx->db->data_gen;
+                storage_note_kept(tx->db);
                 /* Remove all savepoints after this one */
                 tx->savepoints.resize(i + 1);
                 tx->sp_data.resize(i + 1);
@@ -3336,13 +5716,17 @@ svdb_code_t svdb_tables(svdb_db_t *db, svdb_rows_t **rows) {
     if (!db || !rows) return SVDB_ERR;
     svdb_rows_t *r = new (std::nothrow) svdb_rows_t();
     if (!r) return SVDB_NOMEM;
-    r->col_names = {"name", "sql"};
+    r->col_names = {"name", "sql", "type"};
     for (const auto &kv : db->schema) {
         SvdbVal sv_name; sv_name.type = SVDB_TYPE_TEXT; sv_name.sval = kv.first;
         SvdbVal sv_sql;
+        SvdbVal sv_type; sv_type.type = SVDB_TYPE_TEXT; sv_type.sval = "table";
         auto it = db->create_sql.find(kv.first);
-        if (it != db->create_sql.end()) { sv_sql.type = SVDB_TYPE_TEXT; sv_sql.sval = it->second; }
-        r->rows.push_back({sv_name, sv_sql});
+        if (it != db->create_sql.end()) {
+            sv_sql.type = SVDB_TYPE_TEXT; sv_sql.sval = it->second;
+            if (str_upper(str_trim(it->second)).compare(0, 11, "CREATE VIEW") == 0) sv_type.sval = "view";
+        }
+        r->rows.push_back({sv_name, sv_sql, sv_type});
     }
     *rows = r;
     return SVDB_OK;
@@ -3395,6 +5779,41 @@ svdb_code_t svdb_indexes(svdb_db_t *db, const char *table, svdb_rows_t **rows) {
     return SVDB_OK;
 }
 
+svdb_code_t svdb_table_scan(svdb_db_t *db, const char *table, int64_t start, int max,
+                            svdb_rows_t **rows, uint64_t *gen) {
+    BUG_ON(db == nullptr);
+    BUG_ON(table == nullptr);
+    BUG_ON(rows == nullptr);
+    if (!db || !table || !rows || start < 0 || max < 0) return SVDB_ERR;
+    std::lock_guard<std::mutex> lk(db->mu);
+    db->last_error.clear();
+    std::string tname = resolve_table_name(db, table);
+    if (tname.empty() || !db->col_order.count(tname)) {
+        db->last_error = std::string("no such table: ") + table;
+        return SVDB_ERR;
+    }
+    svdb_rows_t *r = new (std::nothrow) svdb_rows_t();
+    if (!r) return SVDB_NOMEM;
+    const auto &cols = db->col_order.at(tname);
+    r->col_names = cols;
+    auto dit = db->data.find(tname);
+    if (dit != db->data.end()) {
+        const auto &data = dit->second;
+        size_t end = std::min(data.size(), (size_t)start + (size_t)max);
+        for (size_t i = (size_t)start; i < end; ++i) {
+            std::vector<SvdbVal> vals(cols.size());
+            for (size_t c = 0; c < cols.size(); ++c) {
+                auto it = data[i].find(cols[c]);
+                if (it != data[i].end()) vals[c] = it->second;
+            }
+            r->rows.push_back(std::move(vals));
+        }
+    }
+    if (gen) *gen = db->data_gen;
+    *rows = r;
+    return SVDB_OK;
+}
+
 svdb_code_t svdb_backup(svdb_db_t *src, const char *dest_path) {
     BUG_ON(src == nullptr);
     BUG_ON(dest_path == nullptr);
@@ -0,0 +1,1061 @@
+/*
+ * fts5_table.cpp — FTS5 virtual tables (CREATE VIRTUAL TABLE ... USING fts5)
+ *
+ * The rows of an FTS5 table are stored in db->data like those of any table, so
+ * INSERT/UPDATE/DELETE, transactions and MERGE need nothing special. The
+ * inverted index (svdb::FTS5Index) is derived from those rows and brought up to
+ * date before each use; only rows that changed since the last sync are
+ * re-tokenized. Saving the database stores the postings with the rows
+ * (storage.cpp), so opening it loads the index rather than re-deriving it.
+ *
+ * External-content (content=tbl) and contentless (content='') tables keep the
+ * indexed text of each document in those rows too, maintained by INSERTs and
+ * 'delete' commands (usually from triggers on the content table); SELECT reads
+ * their columns from the content table instead.
+ *
+ * MATCH queries support the FTS5 query syntax: implicit AND, AND/OR/NOT,
+ * parentheses, "quoted phrases", phrase concatenation with '+', prefix terms
+ * (term*), initial-token queries (^term), column filters (col: and
+ * {col1 col2}:, '-' to exclude) and NEAR(phrase phrase ..., N).
+ *
+ * Besides the unicode61, ascii and porter tokenizers, tokenize= may name
+ * trigram (substring search), ngram [N] (N-character windows of each word,
+ * for CJK text) or a tokenizer registered with svdb_register_tokenizer().
+ * Registered tokenizers may emit colocated tokens (synonyms) that share the
+ * position of the token before them; phrases and NEAR count positions, so a
+ * synonym matches wherever the word it stands for does.
+ */
+#include "svdb_fts5.h"
+#include "svdb_util.h"
+#include "../IS/vtab_fts5.h"
+#include "../../ext/fts5/fts5.h"
+
+#include <algorithm>
+#include <cmath>
+#include <cstdlib>
+#include <cstring>
+#include <memory>
+#include <unordered_set>
+
+/* ── Table definition ───────────────────────────────────────────── */
+
+static std::string fts5_unquote(const std::string &s) {
+    if (s.size() >= 2 && (s.front() == '\'' || s.front() == '"' || s.front() == '`') && s.back() == s.front())
+        return s.substr(1, s.size() - 2);
+    if (s.size() >= 2 && s.front() == '[' && s.back() == ']')
+        return s.substr(1, s.size() - 2);
+    return s;
+}
+
+/* Check the tokenize= option; a registered tokenizer takes precedence over a
+ * built-in one of the same name */
+static bool fts5_parse_tokenize(svdb_db_t *db, const std::string &spec, Fts5Table &ft,
+                                std::string &err) {
+    std::vector<std::string> words;
+    size_t i = 0;
+    while (i < spec.size()) {
+        size_t j = spec.find_first_of(" \t", i);
+        if (j == std::string::npos) j = spec.size();
+        std::string w = fts5_unquote(spec.substr(i, j - i));
+        if (!w.empty()) words.push_back(w);
+        i = j + 1;
+    }
+    if (words.empty()) {
+        err = "no such tokenizer: ";
+        return false;
+    }
+    std::string name = svdb_str_upper(words[0]);
+    auto it = db->tokenizers.find(name);
+    if (it != db->tokenizers.end()) {
+        ft.custom = it->second;
+    } else if (name == "TRIGRAM") {
+        for (size_t k = 1; k < words.size(); k += 2) {
+            std::string opt = svdb_str_upper(words[k]);
+            if ((opt != "CASE_SENSITIVE" && opt != "REMOVE_DIACRITICS") || k + 1 >= words.size() ||
+                (words[k + 1] != "0" && words[k + 1] != "1")) {
+                err = "error in tokenizer constructor";
+                return false;
+            }
+        }
+    } else if (name == "NGRAM") {
+        if (words.size() > 2 || (words.size() == 2 && (words[1].find_first_not_of("0123456789") != std::string::npos ||
+                                                      words[1].size() > 2 || std::atoi(words[1].c_str()) < 1))) {
+            err = "error in tokenizer constructor";
+            return false;
+        }
+    } else if (name != "UNICODE61" && name != "ASCII" && name != "PORTER") {
+        err = "no such tokenizer: " + words[0];
+        return false;
+    }
+    ft.tokenize = spec;
+    return true;
+}
+
+bool fts5_parse_args(svdb_db_t *db, const std::vector<std::string> &args, Fts5Table &ft,
+                     std::string &err) {
+    for (const auto &raw : args) {
+        std::string arg = svdb_str_trim(raw);
+        size_t eq = arg.find('=');
+        if (eq != std::string::npos && arg.front() != '"' && arg.front() != '`' && arg.front() != '[') {
+            std::string key = svdb_str_upper(svdb_str_trim(arg.substr(0, eq)));
+            std::string val = fts5_unquote(svdb_str_trim(arg.substr(eq + 1)));
+            if (key == "TOKENIZE") {
+                if (!fts5_parse_tokenize(db, svdb_str_trim(val), ft, err)) return false;
+            } else if (key == "PREFIX") {
+                /* Prefix queries scan the term dictionary; no extra index needed */
+            } else if (key == "CONTENT") {
+                ft.content = val;
+                ft.contentless = val.empty();
+            } else if (key == "CONTENT_ROWID") {
+                ft.content_rowid = val;
+            } else {
+                err = "unrecognized option: \"" + svdb_str_trim(arg.substr(0, eq)) + "\"";
+                return false;
+            }
+            continue;
+        }
+        /* Column: name [UNINDEXED] */
+        std::string name = arg, opt;
+        size_t sp = arg.find_last_of(" \t");
+        if (sp != std::string::npos && arg.back() != '"' && arg.back() != '`' && arg.back() != ']') {
+            name = svdb_str_trim(arg.substr(0, sp));
+            opt  = svdb_str_upper(arg.substr(sp + 1));
+        }
+        if (!opt.empty() && opt != "UNINDEXED") {
+            err = "unrecognized column option: " + arg.substr(sp + 1);
+            return false;
+        }
+        name = fts5_unquote(name);
+        std::string nu = svdb_str_upper(name);
+        if (nu == "RANK" || nu == "ROWID") {
+            err = "reserved fts5 column name: " + name;
+            return false;
+        }
+        for (const auto &c : ft.columns) {
+            if (svdb_str_upper(c) == nu) {
+                err = "duplicate column name: " + name;
+                return false;
+            }
+        }
+        ft.columns.push_back(name);
+        ft.unindexed.push_back(!opt.empty());
+    }
+    if (ft.columns.empty()) {
+        err = "fts5: no columns specified";
+        return false;
+    }
+    return true;
+}
+
+/* ── Tokenizing ─────────────────────────────────────────────────── */
+
+extern "C" void svdb_tokens_add(svdb_tokens_t *out, const char *term, int term_len,
+                                int start, int end, int colocated) {
+    if (!out || !term || term_len < 0) return;
+    std::vector<Fts5Token> &toks = out->toks;
+    Fts5Token t;
+    t.term.assign(term, (size_t)term_len);
+    t.start = std::max(0, std::min(start, out->len));
+    t.end   = std::max(t.start, std::min(end, out->len));
+    t.pos   = toks.empty() ? 0 : colocated ? toks.back().pos : toks.back().pos + 1;
+    toks.push_back(std::move(t));
+}
+
+static svdb::FTS5Index::TokenizeFn fts5_index_tokenizer(const Fts5Table &ft);
+
+extern "C" svdb_code_t svdb_register_tokenizer(svdb_db_t *db, const char *name,
+                                               svdb_tokenizer_fn fn, uintptr_t ctx) {
+    if (!db || !name || !fn) return SVDB_ERR;
+    std::lock_guard<std::mutex> lk(db->mu);
+    std::string key = svdb_str_upper(name);
+    if (key.empty()) {
+        db->last_error = "tokenizer name is empty";
+        return SVDB_ERR;
+    }
+    db->tokenizers[key] = SvdbTokenizer{fn, ctx};
+    /* Tables already using the name pick up the new tokenizer. An index
+     * loaded from the file was made by a tokenizer of this name and is kept
+     * when the name is first registered; replacing a tokenizer rebuilds. */
+    for (auto &kv : db->fts5) {
+        Fts5Table &ft = kv.second;
+        if (svdb_str_upper(ft.tokenize.substr(0, ft.tokenize.find_first_of(" \t"))) != key) continue;
+        bool keep = ft.loaded && !ft.custom.fn;
+        ft.custom = db->tokenizers[key];
+        if (keep) ft.index->SetTokenizer(fts5_index_tokenizer(ft));
+        else ft.index.reset();
+    }
+    return SVDB_OK;
+}
+
+static std::vector<Fts5Token> fts5_tokens_spec(const std::string &spec, const SvdbTokenizer &custom,
+                                               const std::string &text) {
+    if (custom.fn) {
+        svdb_tokens_t out;
+        out.len = (int)text.size();
+        if (custom.fn(custom.ctx, text.data(), (int)text.size(), &out) != SVDB_OK) return {};
+        return std::move(out.toks);
+    }
+
+    size_t sp = spec.find_first_of(" \t");
+    std::string first = svdb_str_upper(spec.substr(0, sp));
+    std::string rest  = sp == std::string::npos ? "" : svdb_str_upper(spec.substr(sp + 1));
+    svdb_fts5_tokenizer_t *tok;
+    if (first == "TRIGRAM") {
+        bool cs = rest.find("CASE_SENSITIVE 1") != std::string::npos ||
+                  rest.find("CASE_SENSITIVE '1'") != std::string::npos;
+        tok = svdb_fts5_tokenizer_create_ngram(SVDB_FTS5_TOKEN_TRIGRAM, 3, cs);
+    } else if (first == "NGRAM") {
+        int n = rest.empty() ? 2 : std::atoi(fts5_unquote(svdb_str_trim(rest)).c_str());
+        tok = svdb_fts5_tokenizer_create_ngram(SVDB_FTS5_TOKEN_NGRAM, n, 0);
+    } else {
+        svdb_fts5_tokenizer_type_t type = SVDB_FTS5_TOKEN_UNICODE61;
+        if (first == "PORTER")     type = SVDB_FTS5_TOKEN_PORTER;
+        else if (first == "ASCII") type = SVDB_FTS5_TOKEN_ASCII;
+        tok = svdb_fts5_tokenizer_create(type);
+    }
+
+    std::vector<Fts5Token> out;
+    if (!tok) return out;
+    int n = 0;
+    svdb_fts5_token_t *toks = svdb_fts5_tokenize(tok, text.c_str(), &n);
+    for (int i = 0; i < n; ++i) {
+        out.push_back(Fts5Token{toks[i].term ? toks[i].term : "", toks[i].start, toks[i].end, i});
+        svdb_fts5_token_free(&toks[i]);
+    }
+    delete[] toks;
+    svdb_fts5_tokenizer_destroy(tok);
+    return out;
+}
+
+std::vector<Fts5Token> fts5_tokens(const Fts5Table &ft, const std::string &text) {
+    return fts5_tokens_spec(ft.tokenize, ft.custom, text);
+}
+
+bool fts5_tokenizer_ready(const Fts5Table &ft) {
+    if (ft.custom.fn) return true;
+    std::string name = svdb_str_upper(ft.tokenize.substr(0, ft.tokenize.find_first_of(" \t")));
+    return name == "UNICODE61" || name == "ASCII" || name == "PORTER" || name == "TRIGRAM" ||
+           name == "NGRAM";
+}
+
+static svdb::FTS5Index::TokenizeFn fts5_index_tokenizer(const Fts5Table &ft) {
+    std::string spec = ft.tokenize;
+    SvdbTokenizer custom = ft.custom;
+    return [spec, custom](const std::string &text) {
+        std::vector<svdb::FTS5Index::Token> toks;
+        for (auto &t : fts5_tokens_spec(spec, custom, text))
+            toks.push_back(svdb::FTS5Index::Token{std::move(t.term), t.pos});
+        return toks;
+    };
+}
+
+std::shared_ptr<svdb::FTS5Index> fts5_new_index(const Fts5Table &ft) {
+    auto index = std::make_shared<svdb::FTS5Index>((int)ft.columns.size());
+    index->SetTokenizer(fts5_index_tokenizer(ft));
+    return index;
+}
+
+/* ── Index maintenance ──────────────────────────────────────────── */
+
+static std::string fts5_val_text(const SvdbVal &v) {
+    switch (v.type) {
+    case SVDB_TYPE_INT:  return std::to_string(v.ival);
+    case SVDB_TYPE_REAL: return svdb_format_real(v.rval);
+    case SVDB_TYPE_NULL: return "";
+    default:             return v.sval;
+    }
+}
+
+std::vector<std::string> fts5_row_values(const Fts5Table &ft, const Row &row) {
+    std::vector<std::string> vals(ft.columns.size());
+    for (size_t c = 0; c < ft.columns.size(); ++c) {
+        if (ft.unindexed[c]) continue;
+        auto it = row.find(ft.columns[c]);
+        if (it != row.end()) vals[c] = fts5_val_text(it->second);
+    }
+    return vals;
+}
+
+void fts5_sync(svdb_db_t *db, const std::string &tname, Fts5Table &ft) {
+    if (ft.index && ft.gen == db->data_gen) return;
+    if (!ft.index) ft.index = fts5_new_index(ft);
+    ft.loaded = false;
+    svdb::FTS5Index &index = *ft.index;
+
+    std::unordered_set<int64_t> live;
+    auto dit = db->data.find(tname);
+    if (dit != db->data.end()) {
+        for (const Row &row : dit->second) {
+            auto rit = row.find(SVDB_ROWID_COLUMN);
+            if (rit == row.end()) continue;
+            int64_t id = rit->second.ival;
+            live.insert(id);
+            std::vector<std::string> vals = fts5_row_values(ft, row);
+            if (index.Contains(id)) {
+                bool same = true;
+                for (size_t c = 0; c < vals.size() && same; ++c)
+                    same = index.GetColumn(id, (int)c) == vals[c];
+                if (same) continue;
+            }
+            index.Insert(id, vals);
+        }
+    }
+    std::vector<int64_t> gone;
+    for (const auto &kv : index.GetDocLengths())
+        if (!live.count(kv.first)) gone.push_back(kv.first);
+    for (int64_t id : gone) index.Delete(id);
+
+    /* Rows may still change before a running statement ends */
+    ft.gen = db->exec_depth > 0 ? 0 : db->data_gen;
+}
+
+/* ── External content and special commands ─────────────────────── */
+
+static bool fts5_is_rowid_name(const std::string &name) {
+    std::string u = svdb_str_upper(name);
+    return u.empty() || u == "ROWID" || u == "OID" || u == "_ROWID_";
+}
+
+/* The value of column name in row, matched case-insensitively */
+static const SvdbVal *fts5_row_get(const Row &row, const std::string &name) {
+    auto it = row.find(name);
+    if (it != row.end()) return &it->second;
+    std::string nu = svdb_str_upper(name);
+    for (const auto &kv : row)
+        if (svdb_str_upper(kv.first) == nu) return &kv.second;
+    return nullptr;
+}
+
+/* The content rowid of a content table row, if it is an integer */
+static bool fts5_content_key(const Fts5Table &ft, const Row &row, int64_t &key) {
+    const SvdbVal *v = fts5_row_get(row, fts5_is_rowid_name(ft.content_rowid)
+                                             ? SVDB_ROWID_COLUMN : ft.content_rowid);
+    if (!v) return false;
+    if (v->type == SVDB_TYPE_INT) { key = v->ival; return true; }
+    if (v->type == SVDB_TYPE_REAL && svdb_real_is_integral(v->rval)) {
+        key = (int64_t)v->rval;
+        return true;
+    }
+    return false;
+}
+
+/* The rows of the content table of ft */
+static const std::vector<Row> *fts5_content_table(svdb_db_t *db, const Fts5Table &ft,
+                                                   std::string &err) {
+    auto it = find_table_case_insensitive(db->data, ft.content);
+    if (it == db->data.end()) {
+        err = "no such table: " + ft.content;
+        return nullptr;
+    }
+    return &it->second;
+}
+
+/* The document an external-content table indexes for a content table row */
+static Row fts5_content_doc(const Fts5Table &ft, const Row &crow, int64_t key) {
+    Row doc;
+    for (const auto &col : ft.columns) {
+        const SvdbVal *v = fts5_row_get(crow, col);
+        doc[col] = v ? *v : SvdbVal{};
+    }
+    doc[SVDB_ROWID_COLUMN] = SvdbVal{SVDB_TYPE_INT, key, 0.0, {}};
+    return doc;
+}
+
+bool fts5_content_rows(svdb_db_t *db, const Fts5Table &ft, std::vector<Row> &rows,
+                       std::string &err) {
+    if (ft.content.empty() && !ft.contentless) return true;
+    std::unordered_map<int64_t, const Row *> by_key;
+    if (!ft.contentless) {
+        const std::vector<Row> *ctab = fts5_content_table(db, ft, err);
+        if (!ctab) return false;
+        for (const Row &crow : *ctab) {
+            int64_t key;
+            if (fts5_content_key(ft, crow, key)) by_key.emplace(key, &crow);
+        }
+    }
+    for (Row &row : rows) {
+        const Row *crow = nullptr;
+        auto rit = row.find(SVDB_ROWID_COLUMN);
+        if (rit != row.end()) {
+            auto cit = by_key.find(rit->second.ival);
+            if (cit != by_key.end()) crow = cit->second;
+        }
+        for (const auto &col : ft.columns) {
+            const SvdbVal *v = crow ? fts5_row_get(*crow, col) : nullptr;
+            row[col] = v ? *v : SvdbVal{};
+        }
+    }
+    return true;
+}
+
+/* A fresh index over the documents of tname, for 'rebuild' and checks */
+static Fts5Table fts5_rebuilt(svdb_db_t *db, const std::string &tname, const Fts5Table &ft) {
+    Fts5Table fresh = ft;
+    fresh.index.reset();
+    fts5_sync(db, tname, fresh);
+    return fresh;
+}
+
+bool fts5_command(svdb_db_t *db, const std::string &tname, Fts5Table &ft,
+                  const std::string &cmd, const SvdbVal &rowid, std::string &err) {
+    std::vector<Row> &docs = db->data[tname];
+    std::string cu = svdb_str_upper(cmd);
+    if (cu == "DELETE" || cu == "DELETE-ALL") {
+        if (ft.content.empty() && !ft.contentless) {
+            err = "'" + cmd + "' may only be used with a contentless or external content fts5 table";
+            return false;
+        }
+        if (cu == "DELETE-ALL") {
+            docs.clear();
+            return true;
+        }
+        if (rowid.type != SVDB_TYPE_INT) {
+            err = "'delete' requires an integer rowid";
+            return false;
+        }
+        for (auto it = docs.begin(); it != docs.end(); ++it) {
+            auto rit = it->find(SVDB_ROWID_COLUMN);
+            if (rit != it->end() && rit->second.ival == rowid.ival) {
+                docs.erase(it);
+                break;
+            }
+        }
+        return true;
+    }
+    if (cu == "REBUILD") {
+        if (ft.contentless) {
+            err = "'rebuild' may not be used with a contentless fts5 table";
+            return false;
+        }
+        if (!ft.content.empty()) {
+            /* Re-read every document from the content table */
+            const std::vector<Row> *ctab = fts5_content_table(db, ft, err);
+            if (!ctab) return false;
+            std::vector<Row> fresh;
+            int64_t max_key = 0;
+            for (const Row &crow : *ctab) {
+                int64_t key;
+                if (!fts5_content_key(ft, crow, key)) continue;
+                fresh.push_back(fts5_content_doc(ft, crow, key));
+                max_key = std::max(max_key, key);
+            }
+            docs = std::move(fresh);
+            db->rowid_counter[tname] = std::max(db->rowid_counter[tname], max_key);
+        }
+        ft.index = fts5_rebuilt(db, tname, ft).index;
+        ft.loaded = false;
+        return true;
+    }
+    if (cu == "OPTIMIZE") {
+        /* Saving writes the postings packed; in memory, shrink the term
+         * tables left sparse by deleted documents */
+        fts5_sync(db, tname, ft);
+        ft.index->Compact();
+        return true;
+    }
+    if (cu == "INTEGRITY-CHECK") {
+        fts5_sync(db, tname, ft);
+        bool ok = ft.index->SameContents(*fts5_rebuilt(db, tname, ft).index);
+        if (ok && !ft.content.empty()) {
+            /* Every document must still match its content table row */
+            const std::vector<Row> *ctab = fts5_content_table(db, ft, err);
+            if (!ctab) return false;
+            std::unordered_map<int64_t, std::vector<std::string>> content;
+            for (const Row &crow : *ctab) {
+                int64_t key;
+                if (fts5_content_key(ft, crow, key))
+                    content[key] = fts5_row_values(ft, fts5_content_doc(ft, crow, key));
+            }
+            ok = content.size() == docs.size();
+            for (size_t i = 0; ok && i < docs.size(); ++i) {
+                auto cit = content.find(docs[i][SVDB_ROWID_COLUMN].ival);
+                ok = cit != content.end() && cit->second == fts5_row_values(ft, docs[i]);
+            }
+        }
+        if (!ok) {
+            err = "database disk image is malformed";
+            return false;
+        }
+        return true;
+    }
+    err = "unknown special query: " + cmd;
+    return false;
+}
+
+/* ── Query parsing ──────────────────────────────────────────────── */
+
+namespace {
+
+enum Fts5TokKind {
+    Q_EOF, Q_WORD, Q_STRING, Q_LP, Q_RP, Q_LCB, Q_RCB, Q_COLON, Q_STAR,
+    Q_PLUS, Q_CARET, Q_MINUS, Q_COMMA, Q_AND, Q_OR, Q_NOT, Q_NEAR
+};
+
+struct Fts5QTok {
+    Fts5TokKind kind = Q_EOF;
+    std::string text;
+};
+
+struct Fts5Node {
+    enum Kind { PHRASE, NEAR, AND, OR, NOT } kind = PHRASE;
+    std::vector<int> phrases;   /* PHRASE: one phrase; NEAR: two or more */
+    int near = 10;
+    std::unique_ptr<Fts5Node> left, right;
+};
+
+static bool fts5_word_char(unsigned char c) {
+    return isalnum(c) || c == '_' || c >= 0x80;
+}
+
+static bool fts5_lex(const std::string &q, std::vector<Fts5QTok> &out, std::string &err) {
+    size_t i = 0;
+    while (i < q.size()) {
+        unsigned char c = (unsigned char)q[i];
+        if (isspace(c)) { ++i; continue; }
+        Fts5QTok t;
+        if (c == '"') {
+            ++i;
+            for (;;) {
+                if (i >= q.size()) { err = "fts5: syntax error near \"\"\""; return false; }
+                if (q[i] == '"') {
+                    if (i + 1 < q.size() && q[i + 1] == '"') { t.text += '"'; i += 2; continue; }
+                    ++i;
+                    break;
+                }
+                t.text += q[i++];
+            }
+            t.kind = Q_STRING;
+        } else if (fts5_word_char(c)) {
+            size_t s = i;
+            while (i < q.size() && fts5_word_char((unsigned char)q[i])) ++i;
+            t.text = q.substr(s, i - s);
+            t.kind = Q_WORD;
+            if (t.text == "AND")      t.kind = Q_AND;
+            else if (t.text == "OR")  t.kind = Q_OR;
+            else if (t.text == "NOT") t.kind = Q_NOT;
+            else if (t.text == "NEAR") {
+                size_t j = i;
+                while (j < q.size() && isspace((unsigned char)q[j])) ++j;
+                if (j < q.size() && q[j] == '(') t.kind = Q_NEAR;
+            }
+        } else {
+            static const char *punct = "(){}:*+^-,";
+            static const Fts5TokKind kinds[] = {
+                Q_LP, Q_RP, Q_LCB, Q_RCB, Q_COLON, Q_STAR, Q_PLUS, Q_CARET, Q_MINUS, Q_COMMA
+            };
+            const char *p = strchr(punct, (char)c);
+            if (!p || !c) {
+                err = std::string("fts5: syntax error near \"") + (char)c + "\"";
+                return false;
+            }
+            t.kind = kinds[p - punct];
+            t.text = std::string(1, (char)c);
+            ++i;
+        }
+        out.push_back(t);
+    }
+    out.push_back(Fts5QTok{});
+    return true;
+}
+
+class Fts5Parser {
+public:
+    Fts5Parser(const Fts5Table &ft, std::vector<Fts5QTok> toks, Fts5Match &m)
+        : ft_(ft), toks_(std::move(toks)), m_(m) {}
+
+    std::unique_ptr<Fts5Node> Parse(const std::vector<bool> &cols) {
+        auto n = ParseOr(cols);
+        if (n && Peek().kind != Q_EOF) return Fail();
+        return n;
+    }
+    const std::string &Error() const { return err_; }
+
+private:
+    const Fts5QTok &Peek(size_t ahead = 0) const {
+        return toks_[std::min(pos_ + ahead, toks_.size() - 1)];
+    }
+    bool Accept(Fts5TokKind k) {
+        if (Peek().kind != k) return false;
+        ++pos_;
+        return true;
+    }
+    std::unique_ptr<Fts5Node> Fail() {
+        if (err_.empty()) {
+            const Fts5QTok &t = Peek();
+            err_ = t.kind == Q_EOF ? "fts5: syntax error near \"\""
+                                   : "fts5: syntax error near \"" + t.text + "\"";
+        }
+        return nullptr;
+    }
+    static std::unique_ptr<Fts5Node> Join(Fts5Node::Kind k, std::unique_ptr<Fts5Node> l,
+                                          std::unique_ptr<Fts5Node> r) {
+        auto n = std::make_unique<Fts5Node>();
+        n->kind = k;
+        n->left = std::move(l);
+        n->right = std::move(r);
+        return n;
+    }
+
+    std::unique_ptr<Fts5Node> ParseOr(const std::vector<bool> &cols) {
+        auto l = ParseAnd(cols);
+        while (l && Accept(Q_OR)) {
+            auto r = ParseAnd(cols);
+            if (!r) return nullptr;
+            l = Join(Fts5Node::OR, std::move(l), std::move(r));
+        }
+        return l;
+    }
+
+    std::unique_ptr<Fts5Node> ParseAnd(const std::vector<bool> &cols) {
+        auto l = ParseNot(cols);
+        while (l) {
+            Fts5TokKind k = Peek().kind;
+            if (k == Q_AND) ++pos_;
+            else if (k != Q_WORD && k != Q_STRING && k != Q_LP && k != Q_LCB &&
+                     k != Q_MINUS && k != Q_CARET && k != Q_NEAR) break;
+            auto r = ParseNot(cols);
+            if (!r) return nullptr;
+            l = Join(Fts5Node::AND, std::move(l), std::move(r));
+        }
+        return l;
+    }
+
+    std::unique_ptr<Fts5Node> ParseNot(const std::vector<bool> &cols) {
+        auto l = ParsePrimary(cols);
+        while (l && Accept(Q_NOT)) {
+            auto r = ParsePrimary(cols);
+            if (!r) return nullptr;
+            l = Join(Fts5Node::NOT, std::move(l), std::move(r));
+        }
+        return l;
+    }
+
+    int ColumnIndex(const std::string &name) {
+        std::string nu = svdb_str_upper(name);
+        for (size_t c = 0; c < ft_.columns.size(); ++c)
+            if (svdb_str_upper(ft_.columns[c]) == nu) return (int)c;
+        err_ = "no such column: " + name;
+        return -1;
+    }
+
+    /* [-] (col | {col ...}) ':' — narrows cols for the primary that follows */
+    bool ParseColumnFilter(std::vector<bool> &cols) {
+        bool exclude = Accept(Q_MINUS);
+        std::vector<bool> named(ft_.columns.size(), false);
+        if (Accept(Q_LCB)) {
+            while (Peek().kind == Q_WORD || Peek().kind == Q_STRING) {
+                int c = ColumnIndex(toks_[pos_++].text);
+                if (c < 0) return false;
+                named[c] = true;
+            }
+            if (!Accept(Q_RCB)) { Fail(); return false; }
+        } else if (Peek().kind == Q_WORD || Peek().kind == Q_STRING) {
+            int c = ColumnIndex(toks_[pos_++].text);
+            if (c < 0) return false;
+            named[c] = true;
+        } else {
+            Fail();
+            return false;
+        }
+        if (!Accept(Q_COLON)) { Fail(); return false; }
+        for (size_t c = 0; c < cols.size(); ++c)
+            cols[c] = cols[c] && (exclude ? !named[c] : named[c]);
+        return true;
+    }
+
+    std::unique_ptr<Fts5Node> ParsePrimary(const std::vector<bool> &cols) {
+        Fts5TokKind k = Peek().kind;
+        if (k == Q_MINUS || k == Q_LCB ||
+            ((k == Q_WORD || k == Q_STRING) && Peek(1).kind == Q_COLON)) {
+            std::vector<bool> narrowed = cols;
+            if (!ParseColumnFilter(narrowed)) return nullptr;
+            return ParsePrimary(narrowed);
+        }
+        if (Accept(Q_LP)) {
+            auto n = ParseOr(cols);
+            if (!n) return nullptr;
+            if (!Accept(Q_RP)) return Fail();
+            return n;
+        }
+        if (Accept(Q_NEAR)) {
+            Accept(Q_LP);
+            auto n = std::make_unique<Fts5Node>();
+            n->kind = Fts5Node::NEAR;
+            while (Peek().kind == Q_WORD || Peek().kind == Q_STRING || Peek().kind == Q_CARET) {
+                int p = ParsePhrase(cols);
+                if (p < 0) return nullptr;
+                n->phrases.push_back(p);
+            }
+            if (Accept(Q_COMMA)) {
+                const Fts5QTok &t = Peek();
+                if (t.kind != Q_WORD || t.text.find_first_not_of("0123456789") != std::string::npos)
+                    return Fail();
+                n->near = atoi(t.text.c_str());
+                ++pos_;
+            }
+            if (n->phrases.empty() || !Accept(Q_RP)) return Fail();
+            if (n->phrases.size() == 1) n->kind = Fts5Node::PHRASE;
+            return n;
+        }
+        int p = ParsePhrase(cols);
+        if (p < 0) return nullptr;
+        auto n = std::make_unique<Fts5Node>();
+        n->phrases.push_back(p);
+        return n;
+    }
+
+    /* [^] (word | "string") [+ (word | "string")]... [*] */
+    int ParsePhrase(const std::vector<bool> &cols) {
+        Fts5Phrase ph;
+        ph.cols = cols;
+        ph.initial = Accept(Q_CARET);
+        do {
+            const Fts5QTok &t = Peek();
+            if (t.kind != Q_WORD && t.kind != Q_STRING) { Fail(); return -1; }
+            /* Synonyms are matched through the colocated tokens of the
+             * documents, so a query needs only the first token at each
+             * position */
+            std::vector<Fts5Token> words = fts5_tokens(ft_, t.text);
+            for (size_t i = 0; i < words.size(); ++i)
+                if (i == 0 || words[i].pos != words[i - 1].pos) ph.terms.push_back(words[i].term);
+            ++pos_;
+        } while (Accept(Q_PLUS));
+        ph.prefix = Accept(Q_STAR);
+        m_.phrases.push_back(ph);
+        return (int)m_.phrases.size() - 1;
+    }
+
+    const Fts5Table &ft_;
+    std::vector<Fts5QTok> toks_;
+    size_t pos_ = 0;
+    Fts5Match &m_;
+    std::string err_;
+};
+
+/* ── Query evaluation ───────────────────────────────────────────── */
+
+using Fts5DocHits = std::unordered_map<int64_t, std::vector<Fts5Hit>>;
+
+class Fts5Eval {
+public:
+    Fts5Eval(const Fts5Table &ft, Fts5Match &m) : ft_(ft), m_(m) {}
+
+    /* Find every instance of every phrase */
+    void FindPhrases() {
+        occ_.assign(m_.phrases.size(), {});
+        m_.freq.assign(m_.phrases.size(), {});
+        for (size_t p = 0; p < m_.phrases.size(); ++p) FindPhrase(p);
+    }
+
+    Fts5DocHits Eval(const Fts5Node &n) {
+        switch (n.kind) {
+        case Fts5Node::PHRASE:
+            return occ_[n.phrases[0]];
+        case Fts5Node::NEAR:
+            return EvalNear(n);
+        case Fts5Node::AND: {
+            Fts5DocHits l = Eval(*n.left), r = Eval(*n.right), out;
+            for (auto &kv : l) {
+                auto it = r.find(kv.first);
+                if (it == r.end()) continue;
+                auto &hits = out[kv.first];
+                hits = std::move(kv.second);
+                hits.insert(hits.end(), it->second.begin(), it->second.end());
+            }
+            return out;
+        }
+        case Fts5Node::OR: {
+            Fts5DocHits l = Eval(*n.left), r = Eval(*n.right);
+            for (auto &kv : r) {
+                auto &hits = l[kv.first];
+                hits.insert(hits.end(), kv.second.begin(), kv.second.end());
+            }
+            return l;
+        }
+        case Fts5Node::NOT: {
+            Fts5DocHits l = Eval(*n.left), r = Eval(*n.right);
+            for (const auto &kv : r) l.erase(kv.first);
+            return l;
+        }
+        }
+        return {};
+    }
+
+private:
+    /* Phrase instances come from the positions in the index; documents are
+     * not tokenized again */
+    void FindPhrase(size_t p) {
+        const Fts5Phrase &ph = m_.phrases[p];
+        if (ph.terms.empty()) return;
+        const svdb::FTS5Index &index = *ft_.index;
+
+        /* Candidates: rows containing every term */
+        std::unordered_set<int64_t> cand;
+        for (size_t i = 0; i < ph.terms.size(); ++i) {
+            bool pre = ph.prefix && i + 1 == ph.terms.size();
+            std::vector<int64_t> ids = pre ? index.SearchPrefix(ph.terms[i]) : index.Search(ph.terms[i]);
+            if (i == 0) {
+                cand.insert(ids.begin(), ids.end());
+            } else {
+                std::unordered_set<int64_t> both;
+                for (int64_t id : ids) if (cand.count(id)) both.insert(id);
+                cand.swap(both);
+            }
+            if (cand.empty()) return;
+        }
+
+        /* The index terms each phrase term stands for: a trailing prefix
+         * term, every term starting with it */
+        size_t len = ph.terms.size();
+        std::vector<std::vector<std::string>> terms(len);
+        for (size_t k = 0; k < len; ++k) {
+            if (ph.prefix && k + 1 == len) terms[k] = index.TermsWithPrefix(ph.terms[k]);
+            else terms[k].push_back(ph.terms[k]);
+        }
+        using Hit = svdb::FTS5Index::Hit;
+        std::vector<std::vector<Hit>> at(len);
+        for (int64_t id : cand) {
+            /* The positions of each term; synonyms may share one */
+            for (size_t k = 0; k < len; ++k) {
+                at[k].clear();
+                for (const auto &term : terms[k])
+                    if (const std::vector<Hit> *hits = index.Hits(term, id))
+                        at[k].insert(at[k].end(), hits->begin(), hits->end());
+                std::sort(at[k].begin(), at[k].end());
+                at[k].erase(std::unique(at[k].begin(), at[k].end()), at[k].end());
+            }
+            for (const Hit &h : at[0]) {
+                if (!ph.cols[h.col] || (ph.initial && h.pos > 0)) continue;
+                bool ok = true;
+                for (size_t k = 1; k < len && ok; ++k)
+                    ok = std::binary_search(at[k].begin(), at[k].end(), Hit{h.col, h.pos + (int)k});
+                if (!ok) continue;
+                occ_[p][id].push_back(Fts5Hit{h.col, h.pos, h.pos + (int)len - 1});
+                auto &f = m_.freq[p][id];
+                if (f.empty()) f.assign(ft_.columns.size(), 0);
+                ++f[h.col];
+            }
+        }
+    }
+
+    /* NEAR: one instance of each phrase in the same column, with at most
+     * n.near tokens between them in total */
+    Fts5DocHits EvalNear(const Fts5Node &n) {
+        Fts5DocHits out;
+        const Fts5DocHits &first = occ_[n.phrases[0]];
+        for (const auto &kv : first) {
+            int64_t id = kv.first;
+            bool in_all = true;
+            for (int p : n.phrases) in_all = in_all && occ_[p].count(id);
+            if (!in_all) continue;
+            for (size_t c = 0; c < ft_.columns.size(); ++c) {
+                std::vector<std::vector<Fts5Hit>> per(n.phrases.size());
+                for (size_t i = 0; i < n.phrases.size(); ++i)
+                    for (const auto &h : occ_[n.phrases[i]].at(id))
+                        if (h.col == (int)c) per[i].push_back(h);
+                std::vector<Fts5Hit> pick;
+                size_t budget = 100000;
+                NearCombos(per, 0, pick, n.near, out[id], budget);
+            }
+            if (out[id].empty()) out.erase(id);
+        }
+        return out;
+    }
+
+    static void NearCombos(const std::vector<std::vector<Fts5Hit>> &per, size_t i,
+                           std::vector<Fts5Hit> &pick, int near,
+                           std::vector<Fts5Hit> &hits, size_t &budget) {
+        if (budget == 0) return;
+        --budget;
+        if (i == per.size()) {
+            int lo = pick[0].first, hi = pick[0].last, len = 0;
+            for (const auto &h : pick) {
+                lo = std::min(lo, h.first);
+                hi = std::max(hi, h.last);
+                len += h.last - h.first + 1;
+            }
+            if ((hi - lo + 1) - len <= near) hits.insert(hits.end(), pick.begin(), pick.end());
+            return;
+        }
+        for (const auto &h : per[i]) {
+            pick.push_back(h);
+            NearCombos(per, i + 1, pick, near, hits, budget);
+            pick.pop_back();
+        }
+    }
+
+    const Fts5Table &ft_;
+    Fts5Match &m_;
+    std::vector<Fts5DocHits> occ_;
+};
+
+} /* namespace */
+
+bool fts5_match(const Fts5Table &ft, const std::string &col, const std::string &query,
+                Fts5Match &out, std::string &err) {
+    std::vector<bool> cols(ft.columns.size());
+    for (size_t c = 0; c < cols.size(); ++c) cols[c] = !ft.unindexed[c];
+    if (!col.empty()) {
+        for (size_t c = 0; c < cols.size(); ++c)
+            cols[c] = cols[c] && svdb_str_upper(ft.columns[c]) == svdb_str_upper(col);
+    }
+
+    std::vector<Fts5QTok> toks;
+    if (!fts5_lex(query, toks, err)) return false;
+    out = Fts5Match{};
+    Fts5Parser parser(ft, std::move(toks), out);
+    std::unique_ptr<Fts5Node> root = parser.Parse(cols);
+    if (!root) {
+        err = parser.Error();
+        return false;
+    }
+    if (!ft.index) return true;
+    Fts5Eval ev(ft, out);
+    ev.FindPhrases();
+    out.docs = ev.Eval(*root);
+    return true;
+}
+
+/* ── Ranking and auxiliary functions ────────────────────────────── */
+
+double fts5_bm25(const Fts5Table &ft, const Fts5Match &m, int64_t rowid,
+                 const std::vector<double> &weights) {
+    if (!ft.index || !m.docs.count(rowid)) return 0.0;
+    const double k1 = 1.2, b = 0.75;
+    const svdb::FTS5Index &index = *ft.index;
+    double n = index.DocCount();
+    double avgdl = index.AvgDocLength();
+    double dl = index.DocLength(rowid);
+    if (avgdl <= 0) avgdl = 1;
+
+    double score = 0.0;
+    for (size_t p = 0; p < m.freq.size(); ++p) {
+        double nq = (double)m.freq[p].size();
+        double idf = std::log((n - nq + 0.5) / (nq + 0.5));
+        if (idf <= 0.0) idf = 1e-6;
+        auto it = m.freq[p].find(rowid);
+        if (it == m.freq[p].end()) continue;
+        double f = 0.0;
+        for (size_t c = 0; c < it->second.size(); ++c)
+            f += (c < weights.size() ? weights[c] : 1.0) * it->second[c];
+        score += idf * (f * (k1 + 1.0)) / (f + k1 * (1.0 - b + b * dl / avgdl));
+    }
+    return -score;
+}
+
+/* Matched token spans of column col, sorted and with overlaps merged */
+static std::vector<std::pair<int, int>> fts5_spans(const Fts5Match &m, int64_t rowid, int col) {
+    std::vector<std::pair<int, int>> spans;
+    auto it = m.docs.find(rowid);
+    if (it == m.docs.end()) return spans;
+    for (const auto &h : it->second)
+        if (h.col == col) spans.emplace_back(h.first, h.last);
+    std::sort(spans.begin(), spans.end());
+    std::vector<std::pair<int, int>> merged;
+    for (const auto &s : spans) {
+        if (!merged.empty() && s.first <= merged.back().second)
+            merged.back().second = std::max(merged.back().second, s.second);
+        else
+            merged.push_back(s);
+    }
+    return merged;
+}
+
+/* The byte range of each token position of toks */
+static std::vector<std::pair<size_t, size_t>> fts5_ranges(const std::vector<Fts5Token> &toks) {
+    std::vector<std::pair<size_t, size_t>> at;
+    for (const auto &t : toks) {
+        if ((size_t)t.pos >= at.size()) at.resize((size_t)t.pos + 1, {(size_t)t.start, (size_t)t.end});
+        at[t.pos].first  = std::min(at[t.pos].first, (size_t)t.start);
+        at[t.pos].second = std::max(at[t.pos].second, (size_t)t.end);
+    }
+    return at;
+}
+
+/* text[from, to) with the tokens of each span wrapped in open/close. Spans
+ * whose text overlaps (as trigrams do) are marked as one. */
+static std::string fts5_mark(const std::string &text, const std::vector<std::pair<size_t, size_t>> &at,
+                             const std::vector<std::pair<int, int>> &spans,
+                             size_t from, size_t to,
+                             const std::string &open, const std::string &close) {
+    std::vector<std::pair<size_t, size_t>> bytes;
+    for (const auto &s : spans) {
+        size_t a = at[s.first].first, z = at[s.second].second;
+        if (a < from || z > to) continue;
+        if (!bytes.empty() && a <= bytes.back().second)
+            bytes.back().second = std::max(bytes.back().second, z);
+        else
+            bytes.emplace_back(a, z);
+    }
+    std::string out;
+    size_t pos = from;
+    for (const auto &b : bytes) {
+        if (b.first < pos) continue;
+        out += text.substr(pos, b.first - pos) + open + text.substr(b.first, b.second - b.first) + close;
+        pos = b.second;
+    }
+    return out + text.substr(pos, to - pos);
+}
+
+/* Text of column col of row as the index sees it */
+static std::string fts5_col_text(const Fts5Table &ft, const Row &row, int col) {
+    auto it = row.find(ft.columns[col]);
+    return it != row.end() ? fts5_val_text(it->second) : std::string();
+}
+
+std::string fts5_highlight(const Fts5Table &ft, const Fts5Match &m, int64_t rowid,
+                           int col, const Row &row,
+                           const std::string &open, const std::string &close) {
+    if (col < 0 || col >= (int)ft.columns.size()) return "";
+    std::string text = fts5_col_text(ft, row, col);
+    if (ft.unindexed[col]) return text;
+    std::vector<std::pair<size_t, size_t>> at = fts5_ranges(fts5_tokens(ft, text));
+    return fts5_mark(text, at, fts5_spans(m, rowid, col), 0, text.size(), open, close);
+}
+
+std::string fts5_snippet(const Fts5Table &ft, const Fts5Match &m, int64_t rowid,
+                         int col, const Row &row,
+                         const std::string &open, const std::string &close,
+                         const std::string &ellipsis, int ntokens) {
+    if (col < 0 || col >= (int)ft.columns.size()) {
+        /* The column with the most matches */
+        col = 0;
+        size_t best = 0;
+        for (size_t c = 0; c < ft.columns.size(); ++c) {
+            size_t n = fts5_spans(m, rowid, (int)c).size();
+            if (n > best) { best = n; col = (int)c; }
+        }
+    }
+    std::string text = fts5_col_text(ft, row, col);
+    std::vector<std::pair<size_t, size_t>> at;
+    if (!ft.unindexed[col]) at = fts5_ranges(fts5_tokens(ft, text));
+    if (at.empty()) return text;
+    std::vector<std::pair<int, int>> spans = fts5_spans(m, rowid, col);
+
+    int n = (int)at.size();
+    int win = std::max(1, std::min(ntokens, 64));
+    int first = 0;
+    if (n > win) {
+        /* Start a little before a match, preferring the window that holds the
+         * most matches */
+        int best = -1;
+        for (const auto &s : spans) {
+            int start = std::max(0, std::min(s.first - 2, n - win));
+            int count = 0;
+            for (const auto &t : spans)
+                if (t.first >= start && t.second < start + win) ++count;
+            if (count > best) { best = count; first = start; }
+        }
+    }
+    int last = std::min(n, first + win) - 1;
+
+    std::vector<std::pair<int, int>> inside;
+    for (const auto &s : spans) {
+        int a = std::max(s.first, first), z = std::min(s.second, last);
+        if (a <= z) inside.emplace_back(a, z);
+    }
+    std::string out = fts5_mark(text, at, inside, at[first].first, at[last].second, open, close);
+    if (first > 0) out = ellipsis + out;
+    if (last < n - 1) out += ellipsis;
+    return out;
+}
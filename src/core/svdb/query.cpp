@@ -14,16 +14,26 @@
  *   - CAST(expr AS type)
  *   - DISTINCT
  *   - JSON functions (when SVDB_EXT_JSON is enabled): json, json_array, json_object,
- *     json_extract, json_type, json_length, json_valid, json_quote, json_remove, json_set
+ *     json_extract, json_type, json_length, json_valid, json_quote, json_remove, json_set,
+ *     and jsonb, jsonb_extract, jsonb_set, ... returning JSONB blobs (accepted by all)
+ *   - SQL/JSON (sqljson.cpp): json_value, json_query, json_exists, JSON_TABLE and
+ *     the -> / ->> operators
  */
 #include "svdb.h"
 #include "svdb_types.h"
 #include "svdb_util.h"
+#include "svdb_fts5.h"
+#include "svdb_tablefn.h"
 #include "../SF/svdb_assert.h"
 #include "QP/parser.h"
 
 #ifdef SVDB_EXT_JSON
 #include "../../ext/json/json.h"
+#include "svdb_sqljson.h"
+#include "svdb_storage.h"
+#include "svdb_vacuum.h"
+#include "svdb_describe.h"
+#include "svdb_columnar.h"
 #endif
 
 #include <cctype>
@@ -303,6 +313,98 @@ static std::vector<std::string> qry_split_returning_exprs(const std::string &ret
     return exprs;
 }
 
+#ifdef SVDB_EXT_JSON
+/* The JSON text of a JSON function argument; JSONB blobs are converted back
+ * to text */
+static std::string qry_json_arg(const SvdbVal &v) {
+    if (v.type == SVDB_TYPE_BLOB) {
+        char *text = svdb_jsonb_to_text((const unsigned char *)v.sval.data(), v.sval.size());
+        if (text) {
+            std::string out(text);
+            svdb_json_free(text);
+            return out;
+        }
+    }
+    return val_to_str(v);
+}
+
+/* JSONB of a JSON function result: JSONB blobs as they are, text encoded.
+ * NULL when the value is not JSON. */
+static SvdbVal qry_jsonb_val(const SvdbVal &v) {
+    if (v.type == SVDB_TYPE_NULL) return SvdbVal{};
+    if (v.type == SVDB_TYPE_BLOB &&
+        svdb_jsonb_valid((const unsigned char *)v.sval.data(), v.sval.size())) return v;
+    std::string text = v.type == SVDB_TYPE_TEXT ? v.sval : val_to_str(v);
+    size_t n = 0;
+    unsigned char *blob = svdb_jsonb_from_text(text.c_str(), &n);
+    if (!blob) return SvdbVal{};
+    SvdbVal out; out.type = SVDB_TYPE_BLOB;
+    out.sval.assign((const char *)blob, n);
+    svdb_json_free((char *)blob);
+    return out;
+}
+
+/* The JSONB element a SQL value is stored as by jsonb_set and friends:
+ * numbers and NULL as JSON, JSONB blobs as they are, text as a string */
+static std::string qry_jsonb_elem(const SvdbVal &v) {
+    std::string text;
+    switch (v.type) {
+        case SVDB_TYPE_NULL: return std::string(1, '\0');
+        case SVDB_TYPE_INT: case SVDB_TYPE_REAL: text = val_to_str(v); break;
+        case SVDB_TYPE_BLOB:
+            if (svdb_jsonb_valid((const unsigned char *)v.sval.data(), v.sval.size())) return v.sval;
+            /* fall through */
+        default: {
+            char *q = svdb_json_quote(v.sval.c_str());
+            if (!q) return std::string(1, '\0');
+            text = q;
+            svdb_json_free(q);
+        }
+    }
+    size_t n = 0;
+    unsigned char *blob = svdb_jsonb_from_text(text.c_str(), &n);
+    if (!blob) return std::string(1, '\0');
+    std::string out((const char *)blob, n);
+    svdb_json_free((char *)blob);
+    return out;
+}
+
+/* The element of JSONB doc at path as a blob; false if there is none */
+static bool qry_jsonb_locate(const SvdbVal &doc, const std::string &path, std::string &elem) {
+    size_t off = 0, n = 0;
+    const unsigned char *b = (const unsigned char *)doc.sval.data();
+    if (svdb_jsonb_locate(b, doc.sval.size(), path.c_str(), &off, &n) != 1) return false;
+    elem.assign((const char *)b + off, n);
+    return true;
+}
+
+/* json_extract(doc, path) as svdb_json_extract() returns it: the text of a
+ * scalar or the JSON of a container. A JSONB doc is walked in its binary form,
+ * so only the extracted value is converted. */
+static bool qry_json_extract_str(const SvdbVal &doc, const std::string &path, std::string &out) {
+    std::string text;
+    if (doc.type == SVDB_TYPE_BLOB) {
+        std::string elem;
+        if (!qry_jsonb_locate(doc, path, elem)) return false;
+        char *t = svdb_jsonb_to_text((const unsigned char *)elem.data(), elem.size());
+        if (!t) return false;
+        text = t;
+        svdb_json_free(t);
+        char *r = svdb_json_extract(text.c_str(), "$");
+        if (!r) return false;
+        out = r;
+        svdb_json_free(r);
+        return true;
+    }
+    text = val_to_str(doc);
+    char *r = svdb_json_extract(text.c_str(), path.c_str());
+    if (!r) return false;
+    out = r;
+    svdb_json_free(r);
+    return true;
+}
+#endif /* SVDB_EXT_JSON */
+
 /* Build a result set by evaluating returning_exprs against each row in rows_in.
  * For RETURNING *, expands to all columns in col_order. */
 static svdb_rows_t *qry_build_returning_result(
@@ -339,6 +441,193 @@ static thread_local const Row *g_outer_row = nullptr;
 static thread_local const std::vector<std::string> *g_outer_col_order = nullptr;
 /* Thread-local eval error: set by eval_expr for fatal errors like unknown function */
 static thread_local std::string g_eval_error;
+/* Thread-local tables of the statement whose WHERE is evaluated */
+static thread_local const SvdbWhereTables *g_where_tables = nullptr;
+
+const SvdbWhereTables *svdb_set_where_tables(const SvdbWhereTables *tables) {
+    const SvdbWhereTables *prev = g_where_tables;
+    g_where_tables = tables;
+    return prev;
+}
+
+/* FTS5 table read by the current single-table SELECT: MATCH, rank and the
+ * auxiliary functions bm25(), highlight() and snippet() consult its index */
+struct FtsScan {
+    std::string tname, alias;
+    Fts5Table *ft = nullptr;
+    std::map<std::string, Fts5Match> matches; /* column + '\n' + query -> result */
+    const Fts5Match *last = nullptr;          /* most recently evaluated MATCH */
+};
+static thread_local FtsScan *g_fts_scan = nullptr;
+
+/* Installs the FtsScan (or none) of one SELECT, restoring the enclosing one */
+struct FtsScanScope {
+    FtsScan *prev;
+    explicit FtsScanScope(FtsScan *s) : prev(g_fts_scan) { g_fts_scan = s; }
+    ~FtsScanScope() { g_fts_scan = prev; }
+};
+
+/* If lhs, the left side of MATCH, names the scanned FTS5 table or one of its
+ * columns, set col to that column ("" for the whole table) and return true */
+static bool qry_fts_target(const std::string &lhs, std::string &col) {
+    if (!g_fts_scan) return false;
+    auto unquote = [](std::string s) {
+        s = qry_trim(s);
+        if (s.size() >= 2 && (s.front() == '"' || s.front() == '`')) s = s.substr(1, s.size() - 2);
+        return s;
+    };
+    auto is_table = [](const std::string &s) {
+        std::string u = qry_upper(s);
+        return u == qry_upper(g_fts_scan->tname) ||
+               (!g_fts_scan->alias.empty() && u == qry_upper(g_fts_scan->alias));
+    };
+    std::string name = unquote(lhs), qual;
+    size_t dot = name.find('.');
+    if (dot != std::string::npos) {
+        qual = unquote(name.substr(0, dot));
+        name = unquote(name.substr(dot + 1));
+        if (!is_table(qual)) return false;
+    } else if (is_table(name)) {
+        col.clear();
+        return true;
+    }
+    for (const auto &cn : g_fts_scan->ft->columns) {
+        if (qry_upper(cn) == qry_upper(name)) { col = cn; return true; }
+    }
+    return false;
+}
+
+/* Run a MATCH query on the scanned table, reusing earlier results. Returns
+ * nullptr on a query syntax error, which is left in g_eval_error. */
+static const Fts5Match *qry_fts_match(const std::string &col, const std::string &query) {
+    FtsScan &fs = *g_fts_scan;
+    std::string key = col + '\n' + query;
+    auto it = fs.matches.find(key);
+    if (it == fs.matches.end()) {
+        Fts5Match m;
+        std::string err;
+        if (!fts5_match(*fs.ft, col, query, m, err)) {
+            g_eval_error = err;
+            return nullptr;
+        }
+        it = fs.matches.emplace(key, std::move(m)).first;
+    }
+    fs.last = &it->second;
+    return &it->second;
+}
+
+/* Implemented in exec.cpp */
+std::vector<std::pair<size_t, size_t>> svdb_fk_violations(svdb_db_t *db, const std::string &tname);
+svdb_code_t svdb_merge_internal(svdb_db_t *db, const std::string &sql, svdb_result_t *res,
+                                std::vector<Row> *changed, std::string *target_out);
+bool svdb_write_refused(svdb_db_t *db, const std::string &sql);
+
+/* Date/time values for date(), time(), datetime(), julianday(), unixepoch()
+ * and strftime(). All of them work in UTC. */
+
+/* Carry out-of-range fields of tm (e.g. minute 75) and fill wday/yday */
+static void dt_normalize(std::tm &tm) {
+    std::time_t t = timegm(&tm);
+    gmtime_r(&t, &tm);
+}
+
+static bool dt_is_number(const std::string &s, double &out) {
+    const char *p = s.c_str();
+    char *end = nullptr;
+    out = strtod(p, &end);
+    if (end == p) return false;
+    while (*end && isspace((unsigned char)*end)) ++end;
+    return *end == '\0';
+}
+
+static void dt_from_unix(double secs, std::tm &out) {
+    std::time_t t = (std::time_t)std::floor(secs);
+    gmtime_r(&t, &out);
+}
+
+/* Parse s as [YYYY-MM-DD][( |T)HH:MM[:SS[.fff]]][Z|(+|-)HH[:]MM], in UTC once
+ * any zone offset is applied, or as a julian day number. A time alone falls
+ * on 2000-01-01, as in SQLite. */
+static bool dt_parse(const std::string &s, std::tm &out) {
+    memset(&out, 0, sizeof(out));
+    double jd;
+    if (dt_is_number(s, jd)) {
+        dt_from_unix((jd - 2440587.5) * 86400.0, out);
+        return true;
+    }
+    const char *p = s.c_str();
+    while (isspace((unsigned char)*p)) ++p;
+    int y = 2000, mo = 1, d = 1, h = 0, mi = 0, n = 0;
+    double sec = 0;
+    bool has_date = false, has_time = false;
+    if (sscanf(p, "%d-%d-%d%n", &y, &mo, &d, &n) == 3) {
+        has_date = true;
+        p += n;
+        if (*p == ' ' || *p == 'T' || *p == 't') {
+            const char *q = p + 1;
+            while (*q == ' ') ++q;
+            if (isdigit((unsigned char)*q)) p = q;
+        }
+    }
+    if (sscanf(p, "%d:%d%n", &h, &mi, &n) == 2) {
+        has_time = true;
+        p += n;
+        if (*p == ':' && sscanf(p + 1, "%lf%n", &sec, &n) == 1) p += 1 + n;
+    }
+    if (!has_date && !has_time) return false;
+    int offset = 0; /* minutes east of UTC */
+    while (*p == ' ') ++p;
+    if (has_time && (*p == 'Z' || *p == 'z')) {
+        ++p;
+    } else if (has_time && (*p == '+' || *p == '-')) {
+        int sign = *p == '-' ? -1 : 1, oh = 0, om = 0;
+        if (sscanf(p + 1, "%2d:%2d", &oh, &om) == 2 || sscanf(p + 1, "%2d%2d", &oh, &om) >= 1)
+            offset = sign * (oh * 60 + om);
+    }
+    out.tm_year = y - 1900;
+    out.tm_mon = mo - 1;
+    out.tm_mday = d;
+    out.tm_hour = h;
+    out.tm_min = mi - offset;
+    out.tm_sec = (int)sec;
+    dt_normalize(out);
+    return true;
+}
+
+/* Split a date function's arguments at top-level commas into the base
+ * value and its modifiers */
+static void dt_split_args(const std::string &args, std::string &base, std::vector<std::string> &mods) {
+    base.clear(); mods.clear();
+    int dp = 0; bool ins = false; size_t start = 0;
+    for (size_t i = 0; i <= args.size(); ++i) {
+        char c = i < args.size() ? args[i] : ',';
+        if (c == '\'') { ins = !ins; continue; }
+        if (ins) continue;
+        if (c == '(') ++dp;
+        else if (c == ')') --dp;
+        else if (c == ',' && dp == 0) {
+            std::string tok = qry_trim(args.substr(start, i - start));
+            if (base.empty()) base = tok;
+            else mods.push_back(tok);
+            start = i + 1;
+        }
+    }
+}
+
+/* The moment a date function's first argument names: with a 'unixepoch'
+ * modifier a number is Unix seconds, otherwise see dt_parse */
+static bool dt_base(const std::string &s, const std::vector<std::string> &mods, std::tm &out) {
+    double secs;
+    for (auto &m : mods) {
+        std::string mu = qry_upper(qry_trim(m));
+        if (mu == "'UNIXEPOCH'" && dt_is_number(s, secs)) {
+            memset(&out, 0, sizeof(out));
+            dt_from_unix(secs, out);
+            return true;
+        }
+    }
+    return dt_parse(s, out);
+}
 
 /* Forward declaration of svdb_query_internal (defined later) */
 svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql, svdb_rows_t **rows_out);
@@ -505,8 +794,10 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 if (c2 >= 'A' && c2 <= 'F') return (unsigned char)(c2 - 'A' + 10);
                 return 0;
             };
+            std::string bytes;
             for (size_t i = 0; i + 1 < hex.size(); i += 2)
-                v.sval += (char)((fh(hex[i]) << 4) | fh(hex[i + 1]));
+                bytes += (char)((fh(hex[i]) << 4) | fh(hex[i + 1]));
+            v.sval = bytes;
         }
         return v;
     }
@@ -554,6 +845,54 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
         return false;
     };
 
+    /* FTS5: rank and the auxiliary functions of the scanned table */
+    if (g_fts_scan) {
+        const FtsScan &fs = *g_fts_scan;
+        std::string eu = qry_upper(e);
+        auto rid = row.find(SVDB_ROWID_COLUMN);
+        bool is_rank = eu == "RANK" || eu == qry_upper(fs.tname) + ".RANK" ||
+                       (!fs.alias.empty() && eu == qry_upper(fs.alias) + ".RANK");
+        if (is_rank || (eu.compare(0, 5, "BM25(") == 0 && fn_paren_ok(5-1))) {
+            if (!fs.last || rid == row.end()) return SvdbVal{};
+            std::vector<double> weights;
+            if (!is_rank) {
+                std::vector<std::string> args = qry_split_returning_exprs(e.substr(5, e.size()-6));
+                for (size_t i = 1; i < args.size(); ++i)
+                    weights.push_back(val_to_dbl(eval_expr(args[i], row, col_order)));
+            }
+            SvdbVal v; v.type = SVDB_TYPE_REAL;
+            v.rval = fts5_bm25(*fs.ft, *fs.last, rid->second.ival, weights);
+            return v;
+        }
+        bool is_hl = eu.compare(0, 10, "HIGHLIGHT(") == 0 && fn_paren_ok(10-1);
+        if (is_hl || (eu.compare(0, 8, "SNIPPET(") == 0 && fn_paren_ok(8-1))) {
+            size_t lp = e.find('(');
+            std::vector<std::string> args = qry_split_returning_exprs(e.substr(lp + 1, e.size() - lp - 2));
+            if (args.size() != (is_hl ? 4u : 6u)) {
+                g_eval_error = std::string("wrong number of arguments to function ") +
+                               (is_hl ? "highlight()" : "snippet()");
+                return SvdbVal{};
+            }
+            if (!fs.last || rid == row.end()) return SvdbVal{};
+            std::vector<SvdbVal> a;
+            for (size_t i = 1; i < args.size(); ++i) a.push_back(eval_expr(args[i], row, col_order));
+            int col = (int)val_to_i64(a[0]);
+            SvdbVal v; v.type = SVDB_TYPE_TEXT;
+            if (is_hl) {
+                if (col < 0 || col >= (int)fs.ft->columns.size()) return SvdbVal{};
+                auto cit = row.find(fs.ft->columns[col]);
+                if (cit == row.end() || cit->second.type == SVDB_TYPE_NULL) return SvdbVal{};
+                v.sval = fts5_highlight(*fs.ft, *fs.last, rid->second.ival, col, row,
+                                        val_to_str(a[1]), val_to_str(a[2]));
+            } else {
+                v.sval = fts5_snippet(*fs.ft, *fs.last, rid->second.ival, col, row,
+                                      val_to_str(a[1]), val_to_str(a[2]), val_to_str(a[3]),
+                                      (int)val_to_i64(a[4]));
+            }
+            return v;
+        }
+    }
+
     /* COALESCE(a, b, ...) */
     {
         std::string eu = qry_upper(e);
@@ -709,20 +1048,32 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
         }
         /* JSON functions (SVDB_EXT_JSON) */
 #ifdef SVDB_EXT_JSON
+        /* SQL/JSON: json_value, json_query, json_exists (doc, path [clauses]) */
+        {
+            static const char *sqljson_fns[] = {"JSON_VALUE", "JSON_QUERY", "JSON_EXISTS", nullptr};
+            for (int fi = 0; sqljson_fns[fi]; ++fi) {
+                size_t fl = strlen(sqljson_fns[fi]);
+                if (eu.compare(0, fl, sqljson_fns[fi]) != 0 || eu.size() <= fl || eu[fl] != '(' ||
+                    !fn_paren_ok(fl))
+                    continue;
+                SvdbVal v;
+                std::string err;
+                if (!sqljson_call(sqljson_fns[fi], e.substr(fl + 1, e.size() - fl - 2), row, col_order, v, err)) {
+                    g_eval_error = err;
+                    return SvdbVal{};
+                }
+                return v;
+            }
+        }
         /* json_extract(json, path) */
         if (eu.substr(0, 13) == "JSON_EXTRACT(" && fn_paren_ok(13-1)) {
-            std::string args = e.substr(13, e.size()-14);
-            size_t comma = args.find(',');
-            if (comma != std::string::npos) {
-                SvdbVal json_val = eval_expr(args.substr(0, comma), row, col_order);
-                SvdbVal path_val = eval_expr(args.substr(comma+1), row, col_order);
+            std::vector<std::string> args = qry_split_returning_exprs(e.substr(13, e.size()-14));
+            if (args.size() == 2) {
+                SvdbVal json_val = eval_expr(args[0], row, col_order);
+                SvdbVal path_val = eval_expr(args[1], row, col_order);
                 if (json_val.type == SVDB_TYPE_NULL || path_val.type == SVDB_TYPE_NULL) return SvdbVal{};
-                std::string json_str = val_to_str(json_val);
-                std::string path_str = val_to_str(path_val);
-                char *result = svdb_json_extract(json_str.c_str(), path_str.c_str());
-                if (result) {
-                    std::string result_str(result);
-                    svdb_json_free(result);
+                std::string result_str;
+                if (qry_json_extract_str(json_val, val_to_str(path_val), result_str)) {
                     /* Try to parse result as number */
                     try {
                         size_t pos;
@@ -741,7 +1092,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
         if (eu.substr(0, 5) == "JSON(" && fn_paren_ok(5-1)) {
             SvdbVal json_val = eval_expr(e.substr(5, e.size()-6), row, col_order);
             if (json_val.type == SVDB_TYPE_NULL) return SvdbVal{};
-            std::string json_str = val_to_str(json_val);
+            std::string json_str = qry_json_arg(json_val);
             if (svdb_json_validate(json_str.c_str())) {
                 SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = json_str; return v;
             }
@@ -757,7 +1108,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 if (c == '(') ++depth; else if (c == ')') --depth;
                 else if (c == ',' && depth == 0) {
                     SvdbVal v = eval_expr(args.substr(start, i-start), row, col_order);
-                    values.push_back(val_to_str(v));
+                    values.push_back(qry_json_arg(v));
                     start = i + 1;
                 }
             }
@@ -782,7 +1133,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 else if (c == ',' && depth == 0) {
                     SvdbVal v = eval_expr(args.substr(start, i-start), row, col_order);
                     if (is_key) keys.push_back(val_to_str(v));
-                    else values.push_back(val_to_str(v));
+                    else values.push_back(qry_json_arg(v));
                     is_key = !is_key;
                     start = i + 1;
                 }
@@ -817,7 +1168,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 SvdbVal json_val = eval_expr(args.substr(0, comma), row, col_order);
                 SvdbVal path_val = eval_expr(args.substr(comma+1), row, col_order);
                 if (json_val.type == SVDB_TYPE_NULL) return SvdbVal{};
-                std::string json_str = val_to_str(json_val);
+                std::string json_str = qry_json_arg(json_val);
                 std::string path_str = path_val.type == SVDB_TYPE_NULL ? "$" : val_to_str(path_val);
                 char *result = svdb_json_type(json_str.c_str(), path_str.c_str());
                 if (result) {
@@ -830,7 +1181,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
             /* No comma - single argument json_type(json) */
             SvdbVal json_val = eval_expr(args, row, col_order);
             if (json_val.type == SVDB_TYPE_NULL) return SvdbVal{};
-            std::string json_str = val_to_str(json_val);
+            std::string json_str = qry_json_arg(json_val);
             char *result = svdb_json_type(json_str.c_str(), "$");
             if (result) {
                 std::string result_str(result);
@@ -861,18 +1212,18 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 path_val.type = SVDB_TYPE_NULL;
             }
             if (json_val.type == SVDB_TYPE_NULL) return SvdbVal{};
-            std::string json_str = val_to_str(json_val);
+            std::string json_str = qry_json_arg(json_val);
             std::string path_str = path_val.type == SVDB_TYPE_NULL ? "$" : val_to_str(path_val);
             int64_t len = svdb_json_length(json_str.c_str(), path_str.c_str());
             if (len < 0) return SvdbVal{}; /* Error case */
             SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = len; return v;
         }
         /* json_valid(json) / json_isvalid(json) */
-        if ((eu.substr(0, 12) == "JSON_VALID(" || eu.substr(0, 13) == "JSON_ISVALID(") && fn_paren_ok(eu.substr(0, 12) == "JSON_VALID(" ? 11 : 12)) {
-            size_t start = (eu.substr(0, 12) == "JSON_VALID(") ? 12 : 13;
+        if ((eu.substr(0, 11) == "JSON_VALID(" || eu.substr(0, 13) == "JSON_ISVALID(") && fn_paren_ok(eu.substr(0, 11) == "JSON_VALID(" ? 10 : 12)) {
+            size_t start = (eu.substr(0, 11) == "JSON_VALID(") ? 11 : 13;
             SvdbVal json_val = eval_expr(e.substr(start, e.size()-start-1), row, col_order);
             if (json_val.type == SVDB_TYPE_NULL) { SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = 0; return v; }
-            std::string json_str = val_to_str(json_val);
+            std::string json_str = qry_json_arg(json_val);
             SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = svdb_json_validate(json_str.c_str()); return v;
         }
         /* json_quote(value) */
@@ -905,7 +1256,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 SvdbVal json_val = eval_expr(args.substr(0, comma), row, col_order);
                 SvdbVal path_val = eval_expr(args.substr(comma+1), row, col_order);
                 if (json_val.type == SVDB_TYPE_NULL || path_val.type == SVDB_TYPE_NULL) return SvdbVal{};
-                std::string json_str = val_to_str(json_val);
+                std::string json_str = qry_json_arg(json_val);
                 std::string path_str = val_to_str(path_val);
                 const char *paths[] = {path_str.c_str()};
                 char *result = svdb_json_remove(json_str.c_str(), paths, 1);
@@ -928,7 +1279,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 if (c == '(') ++depth; else if (c == ')') --depth;
                 else if (c == ',' && depth == 0) {
                     SvdbVal v = eval_expr(args.substr(start, i-start), row, col_order);
-                    parts.push_back(val_to_str(v));
+                    parts.push_back(qry_json_arg(v));
                     start = i + 1;
                 }
             }
@@ -954,7 +1305,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 if (c == '(') ++depth; else if (c == ')') --depth;
                 else if (c == ',' && depth == 0) {
                     SvdbVal v = eval_expr(args.substr(start, i-start), row, col_order);
-                    parts.push_back(val_to_str(v));
+                    parts.push_back(qry_json_arg(v));
                     start = i + 1;
                 }
             }
@@ -969,18 +1320,65 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
             }
             return SvdbVal{};
         }
-        /* jsonb(json) - return canonical JSON (same as json() for now) */
+        /* jsonb(json) - the JSONB blob of a JSON text (or JSONB) value */
         if (eu.substr(0, 6) == "JSONB(" && fn_paren_ok(6-1)) {
-            SvdbVal json_val = eval_expr(e.substr(6, e.size()-7), row, col_order);
-            if (json_val.type == SVDB_TYPE_NULL) return SvdbVal{};
-            std::string json_str = val_to_str(json_val);
-            char *result = svdb_json_minify(json_str.c_str());
-            if (result) {
-                std::string result_str(result);
-                svdb_json_free(result);
-                SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = result_str; return v;
+            return qry_jsonb_val(eval_expr(e.substr(6, e.size()-7), row, col_order));
+        }
+        /* jsonb_extract(json, path) - like json_extract, but arrays and
+         * objects come back as JSONB */
+        if (eu.substr(0, 14) == "JSONB_EXTRACT(" && fn_paren_ok(14-1)) {
+            std::vector<std::string> args = qry_split_returning_exprs(e.substr(14, e.size()-15));
+            if (args.size() != 2) return SvdbVal{};
+            SvdbVal doc = eval_expr(args[0], row, col_order);
+            SvdbVal path_val = eval_expr(args[1], row, col_order);
+            if (doc.type == SVDB_TYPE_NULL || path_val.type == SVDB_TYPE_NULL) return SvdbVal{};
+            doc = qry_jsonb_val(doc);
+            std::string elem;
+            if (doc.type == SVDB_TYPE_NULL || !qry_jsonb_locate(doc, val_to_str(path_val), elem)) return SvdbVal{};
+            const char *type = svdb_jsonb_type((const unsigned char *)elem.data(), elem.size());
+            if (type && (std::strcmp(type, "array") == 0 || std::strcmp(type, "object") == 0)) {
+                SvdbVal v; v.type = SVDB_TYPE_BLOB; v.sval = elem; return v;
+            }
+            return eval_expr("JSON_EXTRACT(" + args[0] + ", " + args[1] + ")", row, col_order);
+        }
+        /* jsonb_set/replace/insert(doc, path, value, ...), jsonb_remove(doc, path, ...):
+         * edit the binary form in place of the text */
+        if (eu.substr(0, 6) == "JSONB_") {
+            static const struct { const char *name; int op; } jsonb_edits[] = {
+                {"SET(", SVDB_JSONB_SET}, {"REPLACE(", SVDB_JSONB_REPLACE},
+                {"INSERT(", SVDB_JSONB_INSERT}, {"REMOVE(", SVDB_JSONB_REMOVE}, {nullptr, 0}};
+            for (int fi = 0; jsonb_edits[fi].name; ++fi) {
+                size_t n = std::strlen(jsonb_edits[fi].name);
+                if (eu.compare(6, n, jsonb_edits[fi].name) != 0 || !fn_paren_ok(6 + n - 1)) continue;
+                int op = jsonb_edits[fi].op;
+                std::vector<std::string> args = qry_split_returning_exprs(e.substr(6 + n, e.size() - 7 - n));
+                size_t step = op == SVDB_JSONB_REMOVE ? 1 : 2;
+                if (args.empty() || (args.size() - 1) % step != 0) return SvdbVal{};
+                SvdbVal doc = qry_jsonb_val(eval_expr(args[0], row, col_order));
+                for (size_t ai = 1; doc.type != SVDB_TYPE_NULL && ai < args.size(); ai += step) {
+                    SvdbVal path_val = eval_expr(args[ai], row, col_order);
+                    if (path_val.type == SVDB_TYPE_NULL) return SvdbVal{};
+                    std::string elem = step == 2 ? qry_jsonb_elem(eval_expr(args[ai + 1], row, col_order)) : "";
+                    size_t len = 0;
+                    unsigned char *blob = svdb_jsonb_edit((const unsigned char *)doc.sval.data(), doc.sval.size(),
+                                                          val_to_str(path_val).c_str(), op,
+                                                          (const unsigned char *)elem.data(), elem.size(), &len);
+                    if (!blob) return SvdbVal{};
+                    doc.sval.assign((const char *)blob, len);
+                    svdb_json_free((char *)blob);
+                }
+                return doc;
+            }
+        }
+        /* jsonb_array, jsonb_object, jsonb_patch, jsonb_array_insert: the
+         * json_ function, as JSONB */
+        if (eu.substr(0, 6) == "JSONB_") {
+            static const char *jsonb_fns[] = {"ARRAY(", "OBJECT(", "PATCH(", "ARRAY_INSERT(", nullptr};
+            for (int fi = 0; jsonb_fns[fi]; ++fi) {
+                size_t n = std::strlen(jsonb_fns[fi]);
+                if (eu.compare(6, n, jsonb_fns[fi]) == 0 && fn_paren_ok(6 + n - 1))
+                    return qry_jsonb_val(eval_expr("JSON_" + e.substr(6), row, col_order));
             }
-            return SvdbVal{};
         }
         /* json_array_insert(json, path, value) */
         if (eu.substr(0, 18) == "JSON_ARRAY_INSERT(" && fn_paren_ok(18-1)) {
@@ -994,7 +1392,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 if (c == '(') ++depth; else if (c == ')') --depth;
                 else if (c == ',' && depth == 0) {
                     SvdbVal v = eval_expr(args.substr(start, i-start), row, col_order);
-                    parts.push_back(val_to_str(v));
+                    parts.push_back(qry_json_arg(v));
                     start = i + 1;
                 }
             }
@@ -1012,7 +1410,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
         if (eu.substr(0, 12) == "JSON_PRETTY(" && fn_paren_ok(12-1)) {
             SvdbVal json_val = eval_expr(e.substr(12, e.size()-13), row, col_order);
             if (json_val.type == SVDB_TYPE_NULL) return SvdbVal{};
-            std::string json_str = val_to_str(json_val);
+            std::string json_str = qry_json_arg(json_val);
             char *result = svdb_json_pretty(json_str.c_str());
             if (result) {
                 std::string result_str(result);
@@ -1037,7 +1435,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 SvdbVal tgt = eval_expr(args.substr(0, comma), row, col_order);
                 SvdbVal pat = eval_expr(args.substr(comma+1), row, col_order);
                 if (tgt.type != SVDB_TYPE_NULL && pat.type != SVDB_TYPE_NULL) {
-                    std::string ts = val_to_str(tgt), ps = val_to_str(pat);
+                    std::string ts = qry_json_arg(tgt), ps = qry_json_arg(pat);
                     char buf[65536];
                     if (svdb_json_patch(buf, sizeof(buf), ts.c_str(), ps.c_str()) == 0) {
                         SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = buf; return v;
@@ -1531,26 +1929,6 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
             std::string au; for (char c : a) au += (char)toupper((unsigned char)c);
             return au == "NOW" || au == "NOW()";
         };
-        /* Parse a date/datetime string into struct tm (supports YYYY-MM-DD [HH:MM:SS]) */
-        auto parse_datetime_str = [](const std::string &s, std::tm &out) -> bool {
-            memset(&out, 0, sizeof(out));
-            /* Try YYYY-MM-DD HH:MM:SS */
-            if (sscanf(s.c_str(), "%d-%d-%d %d:%d:%d",
-                       &out.tm_year, &out.tm_mon, &out.tm_mday,
-                       &out.tm_hour, &out.tm_min, &out.tm_sec) >= 3) {
-                out.tm_year -= 1900; out.tm_mon -= 1;
-                return true;
-            }
-            /* Try YYYY-MM-DD */
-            if (sscanf(s.c_str(), "%d-%d-%d", &out.tm_year, &out.tm_mon, &out.tm_mday) == 3) {
-                out.tm_year -= 1900; out.tm_mon -= 1;
-                return true;
-            }
-            /* Try HH:MM:SS */
-            if (sscanf(s.c_str(), "%d:%d:%d", &out.tm_hour, &out.tm_min, &out.tm_sec) == 3)
-                return true;
-            return false;
-        };
         /* Resolve argument: if it's a string literal strip quotes, else eval as expr */
         auto resolve_dt_arg = [&](const std::string &arg) -> std::string {
             std::string a = qry_trim(arg);
@@ -1562,38 +1940,6 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
             if (v.type == SVDB_TYPE_INT)  return std::to_string(v.ival);
             char buf[64]; snprintf(buf, sizeof(buf), "%.17g", v.rval); return buf;
         };
-        if (eu.size() >= 9 && eu.substr(0, 9) == "STRFTIME(" && fn_paren_ok(8)) {
-            std::string args_str = qry_trim(e.substr(9, e.size()-10));
-            /* Split at top-level comma: first arg = format, second = datetime */
-            size_t comma_pos = std::string::npos;
-            { int dp=0; bool ins=false;
-              for (size_t i=0;i<args_str.size();++i) {
-                char c2=args_str[i]; if(c2=='\''){ins=!ins;continue;} if(ins)continue;
-                if(c2=='(')++dp;else if(c2==')')--dp;
-                else if(c2==','&&dp==0){comma_pos=i;break;}
-              }
-            }
-            if (comma_pos != std::string::npos) {
-                std::string fmt_arg = qry_trim(args_str.substr(0, comma_pos));
-                std::string dt_arg  = qry_trim(args_str.substr(comma_pos + 1));
-                std::string fmt_str;
-                if (fmt_arg.size()>=2 && fmt_arg.front()=='\'') fmt_str=fmt_arg.substr(1,fmt_arg.size()-2);
-                else fmt_str=fmt_arg;
-                if (is_now_arg(dt_arg)) {
-                    char buf[64]; fmt_utc(fmt_str.c_str(), buf, sizeof(buf));
-                    SvdbVal v; v.type=SVDB_TYPE_TEXT; v.sval=buf; return v;
-                }
-                std::string dt_str = resolve_dt_arg(dt_arg);
-                if (!dt_str.empty()) {
-                    std::tm tm_buf{}; if (parse_datetime_str(dt_str, tm_buf)) {
-                        tm_buf.tm_isdst = -1; mktime(&tm_buf); /* fills tm_wday, tm_yday */
-                        char buf[64]; strftime(buf, sizeof(buf), fmt_str.c_str(), &tm_buf);
-                        SvdbVal v; v.type=SVDB_TYPE_TEXT; v.sval=buf; return v;
-                    }
-                }
-            }
-            return SvdbVal{}; /* NULL on failure */
-        }
         /* Helper: apply SQLite-compatible date modifiers to a tm struct */
         auto apply_date_modifier = [](std::tm &tm_in, const std::string &mod) -> bool {
             std::string m = mod;
@@ -1628,26 +1974,48 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
                 tm_in.tm_sec += delta;
             } else { return false; }
             /* Normalize */
-            tm_in.tm_isdst = -1; mktime(&tm_in);
+            dt_normalize(tm_in);
             return true;
         };
         /* Parse all args of a date function: first is base datetime/col, rest are modifiers */
-        auto parse_dt_args = [&](const std::string &args_str, std::string &base_out,
-                                  std::vector<std::string> &mods_out) {
-            base_out.clear(); mods_out.clear();
-            int dp=0; bool ins=false; size_t start=0;
-            for (size_t i=0; i<=args_str.size(); ++i) {
-                char c2 = (i<args_str.size()) ? args_str[i] : ',';
-                if(c2=='\''){ins=!ins; continue;} if(ins) continue;
-                if(c2=='(')++dp; else if(c2==')')--dp;
-                else if(c2==','&&dp==0){
-                    std::string tok=qry_trim(args_str.substr(start,i-start));
-                    if(base_out.empty()) base_out=tok;
-                    else mods_out.push_back(tok);
-                    start=i+1;
+        auto parse_dt_args = [](const std::string &args_str, std::string &base_out,
+                                std::vector<std::string> &mods_out) {
+            dt_split_args(args_str, base_out, mods_out);
+        };
+        if (eu.size() >= 9 && eu.substr(0, 9) == "STRFTIME(" && fn_paren_ok(8)) {
+            std::string args_str = qry_trim(e.substr(9, e.size()-10));
+            /* Split at top-level comma: first arg = format, second = datetime */
+            size_t comma_pos = std::string::npos;
+            { int dp=0; bool ins=false;
+              for (size_t i=0;i<args_str.size();++i) {
+                char c2=args_str[i]; if(c2=='\''){ins=!ins;continue;} if(ins)continue;
+                if(c2=='(')++dp;else if(c2==')')--dp;
+                else if(c2==','&&dp==0){comma_pos=i;break;}
+              }
+            }
+            if (comma_pos != std::string::npos) {
+                std::string fmt_arg = qry_trim(args_str.substr(0, comma_pos));
+                std::string dt_arg  = qry_trim(args_str.substr(comma_pos + 1));
+                std::string fmt_str;
+                if (fmt_arg.size()>=2 && fmt_arg.front()=='\'') fmt_str=fmt_arg.substr(1,fmt_arg.size()-2);
+                else fmt_str=fmt_arg;
+                if (is_now_arg(dt_arg)) {
+                    char buf[64]; fmt_utc(fmt_str.c_str(), buf, sizeof(buf));
+                    SvdbVal v; v.type=SVDB_TYPE_TEXT; v.sval=buf; return v;
+                }
+                std::string base_arg; std::vector<std::string> mods;
+                dt_split_args(dt_arg, base_arg, mods);
+                std::string dt_str = resolve_dt_arg(base_arg);
+                if (!dt_str.empty()) {
+                    std::tm tm_buf{}; if (dt_base(dt_str, mods, tm_buf)) {
+                        for (auto &mod : mods) apply_date_modifier(tm_buf, mod);
+                        char buf[64]; strftime(buf, sizeof(buf), fmt_str.c_str(), &tm_buf);
+                        SvdbVal v; v.type=SVDB_TYPE_TEXT; v.sval=buf; return v;
+                    }
                 }
             }
-        };
+            return SvdbVal{}; /* NULL on failure */
+        }
         /* Helper: compute Julian Day Number from tm (proleptic Gregorian) */
         auto tm_to_julianday = [](const std::tm &tm_in, double frac_day) -> double {
             int Y = tm_in.tm_year + 1900;
@@ -1677,8 +2045,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
             }
             std::string dt_str = resolve_dt_arg(base_arg);
             if (!dt_str.empty()) {
-                std::tm tm_buf{}; if (parse_datetime_str(dt_str, tm_buf)) {
-                    tm_buf.tm_isdst = -1; mktime(&tm_buf);
+                std::tm tm_buf{}; if (dt_base(dt_str, mods, tm_buf)) {
                     for (auto &mod : mods) apply_date_modifier(tm_buf, mod);
                     if (is_julian) {
                         double frac = (tm_buf.tm_hour * 3600 + tm_buf.tm_min * 60 + tm_buf.tm_sec) / 86400.0;
@@ -1714,7 +2081,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
             }
             std::string dt_str = resolve_dt_arg(base_arg);
             if (!dt_str.empty()) {
-                std::tm tm_buf{}; if (parse_datetime_str(dt_str, tm_buf)) {
+                std::tm tm_buf{}; if (dt_base(dt_str, mods, tm_buf)) {
                     SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = to_epoch(tm_buf); return v;
                 }
             }
@@ -1732,8 +2099,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
             }
             std::string dt_str = resolve_dt_arg(base_arg);
             if (!dt_str.empty()) {
-                std::tm tm_buf{}; if (parse_datetime_str(dt_str, tm_buf)) {
-                    tm_buf.tm_isdst = -1; mktime(&tm_buf);
+                std::tm tm_buf{}; if (dt_base(dt_str, mods, tm_buf)) {
                     for (auto &mod : mods) apply_date_modifier(tm_buf, mod);
                     char buf[10]; strftime(buf, sizeof(buf), "%H:%M:%S", &tm_buf);
                     SvdbVal v; v.type=SVDB_TYPE_TEXT; v.sval=buf; return v;
@@ -1753,8 +2119,7 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
             }
             std::string dt_str = resolve_dt_arg(base_arg);
             if (!dt_str.empty()) {
-                std::tm tm_buf{}; if (parse_datetime_str(dt_str, tm_buf)) {
-                    tm_buf.tm_isdst = -1; mktime(&tm_buf);
+                std::tm tm_buf{}; if (dt_base(dt_str, mods, tm_buf)) {
                     for (auto &mod : mods) apply_date_modifier(tm_buf, mod);
                     char buf[24]; strftime(buf, sizeof(buf), "%Y-%m-%d %H:%M:%S", &tm_buf);
                     SvdbVal v; v.type=SVDB_TYPE_TEXT; v.sval=buf; return v;
@@ -2261,7 +2626,8 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
     }
 
 #ifdef SVDB_EXT_JSON
-    /* JSON arrow operators: ->> (extract as text) and -> (extract as JSON) */
+    /* JSON arrow operators: -> (the JSON of the element) and ->> (its SQL
+     * value). The right side is a path, an object label or an array index. */
     {
         int depth_arrow = 0;
         bool in_str_arrow = false;
@@ -2273,35 +2639,28 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
             if (c == ')') ++depth_arrow;
             else if (c == '(') { if (depth_arrow > 0) --depth_arrow; }
             if (depth_arrow > 0) continue;
-            /* Check for ->> at position i-1, i, i+1 */
-            if (i+1 < (int)e.size() && e[i-1] == '-' && e[i] == '>' && e[i+1] == '>') {
-                SvdbVal lhs = eval_expr(e.substr(0, i-1), row, col_order);
-                SvdbVal rhs = eval_expr(e.substr(i+2), row, col_order);
-                if (lhs.type == SVDB_TYPE_NULL || rhs.type == SVDB_TYPE_NULL) return SvdbVal{};
-                std::string json_str = val_to_str(lhs);
-                std::string path_str = val_to_str(rhs);
-                char *result = svdb_json_extract(json_str.c_str(), path_str.c_str());
-                if (result) {
-                    std::string rs(result); svdb_json_free(result);
-                    SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = rs; return v;
-                }
-                return SvdbVal{};
-            }
-            /* Check for -> at position i-1, i (but not ->>) */
-            if (i < (int)e.size() && e[i-1] == '-' && e[i] == '>' &&
-                (i+1 >= (int)e.size() || e[i+1] != '>')) {
-                SvdbVal lhs = eval_expr(e.substr(0, i-1), row, col_order);
-                SvdbVal rhs = eval_expr(e.substr(i+1), row, col_order);
-                if (lhs.type == SVDB_TYPE_NULL || rhs.type == SVDB_TYPE_NULL) return SvdbVal{};
-                std::string json_str = val_to_str(lhs);
-                std::string path_str = val_to_str(rhs);
-                char *result = svdb_json_extract(json_str.c_str(), path_str.c_str());
-                if (result) {
-                    std::string rs(result); svdb_json_free(result);
-                    SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = rs; return v;
-                }
-                return SvdbVal{};
-            }
+            if (e[i-1] != '-' || e[i] != '>') continue;
+            bool as_sql = i+1 < (int)e.size() && e[i+1] == '>';
+            SvdbVal lhs = eval_expr(e.substr(0, i-1), row, col_order);
+            SvdbVal rhs = eval_expr(e.substr(i + (as_sql ? 2 : 1)), row, col_order);
+            if (lhs.type == SVDB_TYPE_NULL || rhs.type == SVDB_TYPE_NULL) return SvdbVal{};
+            std::string path, doc, elem;
+            if (rhs.type == SVDB_TYPE_INT)
+                path = rhs.ival < 0 ? "$[#" + std::to_string(rhs.ival) + "]"
+                                    : "$[" + std::to_string(rhs.ival) + "]";
+            else if (!rhs.sval.empty() && rhs.sval[0] == '$')
+                path = rhs.sval;
+            else
+                path = "$.\"" + val_to_str(rhs) + "\"";
+            if (!sqljson_doc(lhs, doc)) return SvdbVal{};
+            SvdbVal d; d.type = SVDB_TYPE_BLOB; d.sval = doc;
+            if (!qry_jsonb_locate(d, path, elem)) return SvdbVal{};
+            if (as_sql) return sqljson_scalar(elem);
+            char *t = svdb_jsonb_to_text((const unsigned char *)elem.data(), elem.size());
+            if (!t) return SvdbVal{};
+            SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = t;
+            svdb_json_free(t);
+            return v;
         }
     }
 #endif /* SVDB_EXT_JSON */
@@ -2430,6 +2789,11 @@ static SvdbVal eval_expr(const std::string &expr, const Row &row,
     for (auto &kv : row) {
         if (qry_upper(kv.first) == col_upper) return kv.second;
     }
+    /* ROWID and OID alias _rowid_ when no real column has the name */
+    if (col_upper == "ROWID" || col_upper == "OID") {
+        it = row.find(SVDB_ROWID_COLUMN);
+        if (it != row.end()) return it->second;
+    }
 
     /* If the expression looks like a function call (IDENT(...)) but wasn't matched
      * by any known function handler above, and wasn't found as a row key, report an
@@ -2458,6 +2822,98 @@ static bool qry_eval_where(const Row &row,
                              const std::vector<std::string> &col_order,
                              const std::string &where_text);
 
+/* Reads an identifier of e at i, bare or quoted with "", `` or [] */
+static bool qry_read_ident(const std::string &e, size_t &i, std::string &out) {
+    if (i >= e.size()) return false;
+    char c = e[i];
+    if (c == '"' || c == '`' || c == '[') {
+        char close = c == '[' ? ']' : c;
+        out.clear();
+        for (++i; i < e.size(); ++i) {
+            if (e[i] != close) { out += e[i]; continue; }
+            if (close != ']' && i + 1 < e.size() && e[i + 1] == close) { out += close; ++i; continue; }
+            ++i;
+            return true;
+        }
+        return false;
+    }
+    if (!isalpha((unsigned char)c) && c != '_') return false;
+    size_t st = i;
+    while (i < e.size() && (isalnum((unsigned char)e[i]) || e[i] == '_')) ++i;
+    out = e.substr(st, i - st);
+    return true;
+}
+
+/* The affinity a comparison operand has, as SQLite determines it: a column
+ * reference has its column's declared affinity, CAST(x AS type) that of
+ * type, and any other expression none (-1). Columns are resolved against
+ * the tables of the statement (g_where_tables). */
+static int qry_operand_affinity(const std::string &operand) {
+    if (!g_where_tables || !g_query_db) return -1;
+    auto cached = g_where_tables->affinity.find(operand);
+    if (cached != g_where_tables->affinity.end()) return cached->second;
+
+    int aff = -1;
+    std::string e = qry_trim(operand);
+    while (e.size() >= 2 && e.front() == '(' && e.back() == ')') {
+        int depth = 0; bool outer = true;
+        for (size_t i = 0; i + 1 < e.size() && outer; ++i) {
+            if (e[i] == '(') ++depth;
+            else if (e[i] == ')' && --depth == 0) outer = false;
+        }
+        if (!outer) break;
+        e = qry_trim(e.substr(1, e.size() - 2));
+    }
+    std::string eu = qry_upper(e);
+    if (eu.compare(0, 4, "CAST") == 0 && !e.empty() && e.back() == ')' &&
+        qry_trim(eu.substr(4)).compare(0, 1, "(") == 0) {
+        /* The type follows the last top-level AS */
+        size_t open = eu.find('('), as = std::string::npos;
+        int depth = 0; char quote = 0;
+        for (size_t i = open + 1; i + 1 < eu.size(); ++i) {
+            char c = eu[i];
+            if (quote) { if (c == quote) quote = 0; continue; }
+            if (c == '\'' || c == '"') quote = c;
+            else if (c == '(') ++depth;
+            else if (c == ')') --depth;
+            else if (depth == 0 && c == ' ' && eu.compare(i, 4, " AS ") == 0) as = i;
+        }
+        if (as != std::string::npos)
+            aff = svdb_affinity_of(qry_trim(e.substr(as + 4, e.size() - 1 - (as + 4))));
+    } else {
+        std::string first, second;
+        size_t i = 0;
+        bool ident = qry_read_ident(e, i, first);
+        if (ident && i < e.size() && e[i] == '.') {
+            ++i;
+            ident = qry_read_ident(e, i, second);
+        }
+        if (ident && i == e.size()) {
+            const std::string &qual = second.empty() ? std::string() : first;
+            const std::string &col = second.empty() ? first : second;
+            std::string cu = qry_upper(col);
+            for (const auto &qt : g_where_tables->tables) {
+                if (!qual.empty() && qry_upper(qual) != qry_upper(qt.first)) continue;
+                auto sit = g_query_db->schema.find(qt.second);
+                if (sit == g_query_db->schema.end()) {
+                    std::string tu = qry_upper(qt.second);
+                    for (sit = g_query_db->schema.begin(); sit != g_query_db->schema.end(); ++sit)
+                        if (qry_upper(sit->first) == tu) break;
+                    if (sit == g_query_db->schema.end()) continue;
+                }
+                for (const auto &kv : sit->second) {
+                    if (qry_upper(kv.first) == cu) { aff = svdb_affinity_of(kv.second.type); break; }
+                }
+                if (aff < 0 && !sit->second.empty() && (cu == "ROWID" || cu == "OID" || cu == "_ROWID_"))
+                    aff = SVDB_AFF_INTEGER;
+                if (aff >= 0) break;
+            }
+        }
+    }
+    g_where_tables->affinity[operand] = aff;
+    return aff;
+}
+
 /* Thread-local flag: set to true when a comparison returns false due to NULL operands.
  * Used by NOT handler to propagate three-valued logic (NOT NULL = NULL = false). */
 static thread_local bool g_last_null_comparison = false;
@@ -2693,10 +3149,19 @@ static bool qry_eval_where(const Row &row,
         }
     }
 
-/* MATCH — case-insensitive substring search (FTS-style on regular tables) */
+/* MATCH — full-text query on FTS5 tables, case-insensitive substring search
+ * on regular tables */
     {
         size_t match_pos = wu.find(" MATCH ");
         if (match_pos != std::string::npos) {
+            std::string fts_col;
+            if (qry_fts_target(wt.substr(0, match_pos), fts_col)) {
+                SvdbVal rhs = eval_expr(wt.substr(match_pos + 7), row, col_order);
+                if (rhs.type == SVDB_TYPE_NULL) return false;
+                const Fts5Match *m = qry_fts_match(fts_col, val_to_str(rhs));
+                auto rit = row.find(SVDB_ROWID_COLUMN);
+                return m && rit != row.end() && m->docs.count(rit->second.ival);
+            }
             SvdbVal lhs = eval_expr(wt.substr(0, match_pos), row, col_order);
             SvdbVal rhs = eval_expr(wt.substr(match_pos + 7), row, col_order);
             if (lhs.type == SVDB_TYPE_NULL || rhs.type == SVDB_TYPE_NULL) return false;
@@ -2880,6 +3345,11 @@ static bool qry_eval_where(const Row &row,
                     if (case_depth_c > 0) { --case_depth_c; i += 3; continue; }
                 }
                 if (case_depth_c > 0) continue;
+                /* Skip the JSON arrows -> and ->> */
+                if (c == '-' && i + 1 < wt.size() && wt[i+1] == '>') {
+                    i += (i + 2 < wt.size() && wt[i+2] == '>') ? 2 : 1;
+                    continue;
+                }
                 /* Try each operator */
                 for (int oi = 0; ops[oi]; ++oi) {
                     size_t oplen = strlen(ops[oi]);
@@ -2919,10 +3389,31 @@ static bool qry_eval_where(const Row &row,
                 g_last_null_comparison = true;
                 return false;
             }
+            /* Comparison affinity: when either operand has INTEGER, REAL or
+             * NUMERIC affinity and the other has another or none, both get
+             * NUMERIC affinity; TEXT against no affinity gives both TEXT. */
+            {
+                int laff = qry_operand_affinity(lhs_s), raff = qry_operand_affinity(rhs_s);
+                auto numeric = [](int a) {
+                    return a == SVDB_AFF_INTEGER || a == SVDB_AFF_REAL || a == SVDB_AFF_NUMERIC;
+                };
+                int aff = -1;
+                if (numeric(laff) || numeric(raff)) aff = SVDB_AFF_NUMERIC;
+                else if ((laff == SVDB_AFF_TEXT && raff < 0) || (raff == SVDB_AFF_TEXT && laff < 0)) aff = SVDB_AFF_TEXT;
+                if (aff >= 0) {
+                    svdb_apply_affinity(lhs, (SvdbAffinity)aff);
+                    svdb_apply_affinity(rhs, (SvdbAffinity)aff);
+                }
+            }
             int c;
+            bool lnum = lhs.type == SVDB_TYPE_INT || lhs.type == SVDB_TYPE_REAL;
+            bool rnum = rhs.type == SVDB_TYPE_INT || rhs.type == SVDB_TYPE_REAL;
             if (nocase && lhs.type == SVDB_TYPE_TEXT && rhs.type == SVDB_TYPE_TEXT) {
                 std::string ls = qry_upper(lhs.sval), rs = qry_upper(rhs.sval);
                 c = ls.compare(rs);
+            } else if (lnum != rnum && (lhs.type == SVDB_TYPE_TEXT || rhs.type == SVDB_TYPE_TEXT)) {
+                /* Left unconverted, a number sorts before any text */
+                c = lnum ? -1 : 1;
             } else {
                 c = val_cmp(lhs, rhs);
             }
@@ -2935,6 +3426,15 @@ static bool qry_eval_where(const Row &row,
         }
     }
 
+    /* A bare function call, e.g. WHERE json_exists(doc, '$.a') */
+    {
+        size_t paren = wt.find('(');
+        bool is_call = paren != std::string::npos && paren > 0 && wt.back() == ')';
+        for (size_t i = 0; is_call && i < paren; ++i)
+            if (!isalnum((unsigned char)wt[i]) && wt[i] != '_') is_call = false;
+        if (is_call) return val_is_true(eval_expr(wt, row, col_order));
+    }
+
     return true;
 }
 
@@ -3065,11 +3565,8 @@ static std::vector<OrderCol> parse_order_by(const std::string &sql) {
     std::string ob_text = (end != std::string::npos)
         ? sql.substr(pos + 9, end - pos - 9)
         : sql.substr(pos + 9);
-    /* Split by comma */
-    std::istringstream ss(ob_text);
-    std::string token;
-    while (std::getline(ss, token, ',')) {
-        token = qry_trim(token);
+    /* Split by top-level comma */
+    for (std::string token : qry_split_returning_exprs(ob_text)) {
         bool desc = false; bool nocase = false; int nulls_opt = 0;
         std::string tu = qry_upper(token);
         /* Strip NULLS FIRST / NULLS LAST */
@@ -3194,6 +3691,248 @@ static std::string parse_where_from_sql(const std::string &sql) {
     return "";
 }
 
+/* Return the first bare ROWID / OID / _ROWID_ identifier in text (outside
+ * string literals), or "" if there is none. */
+static std::string qry_find_rowid_ref(const std::string &text) {
+    bool in_str = false;
+    for (size_t i = 0; i < text.size(); ) {
+        char c = text[i];
+        if (c == '\'') { in_str = !in_str; ++i; continue; }
+        if (in_str || !(isalpha((unsigned char)c) || c == '_')) { ++i; continue; }
+        size_t s = i;
+        while (i < text.size() && (isalnum((unsigned char)text[i]) || text[i] == '_')) ++i;
+        std::string w = qry_upper(text.substr(s, i - s));
+        bool is_call = i < text.size() && text[i] == '(';
+        if (!is_call && (w == "ROWID" || w == "OID" || w == "_ROWID_")) return text.substr(s, i - s);
+    }
+    return "";
+}
+
+/* Split where into its top-level AND terms */
+static std::vector<std::string> qry_split_and(const std::string &where) {
+    std::string wu = qry_upper(where);
+    std::vector<std::string> terms;
+    int depth = 0; bool in_str = false; size_t start = 0;
+    for (size_t i = 0; i < where.size(); ++i) {
+        char c = where[i];
+        if (c == '\'') { in_str = !in_str; continue; }
+        if (in_str) continue;
+        if (c == '(') ++depth;
+        else if (c == ')') { if (depth > 0) --depth; }
+        else if (depth == 0 && wu.compare(i, 5, " AND ") == 0) {
+            terms.push_back(qry_trim(where.substr(start, i - start)));
+            start = i + 5; i += 4;
+        }
+    }
+    terms.push_back(qry_trim(where.substr(start)));
+    return terms;
+}
+
+/* Parse a string or numeric literal */
+static bool qry_parse_literal(std::string x, SvdbVal &v) {
+    x = qry_trim(x);
+    if (x.size() >= 2 && x.front() == '\'' && x.back() == '\'') {
+        std::string body;
+        for (size_t i = 1; i + 1 < x.size(); ++i) {
+            if (x[i] == '\'') { if (x[i+1] != '\'' || i + 2 >= x.size()) return false; ++i; }
+            body += x[i];
+        }
+        v = SvdbVal{}; v.type = SVDB_TYPE_TEXT; v.sval = body;
+        return true;
+    }
+    return svdb_parse_numeric_text(x, v) && !x.empty() && !isspace((unsigned char)x[0]);
+}
+
+/* Split an "l = r" term; false for any other operator */
+static bool qry_split_eq(const std::string &t, std::string &l, std::string &r) {
+    size_t eq = std::string::npos;
+    bool in_str = false;
+    for (size_t i = 0; i < t.size(); ++i) {
+        if (t[i] == '\'') { in_str = !in_str; continue; }
+        if (!in_str && t[i] == '=') { eq = i; break; }
+    }
+    if (eq == std::string::npos || eq == 0) return false;
+    l = t.substr(0, eq); r = t.substr(eq + 1);
+    if (!l.empty() && (l.back() == '!' || l.back() == '<' || l.back() == '>')) return false;
+    if (!r.empty() && r[0] == '=') r = r.substr(1);
+    return true;
+}
+
+/* WITHOUT ROWID primary-key lookup: collect "pk_col = literal" terms from the
+ * top-level AND chain of where into probe, converted to the column affinity.
+ * Returns how many leading key columns are pinned by an equality. */
+static size_t qry_pk_prefix(svdb_db_t *db, const std::string &tname, const std::string &alias,
+                            const std::vector<std::string> &key, const std::string &where,
+                            Row &probe) {
+    auto column_of = [&](std::string x) -> std::string {
+        x = qry_trim(x);
+        size_t dot = x.find('.');
+        if (dot != std::string::npos) {
+            std::string q = qry_upper(x.substr(0, dot));
+            if (q != qry_upper(tname) && q != qry_upper(alias)) return "";
+            x = x.substr(dot + 1);
+        }
+        for (const auto &k : key) if (qry_upper(k) == qry_upper(x)) return k;
+        return "";
+    };
+    for (const auto &t : qry_split_and(where)) {
+        std::string l, r;
+        if (!qry_split_eq(t, l, r)) continue;
+        std::string col; SvdbVal v;
+        if (!(col = column_of(l)).empty() && qry_parse_literal(r, v)) {}
+        else if (!(col = column_of(r)).empty() && qry_parse_literal(l, v)) {}
+        else continue;
+        const auto &td = db->schema[tname];
+        auto cit = td.find(col);
+        if (cit != td.end()) svdb_apply_affinity(v, svdb_affinity_of(cit->second.type));
+        probe[col] = v;
+    }
+    size_t n = 0;
+    while (n < key.size() && probe.count(key[n])) ++n;
+    return n;
+}
+
+/* Canonical text of an expression for matching index terms against a query:
+ * upper-cased outside string literals, with whitespace and tname/alias
+ * qualifiers removed */
+static std::string qry_norm_expr(const std::string &x, const std::string &tname,
+                                 const std::string &alias) {
+    std::string out, tu = qry_upper(tname), au = qry_upper(alias);
+    for (size_t i = 0; i < x.size(); ) {
+        char c = x[i];
+        if (c == '\'') {
+            size_t e = i + 1;
+            while (e < x.size() && !(x[e] == '\'' && (e + 1 >= x.size() || x[e+1] != '\''))) e += (x[e] == '\'') ? 2 : 1;
+            out += x.substr(i, e + 1 - i);
+            i = e + 1;
+        } else if (isalpha((unsigned char)c) || c == '_') {
+            size_t s = i;
+            while (i < x.size() && (isalnum((unsigned char)x[i]) || x[i] == '_')) ++i;
+            std::string w = qry_upper(x.substr(s, i - s));
+            if (i < x.size() && x[i] == '.' && (w == tu || (!au.empty() && w == au))) { ++i; continue; }
+            out += w;
+        } else if (c == '"' || c == '`') {
+            size_t e = x.find(c, i + 1);
+            if (e == std::string::npos) e = x.size();
+            out += qry_upper(x.substr(i + 1, e - i - 1));
+            i = e + 1;
+        } else {
+            if (!isspace((unsigned char)c)) out += c;
+            ++i;
+        }
+    }
+    return out;
+}
+
+/* Whether every row matching where also satisfies pred, judged term by term:
+ * each AND term of pred must appear in where, except that "x IS NOT NULL" is
+ * also implied by "x = <non-null literal>" */
+static bool qry_where_implies(const std::string &where, const std::string &pred,
+                              const std::string &tname, const std::string &alias) {
+    std::vector<std::string> wterms = qry_split_and(where);
+    std::set<std::string> have;
+    for (const auto &t : wterms) have.insert(qry_norm_expr(t, tname, alias));
+    for (const auto &t : qry_split_and(pred)) {
+        std::string n = qry_norm_expr(t, tname, alias);
+        if (have.count(n)) continue;
+        const std::string suffix = "ISNOTNULL";
+        if (n.size() <= suffix.size() || n.compare(n.size() - suffix.size(), suffix.size(), suffix) != 0)
+            return false;
+        std::string x = n.substr(0, n.size() - suffix.size());
+        bool implied = false;
+        for (const auto &wt : wterms) {
+            std::string l, r; SvdbVal v;
+            if (!qry_split_eq(wt, l, r)) continue;
+            if ((qry_norm_expr(l, tname, alias) == x && qry_parse_literal(r, v)) ||
+                (qry_norm_expr(r, tname, alias) == x && qry_parse_literal(l, v))) { implied = true; break; }
+        }
+        if (!implied) return false;
+    }
+    return true;
+}
+
+/* Pick an index of tname usable for where: its leading key term is compared
+ * with "=" to a literal and, for a partial index, where implies its predicate.
+ * Unique indexes are preferred. */
+static bool qry_choose_index(svdb_db_t *db, const std::string &tname, const std::string &alias,
+                             const std::string &where, std::string &iname, SvdbVal &key) {
+    if (where.empty()) return false;
+    std::vector<std::pair<std::string, std::string>> eqs; /* {normalized expr, literal} */
+    for (const auto &t : qry_split_and(where)) {
+        std::string l, r; SvdbVal v;
+        if (!qry_split_eq(t, l, r)) continue;
+        if (qry_parse_literal(r, v)) eqs.push_back({qry_norm_expr(l, tname, alias), r});
+        else if (qry_parse_literal(l, v)) eqs.push_back({qry_norm_expr(r, tname, alias), l});
+    }
+    if (eqs.empty()) return false;
+    bool found = false;
+    for (const auto &kv : db->indexes) {
+        const IndexDef &idx = kv.second;
+        if (idx.table != tname || idx.columns.empty()) continue;
+        if (found && (!idx.unique || db->indexes.at(iname).unique)) continue;
+        std::string lead = qry_norm_expr(idx.columns[0], tname, alias);
+        for (const auto &eq : eqs) {
+            if (eq.first != lead) continue;
+            if (!idx.where.empty() && !qry_where_implies(where, idx.where, tname, alias)) break;
+            SvdbVal v;
+            qry_parse_literal(eq.second, v);
+            const auto &td = db->schema[tname];
+            auto cit = td.find(idx.columns[0]);
+            if (cit != td.end()) svdb_apply_affinity(v, svdb_affinity_of(cit->second.type));
+            iname = kv.first; key = v; found = true;
+            break;
+        }
+    }
+    return found;
+}
+
+/* The entries of index iname sorted by leading key. They are cached until
+ * the data changes; bulk loads build them ahead of the first lookup. */
+IndexEntries &svdb_index_build(svdb_db_t *db, const std::string &iname) {
+    const IndexDef &idx = db->indexes.at(iname);
+    IndexEntries &ent = db->index_cache[iname];
+    if (ent.gen != db->data_gen) {
+        ent.keys.clear();
+        const auto &rows = db->data[idx.table];
+        const auto &order = db->col_order[idx.table];
+        const auto &td = db->schema[idx.table];
+        SvdbWhereTables where_tables{{{idx.table, idx.table}}, {}};
+        SvdbWhereTablesScope where_scope(&where_tables);
+        for (size_t i = 0; i < rows.size(); ++i) {
+            SvdbVal v;
+            if (td.count(idx.columns[0])) {
+                auto it = rows[i].find(idx.columns[0]);
+                if (it != rows[i].end()) v = it->second;
+            } else {
+                v = eval_expr(idx.columns[0], rows[i], order);
+            }
+            if (v.type == SVDB_TYPE_NULL) continue;
+            if (!idx.where.empty() && !qry_eval_where(rows[i], order, idx.where)) continue;
+            ent.keys.push_back({v, i});
+        }
+        std::stable_sort(ent.keys.begin(), ent.keys.end(),
+            [](const std::pair<SvdbVal, size_t> &a, const std::pair<SvdbVal, size_t> &b) {
+                return svdb_val_order(a.first, b.first) < 0;
+            });
+        ent.gen = db->data_gen;
+    }
+    return ent;
+}
+
+/* Positions in db->data[tname] of the rows whose leading key in index iname
+ * equals key, in table order */
+static std::vector<size_t> qry_index_lookup(svdb_db_t *db, const std::string &iname, const SvdbVal &key) {
+    const IndexEntries &ent = svdb_index_build(db, iname);
+    auto range = std::equal_range(ent.keys.begin(), ent.keys.end(), std::make_pair(key, (size_t)0),
+        [](const std::pair<SvdbVal, size_t> &a, const std::pair<SvdbVal, size_t> &b) {
+            return svdb_val_order(a.first, b.first) < 0;
+        });
+    std::vector<size_t> pos;
+    for (auto it = range.first; it != range.second; ++it) pos.push_back(it->second);
+    std::sort(pos.begin(), pos.end());
+    return pos;
+}
+
 /* Extract left table alias from FROM clause */
 static std::string parse_left_alias(const std::string &sql) {
     std::string su = qry_upper(sql);
@@ -3754,9 +4493,9 @@ static SvdbVal agg_result(const AggState &a) {
             if (base_result.type == SVDB_TYPE_INT) { if (base_result.ival < 0) base_result.ival = -base_result.ival; }
             else if (base_result.type == SVDB_TYPE_REAL) { base_result.rval = std::abs(base_result.rval); }
         } else if (a.wrapper == "UPPER") {
-            if (base_result.type == SVDB_TYPE_TEXT) { for (auto &c2 : base_result.sval) c2 = (char)toupper((unsigned char)c2); }
+            if (base_result.type == SVDB_TYPE_TEXT) { std::string t = base_result.sval; for (auto &c2 : t) c2 = (char)toupper((unsigned char)c2); base_result.sval = t; }
         } else if (a.wrapper == "LOWER") {
-            if (base_result.type == SVDB_TYPE_TEXT) { for (auto &c2 : base_result.sval) c2 = (char)tolower((unsigned char)c2); }
+            if (base_result.type == SVDB_TYPE_TEXT) { std::string t = base_result.sval; for (auto &c2 : t) c2 = (char)tolower((unsigned char)c2); base_result.sval = t; }
         } else if (a.wrapper == "ROUND") {
             if (base_result.type == SVDB_TYPE_REAL) base_result.rval = std::round(base_result.rval);
             else if (base_result.type == SVDB_TYPE_INT) { /* already integer */ }
@@ -3815,6 +4554,27 @@ static SvdbVal agg_result(const AggState &a) {
     return base_result;
 }
 
+/* e when it is a plain column reference, name or table.name, that eval_expr
+ * would read from the row by its exact key; otherwise "" */
+static std::string qry_column_ref(const std::string &e) {
+    if (e.empty()) return "";
+    bool start = true;
+    for (char c : e) {
+        if (c == '.' && !start) { start = true; continue; }
+        if (c == '_' || isalpha((unsigned char)c) || (!start && isdigit((unsigned char)c))) {
+            start = false;
+            continue;
+        }
+        return "";
+    }
+    if (start) return "";
+    std::string eu = qry_upper(e);
+    if (eu == "NULL" || eu == "TRUE" || eu == "FALSE" || eu == "CURRENT_DATE" ||
+        eu == "CURRENT_TIME" || eu == "CURRENT_TIMESTAMP")
+        return "";
+    return e;
+}
+
 /* ── Window Function Support ─────────────────────────────────────── */
 
 /* Check if expression contains a window function (has OVER at top level) */
@@ -4115,6 +4875,9 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
     g_query_db = db;
     struct DbGuard { svdb_db_t **p; svdb_db_t *v; ~DbGuard() { *p = v; } } db_guard{&g_query_db, prev_db};
 
+    /* Scans of COLUMNAR tables the column image can answer */
+    if (columnar_try_select(db, sql, rows_out)) return SVDB_OK;
+
     /* ── information_schema / sqlite_master / sqlite_sequence / sqlite_stat1 intercept ── */
     {
         std::string su_is = qry_upper(qry_trim(sql));
@@ -4233,15 +4996,29 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
                 if (!where_txt.empty() && !qry_eval_where(rd, r->col_names, where_txt)) continue;
                 r->rows.push_back({rd["type"],rd["name"],rd["tbl_name"],rd["rootpage"],rd["sql"]});
             }
-            /* Also add indexes */
+            /* Also add indexes and triggers, as written or rebuilt from
+             * their definitions if the database was reloaded */
+            /* Always quoted: a plain name may still be a keyword */
+            auto ident = [](const std::string &name) {
+                std::string q = "\"";
+                for (char c : name) { if (c == '"') q += '"'; q += c; }
+                return q + "\"";
+            };
             for (auto &kv : db->indexes) {
                 Row rd;
-                std::string idx_sql = "CREATE INDEX " + kv.first + " ON " + kv.second.table + " (";
-                for (size_t i = 0; i < kv.second.columns.size(); ++i) {
-                    if (i) idx_sql += ",";
-                    idx_sql += kv.second.columns[i];
+                std::string idx_sql = kv.second.sql;
+                if (idx_sql.empty()) {
+                    idx_sql = std::string(kv.second.unique ? "CREATE UNIQUE INDEX " : "CREATE INDEX ") +
+                              ident(kv.first) + " ON " + ident(kv.second.table) + " (";
+                    const auto &td = db->schema[kv.second.table];
+                    for (size_t i = 0; i < kv.second.columns.size(); ++i) {
+                        if (i) idx_sql += ",";
+                        const std::string &c = kv.second.columns[i];
+                        idx_sql += td.count(c) ? ident(c) : c;
+                    }
+                    idx_sql += ")";
+                    if (!kv.second.where.empty()) idx_sql += " WHERE " + kv.second.where;
                 }
-                idx_sql += ")";
                 rd["type"]     = SvdbVal{SVDB_TYPE_TEXT,0,0,"index"};
                 rd["name"]     = SvdbVal{SVDB_TYPE_TEXT,0,0,kv.first};
                 rd["tbl_name"] = SvdbVal{SVDB_TYPE_TEXT,0,0,kv.second.table};
@@ -4250,6 +5027,26 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
                 if (!where_txt.empty() && !qry_eval_where(rd, r->col_names, where_txt)) continue;
                 r->rows.push_back({rd["type"],rd["name"],rd["tbl_name"],rd["rootpage"],rd["sql"]});
             }
+            for (auto &kv : db->triggers) {
+                const TriggerDef &td = kv.second;
+                std::string trg_sql = td.sql;
+                if (trg_sql.empty()) {
+                    static const char *timings[] = {"BEFORE", "AFTER", "INSTEAD OF"};
+                    static const char *events[] = {"INSERT", "UPDATE", "DELETE"};
+                    trg_sql = "CREATE TRIGGER " + ident(kv.first) + " " + timings[td.timing] + " " +
+                              events[td.event] + " ON " + ident(td.table);
+                    if (!td.when_expr.empty()) trg_sql += " WHEN " + td.when_expr;
+                    trg_sql += " BEGIN " + td.body + " END";
+                }
+                Row rd;
+                rd["type"]     = SvdbVal{SVDB_TYPE_TEXT,0,0,"trigger"};
+                rd["name"]     = SvdbVal{SVDB_TYPE_TEXT,0,0,kv.first};
+                rd["tbl_name"] = SvdbVal{SVDB_TYPE_TEXT,0,0,td.table};
+                rd["rootpage"] = SvdbVal{SVDB_TYPE_INT,0,0,""};
+                rd["sql"]      = SvdbVal{SVDB_TYPE_TEXT,0,0,trg_sql};
+                if (!where_txt.empty() && !qry_eval_where(rd, r->col_names, where_txt)) continue;
+                r->rows.push_back({rd["type"],rd["name"],rd["tbl_name"],rd["rootpage"],rd["sql"]});
+            }
             /* Project columns */
             {
                 std::string sel_part = qry_trim(sql);
@@ -4689,7 +5486,7 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
                             db->data[tmp_rname] = {};
                             if (cte_rows) {
                                 for (const auto &cn : cte_rows->col_names) {
-                                    db->schema[tmp_rname][cn] = ColDef{"TEXT", "", false, false};
+                                    db->schema[tmp_rname][cn] = ColDef{"", "", false, false};
                                     db->col_order[tmp_rname].push_back(cn);
                                 }
                                 for (const auto &irow : cte_rows->rows) {
@@ -4744,7 +5541,7 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
                         db->data[tmp_name] = {};
                         if (cte_rows) {
                             for (const auto &cn : cte_rows->col_names) {
-                                db->schema[tmp_name][cn] = ColDef{"TEXT", "", false, false};
+                                db->schema[tmp_name][cn] = ColDef{"", "", false, false};
                                 db->col_order[tmp_name].push_back(cn);
                             }
                             for (const auto &irow : cte_rows->rows) {
@@ -5020,7 +5817,43 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
         }
     }
 
+    /* ── Registered table functions, materialized the same way as JSON_TABLE ── */
+    {
+        std::string tf_sql = sql, tf_err;
+        std::vector<std::string> tf_tmps;
+        bool ok = tablefn_rewrite(db, tf_sql, tf_tmps, tf_err);
+        if (!ok || !tf_tmps.empty()) {
+            svdb_rows_t *result = nullptr;
+            svdb_code_t rc2 = SVDB_ERR;
+            if (ok) rc2 = svdb_query_internal(db, tf_sql, &result);
+            else db->last_error = tf_err;
+            tablefn_drop(db, tf_tmps);
+            if (rc2 != SVDB_OK) { if (result) delete result; return rc2; }
+            *rows_out = result;
+            return SVDB_OK;
+        }
+    }
+
 #ifdef SVDB_EXT_JSON
+    /* ── JSON_TABLE: materialized into a temp table the rewritten query reads ── */
+    {
+        std::string jt_sql = sql, jt_tmp, jt_err;
+        if (!sqljson_rewrite_table(db, jt_sql, jt_tmp, jt_err)) {
+            db->last_error = jt_err;
+            return SVDB_ERR;
+        }
+        if (!jt_tmp.empty()) {
+            svdb_rows_t *result = nullptr;
+            svdb_code_t rc2 = svdb_query_internal(db, jt_sql, &result);
+            db->schema.erase(jt_tmp);
+            db->col_order.erase(jt_tmp);
+            db->data.erase(jt_tmp);
+            if (rc2 != SVDB_OK) { if (result) delete result; return rc2; }
+            *rows_out = result;
+            return SVDB_OK;
+        }
+    }
+
     /* ── JSON Table-Valued Functions (json_each, json_tree, jsonb_each, jsonb_tree) ── */
     {
         std::string su_tvf = qry_upper(sql);
@@ -5069,7 +5902,7 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
                     /* Evaluate the argument */
                     Row empty_row; std::vector<std::string> empty_order;
                     SvdbVal arg_val = eval_expr(tvf_arg_expr, empty_row, empty_order);
-                    std::string json_str = arg_val.type == SVDB_TYPE_NULL ? "" : val_to_str(arg_val);
+                    std::string json_str = arg_val.type == SVDB_TYPE_NULL ? "" : qry_json_arg(arg_val);
 
                     /* Generate TVF rows */
                     svdb_json_tvf_rows_t *tvf_rows = nullptr;
@@ -5107,7 +5940,7 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
                     db->col_order[tmp_tname] = {};
                     db->data[tmp_tname] = {};
                     for (const char *cn : tvf_cols) {
-                        db->schema[tmp_tname][cn] = ColDef{"TEXT", "", false, false};
+                        db->schema[tmp_tname][cn] = ColDef{"", "", false, false};
                         db->col_order[tmp_tname].push_back(cn);
                     }
 
@@ -5298,7 +6131,7 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
 
                         for (size_t i = 0; i < inner_rows->col_names.size(); ++i) {
                             std::string cn = (i < col_aliases.size()) ? col_aliases[i] : inner_rows->col_names[i];
-                            db->schema[tmp_tname][cn] = ColDef{"TEXT", "", false, false};
+                            db->schema[tmp_tname][cn] = ColDef{"", "", false, false};
                             db->col_order[tmp_tname].push_back(cn);
                         }
                         for (const auto &irow : inner_rows->rows) {
@@ -5376,6 +6209,19 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
     }
     JoinSpec join = all_joins.empty() ? JoinSpec{} : all_joins[0];
 
+    /* The tables comparisons resolve column operands against */
+    SvdbWhereTables where_tables;
+    if (!tname.empty()) {
+        where_tables.tables.push_back({tname, tname});
+        std::string la = parse_left_alias(sql);
+        if (!la.empty()) where_tables.tables.push_back({la, tname});
+        for (const auto &j : all_joins) {
+            where_tables.tables.push_back({j.table, j.table});
+            if (!j.alias.empty()) where_tables.tables.push_back({j.alias, j.table});
+        }
+    }
+    SvdbWhereTablesScope where_scope(&where_tables);
+
     /* Check DISTINCT */
     {
         std::string su = qry_upper(sql);
@@ -5482,6 +6328,25 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
     }
     const auto &col_order = col_order_it->second;
 
+    /* WITHOUT ROWID tables keep rows sorted by primary key and have no rowid */
+    const std::vector<std::string> *clustered_pk = nullptr;
+    {
+        auto oit = db->table_opts.find(tname);
+        auto pit = db->primary_keys.find(tname);
+        if (oit != db->table_opts.end() && oit->second.without_rowid && pit != db->primary_keys.end())
+            clustered_pk = &pit->second;
+    }
+    if (clustered_pk && all_joins.empty()) {
+        std::string ref;
+        for (const auto &sc : sel_cols) if (ref.empty()) ref = qry_find_rowid_ref(sc);
+        if (ref.empty()) ref = qry_find_rowid_ref(where_txt);
+        if (!ref.empty() && !db->schema[tname].count(ref)) {
+            delete r;
+            db->last_error = "no such column: " + ref;
+            return SVDB_ERR;
+        }
+    }
+
     /* ── Build joined rows if JOIN present ── */
     std::vector<Row> all_rows;
     std::vector<std::string> merged_col_order;
@@ -5489,6 +6354,19 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
     /* Parse left table alias */
     std::string left_alias = parse_left_alias(sql);
 
+    /* FTS5 tables: MATCH, rank and the auxiliary functions read the index */
+    FtsScan fts_scan;
+    if (join.type.empty()) {
+        auto fit = db->fts5.find(tname);
+        if (fit != db->fts5.end()) {
+            fts5_sync(db, tname, fit->second);
+            fts_scan.tname = tname;
+            fts_scan.alias = left_alias;
+            fts_scan.ft = &fit->second;
+        }
+    }
+    FtsScanScope fts_scope(fts_scan.ft ? &fts_scan : nullptr);
+
     /* Qualified lookup keys for SELECT * (supports JOINs with overlapping column names) */
     std::vector<std::string> star_lookup_keys;
 
@@ -5695,7 +6573,60 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
         /* Safe access to db->data.at(tname) */
         auto data_it = db->data.find(tname);
         if (data_it != db->data.end()) {
-            all_rows = data_it->second;
+            Row probe;
+            std::string iname;
+            SvdbVal ikey;
+            size_t npk = (clustered_pk && !where_txt.empty())
+                ? qry_pk_prefix(db, tname, left_alias, *clustered_pk, where_txt, probe) : 0;
+            if (npk > 0) {
+                /* Binary search the key range; WHERE is still applied below */
+                std::vector<std::string> prefix(clustered_pk->begin(), clustered_pk->begin() + npk);
+                auto range = std::equal_range(data_it->second.begin(), data_it->second.end(), probe,
+                    [&](const Row &a, const Row &b) { return svdb_row_key_cmp(a, b, prefix) < 0; });
+                all_rows.assign(range.first, range.second);
+            } else if (db->exec_depth == 0 &&
+                       qry_choose_index(db, tname, left_alias, where_txt, iname, ikey)) {
+                /* Index lookup; WHERE is still applied below. Inside a statement
+                 * the data may be mid-change, so only plain queries use the cache. */
+                for (size_t pos : qry_index_lookup(db, iname, ikey))
+                    all_rows.push_back(data_it->second[pos]);
+            } else {
+                all_rows = data_it->second;
+            }
+            /* A top-level "x MATCH 'query'" on an FTS5 table: scan only the
+             * matching documents; WHERE is still applied below */
+            if (g_fts_scan && !where_txt.empty()) {
+                for (const auto &term : qry_split_and(where_txt)) {
+                    size_t mp = qry_upper(term).find(" MATCH ");
+                    std::string fts_col;
+                    SvdbVal q;
+                    if (mp == std::string::npos || !qry_fts_target(term.substr(0, mp), fts_col) ||
+                        !qry_parse_literal(term.substr(mp + 7), q) || q.type != SVDB_TYPE_TEXT)
+                        continue;
+                    const Fts5Match *m = qry_fts_match(fts_col, q.sval);
+                    if (!m) {
+                        db->last_error = g_eval_error;
+                        g_eval_error.clear();
+                        delete r;
+                        return SVDB_ERR;
+                    }
+                    std::vector<Row> kept;
+                    for (auto &row : all_rows) {
+                        auto rit = row.find(SVDB_ROWID_COLUMN);
+                        if (rit != row.end() && m->docs.count(rit->second.ival)) kept.push_back(std::move(row));
+                    }
+                    all_rows = std::move(kept);
+                    break;
+                }
+            }
+            /* External-content and contentless tables read their columns from
+             * the content table */
+            std::string content_err;
+            if (g_fts_scan && !fts5_content_rows(db, *g_fts_scan->ft, all_rows, content_err)) {
+                db->last_error = content_err;
+                delete r;
+                return SVDB_ERR;
+            }
         }
         merged_col_order = col_order;
         /* Always add table-name and alias prefixes to rows for correlated subqueries */
@@ -6199,6 +7130,12 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
         if (!qry_eval_where(row, merged_col_order, where_txt)) continue;
         matching_rows.push_back(row);
     }
+    if (!g_eval_error.empty()) {
+        db->last_error = g_eval_error;
+        g_eval_error.clear();
+        delete r;
+        return SVDB_ERR;
+    }
 
     /* Detect and pre-compute window functions */
     bool has_win = false;
@@ -6210,6 +7147,16 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
     if (has_win)
         win_vals = compute_window_functions(matching_rows, merged_col_order, out_cols);
 
+    /* Resolve the columns read by name to positions once, not per row */
+    bool star_qualified = !star_lookup_keys.empty() && star_lookup_keys.size() == merged_col_order.size();
+    ColumnSlots star_slots(star && star_qualified ? star_lookup_keys : std::vector<std::string>{});
+    ColumnSlots bare_slots(star ? merged_col_order : std::vector<std::string>{});
+    std::vector<std::string> col_refs;
+    if (!star) {
+        for (const auto &ce : out_cols) col_refs.push_back(qry_column_ref(ce));
+    }
+    ColumnSlots ref_slots(std::move(col_refs));
+
     std::vector<std::vector<SvdbVal>> raw_rows;
     std::vector<Row> orig_rows;  /* keep original rows for ORDER BY on non-SELECT cols */
     for (size_t ri = 0; ri < matching_rows.size(); ++ri) {
@@ -6217,18 +7164,17 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
         std::vector<SvdbVal> result_row;
         if (star) {
             /* Use qualified lookup keys when available (JOINs with overlapping column names) */
-            if (!star_lookup_keys.empty() && star_lookup_keys.size() == merged_col_order.size()) {
+            if (star_qualified) {
                 for (size_t ki = 0; ki < star_lookup_keys.size(); ++ki) {
-                    auto it = row.find(star_lookup_keys[ki]);
-                    if (it != row.end()) { result_row.push_back(it->second); continue; }
+                    const SvdbVal *v = star_slots.get(row, ki);
                     /* Fallback to bare name */
-                    auto it2 = row.find(merged_col_order[ki]);
-                    result_row.push_back(it2 != row.end() ? it2->second : SvdbVal{});
+                    if (!v) v = bare_slots.get(row, ki);
+                    result_row.push_back(v ? *v : SvdbVal{});
                 }
             } else {
-                for (const auto &cn : merged_col_order) {
-                    auto it = row.find(cn);
-                    result_row.push_back(it != row.end() ? it->second : SvdbVal{});
+                for (size_t ki = 0; ki < merged_col_order.size(); ++ki) {
+                    const SvdbVal *v = bare_slots.get(row, ki);
+                    result_row.push_back(v ? *v : SvdbVal{});
                 }
             }
             /* Evaluate any extra expressions appended after * (e.g., SELECT *, expr AS alias) */
@@ -6243,6 +7189,8 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
             for (size_t ci = 0; ci < out_cols.size(); ++ci) {
                 if (has_win && is_window_expr(out_cols[ci]))
                     result_row.push_back(win_vals[ci][ri]);
+                else if (const SvdbVal *v = ref_slots.get(row, ci))
+                    result_row.push_back(*v);
                 else
                     result_row.push_back(eval_expr(out_cols[ci], row, merged_col_order));
             }
@@ -6342,8 +7290,90 @@ svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql,
     *rows_out = r; return SVDB_OK;
 }
 
+/* ── EXPLAIN QUERY PLAN ─────────────────────────────────────────── */
+
+/* Describe how a single-table SELECT reads its table, using the same choices
+ * as svdb_query_internal: a primary-key range on a WITHOUT ROWID table, an
+ * index lookup, or a full scan. Other statements produce no rows. */
+static svdb_code_t qry_explain_query_plan(svdb_db_t *db, const std::string &sql,
+                                          svdb_rows_t **rows_out) {
+    svdb_rows_t *r = new (std::nothrow) svdb_rows_t();
+    if (!r) return SVDB_NOMEM;
+    *rows_out = r;
+    r->col_names = {"id", "parent", "notused", "detail"};
+    if (qry_upper(sql.substr(0, 6)) != "SELECT" || !parse_all_joins(sql).empty() ||
+        !parse_comma_joins(sql).empty()) return SVDB_OK;
+
+    svdb_parser_t *p = svdb_parser_create(sql.c_str(), sql.size());
+    if (!p) return SVDB_NOMEM;
+    svdb_ast_node_t *ast = svdb_parser_parse(p);
+    std::string tname, where_txt;
+    if (ast) {
+        tname = svdb_ast_get_table(ast);
+        where_txt = svdb_ast_get_where(ast);
+        svdb_ast_node_free(ast);
+    }
+    svdb_parser_destroy(p);
+    if (where_txt.empty()) where_txt = parse_where_from_sql(sql);
+    std::string resolved;
+    for (auto &kv : db->schema)
+        if (qry_upper(kv.first) == qry_upper(tname)) { resolved = kv.first; break; }
+    if (resolved.empty()) return SVDB_OK;
+    std::string alias = parse_left_alias(sql);
+
+    std::string detail = "SCAN " + resolved;
+    auto oit = db->table_opts.find(resolved);
+    auto pit = db->primary_keys.find(resolved);
+    Row probe;
+    std::string iname;
+    SvdbVal ikey;
+    size_t npk = 0;
+    if (oit != db->table_opts.end() && oit->second.without_rowid && pit != db->primary_keys.end() &&
+        !where_txt.empty())
+        npk = qry_pk_prefix(db, resolved, alias, pit->second, where_txt, probe);
+    if (columnar_explain(db, sql, detail)) {
+        /* detail set by the column image scan */
+    } else if (npk > 0) {
+        detail = "SEARCH " + resolved + " USING PRIMARY KEY (";
+        for (size_t i = 0; i < npk; ++i) detail += (i ? " AND " : "") + pit->second[i] + "=?";
+        detail += ")";
+    } else if (qry_choose_index(db, resolved, alias, where_txt, iname, ikey)) {
+        detail = "SEARCH " + resolved + " USING INDEX " + iname + " (" +
+                 db->indexes.at(iname).columns[0] + "=?)";
+    }
+    SvdbVal v_id, v_parent, v_notused, v_detail;
+    v_id.type = SVDB_TYPE_INT; v_id.ival = 2;
+    v_parent.type = SVDB_TYPE_INT; v_parent.ival = 0;
+    v_notused.type = SVDB_TYPE_INT; v_notused.ival = 0;
+    v_detail.type = SVDB_TYPE_TEXT; v_detail.sval = detail;
+    r->rows.push_back({v_id, v_parent, v_notused, v_detail});
+    return SVDB_OK;
+}
+
 /* ── PRAGMA query handler ───────────────────────────────────────── */
 
+/* Resolve an index name for PRAGMA index_info/index_xinfo. The primary key
+ * of a WITHOUT ROWID table is reported as "sqlite_autoindex_<table>_1". */
+static bool qry_lookup_index(svdb_db_t *db, const std::string &name, std::string &tname,
+                             std::vector<std::string> &cols, bool &pk_index) {
+    std::string name_u = qry_upper(name);
+    for (auto &iv : db->indexes) {
+        if (iv.first == name || qry_upper(iv.first) == name_u) {
+            tname = iv.second.table; cols = iv.second.columns; pk_index = false;
+            return true;
+        }
+    }
+    for (auto &kv : db->table_opts) {
+        if (!kv.second.without_rowid) continue;
+        if (qry_upper("sqlite_autoindex_" + kv.first + "_1") != name_u) continue;
+        auto pk_it = db->primary_keys.find(kv.first);
+        if (pk_it == db->primary_keys.end()) return false;
+        tname = kv.first; cols = pk_it->second; pk_index = true;
+        return true;
+    }
+    return false;
+}
+
 svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
                                svdb_rows_t **rows_out) {
     svdb_assert(db != nullptr);
@@ -6417,13 +7447,10 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
                         }
                     }
                 }
-                std::string base_type = ctype;
-                size_t sp = ctype.find(' ');
-                if (sp != std::string::npos) base_type = ctype.substr(0, sp);
                 SvdbVal v_cid, v_name, v_type, v_notnull, v_dflt, v_pk;
                 v_cid.type = SVDB_TYPE_INT; v_cid.ival = cid++;
                 v_name.type = SVDB_TYPE_TEXT; v_name.sval = col;
-                v_type.type = SVDB_TYPE_TEXT; v_type.sval = base_type;
+                v_type.type = SVDB_TYPE_TEXT; v_type.sval = ctype;
                 v_notnull.type = SVDB_TYPE_INT; v_notnull.ival = notnull;
                 /* dflt_value: use ColDef.default_val if set */
                 if (def && !def->default_val.empty()) {
@@ -6464,8 +7491,11 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
             v_name.type   = SVDB_TYPE_TEXT; v_name.sval   = kv.first;
             v_type.type   = SVDB_TYPE_TEXT; v_type.sval   = ttype;
             v_ncol.type   = SVDB_TYPE_INT;  v_ncol.ival   = (int64_t)kv.second.size();
-            v_wr.type     = SVDB_TYPE_INT;  v_wr.ival     = 0;
-            v_strict.type = SVDB_TYPE_INT;  v_strict.ival = 0;
+            auto opt_it = db->table_opts.find(kv.first);
+            v_wr.type     = SVDB_TYPE_INT;
+            v_wr.ival     = (opt_it != db->table_opts.end() && opt_it->second.without_rowid) ? 1 : 0;
+            v_strict.type = SVDB_TYPE_INT;
+            v_strict.ival = (opt_it != db->table_opts.end() && opt_it->second.strict) ? 1 : 0;
             r->rows.push_back({v_schema, v_name, v_type, v_ncol, v_wr, v_strict});
         }
         return SVDB_OK;
@@ -6476,6 +7506,17 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
         r->col_names = {"seq", "name", "unique", "origin", "partial"};
         std::string parg_u = qry_upper(parg);
         int seq = 0;
+        /* The primary key of a WITHOUT ROWID table is its clustered index */
+        for (auto &kv : db->table_opts) {
+            if (!kv.second.without_rowid || qry_upper(kv.first) != parg_u) continue;
+            SvdbVal v_seq, v_name, v_uniq, v_origin, v_partial;
+            v_seq.type = SVDB_TYPE_INT; v_seq.ival = seq++;
+            v_name.type = SVDB_TYPE_TEXT; v_name.sval = "sqlite_autoindex_" + kv.first + "_1";
+            v_uniq.type = SVDB_TYPE_INT; v_uniq.ival = 1;
+            v_origin.type = SVDB_TYPE_TEXT; v_origin.sval = "pk";
+            v_partial.type = SVDB_TYPE_INT; v_partial.ival = 0;
+            r->rows.push_back({v_seq, v_name, v_uniq, v_origin, v_partial});
+        }
         for (auto &iv : db->indexes) {
             if (qry_upper(iv.second.table) != parg_u) continue;
             SvdbVal v_seq, v_name, v_uniq, v_origin, v_partial;
@@ -6483,68 +7524,56 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
             v_name.type = SVDB_TYPE_TEXT; v_name.sval = iv.first;
             v_uniq.type = SVDB_TYPE_INT; v_uniq.ival = iv.second.unique ? 1 : 0;
             v_origin.type = SVDB_TYPE_TEXT; v_origin.sval = "c";
-            v_partial.type = SVDB_TYPE_INT; v_partial.ival = 0;
+            v_partial.type = SVDB_TYPE_INT; v_partial.ival = iv.second.where.empty() ? 0 : 1;
             r->rows.push_back({v_seq, v_name, v_uniq, v_origin, v_partial});
         }
         return SVDB_OK;
     }
 
-    /* PRAGMA index_info(idx_name) */
-    if (pname == "INDEX_INFO" && !parg.empty()) {
-        r->col_names = {"seqno", "cid", "name"};
-        std::string parg_u = qry_upper(parg);
-        auto it = db->indexes.find(parg);
-        if (it == db->indexes.end()) {
-            for (auto &iv : db->indexes)
-                if (qry_upper(iv.first) == parg_u) { it = db->indexes.find(iv.first); break; }
-        }
-        if (it != db->indexes.end()) {
-            const auto &tname = it->second.table;
-            int seqno = 0;
-            for (const auto &cn : it->second.columns) {
-                /* find cid in col_order */
-                int cid = 0;
-                if (db->col_order.count(tname)) {
-                    for (size_t ci = 0; ci < db->col_order.at(tname).size(); ++ci)
-                        if (db->col_order.at(tname)[ci] == cn) { cid = (int)ci; break; }
-                }
-                SvdbVal v_seqno, v_cid, v_name;
-                v_seqno.type = SVDB_TYPE_INT; v_seqno.ival = seqno++;
-                v_cid.type   = SVDB_TYPE_INT; v_cid.ival   = cid;
-                v_name.type  = SVDB_TYPE_TEXT; v_name.sval  = cn;
-                r->rows.push_back({v_seqno, v_cid, v_name});
-            }
-        }
-        return SVDB_OK;
-    }
-
-    /* PRAGMA index_xinfo(idx_name) */
-    if (pname == "INDEX_XINFO" && !parg.empty()) {
-        r->col_names = {"seqno", "cid", "name", "desc", "coll", "key"};
-        std::string parg_u = qry_upper(parg);
-        auto it = db->indexes.find(parg);
-        if (it == db->indexes.end()) {
-            for (auto &iv : db->indexes)
-                if (qry_upper(iv.first) == parg_u) { it = db->indexes.find(iv.first); break; }
-        }
-        if (it != db->indexes.end()) {
-            const auto &tname = it->second.table;
-            int seqno = 0;
-            for (const auto &cn : it->second.columns) {
-                int cid = 0;
-                if (db->col_order.count(tname)) {
-                    for (size_t ci = 0; ci < db->col_order.at(tname).size(); ++ci)
-                        if (db->col_order.at(tname)[ci] == cn) { cid = (int)ci; break; }
-                }
-                SvdbVal v_seqno, v_cid, v_name, v_desc, v_coll, v_key;
-                v_seqno.type = SVDB_TYPE_INT; v_seqno.ival = seqno++;
-                v_cid.type   = SVDB_TYPE_INT; v_cid.ival   = cid;
-                v_name.type  = SVDB_TYPE_TEXT; v_name.sval  = cn;
-                v_desc.type  = SVDB_TYPE_INT; v_desc.ival  = 0;
-                v_coll.type  = SVDB_TYPE_TEXT; v_coll.sval  = "BINARY";
-                v_key.type   = SVDB_TYPE_INT; v_key.ival   = 1;
-                r->rows.push_back({v_seqno, v_cid, v_name, v_desc, v_coll, v_key});
-            }
+    /* PRAGMA index_info(idx_name) / PRAGMA index_xinfo(idx_name) */
+    if ((pname == "INDEX_INFO" || pname == "INDEX_XINFO") && !parg.empty()) {
+        bool xinfo = pname == "INDEX_XINFO";
+        if (xinfo) r->col_names = {"seqno", "cid", "name", "desc", "coll", "key"};
+        else       r->col_names = {"seqno", "cid", "name"};
+        std::string tname;
+        std::vector<std::string> key_cols;
+        bool pk_index = false;
+        if (!qry_lookup_index(db, parg, tname, key_cols, pk_index)) return SVDB_OK;
+        const std::vector<std::string> empty_order;
+        auto co_it = db->col_order.find(tname);
+        const auto &co = co_it != db->col_order.end() ? co_it->second : empty_order;
+        /* Expression terms are reported with cid -2 and no name */
+        auto cid_of = [&](const std::string &cn) {
+            for (size_t ci = 0; ci < co.size(); ++ci) if (co[ci] == cn) return (int)ci;
+            return -2;
+        };
+        /* index_xinfo also lists the auxiliary columns stored with each entry:
+         * the rest of the row for a clustered primary key, the primary key for
+         * a secondary index on a WITHOUT ROWID table */
+        std::vector<std::string> aux_cols;
+        auto opt_it = db->table_opts.find(tname);
+        auto pk_it = db->primary_keys.find(tname);
+        if (xinfo && opt_it != db->table_opts.end() && opt_it->second.without_rowid &&
+            pk_it != db->primary_keys.end()) {
+            const auto &src = pk_index ? co : pk_it->second;
+            for (const auto &cn : src)
+                if (std::find(key_cols.begin(), key_cols.end(), cn) == key_cols.end())
+                    aux_cols.push_back(cn);
+        }
+        int seqno = 0;
+        for (size_t i = 0; i < key_cols.size() + aux_cols.size(); ++i) {
+            bool is_key = i < key_cols.size();
+            const std::string &cn = is_key ? key_cols[i] : aux_cols[i - key_cols.size()];
+            SvdbVal v_seqno, v_cid, v_name;
+            v_seqno.type = SVDB_TYPE_INT; v_seqno.ival = seqno++;
+            v_cid.type   = SVDB_TYPE_INT; v_cid.ival   = cid_of(cn);
+            if (v_cid.ival != -2) { v_name.type = SVDB_TYPE_TEXT; v_name.sval = cn; }
+            if (!xinfo) { r->rows.push_back({v_seqno, v_cid, v_name}); continue; }
+            SvdbVal v_desc, v_coll, v_key;
+            v_desc.type  = SVDB_TYPE_INT; v_desc.ival  = 0;
+            v_coll.type  = SVDB_TYPE_TEXT; v_coll.sval  = "BINARY";
+            v_key.type   = SVDB_TYPE_INT; v_key.ival   = is_key ? 1 : 0;
+            r->rows.push_back({v_seqno, v_cid, v_name, v_desc, v_coll, v_key});
         }
         return SVDB_OK;
     }
@@ -6578,47 +7607,23 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
         r->col_names = {"table", "rowid", "parent", "fkid"};
         /* Scan all (or one) tables for FK violations */
         auto check_table_fks = [&](const std::string &tname) {
-            auto fk_it = db->fk_constraints.find(tname);
-            if (fk_it == db->fk_constraints.end()) return;
-            auto data_it = db->data.find(tname);
-            if (data_it == db->data.end()) return;
-            const auto &rows2 = data_it->second;
-            int64_t rowid2 = 1;
-            for (const auto &row2 : rows2) {
-                for (const auto &fk : fk_it->second) {
-                    auto cit = row2.find(fk.child_col);
-                    if (cit == row2.end() || cit->second.type == SVDB_TYPE_NULL) { ++rowid2; continue; }
-                    const std::string &pcol = fk.parent_col.empty() ? fk.child_col : fk.parent_col;
-                    bool found = false;
-                    auto parent_data_it = db->data.find(fk.parent_table);
-                    if (parent_data_it != db->data.end()) {
-                        for (const auto &pr : parent_data_it->second) {
-                            auto pit = pr.find(pcol);
-                            if (pit == pr.end()) continue;
-                            if (pit->second.type == cit->second.type) {
-                                if (pit->second.type == SVDB_TYPE_INT  && pit->second.ival == cit->second.ival) { found = true; break; }
-                                if (pit->second.type == SVDB_TYPE_REAL && pit->second.rval == cit->second.rval) { found = true; break; }
-                                if (pit->second.type == SVDB_TYPE_TEXT && pit->second.sval == cit->second.sval) { found = true; break; }
-                            }
-                        }
-                    }
-                    if (!found) {
-                        SvdbVal v_tbl, v_rowid, v_parent, v_fkid;
-                        v_tbl.type    = SVDB_TYPE_TEXT; v_tbl.sval    = tname;
-                        v_rowid.type  = SVDB_TYPE_INT;  v_rowid.ival  = rowid2;
-                        v_parent.type = SVDB_TYPE_TEXT; v_parent.sval = fk.parent_table;
-                        v_fkid.type   = SVDB_TYPE_INT;  v_fkid.ival   = 0;
-                        r->rows.push_back({v_tbl, v_rowid, v_parent, v_fkid});
-                    }
-                }
-                ++rowid2;
+            const auto &rows2 = db->data[tname];
+            const auto &fks = db->fk_constraints.at(tname);
+            for (const auto &v : svdb_fk_violations(db, tname)) {
+                SvdbVal v_tbl, v_rowid, v_parent, v_fkid;
+                v_tbl.type    = SVDB_TYPE_TEXT; v_tbl.sval    = tname;
+                auto rit = rows2[v.first].find(SVDB_ROWID_COLUMN);
+                if (rit != rows2[v.first].end()) v_rowid = rit->second; /* NULL for WITHOUT ROWID */
+                v_parent.type = SVDB_TYPE_TEXT; v_parent.sval = fks[v.second].parent_table;
+                v_fkid.type   = SVDB_TYPE_INT;  v_fkid.ival   = (int64_t)v.second;
+                r->rows.push_back({v_tbl, v_rowid, v_parent, v_fkid});
             }
         };
-        if (!parg.empty()) {
-            check_table_fks(parg);
-        } else {
-            for (auto &kv : db->fk_constraints) check_table_fks(kv.first);
-        }
+        std::vector<std::string> tables;
+        for (auto &kv : db->fk_constraints)
+            if (parg.empty() || qry_upper(kv.first) == qry_upper(parg)) tables.push_back(kv.first);
+        std::sort(tables.begin(), tables.end());
+        for (const auto &tname : tables) check_table_fks(tname);
         return SVDB_OK;
     }
 
@@ -6653,7 +7658,11 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
     if (pname == "WAL_MODE") {
         if (!parg.empty()) {
             std::string upper_arg = qry_upper(parg);
-            db->wal_mode = (upper_arg == "ON" || upper_arg == "WAL") ? "WAL" : "DELETE";
+            std::string err;
+            if (!storage_journal_mode(db, (upper_arg == "ON" || upper_arg == "WAL") ? "WAL" : "DELETE", err)) {
+                db->last_error = err;
+                return SVDB_ERR;
+            }
         }
         r->col_names = {"wal_mode"};
         SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = db->wal_mode;
@@ -6665,7 +7674,11 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
     if (pname == "JOURNAL_MODE") {
         if (!parg.empty()) {
             std::string upper_arg = qry_upper(parg);
-            db->wal_mode = (upper_arg == "WAL") ? "WAL" : "DELETE";
+            std::string err;
+            if (!storage_journal_mode(db, (upper_arg == "WAL") ? "WAL" : "DELETE", err)) {
+                db->last_error = err;
+                return SVDB_ERR;
+            }
         }
         r->col_names = {"journal_mode"};
         SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = db->wal_mode;
@@ -6695,13 +7708,47 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
 
     /* PRAGMA compression [= val] */
     if (pname == "COMPRESSION") {
-        if (!parg.empty()) db->compression = qry_upper(parg);
+        if (!parg.empty()) {
+            std::string algo = parg;
+            if (algo.size() >= 2 && (algo.front() == '\'' || algo.front() == '"') && algo.back() == algo.front())
+                algo = algo.substr(1, algo.size() - 2);
+            if (storage_codec_id(algo) < 0) {
+                db->last_error = "unknown compression algorithm: " + algo;
+                delete r;
+                *rows_out = nullptr;
+                return SVDB_ERR;
+            }
+            db->compression = qry_upper(algo);
+            ++db->data_gen; /* pages are rewritten with the new codec */
+            storage_note_kept(db);
+        }
         r->col_names = {"compression"};
         SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = db->compression;
         r->rows.push_back({v});
         return SVDB_OK;
     }
 
+    /* PRAGMA storage_mode [= ROW|COLUMNAR]: layout of tables created without USING */
+    if (pname == "STORAGE_MODE") {
+        if (!parg.empty()) {
+            std::string mode = parg;
+            if (mode.size() >= 2 && (mode.front() == '\'' || mode.front() == '"') && mode.back() == mode.front())
+                mode = mode.substr(1, mode.size() - 2);
+            mode = qry_upper(mode);
+            if (mode != "ROW" && mode != "COLUMNAR") {
+                db->last_error = "unknown storage mode: " + mode;
+                delete r;
+                *rows_out = nullptr;
+                return SVDB_ERR;
+            }
+            db->storage_mode = mode;
+        }
+        r->col_names = {"storage_mode"};
+        SvdbVal v; v.type = SVDB_TYPE_TEXT; v.sval = db->storage_mode;
+        r->rows.push_back({v});
+        return SVDB_OK;
+    }
+
     /* PRAGMA synchronous [= val] */
     if (pname == "SYNCHRONOUS") {
         if (!parg.empty()) db->synchronous = qry_upper(parg);
@@ -6716,6 +7763,19 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
         return SVDB_OK;
     }
 
+    /* PRAGMA defer_foreign_keys [= val]: cleared when the transaction ends */
+    if (pname == "DEFER_FOREIGN_KEYS") {
+        if (!parg.empty()) {
+            std::string up = qry_upper(parg);
+            db->defer_foreign_keys = (up == "ON" || up == "1" || up == "TRUE");
+            return SVDB_OK;
+        }
+        r->col_names = {"defer_foreign_keys"};
+        SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = db->defer_foreign_keys ? 1 : 0;
+        r->rows.push_back({v});
+        return SVDB_OK;
+    }
+
     /* PRAGMA foreign_keys [= val] */
     if (pname == "FOREIGN_KEYS") {
         if (!parg.empty()) {
@@ -6754,36 +7814,68 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
     /* PRAGMA memory_stats */
     if (pname == "MEMORY_STATS" || pname == "MEMORY_STATUS") {
         r->col_names = {"stat", "value"};
-        int64_t total_rows = 0;
-        for (auto &kv : db->data) total_rows += (int64_t)kv.second.size();
-        SvdbVal k1, v1, k2, v2;
-        k1.type = SVDB_TYPE_TEXT; k1.sval = "total_rows";  v1.type = SVDB_TYPE_INT; v1.ival = total_rows;
-        k2.type = SVDB_TYPE_TEXT; k2.sval = "table_count"; v2.type = SVDB_TYPE_INT; v2.ival = (int64_t)db->schema.size();
-        r->rows.push_back({k1, v1});
-        r->rows.push_back({k2, v2});
+        int64_t total_rows = 0, cells = 0, row_bytes = 0, layouts = 0, layout_bytes = 0;
+        for (auto &kv : db->data) {
+            total_rows += (int64_t)kv.second.size();
+            for (auto &row : kv.second) {
+                cells += (int64_t)row.size();
+                row_bytes += (int64_t)row.heap_bytes();
+            }
+        }
+        RowLayout::stats(layouts, layout_bytes);
+        auto add = [&](const char *name, int64_t val) {
+            SvdbVal k, v;
+            k.type = SVDB_TYPE_TEXT; k.sval = name;
+            v.type = SVDB_TYPE_INT;  v.ival = val;
+            r->rows.push_back({k, v});
+        };
+        add("total_rows", total_rows);
+        add("table_count", (int64_t)db->schema.size());
+        add("cell_count", cells);
+        add("row_bytes", row_bytes);            /* values, strings and row headers */
+        add("bytes_per_row", total_rows ? row_bytes / total_rows : 0);
+        add("row_layouts", layouts);            /* shared by every open database */
+        add("layout_bytes", layout_bytes);
         return SVDB_OK;
     }
 
-    /* PRAGMA storage_info */
+    /* PRAGMA storage_info: per table, the pages its rows take on disk and
+     * how well its codec compresses them */
     if (pname == "STORAGE_INFO") {
-        r->col_names = {"table_name", "row_count", "col_count"};
-        for (auto &kv : db->schema) {
-            SvdbVal v_tbl, v_rows, v_cols;
-            v_tbl.type  = SVDB_TYPE_TEXT; v_tbl.sval  = kv.first;
-            v_rows.type = SVDB_TYPE_INT;
-            auto data_it = db->data.find(kv.first);
-            v_rows.ival = (data_it != db->data.end()) ? (int64_t)data_it->second.size() : 0;
+        r->col_names = {"table_name", "row_count", "col_count", "compression", "page_count",
+                        "raw_bytes", "stored_bytes", "compression_ratio"};
+        std::vector<std::string> names;
+        for (auto &kv : db->schema)
+            if (db->data.count(kv.first)) names.push_back(kv.first);
+        std::sort(names.begin(), names.end());
+        for (auto &name : names) {
+            StorageStats st = storage_table_stats(db, name);
+            SvdbVal v_tbl, v_rows, v_cols, v_codec, v_pages, v_raw, v_stored, v_ratio;
+            v_tbl.type  = SVDB_TYPE_TEXT; v_tbl.sval  = name;
+            v_rows.type = SVDB_TYPE_INT;  v_rows.ival = (int64_t)db->data[name].size();
             v_cols.type = SVDB_TYPE_INT;
-            auto col_it = db->col_order.find(kv.first);
+            auto col_it = db->col_order.find(name);
             v_cols.ival = (col_it != db->col_order.end()) ? (int64_t)col_it->second.size() : 0;
-            r->rows.push_back({v_tbl, v_rows, v_cols});
+            v_codec.type  = SVDB_TYPE_TEXT; v_codec.sval  = storage_codec_name(st.codec);
+            v_pages.type  = SVDB_TYPE_INT;  v_pages.ival  = st.pages;
+            v_raw.type    = SVDB_TYPE_INT;  v_raw.ival    = st.raw_bytes;
+            v_stored.type = SVDB_TYPE_INT;  v_stored.ival = st.stored_bytes;
+            v_ratio.type  = SVDB_TYPE_REAL;
+            v_ratio.rval  = st.stored_bytes > 0 ? (double)st.raw_bytes / (double)st.stored_bytes : 1.0;
+            r->rows.push_back({v_tbl, v_rows, v_cols, v_codec, v_pages, v_raw, v_stored, v_ratio});
         }
         return SVDB_OK;
     }
 
-    /* PRAGMA page_size / page_count / freelist_count: return stubs */
+    /* PRAGMA page_size / page_count / freelist_count */
     if (pname == "PAGE_SIZE") {
-        if (!parg.empty()) { try { db->page_size_val = std::stoll(parg); } catch (...) {} }
+        if (!parg.empty()) {
+            try {
+                db->page_size_val = std::stoll(parg);
+                ++db->data_gen;
+                storage_note_kept(db);
+            } catch (...) {}
+        }
         r->col_names = {"page_size"};
         SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = db->page_size_val;
         r->rows.push_back({v});
@@ -6791,20 +7883,47 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
     }
     if (pname == "PAGE_COUNT") {
         r->col_names = {"page_count"};
-        SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = 1;
+        SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = 1; /* the header page */
+        for (auto &kv : db->data) v.ival += storage_table_stats(db, kv.first).pages;
+        v.ival += storage_free_pages(db);
         r->rows.push_back({v});
         return SVDB_OK;
     }
     if (pname == "FREELIST_COUNT") {
         r->col_names = {"freelist_count"};
-        SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = 0;
+        SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = storage_free_pages(db);
         r->rows.push_back({v});
         return SVDB_OK;
     }
 
-    /* PRAGMA auto_vacuum [= val] */
+    /* PRAGMA incremental_vacuum [(N)] — with auto_vacuum=INCREMENTAL, give
+     * back up to N free pages (all of them without N) */
+    if (pname == "INCREMENTAL_VACUUM") {
+        int64_t n = 0;
+        if (!parg.empty()) { try { n = std::stoll(parg); } catch (...) {} }
+        std::string err;
+        if (db->auto_vacuum_val == AUTO_VACUUM_INCREMENTAL && vacuum_incremental(db, n, err) < 0) {
+            db->last_error = err;
+            return SVDB_ERR;
+        }
+        return SVDB_OK;
+    }
+
+    /* PRAGMA auto_vacuum [= NONE | FULL | INCREMENTAL | 0 | 1 | 2] */
     if (pname == "AUTO_VACUUM") {
-        if (!parg.empty()) { try { db->auto_vacuum_val = std::stoll(parg); } catch (...) {} }
+        if (!parg.empty()) {
+            std::string mode = qry_upper(parg);
+            int64_t val = -1;
+            if (mode == "NONE") val = AUTO_VACUUM_NONE;
+            else if (mode == "FULL") val = AUTO_VACUUM_FULL;
+            else if (mode == "INCREMENTAL") val = AUTO_VACUUM_INCREMENTAL;
+            else { try { val = std::stoll(parg); } catch (...) {} }
+            if (val >= AUTO_VACUUM_NONE && val <= AUTO_VACUUM_INCREMENTAL) {
+                db->auto_vacuum_val = val;
+                ++db->data_gen;
+                storage_note_kept(db);
+            }
+        }
         r->col_names = {"auto_vacuum"};
         SvdbVal v; v.type = SVDB_TYPE_INT; v.ival = db->auto_vacuum_val;
         r->rows.push_back({v});
@@ -7022,19 +8141,25 @@ svdb_code_t svdb_query_pragma(svdb_db_t *db, const std::string &sql,
         return SVDB_OK;
     }
 
-    /* PRAGMA wal_checkpoint [(mode)] — perform WAL checkpoint
-     * Modes: passive, full, truncate (default: passive)
-     * Returns: (busy, log, checkpointed) — stub values for in-memory DB
+    /* PRAGMA wal_checkpoint [(mode)] — fold the WAL into the database file
+     * Modes: passive, full, restart, truncate (default: passive); truncate
+     * also empties the log
+     * Returns: (busy, log, checkpointed) — (0,0,0) outside WAL mode
      */
     if (pname == "WAL_CKPT" || pname == "WAL_CHECKPOINT") {
         std::string mode = qry_upper(parg);
         if (mode.empty()) mode = "PASSIVE";
-        /* In delete mode (no WAL), checkpoint is a no-op but returns (0,0,0) */
+        StorageCheckpoint ck;
+        std::string err;
+        if (!storage_checkpoint(db, mode, ck, err)) {
+            db->last_error = "disk I/O error: " + err;
+            return SVDB_ERR;
+        }
         r->col_names = {"busy", "log", "checkpointed"};
         SvdbVal v_busy, v_log, v_ckpt;
-        v_busy.type = SVDB_TYPE_INT; v_busy.ival = 0;
-        v_log.type  = SVDB_TYPE_INT; v_log.ival  = 0;
-        v_ckpt.type = SVDB_TYPE_INT; v_ckpt.ival = 0;
+        v_busy.type = SVDB_TYPE_INT; v_busy.ival = ck.busy;
+        v_log.type  = SVDB_TYPE_INT; v_log.ival  = ck.log;
+        v_ckpt.type = SVDB_TYPE_INT; v_ckpt.ival = ck.checkpointed;
         r->rows.push_back({v_busy, v_log, v_ckpt});
         return SVDB_OK;
     }
@@ -7058,6 +8183,8 @@ svdb_code_t svdb_query(svdb_db_t *db, const char *sql, svdb_rows_t **rows) {
         std::string su = qry_upper(s.substr(0, 6));
         if (su == "PRAGMA") return svdb_query_pragma(db, s, rows);
     }
+    if (s.size() > 19 && qry_upper(s.substr(0, 19)) == "EXPLAIN QUERY PLAN ")
+        return qry_explain_query_plan(db, qry_trim(s.substr(19)), rows);
     /* Dispatch BACKUP DATABASE TO 'path' */
     if (s.size() >= 6 && qry_upper(s.substr(0, 6)) == "BACKUP") {
         /* Extract destination path from: BACKUP DATABASE TO 'path' */
@@ -7097,8 +8224,8 @@ svdb_code_t svdb_query(svdb_db_t *db, const char *sql, svdb_rows_t **rows) {
      * svdb_exec acquires db->mu; we must release our lock first to prevent
      * re-entrant deadlock on the non-recursive std::mutex. */
     {
-        const char *dml_keywords[] = {"INSERT", "UPDATE", "DELETE", nullptr};
-        const char *ddl_keywords[] = {"CREATE", "DROP", "ALTER", nullptr};
+        const char *dml_keywords[] = {"INSERT", "UPDATE", "DELETE", "MERGE", nullptr};
+        const char *ddl_keywords[] = {"CREATE", "DROP", "ALTER", "SET", "VACUUM", "COPY", nullptr};
 
         auto starts_with_kw = [&](const char *kw) -> bool {
             size_t klen = strlen(kw);
@@ -7115,9 +8242,28 @@ svdb_code_t svdb_query(svdb_db_t *db, const char *sql, svdb_rows_t **rows) {
             if (starts_with_kw(*ns)) { is_ddl = true; break; }
         }
 
+        if ((is_dml || is_ddl) && svdb_write_refused(db, s)) return SVDB_READONLY;
         if (is_dml) {
             std::string ret_clause, sql_no_ret;
             if (qry_extract_returning(s, ret_clause, sql_no_ret)) {
+                if (starts_with_kw("MERGE")) {
+                    /* MERGE reports exactly the rows its actions touched */
+                    std::vector<Row> changed;
+                    std::string tname;
+                    {
+                        SvdbExecScope scope(db);
+                        if (svdb_merge_internal(db, sql_no_ret, nullptr, &changed, &tname) != SVDB_OK)
+                            return SVDB_ERR;
+                    }
+                    std::string err;
+                    if (!storage_commit(db, err)) {
+                        db->last_error = "disk I/O error: " + err;
+                        return SVDB_ERR;
+                    }
+                    *rows = qry_build_returning_result(qry_split_returning_exprs(ret_clause),
+                                                       changed, db->col_order[tname]);
+                    return (*rows) ? SVDB_OK : SVDB_NOMEM;
+                }
                 /* DML with RETURNING: execute DML then return RETURNING rows */
                 std::string ret_kw = qry_upper(s.substr(0, 6).substr(0, s.find(' ')));
                 /* Find table name */
@@ -7171,7 +8317,25 @@ svdb_code_t svdb_query(svdb_db_t *db, const char *sql, svdb_rows_t **rows) {
 
                 std::vector<Row> affected_rows;
                 std::string su2 = qry_upper(sql_no_ret.substr(0, 6));
-                if (su2 == "INSERT") {
+                /* WITHOUT ROWID tables are sorted by key and have no rowid:
+                 * match rows by primary key instead */
+                const std::vector<std::string> *ckey = nullptr;
+                {
+                    auto oit = db->table_opts.find(tname);
+                    auto pit = db->primary_keys.find(tname);
+                    if (oit != db->table_opts.end() && oit->second.without_rowid &&
+                        pit != db->primary_keys.end()) ckey = &pit->second;
+                }
+                auto key_less = [&](const Row &a, const Row &b) {
+                    return svdb_row_key_cmp(a, b, *ckey) < 0;
+                };
+                if (ckey && (su2 == "INSERT" || su2 == "DELETE")) {
+                    const auto &from = su2 == "INSERT" ? after_snap : before_snap;
+                    const auto &other = su2 == "INSERT" ? before_snap : after_snap;
+                    for (const auto &r2 : from)
+                        if (!std::binary_search(other.begin(), other.end(), r2, key_less))
+                            affected_rows.push_back(r2);
+                } else if (su2 == "INSERT") {
                     /* New rows appended at the end */
                     for (size_t i = before_snap.size(); i < after_snap.size(); ++i)
                         affected_rows.push_back(after_snap[i]);
@@ -7245,7 +8409,9 @@ svdb_code_t svdb_query(svdb_db_t *db, const char *sql, svdb_rows_t **rows) {
                             svdb_exec(db, stmts[i].c_str(), &res);
                         }
                         lk.lock();
-                        return svdb_query_internal(db, stmts.back(), rows);
+                        svdb_code_t rc = svdb_query_internal(db, stmts.back(), rows);
+                        if (rc == SVDB_OK && *rows) describe_columns(db, stmts.back(), *rows);
+                        return rc;
                     }
                 }
             }
@@ -7256,7 +8422,9 @@ svdb_code_t svdb_query(svdb_db_t *db, const char *sql, svdb_rows_t **rows) {
             return (*rows) ? SVDB_OK : SVDB_NOMEM;
         }
     }
-    return svdb_query_internal(db, s, rows);
+    svdb_code_t rc = svdb_query_internal(db, s, rows);
+    if (rc == SVDB_OK && *rows) describe_columns(db, s, *rows);
+    return rc;
 }
 
 } /* extern "C" */
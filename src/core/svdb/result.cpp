@@ -15,6 +15,32 @@ const char *svdb_rows_column_name(svdb_rows_t *rows, int col) {
     return rows->col_names[col].c_str();
 }
 
+static const SvdbColumnMeta *column_meta(svdb_rows_t *rows, int col) {
+    if (!rows || col < 0 || col >= static_cast<int>(rows->col_meta.size()))
+        return nullptr;
+    return &rows->col_meta[col];
+}
+
+const char *svdb_rows_column_decltype(svdb_rows_t *rows, int col) {
+    const SvdbColumnMeta *m = column_meta(rows, col);
+    return m && !m->decl_type.empty() ? m->decl_type.c_str() : nullptr;
+}
+
+const char *svdb_rows_column_table(svdb_rows_t *rows, int col) {
+    const SvdbColumnMeta *m = column_meta(rows, col);
+    return m && !m->table.empty() ? m->table.c_str() : nullptr;
+}
+
+const char *svdb_rows_column_origin(svdb_rows_t *rows, int col) {
+    const SvdbColumnMeta *m = column_meta(rows, col);
+    return m && !m->origin.empty() ? m->origin.c_str() : nullptr;
+}
+
+int svdb_rows_column_nullable(svdb_rows_t *rows, int col) {
+    const SvdbColumnMeta *m = column_meta(rows, col);
+    return m ? m->nullable : -1;
+}
+
 int svdb_rows_next(svdb_rows_t *rows) {
     if (!rows) return 0;
     rows->cursor++;
@@ -0,0 +1,187 @@
+/*
+ * row.cpp — interned row layouts and compact cell strings
+ *
+ * Every Row points at a RowLayout naming its keys by position. Layouts form
+ * a tree rooted at the empty layout: adding key k to layout L follows (or
+ * creates) the edge L.next[k], so all rows built by adding the same keys in
+ * the same order end up on one shared layout. A table's rows are built from
+ * its col_order, so a table normally has a single layout.
+ *
+ * A layout is held by each row using it and by each child layout, and
+ * dropping a reference is a plain decrement: rows built and dropped one at a
+ * time, as a scan does, keep finding their layout instead of freeing and
+ * rebuilding it. A layout nobody holds has no children, so it is a leaf of
+ * the tree; sweep() frees such leaves under the intern lock whenever the
+ * table has doubled since the last sweep. A layout is only taken back from
+ * zero under the lock too, so the sweep never frees one in use.
+ */
+#include "svdb_types.h"
+#include "../DS/varint.h"
+#include <algorithm>
+#include <cstring>
+#include <new>
+#include <shared_mutex>
+#include <stdexcept>
+
+namespace {
+
+std::shared_mutex &layout_mu() {
+    static std::shared_mutex *mu = new std::shared_mutex();
+    return *mu;
+}
+int64_t g_layout_count = 1;
+int64_t g_layout_bytes = 0;
+
+RowLayout *root_layout() {
+    static RowLayout *root = new RowLayout();
+    return root;
+}
+
+size_t string_heap(const std::string &s) {
+    static const size_t sso = std::string().capacity();
+    return s.capacity() > sso ? s.capacity() + 1 : 0;
+}
+
+int64_t layout_bytes(const RowLayout *l) {
+    int64_t n = (int64_t)sizeof(RowLayout);
+    for (auto &k : l->keys)
+        n += (int64_t)(sizeof(std::string) * 2 + sizeof(uint32_t) + 2 * string_heap(k));
+    return n;
+}
+
+int64_t g_sweep_at = 256;
+
+/* Free the unheld layouts below l, deepest first, returning whether l itself
+ * is now unheld. The intern lock is held. */
+bool sweep(const RowLayout *l) {
+    for (auto it = l->next.begin(); it != l->next.end();) {
+        const RowLayout *child = it->second;
+        if (sweep(child)) {
+            --g_layout_count;
+            g_layout_bytes -= layout_bytes(child);
+            delete child;
+            it = l->next.erase(it);
+            RowLayout::release(l);
+        } else {
+            ++it;
+        }
+    }
+    return l->parent && l->refs.load(std::memory_order_acquire) == 0;
+}
+
+void sweep_all() {
+    sweep(root_layout());
+    g_sweep_at = std::max<int64_t>(256, 2 * g_layout_count);
+}
+
+} // namespace
+
+void SvdbStr::assign(const char *s, size_t n) {
+    uint64_t w = 0;
+    if (n > 0 && n <= 6) {
+        w = (uint64_t)((n << 1) | 1);
+        std::memcpy((char *)&w + kInline, s, n);
+    } else if (n > 0) {
+        uint8_t len[9];
+        int h = svdb_put_varint(len, sizeof len, (int64_t)n);
+        char *p = (char *)std::malloc(sizeof(uint32_t) + (size_t)h + n + 1);
+        if (!p) throw std::bad_alloc();
+        new (p) std::atomic<uint32_t>(1);
+        std::memcpy(p + sizeof(uint32_t), len, (size_t)h);
+        std::memcpy(p + sizeof(uint32_t) + h, s, n);
+        p[sizeof(uint32_t) + h + n] = '\0';
+        w = (uint64_t)(uintptr_t)p;
+    }
+    release();
+    w_ = w;
+}
+
+std::string_view SvdbStr::view_long() const {
+    int64_t n = 0;
+    int used = 0;
+    const char *p = block() + sizeof(uint32_t);
+    svdb_get_varint((const uint8_t *)p, 9, &n, &used);
+    return {p + used, (size_t)n};
+}
+
+size_t SvdbStr::heap_bytes() const {
+    if (!heap()) return 0;
+    size_t n = size();
+    return sizeof(uint32_t) + (size_t)svdb_varint_len((int64_t)n) + n + 1;
+}
+
+const RowLayout *RowLayout::empty() {
+    return root_layout();
+}
+
+const RowLayout *RowLayout::with(const std::string &key) const {
+    {
+        std::shared_lock<std::shared_mutex> lk(layout_mu());
+        auto it = next.find(key);
+        if (it != next.end()) {
+            it->second->retain();
+            return it->second;
+        }
+    }
+    std::unique_lock<std::shared_mutex> lk(layout_mu());
+    if (g_layout_count >= g_sweep_at && !next.count(key)) sweep_all();
+    const RowLayout *&child = next[key];
+    if (!child) {
+        RowLayout *l = new RowLayout();
+        l->keys = keys;
+        l->keys.push_back(key);
+        for (size_t i = 0; i < l->keys.size(); ++i) l->pos[l->keys[i]] = (uint32_t)i;
+        l->parent = this;
+        retain();
+        child = l;
+        ++g_layout_count;
+        g_layout_bytes += layout_bytes(l);
+    }
+    child->retain();
+    return child;
+}
+
+const RowLayout *RowLayout::without(size_t i) const {
+    const RowLayout *l = empty();
+    for (size_t k = 0; k < keys.size(); ++k) {
+        if (k == i) continue;
+        const RowLayout *n = l->with(keys[k]);
+        release(l);
+        l = n;
+    }
+    return l;
+}
+
+void RowLayout::stats(int64_t &count, int64_t &bytes) {
+    std::unique_lock<std::shared_mutex> lk(layout_mu());
+    sweep_all();
+    count = g_layout_count;
+    bytes = g_layout_bytes;
+}
+
+SvdbVal &Row::at(const std::string &key) {
+    int i = layout_->find(key);
+    if (i < 0) throw std::out_of_range("Row::at: " + key);
+    return vals_[(size_t)i];
+}
+
+const SvdbVal &Row::at(const std::string &key) const {
+    int i = layout_->find(key);
+    if (i < 0) throw std::out_of_range("Row::at: " + key);
+    return vals_[(size_t)i];
+}
+
+size_t Row::heap_bytes() const {
+    size_t n = sizeof(Row) + vals_.capacity() * sizeof(SvdbVal);
+    for (auto &v : vals_) n += v.sval.heap_bytes();
+    return n;
+}
+
+void ColumnSlots::resolve(const RowLayout *l) {
+    l->retain();
+    if (layout_) RowLayout::release(layout_);
+    layout_ = l;
+    pos_.resize(names_.size());
+    for (size_t i = 0; i < names_.size(); ++i)
+        pos_[i] = names_[i].empty() ? -1 : l->find(names_[i]);
+}
@@ -0,0 +1,873 @@
+/*
+ * sqljson.cpp — SQL/JSON query functions and JSON_TABLE
+ *
+ *   JSON_VALUE(doc, path [RETURNING type] [behaviour ON EMPTY] [behaviour ON ERROR])
+ *   JSON_QUERY(doc, path [RETURNING type] [WITH [CONDITIONAL|UNCONDITIONAL] [ARRAY] WRAPPER |
+ *              WITHOUT [ARRAY] WRAPPER] [KEEP|OMIT QUOTES] [behaviour ON EMPTY] [behaviour ON ERROR])
+ *   JSON_EXISTS(doc, path [TRUE|FALSE|UNKNOWN|ERROR ON ERROR])
+ *   JSON_TABLE(doc, path COLUMNS (column, ...) [ERROR|EMPTY ON ERROR]) [AS] alias
+ *
+ * Paths use the SQL/JSON path language (svdb_jsonb_path_query). A behaviour is
+ * NULL, ERROR, DEFAULT expr, or for JSON_QUERY also EMPTY [ARRAY] / EMPTY
+ * OBJECT; without one, an empty result or an error gives NULL (FALSE for
+ * JSON_EXISTS). Malformed paths are always errors.
+ *
+ * JSON_TABLE columns are
+ *   name type [FORMAT JSON] [PATH path] [wrapper] [quotes] [behaviours]
+ *   name type EXISTS [PATH path] [behaviour ON ERROR]
+ *   name FOR ORDINALITY
+ *   NESTED [PATH] path COLUMNS (column, ...)
+ * A column reads $."name" unless given a PATH. JSON/JSONB and FORMAT JSON
+ * columns behave like JSON_QUERY, the others like JSON_VALUE. A nested path
+ * gives one row per item it selects, joined to the row of its parent (which is
+ * kept, with NULLs, when there are none); sibling nested paths give their rows
+ * one after the other.
+ *
+ * JSON_TABLE is run before the SELECT that reads it: its rows go into a
+ * temporary table the SELECT is rewritten to read. When other tables come
+ * first in the FROM clause, the table holds the rows of every document they
+ * supply, tagged with a hidden __doc column, and is joined to them on it.
+ */
+#include "svdb_sqljson.h"
+#include "svdb_util.h"
+#include "../../ext/json/json.h"
+
+#include <cstdlib>
+#include <cerrno>
+#include <cstring>
+#include <set>
+
+/* Implemented in query.cpp */
+SvdbVal svdb_eval_expr_in_row(const std::string &expr, const Row &row,
+                              const std::vector<std::string> &col_order);
+svdb_code_t svdb_query_internal(svdb_db_t *db, const std::string &sql, svdb_rows_t **rows_out);
+
+/* ── Clauses ────────────────────────────────────────────────────── */
+
+/* What to return for an empty result or an error */
+struct SjBehavior {
+    enum Kind { UNSET, NUL, ERROR, DEFAULT, EMPTY_ARRAY, EMPTY_OBJECT, TRUE_, FALSE_, UNKNOWN };
+    Kind kind = UNSET;
+    std::string expr;           /* DEFAULT expression */
+};
+
+enum SjMode { SJ_VALUE, SJ_QUERY, SJ_EXISTS };
+
+/* A path and the clauses that follow it */
+struct SjSpec {
+    std::string path;           /* path expression (SQL text) */
+    std::string returning;      /* upper-case type name, "" if none */
+    bool format_json = false;
+    int  wrapper = 0;           /* 0 WITHOUT, 1 CONDITIONAL, 2 UNCONDITIONAL */
+    bool omit_quotes = false;
+    SjBehavior on_empty, on_error;
+};
+
+static bool sj_is_space(char c) { return isspace((unsigned char)c) != 0; }
+
+/* Split at top-level occurrences of sep, outside quotes and parentheses */
+static std::vector<std::string> sj_split(const std::string &s, char sep) {
+    std::vector<std::string> out;
+    int depth = 0;
+    char quote = 0;
+    size_t start = 0;
+    for (size_t i = 0; i <= s.size(); ++i) {
+        char c = i < s.size() ? s[i] : sep;
+        if (quote) {
+            if (c == quote) quote = 0;
+            continue;
+        }
+        if (c == '\'' || c == '"' || c == '`') { quote = c; continue; }
+        if (c == '(') ++depth;
+        else if (c == ')') { if (depth > 0) --depth; }
+        else if (depth == 0 && (c == sep || (sep == ' ' && sj_is_space(c)) || i == s.size())) {
+            std::string part = svdb_str_trim(s.substr(start, i - start));
+            if (sep != ' ' || !part.empty()) out.push_back(part);
+            start = i + 1;
+        }
+    }
+    return out;
+}
+
+/* Position of keyword kw (upper case) as a top-level word of s, or npos */
+static size_t sj_find_keyword(const std::string &s, const std::string &kw, size_t from = 0) {
+    std::string su = svdb_str_upper(s);
+    int depth = 0;
+    char quote = 0;
+    for (size_t i = from; i < su.size(); ++i) {
+        char c = su[i];
+        if (quote) {
+            if (c == quote) quote = 0;
+            continue;
+        }
+        if (c == '\'' || c == '"' || c == '`') { quote = c; continue; }
+        if (c == '(') { ++depth; continue; }
+        if (c == ')') { if (depth > 0) --depth; continue; }
+        if (depth > 0 || su.compare(i, kw.size(), kw) != 0) continue;
+        bool left = i == 0 || !(isalnum((unsigned char)su[i - 1]) || su[i - 1] == '_');
+        size_t e = i + kw.size();
+        bool right = e >= su.size() || !(isalnum((unsigned char)su[e]) || su[e] == '_');
+        if (left && right) return i;
+    }
+    return std::string::npos;
+}
+
+static std::string sj_join(const std::vector<std::string> &w, size_t from, size_t to) {
+    std::string out;
+    for (size_t i = from; i < to && i < w.size(); ++i) {
+        if (!out.empty()) out += ' ';
+        out += w[i];
+    }
+    return out;
+}
+
+static bool sj_clause_word(const std::string &u) {
+    static const char *kws[] = {"RETURNING", "DEFAULT", "NULL", "ERROR", "EMPTY", "WITH", "WITHOUT",
+                                "KEEP", "OMIT", "TRUE", "FALSE", "UNKNOWN", "FORMAT", "PATH",
+                                "EXISTS", "PASSING", nullptr};
+    for (int i = 0; kws[i]; ++i)
+        if (u == kws[i]) return true;
+    return false;
+}
+
+/* Parse the clauses in words w[i..] into s */
+static bool sj_parse_clauses(const std::vector<std::string> &w, size_t i, SjMode mode,
+                             SjSpec &s, std::string &err) {
+    auto up = [&](size_t k) { return k < w.size() ? svdb_str_upper(w[k]) : std::string(); };
+    while (i < w.size()) {
+        std::string u = up(i);
+        if (u == "RETURNING") {
+            size_t j = ++i;
+            while (i < w.size() && !sj_clause_word(up(i))) ++i;
+            if (i == j) { err = "RETURNING needs a type"; return false; }
+            s.returning = svdb_str_upper(sj_join(w, j, i));
+            continue;
+        }
+        if (u == "FORMAT") {
+            if (up(i + 1) != "JSON") { err = "expected JSON after FORMAT"; return false; }
+            s.format_json = true;
+            i += 2;
+            continue;
+        }
+        if (u == "WITH" || u == "WITHOUT") {
+            size_t j = i + 1;
+            s.wrapper = 0;
+            if (u == "WITH") {
+                s.wrapper = 2;
+                if (up(j) == "CONDITIONAL") { s.wrapper = 1; ++j; }
+                else if (up(j) == "UNCONDITIONAL") ++j;
+            }
+            if (up(j) == "ARRAY") ++j;
+            if (up(j) != "WRAPPER") { err = "expected WRAPPER after " + u; return false; }
+            if (mode != SJ_QUERY) { err = "wrapper options are only allowed for JSON_QUERY"; return false; }
+            i = j + 1;
+            continue;
+        }
+        if (u == "KEEP" || u == "OMIT") {
+            if (up(i + 1) != "QUOTES") { err = "expected QUOTES after " + u; return false; }
+            if (mode != SJ_QUERY) { err = "quote options are only allowed for JSON_QUERY"; return false; }
+            s.omit_quotes = u == "OMIT";
+            i += 2;
+            if (up(i) == "ON" && up(i + 1) == "SCALAR" && up(i + 2) == "STRING") i += 3;
+            continue;
+        }
+        /* behaviour ON EMPTY | ON ERROR */
+        SjBehavior b;
+        size_t j = i + 1;
+        if (u == "NULL") b.kind = SjBehavior::NUL;
+        else if (u == "ERROR") b.kind = SjBehavior::ERROR;
+        else if (u == "TRUE") b.kind = SjBehavior::TRUE_;
+        else if (u == "FALSE") b.kind = SjBehavior::FALSE_;
+        else if (u == "UNKNOWN") b.kind = SjBehavior::UNKNOWN;
+        else if (u == "EMPTY") {
+            b.kind = SjBehavior::EMPTY_ARRAY;
+            if (up(j) == "ARRAY") ++j;
+            else if (up(j) == "OBJECT") { b.kind = SjBehavior::EMPTY_OBJECT; ++j; }
+        } else if (u == "DEFAULT") {
+            b.kind = SjBehavior::DEFAULT;
+            while (j < w.size() && up(j) != "ON") ++j;
+            b.expr = sj_join(w, i + 1, j);
+            if (b.expr.empty()) { err = "DEFAULT needs an expression"; return false; }
+        } else {
+            err = "syntax error near \"" + w[i] + "\"";
+            return false;
+        }
+        bool logical = b.kind == SjBehavior::TRUE_ || b.kind == SjBehavior::FALSE_ ||
+                       b.kind == SjBehavior::UNKNOWN;
+        bool query_only = b.kind == SjBehavior::EMPTY_ARRAY || b.kind == SjBehavior::EMPTY_OBJECT;
+        if (up(j) != "ON" || (up(j + 1) != "EMPTY" && up(j + 1) != "ERROR")) {
+            err = "expected ON EMPTY or ON ERROR after " + u;
+            return false;
+        }
+        bool on_empty = up(j + 1) == "EMPTY";
+        if ((mode == SJ_EXISTS) != logical || (mode == SJ_EXISTS && on_empty) ||
+            (query_only && mode != SJ_QUERY) || (mode == SJ_EXISTS && b.kind == SjBehavior::DEFAULT)) {
+            if (!(mode == SJ_EXISTS && b.kind == SjBehavior::ERROR && !on_empty)) {
+                err = "invalid " + u + " ON " + up(j + 1) + " behaviour";
+                return false;
+            }
+        }
+        (on_empty ? s.on_empty : s.on_error) = b;
+        i = j + 2;
+    }
+    return true;
+}
+
+/* ── Evaluation ─────────────────────────────────────────────────── */
+
+bool sqljson_doc(const SvdbVal &v, std::string &jsonb) {
+    if (v.type == SVDB_TYPE_BLOB &&
+        svdb_jsonb_valid((const unsigned char *)v.sval.data(), v.sval.size())) {
+        jsonb = v.sval;
+        return true;
+    }
+    std::string text;
+    if (v.type == SVDB_TYPE_TEXT || v.type == SVDB_TYPE_BLOB) text = v.sval;
+    else if (v.type == SVDB_TYPE_INT) text = std::to_string(v.ival);
+    else if (v.type == SVDB_TYPE_REAL) {
+        char buf[64];
+        snprintf(buf, sizeof(buf), "%.17g", v.rval);
+        text = buf;
+    } else return false;
+    size_t n = 0;
+    unsigned char *b = svdb_jsonb_from_text(text.c_str(), &n);
+    if (!b) return false;
+    jsonb.assign((const char *)b, n);
+    svdb_json_free((char *)b);
+    return true;
+}
+
+static std::string sj_type(const std::string &elem) {
+    const char *t = svdb_jsonb_type((const unsigned char *)elem.data(), elem.size());
+    return t ? t : "";
+}
+
+static std::string sj_atom(const std::string &elem) {
+    char *a = svdb_jsonb_atom((const unsigned char *)elem.data(), elem.size());
+    if (!a) return "";
+    std::string out(a);
+    svdb_json_free(a);
+    return out;
+}
+
+static std::string sj_text(const std::string &elem) {
+    char *t = svdb_jsonb_to_text((const unsigned char *)elem.data(), elem.size());
+    if (!t) return "";
+    std::string out(t);
+    svdb_json_free(t);
+    return out;
+}
+
+SvdbVal sqljson_scalar(const std::string &elem) {
+    std::string t = sj_type(elem);
+    SvdbVal v;
+    if (t.empty() || t == "null") return v;
+    if (t == "true" || t == "false") {
+        v.type = SVDB_TYPE_INT;
+        v.ival = t == "true";
+        return v;
+    }
+    std::string a = sj_atom(elem);
+    if (t == "integer") {
+        errno = 0;
+        char *end = nullptr;
+        long long iv = strtoll(a.c_str(), &end, 10);
+        if (errno == 0 && end && *end == '\0') {
+            v.type = SVDB_TYPE_INT;
+            v.ival = iv;
+            return v;
+        }
+        t = "real";
+    }
+    if (t == "real") {
+        v.type = SVDB_TYPE_REAL;
+        v.rval = strtod(a.c_str(), nullptr);
+        return v;
+    }
+    v.type = SVDB_TYPE_TEXT;
+    v.sval = a;
+    return v;
+}
+
+/* Items path selects in doc: 1, 0 on a data error, -1 on a malformed path */
+static int sj_items(const std::string &doc, const std::string &path,
+                    std::vector<std::string> &items, std::string &err) {
+    char *e = nullptr;
+    svdb_jsonb_seq_t *seq = svdb_jsonb_path_query((const unsigned char *)doc.data(), doc.size(),
+                                                  path.c_str(), &e);
+    if (!seq) {
+        err = e ? e : "JSON path query failed";
+        svdb_json_free(e);
+        return err.compare(0, 10, "JSON path:") == 0 ? -1 : 0;
+    }
+    for (int i = 0; i < seq->count; ++i)
+        items.emplace_back((const char *)seq->items[i], seq->lens[i]);
+    svdb_jsonb_seq_free(seq);
+    return 1;
+}
+
+/* Convert JSON scalar elem to the RETURNING type of a JSON_VALUE */
+static bool sj_cast(const std::string &elem, const std::string &type, SvdbVal &out, std::string &err) {
+    out = sqljson_scalar(elem);
+    if (type.empty() || out.type == SVDB_TYPE_NULL) return true;
+    std::string jt = sj_type(elem), a = sj_atom(elem);
+    auto bad = [&](const char *what) {
+        err = std::string("invalid input syntax for type ") + what + ": \"" + a + "\"";
+        return false;
+    };
+    if (type.find("JSON") != std::string::npos) {
+        out = SvdbVal{SVDB_TYPE_TEXT, 0, 0.0, sj_text(elem)};
+        return true;
+    }
+    if (type.find("BOOL") != std::string::npos) {
+        std::string u = svdb_str_upper(a);
+        if (jt == "true" || u == "TRUE" || u == "T" || u == "1") out = SvdbVal{SVDB_TYPE_INT, 1, 0.0, {}};
+        else if (jt == "false" || u == "FALSE" || u == "F" || u == "0") out = SvdbVal{SVDB_TYPE_INT, 0, 0.0, {}};
+        else return bad("boolean");
+        return true;
+    }
+    if (type.find("INT") != std::string::npos) {
+        if (jt == "true" || jt == "false") return bad("integer");
+        errno = 0;
+        char *end = nullptr;
+        long long iv = strtoll(a.c_str(), &end, 10);
+        if (errno != 0 || a.empty() || !end || *end != '\0') {
+            double d = strtod(a.c_str(), &end);
+            if (a.empty() || !end || *end != '\0' || jt == "text") return bad("integer");
+            iv = (long long)(d < 0 ? d - 0.5 : d + 0.5);
+        }
+        out = SvdbVal{SVDB_TYPE_INT, iv, 0.0, {}};
+        return true;
+    }
+    if (type.find("REAL") != std::string::npos || type.find("FLOA") != std::string::npos ||
+        type.find("DOUB") != std::string::npos || type.find("NUMERIC") != std::string::npos ||
+        type.find("DECIMAL") != std::string::npos) {
+        if (jt == "true" || jt == "false") return bad("numeric");
+        char *end = nullptr;
+        double d = strtod(a.c_str(), &end);
+        if (a.empty() || !end || *end != '\0') return bad("numeric");
+        bool exact = type.find("NUMERIC") != std::string::npos || type.find("DECIMAL") != std::string::npos;
+        if (exact && jt == "integer" && out.type == SVDB_TYPE_INT) return true;
+        out = SvdbVal{SVDB_TYPE_REAL, 0, d, {}};
+        return true;
+    }
+    out = SvdbVal{SVDB_TYPE_TEXT, 0, 0.0, a};
+    return true;
+}
+
+/* The result of b, for an empty result or the error msg */
+static bool sj_behave(const SjBehavior &b, SjMode mode, bool empty, const SjSpec &s,
+                      const std::string &msg, const Row &row,
+                      const std::vector<std::string> &col_order, SvdbVal &out, std::string &err) {
+    out = SvdbVal{};
+    bool jsonb = s.returning.find("JSONB") != std::string::npos;
+    switch (b.kind) {
+        case SjBehavior::UNSET:
+            if (mode == SJ_EXISTS) out = SvdbVal{SVDB_TYPE_INT, 0, 0.0, {}};
+            return true;
+        case SjBehavior::NUL:
+        case SjBehavior::UNKNOWN:
+            return true;
+        case SjBehavior::ERROR:
+            err = empty ? "no SQL/JSON item found for specified path" : msg;
+            return false;
+        case SjBehavior::TRUE_:
+        case SjBehavior::FALSE_:
+            out = SvdbVal{SVDB_TYPE_INT, b.kind == SjBehavior::TRUE_, 0.0, {}};
+            return true;
+        case SjBehavior::EMPTY_ARRAY:
+        case SjBehavior::EMPTY_OBJECT: {
+            std::string text = b.kind == SjBehavior::EMPTY_ARRAY ? "[]" : "{}";
+            out = SvdbVal{SVDB_TYPE_TEXT, 0, 0.0, text};
+            std::string doc;
+            if (jsonb && sqljson_doc(out, doc)) out.sval = doc;
+            if (jsonb) out.type = SVDB_TYPE_BLOB;
+            return true;
+        }
+        case SjBehavior::DEFAULT:
+            out = svdb_eval_expr_in_row(b.expr, row, col_order);
+            return true;
+    }
+    return true;
+}
+
+/* The JSON_VALUE/JSON_QUERY/JSON_EXISTS result for items; false on a data
+ * error, with the message in err */
+static bool sj_result(SjMode mode, const SjSpec &s, const std::vector<std::string> &items,
+                      SvdbVal &out, std::string &err) {
+    if (mode == SJ_EXISTS) {
+        out = SvdbVal{SVDB_TYPE_INT, !items.empty(), 0.0, {}};
+        return true;
+    }
+    if (mode == SJ_VALUE) {
+        std::string t = items.size() == 1 ? sj_type(items[0]) : "";
+        if (items.size() != 1 || t == "array" || t == "object") {
+            err = "JSON path expression in JSON_VALUE should return single scalar item";
+            return false;
+        }
+        return sj_cast(items[0], s.returning, out, err);
+    }
+    bool wrap = s.wrapper == 2;
+    if (s.wrapper == 1) {
+        std::string t = items.size() == 1 ? sj_type(items[0]) : "";
+        wrap = t != "array" && t != "object";
+    }
+    if (!wrap && items.size() != 1) {
+        err = "JSON path expression in JSON_QUERY should return single item without wrapper";
+        return false;
+    }
+    std::string text;
+    if (wrap) {
+        text = "[";
+        for (size_t i = 0; i < items.size(); ++i) {
+            if (i) text += ',';
+            text += sj_text(items[i]);
+        }
+        text += ']';
+    } else if (s.omit_quotes && sj_type(items[0]) == "text") {
+        out = SvdbVal{SVDB_TYPE_TEXT, 0, 0.0, sj_atom(items[0])};
+        return true;
+    } else {
+        text = sj_text(items[0]);
+    }
+    out = SvdbVal{SVDB_TYPE_TEXT, 0, 0.0, text};
+    std::string doc;
+    if (s.returning.find("JSONB") != std::string::npos && sqljson_doc(out, doc)) {
+        out.sval = doc;
+        out.type = SVDB_TYPE_BLOB;
+    }
+    return true;
+}
+
+/* Apply spec to the JSONB doc with the path already evaluated */
+static bool sj_eval(SjMode mode, const SjSpec &s, const std::string &doc, const std::string &path,
+                    const Row &row, const std::vector<std::string> &col_order,
+                    SvdbVal &out, std::string &err) {
+    std::vector<std::string> items;
+    std::string msg;
+    int rc = sj_items(doc, path, items, msg);
+    if (rc < 0) { err = msg; return false; }
+    if (rc > 0 && items.empty() && mode != SJ_EXISTS)
+        return sj_behave(s.on_empty, mode, true, s, msg, row, col_order, out, err);
+    if (rc > 0 && sj_result(mode, s, items, out, msg)) return true;
+    return sj_behave(s.on_error, mode, false, s, msg, row, col_order, out, err);
+}
+
+/* Split "path clauses..." words into the path expression and its clauses */
+static bool sj_parse_spec(const std::string &text, SjMode mode, SjSpec &s, std::string &err) {
+    std::vector<std::string> w = sj_split(text, ' ');
+    size_t i = 0;
+    while (i < w.size() && !sj_clause_word(svdb_str_upper(w[i]))) ++i;
+    s.path = sj_join(w, 0, i);
+    if (s.path.empty()) { err = "missing JSON path"; return false; }
+    if (i < w.size() && svdb_str_upper(w[i]) == "PASSING") {
+        err = "PASSING is not supported";
+        return false;
+    }
+    return sj_parse_clauses(w, i, mode, s, err);
+}
+
+bool sqljson_call(const std::string &fn, const std::string &args, const Row &row,
+                  const std::vector<std::string> &col_order, SvdbVal &out, std::string &err) {
+    SjMode mode = fn == "JSON_VALUE" ? SJ_VALUE : fn == "JSON_QUERY" ? SJ_QUERY : SJ_EXISTS;
+    std::vector<std::string> parts = sj_split(args, ',');
+    if (parts.size() != 2) {
+        err = fn + "() takes a document and a path";
+        return false;
+    }
+    SjSpec s;
+    if (!sj_parse_spec(parts[1], mode, s, err)) {
+        err = fn + ": " + err;
+        return false;
+    }
+    out = SvdbVal{};
+    SvdbVal docv = svdb_eval_expr_in_row(parts[0], row, col_order);
+    SvdbVal pathv = svdb_eval_expr_in_row(s.path, row, col_order);
+    if (docv.type == SVDB_TYPE_NULL || pathv.type == SVDB_TYPE_NULL) return true;
+    std::string doc;
+    if (!sqljson_doc(docv, doc))
+        return sj_behave(s.on_error, mode, false, s, "malformed JSON", row, col_order, out, err);
+    return sj_eval(mode, s, doc, pathv.sval.empty() && pathv.type != SVDB_TYPE_TEXT
+                                     ? std::string() : pathv.sval, row, col_order, out, err);
+}
+
+/* ── JSON_TABLE ─────────────────────────────────────────────────── */
+
+struct SjColumn {
+    enum Kind { VALUE, QUERY, EXISTS, ORDINALITY, NESTED } kind = VALUE;
+    std::string name, type;
+    SjSpec spec;
+    std::string path;                /* evaluated path */
+    std::vector<SjColumn> nested;    /* NESTED: its columns */
+};
+
+static std::string sj_unquote(const std::string &s) {
+    if (s.size() >= 2 && (s[0] == '"' || s[0] == '`') && s.back() == s[0]) return s.substr(1, s.size() - 2);
+    return s;
+}
+
+/* A constant SQL string expression, such as a path literal */
+static bool sj_const_text(const std::string &expr, std::string &out, std::string &err) {
+    SvdbVal v = svdb_eval_expr_in_row(expr, Row{}, {});
+    if (v.type != SVDB_TYPE_TEXT) {
+        err = "JSON_TABLE paths must be string literals: " + expr;
+        return false;
+    }
+    out = v.sval;
+    return true;
+}
+
+static bool sj_parse_columns(const std::string &text, std::vector<SjColumn> &cols, std::string &err);
+
+static bool sj_parse_column(const std::string &def, SjColumn &c, std::string &err) {
+    std::vector<std::string> w = sj_split(def, ' ');
+    if (w.empty()) { err = "empty JSON_TABLE column"; return false; }
+    auto up = [&](size_t k) { return k < w.size() ? svdb_str_upper(w[k]) : std::string(); };
+    if (up(0) == "NESTED") {
+        c.kind = SjColumn::NESTED;
+        size_t kw = sj_find_keyword(def, "COLUMNS");
+        size_t open = kw == std::string::npos ? kw : def.find('(', kw);
+        size_t close = def.rfind(')');
+        if (open == std::string::npos || close == std::string::npos || close < open) {
+            err = "NESTED needs COLUMNS (...)";
+            return false;
+        }
+        std::vector<std::string> hw = sj_split(def.substr(0, kw), ' ');
+        size_t i = 1;
+        if (i < hw.size() && svdb_str_upper(hw[i]) == "PATH") ++i;
+        if (i >= hw.size()) { err = "NESTED needs a path"; return false; }
+        if (!sj_const_text(hw[i], c.path, err)) return false;
+        return sj_parse_columns(def.substr(open + 1, close - open - 1), c.nested, err);
+    }
+    c.name = sj_unquote(w[0]);
+    if (up(1) == "FOR" && up(2) == "ORDINALITY") {
+        c.kind = SjColumn::ORDINALITY;
+        c.type = "INTEGER";
+        return true;
+    }
+    size_t i = 1;
+    while (i < w.size() && !sj_clause_word(up(i))) ++i;
+    c.type = svdb_str_upper(sj_join(w, 1, i));
+    c.spec.returning = c.type;
+    SjMode mode = SJ_VALUE;
+    if (up(i) == "FORMAT" && up(i + 1) == "JSON") {
+        c.spec.format_json = true;
+        i += 2;
+    }
+    if (up(i) == "EXISTS") {
+        mode = SJ_EXISTS;
+        ++i;
+    } else if (c.spec.format_json || c.type.find("JSON") != std::string::npos) {
+        mode = SJ_QUERY;
+    }
+    c.kind = mode == SJ_EXISTS ? SjColumn::EXISTS : mode == SJ_QUERY ? SjColumn::QUERY : SjColumn::VALUE;
+    if (up(i) == "PATH") {
+        if (i + 1 >= w.size()) { err = "PATH needs a path"; return false; }
+        if (!sj_const_text(w[i + 1], c.path, err)) return false;
+        i += 2;
+    } else {
+        std::string key;
+        for (char ch : c.name) {
+            if (ch == '"' || ch == '\\') key += '\\';
+            key += ch;
+        }
+        c.path = "$.\"" + key + "\"";
+    }
+    if (!sj_parse_clauses(w, i, mode, c.spec, err)) {
+        err = "column " + c.name + ": " + err;
+        return false;
+    }
+    if (mode == SJ_EXISTS && c.type.empty()) c.type = "INTEGER";
+    return true;
+}
+
+static bool sj_parse_columns(const std::string &text, std::vector<SjColumn> &cols, std::string &err) {
+    for (const std::string &def : sj_split(text, ',')) {
+        SjColumn c;
+        if (!sj_parse_column(def, c, err)) return false;
+        cols.push_back(c);
+    }
+    if (cols.empty()) { err = "JSON_TABLE needs at least one column"; return false; }
+    return true;
+}
+
+/* Column names and types in output order */
+static void sj_names(const std::vector<SjColumn> &cols, std::vector<std::pair<std::string, std::string>> &out) {
+    for (const SjColumn &c : cols) {
+        if (c.kind == SjColumn::NESTED) sj_names(c.nested, out);
+        else out.emplace_back(c.name, c.type);
+    }
+}
+
+/* Append the rows cols produce for item, the ord-th item of its path */
+static bool sj_rows(const std::vector<SjColumn> &cols, const std::string &item, int64_t ord,
+                    std::vector<Row> &out, std::string &err) {
+    Row base;
+    std::vector<const SjColumn *> nested;
+    for (const SjColumn &c : cols) {
+        SvdbVal v;
+        switch (c.kind) {
+            case SjColumn::ORDINALITY: v = SvdbVal{SVDB_TYPE_INT, ord, 0.0, {}}; break;
+            case SjColumn::NESTED: nested.push_back(&c); continue;
+            default: {
+                SjMode mode = c.kind == SjColumn::EXISTS ? SJ_EXISTS
+                            : c.kind == SjColumn::QUERY ? SJ_QUERY : SJ_VALUE;
+                SjSpec s = c.spec;
+                if (mode == SJ_EXISTS) s.returning.clear();
+                if (!sj_eval(mode, s, item, c.path, Row{}, {}, v, err)) {
+                    err = "JSON_TABLE column " + c.name + ": " + err;
+                    return false;
+                }
+            }
+        }
+        base[c.name] = v;
+    }
+    if (nested.empty()) {
+        out.push_back(base);
+        return true;
+    }
+    std::vector<std::pair<std::string, std::string>> nested_names;
+    for (const SjColumn *n : nested) sj_names(n->nested, nested_names);
+    for (const auto &nm : nested_names) base[nm.first] = SvdbVal{};
+    size_t before = out.size();
+    for (const SjColumn *n : nested) {
+        std::vector<std::string> items;
+        std::string msg;
+        int rc = sj_items(item, n->path, items, msg);
+        if (rc < 0) { err = msg; return false; }
+        for (size_t j = 0; j < items.size(); ++j) {
+            std::vector<Row> sub;
+            if (!sj_rows(n->nested, items[j], (int64_t)j + 1, sub, err)) return false;
+            for (Row &r : sub) {
+                Row merged = base;
+                for (auto &kv : r) merged[kv.first] = kv.second;
+                out.push_back(merged);
+            }
+        }
+    }
+    if (out.size() == before) out.push_back(base);
+    return true;
+}
+
+/* A parsed JSON_TABLE(...) call */
+struct SjTable {
+    std::string doc;                 /* document expression */
+    std::string path;                /* row path */
+    bool error_on_error = false;
+    std::vector<SjColumn> cols;
+};
+
+static bool sj_parse_table(const std::string &args, SjTable &t, std::string &err) {
+    std::vector<std::string> parts = sj_split(args, ',');
+    if (parts.size() != 2) {
+        err = "JSON_TABLE takes a document, a path and COLUMNS (...)";
+        return false;
+    }
+    t.doc = parts[0];
+    const std::string &rest = parts[1];
+    size_t kw = sj_find_keyword(rest, "COLUMNS");
+    size_t open = kw == std::string::npos ? kw : rest.find('(', kw);
+    if (open == std::string::npos) { err = "JSON_TABLE needs COLUMNS (...)"; return false; }
+    int depth = 0;
+    char quote = 0;
+    size_t close = std::string::npos;
+    for (size_t i = open; i < rest.size() && close == std::string::npos; ++i) {
+        char c = rest[i];
+        if (quote) { if (c == quote) quote = 0; continue; }
+        if (c == '\'' || c == '"') quote = c;
+        else if (c == '(') ++depth;
+        else if (c == ')' && --depth == 0) close = i;
+    }
+    if (close == std::string::npos) { err = "unterminated JSON_TABLE COLUMNS"; return false; }
+    std::vector<std::string> pw = sj_split(rest.substr(0, kw), ' ');
+    if (pw.size() >= 3 && svdb_str_upper(pw[pw.size() - 2]) == "AS") pw.resize(pw.size() - 2);
+    if (pw.empty()) { err = "JSON_TABLE needs a path"; return false; }
+    if (!sj_const_text(sj_join(pw, 0, pw.size()), t.path, err)) return false;
+    std::vector<std::string> tail = sj_split(rest.substr(close + 1), ' ');
+    std::string tu = svdb_str_upper(sj_join(tail, 0, tail.size()));
+    if (tu == "ERROR ON ERROR") t.error_on_error = true;
+    else if (!tu.empty() && tu != "EMPTY ON ERROR" && tu != "EMPTY ARRAY ON ERROR") {
+        err = "JSON_TABLE: syntax error near \"" + tail[0] + "\"";
+        return false;
+    }
+    return sj_parse_columns(rest.substr(open + 1, close - open - 1), t.cols, err);
+}
+
+/* Append the rows of t for one document */
+static bool sj_table_rows(const SjTable &t, const SvdbVal &docv, std::vector<Row> &out, std::string &err) {
+    if (docv.type == SVDB_TYPE_NULL) return true;
+    std::string doc;
+    std::vector<std::string> items;
+    std::string msg = "malformed JSON";
+    int rc = sqljson_doc(docv, doc) ? sj_items(doc, t.path, items, msg) : 0;
+    if (rc < 0 || (rc == 0 && t.error_on_error)) {
+        err = "JSON_TABLE: " + msg;
+        return false;
+    }
+    for (size_t j = 0; j < items.size(); ++j)
+        if (!sj_rows(t.cols, items[j], (int64_t)j + 1, out, err)) return false;
+    return true;
+}
+
+static bool sj_word_char(char c) { return isalnum((unsigned char)c) || c == '_'; }
+
+bool sqljson_rewrite_table(svdb_db_t *db, std::string &sql, std::string &tmp, std::string &err) {
+    tmp.clear();
+    size_t from = sj_find_keyword(sql, "FROM");
+    if (from == std::string::npos) return true;
+    size_t call = sj_find_keyword(sql, "JSON_TABLE", from);
+    if (call == std::string::npos) return true;
+    size_t open = call + 10;
+    while (open < sql.size() && sj_is_space(sql[open])) ++open;
+    if (open >= sql.size() || sql[open] != '(') return true;
+    int depth = 0;
+    char quote = 0;
+    size_t close = std::string::npos;
+    for (size_t i = open; i < sql.size() && close == std::string::npos; ++i) {
+        char c = sql[i];
+        if (quote) { if (c == quote) quote = 0; continue; }
+        if (c == '\'' || c == '"') quote = c;
+        else if (c == '(') ++depth;
+        else if (c == ')' && --depth == 0) close = i;
+    }
+    if (close == std::string::npos) { err = "unterminated JSON_TABLE(...)"; return false; }
+
+    SjTable t;
+    if (!sj_parse_table(sql.substr(open + 1, close - open - 1), t, err)) return false;
+
+    /* What comes before: nothing, or other tables and a join operator */
+    std::string head = svdb_str_trim(sql.substr(from + 4, call - from - 4));
+    std::string hu = svdb_str_upper(head);
+    auto strip = [&](const char *word) {
+        size_t n = strlen(word);
+        if (hu.size() >= n && hu.compare(hu.size() - n, n, word) == 0 &&
+            (hu.size() == n || !sj_word_char(hu[hu.size() - n - 1]))) {
+            head = svdb_str_trim(head.substr(0, head.size() - n));
+            hu = svdb_str_upper(head);
+            return true;
+        }
+        return false;
+    };
+    strip("LATERAL");
+    std::string join;
+    bool needs_on = false;
+    if (!hu.empty() && hu.back() == ',') {
+        head = svdb_str_trim(head.substr(0, head.size() - 1));
+        join = "JOIN";
+    } else if (strip("JOIN")) {
+        join = "JOIN";
+        needs_on = true;
+        if (strip("CROSS")) needs_on = false;
+        else if (strip("OUTER")) { strip("LEFT"); join = "LEFT JOIN"; }
+        else if (strip("LEFT")) join = "LEFT JOIN";
+        else strip("INNER");
+    } else if (!head.empty()) {
+        err = "JSON_TABLE must follow FROM, a comma or JOIN";
+        return false;
+    }
+    hu = svdb_str_upper(head);
+
+    /* The alias and, after JOIN, the ON condition */
+    size_t pos = close + 1;
+    auto next_word = [&](size_t p, size_t &end) {
+        while (p < sql.size() && sj_is_space(sql[p])) ++p;
+        end = p;
+        if (p < sql.size() && (sql[p] == '"' || sql[p] == '`')) {
+            size_t q = sql.find(sql[p], p + 1);
+            end = q == std::string::npos ? sql.size() : q + 1;
+        } else {
+            while (end < sql.size() && sj_word_char(sql[end])) ++end;
+        }
+        return std::make_pair(p, svdb_str_upper(sql.substr(p, end - p)));
+    };
+    static const char *stops[] = {"WHERE", "GROUP", "ORDER", "LIMIT", "HAVING", "ON", "JOIN", "LEFT",
+                                  "INNER", "CROSS", "UNION", "INTERSECT", "EXCEPT", "WINDOW", nullptr};
+    auto is_stop = [&](const std::string &w) {
+        for (int i = 0; stops[i]; ++i)
+            if (w == stops[i]) return true;
+        return w.empty();
+    };
+    size_t end;
+    auto w = next_word(pos, end);
+    std::string alias;
+    if (w.second == "AS") {
+        pos = end;
+        w = next_word(pos, end);
+    }
+    if (!is_stop(w.second)) {
+        alias = sql.substr(w.first, end - w.first);
+        pos = end;
+    }
+    std::string on_cond;
+    if (needs_on) {
+        w = next_word(pos, end);
+        if (w.second != "ON") { err = "JOIN JSON_TABLE(...) needs an ON condition"; return false; }
+        size_t stop = sql.size();
+        for (int i = 0; stops[i]; ++i) {
+            if (std::string(stops[i]) == "ON") continue;
+            size_t k = sj_find_keyword(sql, stops[i], end);
+            if (k != std::string::npos && k < stop) stop = k;
+        }
+        on_cond = svdb_str_trim(sql.substr(end, stop - end));
+        pos = stop;
+    }
+
+    /* Materialize the rows */
+    static int seq = 0;
+    tmp = "__json_table_" + std::to_string(++seq);
+    std::vector<std::pair<std::string, std::string>> names;
+    sj_names(t.cols, names);
+    std::vector<Row> rows;
+    bool correlated = !head.empty();
+    if (!correlated) {
+        SvdbVal docv = svdb_eval_expr_in_row(t.doc, Row{}, {});
+        if (!sj_table_rows(t, docv, rows, err)) { tmp.clear(); return false; }
+    } else {
+        svdb_rows_t *docs = nullptr;
+        svdb_code_t rc = svdb_query_internal(db, "SELECT " + t.doc + " FROM " + head, &docs);
+        if (rc != SVDB_OK) {
+            delete docs;
+            tmp.clear();
+            err = db->last_error.empty() ? "JSON_TABLE: cannot evaluate " + t.doc : db->last_error;
+            return false;
+        }
+        std::set<std::pair<int, std::string>> seen;
+        for (const auto &r : docs->rows) {
+            if (r.empty()) continue;
+            const SvdbVal &d = r[0];
+            std::string key = d.type == SVDB_TYPE_INT ? std::to_string(d.ival) : d.sval;
+            if (!seen.insert({d.type, key}).second) continue;
+            size_t first = rows.size();
+            if (!sj_table_rows(t, d, rows, err)) { delete docs; tmp.clear(); return false; }
+            for (size_t k = first; k < rows.size(); ++k) rows[k]["__doc"] = d;
+        }
+        delete docs;
+    }
+    db->schema[tmp] = {};
+    db->col_order[tmp] = {};
+    for (const auto &nm : names) {
+        if (db->schema[tmp].count(nm.first)) {
+            db->schema.erase(tmp);
+            db->col_order.erase(tmp);
+            err = "JSON_TABLE: duplicate column name " + nm.first;
+            tmp.clear();
+            return false;
+        }
+        db->schema[tmp][nm.first] = ColDef{nm.second, "", false, false};
+        db->col_order[tmp].push_back(nm.first);
+    }
+    db->data[tmp] = std::move(rows);
+
+    /* Rewrite the FROM clause to read the table */
+    std::string ref = alias.empty() ? tmp : alias;
+    std::string out = sql.substr(0, from) + "FROM ";
+    if (!correlated) {
+        out += tmp + (alias.empty() ? "" : " " + alias);
+    } else {
+        out += head + " " + join + " " + tmp + " " + ref + " ON " + ref + ".__doc = (" + t.doc + ")";
+        if (!on_cond.empty()) out += " AND (" + on_cond + ")";
+    }
+    out += " " + sql.substr(pos);
+    sql = out;
+    return true;
+}
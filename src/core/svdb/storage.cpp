@@ -0,0 +1,1520 @@
+/*
+ * storage.cpp — Database file persistence (SQLVIBE format v2, docs/DB-FORMAT.md)
+ *
+ * The whole database lives in memory while open. svdb_open() loads the file,
+ * and outside WAL mode every commit that changed something writes all of it
+ * back, atomically, through "<path>-tmp", so a commit costs time in
+ * proportion to the size of the database. New files therefore start in WAL
+ * mode; PRAGMA journal_mode=DELETE opts out.
+ *
+ *   header (256 bytes) | catalog | data pages, table by table |
+ *   free pages | FTS5 postings | footer (32 bytes)
+ *
+ * The catalog records every table, view, index, trigger and setting. Each
+ * table's rows are encoded back to back and cut into pages of about
+ * PRAGMA page_size bytes; every page is compressed on its own with the
+ * table's codec, so reading one page never needs another. A page that does
+ * not shrink is stored raw. Deleting rows does not shrink the file: pages
+ * the data no longer fills are kept as free pages for later rows, until
+ * VACUUM or PRAGMA incremental_vacuum give them back. The postings of FTS5
+ * tables follow, so opening the file loads their indexes instead of
+ * tokenizing every row again.
+ *
+ * In WAL mode (PRAGMA journal_mode=WAL) every commit also appends a frame to
+ * "<path>-wal" holding what changed since the previous frame: the catalog if
+ * it changed, and for each changed table the run of rows that replaced a run
+ * of old ones. Writes note the rows they touch (storage_note_rows), so a
+ * commit costs what it wrote rather than what the database holds. Frames are
+ * chained by checksum, so opening after a crash replays the committed frames
+ * and drops a torn tail. A checkpoint rewrites the main file and restarts the
+ * log; the main file and the log carry the same salt, so a log left over from
+ * before a checkpoint is never replayed twice.
+ */
+#include "svdb_storage.h"
+#include "svdb_fts5.h"
+#include "svdb_util.h"
+#include "svdb_vacuum.h"
+#include "../DS/compression.h"
+#include "../DS/varint.h"
+#include "../IS/vtab_fts5.h"
+#include <algorithm>
+#include <cerrno>
+#include <cstdio>
+#include <cstring>
+#include <ctime>
+#include <random>
+#include <unistd.h>
+
+namespace {
+
+const char kMagic[8]       = {'S', 'Q', 'L', 'V', 'I', 'B', 'E', '\x01'};
+const char kFooterMagic[8] = {'S', 'Q', 'L', 'V', 'I', 'B', '\xFE', '\x01'};
+const uint32_t kVersionMajor = 2;
+const uint32_t kVersionMinor = 3;
+const uint32_t kVersionPatch = 0;
+const size_t kHeaderSize = 256;
+const size_t kFooterSize = 32;
+const size_t kPageHeaderSize = 16;
+/* Header Flags: the database is in WAL mode */
+const uint32_t kFlagWal = 1;
+/* No codec expands a stored byte into more than this many raw bytes */
+const uint64_t kMaxExpansion = 70;
+
+/* Value tags in encoded rows */
+enum { TAG_NULL = 0, TAG_INT = 1, TAG_REAL = 2, TAG_TEXT = 3, TAG_BLOB = 4 };
+
+/* CRC64 with the ECMA-182 polynomial, as Go's hash/crc64 computes it. A
+ * running checksum continues from seed: crc64(b, crc64(a)) == crc64(a + b). */
+uint64_t crc64(const uint8_t *p, size_t n, uint64_t seed = 0) {
+    static uint64_t table[256];
+    static bool init = false;
+    if (!init) {
+        for (int i = 0; i < 256; ++i) {
+            uint64_t c = (uint64_t)i;
+            for (int k = 0; k < 8; ++k)
+                c = (c & 1) ? (c >> 1) ^ 0xC96C5795D7870F42ULL : c >> 1;
+            table[i] = c;
+        }
+        init = true;
+    }
+    uint64_t crc = ~seed;
+    for (size_t i = 0; i < n; ++i) crc = table[(uint8_t)(crc ^ p[i])] ^ (crc >> 8);
+    return ~crc;
+}
+
+struct Writer {
+    std::string buf;
+
+    void u8(uint8_t v) { buf.push_back((char)v); }
+    void u32(uint32_t v) { for (int i = 0; i < 4; ++i) u8((uint8_t)(v >> (8 * i))); }
+    void u64(uint64_t v) { for (int i = 0; i < 8; ++i) u8((uint8_t)(v >> (8 * i))); }
+    void varint(int64_t v) {
+        uint8_t tmp[9];
+        int n = svdb_put_varint(tmp, sizeof tmp, v);
+        buf.append((const char *)tmp, n);
+    }
+    /* Signed values zigzag-encoded so small negatives stay short */
+    void svarint(int64_t v) { varint((int64_t)(((uint64_t)v << 1) ^ (uint64_t)(v >> 63))); }
+    void str(const std::string &s) { varint((int64_t)s.size()); buf += s; }
+    void strs(const std::vector<std::string> &v) {
+        varint((int64_t)v.size());
+        for (auto &s : v) str(s);
+    }
+};
+
+/* Bounds-checked reader: any overrun clears ok and yields zero values */
+struct Reader {
+    const uint8_t *p;
+    size_t n;
+    size_t pos = 0;
+    bool ok = true;
+
+    Reader(const uint8_t *data, size_t size) : p(data), n(size) {}
+
+    size_t left() const { return ok ? n - pos : 0; }
+    bool need(size_t k) {
+        if (!ok || k > n - pos) ok = false;
+        return ok;
+    }
+    uint8_t u8() { return need(1) ? p[pos++] : 0; }
+    uint32_t u32() {
+        if (!need(4)) return 0;
+        uint32_t v = 0;
+        for (int i = 0; i < 4; ++i) v |= (uint32_t)p[pos + i] << (8 * i);
+        pos += 4;
+        return v;
+    }
+    int64_t varint() {
+        if (!ok) return 0;
+        int64_t v = 0;
+        int used = 0;
+        if (!svdb_get_varint(p + pos, n - pos, &v, &used)) { ok = false; return 0; }
+        pos += (size_t)used;
+        return v;
+    }
+    int64_t svarint() {
+        uint64_t u = (uint64_t)varint();
+        return (int64_t)((u >> 1) ^ (~(u & 1) + 1));
+    }
+    /* A count of items that take at least one byte each */
+    size_t count() {
+        int64_t c = varint();
+        if (c < 0 || (uint64_t)c > left()) { ok = false; return 0; }
+        return (size_t)c;
+    }
+    std::string str() {
+        size_t len = count();
+        if (!ok) return "";
+        std::string s((const char *)p + pos, len);
+        pos += len;
+        return s;
+    }
+    std::vector<std::string> strs() {
+        std::vector<std::string> v(count());
+        for (auto &s : v) s = str();
+        return v;
+    }
+};
+
+void put_u32_at(std::string &buf, size_t off, uint32_t v) {
+    for (int i = 0; i < 4; ++i) buf[off + i] = (char)(uint8_t)(v >> (8 * i));
+}
+
+void put_u64_at(std::string &buf, size_t off, uint64_t v) {
+    for (int i = 0; i < 8; ++i) buf[off + i] = (char)(uint8_t)(v >> (8 * i));
+}
+
+uint32_t get_u32_at(const uint8_t *p) {
+    return p[0] | (p[1] << 8) | (p[2] << 16) | ((uint32_t)p[3] << 24);
+}
+
+uint64_t get_u64_at(const uint8_t *p) {
+    uint64_t v = 0;
+    for (int i = 0; i < 8; ++i) v |= (uint64_t)p[i] << (8 * i);
+    return v;
+}
+
+template <typename M>
+std::vector<std::string> sorted_keys(const M &m) {
+    std::vector<std::string> keys;
+    for (auto &kv : m) keys.push_back(kv.first);
+    std::sort(keys.begin(), keys.end());
+    return keys;
+}
+
+/* ---- Page codecs -------------------------------------------------------- */
+
+/* Compress raw with codec into out. False if the codec fails or does not
+ * shrink the data, in which case the page is stored raw. */
+bool codec_compress(int codec, const std::string &raw, std::string &out) {
+    if (codec == CODEC_NONE || raw.empty()) return false;
+    size_t bound = std::max(svdb_lz4_compress_bound(raw.size()), svdb_rle_compress_bound(raw.size()));
+    out.resize(bound);
+    const uint8_t *in = (const uint8_t *)raw.data();
+    uint8_t *dst = (uint8_t *)&out[0];
+    int n = 0;
+    switch (codec) {
+    case CODEC_LZ4:  n = svdb_lz4_compress(in, raw.size(), dst, bound); break;
+    case CODEC_ZSTD: n = svdb_zstd_compress(in, raw.size(), dst, bound,
+                                            svdb_zstd_default_compression_level()); break;
+    case CODEC_GZIP: n = svdb_gzip_compress(in, raw.size(), dst, bound,
+                                            svdb_gzip_default_compression_level()); break;
+    case CODEC_RLE:  n = svdb_rle_compress(in, raw.size(), dst, bound); break;
+    default: return false;
+    }
+    if (n <= 0 || (size_t)n >= raw.size()) return false;
+    out.resize((size_t)n);
+    return true;
+}
+
+bool codec_decompress(int codec, const uint8_t *in, size_t len, std::string &raw) {
+    uint8_t *dst = (uint8_t *)&raw[0];
+    int n = 0;
+    switch (codec) {
+    case CODEC_LZ4:  n = svdb_lz4_decompress(in, len, dst, raw.size()); break;
+    case CODEC_ZSTD: n = svdb_zstd_decompress(in, len, dst, raw.size()); break;
+    case CODEC_GZIP: n = svdb_gzip_decompress(in, len, dst, raw.size()); break;
+    case CODEC_RLE:  n = svdb_rle_decompress(in, len, dst, raw.size()); break;
+    default: return false;
+    }
+    return n >= 0 && (size_t)n == raw.size();
+}
+
+/* ---- Rows and pages ----------------------------------------------------- */
+
+/* Keys of a table's rows: declared columns first, then hidden ones such as
+ * the rowid, in order of first appearance */
+std::vector<std::string> row_keys(svdb_db_t *db, const std::string &table,
+                                  const std::vector<Row> &rows) {
+    std::vector<std::string> keys;
+    auto co = db->col_order.find(table);
+    if (co != db->col_order.end()) keys = co->second;
+    std::set<std::string> seen(keys.begin(), keys.end());
+    for (auto &row : rows) {
+        std::vector<std::string> extra;
+        for (auto &kv : row)
+            if (!seen.count(kv.first)) extra.push_back(kv.first);
+        std::sort(extra.begin(), extra.end());
+        for (auto &k : extra) { seen.insert(k); keys.push_back(k); }
+    }
+    return keys;
+}
+
+/* The values of a row layout in key order: (position, key index) pairs,
+ * resolved once per layout rather than once per row */
+struct KeyIndex {
+    std::unordered_map<std::string, int64_t> index;
+    const RowLayout *layout = nullptr;
+    std::vector<std::pair<size_t, int64_t>> order;
+
+    explicit KeyIndex(const std::vector<std::string> &keys) {
+        for (size_t i = 0; i < keys.size(); ++i) index[keys[i]] = (int64_t)i;
+    }
+    const std::vector<std::pair<size_t, int64_t>> &resolve(const RowLayout *l) {
+        if (l == layout) return order;
+        layout = l;
+        order.clear();
+        for (size_t p = 0; p < l->keys.size(); ++p) {
+            auto it = index.find(l->keys[p]);
+            if (it != index.end()) order.emplace_back(p, it->second);
+        }
+        std::sort(order.begin(), order.end(),
+                  [](const std::pair<size_t, int64_t> &a, const std::pair<size_t, int64_t> &b) {
+                      return a.second < b.second;
+                  });
+        return order;
+    }
+};
+
+void encode_row(Writer &w, const Row &row, KeyIndex &keys) {
+    const auto &order = keys.resolve(row.layout());
+    w.varint((int64_t)order.size());
+    for (auto &pk : order) {
+        const SvdbVal &v = row.at_pos(pk.first);
+        w.varint(pk.second);
+        switch (v.type) {
+        case SVDB_TYPE_INT:  w.u8(TAG_INT); w.svarint(v.ival); break;
+        case SVDB_TYPE_REAL: {
+            uint64_t bits;
+            std::memcpy(&bits, &v.rval, 8);
+            w.u8(TAG_REAL);
+            w.u64(bits);
+            break;
+        }
+        case SVDB_TYPE_TEXT: w.u8(TAG_TEXT); w.str(v.sval); break;
+        case SVDB_TYPE_BLOB: w.u8(TAG_BLOB); w.str(v.sval); break;
+        default:             w.u8(TAG_NULL); break;
+        }
+    }
+}
+
+bool decode_row(Reader &r, const std::vector<std::string> &keys, Row &row) {
+    size_t n = r.count();
+    for (size_t i = 0; i < n && r.ok; ++i) {
+        int64_t k = r.varint();
+        if (k < 0 || (size_t)k >= keys.size()) return false;
+        SvdbVal v;
+        switch (r.u8()) {
+        case TAG_NULL: break;
+        case TAG_INT:  v.type = SVDB_TYPE_INT; v.ival = r.svarint(); break;
+        case TAG_REAL: {
+            uint64_t bits = r.need(8) ? get_u64_at(r.p + r.pos) : 0;
+            if (r.ok) r.pos += 8;
+            v.type = SVDB_TYPE_REAL;
+            std::memcpy(&v.rval, &bits, 8);
+            break;
+        }
+        case TAG_TEXT: v.type = SVDB_TYPE_TEXT; v.sval = r.str(); break;
+        case TAG_BLOB: v.type = SVDB_TYPE_BLOB; v.sval = r.str(); break;
+        default: return false;
+        }
+        row[keys[(size_t)k]] = std::move(v);
+    }
+    return r.ok;
+}
+
+size_t page_limit(const svdb_db_t *db) {
+    return (size_t)std::min<int64_t>(std::max<int64_t>(db->page_size_val, 512), 65536);
+}
+
+/* Append the pages of rows to out; returns the page count and adds the raw
+ * and stored sizes to stats */
+int64_t write_pages(std::string &out, const std::vector<Row> &rows,
+                    const std::vector<std::string> &keys, int codec, size_t limit,
+                    StorageStats &stats) {
+    int64_t pages = 0;
+    Writer page;
+    uint32_t page_rows = 0;
+    std::string packed;
+    auto flush = [&]() {
+        if (page_rows == 0) return;
+        bool packed_ok = codec_compress(codec, page.buf, packed);
+        const std::string &payload = packed_ok ? packed : page.buf;
+        Writer h;
+        h.u8((uint8_t)(packed_ok ? codec : CODEC_NONE));
+        h.u8(0);
+        h.u8(0);
+        h.u8(0);
+        h.u32(page_rows);
+        h.u32((uint32_t)page.buf.size());
+        h.u32((uint32_t)payload.size());
+        out += h.buf;
+        out += payload;
+        stats.raw_bytes += (int64_t)page.buf.size();
+        stats.stored_bytes += (int64_t)(kPageHeaderSize + payload.size());
+        ++pages;
+        page.buf.clear();
+        page_rows = 0;
+    };
+    KeyIndex key_index(keys);
+    for (auto &row : rows) {
+        encode_row(page, row, key_index);
+        ++page_rows;
+        if (page.buf.size() >= limit) flush();
+    }
+    flush();
+    return pages;
+}
+
+bool read_pages(Reader &r, size_t pages, const std::vector<std::string> &keys,
+                std::vector<Row> &rows, std::string &err) {
+    std::string raw;
+    for (size_t p = 0; p < pages; ++p) {
+        if (!r.need(kPageHeaderSize)) break;
+        const uint8_t *h = r.p + r.pos;
+        int codec = h[0];
+        uint32_t nrows = get_u32_at(h + 4);
+        uint32_t raw_len = get_u32_at(h + 8);
+        uint32_t stored_len = get_u32_at(h + 12);
+        r.pos += kPageHeaderSize;
+        if (!r.need(stored_len)) break;
+        const uint8_t *payload = r.p + r.pos;
+        r.pos += stored_len;
+        if (codec == CODEC_NONE) {
+            if (raw_len != stored_len) { err = "corrupt page"; return false; }
+            raw.assign((const char *)payload, stored_len);
+        } else {
+            if (storage_codec_name(codec)[0] == '\0' ||
+                (uint64_t)raw_len > (uint64_t)stored_len * kMaxExpansion + 64) {
+                err = "corrupt page";
+                return false;
+            }
+            raw.assign(raw_len, '\0');
+            if (raw_len > 0 && !codec_decompress(codec, payload, stored_len, raw)) {
+                err = "corrupt page: cannot decompress";
+                return false;
+            }
+        }
+        Reader pr((const uint8_t *)raw.data(), raw.size());
+        if (nrows > raw.size()) { err = "corrupt page"; return false; }
+        for (uint32_t i = 0; i < nrows; ++i) {
+            Row row;
+            if (!decode_row(pr, keys, row)) { err = "corrupt row"; return false; }
+            rows.push_back(std::move(row));
+        }
+        if (pr.pos != raw.size()) { err = "corrupt page"; return false; }
+    }
+    if (!r.ok) { err = "truncated page"; return false; }
+    return true;
+}
+
+/* ---- Catalog ------------------------------------------------------------ */
+
+void write_table_catalog(Writer &w, svdb_db_t *db, const std::string &t) {
+    uint8_t flags = 0;
+    auto sc = db->schema.find(t);
+    if (sc != db->schema.end()) flags |= 1;
+    if (db->data.count(t)) flags |= 2;
+    auto op = db->table_opts.find(t);
+    if (op != db->table_opts.end()) flags |= 4;
+    auto ft = db->fts5.find(t);
+    if (ft != db->fts5.end()) flags |= 8;
+    auto rc = db->rowid_counter.find(t);
+    if (rc != db->rowid_counter.end()) flags |= 16;
+    auto cs = db->create_sql.find(t);
+    if (cs != db->create_sql.end()) flags |= 32;
+    w.str(t);
+    w.u8(flags);
+    if (flags & 32) w.str(cs->second);
+    auto co = db->col_order.find(t);
+    w.strs(co != db->col_order.end() ? co->second : std::vector<std::string>());
+    if (flags & 1) {
+        w.varint((int64_t)sc->second.size());
+        for (auto &name : sorted_keys(sc->second)) {
+            const ColDef &cd = sc->second.at(name);
+            w.str(name);
+            w.str(cd.type);
+            w.str(cd.default_val);
+            w.u8((cd.not_null ? 1 : 0) | (cd.primary_key ? 2 : 0) | (cd.auto_increment ? 4 : 0));
+        }
+    }
+    auto pk = db->primary_keys.find(t);
+    w.strs(pk != db->primary_keys.end() ? pk->second : std::vector<std::string>());
+    auto uq = db->unique_constraints.find(t);
+    if (uq != db->unique_constraints.end()) {
+        w.varint((int64_t)uq->second.size());
+        for (auto &cols : uq->second) w.strs(cols);
+    } else {
+        w.varint(0);
+    }
+    auto ck = db->check_constraints.find(t);
+    w.strs(ck != db->check_constraints.end() ? ck->second : CheckList());
+    auto fk = db->fk_constraints.find(t);
+    if (fk != db->fk_constraints.end()) {
+        w.varint((int64_t)fk->second.size());
+        for (auto &f : fk->second) {
+            w.str(f.child_col);
+            w.str(f.parent_table);
+            w.str(f.parent_col);
+            w.str(f.on_delete);
+            w.str(f.on_update);
+            w.u8((f.deferrable ? 1 : 0) | (f.initially_deferred ? 2 : 0));
+        }
+    } else {
+        w.varint(0);
+    }
+    if (flags & 4) {
+        w.u8((op->second.strict ? 1 : 0) | (op->second.without_rowid ? 2 : 0) | (op->second.columnar ? 4 : 0));
+        w.str(op->second.compression);
+    }
+    if (flags & 8) {
+        const Fts5Table &f = ft->second;
+        w.strs(f.columns);
+        w.varint((int64_t)f.unindexed.size());
+        for (bool u : f.unindexed) w.u8(u ? 1 : 0);
+        w.str(f.tokenize);
+        w.str(f.content);
+        w.str(f.content_rowid);
+        w.u8(f.contentless ? 1 : 0);
+    }
+    if (flags & 16) w.svarint(rc->second);
+}
+
+bool read_table_catalog(Reader &r, svdb_db_t *db, std::string &t) {
+    t = r.str();
+    uint8_t flags = r.u8();
+    if (flags & 32) db->create_sql[t] = r.str();
+    std::vector<std::string> cols = r.strs();
+    if (!cols.empty()) db->col_order[t] = cols;
+    if (flags & 1) {
+        TableDef &td = db->schema[t];
+        size_t n = r.count();
+        for (size_t i = 0; i < n && r.ok; ++i) {
+            std::string name = r.str();
+            ColDef cd;
+            cd.type = r.str();
+            cd.default_val = r.str();
+            uint8_t f = r.u8();
+            cd.not_null = f & 1;
+            cd.primary_key = f & 2;
+            cd.auto_increment = f & 4;
+            td[name] = cd;
+        }
+    }
+    std::vector<std::string> pk = r.strs();
+    if (!pk.empty()) db->primary_keys[t] = pk;
+    size_t nu = r.count();
+    for (size_t i = 0; i < nu && r.ok; ++i) db->unique_constraints[t].push_back(r.strs());
+    CheckList checks = r.strs();
+    if (!checks.empty()) db->check_constraints[t] = checks;
+    size_t nf = r.count();
+    for (size_t i = 0; i < nf && r.ok; ++i) {
+        FKDef f;
+        f.child_col = r.str();
+        f.parent_table = r.str();
+        f.parent_col = r.str();
+        f.on_delete = r.str();
+        f.on_update = r.str();
+        uint8_t b = r.u8();
+        f.deferrable = b & 1;
+        f.initially_deferred = b & 2;
+        db->fk_constraints[t].push_back(f);
+    }
+    if (flags & 4) {
+        TableOpts &o = db->table_opts[t];
+        uint8_t b = r.u8();
+        o.strict = b & 1;
+        o.without_rowid = b & 2;
+        o.columnar = b & 4;
+        o.compression = r.str();
+    }
+    if (flags & 8) {
+        Fts5Table &f = db->fts5[t];
+        f.columns = r.strs();
+        size_t nu2 = r.count();
+        for (size_t i = 0; i < nu2 && r.ok; ++i) f.unindexed.push_back(r.u8() != 0);
+        f.tokenize = r.str();
+        f.content = r.str();
+        f.content_rowid = r.str();
+        f.contentless = r.u8() != 0;
+        std::string tok = svdb_str_upper(f.tokenize.substr(0, f.tokenize.find_first_of(" \t")));
+        auto it = db->tokenizers.find(tok);
+        if (it != db->tokenizers.end()) f.custom = it->second;
+    }
+    if (flags & 16) db->rowid_counter[t] = r.svarint();
+    if (flags & 2) db->data[t];
+    return r.ok;
+}
+
+void write_catalog(Writer &w, svdb_db_t *db, const std::vector<std::string> &tables) {
+    w.str(db->compression);
+    w.varint(db->page_size_val);
+    w.varint(db->auto_vacuum_val);
+
+    w.varint((int64_t)tables.size());
+    for (auto &t : tables) write_table_catalog(w, db, t);
+
+    w.varint((int64_t)db->triggers.size());
+    for (auto &name : sorted_keys(db->triggers)) {
+        const TriggerDef &td = db->triggers.at(name);
+        w.str(td.name);
+        w.u8((uint8_t)td.timing);
+        w.u8((uint8_t)td.event);
+        w.str(td.table);
+        w.str(td.when_expr);
+        w.str(td.body);
+        w.str(name);
+    }
+
+    w.varint((int64_t)db->indexes.size());
+    for (auto &kv : db->indexes) {
+        w.str(kv.first);
+        w.str(kv.second.table);
+        w.strs(kv.second.columns);
+        w.u8(kv.second.unique ? 1 : 0);
+        w.str(kv.second.where);
+    }
+
+    w.varint((int64_t)db->stat1.size());
+    for (auto &s : db->stat1) {
+        w.str(std::get<0>(s));
+        w.str(std::get<1>(s));
+        w.str(std::get<2>(s));
+    }
+}
+
+bool read_catalog(Reader &r, svdb_db_t *db, std::vector<std::string> &tables) {
+    db->compression = r.str();
+    db->page_size_val = r.varint();
+    db->auto_vacuum_val = r.varint();
+    if (storage_codec_id(db->compression) < 0) return false;
+
+    size_t nt = r.count();
+    for (size_t i = 0; i < nt && r.ok; ++i) {
+        std::string t;
+        if (!read_table_catalog(r, db, t)) return false;
+        tables.push_back(t);
+    }
+
+    size_t ntr = r.count();
+    for (size_t i = 0; i < ntr && r.ok; ++i) {
+        TriggerDef td;
+        td.name = r.str();
+        uint8_t timing = r.u8();
+        uint8_t event = r.u8();
+        if (timing > TRIGGER_INSTEAD_OF || event > TRIGGER_DELETE) return false;
+        td.timing = (TriggerTiming)timing;
+        td.event = (TriggerEvent)event;
+        td.table = r.str();
+        td.when_expr = r.str();
+        td.body = r.str();
+        db->triggers[r.str()] = td;
+    }
+
+    size_t ni = r.count();
+    for (size_t i = 0; i < ni && r.ok; ++i) {
+        std::string name = r.str();
+        IndexDef idx;
+        idx.table = r.str();
+        idx.columns = r.strs();
+        idx.unique = r.u8() != 0;
+        idx.where = r.str();
+        db->indexes[name] = idx;
+    }
+
+    size_t ns = r.count();
+    for (size_t i = 0; i < ns && r.ok; ++i) {
+        std::string a = r.str(), b = r.str(), c = r.str();
+        db->stat1.emplace_back(a, b, c);
+    }
+    return r.ok;
+}
+
+/* ---- FTS5 postings ------------------------------------------------------ */
+
+/* Documents are listed in rowid order with their token counts. Each term's
+ * postings refer to them by position in that list and give the term's
+ * (column, position) hits, each position relative to the previous hit in
+ * the same column. */
+void write_fts5_index(Writer &w, const std::string &t, const svdb::FTS5Index &index) {
+    std::vector<int64_t> ids;
+    for (auto &kv : index.GetDocLengths()) ids.push_back(kv.first);
+    std::sort(ids.begin(), ids.end());
+    std::unordered_map<int64_t, int64_t> at;
+    w.str(t);
+    w.varint((int64_t)ids.size());
+    uint64_t prev = 0;
+    for (size_t i = 0; i < ids.size(); ++i) {
+        w.svarint((int64_t)((uint64_t)ids[i] - prev));
+        w.varint(index.DocLength(ids[i]));
+        prev = (uint64_t)ids[i];
+        at[ids[i]] = (int64_t)i;
+    }
+    const auto &terms = index.GetTermHits();
+    w.varint((int64_t)terms.size());
+    std::vector<std::pair<int64_t, const std::vector<svdb::FTS5Index::Hit> *>> postings;
+    for (auto &term : sorted_keys(terms)) {
+        postings.clear();
+        for (auto &kv : terms.at(term)) postings.emplace_back(at.at(kv.first), &kv.second);
+        std::sort(postings.begin(), postings.end());
+        w.str(term);
+        w.varint((int64_t)postings.size());
+        int64_t last = -1;
+        for (auto &ph : postings) {
+            w.varint(ph.first - last);
+            w.varint((int64_t)ph.second->size());
+            int col = 0, pos = 0;
+            for (const auto &h : *ph.second) {
+                w.varint(h.col - col);
+                if (h.col != col) pos = 0;
+                w.varint(h.pos - pos);
+                col = h.col;
+                pos = h.pos;
+            }
+            last = ph.first;
+        }
+    }
+}
+
+/* Load the postings of one table as its index. Every row with a rowid must
+ * be a listed document; the column text is taken from the rows. */
+bool read_fts5_index(Reader &r, svdb_db_t *db) {
+    std::string t = r.str();
+    auto fit = db->fts5.find(t);
+    auto dit = db->data.find(t);
+    if (!r.ok || fit == db->fts5.end() || dit == db->data.end()) return false;
+    Fts5Table &ft = fit->second;
+    std::unordered_map<int64_t, const Row *> rows;
+    for (const Row &row : dit->second) {
+        auto rit = row.find(SVDB_ROWID_COLUMN);
+        if (rit != row.end()) rows[rit->second.ival] = &row;
+    }
+    size_t ndocs = r.count();
+    if (ndocs != rows.size()) return false;
+    std::shared_ptr<svdb::FTS5Index> index = fts5_new_index(ft);
+    std::vector<int64_t> ids(ndocs);
+    uint64_t id = 0;
+    for (size_t i = 0; i < ndocs && r.ok; ++i) {
+        id += (uint64_t)r.svarint();
+        int64_t len = r.varint();
+        auto it = rows.find((int64_t)id);
+        if (it == rows.end() || len < 0 || len > INT32_MAX || index->Contains((int64_t)id)) return false;
+        index->Restore((int64_t)id, fts5_row_values(ft, *it->second), (int)len);
+        ids[i] = (int64_t)id;
+    }
+    size_t nterms = r.count();
+    for (size_t i = 0; i < nterms && r.ok; ++i) {
+        std::string term = r.str();
+        size_t n = r.count();
+        int64_t at = -1;
+        for (size_t k = 0; k < n && r.ok; ++k) {
+            int64_t gap = r.varint();
+            size_t nhits = r.count();
+            if (gap < 1 || gap > (int64_t)ndocs - at - 1 || nhits == 0) return false;
+            at += gap;
+            std::vector<svdb::FTS5Index::Hit> hits(nhits);
+            int64_t col = 0, pos = 0;
+            for (auto &h : hits) {
+                int64_t dcol = r.varint();
+                int64_t dpos = r.varint();
+                if (dcol < 0 || dcol >= (int64_t)ft.columns.size() - col || dpos < 0 ||
+                    dpos > INT32_MAX)
+                    return false;
+                if (dcol > 0) pos = 0;
+                col += dcol;
+                pos += dpos;
+                if (pos > INT32_MAX) return false;
+                h = svdb::FTS5Index::Hit{(int)col, (int)pos};
+            }
+            index->RestorePosting(term, ids[(size_t)at], std::move(hits));
+        }
+    }
+    if (!r.ok) return false;
+    ft.index = std::move(index);
+    ft.gen = db->data_gen;
+    ft.loaded = true;
+    return true;
+}
+
+/* The postings section: u8 codec, varint raw length, then the table entries
+ * compressed with the database codec */
+std::string write_fts5_section(svdb_db_t *db, const std::vector<std::string> &tables) {
+    Writer w;
+    int64_t n = 0;
+    for (auto &t : tables) {
+        auto fit = db->fts5.find(t);
+        if (fit == db->fts5.end() || !db->data.count(t) || !fts5_tokenizer_ready(fit->second))
+            continue;
+        fts5_sync(db, t, fit->second);
+        write_fts5_index(w, t, *fit->second.index);
+        ++n;
+    }
+    if (n == 0) return "";
+    Writer raw;
+    raw.varint(n);
+    raw.buf += w.buf;
+    int codec = std::max(storage_codec_id(db->compression), 0);
+    std::string packed;
+    bool packed_ok = codec_compress(codec, raw.buf, packed);
+    Writer out;
+    out.u8((uint8_t)(packed_ok ? codec : CODEC_NONE));
+    out.varint((int64_t)raw.buf.size());
+    out.buf += packed_ok ? packed : raw.buf;
+    return out.buf;
+}
+
+bool read_fts5_section(const uint8_t *p, size_t n, svdb_db_t *db, std::string &err) {
+    Reader r(p, n);
+    int codec = r.u8();
+    int64_t raw_len = r.varint();
+    if (!r.ok || raw_len < 0 || storage_codec_name(codec)[0] == '\0' ||
+        (codec != CODEC_NONE && (uint64_t)raw_len > (uint64_t)r.left() * kMaxExpansion + 64) ||
+        (codec == CODEC_NONE && (uint64_t)raw_len != r.left())) {
+        err = "bad FTS5 postings";
+        return false;
+    }
+    std::string raw((const char *)p + r.pos, r.left());
+    if (codec != CODEC_NONE) {
+        raw.assign((size_t)raw_len, '\0');
+        if (raw_len > 0 && !codec_decompress(codec, p + r.pos, r.left(), raw)) {
+            err = "bad FTS5 postings: cannot decompress";
+            return false;
+        }
+    }
+    Reader ir((const uint8_t *)raw.data(), raw.size());
+    size_t tables = ir.count();
+    for (size_t i = 0; i < tables && ir.ok; ++i) {
+        if (!read_fts5_index(ir, db)) {
+            err = "bad FTS5 postings";
+            return false;
+        }
+    }
+    if (!ir.ok || ir.left() != 0) {
+        err = "bad FTS5 postings";
+        return false;
+    }
+    return true;
+}
+
+/* Every name with catalog state: tables, views and FTS5 tables */
+std::vector<std::string> catalog_tables(svdb_db_t *db) {
+    std::set<std::string> names;
+    for (auto &kv : db->schema) names.insert(kv.first);
+    for (auto &kv : db->create_sql) names.insert(kv.first);
+    for (auto &kv : db->data) names.insert(kv.first);
+    return std::vector<std::string>(names.begin(), names.end());
+}
+
+/* The committed rows of the database: an open SQL transaction's changes are
+ * not saved */
+const std::unordered_map<std::string, std::vector<Row>> &committed_data(svdb_db_t *db) {
+    return db->sql_tx ? db->sql_tx->data_snapshot : db->data;
+}
+
+/* The table data of the committed rows, as the file stores it; sets pages to
+ * its page count and rows to its row count */
+std::string write_table_data(svdb_db_t *db, const std::vector<std::string> &tables,
+                             int64_t &pages, int64_t &rows) {
+    const auto &data = committed_data(db);
+    std::string out;
+    size_t limit = page_limit(db);
+    pages = 0;
+    rows = 0;
+    for (auto &t : tables) {
+        auto it = data.find(t);
+        if (it == data.end()) continue;
+        std::vector<std::string> keys = row_keys(db, t, it->second);
+        Writer w;
+        w.str(t);
+        w.strs(keys);
+        std::string body;
+        StorageStats st;
+        int64_t n = write_pages(body, it->second, keys, storage_table_codec(db, t), limit, st);
+        w.varint(n);
+        out += w.buf;
+        out += body;
+        pages += n;
+        rows += (int64_t)it->second.size();
+    }
+    return out;
+}
+
+/* ---- Write-ahead log --------------------------------------------------- */
+
+const char kWalMagic[8] = {'S', 'V', 'D', 'B', 'W', 'A', 'L', '\x01'};
+const size_t kWalHeaderSize = 32;
+/* u32 payload length, u64 frame number, u64 salt; the payload and a u64
+ * chained checksum follow */
+const size_t kFrameHeaderSize = 20;
+
+std::string wal_path(const svdb_db_t *db) {
+    return db->path + "-wal";
+}
+
+bool wal_enabled(const svdb_db_t *db) {
+    return storage_is_file(db) && db->wal_mode == "WAL";
+}
+
+/* PRAGMA synchronous as 0 (OFF) to 3 (EXTRA) */
+int sync_level(const svdb_db_t *db) {
+    const std::string &s = db->synchronous;
+    if (s == "OFF" || s == "0") return 0;
+    if (s == "NORMAL" || s == "1") return 1;
+    if (s == "EXTRA" || s == "3") return 3;
+    return 2;
+}
+
+/* Flush f and, if sync is set, force it to disk; closes f */
+bool finish_file(FILE *f, bool sync) {
+    bool ok = fflush(f) == 0;
+    if (ok && sync) ok = fsync(fileno(f)) == 0;
+    return (fclose(f) == 0) && ok;
+}
+
+/* Read all of path into out; a missing file reads as empty */
+bool read_file(const std::string &path, std::string &out, std::string &err) {
+    out.clear();
+    FILE *f = fopen(path.c_str(), "rb");
+    if (!f) {
+        if (errno == ENOENT) return true;
+        err = "cannot open " + path + ": " + strerror(errno);
+        return false;
+    }
+    char chunk[65536];
+    size_t got;
+    while ((got = fread(chunk, 1, sizeof chunk, f)) > 0) out.append(chunk, got);
+    bool read_err = ferror(f);
+    fclose(f);
+    if (read_err) err = "cannot read " + path;
+    return !read_err;
+}
+
+uint64_t new_salt() {
+    static std::mt19937_64 rng(std::random_device{}() ^ (uint64_t)time(nullptr));
+    uint64_t salt;
+    do salt = rng(); while (salt == 0);
+    return salt;
+}
+
+uint64_t catalog_crc(svdb_db_t *db) {
+    Writer cat;
+    write_catalog(cat, db, catalog_tables(db));
+    return crc64((const uint8_t *)cat.buf.data(), cat.buf.size());
+}
+
+/* Start tracking writes from the committed state: count its rows as the
+ * base of the next frame, with no table written since */
+void wal_track(svdb_db_t *db) {
+    db->wal.rows.clear();
+    for (auto &kv : committed_data(db)) db->wal.rows[kv.first] = kv.second.size();
+    db->wal.dirty.clear();
+    db->wal.noted = db->data_gen;
+    db->wal.gen = db->data_gen;
+}
+
+/* Take the committed state as the base the next frame is diffed against */
+void wal_rebase(svdb_db_t *db) {
+    db->wal.catalog_crc = catalog_crc(db);
+    wal_track(db);
+}
+
+/* Encode what changed since the last frame into payload; false if nothing
+ * did. Only the tables noted as written are looked at, and of those only the
+ * rows between their unwritten head and tail; if a write went unnoted every
+ * table is written out whole. */
+bool wal_frame(svdb_db_t *db, std::string &payload, uint64_t &cat_crc) {
+    Writer cat;
+    write_catalog(cat, db, catalog_tables(db));
+    cat_crc = crc64((const uint8_t *)cat.buf.data(), cat.buf.size());
+    bool cat_changed = cat_crc != db->wal.catalog_crc;
+
+    const WalState &wal = db->wal;
+    bool all = wal.noted != db->data_gen;
+    const auto &data = committed_data(db);
+    Writer body;
+    int64_t changed = 0;
+    for (auto &t : sorted_keys(data)) {
+        auto dit = wal.dirty.find(t);
+        if (!all && dit == wal.dirty.end()) continue;
+        const std::vector<Row> &rows = data.at(t);
+        auto oit = wal.rows.find(t);
+        size_t old = oit == wal.rows.end() ? 0 : oit->second;
+        size_t both = std::min(old, rows.size());
+        size_t keep = all ? 0 : std::min(dit->second.keep, both);
+        size_t tail = all ? 0 : std::min(dit->second.tail, both - keep);
+        if (keep + tail == old && old == rows.size()) continue;
+        std::vector<Row> added(rows.begin() + (std::ptrdiff_t)keep,
+                               rows.end() - (std::ptrdiff_t)tail);
+        std::vector<std::string> keys = row_keys(db, t, added);
+        body.str(t);
+        body.varint((int64_t)old);
+        body.varint((int64_t)keep);
+        body.varint((int64_t)(old - keep - tail));
+        body.strs(keys);
+        body.varint((int64_t)added.size());
+        KeyIndex key_index(keys);
+        for (auto &row : added) encode_row(body, row, key_index);
+        ++changed;
+    }
+    if (!cat_changed && changed == 0) return false;
+
+    Writer w;
+    w.u8(cat_changed ? 1 : 0);
+    if (cat_changed) w.str(cat.buf);
+    w.varint(changed);
+    payload = w.buf + body.buf;
+    return true;
+}
+
+/* Forget every table, view, index, trigger and setting, before a catalog
+ * from the log replaces them */
+void reset_catalog(svdb_db_t *db) {
+    db->schema.clear();
+    db->col_order.clear();
+    db->primary_keys.clear();
+    db->unique_constraints.clear();
+    db->check_constraints.clear();
+    db->fk_constraints.clear();
+    db->triggers.clear();
+    db->create_sql.clear();
+    db->table_opts.clear();
+    db->fts5.clear();
+    db->columnar.clear();
+    db->indexes.clear();
+    db->index_cache.clear();
+    db->unique_keys.clear();
+    db->rowid_counter.clear();
+    db->stat1.clear();
+}
+
+/* Apply one frame payload to db */
+bool wal_apply(svdb_db_t *db, const uint8_t *p, size_t n) {
+    Reader r(p, n);
+    uint8_t flags = r.u8();
+    if (flags & ~1u) return false;
+    if (flags & 1) {
+        std::string cat = r.str();
+        if (!r.ok) return false;
+        auto old = std::move(db->data);
+        auto old_fts = std::move(db->fts5);
+        db->data.clear();
+        reset_catalog(db);
+        Reader cr((const uint8_t *)cat.data(), cat.size());
+        std::vector<std::string> tables;
+        if (!read_catalog(cr, db, tables) || cr.pos != cat.size()) return false;
+        for (auto &kv : db->data) {
+            auto it = old.find(kv.first);
+            if (it != old.end()) kv.second = std::move(it->second);
+        }
+        /* An FTS5 table defined as before keeps its index, to be checked
+         * against the replayed rows */
+        for (auto &kv : db->fts5) {
+            auto it = old_fts.find(kv.first);
+            if (it == old_fts.end() || it->second.columns != kv.second.columns ||
+                it->second.unindexed != kv.second.unindexed || it->second.tokenize != kv.second.tokenize)
+                continue;
+            kv.second.index = std::move(it->second.index);
+            kv.second.loaded = it->second.loaded;
+            kv.second.gen = 0;
+        }
+    }
+    size_t tables = r.count();
+    for (size_t i = 0; i < tables && r.ok; ++i) {
+        std::string t = r.str();
+        int64_t old_rows = r.varint();
+        int64_t keep = r.varint();
+        int64_t removed = r.varint();
+        std::vector<std::string> keys = r.strs();
+        size_t added = r.count();
+        auto it = db->data.find(t);
+        if (!r.ok || it == db->data.end()) return false;
+        std::vector<Row> &rows = it->second;
+        if (old_rows != (int64_t)rows.size() || keep < 0 || removed < 0 || keep + removed > old_rows)
+            return false;
+        std::vector<Row> mid(added);
+        for (auto &row : mid)
+            if (!decode_row(r, keys, row)) return false;
+        /* A loaded FTS5 index re-checks its rows at the next sync */
+        auto fit = db->fts5.find(t);
+        if (fit != db->fts5.end()) fit->second.gen = 0;
+        rows.erase(rows.begin() + keep, rows.begin() + keep + removed);
+        rows.insert(rows.begin() + keep, std::make_move_iterator(mid.begin()),
+                    std::make_move_iterator(mid.end()));
+    }
+    return r.ok && r.left() == 0;
+}
+
+/* Start an empty log for the current main file, or leave no log at all */
+bool wal_restart(svdb_db_t *db, bool truncate, std::string &err) {
+    std::string path = wal_path(db);
+    FILE *f = fopen(path.c_str(), "wb");
+    if (!f) {
+        err = "cannot write " + path + ": " + strerror(errno);
+        return false;
+    }
+    std::string h;
+    if (!truncate) {
+        h.assign(kWalHeaderSize, '\0');
+        std::memcpy(&h[0], kWalMagic, 8);
+        put_u32_at(h, 8, 1);
+        put_u64_at(h, 16, db->wal.salt);
+        put_u64_at(h, 24, crc64((const uint8_t *)h.data(), 24));
+    }
+    bool ok = fwrite(h.data(), 1, h.size(), f) == h.size();
+    if (!finish_file(f, sync_level(db) >= 1) || !ok) {
+        err = "cannot write " + path + ": " + strerror(errno);
+        return false;
+    }
+    db->wal.frames = 0;
+    db->wal.chain = truncate ? 0 : get_u64_at((const uint8_t *)h.data() + 24);
+    return true;
+}
+
+bool wal_append(svdb_db_t *db, const std::string &payload, std::string &err) {
+    /* A truncated or stale log is started afresh */
+    if (db->wal.chain == 0 && !wal_restart(db, false, err)) return false;
+    std::string frame(kFrameHeaderSize, '\0');
+    put_u32_at(frame, 0, (uint32_t)payload.size());
+    put_u64_at(frame, 4, (uint64_t)db->wal.frames + 1);
+    put_u64_at(frame, 12, db->wal.salt);
+    frame += payload;
+    uint64_t sum = crc64((const uint8_t *)frame.data(), frame.size(), db->wal.chain);
+    frame.append(8, '\0');
+    put_u64_at(frame, frame.size() - 8, sum);
+
+    std::string path = wal_path(db);
+    FILE *f = fopen(path.c_str(), "ab");
+    if (!f) {
+        err = "cannot write " + path + ": " + strerror(errno);
+        return false;
+    }
+    bool ok = fwrite(frame.data(), 1, frame.size(), f) == frame.size();
+    if (!finish_file(f, sync_level(db) >= 2) || !ok) {
+        err = "cannot write " + path + ": " + strerror(errno);
+        return false;
+    }
+    ++db->wal.frames;
+    db->wal.chain = sum;
+    return true;
+}
+
+/* Replay the committed frames of the log over the freshly loaded main file
+ * and drop anything after the last good one */
+bool wal_replay(svdb_db_t *db, std::string &err) {
+    std::string path = wal_path(db);
+    std::string log;
+    if (!read_file(path, log, err)) return false;
+    db->wal.frames = 0;
+    db->wal.chain = 0;
+    const uint8_t *p = (const uint8_t *)log.data();
+    if (log.size() < kWalHeaderSize || std::memcmp(p, kWalMagic, 8) != 0 ||
+        get_u64_at(p + 24) != crc64(p, 24) || get_u64_at(p + 16) != db->wal.salt)
+        return true; /* no log, or one the main file already holds */
+    uint64_t chain = get_u64_at(p + 24);
+    size_t pos = kWalHeaderSize;
+    while (log.size() - pos >= kFrameHeaderSize + 8) {
+        uint32_t len = get_u32_at(p + pos);
+        if (len > log.size() - pos - kFrameHeaderSize - 8) break;
+        size_t end = pos + kFrameHeaderSize + len;
+        uint64_t sum = crc64(p + pos, end - pos, chain);
+        if (get_u64_at(p + pos + 4) != (uint64_t)db->wal.frames + 1 ||
+            get_u64_at(p + pos + 12) != db->wal.salt || get_u64_at(p + end) != sum)
+            break;
+        if (!wal_apply(db, p + pos + kFrameHeaderSize, len)) {
+            err = "database disk image is malformed: bad frame in " + path;
+            return false;
+        }
+        ++db->wal.frames;
+        db->wal.chain = chain = sum;
+        pos = end + 8;
+    }
+    if (pos < log.size() && !db->read_only && truncate(path.c_str(), (off_t)pos) != 0) {
+        err = "cannot truncate " + path + ": " + strerror(errno);
+        return false;
+    }
+    if (db->wal.frames == 0) db->wal.chain = 0;
+    return true;
+}
+
+} // namespace
+
+int storage_codec_id(const std::string &name) {
+    std::string n = svdb_str_upper(name);
+    if (n == "NONE" || n.empty()) return CODEC_NONE;
+    if (n == "LZ4")  return CODEC_LZ4;
+    if (n == "ZSTD") return CODEC_ZSTD;
+    if (n == "RLE")  return CODEC_RLE;
+    if (n == "GZIP") return CODEC_GZIP;
+    return -1;
+}
+
+const char *storage_codec_name(int codec) {
+    switch (codec) {
+    case CODEC_NONE: return "NONE";
+    case CODEC_LZ4:  return "LZ4";
+    case CODEC_ZSTD: return "ZSTD";
+    case CODEC_RLE:  return "RLE";
+    case CODEC_GZIP: return "GZIP";
+    default:         return "";
+    }
+}
+
+int storage_table_codec(svdb_db_t *db, const std::string &table) {
+    auto op = db->table_opts.find(table);
+    int codec = -1;
+    if (op != db->table_opts.end() && !op->second.compression.empty())
+        codec = storage_codec_id(op->second.compression);
+    if (codec < 0) codec = storage_codec_id(db->compression);
+    return codec < 0 ? CODEC_NONE : codec;
+}
+
+StorageStats storage_table_stats(svdb_db_t *db, const std::string &table) {
+    StorageStats st;
+    st.codec = storage_table_codec(db, table);
+    auto it = db->data.find(table);
+    if (it == db->data.end()) return st;
+    std::string out;
+    st.pages = write_pages(out, it->second, row_keys(db, table, it->second), st.codec,
+                           page_limit(db), st);
+    return st;
+}
+
+int64_t storage_free_pages(svdb_db_t *db) {
+    if (db->file_pages == 0) return 0;
+    int64_t pages = 0, rows = 0;
+    write_table_data(db, catalog_tables(db), pages, rows);
+    return std::max<int64_t>(db->file_pages - pages, 0);
+}
+
+int64_t storage_release_pages(svdb_db_t *db, int64_t pages) {
+    if (db->file_pages == 0) return 0;
+    int64_t data_pages = 0, rows = 0;
+    std::string data = write_table_data(db, catalog_tables(db), data_pages, rows);
+    int64_t free = std::max<int64_t>(db->file_pages - data_pages, 0);
+    int64_t take = pages > 0 ? std::min(pages, free) : free;
+    if (take == 0) return 0;
+    /* Released pages take their share of the free bytes */
+    int64_t free_len = std::max<int64_t>(db->file_bytes - (int64_t)data.size(), 0);
+    db->file_bytes -= take == free ? free_len : free_len / free * take;
+    db->file_pages -= take;
+    db->saved_gen = 0; /* the file is due a rewrite */
+    return take;
+}
+
+bool storage_is_file(const svdb_db_t *db) {
+    return !db->path.empty() && db->path != ":memory:";
+}
+
+/* A new database file starts in WAL mode, where a commit appends what it
+ * changed to the log instead of rewriting the whole file */
+static bool start_file(svdb_db_t *db, std::string &err) {
+    return db->read_only || storage_journal_mode(db, "WAL", err);
+}
+
+bool storage_load(svdb_db_t *db, std::string &err) {
+    if (!storage_is_file(db)) return true;
+    FILE *f = fopen(db->path.c_str(), "rb");
+    if (!f) {
+        if (errno == ENOENT) return start_file(db, err);
+        err = std::string("cannot open database file: ") + strerror(errno);
+        return false;
+    }
+    std::string file;
+    char chunk[65536];
+    size_t got;
+    while ((got = fread(chunk, 1, sizeof chunk, f)) > 0) file.append(chunk, got);
+    bool read_err = ferror(f);
+    fclose(f);
+    if (read_err) {
+        err = "cannot read database file";
+        return false;
+    }
+    if (file.empty()) return start_file(db, err);
+
+    const uint8_t *p = (const uint8_t *)file.data();
+    size_t size = file.size();
+    if (size < kHeaderSize + kFooterSize || std::memcmp(p, kMagic, 8) != 0) {
+        err = "file is not a database";
+        return false;
+    }
+    const uint8_t *foot = p + size - kFooterSize;
+    if (std::memcmp(foot, kFooterMagic, 8) != 0 ||
+        get_u64_at(foot + 8) != crc64(p, size - kFooterSize)) {
+        err = "database disk image is malformed: checksum mismatch";
+        return false;
+    }
+    if (get_u64_at(p + 248) != crc64(p, 248)) {
+        err = "database disk image is malformed: header checksum mismatch";
+        return false;
+    }
+    if (get_u32_at(p + 8) != kVersionMajor) {
+        err = "unsupported database format version " + std::to_string(get_u32_at(p + 8));
+        return false;
+    }
+    uint32_t cat_off = get_u32_at(p + 24);
+    uint32_t cat_len = get_u32_at(p + 28);
+    size_t body_end = size - kFooterSize;
+    if (cat_off != kHeaderSize || cat_len > body_end - cat_off) {
+        err = "database disk image is malformed: bad catalog";
+        return false;
+    }
+    /* The FTS5 postings, if any, end the body */
+    uint64_t fts_off = get_u64_at(p + 68);
+    uint64_t fts_len = get_u64_at(p + 76);
+    if (fts_len > 0) {
+        if (fts_off < cat_off + cat_len || fts_off > body_end || fts_len != body_end - fts_off) {
+            err = "database disk image is malformed: bad FTS5 postings";
+            return false;
+        }
+        body_end = (size_t)fts_off;
+    }
+    /* The free pages, if any, end the table data; each takes a byte or more */
+    uint64_t free_off = get_u64_at(p + 84);
+    uint64_t free_pages = get_u64_at(p + 92);
+    uint64_t free_len = get_u64_at(p + 100);
+    if (free_pages > 0 || free_len > 0) {
+        if (free_pages == 0 || free_pages > free_len || free_off < cat_off + cat_len ||
+            free_off > body_end || free_len != body_end - free_off) {
+            err = "database disk image is malformed: bad freelist";
+            return false;
+        }
+        body_end = (size_t)free_off;
+    }
+
+    db->created_at = get_u32_at(p + 44);
+    if (get_u32_at(p + 20) & kFlagWal) {
+        db->wal_mode = "WAL";
+        db->wal.salt = get_u64_at(p + 60);
+    } else {
+        db->wal_mode = "DELETE";
+    }
+
+    Reader cat(p + cat_off, cat_len);
+    std::vector<std::string> tables;
+    if (!read_catalog(cat, db, tables) || cat.pos != cat_len) {
+        err = "database disk image is malformed: bad catalog";
+        return false;
+    }
+
+    Reader body(p + cat_off + cat_len, body_end - cat_off - cat_len);
+    int64_t data_pages = 0;
+    while (body.left() > 0) {
+        std::string t = body.str();
+        std::vector<std::string> keys = body.strs();
+        size_t pages = body.count();
+        data_pages += (int64_t)pages;
+        if (!body.ok || !db->data.count(t)) {
+            err = "database disk image is malformed: bad table data";
+            return false;
+        }
+        std::vector<Row> &rows = db->data[t];
+        rows.clear();
+        if (!read_pages(body, pages, keys, rows, err)) {
+            err = "database disk image is malformed: " + err + " in table " + t;
+            return false;
+        }
+    }
+    if (!body.ok) {
+        err = "database disk image is malformed: bad table data";
+        return false;
+    }
+    if (fts_len > 0 && !read_fts5_section(p + fts_off, (size_t)fts_len, db, err)) {
+        err = "database disk image is malformed: " + err;
+        return false;
+    }
+    db->file_pages = data_pages + (int64_t)free_pages;
+    db->file_bytes = (int64_t)(body_end - cat_off - cat_len + free_len);
+    if (wal_enabled(db)) {
+        if (!wal_replay(db, err)) return false;
+        wal_rebase(db);
+    }
+    db->saved_gen = db->data_gen;
+    return true;
+}
+
+/* Write the committed state of db to path, replacing it atomically; a
+ * non-zero salt marks it as extended by a log with that salt. The database's
+ * own file keeps its free pages, a copy (main false) has none. */
+static bool write_image(svdb_db_t *db, const std::string &path, uint64_t salt, bool main,
+                        std::string &err) {
+    std::vector<std::string> tables = catalog_tables(db);
+
+    Writer cat;
+    write_catalog(cat, db, tables);
+
+    std::string file(kHeaderSize, '\0');
+    file += cat.buf;
+    int64_t data_pages = 0, row_count = 0;
+    std::string data = write_table_data(db, tables, data_pages, row_count);
+    file += data;
+    /* Pages the table data spanned and no longer needs stay in the file, zero
+     * filled, until VACUUM or incremental_vacuum give them back; with
+     * auto_vacuum=FULL they go at once */
+    int64_t free_pages = 0, free_len = 0;
+    if (main && db->auto_vacuum_val != AUTO_VACUUM_FULL && db->file_pages > data_pages) {
+        free_pages = db->file_pages - data_pages;
+        free_len = std::max(db->file_bytes - (int64_t)data.size(), free_pages);
+    }
+    size_t free_off = file.size();
+    file.append((size_t)free_len, '\0');
+    /* The indexes follow the rows of an open transaction, which are not
+     * saved; the next open rebuilds them then */
+    size_t fts_off = file.size();
+    if (!db->sql_tx) file += write_fts5_section(db, tables);
+    size_t fts_len = file.size() - fts_off;
+
+    uint32_t now = (uint32_t)time(nullptr);
+    std::memcpy(&file[0], kMagic, 8);
+    put_u32_at(file, 8, kVersionMajor);
+    put_u32_at(file, 12, kVersionMinor);
+    put_u32_at(file, 16, kVersionPatch);
+    put_u32_at(file, 20, salt ? kFlagWal : 0);
+    put_u32_at(file, 24, (uint32_t)kHeaderSize);
+    put_u32_at(file, 28, (uint32_t)cat.buf.size());
+    put_u32_at(file, 32, (uint32_t)tables.size());
+    put_u32_at(file, 36, (uint32_t)row_count);
+    put_u32_at(file, 40, (uint32_t)db->indexes.size());
+    put_u32_at(file, 44, db->created_at ? db->created_at : now);
+    put_u32_at(file, 48, now);
+    put_u32_at(file, 52, (uint32_t)std::max(storage_codec_id(db->compression), 0));
+    put_u32_at(file, 56, (uint32_t)page_limit(db));
+    put_u64_at(file, 60, salt);
+    put_u64_at(file, 68, fts_len ? fts_off : 0);
+    put_u64_at(file, 76, fts_len);
+    put_u64_at(file, 84, free_pages ? free_off : 0);
+    put_u64_at(file, 92, (uint64_t)free_pages);
+    put_u64_at(file, 100, (uint64_t)free_len);
+    put_u64_at(file, 248, crc64((const uint8_t *)file.data(), 248));
+
+    std::string foot(kFooterSize, '\0');
+    std::memcpy(&foot[0], kFooterMagic, 8);
+    put_u64_at(foot, 8, crc64((const uint8_t *)file.data(), file.size()));
+    put_u32_at(foot, 16, (uint32_t)row_count);
+    put_u32_at(foot, 20, (uint32_t)tables.size());
+    file += foot;
+
+    std::string tmp = path + "-tmp";
+    FILE *f = fopen(tmp.c_str(), "wb");
+    if (!f) {
+        err = std::string("cannot write database file: ") + strerror(errno);
+        return false;
+    }
+    bool ok = fwrite(file.data(), 1, file.size(), f) == file.size();
+    ok = finish_file(f, sync_level(db) >= 1) && ok;
+    if (!ok || rename(tmp.c_str(), path.c_str()) != 0) {
+        err = std::string("cannot write database file: ") + strerror(errno);
+        remove(tmp.c_str());
+        return false;
+    }
+    if (main) {
+        db->file_pages = data_pages + free_pages;
+        db->file_bytes = (int64_t)data.size() + free_len;
+    }
+    return true;
+}
+
+bool storage_save(svdb_db_t *db, std::string &err) {
+    if (!storage_is_file(db)) return true;
+    if (db->read_only) {
+        err = "attempt to write a readonly database";
+        return false;
+    }
+    /* A new salt orphans the log of the previous image */
+    uint64_t salt = wal_enabled(db) ? new_salt() : 0;
+    if (!write_image(db, db->path, salt, true, err)) return false;
+    db->created_at = db->created_at ? db->created_at : (uint32_t)time(nullptr);
+    db->saved_gen = db->data_gen;
+    db->wal.salt = salt;
+    return true;
+}
+
+bool storage_save_copy(svdb_db_t *db, const std::string &path, std::string &err) {
+    return write_image(db, path, 0, false, err);
+}
+
+bool storage_commit(svdb_db_t *db, std::string &err) {
+    if (db->read_only || db->in_transaction) return true;
+    if (!wal_enabled(db)) return db->data_gen == db->saved_gen || storage_save(db, err);
+    if (db->wal.gen == db->data_gen) return true;
+    std::string payload;
+    uint64_t cat_crc = 0;
+    if (wal_frame(db, payload, cat_crc)) {
+        if (!wal_append(db, payload, err)) return false;
+        db->wal.catalog_crc = cat_crc;
+    }
+    wal_track(db);
+    if (db->wal_autocheckpoint_val > 0 && db->wal.frames >= db->wal_autocheckpoint_val) {
+        StorageCheckpoint ck;
+        return storage_checkpoint(db, "PASSIVE", ck, err);
+    }
+    return true;
+}
+
+void storage_note_rows(svdb_db_t *db, const std::string &table, size_t keep, size_t tail) {
+    WalState &wal = db->wal;
+    if (!wal_enabled(db) || wal.noted + 1 != db->data_gen) return;
+    wal.noted = db->data_gen;
+    auto it = wal.dirty.find(table);
+    if (it == wal.dirty.end()) {
+        wal.dirty[table] = WalDirty{keep, tail};
+        return;
+    }
+    it->second.keep = std::min(it->second.keep, keep);
+    it->second.tail = std::min(it->second.tail, tail);
+}
+
+void storage_note_table(svdb_db_t *db, const std::string &table) {
+    if (wal_enabled(db)) db->wal.dirty[table] = WalDirty{};
+}
+
+void storage_note_kept(svdb_db_t *db) {
+    WalState &wal = db->wal;
+    if (wal_enabled(db) && wal.noted + 1 == db->data_gen) wal.noted = db->data_gen;
+}
+
+bool storage_checkpoint(svdb_db_t *db, const std::string &mode, StorageCheckpoint &out,
+                        std::string &err) {
+    out = StorageCheckpoint();
+    if (!wal_enabled(db)) return true;
+    int64_t frames = db->wal.frames;
+    if (db->in_transaction) {
+        /* The open transaction's catalog is not committed yet */
+        out.busy = 1;
+        out.log = frames;
+        return true;
+    }
+    if (!storage_save(db, err) || !wal_restart(db, mode == "TRUNCATE", err)) return false;
+    wal_rebase(db);
+    out.log = frames;
+    out.checkpointed = frames;
+    return true;
+}
+
+bool storage_journal_mode(svdb_db_t *db, const std::string &mode, std::string &err) {
+    if (mode == db->wal_mode) return true;
+    if (db->read_only && storage_is_file(db)) {
+        err = "attempt to write a readonly database";
+        return false;
+    }
+    if (db->in_transaction && storage_is_file(db)) {
+        err = "cannot change journal mode from within a transaction";
+        return false;
+    }
+    bool was_wal = wal_enabled(db);
+    db->wal_mode = mode;
+    if (!storage_is_file(db)) return true;
+    if (!storage_save(db, err)) return false;
+    if (mode == "WAL") {
+        if (!wal_restart(db, false, err)) return false;
+        wal_rebase(db);
+    } else if (was_wal && remove(wal_path(db).c_str()) != 0 && errno != ENOENT) {
+        err = "cannot remove " + wal_path(db) + ": " + strerror(errno);
+        return false;
+    }
+    return true;
+}
+
+bool storage_close(svdb_db_t *db, std::string &err) {
+    /* A read-only database leaves its file and log as it found them */
+    if (!storage_is_file(db) || db->read_only) return true;
+    if (wal_enabled(db)) {
+        if ((db->wal.frames > 0 || db->data_gen != db->saved_gen) && !storage_save(db, err))
+            return false;
+        if (remove(wal_path(db).c_str()) != 0 && errno != ENOENT) {
+            err = "cannot remove " + wal_path(db) + ": " + strerror(errno);
+            return false;
+        }
+        return true;
+    }
+    return db->data_gen == db->saved_gen || storage_save(db, err);
+}
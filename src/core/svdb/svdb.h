@@ -51,7 +51,17 @@ typedef struct {
 } svdb_val_t;
 
 /* ── Database lifecycle ──────────────────────────────────────── */
+/* Open flags for svdb_open_v2; svdb_open is READWRITE|CREATE */
+enum {
+    SVDB_OPEN_READONLY  = 0x01,  /* refuse every write */
+    SVDB_OPEN_READWRITE = 0x02,
+    SVDB_OPEN_CREATE    = 0x04,  /* create the file if it does not exist */
+    SVDB_OPEN_MEMORY    = 0x80,  /* in memory, whatever the path */
+};
+
 svdb_code_t   svdb_open(const char *path, svdb_db_t **db);
+/* SVDB_NOTFOUND when the file does not exist and CREATE is not given */
+svdb_code_t   svdb_open_v2(const char *path, int flags, svdb_db_t **db);
 svdb_code_t   svdb_close(svdb_db_t *db);
 const char   *svdb_errmsg(svdb_db_t *db);
 
@@ -62,6 +72,12 @@ svdb_code_t   svdb_exec(svdb_db_t *db, const char *sql, svdb_result_t *res);
 svdb_code_t   svdb_query(svdb_db_t *db, const char *sql, svdb_rows_t **rows);
 int           svdb_rows_column_count(svdb_rows_t *rows);
 const char   *svdb_rows_column_name(svdb_rows_t *rows, int col);
+/* Declared type, source table and source column of a result column; NULL
+ * when the column is computed or its origin is unknown */
+const char   *svdb_rows_column_decltype(svdb_rows_t *rows, int col);
+const char   *svdb_rows_column_table(svdb_rows_t *rows, int col);
+const char   *svdb_rows_column_origin(svdb_rows_t *rows, int col);
+int           svdb_rows_column_nullable(svdb_rows_t *rows, int col); /* 1, 0=NOT NULL, -1=unknown */
 int           svdb_rows_next(svdb_rows_t *rows);   /* 1=row, 0=done */
 svdb_val_t    svdb_rows_get(svdb_rows_t *rows, int col);
 void          svdb_rows_close(svdb_rows_t *rows);
@@ -85,15 +101,91 @@ svdb_code_t   svdb_rollback(svdb_tx_t *tx);
 svdb_code_t   svdb_savepoint(svdb_tx_t *tx, const char *name);
 svdb_code_t   svdb_release(svdb_tx_t *tx, const char *name);
 svdb_code_t   svdb_rollback_to(svdb_tx_t *tx, const char *name);
+/* 1 while a transaction is open on db, from BEGIN or svdb_begin, else 0 */
+int           svdb_in_transaction(svdb_db_t *db);
+
+/* ── Bulk loading ────────────────────────────────────────────── */
+/* Stores rows given as values without SQL text, checking constraints a
+ * chunk at a time. Triggers do not fire. */
+typedef struct svdb_bulk_s   svdb_bulk_t;
+
+/* Load the ncols columns cols of table, or all of them if ncols is 0 */
+svdb_code_t   svdb_bulk_begin(svdb_db_t *db, const char *table, const char *const *cols,
+                              int ncols, svdb_bulk_t **bulk);
+int           svdb_bulk_column_count(svdb_bulk_t *bulk);
+/* Store nrows rows of svdb_bulk_column_count values each, row by row, as
+ * one statement. Rows that violate a constraint are skipped; if more than
+ * max_rejects are (max_rejects < 0: no limit) none are stored and the last
+ * one's error is returned. Either way the rejected rows are listed. */
+svdb_code_t   svdb_bulk_insert(svdb_bulk_t *bulk, const svdb_val_t *vals, int nrows,
+                               int64_t max_rejects, svdb_result_t *res);
+int           svdb_bulk_reject_count(svdb_bulk_t *bulk);
+/* Why rejected row i was, NULL if there is no such reject. *row is its
+ * position in the rows of the svdb_bulk_insert call. */
+const char   *svdb_bulk_reject(svdb_bulk_t *bulk, int i, int64_t *row);
+/* Build the table's indexes now rather than at the first query using them */
+svdb_code_t   svdb_bulk_build_indexes(svdb_bulk_t *bulk);
+svdb_code_t   svdb_bulk_close(svdb_bulk_t *bulk);
 
 /* ── Schema introspection ────────────────────────────────────── */
+/* Rows of (name, sql, type) for each table and view; type is "table" or "view" */
 svdb_code_t   svdb_tables(svdb_db_t *db, svdb_rows_t **rows);
 svdb_code_t   svdb_columns(svdb_db_t *db, const char *table, svdb_rows_t **rows);
 svdb_code_t   svdb_indexes(svdb_db_t *db, const char *table, svdb_rows_t **rows);
 
+/* ── Table scans ─────────────────────────────────────────────── */
+/* Up to max rows of table from position start on, in the order they are
+ * stored, with all its columns; fewer once the table ends. *gen is set to a
+ * number that changes whenever rows of the database change, so a scan made
+ * in several calls can tell a write came in between. */
+svdb_code_t   svdb_table_scan(svdb_db_t *db, const char *table, int64_t start, int max,
+                              svdb_rows_t **rows, uint64_t *gen);
+
 /* ── Backup ──────────────────────────────────────────────────── */
 svdb_code_t   svdb_backup(svdb_db_t *src, const char *dest_path);
 
+/* ── FTS5 tokenizers ─────────────────────────────────────────── */
+/* Tokens produced by a tokenizer callback; see svdb_tokens_add() */
+typedef struct svdb_tokens_s svdb_tokens_t;
+
+/* Split text[0..len) into tokens, adding each with svdb_tokens_add().
+ * ctx is the value given to svdb_register_tokenizer(). Called with the
+ * database locked, so it must not use the database itself. */
+typedef svdb_code_t (*svdb_tokenizer_fn)(uintptr_t ctx, const char *text, int len,
+                                         svdb_tokens_t *out);
+
+/* Add token term[0..term_len) covering bytes start..end of the text. A
+ * colocated token is a synonym at the same position as the token before it. */
+void          svdb_tokens_add(svdb_tokens_t *out, const char *term, int term_len,
+                              int start, int end, int colocated);
+
+/* Make tokenizer name usable as fts5(..., tokenize='name'). Replaces a
+ * tokenizer registered earlier under the same name (case-insensitive). */
+svdb_code_t   svdb_register_tokenizer(svdb_db_t *db, const char *name,
+                                      svdb_tokenizer_fn fn, uintptr_t ctx);
+
+/* ── Table-valued functions ──────────────────────────────────── */
+/* The columns and rows a table function produces */
+typedef struct svdb_table_s svdb_table_t;
+
+/* Produce the table FROM name(args) reads: add its columns with
+ * svdb_table_add_column(), then its rows with svdb_table_add_row(). On an
+ * error, describe it with svdb_table_error() and return SVDB_ERR. ctx is the
+ * value given to svdb_register_table_function(). Called with the database
+ * locked, so it must not use the database itself. */
+typedef svdb_code_t (*svdb_table_fn)(uintptr_t ctx, const svdb_val_t *args, int nargs,
+                                     svdb_table_t *out);
+
+void          svdb_table_add_column(svdb_table_t *out, const char *name, const char *decl_type);
+/* Add a row of one value per column added */
+void          svdb_table_add_row(svdb_table_t *out, const svdb_val_t *vals);
+void          svdb_table_error(svdb_table_t *out, const char *msg);
+
+/* Make name(args) usable as a table in FROM clauses. Replaces a function
+ * registered earlier under the same name (case-insensitive). */
+svdb_code_t   svdb_register_table_function(svdb_db_t *db, const char *name,
+                                           svdb_table_fn fn, uintptr_t ctx);
+
 /* ── Version ─────────────────────────────────────────────────── */
 const char   *svdb_version(void);
 int           svdb_version_number(void);
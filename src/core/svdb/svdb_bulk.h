@@ -0,0 +1,52 @@
+/* svdb_bulk.h — bulk loading and COPY ... FROM */
+#pragma once
+#include <string>
+#include <unordered_set>
+#include <vector>
+#include "svdb_types.h"
+
+/* A row a bulk load turned away: its position in the rows given (or its
+ * line, for COPY) and why */
+struct BulkReject {
+    int64_t     row = 0;
+    std::string reason;
+};
+
+/* One uniqueness rule of the target table, with the keys it already holds */
+struct BulkUnique {
+    std::vector<std::string> cols;   /* key columns; empty for an index */
+    std::string index;               /* partial or expression UNIQUE index */
+    std::string error;               /* message for a duplicate key */
+    bool numeric = false;            /* 1 and 1.0 are the same key, as in key order */
+    std::unordered_set<std::string> keys;
+};
+
+/* A load into one table. The key sets let each row be checked against the
+ * rows already stored without scanning them; they are rebuilt when other
+ * statements changed the data since the last chunk. */
+struct BulkLoad {
+    std::string table;
+    std::vector<std::string> cols;   /* columns the values are given for */
+    std::vector<BulkUnique> uniques;
+    uint64_t gen = 0;                /* db->data_gen the key sets match */
+    bool defer_sort = false;         /* leave WITHOUT ROWID rows unsorted (COPY) */
+};
+
+/* Prepare a load of cols (every column, in order, if empty) of table.
+ * False if there is no such table or column; reason in db->last_error. */
+bool bulk_open(svdb_db_t *db, const std::string &table, const std::vector<std::string> &cols,
+               BulkLoad &b);
+
+/* Check rows, each holding a value per column of b, against the table's
+ * defaults, affinity, NOT NULL, PRIMARY KEY, UNIQUE, CHECK and FOREIGN KEY
+ * constraints, and store those that pass as one statement. Triggers do not
+ * fire. Rejected rows are skipped and listed in rejects, numbered from
+ * first_row; if more than max_rejects are rejected (max_rejects < 0: no
+ * limit) nothing is stored and SVDB_ERR is returned. Call with db->mu held. */
+svdb_code_t bulk_insert(svdb_db_t *db, BulkLoad &b, std::vector<std::vector<SvdbVal>> &rows,
+                        int64_t first_row, int64_t max_rejects, std::vector<BulkReject> &rejects);
+
+/* Run COPY table [(cols)] FROM 'path' [WITH (FORMAT csv, HEADER, DELIMITER
+ * 'c', NULL 'text')]. The file loads completely or not at all; a bad line
+ * fails the statement with its line number in db->last_error. */
+svdb_code_t copy_exec(svdb_db_t *db, const std::string &sql, svdb_result_t *res);
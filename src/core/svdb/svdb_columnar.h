@@ -0,0 +1,29 @@
+/* svdb_columnar.h — column images of COLUMNAR tables and vectorized scans */
+#pragma once
+#include <string>
+#include <vector>
+#include "svdb_types.h"
+
+/* Answer sql from the column image of a COLUMNAR table when it is a
+ * single-table SELECT of columns, or of aggregates optionally grouped by one
+ * column, filtered by a conjunction of column-versus-literal predicates.
+ * Returns false, leaving rows_out untouched, for anything else so the row
+ * executor runs it instead. */
+bool columnar_try_select(svdb_db_t *db, const std::string &sql, svdb_rows_t **rows_out);
+
+/* The EXPLAIN QUERY PLAN detail of sql when columnar_try_select() would
+ * answer it, e.g. "SCAN t USING COLUMNAR (bitmap index on region)" */
+bool columnar_explain(svdb_db_t *db, const std::string &sql, std::string &detail);
+
+/* Drop the cached column image of table */
+void columnar_forget(svdb_db_t *db, const std::string &table);
+
+/* Patch the column images after a write that just moved db->data_gen: the
+ * row of table at pos was changed, or appended when pos is the old row
+ * count. Images of other tables are carried over to the new generation, and
+ * one that had already missed a write is left for the next scan to rebuild. */
+void columnar_note_row(svdb_db_t *db, const std::string &table, size_t pos);
+
+/* Likewise after a DELETE removed the rows of table at the ascending
+ * positions gone, counted before the delete */
+void columnar_note_delete(svdb_db_t *db, const std::string &table, const std::vector<size_t> &gone);
@@ -0,0 +1,8 @@
+/* svdb_describe.h — declared types and origins of result columns */
+#pragma once
+#include <string>
+#include "svdb_types.h"
+
+/* Fill rows->col_meta for the SELECT sql produced. Columns the select list
+ * and FROM clause don't account for are left unknown. */
+void describe_columns(svdb_db_t *db, const std::string &sql, svdb_rows_t *rows);
@@ -0,0 +1,102 @@
+/* svdb_fts5.h — FTS5 virtual tables on top of the svdb row store */
+#pragma once
+#include <memory>
+#include <string>
+#include <vector>
+#include <unordered_map>
+#include "svdb_types.h"
+
+/* A token of column text: the index term, its byte range in the text and
+ * its position. Colocated tokens (synonyms) share a position. */
+struct Fts5Token {
+    std::string term;
+    int start = 0;
+    int end   = 0;
+    int pos   = 0;
+};
+
+/* Tokens collected from a registered tokenizer by svdb_tokens_add() */
+struct svdb_tokens_s {
+    std::vector<Fts5Token> toks;
+    int len = 0;                /* byte length of the text being tokenized */
+};
+
+/* One phrase of a MATCH query, e.g. "new york"* restricted to {title body} */
+struct Fts5Phrase {
+    std::vector<std::string> terms;
+    bool prefix  = false;       /* last term is a prefix (trailing '*') */
+    bool initial = false;       /* must start the column ('^') */
+    std::vector<bool> cols;     /* columns the phrase may match in */
+};
+
+/* A matched phrase instance: token positions first..last of column col */
+struct Fts5Hit {
+    int col   = 0;
+    int first = 0;
+    int last  = 0;
+};
+
+/* The rows matching a MATCH query, with what bm25(), highlight() and
+ * snippet() need to know about them. */
+struct Fts5Match {
+    std::vector<Fts5Phrase> phrases;
+    /* per phrase: rowid -> occurrences of the phrase in each column */
+    std::vector<std::unordered_map<int64_t, std::vector<int>>> freq;
+    /* matching rowid -> phrase instances that satisfied the query */
+    std::unordered_map<int64_t, std::vector<Fts5Hit>> docs;
+};
+
+/* Parse the arguments of fts5(...) into ft. Returns false with err set on a
+ * bad column or option, or an unknown tokenizer. */
+bool fts5_parse_args(svdb_db_t *db, const std::vector<std::string> &args, Fts5Table &ft,
+                     std::string &err);
+
+/* Split text into tokens with the table's tokenizer */
+std::vector<Fts5Token> fts5_tokens(const Fts5Table &ft, const std::string &text);
+
+/* False while tokenize= names a tokenizer that is not registered yet */
+bool fts5_tokenizer_ready(const Fts5Table &ft);
+
+/* An empty index tokenizing with the table's tokenizer */
+std::shared_ptr<svdb::FTS5Index> fts5_new_index(const Fts5Table &ft);
+
+/* The indexed text of each column of row; UNINDEXED columns are empty */
+std::vector<std::string> fts5_row_values(const Fts5Table &ft, const Row &row);
+
+/* Bring the index of table tname up to date with its rows */
+void fts5_sync(svdb_db_t *db, const std::string &tname, Fts5Table &ft);
+
+/* For an external-content or contentless table, replace the column values of
+ * rows read from it with those of the content table (NULL when contentless) */
+bool fts5_content_rows(svdb_db_t *db, const Fts5Table &ft, std::vector<Row> &rows,
+                       std::string &err);
+
+/* Run a special command, INSERT INTO t(t[, rowid, ...]) VALUES('cmd'[, ...]):
+ * 'delete' (of document rowid), 'delete-all', 'rebuild', 'optimize' or
+ * 'integrity-check'. Returns false with err set if the command fails. */
+bool fts5_command(svdb_db_t *db, const std::string &tname, Fts5Table &ft,
+                  const std::string &cmd, const SvdbVal &rowid, std::string &err);
+
+/* Run a MATCH query against a synced table. col restricts the whole query to
+ * one column (for "col MATCH ..."); empty means all columns. */
+bool fts5_match(const Fts5Table &ft, const std::string &col, const std::string &query,
+                Fts5Match &out, std::string &err);
+
+/* bm25() of a matching row: negative, lower is better. weights are per column;
+ * missing weights default to 1.0. */
+double fts5_bm25(const Fts5Table &ft, const Fts5Match &m, int64_t rowid,
+                 const std::vector<double> &weights);
+
+/* highlight(): the text of column col of row with every matched phrase
+ * wrapped in open/close */
+std::string fts5_highlight(const Fts5Table &ft, const Fts5Match &m, int64_t rowid,
+                           int col, const Row &row,
+                           const std::string &open, const std::string &close);
+
+/* snippet(): up to ntokens tokens of column col around its matches (the
+ * column with the most matches when col < 0), highlighted, with ellipsis
+ * marking trimmed text */
+std::string fts5_snippet(const Fts5Table &ft, const Fts5Match &m, int64_t rowid,
+                         int col, const Row &row,
+                         const std::string &open, const std::string &close,
+                         const std::string &ellipsis, int ntokens);
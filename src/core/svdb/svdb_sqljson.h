@@ -0,0 +1,25 @@
+/* svdb_sqljson.h — SQL/JSON query functions and JSON_TABLE */
+#pragma once
+#include <string>
+#include <vector>
+#include "svdb_types.h"
+
+/* The JSONB of a SQL value: JSONB blobs as they are, JSON text encoded.
+ * False when the value is not JSON. */
+bool sqljson_doc(const SvdbVal &v, std::string &jsonb);
+
+/* The SQL value of a JSONB element: numbers and strings as such, true and
+ * false as 1 and 0, null as NULL, arrays and objects as JSON text */
+SvdbVal sqljson_scalar(const std::string &elem);
+
+/* JSON_VALUE, JSON_QUERY or JSON_EXISTS (fn, upper case) applied to args,
+ * the text between its parentheses. False on an error, left in err. */
+bool sqljson_call(const std::string &fn, const std::string &args, const Row &row,
+                  const std::vector<std::string> &col_order, SvdbVal &out, std::string &err);
+
+/* If the FROM clause of sql reads a JSON_TABLE(...), materialize its rows in
+ * a temporary table and rewrite sql to read that instead. A JSON_TABLE that
+ * follows other tables is evaluated once per distinct document they supply
+ * and joined to them. Sets tmp to the table to drop once sql has run, or
+ * leaves it empty if there was no JSON_TABLE. False on an error, left in err. */
+bool sqljson_rewrite_table(svdb_db_t *db, std::string &sql, std::string &tmp, std::string &err);
@@ -0,0 +1,102 @@
+/* svdb_storage.h — database file persistence and page compression */
+#pragma once
+#include <string>
+#include "svdb_types.h"
+
+/* Page codecs, as stored in the file header and in each page */
+enum StorageCodec {
+    CODEC_NONE = 0,
+    CODEC_LZ4  = 1,
+    CODEC_ZSTD = 2,
+    CODEC_RLE  = 4,
+    CODEC_GZIP = 5,
+};
+
+/* The codec of a PRAGMA compression or WITH (compression=...) name, matched
+ * case-insensitively. -1 if the name is not a supported codec. */
+int storage_codec_id(const std::string &name);
+/* The upper-case name of a codec id */
+const char *storage_codec_name(int codec);
+
+/* The codec the pages of table are written with: its own compression
+ * option, else the database default (PRAGMA compression) */
+int storage_table_codec(svdb_db_t *db, const std::string &table);
+
+/* What the pages of one table take on disk */
+struct StorageStats {
+    int     codec        = CODEC_NONE;
+    int64_t pages        = 0;
+    int64_t raw_bytes    = 0;  /* encoded rows before compression */
+    int64_t stored_bytes = 0;  /* page headers plus compressed payloads */
+};
+
+/* Encode and compress the rows of table as they would be saved */
+StorageStats storage_table_stats(svdb_db_t *db, const std::string &table);
+
+/* Pages of the database file the committed rows no longer fill (PRAGMA
+ * freelist_count). 0 for an in-memory database. */
+int64_t storage_free_pages(svdb_db_t *db);
+
+/* Give back up to pages free pages, all of them if pages <= 0; the file
+ * shrinks the next time it is written. Returns the pages given back. */
+int64_t storage_release_pages(svdb_db_t *db, int64_t pages);
+
+/* True if db->path names a database file rather than an in-memory database */
+bool storage_is_file(const svdb_db_t *db);
+
+/* Read the database file at db->path into db. A missing or empty file is an
+ * empty database, which starts in WAL mode unless db is read-only. False on
+ * a corrupt or unreadable file, reason in err. */
+bool storage_load(svdb_db_t *db, std::string &err);
+
+/* Write db to the file at db->path, replacing it atomically. False on an
+ * I/O error, reason in err. */
+bool storage_save(svdb_db_t *db, std::string &err);
+
+/* Write the committed state of db, in memory or not, as a standalone
+ * database file at path (VACUUM INTO). The copy is not in WAL mode. */
+bool storage_save_copy(svdb_db_t *db, const std::string &path, std::string &err);
+
+/* Make what the last statement or transaction committed durable. In WAL
+ * mode append it to the log at "<path>-wal", synced per PRAGMA synchronous,
+ * and checkpoint once the log holds PRAGMA wal_autocheckpoint frames;
+ * otherwise rewrite the whole database file if anything changed, which takes
+ * time in proportion to its size. A no-op inside a transaction. False on an
+ * I/O error, reason in err. */
+bool storage_commit(svdb_db_t *db, std::string &err);
+
+/* Record, right after a write moved db->data_gen, which rows of table it
+ * touched: all but the first keep and the last tail of them. The next WAL
+ * frame carries only the rows between the head and tail no write since the
+ * last frame touched. A data_gen move that is not noted makes that frame
+ * carry every table whole. */
+void storage_note_rows(svdb_db_t *db, const std::string &table, size_t keep, size_t tail);
+
+/* Record that any row of table may have changed, e.g. in ALTER TABLE or
+ * DROP TABLE. Needs no data_gen move of its own. */
+void storage_note_table(svdb_db_t *db, const std::string &table);
+
+/* Record, right after a write moved db->data_gen, that it left the rows as
+ * they were or put them back as they were at some point since the last
+ * frame (a rollback), apart from tables noted with storage_note_table */
+void storage_note_kept(svdb_db_t *db);
+
+/* The result row of PRAGMA wal_checkpoint */
+struct StorageCheckpoint {
+    int64_t busy         = 0;  /* 1 if a transaction kept the log from being folded */
+    int64_t log          = 0;  /* frames in the log */
+    int64_t checkpointed = 0;  /* frames folded into the main file */
+};
+
+/* Fold the log into the main file and restart it; mode TRUNCATE also
+ * leaves it empty. Reports zeros outside WAL mode. */
+bool storage_checkpoint(svdb_db_t *db, const std::string &mode, StorageCheckpoint &out,
+                        std::string &err);
+
+/* Switch db->wal_mode to mode ("WAL" or "DELETE"), starting or removing the
+ * log of a database file */
+bool storage_journal_mode(svdb_db_t *db, const std::string &mode, std::string &err);
+
+/* Write unsaved changes before db is closed; in WAL mode this checkpoints
+ * and removes the log */
+bool storage_close(svdb_db_t *db, std::string &err);
@@ -0,0 +1,14 @@
+/* svdb_tablefn.h — table-valued functions registered by the application */
+#pragma once
+#include <string>
+#include <vector>
+#include "svdb_types.h"
+
+/* Call each registered table function the FROM clauses of sql read, put its
+ * rows in a temporary table and rewrite sql to read that instead. Appends
+ * the tables to drop once sql has run to tmps, also on failure. False on an
+ * error, left in err. */
+bool tablefn_rewrite(svdb_db_t *db, std::string &sql, std::vector<std::string> &tmps, std::string &err);
+
+/* Drop the temporary tables tablefn_rewrite() made */
+void tablefn_drop(svdb_db_t *db, const std::vector<std::string> &tmps);
@@ -1,9 +1,17 @@
 #pragma once
 #include <string>
+#include <string_view>
+#include <cstdlib>
 #include <vector>
 #include <map>
+#include <memory>
+#include <set>
 #include <unordered_map>
 #include <mutex>
+#include <atomic>
+#include <optional>
+#include <type_traits>
+#include <iterator>
 #include "svdb.h"
 
 /* Column type string e.g. "INTEGER", "TEXT", "REAL", "BLOB" */
@@ -28,8 +36,14 @@ struct FKDef {
     std::string parent_col;
     std::string on_delete; /* "CASCADE", "SET NULL", "RESTRICT", "NO ACTION", or "" */
     std::string on_update; /* "CASCADE", "SET NULL", "RESTRICT", "NO ACTION", or "" */
+    bool deferrable = false;          /* DEFERRABLE */
+    bool initially_deferred = false;  /* DEFERRABLE INITIALLY DEFERRED */
 };
 
+/* SET CONSTRAINTS ALL ... mode for the current transaction; it applies to
+ * DEFERRABLE foreign keys only */
+enum ConstraintMode { CONSTRAINTS_DEFAULT, CONSTRAINTS_DEFERRED, CONSTRAINTS_IMMEDIATE };
+
 /* Trigger timing */
 enum TriggerTiming { TRIGGER_BEFORE, TRIGGER_AFTER, TRIGGER_INSTEAD_OF };
 /* Trigger event */
@@ -43,27 +57,428 @@ struct TriggerDef {
     std::string   table;         /* target table */
     std::string   when_expr;     /* optional WHEN condition */
     std::string   body;          /* raw SQL of BEGIN ... END body */
+    std::string   sql;           /* CREATE TRIGGER as written; empty once reloaded */
 };
 
 /* Table schema: column name -> ColDef */
 using TableDef = std::unordered_map<std::string, ColDef>;
 
-/* A single cell value stored in memory */
+/* The bytes of a TEXT or BLOB value in 8 bytes (row.cpp). Up to six bytes
+ * are kept inline: the low byte of the word holds the length and a tag bit,
+ * the bytes and a NUL fill the other seven, at kInline in memory. Longer values live in a shared heap block holding a
+ * reference count, the length as a varint, the bytes and a NUL; copies share
+ * the block and assignment always makes a new one, so a block never changes
+ * once written. Reads go through std::string_view; an implicit std::string
+ * conversion keeps the code that takes strings working. */
+class SvdbStr {
+public:
+    SvdbStr() = default;
+    explicit SvdbStr(std::string_view s) { assign(s.data(), s.size()); }
+    SvdbStr(const SvdbStr &o) : w_(o.w_) { retain(); }
+    SvdbStr(SvdbStr &&o) noexcept : w_(o.w_) { o.w_ = 0; }
+    ~SvdbStr() { release(); }
+
+    SvdbStr &operator=(const SvdbStr &o) {
+        if (this != &o) { o.retain(); release(); w_ = o.w_; }
+        return *this;
+    }
+    SvdbStr &operator=(SvdbStr &&o) noexcept { std::swap(w_, o.w_); return *this; }
+    SvdbStr &operator=(std::string_view s) { assign(s.data(), s.size()); return *this; }
+    void assign(const char *s, size_t n);
+
+    size_t size() const { return view().size(); }
+    bool empty() const { return w_ == 0; }
+    const char *data() const { return view().data(); }
+    const char *c_str() const { return data(); }
+    char operator[](size_t i) const { return data()[i]; }
+    const char *begin() const { return data(); }
+    const char *end() const { std::string_view v = view(); return v.data() + v.size(); }
+    std::string_view view() const {
+        if (w_ == 0) return {"", 0};
+        if (w_ & 1) return {(const char *)&w_ + kInline, (size_t)((w_ & 0xff) >> 1)};
+        const char *p = block() + sizeof(uint32_t);
+        if ((unsigned char)p[0] < 0x80) return {p + 1, (size_t)(unsigned char)p[0]};
+        return view_long();
+    }
+    std::string str() const { return std::string(view()); }
+    operator std::string_view() const { return view(); }
+    operator std::string() const { return str(); }
+    int compare(std::string_view s) const { return view().compare(s); }
+    /* Bytes of the heap block, 0 when the value is inline */
+    size_t heap_bytes() const;
+
+    friend bool operator==(const SvdbStr &a, const SvdbStr &b) { return a.w_ == b.w_ || a.view() == b.view(); }
+    friend bool operator!=(const SvdbStr &a, const SvdbStr &b) { return !(a == b); }
+    friend bool operator<(const SvdbStr &a, const SvdbStr &b) { return a.view() < b.view(); }
+    friend bool operator>(const SvdbStr &a, const SvdbStr &b) { return a.view() > b.view(); }
+    friend bool operator==(const SvdbStr &a, std::string_view b) { return a.view() == b; }
+    friend bool operator!=(const SvdbStr &a, std::string_view b) { return a.view() != b; }
+    friend bool operator==(std::string_view a, const SvdbStr &b) { return a == b.view(); }
+    friend bool operator!=(std::string_view a, const SvdbStr &b) { return a != b.view(); }
+    friend std::string operator+(const SvdbStr &a, std::string_view b) {
+        std::string s = a.str();
+        s.append(b.data(), b.size());
+        return s;
+    }
+    friend std::string operator+(std::string_view a, const SvdbStr &b) {
+        std::string s(a);
+        s += b.view();
+        return s;
+    }
+
+private:
+    /* Where the inline bytes start in memory: after the low byte on a
+     * little-endian machine, before it on a big-endian one */
+#if __BYTE_ORDER__ == __ORDER_LITTLE_ENDIAN__
+    static constexpr size_t kInline = 1;
+#elif __BYTE_ORDER__ == __ORDER_BIG_ENDIAN__
+    static constexpr size_t kInline = 0;
+#else
+#error "SvdbStr needs a little- or big-endian byte order"
+#endif
+    /* Heap blocks are malloc'ed, so their low bit is clear */
+    bool heap() const { return w_ != 0 && !(w_ & 1); }
+    char *block() const { return (char *)(uintptr_t)w_; }
+    std::atomic<uint32_t> &refs() const { return *(std::atomic<uint32_t> *)block(); }
+    void retain() const { if (heap()) refs().fetch_add(1, std::memory_order_relaxed); }
+    void release() {
+        if (heap() && refs().fetch_sub(1, std::memory_order_acq_rel) == 1) std::free(block());
+    }
+    std::string_view view_long() const;
+
+    uint64_t w_ = 0;
+};
+
+/* A single cell value stored in memory: the type, one number and the bytes
+ * of a TEXT or BLOB. ival and rval share storage, so only the member that
+ * matches type is meaningful. */
 struct SvdbVal {
-    svdb_type_t type   = SVDB_TYPE_NULL;
-    int64_t     ival   = 0;
-    double      rval   = 0.0;
-    std::string sval;   /* TEXT or BLOB */
+    svdb_type_t type = SVDB_TYPE_NULL;
+    union {
+        int64_t ival = 0;
+        double  rval;
+    };
+    SvdbStr sval;   /* TEXT or BLOB */
+
+    SvdbVal() = default;
+    SvdbVal(svdb_type_t t, int64_t i = 0, double r = 0.0, std::string_view s = {})
+        : type(t), sval(s) {
+        if (t == SVDB_TYPE_REAL) rval = r;
+        else ival = i;
+    }
+};
+
+/* The keys of a row in position order. Layouts are interned (row.cpp): rows
+ * whose keys were added in the same order share one layout, so a stored row
+ * holds only its values and each key string exists once per layout. Layouts
+ * are immutable and counted by the rows using them and by the layouts one key
+ * longer. Unheld layouts are swept as the intern table grows, so the layouts
+ * of dropped tables and finished queries do not pile up. */
+struct RowLayout {
+    std::vector<std::string> keys;
+    std::unordered_map<std::string, uint32_t> pos;  /* key -> position */
+    /* The layout one key shorter; null for the empty layout, which is
+     * never counted or freed */
+    const RowLayout *parent = nullptr;
+    /* Layouts reached by adding one key; guarded by the intern lock */
+    mutable std::unordered_map<std::string, const RowLayout *> next;
+    mutable std::atomic<int64_t> refs{0};
+
+    /* Position of key, or -1 */
+    int find(const std::string &key) const {
+        if (keys.size() <= 8) {
+            for (size_t i = 0; i < keys.size(); ++i)
+                if (keys[i] == key) return (int)i;
+            return -1;
+        }
+        auto it = pos.find(key);
+        return it == pos.end() ? -1 : (int)it->second;
+    }
+    /* This layout with key appended; the caller owns a reference */
+    const RowLayout *with(const std::string &key) const;
+    /* This layout without the key at position i; the caller owns a reference */
+    const RowLayout *without(size_t i) const;
+    /* The layout of a row with no keys */
+    static const RowLayout *empty();
+    /* Take and drop a reference */
+    void retain() const { if (parent) refs.fetch_add(1, std::memory_order_relaxed); }
+    static void release(const RowLayout *l) {
+        if (l->parent) l->refs.fetch_sub(1, std::memory_order_release);
+    }
+    /* Free the unheld layouts, then report the number left and their bytes */
+    static void stats(int64_t &count, int64_t &bytes);
+};
+
+/* A row: values addressed by position in a shared RowLayout. It keeps the
+ * interface of the std::unordered_map<std::string, SvdbVal> it replaces;
+ * iteration is in key insertion order. Hot paths resolve their columns to
+ * positions once, through ColumnSlots, and read values with at_pos(). */
+class Row {
+public:
+    template <bool Const>
+    class Iter {
+    public:
+        using RowPtr = typename std::conditional<Const, const Row *, Row *>::type;
+        using Val = typename std::conditional<Const, const SvdbVal, SvdbVal>::type;
+        using value_type = std::pair<const std::string &, Val &>;
+        using reference = value_type &;
+        using pointer = value_type *;
+        using difference_type = std::ptrdiff_t;
+        using iterator_category = std::forward_iterator_tag;
+
+        Iter() = default;
+        Iter(RowPtr row, size_t i) : row_(row), i_(i) {}
+        Iter(const Iter &o) : row_(o.row_), i_(o.i_) {}
+        template <bool C = Const, typename = typename std::enable_if<C>::type>
+        Iter(const Iter<false> &o) : row_(o.row_), i_(o.i_) {}
+        Iter &operator=(const Iter &o) { row_ = o.row_; i_ = o.i_; cur_.reset(); return *this; }
+
+        reference operator*() const {
+            cur_.reset();
+            cur_.emplace(row_->layout_->keys[i_], row_->vals_[i_]);
+            return *cur_;
+        }
+        pointer operator->() const { return &**this; }
+        Iter &operator++() { ++i_; return *this; }
+        Iter operator++(int) { Iter t(*this); ++i_; return t; }
+        bool operator==(const Iter &o) const { return i_ == o.i_ && row_ == o.row_; }
+        bool operator!=(const Iter &o) const { return !(*this == o); }
+        size_t index() const { return i_; }
+
+    private:
+        template <bool> friend class Iter;
+        friend class Row;
+        RowPtr row_ = nullptr;
+        size_t i_ = 0;
+        mutable std::optional<value_type> cur_;
+    };
+    using iterator = Iter<false>;
+    using const_iterator = Iter<true>;
+    using key_type = std::string;
+    using mapped_type = SvdbVal;
+
+    Row() : layout_(RowLayout::empty()) {}
+    Row(std::initializer_list<std::pair<const std::string, SvdbVal>> init) : Row() {
+        for (auto &kv : init) (*this)[kv.first] = kv.second;
+    }
+    Row(const Row &o) : layout_(o.layout_), vals_(o.vals_) { layout_->retain(); }
+    Row(Row &&o) noexcept : layout_(o.layout_), vals_(std::move(o.vals_)) {
+        o.layout_ = RowLayout::empty();
+        o.vals_.clear();
+    }
+    Row &operator=(const Row &o) {
+        if (this == &o) return *this;
+        o.layout_->retain();
+        RowLayout::release(layout_);
+        layout_ = o.layout_;
+        vals_ = o.vals_;
+        return *this;
+    }
+    Row &operator=(Row &&o) noexcept {
+        if (this == &o) return *this;
+        RowLayout::release(layout_);
+        layout_ = o.layout_;
+        vals_ = std::move(o.vals_);
+        o.layout_ = RowLayout::empty();
+        o.vals_.clear();
+        return *this;
+    }
+    ~Row() { RowLayout::release(layout_); }
+
+    size_t size() const { return vals_.size(); }
+    bool empty() const { return vals_.empty(); }
+    void clear() { set_layout(RowLayout::empty()); vals_.clear(); }
+    void reserve(size_t n) { vals_.reserve(n); }
+
+    iterator begin() { return iterator(this, 0); }
+    iterator end() { return iterator(this, vals_.size()); }
+    const_iterator begin() const { return const_iterator(this, 0); }
+    const_iterator end() const { return const_iterator(this, vals_.size()); }
+    const_iterator cbegin() const { return begin(); }
+    const_iterator cend() const { return end(); }
+
+    iterator find(const std::string &key) {
+        int i = layout_->find(key);
+        return iterator(this, i < 0 ? vals_.size() : (size_t)i);
+    }
+    const_iterator find(const std::string &key) const {
+        int i = layout_->find(key);
+        return const_iterator(this, i < 0 ? vals_.size() : (size_t)i);
+    }
+    size_t count(const std::string &key) const { return layout_->find(key) < 0 ? 0 : 1; }
+
+    SvdbVal &operator[](const std::string &key) {
+        int i = layout_->find(key);
+        if (i >= 0) return vals_[(size_t)i];
+        set_layout(layout_->with(key));
+        vals_.emplace_back();
+        return vals_.back();
+    }
+    SvdbVal &at(const std::string &key);
+    const SvdbVal &at(const std::string &key) const;
+
+    std::pair<iterator, bool> emplace(const std::string &key, SvdbVal v) {
+        int i = layout_->find(key);
+        if (i >= 0) return {iterator(this, (size_t)i), false};
+        (*this)[key] = std::move(v);
+        return {iterator(this, vals_.size() - 1), true};
+    }
+    std::pair<iterator, bool> insert(const std::pair<const std::string, SvdbVal> &kv) {
+        return emplace(kv.first, kv.second);
+    }
+    template <typename It>
+    void insert(It first, It last) {
+        for (; first != last; ++first) emplace(first->first, first->second);
+    }
+    size_t erase(const std::string &key) {
+        int i = layout_->find(key);
+        if (i < 0) return 0;
+        erase_pos((size_t)i);
+        return 1;
+    }
+    iterator erase(const_iterator it) {
+        erase_pos(it.i_);
+        return iterator(this, it.i_);
+    }
+
+    /* Positional access */
+    const RowLayout *layout() const { return layout_; }
+    SvdbVal &at_pos(size_t i) { return vals_[i]; }
+    const SvdbVal &at_pos(size_t i) const { return vals_[i]; }
+    /* Bytes of memory this row takes, including out-of-line strings */
+    size_t heap_bytes() const;
+
+private:
+    /* Switch to l, whose reference the caller already holds */
+    void set_layout(const RowLayout *l) {
+        RowLayout::release(layout_);
+        layout_ = l;
+    }
+    void erase_pos(size_t i) {
+        set_layout(layout_->without(i));
+        vals_.erase(vals_.begin() + (std::ptrdiff_t)i);
+    }
+
+    const RowLayout *layout_;
+    std::vector<SvdbVal> vals_;
 };
 
-/* A row: column name -> value */
-using Row = std::unordered_map<std::string, SvdbVal>;
+/* The positions of a statement's column references, resolved when the
+ * statement is planned rather than looked up by name on every row. They are
+ * resolved against the layout of the first row read, which the rows of one
+ * table share, and again only for a row on another layout. The resolved
+ * layout is held, so it is not freed and its address reused while cached. */
+class ColumnSlots {
+public:
+    /* An empty name is not a column reference and never has a cell */
+    explicit ColumnSlots(std::vector<std::string> names) : names_(std::move(names)) {}
+    ColumnSlots(const ColumnSlots &) = delete;
+    ColumnSlots &operator=(const ColumnSlots &) = delete;
+    ~ColumnSlots() { if (layout_) RowLayout::release(layout_); }
+
+    /* The cell of name i in row, or null when row has no such key */
+    const SvdbVal *get(const Row &row, size_t i) {
+        if (row.layout() != layout_) resolve(row.layout());
+        int p = pos_[i];
+        return p < 0 ? nullptr : &row.at_pos((size_t)p);
+    }
+
+private:
+    void resolve(const RowLayout *l);
+
+    std::vector<std::string> names_;
+    const RowLayout *layout_ = nullptr;
+    std::vector<int> pos_;
+};
 
 /* Index definition */
 struct IndexDef {
     std::string table;
-    std::vector<std::string> columns;
+    std::vector<std::string> columns;  /* key terms: column names or expressions */
     bool unique = false;
+    std::string where;                 /* partial index predicate; empty = all rows */
+    std::string sql;                   /* CREATE INDEX as written; empty once reloaded */
+};
+
+/* Materialized entries of a secondary index, built on demand by the query
+ * planner: (value of the first key term, row position), sorted by value.
+ * Valid only while db->data_gen still equals gen. */
+struct IndexEntries {
+    uint64_t gen = 0;
+    std::vector<std::pair<SvdbVal, size_t>> keys;
+};
+
+/* Keys held by a partial or expression UNIQUE index, encoded as for a bulk
+ * load, with the number of rows holding each. Valid only while db->data_gen
+ * still equals gen; a write of one row folds itself in rather than leaving
+ * the next check to rebuild them (exec.cpp). */
+struct UniqueKeys {
+    uint64_t gen = 0;
+    std::unordered_map<std::string, uint32_t> counts;
+};
+
+/* Table options given after the column list, e.g. CREATE TABLE t(...) STRICT */
+struct TableOpts {
+    bool strict = false;   /* STRICT: reject values that don't match the column type */
+    bool without_rowid = false; /* WITHOUT ROWID: rows clustered on the PRIMARY KEY, no rowid */
+    std::string compression;    /* WITH (compression=...): page codec; empty = PRAGMA compression */
+    bool columnar = false;      /* USING COLUMNAR: scans read a column image (columnar.cpp) */
+};
+
+/* Rows of a table written since the last WAL frame: all but the first keep
+ * and the last tail of them, which are as they were then */
+struct WalDirty {
+    size_t keep = 0;
+    size_t tail = 0;
+};
+
+/* The write-ahead log ("<path>-wal") of a file database in WAL mode. Each
+ * commit appends a frame with the rows that changed since the previous one;
+ * a checkpoint folds the log into the main file. */
+struct WalState {
+    uint64_t salt = 0;       /* ties the log to one main file image */
+    uint64_t chain = 0;      /* checksum of the last frame, seeds the next */
+    uint64_t gen = 0;        /* data_gen as of the last frame */
+    int64_t  frames = 0;     /* frames in the log */
+    uint64_t catalog_crc = 0;
+    /* data_gen up to which every write was noted in dirty; behind data_gen
+     * once a write goes unnoted, and then every table counts as written */
+    uint64_t noted = 0;
+    std::unordered_map<std::string, size_t> rows;     /* row count of each table as of the last frame */
+    std::unordered_map<std::string, WalDirty> dirty;  /* tables written since */
+};
+
+namespace svdb { class FTS5Index; }
+struct ColumnarImage;
+
+/* A tokenizer registered with svdb_register_tokenizer() */
+struct SvdbTokenizer {
+    svdb_tokenizer_fn fn = nullptr;
+    uintptr_t ctx = 0;
+};
+
+/* A table function registered with svdb_register_table_function() */
+struct SvdbTableFn {
+    svdb_table_fn fn = nullptr;
+    uintptr_t ctx = 0;
+};
+
+/* FTS5 virtual table (CREATE VIRTUAL TABLE t USING fts5(...)). Its rows live in
+ * db->data like any table's; the inverted index is derived from them and
+ * saved with them. For external-content and contentless tables those rows
+ * hold the indexed text only, and SELECT reads the columns from the content
+ * table (or NULL). */
+struct Fts5Table {
+    std::vector<std::string> columns;   /* declared columns, in order */
+    std::vector<bool> unindexed;        /* column declared UNINDEXED */
+    std::string tokenize = "unicode61"; /* tokenize= option */
+    SvdbTokenizer custom;               /* registered tokenizer named by tokenize= */
+    std::string content;                /* content= table; empty for none */
+    std::string content_rowid;          /* content_rowid= column; empty for rowid */
+    bool contentless = false;           /* content='' */
+    std::shared_ptr<svdb::FTS5Index> index;
+    uint64_t gen = 0;                   /* db->data_gen the index was synced at */
+    bool loaded = false;                /* index read from the file, not synced since */
 };
 
 /* Database state */
@@ -84,12 +499,42 @@ struct svdb_db_s {
     std::unordered_map<std::string, TriggerDef>                        triggers;
     /* CREATE TABLE original SQL for each table/view */
     std::unordered_map<std::string, std::string>                       create_sql;
+    /* Table options (STRICT, ...) per table; absent = all defaults */
+    std::unordered_map<std::string, TableOpts>                         table_opts;
+    /* FTS5 virtual tables: table_name -> definition and index */
+    std::unordered_map<std::string, Fts5Table>                         fts5;
+    /* Registered FTS5 tokenizers: upper-case name -> callback */
+    std::unordered_map<std::string, SvdbTokenizer>                     tokenizers;
+    /* Registered table-valued functions: upper-case name -> callback */
+    std::unordered_map<std::string, SvdbTableFn>                       table_functions;
+    /* Column images of COLUMNAR tables, rebuilt when data_gen moves */
+    std::unordered_map<std::string, std::shared_ptr<ColumnarImage>>    columnar;
 
     /* In-memory row storage: table_name -> rows */
     std::unordered_map<std::string, std::vector<Row>>                  data;
 
     /* Index metadata: index_name -> IndexDef */
     std::map<std::string, IndexDef>                                    indexes;
+    /* Planner cache of index entries: index_name -> entries */
+    std::unordered_map<std::string, IndexEntries>                      index_cache;
+    /* Key counts of partial and expression UNIQUE indexes: index_name -> keys */
+    std::unordered_map<std::string, UniqueKeys>                        unique_keys;
+    /* Bumped whenever rows, the catalog or a saved setting change;
+     * invalidates index_cache, unique_keys and the other derived images */
+    uint64_t data_gen   = 1;
+    /* data_gen when the database file was last loaded or saved */
+    uint64_t saved_gen  = 0;
+    /* Unix time the database file was first written; 0 = not yet */
+    uint32_t created_at = 0;
+    /* Pages and bytes the table data of the database file spans, free pages
+     * included: deleting rows leaves the span as it was, and the pages it
+     * holds beyond the rows are the freelist (vacuum.cpp) */
+    int64_t  file_pages = 0;
+    int64_t  file_bytes = 0;
+    /* Write-ahead log of a file database in WAL mode (storage.cpp) */
+    WalState wal;
+    /* >0 while a statement is executing, when data may change under a query */
+    int      exec_depth = 0;
 
     /* Auto-increment counters: table_name -> last rowid */
     std::unordered_map<std::string, int64_t>                           rowid_counter;
@@ -106,7 +551,9 @@ struct svdb_db_s {
     std::string isolation_level  = "READ COMMITTED";
     int64_t     busy_timeout_ms  = 0;
     std::string compression      = "NONE";
+    std::string storage_mode     = "ROW";   /* layout of new tables: ROW or COLUMNAR */
     bool        foreign_keys_enabled = false;
+    bool        defer_foreign_keys   = false; /* defer every FK check to COMMIT */
     int64_t     max_rows         = 0;       /* 0 = unlimited */
     int64_t     cache_memory     = 2097152; /* 2 MB default */
     std::string synchronous      = "FULL";  /* default=2 (FULL) */
@@ -117,6 +564,7 @@ struct svdb_db_s {
     int64_t     auto_vacuum_val  = 0;       /* auto_vacuum */
     int64_t     temp_store_val   = 0;       /* temp_store */
     bool        query_only_val   = false;   /* query_only */
+    bool        read_only        = false;   /* opened with SVDB_OPEN_READONLY */
     std::string locking_mode_val = "normal";/* locking_mode */
     bool        read_uncommitted_val = false; /* read_uncommitted */
     int64_t     cache_spill_val  = 1;       /* cache_spill, default=1 */
@@ -129,14 +577,27 @@ struct svdb_db_s {
     /* Transaction state */
     bool         in_transaction = false;
     svdb_tx_t   *sql_tx         = nullptr;  /* active SQL-level transaction */
+    ConstraintMode constraint_mode = CONSTRAINTS_DEFAULT;
+    /* Child tables with FK violations whose check was deferred to COMMIT */
+    std::set<std::string> fk_pending;
 
     /* Thread safety */
     std::mutex mu;
 };
 
+/* Where a result column comes from. Empty strings mean unknown, as for an
+ * expression; nullable is 1, 0 for NOT NULL, or -1 when unknown. */
+struct SvdbColumnMeta {
+    std::string decl_type;
+    std::string table;
+    std::string origin;
+    int nullable = -1;
+};
+
 /* Result set */
 struct svdb_rows_s {
     std::vector<std::string>  col_names;
+    std::vector<SvdbColumnMeta> col_meta; /* parallel to col_names, or empty */
     std::vector<std::vector<SvdbVal>> rows;
     int cursor = -1;   /* points at current row; -1 = before first */
 
@@ -4,6 +4,11 @@
 #include <cctype>
 #include <algorithm>
 #include <unordered_map>
+#include <vector>
+#include <cstdio>
+#include <cstdlib>
+#include <cerrno>
+#include "svdb_types.h"
 
 /* Constant for the internal rowid column name */
 #define SVDB_ROWID_COLUMN "_rowid_"
@@ -66,3 +71,234 @@ static inline bool contains_table_case_insensitive(
     }
     return false;
 }
+
+/* ── Column type affinity (SQLite §3.1 rules) ──────────────────── */
+
+enum SvdbAffinity {
+    SVDB_AFF_BLOB,     /* no conversion ("NONE" in older SQLite docs) */
+    SVDB_AFF_TEXT,
+    SVDB_AFF_NUMERIC,
+    SVDB_AFF_INTEGER,
+    SVDB_AFF_REAL
+};
+
+/* Determine the affinity of a declared column type. The rules are applied in
+ * order, so "CHARINT" gets INTEGER affinity and "FLOATING POINT" gets REAL. */
+static inline SvdbAffinity svdb_affinity_of(const std::string &decl_type) {
+    std::string t = svdb_str_upper(decl_type);
+    if (t.find("INT") != std::string::npos) return SVDB_AFF_INTEGER;
+    if (t.find("CHAR") != std::string::npos || t.find("CLOB") != std::string::npos ||
+        t.find("TEXT") != std::string::npos) return SVDB_AFF_TEXT;
+    if (t.empty() || t.find("BLOB") != std::string::npos) return SVDB_AFF_BLOB;
+    if (t.find("REAL") != std::string::npos || t.find("FLOA") != std::string::npos ||
+        t.find("DOUB") != std::string::npos) return SVDB_AFF_REAL;
+    return SVDB_AFF_NUMERIC;
+}
+
+/* Storage class name as reported by typeof() and STRICT errors */
+static inline const char *svdb_type_name(svdb_type_t t) {
+    switch (t) {
+    case SVDB_TYPE_INT:  return "INTEGER";
+    case SVDB_TYPE_REAL: return "REAL";
+    case SVDB_TYPE_TEXT: return "TEXT";
+    case SVDB_TYPE_BLOB: return "BLOB";
+    default:             return "NULL";
+    }
+}
+
+/* Render a REAL the way SQLite does when converting it to TEXT: 15
+ * significant digits, and integral values keep a trailing ".0". */
+static inline std::string svdb_format_real(double d) {
+    char buf[64];
+    snprintf(buf, sizeof(buf), "%.15g", d);
+    std::string s(buf);
+    if (s.find_first_of(".eEn") == std::string::npos) s += ".0";
+    return s;
+}
+
+/* Parse TEXT that looks exactly like a number (surrounding whitespace is
+ * allowed, hex is not). Integers that overflow int64 come back as REAL. */
+static inline bool svdb_parse_numeric_text(const std::string &text, SvdbVal &out) {
+    std::string s = svdb_str_trim(text);
+    if (s.empty()) return false;
+    size_t i = 0;
+    if (s[i] == '+' || s[i] == '-') ++i;
+    size_t digits = 0;
+    bool is_real = false;
+    while (i < s.size() && isdigit((unsigned char)s[i])) { ++i; ++digits; }
+    if (i < s.size() && s[i] == '.') {
+        is_real = true; ++i;
+        while (i < s.size() && isdigit((unsigned char)s[i])) { ++i; ++digits; }
+    }
+    if (digits == 0) return false;
+    if (i < s.size() && (s[i] == 'e' || s[i] == 'E')) {
+        is_real = true; ++i;
+        if (i < s.size() && (s[i] == '+' || s[i] == '-')) ++i;
+        size_t exp_digits = 0;
+        while (i < s.size() && isdigit((unsigned char)s[i])) { ++i; ++exp_digits; }
+        if (exp_digits == 0) return false;
+    }
+    if (i != s.size()) return false;
+    if (!is_real) {
+        errno = 0;
+        long long v = strtoll(s.c_str(), nullptr, 10);
+        if (errno != ERANGE) {
+            out = SvdbVal{};
+            out.type = SVDB_TYPE_INT; out.ival = (int64_t)v;
+            return true;
+        }
+    }
+    out = SvdbVal{};
+    out.type = SVDB_TYPE_REAL; out.rval = strtod(s.c_str(), nullptr);
+    return true;
+}
+
+/* True when d can be stored as an int64 without losing information */
+static inline bool svdb_real_is_integral(double d) {
+    return d >= -9223372036854775808.0 && d < 9223372036854775808.0 &&
+           d == (double)(int64_t)d;
+}
+
+/* Apply a column affinity to a value in place. NULL and BLOB values are never
+ * converted; TEXT is converted only when it is a well-formed number. */
+static inline void svdb_apply_affinity(SvdbVal &v, SvdbAffinity aff) {
+    if (v.type == SVDB_TYPE_NULL || v.type == SVDB_TYPE_BLOB) return;
+    switch (aff) {
+    case SVDB_AFF_BLOB:
+        return;
+    case SVDB_AFF_TEXT:
+        if (v.type == SVDB_TYPE_INT) {
+            v.sval = std::to_string(v.ival); v.type = SVDB_TYPE_TEXT;
+        } else if (v.type == SVDB_TYPE_REAL) {
+            v.sval = svdb_format_real(v.rval); v.type = SVDB_TYPE_TEXT;
+        }
+        return;
+    case SVDB_AFF_REAL:
+        if (v.type == SVDB_TYPE_TEXT) {
+            SvdbVal n;
+            if (!svdb_parse_numeric_text(v.sval, n)) return;
+            v = n;
+        }
+        if (v.type == SVDB_TYPE_INT) { v.rval = (double)v.ival; v.type = SVDB_TYPE_REAL; }
+        return;
+    case SVDB_AFF_NUMERIC:
+    case SVDB_AFF_INTEGER:
+        if (v.type == SVDB_TYPE_TEXT) {
+            SvdbVal n;
+            if (!svdb_parse_numeric_text(v.sval, n)) return;
+            v = n;
+        }
+        if (v.type == SVDB_TYPE_REAL && svdb_real_is_integral(v.rval)) {
+            v.ival = (int64_t)v.rval; v.type = SVDB_TYPE_INT;
+        }
+        return;
+    }
+}
+
+/* Column types accepted in a STRICT table */
+static inline bool svdb_strict_type_ok(const std::string &decl_type) {
+    std::string t = svdb_str_upper(decl_type);
+    return t == "INT" || t == "INTEGER" || t == "REAL" || t == "TEXT" ||
+           t == "BLOB" || t == "ANY";
+}
+
+/* Coerce a value for a STRICT column. Returns false when the value cannot be
+ * stored losslessly in the declared type; v is left unchanged in that case. */
+static inline bool svdb_strict_coerce(SvdbVal &v, const std::string &decl_type) {
+    if (v.type == SVDB_TYPE_NULL) return true;
+    std::string t = svdb_str_upper(decl_type);
+    if (t == "ANY") return true;
+    SvdbVal c = v;
+    if (t == "INT" || t == "INTEGER") {
+        svdb_apply_affinity(c, SVDB_AFF_INTEGER);
+        if (c.type != SVDB_TYPE_INT) return false;
+    } else if (t == "REAL") {
+        svdb_apply_affinity(c, SVDB_AFF_REAL);
+        if (c.type != SVDB_TYPE_REAL) return false;
+    } else if (t == "TEXT") {
+        svdb_apply_affinity(c, SVDB_AFF_TEXT);
+        if (c.type != SVDB_TYPE_TEXT) return false;
+    } else if (t == "BLOB") {
+        if (c.type != SVDB_TYPE_BLOB) return false;
+    }
+    v = c;
+    return true;
+}
+
+/* ── Value ordering ─────────────────────────────────────────────── */
+
+/* Compare two values in SQLite sort order: NULL < INTEGER/REAL < TEXT < BLOB.
+ * Numbers compare numerically, TEXT and BLOB bytewise. Returns <0, 0, >0. */
+static inline int svdb_val_order(const SvdbVal &a, const SvdbVal &b) {
+    auto rank = [](svdb_type_t t) {
+        switch (t) {
+        case SVDB_TYPE_NULL: return 0;
+        case SVDB_TYPE_INT:
+        case SVDB_TYPE_REAL: return 1;
+        case SVDB_TYPE_TEXT: return 2;
+        default:             return 3;
+        }
+    };
+    int ra = rank(a.type), rb = rank(b.type);
+    if (ra != rb) return ra < rb ? -1 : 1;
+    if (ra == 0) return 0;
+    if (ra == 1) {
+        if (a.type == SVDB_TYPE_INT && b.type == SVDB_TYPE_INT)
+            return a.ival < b.ival ? -1 : (a.ival > b.ival ? 1 : 0);
+        double da = a.type == SVDB_TYPE_INT ? (double)a.ival : a.rval;
+        double db = b.type == SVDB_TYPE_INT ? (double)b.ival : b.rval;
+        return da < db ? -1 : (da > db ? 1 : 0);
+    }
+    int c = a.sval.compare(b.sval);
+    return c < 0 ? -1 : (c > 0 ? 1 : 0);
+}
+
+/* Compare two rows on the given key columns, left to right. A column missing
+ * from a row compares as NULL. */
+static inline int svdb_row_key_cmp(const Row &a, const Row &b,
+                                   const std::vector<std::string> &cols) {
+    static const SvdbVal null_val;
+    for (const auto &c : cols) {
+        auto ia = a.find(c), ib = b.find(c);
+        int r = svdb_val_order(ia == a.end() ? null_val : ia->second,
+                               ib == b.end() ? null_val : ib->second);
+        if (r != 0) return r;
+    }
+    return 0;
+}
+
+/* ── Comparison affinity ────────────────────────────────────────── */
+
+/* The tables a statement reads, as (qualifier, table) pairs: each table under
+ * its own name and under its alias. A WHERE comparison resolves a column
+ * operand against them to apply the column's declared affinity (query.cpp). */
+struct SvdbWhereTables {
+    std::vector<std::pair<std::string, std::string>> tables;
+    /* Operand text -> its affinity, or -1 for none; filled as resolved */
+    mutable std::unordered_map<std::string, int> affinity;
+};
+
+/* Installs the tables of the current statement; returns the previous ones */
+const SvdbWhereTables *svdb_set_where_tables(const SvdbWhereTables *tables);
+
+/* Installs tables for the lifetime of the scope */
+struct SvdbWhereTablesScope {
+    const SvdbWhereTables *prev;
+    explicit SvdbWhereTablesScope(const SvdbWhereTables *t) : prev(svdb_set_where_tables(t)) {}
+    ~SvdbWhereTablesScope() { svdb_set_where_tables(prev); }
+    SvdbWhereTablesScope(const SvdbWhereTablesScope &) = delete;
+    SvdbWhereTablesScope &operator=(const SvdbWhereTablesScope &) = delete;
+};
+
+/* ── Statement scope ────────────────────────────────────────────── */
+
+/* Held while a statement executes. Rows may change under any query run in
+ * the meantime, so the planner must not use cached index entries then.
+ * Whatever changes rows moves db->data_gen as it does so. */
+struct SvdbExecScope {
+    svdb_db_t *db;
+    explicit SvdbExecScope(svdb_db_t *d) : db(d) { ++db->exec_depth; }
+    ~SvdbExecScope() { --db->exec_depth; }
+    SvdbExecScope(const SvdbExecScope &) = delete;
+    SvdbExecScope &operator=(const SvdbExecScope &) = delete;
+};
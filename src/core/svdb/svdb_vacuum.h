@@ -0,0 +1,20 @@
+/* svdb_vacuum.h — VACUUM, VACUUM INTO and the freelist */
+#pragma once
+#include <string>
+#include "svdb_types.h"
+
+/* auto_vacuum modes, as PRAGMA auto_vacuum reports them */
+enum AutoVacuum {
+    AUTO_VACUUM_NONE        = 0,
+    AUTO_VACUUM_FULL        = 1,
+    AUTO_VACUUM_INCREMENTAL = 2,
+};
+
+/* Run VACUUM [schema] [INTO 'path']. Fails inside a transaction and, for
+ * INTO, if path already holds data; reason in db->last_error. */
+svdb_code_t vacuum_exec(svdb_db_t *db, const std::string &sql);
+
+/* Give back up to pages free pages of the database file, all of them if
+ * pages <= 0, and rewrite it (at commit inside a transaction). Returns the
+ * pages given back, -1 on an I/O error with the reason in err. */
+int64_t vacuum_incremental(svdb_db_t *db, int64_t pages, std::string &err);
@@ -0,0 +1,210 @@
+/*
+ * tablefn.cpp — table-valued functions registered by the application
+ *
+ *   SELECT ... FROM name(arg, ...) [[AS] alias] ...
+ *
+ * A function registered with svdb_register_table_function() may stand for a
+ * table wherever a FROM clause names one: after FROM, JOIN or a comma. Its
+ * arguments are evaluated as constants and it is called once, before the
+ * statement runs; the columns and rows it produces go into a temporary table
+ * the statement is rewritten to read, as JSON_TABLE's do (sqljson.cpp).
+ */
+#include "svdb.h"
+#include "svdb_tablefn.h"
+#include "svdb_util.h"
+#include <cstring>
+#include <mutex>
+
+/* Implemented in query.cpp */
+SvdbVal svdb_eval_expr_in_row(const std::string &expr, const Row &row,
+                              const std::vector<std::string> &col_order);
+
+struct svdb_table_s {
+    std::vector<std::string> names;
+    std::vector<std::string> types;
+    Row proto;              /* a row of NULLs with every column, in order */
+    std::vector<Row> rows;
+    std::string error;
+};
+
+extern "C" void svdb_table_add_column(svdb_table_t *out, const char *name, const char *decl_type) {
+    if (!out || !out->error.empty()) return;
+    if (!name || !*name) {
+        out->error = "column name is empty";
+    } else if (!out->rows.empty()) {
+        out->error = "columns must be added before rows";
+    } else if (out->proto.count(name)) {
+        out->error = std::string("duplicate column name ") + name;
+    } else {
+        out->names.push_back(name);
+        out->types.push_back(decl_type ? decl_type : "");
+        out->proto[name] = SvdbVal{};
+    }
+}
+
+extern "C" void svdb_table_add_row(svdb_table_t *out, const svdb_val_t *vals) {
+    if (!out || !out->error.empty()) return;
+    Row r = out->proto;
+    for (size_t c = 0; c < out->names.size(); ++c) {
+        const svdb_val_t &v = vals[c];
+        SvdbVal &d = r.at_pos(c);
+        d.type = v.type;
+        if (v.type == SVDB_TYPE_INT) d.ival = v.ival;
+        else if (v.type == SVDB_TYPE_REAL) d.rval = v.rval;
+        else if (v.type == SVDB_TYPE_TEXT || v.type == SVDB_TYPE_BLOB) d.sval.assign(v.sval ? v.sval : "", v.slen);
+        else d.type = SVDB_TYPE_NULL;
+    }
+    out->rows.push_back(std::move(r));
+}
+
+extern "C" void svdb_table_error(svdb_table_t *out, const char *msg) {
+    if (out && out->error.empty()) out->error = msg && *msg ? msg : "table function failed";
+}
+
+extern "C" svdb_code_t svdb_register_table_function(svdb_db_t *db, const char *name,
+                                                    svdb_table_fn fn, uintptr_t ctx) {
+    if (!db || !name || !fn) return SVDB_ERR;
+    std::lock_guard<std::mutex> lk(db->mu);
+    std::string key = svdb_str_upper(name);
+    if (key.empty()) {
+        db->last_error = "table function name is empty";
+        return SVDB_ERR;
+    }
+    db->table_functions[key] = SvdbTableFn{fn, ctx};
+    return SVDB_OK;
+}
+
+/* ── Rewriting FROM clauses ─────────────────────────────────────── */
+
+static bool tf_word_char(char c) { return isalnum((unsigned char)c) || c == '_'; }
+
+/* Position of the parenthesis closing the one at open, or npos */
+static size_t tf_close_paren(const std::string &s, size_t open) {
+    int depth = 0;
+    char quote = 0;
+    for (size_t i = open; i < s.size(); ++i) {
+        char c = s[i];
+        if (quote) { if (c == quote) quote = 0; continue; }
+        if (c == '\'' || c == '"' || c == '`') quote = c;
+        else if (c == '(') ++depth;
+        else if (c == ')' && --depth == 0) return i;
+    }
+    return std::string::npos;
+}
+
+/* The arguments of a call, split at top-level commas */
+static std::vector<std::string> tf_split_args(const std::string &s) {
+    std::vector<std::string> out;
+    if (svdb_str_trim(s).empty()) return out;
+    int depth = 0;
+    char quote = 0;
+    size_t start = 0;
+    for (size_t i = 0; i <= s.size(); ++i) {
+        char c = i < s.size() ? s[i] : ',';
+        if (quote) { if (c == quote) quote = 0; continue; }
+        if (c == '\'' || c == '"' || c == '`') quote = c;
+        else if (c == '(') ++depth;
+        else if (c == ')') --depth;
+        else if (c == ',' && depth == 0) {
+            out.push_back(svdb_str_trim(s.substr(start, i - start)));
+            start = i + 1;
+        }
+    }
+    return out;
+}
+
+/* Call fn with the arguments args and put its table in a new temporary
+ * table, named in tmp */
+static bool tf_materialize(svdb_db_t *db, const std::string &name, const SvdbTableFn &fn,
+                           const std::string &args, std::string &tmp, std::string &err) {
+    std::vector<SvdbVal> vals;
+    for (const auto &a : tf_split_args(args)) {
+        if (a.empty()) { err = name + ": missing argument"; return false; }
+        vals.push_back(svdb_eval_expr_in_row(a, Row{}, {}));
+    }
+    std::vector<svdb_val_t> cargs(vals.size());
+    for (size_t i = 0; i < vals.size(); ++i) {
+        cargs[i].type = vals[i].type;
+        cargs[i].ival = vals[i].ival;
+        cargs[i].rval = vals[i].rval;
+        cargs[i].sval = vals[i].sval.c_str();
+        cargs[i].slen = vals[i].sval.size();
+    }
+    svdb_table_t out;
+    svdb_code_t rc = fn.fn(fn.ctx, cargs.data(), (int)cargs.size(), &out);
+    if (rc != SVDB_OK || !out.error.empty()) {
+        err = name + ": " + (out.error.empty() ? "table function failed" : out.error);
+        return false;
+    }
+    if (out.names.empty()) {
+        err = name + ": table function returned no columns";
+        return false;
+    }
+    static int seq = 0;
+    tmp = "__table_fn_" + std::to_string(++seq);
+    auto &def = db->schema[tmp];
+    for (size_t c = 0; c < out.names.size(); ++c)
+        def[out.names[c]] = ColDef{out.types[c], "", false, false};
+    db->col_order[tmp] = out.names;
+    db->data[tmp] = std::move(out.rows);
+    return true;
+}
+
+bool tablefn_rewrite(svdb_db_t *db, std::string &sql, std::vector<std::string> &tmps, std::string &err) {
+    if (db->table_functions.empty()) return true;
+    static const char *clauses[] = {"SELECT", "FROM", "WHERE", "GROUP", "HAVING", "ORDER", "LIMIT",
+                                    "WINDOW", "ON", "USING", "SET", "VALUES", nullptr};
+    std::vector<std::string> clause{""};  /* last clause keyword at each nesting depth */
+    std::string prev;                     /* the token before, upper case */
+    char quote = 0;
+    for (size_t i = 0; i < sql.size(); ++i) {
+        char c = sql[i];
+        if (quote) {
+            if (c == quote) quote = 0;
+            continue;
+        }
+        if (c == '\'' || c == '"' || c == '`') { quote = c; prev = c; continue; }
+        if (isspace((unsigned char)c)) continue;
+        if (c == '(') { clause.push_back(""); prev = "("; continue; }
+        if (c == ')') { if (clause.size() > 1) clause.pop_back(); prev = ")"; continue; }
+        if (!tf_word_char(c)) { prev = c; continue; }
+
+        size_t end = i;
+        while (end < sql.size() && tf_word_char(sql[end])) ++end;
+        std::string word = svdb_str_upper(sql.substr(i, end - i));
+        size_t open = end;
+        while (open < sql.size() && isspace((unsigned char)sql[open])) ++open;
+        auto fn = db->table_functions.find(word);
+        bool in_from = prev == "FROM" || prev == "JOIN" || (prev == "," && clause.back() == "FROM");
+        if (fn != db->table_functions.end() && in_from && open < sql.size() && sql[open] == '(') {
+            std::string name = sql.substr(i, end - i);
+            size_t close = tf_close_paren(sql, open);
+            if (close == std::string::npos) { err = "unterminated " + name + "(...)"; return false; }
+            std::string tmp;
+            if (!tf_materialize(db, name, fn->second, sql.substr(open + 1, close - open - 1), tmp, err))
+                return false;
+            tmps.push_back(tmp);
+            sql.replace(i, close + 1 - i, tmp);
+            i += tmp.size() - 1;
+            prev = svdb_str_upper(tmp);
+            continue;
+        }
+        if (word == "JOIN") {
+            clause.back() = "FROM";
+        } else {
+            for (int k = 0; clauses[k]; ++k)
+                if (word == clauses[k]) clause.back() = word;
+        }
+        prev = word;
+        i = end - 1;
+    }
+    return true;
+}
+
+void tablefn_drop(svdb_db_t *db, const std::vector<std::string> &tmps) {
+    for (const auto &t : tmps) {
+        db->schema.erase(t);
+        db->col_order.erase(t);
+        db->data.erase(t);
+    }
+}
@@ -1,8 +1,153 @@
 /*
- * vacuum.cpp — VACUUM stub (Phase 7)
+ * vacuum.cpp — VACUUM, VACUUM INTO and the freelist
+ *
+ * Deleting rows leaves the database file its size: the data pages the rows
+ * no longer fill stay in it as free pages, counted in the file header, and
+ * later rows take them first (storage.cpp). VACUUM rebuilds every table at
+ * its live size, with rows laid out in column order, and rewrites the file
+ * without free pages; auto_vacuum=FULL drops them whenever the file is
+ * written and auto_vacuum=INCREMENTAL leaves them to PRAGMA
+ * incremental_vacuum(N).
  */
-#include "svdb.h"
-#include "svdb_types.h"
+#include "svdb_vacuum.h"
+#include "svdb_storage.h"
+#include "svdb_columnar.h"
+#include "svdb_util.h"
+#include <cctype>
+#include <sys/stat.h>
+#if defined(__GLIBC__)
+#include <malloc.h>
+#endif
 
-extern "C" {
-} /* extern "C" */
+namespace {
+
+/* Copy row with its values in column order and no spare capacity */
+Row compact_row(const Row &row, const std::vector<std::string> &cols) {
+    Row out;
+    out.reserve(row.size());
+    for (auto &c : cols) {
+        auto it = row.find(c);
+        if (it != row.end()) out.emplace(c, it->second);
+    }
+    for (auto kv : row)
+        if (!out.count(kv.first)) out.emplace(kv.first, kv.second);
+    return out;
+}
+
+/* Hand memory freed by a rebuild back to the system */
+void release_memory() {
+#if defined(__GLIBC__)
+    malloc_trim(0);
+#endif
+}
+
+/* Rebuild every table at its live size and rewrite the database file */
+bool vacuum_main(svdb_db_t *db, std::string &err) {
+    static const std::vector<std::string> no_cols;
+    for (auto &kv : db->data) {
+        auto co = db->col_order.find(kv.first);
+        const std::vector<std::string> &cols = co == db->col_order.end() ? no_cols : co->second;
+        std::vector<Row> rows;
+        rows.reserve(kv.second.size());
+        for (auto &row : kv.second) rows.push_back(compact_row(row, cols));
+        kv.second.swap(rows);
+        columnar_forget(db, kv.first);
+    }
+    db->index_cache.clear();
+    release_memory();
+    db->file_pages = 0;
+    db->file_bytes = 0;
+    if (db->wal_mode == "WAL") {
+        StorageCheckpoint ck;
+        return storage_checkpoint(db, "TRUNCATE", ck, err);
+    }
+    return storage_save(db, err);
+}
+
+bool vacuum_into(svdb_db_t *db, const std::string &path, std::string &err) {
+    struct stat st;
+    if (stat(path.c_str(), &st) == 0 && st.st_size > 0) {
+        err = "output file already exists";
+        return false;
+    }
+    return storage_save_copy(db, path, err);
+}
+
+/* The next word of sql from pos, upper-cased, or a quoted string's text */
+std::string next_token(const std::string &sql, size_t &pos, bool &quoted) {
+    quoted = false;
+    while (pos < sql.size() && isspace((unsigned char)sql[pos])) ++pos;
+    if (pos >= sql.size()) return "";
+    char q = sql[pos];
+    if (q == '\'' || q == '"') {
+        quoted = true;
+        std::string out;
+        for (++pos; pos < sql.size(); ++pos) {
+            if (sql[pos] != q) { out += sql[pos]; continue; }
+            if (pos + 1 < sql.size() && sql[pos + 1] == q) { out += q; ++pos; continue; }
+            ++pos;
+            return out;
+        }
+        quoted = false; /* unterminated */
+        return "";
+    }
+    size_t start = pos;
+    while (pos < sql.size() && !isspace((unsigned char)sql[pos]) && sql[pos] != ';') ++pos;
+    if (pos == start) ++pos;
+    return svdb_str_upper(sql.substr(start, pos - start));
+}
+
+} // namespace
+
+svdb_code_t vacuum_exec(svdb_db_t *db, const std::string &sql) {
+    size_t pos = 0;
+    bool quoted = false;
+    next_token(sql, pos, quoted); /* VACUUM */
+    std::string tok = next_token(sql, pos, quoted);
+    if (quoted || (!tok.empty() && tok != "INTO" && tok != ";")) {
+        std::string schema = svdb_str_upper(tok);
+        if (schema != "MAIN" && schema != "TEMP") {
+            db->last_error = "unknown database " + tok;
+            return SVDB_ERR;
+        }
+        tok = next_token(sql, pos, quoted);
+    }
+    std::string into;
+    bool has_into = !quoted && tok == "INTO";
+    if (has_into) {
+        into = next_token(sql, pos, quoted);
+        if (!quoted || into.empty()) {
+            db->last_error = "VACUUM INTO requires a file name";
+            return SVDB_ERR;
+        }
+        tok = next_token(sql, pos, quoted);
+    }
+    if (!tok.empty() && tok != ";") {
+        db->last_error = "near \"" + tok + "\": syntax error";
+        return SVDB_ERR;
+    }
+    if (db->in_transaction) {
+        db->last_error = "cannot VACUUM from within a transaction";
+        return SVDB_ERR;
+    }
+    std::string err;
+    if (has_into ? !vacuum_into(db, into, err) : !vacuum_main(db, err)) {
+        db->last_error = err;
+        return SVDB_ERR;
+    }
+    return SVDB_OK;
+}
+
+int64_t vacuum_incremental(svdb_db_t *db, int64_t pages, std::string &err) {
+    int64_t released = storage_release_pages(db, pages);
+    /* Inside a transaction the file shrinks when it commits */
+    if (released == 0 || db->in_transaction) return released;
+    bool ok;
+    if (db->wal_mode == "WAL") {
+        StorageCheckpoint ck;
+        ok = storage_checkpoint(db, "PASSIVE", ck, err);
+    } else {
+        ok = storage_save(db, err);
+    }
+    return ok ? released : -1;
+}
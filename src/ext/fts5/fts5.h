@@ -12,7 +12,9 @@ extern "C" {
 typedef enum {
     SVDB_FTS5_TOKEN_ASCII,
     SVDB_FTS5_TOKEN_PORTER,
-    SVDB_FTS5_TOKEN_UNICODE61
+    SVDB_FTS5_TOKEN_UNICODE61,
+    SVDB_FTS5_TOKEN_TRIGRAM,    // every 3-character window, for substring search
+    SVDB_FTS5_TOKEN_NGRAM       // n-character windows of each word (default n = 2)
 } svdb_fts5_tokenizer_type_t;
 
 // Token structure
@@ -34,6 +36,9 @@ typedef struct svdb_fts5_ranker svdb_fts5_ranker_t;
 
 // Tokenizer functions
 svdb_fts5_tokenizer_t* svdb_fts5_tokenizer_create(svdb_fts5_tokenizer_type_t type);
+// Trigram or n-gram tokenizer with window size n (ignored for trigram);
+// case_sensitive keeps ASCII letters as written
+svdb_fts5_tokenizer_t* svdb_fts5_tokenizer_create_ngram(svdb_fts5_tokenizer_type_t type, int n, int case_sensitive);
 void svdb_fts5_tokenizer_destroy(svdb_fts5_tokenizer_t* tokenizer);
 
 svdb_fts5_token_t* svdb_fts5_tokenize(svdb_fts5_tokenizer_t* tokenizer, const char* text, int* token_count);
@@ -43,7 +48,11 @@ void svdb_fts5_token_free(svdb_fts5_token_t* token);
 svdb_fts5_index_t* svdb_fts5_index_create(int column_count);
 void svdb_fts5_index_destroy(svdb_fts5_index_t* index);
 
+// Adding a doc_id that is already indexed replaces that document
 int svdb_fts5_index_add_document(svdb_fts5_index_t* index, int64_t doc_id, const char* const* column_values, int column_count);
+// Remove and update return -1 if doc_id is not indexed
+int svdb_fts5_index_remove_document(svdb_fts5_index_t* index, int64_t doc_id);
+int svdb_fts5_index_update_document(svdb_fts5_index_t* index, int64_t doc_id, const char* const* column_values, int column_count);
 int64_t* svdb_fts5_index_query(svdb_fts5_index_t* index, const char* query, int* doc_count);
 
 // Index metadata
@@ -30,6 +30,27 @@ struct svdb_fts5_ranker {
         : index(idx), k1(k), b(b_val) {}
 };
 
+// Lowercased ASCII alphanumeric runs of text, the terms the index stores
+static std::vector<std::string> index_terms(const std::string& text) {
+    std::vector<std::string> terms;
+    std::string current;
+    for (char c : text) {
+        if ((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+            if (c >= 'A' && c <= 'Z') {
+                c = c + 'a' - 'A';
+            }
+            current += c;
+        } else if (!current.empty()) {
+            terms.push_back(current);
+            current.clear();
+        }
+    }
+    if (!current.empty()) {
+        terms.push_back(current);
+    }
+    return terms;
+}
+
 // C API implementation
 extern "C" {
 
@@ -55,37 +76,20 @@ int svdb_fts5_index_add_document(svdb_fts5_index_t* index, int64_t doc_id,
     }
     
     try {
+        // Adding an existing document replaces it
+        if (index->doc_columns.count(doc_id)) {
+            svdb_fts5_index_remove_document(index, doc_id);
+        }
+        
         int total_tokens = 0;
         std::vector<std::string> columns;
         
         for (int col = 0; col < column_count && col < index->column_count; col++) {
             if (column_values[col]) {
                 columns.push_back(column_values[col]);
-                
-                // Tokenize and add to index
-                std::string text = column_values[col];
-                std::string current;
-                
-                for (size_t i = 0; i < text.size(); i++) {
-                    char c = text[i];
-                    if ((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
-                        if (c >= 'A' && c <= 'Z') {
-                            c = c + 'a' - 'A';
-                        }
-                        current += c;
-                    } else {
-                        if (!current.empty()) {
-                            index->posting_list[current].insert(doc_id);
-                            index->term_freq[current][doc_id]++;
-                            total_tokens++;
-                            current.clear();
-                        }
-                    }
-                }
-                
-                if (!current.empty()) {
-                    index->posting_list[current].insert(doc_id);
-                    index->term_freq[current][doc_id]++;
+                for (const auto& term : index_terms(column_values[col])) {
+                    index->posting_list[term].insert(doc_id);
+                    index->term_freq[term][doc_id]++;
                     total_tokens++;
                 }
             } else {
@@ -103,6 +107,45 @@ int svdb_fts5_index_add_document(svdb_fts5_index_t* index, int64_t doc_id,
     }
 }
 
+int svdb_fts5_index_remove_document(svdb_fts5_index_t* index, int64_t doc_id) {
+    if (!index) return -1;
+    
+    auto doc_it = index->doc_columns.find(doc_id);
+    if (doc_it == index->doc_columns.end()) {
+        return -1;
+    }
+    
+    // Drop the document's postings; terms no other document uses go away
+    for (const auto& text : doc_it->second) {
+        for (const auto& term : index_terms(text)) {
+            auto pl_it = index->posting_list.find(term);
+            if (pl_it != index->posting_list.end()) {
+                pl_it->second.erase(doc_id);
+                if (pl_it->second.empty()) index->posting_list.erase(pl_it);
+            }
+            auto tf_it = index->term_freq.find(term);
+            if (tf_it != index->term_freq.end()) {
+                tf_it->second.erase(doc_id);
+                if (tf_it->second.empty()) index->term_freq.erase(tf_it);
+            }
+        }
+    }
+    
+    index->doc_lengths.erase(doc_id);
+    index->doc_columns.erase(doc_it);
+    index->doc_count--;
+    
+    return 0;
+}
+
+int svdb_fts5_index_update_document(svdb_fts5_index_t* index, int64_t doc_id,
+                                     const char* const* column_values, int column_count) {
+    if (!index || !index->doc_columns.count(doc_id)) {
+        return -1;
+    }
+    return svdb_fts5_index_add_document(index, doc_id, column_values, column_count);
+}
+
 int64_t* svdb_fts5_index_query(svdb_fts5_index_t* index, const char* query, int* doc_count) {
     if (!index || !query || !doc_count) {
         if (doc_count) *doc_count = 0;
@@ -16,6 +16,8 @@ struct Token {
 // Tokenizer implementation
 struct svdb_fts5_tokenizer {
     svdb_fts5_tokenizer_type_t type;
+    int ngram = 2;                // window size of SVDB_FTS5_TOKEN_NGRAM
+    bool case_sensitive = false;  // trigram/ngram: keep ASCII case
     
     std::vector<Token> tokenize(const std::string& text) {
         std::vector<Token> tokens;
@@ -27,6 +29,10 @@ struct svdb_fts5_tokenizer {
                 return tokenizePorter(text);
             case SVDB_FTS5_TOKEN_UNICODE61:
                 return tokenizeUnicode61(text);
+            case SVDB_FTS5_TOKEN_TRIGRAM:
+                return tokenizeTrigram(text);
+            case SVDB_FTS5_TOKEN_NGRAM:
+                return tokenizeNgram(text);
             default:
                 return tokenizeASCII(text);
         }
@@ -84,9 +90,10 @@ private:
         
         for (size_t i = 0; i < text.size(); i++) {
             unsigned char c = static_cast<unsigned char>(text[i]);
-            // Simple heuristic: ASCII letters and numbers
+            // ASCII letters and numbers, plus every byte of a multi-byte
+            // UTF-8 sequence (lead and continuation bytes alike)
             if ((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || 
-                (c >= '0' && c <= '9') || c >= 0xC0) {
+                (c >= '0' && c <= '9') || c >= 0x80) {
                 if (start == -1) {
                     start = static_cast<int>(i);
                 }
@@ -112,6 +119,96 @@ private:
         return tokens;
     }
     
+    // A character of text: its byte offset and length
+    struct Char {
+        int start;
+        int len;
+    };
+
+    static std::vector<Char> splitUTF8(const std::string& text) {
+        std::vector<Char> chars;
+        size_t i = 0;
+        while (i < text.size()) {
+            unsigned char c = static_cast<unsigned char>(text[i]);
+            int len = c < 0x80 ? 1 : c < 0xE0 ? 2 : c < 0xF0 ? 3 : 4;
+            if (i + len > text.size()) len = static_cast<int>(text.size() - i);
+            chars.push_back(Char{static_cast<int>(i), len});
+            i += len;
+        }
+        return chars;
+    }
+
+    static uint32_t codepoint(const std::string& text, const Char& ch) {
+        unsigned char c = static_cast<unsigned char>(text[ch.start]);
+        if (ch.len == 1) return c;
+        uint32_t cp = c & (0xFF >> (ch.len + 1));
+        for (int k = 1; k < ch.len; k++) {
+            cp = (cp << 6) | (static_cast<unsigned char>(text[ch.start + k]) & 0x3F);
+        }
+        return cp;
+    }
+
+    // Letters, digits and non-ASCII characters other than common punctuation
+    static bool isWordChar(uint32_t cp) {
+        if (cp < 0x80) {
+            return (cp >= 'a' && cp <= 'z') || (cp >= 'A' && cp <= 'Z') || (cp >= '0' && cp <= '9');
+        }
+        if (cp >= 0x2000 && cp <= 0x206F) return false;  // General Punctuation
+        if (cp >= 0x3000 && cp <= 0x303F) return false;  // CJK Symbols and Punctuation
+        if (cp >= 0xFF00 && cp <= 0xFF0F) return false;  // Fullwidth punctuation
+        if (cp >= 0xFF1A && cp <= 0xFF20) return false;
+        return true;
+    }
+
+    std::string window(const std::string& text, const std::vector<Char>& chars, size_t from, size_t n) {
+        const Char& last = chars[from + n - 1];
+        std::string term = text.substr(chars[from].start, last.start + last.len - chars[from].start);
+        if (!case_sensitive) {
+            for (char& c : term) {
+                if (c >= 'A' && c <= 'Z') c = c + 'a' - 'A';
+            }
+        }
+        return term;
+    }
+
+    // Every run of three characters, spaces and punctuation included, so that
+    // a phrase of a query's trigrams matches any substring of the text
+    std::vector<Token> tokenizeTrigram(const std::string& text) {
+        std::vector<Token> tokens;
+        std::vector<Char> chars = splitUTF8(text);
+        for (size_t i = 0; i + 3 <= chars.size(); i++) {
+            const Char& last = chars[i + 2];
+            tokens.push_back(Token{window(text, chars, i, 3), chars[i].start,
+                                   last.start + last.len, static_cast<int>(i)});
+        }
+        return tokens;
+    }
+
+    // The n-character windows of each word; words shorter than n are one
+    // token. Suits scripts without spaces between words, such as CJK.
+    std::vector<Token> tokenizeNgram(const std::string& text) {
+        std::vector<Token> tokens;
+        std::vector<Char> chars = splitUTF8(text);
+        size_t n = ngram > 0 ? static_cast<size_t>(ngram) : 2;
+        size_t i = 0;
+        while (i < chars.size()) {
+            if (!isWordChar(codepoint(text, chars[i]))) {
+                i++;
+                continue;
+            }
+            size_t j = i;
+            while (j < chars.size() && isWordChar(codepoint(text, chars[j]))) j++;
+            size_t len = std::min(n, j - i);
+            for (size_t k = i; k + len <= j; k++) {
+                const Char& last = chars[k + len - 1];
+                tokens.push_back(Token{window(text, chars, k, len), chars[k].start,
+                                       last.start + last.len, static_cast<int>(tokens.size())});
+            }
+            i = j;
+        }
+        return tokens;
+    }
+
     // Porter stemmer implementation
     std::string stem(const std::string& word) {
         if (word.length() <= 2) {
@@ -341,6 +438,17 @@ svdb_fts5_tokenizer_t* svdb_fts5_tokenizer_create(svdb_fts5_tokenizer_type_t typ
     }
 }
 
+svdb_fts5_tokenizer_t* svdb_fts5_tokenizer_create_ngram(svdb_fts5_tokenizer_type_t type, int n, int case_sensitive) {
+    try {
+        svdb_fts5_tokenizer_t* tokenizer = new svdb_fts5_tokenizer{type};
+        tokenizer->ngram = type == SVDB_FTS5_TOKEN_TRIGRAM ? 3 : n;
+        tokenizer->case_sensitive = case_sensitive != 0;
+        return tokenizer;
+    } catch (...) {
+        return nullptr;
+    }
+}
+
 void svdb_fts5_tokenizer_destroy(svdb_fts5_tokenizer_t* tokenizer) {
     if (tokenizer) {
         delete tokenizer;
@@ -60,6 +60,56 @@ svdb_json_tvf_rows_t* svdb_json_each(const char* json_str);
 svdb_json_tvf_rows_t* svdb_json_tree(const char* json_str);
 void svdb_json_tvf_rows_free(svdb_json_tvf_rows_t* rows);
 
+// JSONB - binary JSON (the SQLite JSONB format), stored as a BLOB. Values are
+// found by walking the binary form, without re-parsing the document text.
+
+// Convert JSON text to JSONB; sets *out_len. NULL if the text is not valid JSON.
+unsigned char* svdb_jsonb_from_text(const char* json_str, size_t* out_len);
+// Convert JSONB back to (minified) JSON text. NULL if blob is not well-formed JSONB.
+char* svdb_jsonb_to_text(const unsigned char* blob, size_t len);
+// 1 if blob is well-formed JSONB, else 0
+int svdb_jsonb_valid(const unsigned char* blob, size_t len);
+// Find the element at path: sets *off and *elem_len to its bytes within blob.
+// Returns 1 if found, 0 if the path does not exist, -1 on a malformed path or blob.
+int svdb_jsonb_locate(const unsigned char* blob, size_t len, const char* path,
+                      size_t* off, size_t* elem_len);
+// JSON type of the element ("null", "true", "false", "integer", "real",
+// "text", "array" or "object"); NULL if malformed. Not to be freed.
+const char* svdb_jsonb_type(const unsigned char* elem, size_t len);
+// SQL text of the element: strings unescaped, numbers as written, "true" or
+// "false", the JSON of an array or object; NULL for null or if malformed
+char* svdb_jsonb_atom(const unsigned char* elem, size_t len);
+// Number of elements of an array or object (1 for other values), -1 if malformed
+int64_t svdb_jsonb_length(const unsigned char* elem, size_t len);
+
+// Edit operations for svdb_jsonb_edit, as in json_set, json_replace,
+// json_insert and json_remove
+enum {
+    SVDB_JSONB_SET = 0,     // replace or create
+    SVDB_JSONB_REPLACE = 1, // only replace an existing value
+    SVDB_JSONB_INSERT = 2,  // only create a missing value
+    SVDB_JSONB_REMOVE = 3
+};
+// Apply op at path, with value (a JSONB element; unused by REMOVE). Returns the
+// new document and sets *out_len; a path that does not apply leaves the
+// document unchanged. NULL on a malformed path or blob. Free with svdb_json_free.
+unsigned char* svdb_jsonb_edit(const unsigned char* blob, size_t len, const char* path, int op,
+                               const unsigned char* value, size_t value_len, size_t* out_len);
+
+// SQL/JSON path ("[lax|strict] $.items[*] ? (@.price > 10).name"), the path
+// language of JSON_VALUE, JSON_QUERY, JSON_EXISTS and JSON_TABLE
+typedef struct {
+    unsigned char** items; /* JSONB elements - heap allocated */
+    size_t* lens;
+    int count;
+} svdb_jsonb_seq_t;
+
+// The items path selects from the JSONB document. NULL on a malformed path or
+// document, or an error in strict mode; *err (if err is not NULL) then gets a
+// message to free with svdb_json_free.
+svdb_jsonb_seq_t* svdb_jsonb_path_query(const unsigned char* blob, size_t len, const char* path, char** err);
+void svdb_jsonb_seq_free(svdb_jsonb_seq_t* seq);
+
 // Memory management - caller must free returned strings
 void svdb_json_free(char* ptr);
 
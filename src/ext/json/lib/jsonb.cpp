@@ -0,0 +1,632 @@
+// JSONB - binary JSON in the SQLite JSONB format
+//
+// Every element is a header followed by its payload. The low four bits of the
+// first header byte are the element type; the high four bits are the payload
+// size (0-11), or say that the size follows in the next 1, 2, 4 or 8 bytes
+// (12-15, big-endian). Numbers are kept as their JSON text, strings as their
+// text between the quotes, arrays as their elements in order and objects as
+// alternating label and value elements.
+//
+// Because containers record their size, a path is looked up by skipping over
+// siblings instead of parsing text, and text converts to JSONB and back
+// without changing key order or number spelling.
+#include "json.h"
+#include "jsonb_internal.h"
+#include <cctype>
+#include <cstdlib>
+#include <cstring>
+#include <string>
+#include <vector>
+
+namespace svdb_jsonb {
+
+// Append the header of an element of type t with a payload of n bytes
+void putHeader(std::string& out, int t, size_t n) {
+    if (n <= 11) {
+        out += static_cast<char>((n << 4) | t);
+        return;
+    }
+    int bytes = n <= 0xFF ? 1 : n <= 0xFFFF ? 2 : n <= 0xFFFFFFFFu ? 4 : 8;
+    int code = bytes == 1 ? 12 : bytes == 2 ? 13 : bytes == 4 ? 14 : 15;
+    out += static_cast<char>((code << 4) | t);
+    for (int i = bytes - 1; i >= 0; i--) {
+        out += static_cast<char>((static_cast<uint64_t>(n) >> (8 * i)) & 0xFF);
+    }
+}
+
+// Decode the header at b[0..len): element type, header size and payload size.
+// Returns false if the element does not fit in len bytes.
+bool getHeader(const unsigned char* b, size_t len, int& type, size_t& hdr, size_t& n) {
+    if (len == 0) return false;
+    type = b[0] & 0x0F;
+    int code = b[0] >> 4;
+    if (code <= 11) {
+        hdr = 1;
+        n = static_cast<size_t>(code);
+    } else {
+        size_t bytes = code == 12 ? 1 : code == 13 ? 2 : code == 14 ? 4 : 8;
+        if (len < 1 + bytes) return false;
+        uint64_t sz = 0;
+        for (size_t i = 0; i < bytes; i++) sz = (sz << 8) | b[1 + i];
+        hdr = 1 + bytes;
+        if (sz > len) return false;
+        n = static_cast<size_t>(sz);
+    }
+    return type <= OBJECT && hdr + n <= len;
+}
+
+// ── Text to JSONB ─────────────────────────────────────────────────
+
+class Encoder {
+public:
+    explicit Encoder(const char* s) : s_(s), len_(std::strlen(s)) {}
+
+    bool encode(std::string& out) {
+        if (!value(out, 0)) return false;
+        ws();
+        return pos_ == len_;
+    }
+
+private:
+    void ws() {
+        while (pos_ < len_ && (s_[pos_] == ' ' || s_[pos_] == '\t' || s_[pos_] == '\n' || s_[pos_] == '\r')) pos_++;
+    }
+
+    bool literal(const char* word) {
+        size_t n = std::strlen(word);
+        if (len_ - pos_ < n || std::strncmp(s_ + pos_, word, n) != 0) return false;
+        pos_ += n;
+        return true;
+    }
+
+    static bool isDigit(char c) { return c >= '0' && c <= '9'; }
+
+    bool value(std::string& out, int depth) {
+        if (depth > 1000) return false;
+        ws();
+        if (pos_ >= len_) return false;
+        char c = s_[pos_];
+        if (c == 'n') { if (!literal("null")) return false; putHeader(out, NUL, 0); return true; }
+        if (c == 't') { if (!literal("true")) return false; putHeader(out, TRUE_, 0); return true; }
+        if (c == 'f') { if (!literal("false")) return false; putHeader(out, FALSE_, 0); return true; }
+        if (c == '"') return string(out);
+        if (c == '[') return array(out, depth);
+        if (c == '{') return object(out, depth);
+        if (c == '-' || isDigit(c)) return number(out);
+        return false;
+    }
+
+    bool string(std::string& out) {
+        size_t start = ++pos_;
+        bool escaped = false;
+        while (pos_ < len_ && s_[pos_] != '"') {
+            unsigned char c = static_cast<unsigned char>(s_[pos_]);
+            if (c < 0x20) return false;
+            if (c == '\\') {
+                escaped = true;
+                if (++pos_ >= len_) return false;
+                char e = s_[pos_];
+                if (e == 'u') {
+                    for (int i = 1; i <= 4; i++) {
+                        if (pos_ + i >= len_ || !std::isxdigit(static_cast<unsigned char>(s_[pos_ + i]))) return false;
+                    }
+                    pos_ += 4;
+                } else if (!std::strchr("\"\\/bfnrt", e)) {
+                    return false;
+                }
+            }
+            pos_++;
+        }
+        if (pos_ >= len_) return false;
+        putHeader(out, escaped ? TEXTJ : TEXT, pos_ - start);
+        out.append(s_ + start, pos_ - start);
+        pos_++;
+        return true;
+    }
+
+    bool number(std::string& out) {
+        size_t start = pos_;
+        bool isInt = true;
+        if (s_[pos_] == '-') pos_++;
+        if (pos_ >= len_ || !isDigit(s_[pos_])) return false;
+        if (s_[pos_] == '0') {
+            pos_++;
+        } else {
+            while (pos_ < len_ && isDigit(s_[pos_])) pos_++;
+        }
+        if (pos_ < len_ && s_[pos_] == '.') {
+            isInt = false;
+            pos_++;
+            if (pos_ >= len_ || !isDigit(s_[pos_])) return false;
+            while (pos_ < len_ && isDigit(s_[pos_])) pos_++;
+        }
+        if (pos_ < len_ && (s_[pos_] == 'e' || s_[pos_] == 'E')) {
+            isInt = false;
+            pos_++;
+            if (pos_ < len_ && (s_[pos_] == '+' || s_[pos_] == '-')) pos_++;
+            if (pos_ >= len_ || !isDigit(s_[pos_])) return false;
+            while (pos_ < len_ && isDigit(s_[pos_])) pos_++;
+        }
+        putHeader(out, isInt ? INT : FLOAT, pos_ - start);
+        out.append(s_ + start, pos_ - start);
+        return true;
+    }
+
+    bool array(std::string& out, int depth) {
+        pos_++;
+        std::string body;
+        ws();
+        if (pos_ < len_ && s_[pos_] == ']') {
+            pos_++;
+        } else {
+            for (;;) {
+                if (!value(body, depth + 1)) return false;
+                ws();
+                if (pos_ >= len_) return false;
+                if (s_[pos_] == ']') { pos_++; break; }
+                if (s_[pos_] != ',') return false;
+                pos_++;
+            }
+        }
+        putHeader(out, ARRAY, body.size());
+        out += body;
+        return true;
+    }
+
+    bool object(std::string& out, int depth) {
+        pos_++;
+        std::string body;
+        ws();
+        if (pos_ < len_ && s_[pos_] == '}') {
+            pos_++;
+        } else {
+            for (;;) {
+                ws();
+                if (pos_ >= len_ || s_[pos_] != '"' || !string(body)) return false;
+                ws();
+                if (pos_ >= len_ || s_[pos_] != ':') return false;
+                pos_++;
+                if (!value(body, depth + 1)) return false;
+                ws();
+                if (pos_ >= len_) return false;
+                if (s_[pos_] == '}') { pos_++; break; }
+                if (s_[pos_] != ',') return false;
+                pos_++;
+            }
+        }
+        putHeader(out, OBJECT, body.size());
+        out += body;
+        return true;
+    }
+
+    const char* s_;
+    size_t len_;
+    size_t pos_ = 0;
+};
+
+bool encode(const char* text, std::string& out) {
+    Encoder enc(text);
+    return enc.encode(out);
+}
+
+// ── JSONB to text ─────────────────────────────────────────────────
+
+static void appendEscaped(std::string& out, const unsigned char* p, size_t n) {
+    static const char* hex = "0123456789abcdef";
+    for (size_t i = 0; i < n; i++) {
+        unsigned char c = p[i];
+        switch (c) {
+            case '"':  out += "\\\""; break;
+            case '\\': out += "\\\\"; break;
+            case '\b': out += "\\b"; break;
+            case '\f': out += "\\f"; break;
+            case '\n': out += "\\n"; break;
+            case '\r': out += "\\r"; break;
+            case '\t': out += "\\t"; break;
+            default:
+                if (c < 0x20) {
+                    out += "\\u00";
+                    out += hex[c >> 4];
+                    out += hex[c & 0x0F];
+                } else {
+                    out += static_cast<char>(c);
+                }
+        }
+    }
+}
+
+// Append the JSON text of the element at b[0..len); false if malformed
+bool toText(const unsigned char* b, size_t len, std::string& out, int depth) {
+    int type;
+    size_t hdr, n;
+    if (depth > 1000 || !getHeader(b, len, type, hdr, n)) return false;
+    const unsigned char* p = b + hdr;
+    switch (type) {
+        case NUL:    out += "null"; return n == 0;
+        case TRUE_:  out += "true"; return n == 0;
+        case FALSE_: out += "false"; return n == 0;
+        case INT: case INT5: case FLOAT: case FLOAT5:
+            if (n == 0) return false;
+            out.append(reinterpret_cast<const char*>(p), n);
+            return true;
+        case TEXT: case TEXTJ: case TEXT5:
+            out += '"';
+            out.append(reinterpret_cast<const char*>(p), n);
+            out += '"';
+            return true;
+        case TEXTRAW:
+            out += '"';
+            appendEscaped(out, p, n);
+            out += '"';
+            return true;
+        case ARRAY: case OBJECT: {
+            out += type == ARRAY ? '[' : '{';
+            size_t off = 0;
+            int count = 0;
+            while (off < n) {
+                int ct;
+                size_t ch, cn;
+                if (!getHeader(p + off, n - off, ct, ch, cn)) return false;
+                bool label = type == OBJECT && count % 2 == 0;
+                if (label && (ct < TEXT || ct > TEXTRAW)) return false;
+                if (count > 0) out += (type == OBJECT && !label) ? ':' : ',';
+                if (!toText(p + off, n - off, out, depth + 1)) return false;
+                off += ch + cn;
+                count++;
+            }
+            if (type == OBJECT && count % 2 != 0) return false;
+            out += type == ARRAY ? ']' : '}';
+            return true;
+        }
+    }
+    return false;
+}
+
+// ── Path lookup ───────────────────────────────────────────────────
+
+// Decode the escapes of a TEXTJ/TEXT5 string into UTF-8
+std::string unescape(const unsigned char* p, size_t n) {
+    std::string out;
+    for (size_t i = 0; i < n; i++) {
+        if (p[i] != '\\' || i + 1 >= n) {
+            out += static_cast<char>(p[i]);
+            continue;
+        }
+        char e = static_cast<char>(p[++i]);
+        switch (e) {
+            case 'b': out += '\b'; break;
+            case 'f': out += '\f'; break;
+            case 'n': out += '\n'; break;
+            case 'r': out += '\r'; break;
+            case 't': out += '\t'; break;
+            case 'u': {
+                if (i + 4 >= n) return out;
+                unsigned cp = static_cast<unsigned>(std::strtoul(std::string(reinterpret_cast<const char*>(p + i + 1), 4).c_str(), nullptr, 16));
+                i += 4;
+                if (cp >= 0xD800 && cp <= 0xDBFF && i + 6 < n && p[i + 1] == '\\' && p[i + 2] == 'u') {
+                    unsigned lo = static_cast<unsigned>(std::strtoul(std::string(reinterpret_cast<const char*>(p + i + 3), 4).c_str(), nullptr, 16));
+                    if (lo >= 0xDC00 && lo <= 0xDFFF) {
+                        cp = 0x10000 + ((cp - 0xD800) << 10) + (lo - 0xDC00);
+                        i += 6;
+                    }
+                }
+                if (cp < 0x80) {
+                    out += static_cast<char>(cp);
+                } else if (cp < 0x800) {
+                    out += static_cast<char>(0xC0 | (cp >> 6));
+                    out += static_cast<char>(0x80 | (cp & 0x3F));
+                } else if (cp < 0x10000) {
+                    out += static_cast<char>(0xE0 | (cp >> 12));
+                    out += static_cast<char>(0x80 | ((cp >> 6) & 0x3F));
+                    out += static_cast<char>(0x80 | (cp & 0x3F));
+                } else {
+                    out += static_cast<char>(0xF0 | (cp >> 18));
+                    out += static_cast<char>(0x80 | ((cp >> 12) & 0x3F));
+                    out += static_cast<char>(0x80 | ((cp >> 6) & 0x3F));
+                    out += static_cast<char>(0x80 | (cp & 0x3F));
+                }
+                break;
+            }
+            default: out += e;
+        }
+    }
+    return out;
+}
+
+// The children of the container at b[0..len): offset and total size of each
+bool children(const unsigned char* b, size_t len, int& type,
+              std::vector<std::pair<size_t, size_t>>& kids) {
+    size_t hdr, n;
+    if (!getHeader(b, len, type, hdr, n)) return false;
+    if (type != ARRAY && type != OBJECT) return true;
+    size_t off = hdr;
+    while (off < hdr + n) {
+        int ct;
+        size_t ch, cn;
+        if (!getHeader(b + off, hdr + n - off, ct, ch, cn)) return false;
+        kids.emplace_back(off, ch + cn);
+        off += ch + cn;
+    }
+    return true;
+}
+
+// One step of a path: .key, [N], [#-N] or [#]
+struct PathStep {
+    bool key;
+    std::string name;
+    long long index;  // for [#-N], N; for [#], 0
+    bool from_end;
+};
+
+// Split a path into steps; false if it is malformed
+static bool parsePath(const char* path, std::vector<PathStep>& steps) {
+    size_t plen = std::strlen(path);
+    if (plen == 0 || path[0] != '$') return false;
+    size_t i = 1;
+    while (i < plen) {
+        PathStep st{false, std::string(), 0, false};
+        if (path[i] == '.') {
+            st.key = true;
+            i++;
+            if (i < plen && path[i] == '"') {
+                const char* q = std::strchr(path + i + 1, '"');
+                if (!q) return false;
+                size_t end = static_cast<size_t>(q - path);
+                st.name.assign(path + i + 1, end - i - 1);
+                i = end + 1;
+            } else {
+                size_t end = i;
+                while (end < plen && path[end] != '.' && path[end] != '[') end++;
+                if (end == i) return false;
+                st.name.assign(path + i, end - i);
+                i = end;
+            }
+        } else if (path[i] == '[') {
+            size_t end = i + 1;
+            while (end < plen && path[end] != ']') end++;
+            if (end >= plen) return false;
+            std::string idx(path + i + 1, end - i - 1);
+            i = end + 1;
+            char* rest = nullptr;
+            if (!idx.empty() && idx[0] == '#') {
+                st.from_end = true;
+                if (idx.size() > 1) {
+                    if (idx[1] != '-') return false;
+                    st.index = std::strtoll(idx.c_str() + 2, &rest, 10);
+                }
+            } else {
+                st.index = std::strtoll(idx.c_str(), &rest, 10);
+            }
+            if (idx.empty() || (rest && *rest != '\0')) return false;
+        } else {
+            return false;
+        }
+        steps.push_back(st);
+    }
+    return true;
+}
+
+// Whether the object label at b[0..len) spells key
+bool labelIs(const unsigned char* b, size_t len, const std::string& key) {
+    int lt;
+    size_t lh, ln;
+    if (!getHeader(b, len, lt, lh, ln)) return false;
+    if (lt == TEXTJ || lt == TEXT5) return unescape(b + lh, ln) == key;
+    return ln == key.size() && std::memcmp(b + lh, key.data(), ln) == 0;
+}
+
+// Index of the child a step selects in a container of the given type;
+// kids.size() if there is none
+static size_t stepChild(const unsigned char* b, int type, const PathStep& st,
+                        const std::vector<std::pair<size_t, size_t>>& kids) {
+    if (st.key) {
+        if (type != OBJECT) return kids.size();
+        for (size_t k = 0; k + 1 < kids.size(); k += 2)
+            if (labelIs(b + kids[k].first, kids[k].second, st.name)) return k + 1;
+        return kids.size();
+    }
+    if (type != ARRAY) return kids.size();
+    long long k = st.from_end ? static_cast<long long>(kids.size()) - st.index : st.index;
+    if (k < 0 || static_cast<size_t>(k) >= kids.size()) return kids.size();
+    return static_cast<size_t>(k);
+}
+
+// Find the element at path; -1 if the path or document is malformed
+static int locate(const unsigned char* b, size_t len, const char* path, size_t& off, size_t& elen) {
+    std::vector<PathStep> steps;
+    if (!parsePath(path, steps)) return -1;
+    off = 0;
+    int type;
+    size_t hdr, n;
+    if (!getHeader(b, len, type, hdr, n)) return -1;
+    elen = hdr + n;
+    for (const PathStep& st : steps) {
+        std::vector<std::pair<size_t, size_t>> kids;
+        if (!children(b + off, elen, type, kids)) return -1;
+        size_t k = stepChild(b + off, type, st, kids);
+        if (k >= kids.size()) return 0;
+        off += kids[k].first;
+        elen = kids[k].second;
+    }
+    return 1;
+}
+
+// ── Editing ───────────────────────────────────────────────────────
+
+// Append the element at b[0..len) with steps[si..] edited by op; containers
+// on the path are rebuilt around the changed child, everything else is
+// copied as is. False if the document is malformed.
+static bool edit(const unsigned char* b, size_t len, const std::vector<PathStep>& steps, size_t si,
+                 int op, const std::string& value, std::string& out) {
+    if (si == steps.size()) {
+        if (op == SVDB_JSONB_SET || op == SVDB_JSONB_REPLACE) out += value;
+        else out.append(reinterpret_cast<const char*>(b), len);
+        return true;
+    }
+    int type;
+    std::vector<std::pair<size_t, size_t>> kids;
+    if (!children(b, len, type, kids)) return false;
+    const PathStep& st = steps[si];
+    size_t k = stepChild(b, type, st, kids);
+    bool last = si + 1 == steps.size();
+    bool container = (st.key && type == OBJECT) || (!st.key && type == ARRAY);
+    bool append = k == kids.size() && container && last &&
+                  (op == SVDB_JSONB_SET || op == SVDB_JSONB_INSERT) &&
+                  (st.key || (st.from_end ? st.index == 0 : st.index == static_cast<long long>(kids.size())));
+    if (k == kids.size() && !append) {
+        out.append(reinterpret_cast<const char*>(b), len);
+        return true;
+    }
+    std::string body;
+    for (size_t i = 0; i < kids.size(); i++) {
+        const unsigned char* cb = b + kids[i].first;
+        bool is_label = type == OBJECT && k < kids.size() && i + 1 == k;
+        if (i == k || is_label) {
+            if (last && op == SVDB_JSONB_REMOVE) continue;
+            if (i == k) {
+                if (!edit(cb, kids[i].second, steps, si + 1, op, value, body)) return false;
+                continue;
+            }
+        }
+        body.append(reinterpret_cast<const char*>(cb), kids[i].second);
+    }
+    if (append) {
+        if (st.key) {
+            bool plain = true;
+            for (unsigned char c : st.name)
+                if (c < 0x20 || c == '"' || c == '\\') plain = false;
+            putHeader(body, plain ? TEXT : TEXTRAW, st.name.size());
+            body += st.name;
+        }
+        body += value;
+    }
+    putHeader(out, type, body.size());
+    out += body;
+    return true;
+}
+
+} // namespace svdb_jsonb
+
+extern "C" {
+
+unsigned char* svdb_jsonb_from_text(const char* json_str, size_t* out_len) {
+    if (!json_str || !out_len) return nullptr;
+    try {
+        std::string out;
+        svdb_jsonb::Encoder enc(json_str);
+        if (!enc.encode(out)) return nullptr;
+        unsigned char* buf = static_cast<unsigned char*>(std::malloc(out.size() ? out.size() : 1));
+        if (!buf) return nullptr;
+        std::memcpy(buf, out.data(), out.size());
+        *out_len = out.size();
+        return buf;
+    } catch (...) {
+        return nullptr;
+    }
+}
+
+char* svdb_jsonb_to_text(const unsigned char* blob, size_t len) {
+    if (!blob) return nullptr;
+    try {
+        std::string out;
+        int type;
+        size_t hdr, n;
+        if (!svdb_jsonb::getHeader(blob, len, type, hdr, n) || hdr + n != len) return nullptr;
+        if (!svdb_jsonb::toText(blob, len, out, 0)) return nullptr;
+        char* s = static_cast<char*>(std::malloc(out.size() + 1));
+        if (s) std::memcpy(s, out.c_str(), out.size() + 1);
+        return s;
+    } catch (...) {
+        return nullptr;
+    }
+}
+
+int svdb_jsonb_valid(const unsigned char* blob, size_t len) {
+    char* text = svdb_jsonb_to_text(blob, len);
+    if (!text) return 0;
+    std::free(text);
+    return 1;
+}
+
+int svdb_jsonb_locate(const unsigned char* blob, size_t len, const char* path,
+                      size_t* off, size_t* elem_len) {
+    if (!blob || !path || !off || !elem_len) return -1;
+    try {
+        return svdb_jsonb::locate(blob, len, path, *off, *elem_len);
+    } catch (...) {
+        return -1;
+    }
+}
+
+const char* svdb_jsonb_type(const unsigned char* elem, size_t len) {
+    int type;
+    size_t hdr, n;
+    if (!elem || !svdb_jsonb::getHeader(elem, len, type, hdr, n)) return nullptr;
+    switch (type) {
+        case svdb_jsonb::NUL:    return "null";
+        case svdb_jsonb::TRUE_:  return "true";
+        case svdb_jsonb::FALSE_: return "false";
+        case svdb_jsonb::INT: case svdb_jsonb::INT5: return "integer";
+        case svdb_jsonb::FLOAT: case svdb_jsonb::FLOAT5: return "real";
+        case svdb_jsonb::ARRAY:  return "array";
+        case svdb_jsonb::OBJECT: return "object";
+        default: return "text";
+    }
+}
+
+char* svdb_jsonb_atom(const unsigned char* elem, size_t len) {
+    int type;
+    size_t hdr, n;
+    if (!elem || !svdb_jsonb::getHeader(elem, len, type, hdr, n) || type == svdb_jsonb::NUL) return nullptr;
+    std::string out;
+    switch (type) {
+        case svdb_jsonb::TRUE_:  out = "true"; break;
+        case svdb_jsonb::FALSE_: out = "false"; break;
+        case svdb_jsonb::TEXTJ: case svdb_jsonb::TEXT5:
+            out = svdb_jsonb::unescape(elem + hdr, n);
+            break;
+        case svdb_jsonb::ARRAY: case svdb_jsonb::OBJECT:
+            if (!svdb_jsonb::toText(elem, len, out, 0)) return nullptr;
+            break;
+        default:
+            out.assign(reinterpret_cast<const char*>(elem + hdr), n);
+    }
+    char* s = static_cast<char*>(std::malloc(out.size() + 1));
+    if (s) std::memcpy(s, out.c_str(), out.size() + 1);
+    return s;
+}
+
+int64_t svdb_jsonb_length(const unsigned char* elem, size_t len) {
+    int type;
+    std::vector<std::pair<size_t, size_t>> kids;
+    if (!elem || !svdb_jsonb::children(elem, len, type, kids)) return -1;
+    if (type == svdb_jsonb::ARRAY) return static_cast<int64_t>(kids.size());
+    if (type == svdb_jsonb::OBJECT) return static_cast<int64_t>(kids.size() / 2);
+    return 1;
+}
+
+unsigned char* svdb_jsonb_edit(const unsigned char* blob, size_t len, const char* path, int op,
+                               const unsigned char* value, size_t value_len, size_t* out_len) {
+    if (!blob || !path || !out_len) return nullptr;
+    if (op != SVDB_JSONB_REMOVE && !value) return nullptr;
+    try {
+        std::vector<svdb_jsonb::PathStep> steps;
+        if (!svdb_jsonb::parsePath(path, steps)) return nullptr;
+        int type;
+        size_t hdr, n;
+        if (!svdb_jsonb::getHeader(blob, len, type, hdr, n) || hdr + n != len) return nullptr;
+        std::string out;
+        std::string val = value ? std::string(reinterpret_cast<const char*>(value), value_len) : std::string();
+        if (steps.empty() && op == SVDB_JSONB_REMOVE) return nullptr;
+        if (!svdb_jsonb::edit(blob, len, steps, 0, op, val, out)) return nullptr;
+        unsigned char* buf = static_cast<unsigned char*>(std::malloc(out.size()));
+        if (!buf) return nullptr;
+        std::memcpy(buf, out.data(), out.size());
+        *out_len = out.size();
+        return buf;
+    } catch (...) {
+        return nullptr;
+    }
+}
+
+} // extern "C"
@@ -0,0 +1,36 @@
+// JSONB internals shared by jsonb.cpp and jsonpath.cpp
+#ifndef SVDB_EXT_JSONB_INTERNAL_H
+#define SVDB_EXT_JSONB_INTERNAL_H
+
+#include <cstddef>
+#include <string>
+#include <utility>
+#include <vector>
+
+namespace svdb_jsonb {
+
+enum ElemType {
+    NUL = 0, TRUE_ = 1, FALSE_ = 2, INT = 3, INT5 = 4, FLOAT = 5, FLOAT5 = 6,
+    TEXT = 7, TEXTJ = 8, TEXT5 = 9, TEXTRAW = 10, ARRAY = 11, OBJECT = 12
+};
+
+// Append the header of an element of type t with a payload of n bytes
+void putHeader(std::string& out, int t, size_t n);
+// Decode the header at b[0..len): element type, header size and payload size.
+// Returns false if the element does not fit in len bytes.
+bool getHeader(const unsigned char* b, size_t len, int& type, size_t& hdr, size_t& n);
+// Append the JSONB of JSON text; false if the text is not valid JSON
+bool encode(const char* text, std::string& out);
+// Append the JSON text of the element at b[0..len); false if malformed
+bool toText(const unsigned char* b, size_t len, std::string& out, int depth);
+// Decode the escapes of a TEXTJ/TEXT5 string into UTF-8
+std::string unescape(const unsigned char* p, size_t n);
+// The children of the container at b[0..len): offset and total size of each
+bool children(const unsigned char* b, size_t len, int& type,
+              std::vector<std::pair<size_t, size_t>>& kids);
+// Whether the object label at b[0..len) spells key
+bool labelIs(const unsigned char* b, size_t len, const std::string& key);
+
+} // namespace svdb_jsonb
+
+#endif // SVDB_EXT_JSONB_INTERNAL_H
@@ -0,0 +1,721 @@
+// SQL/JSON path - the path language of JSON_VALUE, JSON_QUERY, JSON_EXISTS
+// and JSON_TABLE, evaluated over JSONB
+//
+//   [lax | strict] $ accessor...
+//
+// Accessors are .key, ."key", .*, [n], [n to m], [last], [last - n], [*],
+// [a, b], the methods .size(), .type() and .double(), and filters
+// ? (predicate). Predicates compare operands with == != <> < <= > >=, combine
+// with && || ! and parentheses, and test with exists(path), starts with,
+// like_regex "re" [flag "i"] and (predicate) is unknown. Operands are paths
+// from @ (the item being filtered) or $ (the document) and JSON literals.
+//
+// In lax mode, the default, a member accessor looks through an array, an
+// array accessor treats a non-array as a one-element array, and members or
+// elements that are not there yield nothing. Strict mode reports those as
+// errors.
+#include "json.h"
+#include "jsonb_internal.h"
+#include <cctype>
+#include <cstdio>
+#include <cstdlib>
+#include <cstring>
+#include <memory>
+#include <regex>
+#include <stdexcept>
+#include <string>
+#include <vector>
+
+namespace svdb_jsonpath {
+
+using namespace svdb_jsonb;
+
+typedef std::vector<std::string> Seq; // JSONB items
+
+struct Path;
+struct Pred;
+
+// [lo] or [lo to hi]; an index counted from last is last - off
+struct Subscript {
+    bool lo_last = false, hi_last = false, range = false;
+    long long lo = 0, hi = 0;
+};
+
+struct Step {
+    enum Kind { KEY, ANY_KEY, INDEX, ANY_INDEX, FILTER, METHOD } kind;
+    std::string name;
+    std::vector<Subscript> subs;
+    std::shared_ptr<Pred> pred;
+};
+
+struct Path {
+    bool from_root = true; // $ rather than @
+    std::vector<Step> steps;
+};
+
+// A predicate operand: a path, or a JSON literal
+struct Operand {
+    std::shared_ptr<Path> path;
+    std::string literal;
+};
+
+struct Pred {
+    enum Kind { AND, OR, NOT, CMP, EXISTS, STARTS, LIKE, IS_UNKNOWN } kind;
+    std::string op;
+    std::shared_ptr<Pred> a, b;
+    Operand l, r;
+    std::shared_ptr<std::regex> re;
+};
+
+// ── Parsing ───────────────────────────────────────────────────────
+
+class Parser {
+public:
+    explicit Parser(const char* s) : s_(s) {}
+
+    Path parse(bool& strict) {
+        ws();
+        strict = false;
+        if (word("strict")) strict = true;
+        else word("lax");
+        ws();
+        if (peek() != '$') fail("path must start with $");
+        Path p = path();
+        ws();
+        if (pos_ < s_.size()) fail("syntax error at or near \"" + s_.substr(pos_) + "\"");
+        return p;
+    }
+
+private:
+    [[noreturn]] void fail(const std::string& msg) { throw std::runtime_error("JSON path: " + msg); }
+
+    char peek() const { return pos_ < s_.size() ? s_[pos_] : '\0'; }
+
+    void ws() {
+        while (pos_ < s_.size() && std::isspace(static_cast<unsigned char>(s_[pos_]))) pos_++;
+    }
+
+    static bool identChar(char c) { return std::isalnum(static_cast<unsigned char>(c)) || c == '_'; }
+
+    // Consume w if it is the next whole word
+    bool word(const char* w) {
+        size_t n = std::strlen(w);
+        if (s_.compare(pos_, n, w) != 0) return false;
+        if (pos_ + n < s_.size() && identChar(s_[pos_ + n])) return false;
+        pos_ += n;
+        return true;
+    }
+
+    bool punct(const char* p) {
+        ws();
+        size_t n = std::strlen(p);
+        if (s_.compare(pos_, n, p) != 0) return false;
+        pos_ += n;
+        return true;
+    }
+
+    void expect(const char* p) {
+        if (!punct(p)) fail(std::string("expected \"") + p + "\"");
+    }
+
+    // A double-quoted string as JSON text, quotes included
+    std::string quoted() {
+        size_t start = pos_++;
+        while (pos_ < s_.size() && s_[pos_] != '"') {
+            if (s_[pos_] == '\\') pos_++;
+            pos_++;
+        }
+        if (pos_ >= s_.size()) fail("unterminated string");
+        pos_++;
+        return s_.substr(start, pos_ - start);
+    }
+
+    std::string jsonb(const std::string& text) {
+        std::string out;
+        if (!encode(text.c_str(), out)) fail("invalid literal " + text);
+        return out;
+    }
+
+    long long integer() {
+        ws();
+        size_t start = pos_;
+        if (peek() == '-' || peek() == '+') pos_++;
+        while (std::isdigit(static_cast<unsigned char>(peek()))) pos_++;
+        if (pos_ == start) fail("expected an array index");
+        return std::strtoll(s_.c_str() + start, nullptr, 10);
+    }
+
+    Path path() {
+        Path p;
+        p.from_root = s_[pos_++] == '$';
+        for (;;) {
+            ws();
+            char c = peek();
+            if (c == '.') {
+                pos_++;
+                ws();
+                Step st;
+                if (peek() == '*') {
+                    pos_++;
+                    st.kind = Step::ANY_KEY;
+                } else if (peek() == '"') {
+                    st.kind = Step::KEY;
+                    std::string lit = quoted();
+                    std::string enc = jsonb(lit);
+                    int t;
+                    size_t hdr, n;
+                    getHeader(reinterpret_cast<const unsigned char*>(enc.data()), enc.size(), t, hdr, n);
+                    st.name = t == TEXTJ ? unescape(reinterpret_cast<const unsigned char*>(enc.data()) + hdr, n)
+                                         : enc.substr(hdr);
+                } else {
+                    size_t start = pos_;
+                    while (identChar(peek())) pos_++;
+                    if (pos_ == start) fail("expected a member name after \".\"");
+                    st.name = s_.substr(start, pos_ - start);
+                    st.kind = Step::KEY;
+                    size_t save = pos_;
+                    if (punct("(")) {
+                        if (!punct(")")) fail("expected \")\" after method ." + st.name);
+                        if (st.name != "size" && st.name != "type" && st.name != "double")
+                            fail("unknown method ." + st.name + "()");
+                        st.kind = Step::METHOD;
+                    } else {
+                        pos_ = save;
+                    }
+                }
+                p.steps.push_back(st);
+            } else if (c == '[') {
+                pos_++;
+                Step st;
+                if (punct("*")) {
+                    st.kind = Step::ANY_INDEX;
+                } else {
+                    st.kind = Step::INDEX;
+                    do {
+                        Subscript sub;
+                        index(sub.lo_last, sub.lo);
+                        ws();
+                        if (word("to")) {
+                            sub.range = true;
+                            index(sub.hi_last, sub.hi);
+                        }
+                        st.subs.push_back(sub);
+                    } while (punct(","));
+                }
+                expect("]");
+                p.steps.push_back(st);
+            } else if (c == '?') {
+                pos_++;
+                expect("(");
+                Step st;
+                st.kind = Step::FILTER;
+                st.pred = orPred();
+                expect(")");
+                p.steps.push_back(st);
+            } else {
+                return p;
+            }
+        }
+    }
+
+    void index(bool& from_last, long long& v) {
+        ws();
+        from_last = word("last");
+        if (!from_last) {
+            v = integer();
+            return;
+        }
+        v = 0;
+        if (punct("-")) v = integer();
+        else if (punct("+")) v = -integer();
+    }
+
+    std::shared_ptr<Pred> orPred() {
+        std::shared_ptr<Pred> p = andPred();
+        while (punct("||")) {
+            auto q = std::make_shared<Pred>();
+            q->kind = Pred::OR;
+            q->a = p;
+            q->b = andPred();
+            p = q;
+        }
+        return p;
+    }
+
+    std::shared_ptr<Pred> andPred() {
+        std::shared_ptr<Pred> p = unaryPred();
+        while (punct("&&")) {
+            auto q = std::make_shared<Pred>();
+            q->kind = Pred::AND;
+            q->a = p;
+            q->b = unaryPred();
+            p = q;
+        }
+        return p;
+    }
+
+    std::shared_ptr<Pred> unaryPred() {
+        auto p = std::make_shared<Pred>();
+        if (punct("!")) {
+            p->kind = Pred::NOT;
+            expect("(");
+            p->a = orPred();
+            expect(")");
+            return p;
+        }
+        if (punct("(")) {
+            std::shared_ptr<Pred> inner = orPred();
+            expect(")");
+            ws();
+            if (!word("is")) return inner;
+            ws();
+            if (!word("unknown")) fail("expected \"unknown\" after \"is\"");
+            p->kind = Pred::IS_UNKNOWN;
+            p->a = inner;
+            return p;
+        }
+        ws();
+        if (word("exists")) {
+            expect("(");
+            ws();
+            if (peek() != '$' && peek() != '@') fail("exists() takes a path");
+            p->kind = Pred::EXISTS;
+            p->l.path = std::make_shared<Path>(path());
+            expect(")");
+            return p;
+        }
+        p->l = operand();
+        ws();
+        static const char* ops[] = {"==", "!=", "<>", "<=", ">=", "<", ">", nullptr};
+        for (int i = 0; ops[i]; i++) {
+            if (punct(ops[i])) {
+                p->kind = Pred::CMP;
+                p->op = ops[i];
+                if (p->op == "<>") p->op = "!=";
+                p->r = operand();
+                return p;
+            }
+        }
+        if (word("starts")) {
+            ws();
+            if (!word("with")) fail("expected \"with\" after \"starts\"");
+            p->kind = Pred::STARTS;
+            p->r = operand();
+            return p;
+        }
+        if (word("like_regex")) {
+            ws();
+            if (peek() != '"') fail("like_regex takes a string");
+            std::string pattern = atom(jsonb(quoted()));
+            auto flags = std::regex::ECMAScript;
+            size_t save = pos_;
+            ws();
+            if (word("flag")) {
+                ws();
+                if (peek() != '"') fail("flag takes a string");
+                std::string f = atom(jsonb(quoted()));
+                if (f.find('i') != std::string::npos) flags |= std::regex::icase;
+            } else {
+                pos_ = save;
+            }
+            p->kind = Pred::LIKE;
+            try {
+                p->re = std::make_shared<std::regex>(pattern, flags);
+            } catch (const std::regex_error&) {
+                fail("invalid regular expression " + pattern);
+            }
+            return p;
+        }
+        fail("expected a comparison in filter");
+    }
+
+    Operand operand() {
+        ws();
+        Operand o;
+        char c = peek();
+        if (c == '$' || c == '@') {
+            o.path = std::make_shared<Path>(path());
+        } else if (c == '"') {
+            o.literal = jsonb(quoted());
+        } else if (word("true")) {
+            o.literal = jsonb("true");
+        } else if (word("false")) {
+            o.literal = jsonb("false");
+        } else if (word("null")) {
+            o.literal = jsonb("null");
+        } else {
+            size_t start = pos_;
+            if (c == '-' || c == '+') pos_++;
+            while (std::isdigit(static_cast<unsigned char>(peek())) || peek() == '.' ||
+                   peek() == 'e' || peek() == 'E' ||
+                   ((peek() == '-' || peek() == '+') && (s_[pos_ - 1] == 'e' || s_[pos_ - 1] == 'E'))) pos_++;
+            if (pos_ == start) fail("expected an operand");
+            std::string num = s_.substr(start, pos_ - start);
+            if (num[0] == '+') num.erase(0, 1);
+            o.literal = jsonb(num);
+        }
+        return o;
+    }
+
+    static std::string atom(const std::string& elem) {
+        int t;
+        size_t hdr, n;
+        getHeader(reinterpret_cast<const unsigned char*>(elem.data()), elem.size(), t, hdr, n);
+        if (t == TEXTJ) return unescape(reinterpret_cast<const unsigned char*>(elem.data()) + hdr, n);
+        return elem.substr(hdr);
+    }
+
+    std::string s_;
+    size_t pos_ = 0;
+};
+
+// ── Evaluation ────────────────────────────────────────────────────
+
+enum Truth { FALSE_T, TRUE_T, UNKNOWN_T };
+
+class Evaluator {
+public:
+    Evaluator(const std::string& root, bool strict) : root_(root), strict_(strict) {}
+
+    std::string err;
+
+    // Items path selects from the current item; false on an error
+    bool eval(const Path& p, const std::string& current, Seq& out) {
+        Seq cur(1, p.from_root ? root_ : current);
+        for (const Step& st : p.steps) {
+            Seq next;
+            for (const std::string& item : cur)
+                if (!step(st, item, next)) return false;
+            cur.swap(next);
+        }
+        out.insert(out.end(), cur.begin(), cur.end());
+        return true;
+    }
+
+private:
+    static const unsigned char* bytes(const std::string& s) {
+        return reinterpret_cast<const unsigned char*>(s.data());
+    }
+
+    static int typeOf(const std::string& item) {
+        int t;
+        size_t hdr, n;
+        if (!getHeader(bytes(item), item.size(), t, hdr, n)) return -1;
+        return t;
+    }
+
+    static bool kidsOf(const std::string& item, int& t, std::vector<std::pair<size_t, size_t>>& kids) {
+        return children(bytes(item), item.size(), t, kids);
+    }
+
+    bool error(const std::string& msg) {
+        err = msg;
+        return false;
+    }
+
+    bool step(const Step& st, const std::string& item, Seq& out) {
+        int t;
+        std::vector<std::pair<size_t, size_t>> kids;
+        if (!kidsOf(item, t, kids)) return error("malformed JSONB");
+        switch (st.kind) {
+            case Step::KEY:
+            case Step::ANY_KEY:
+                if (t == ARRAY && !strict_) {
+                    for (const auto& k : kids) {
+                        std::string kid = item.substr(k.first, k.second);
+                        if (typeOf(kid) == OBJECT && !step(st, kid, out)) return false;
+                    }
+                    return true;
+                }
+                if (t != OBJECT) {
+                    if (strict_) return error("jsonpath member accessor can only be applied to an object");
+                    return true;
+                }
+                if (st.kind == Step::ANY_KEY) {
+                    for (size_t k = 1; k < kids.size(); k += 2) out.push_back(item.substr(kids[k].first, kids[k].second));
+                    return true;
+                }
+                for (size_t k = 0; k + 1 < kids.size(); k += 2) {
+                    if (labelIs(bytes(item) + kids[k].first, kids[k].second, st.name)) {
+                        out.push_back(item.substr(kids[k + 1].first, kids[k + 1].second));
+                        return true;
+                    }
+                }
+                if (strict_) return error("JSON object does not contain key \"" + st.name + "\"");
+                return true;
+            case Step::ANY_INDEX:
+            case Step::INDEX: {
+                std::vector<std::string> elems;
+                if (t == ARRAY) {
+                    for (const auto& k : kids) elems.push_back(item.substr(k.first, k.second));
+                } else if (strict_) {
+                    return error("jsonpath array accessor can only be applied to an array");
+                } else {
+                    elems.push_back(item);
+                }
+                if (st.kind == Step::ANY_INDEX) {
+                    out.insert(out.end(), elems.begin(), elems.end());
+                    return true;
+                }
+                long long last = static_cast<long long>(elems.size()) - 1;
+                for (const Subscript& sub : st.subs) {
+                    long long lo = sub.lo_last ? last - sub.lo : sub.lo;
+                    long long hi = sub.range ? (sub.hi_last ? last - sub.hi : sub.hi) : lo;
+                    if (lo < 0 || hi > last || lo > hi) {
+                        if (strict_) return error("jsonpath array subscript is out of bounds");
+                        if (!sub.range) continue;
+                        if (lo < 0) lo = 0;
+                        if (hi > last) hi = last;
+                    }
+                    for (long long i = lo; i <= hi; i++) out.push_back(elems[static_cast<size_t>(i)]);
+                }
+                return true;
+            }
+            case Step::FILTER:
+                if (t == ARRAY && !strict_) {
+                    for (const auto& k : kids) {
+                        std::string kid = item.substr(k.first, k.second);
+                        if (test(*st.pred, kid) == TRUE_T) out.push_back(kid);
+                    }
+                    return true;
+                }
+                if (test(*st.pred, item) == TRUE_T) out.push_back(item);
+                return true;
+            case Step::METHOD:
+                return method(st.name, item, t, kids, out);
+        }
+        return true;
+    }
+
+    bool method(const std::string& name, const std::string& item, int t,
+                const std::vector<std::pair<size_t, size_t>>& kids, Seq& out) {
+        std::string text;
+        if (name == "size") {
+            if (t != ARRAY && strict_) return error("jsonpath item method .size() can only be applied to an array");
+            text = std::to_string(t == ARRAY ? kids.size() : 1);
+        } else if (name == "type") {
+            switch (t) {
+                case NUL: text = "\"null\""; break;
+                case TRUE_: case FALSE_: text = "\"boolean\""; break;
+                case INT: case INT5: case FLOAT: case FLOAT5: text = "\"number\""; break;
+                case ARRAY: text = "\"array\""; break;
+                case OBJECT: text = "\"object\""; break;
+                default: text = "\"string\"";
+            }
+        } else {
+            if (t == ARRAY && !strict_) {
+                for (const auto& k : kids) {
+                    std::string kid = item.substr(k.first, k.second);
+                    int kt;
+                    std::vector<std::pair<size_t, size_t>> none;
+                    if (!kidsOf(kid, kt, none) || !method(name, kid, kt, none, out)) return false;
+                }
+                return true;
+            }
+            double d;
+            if (!number(item, d) && !(isString(t) && parseDouble(str(item), d)))
+                return error("jsonpath item method .double() can only be applied to a string or numeric value");
+            char buf[64];
+            std::snprintf(buf, sizeof(buf), "%.17g", d);
+            text = buf;
+            if (text.find_first_of(".eEn") == std::string::npos) text += ".0";
+        }
+        std::string elem;
+        if (!encode(text.c_str(), elem)) return error("jsonpath item method ." + name + "() failed");
+        out.push_back(elem);
+        return true;
+    }
+
+    static bool isString(int t) { return t >= TEXT && t <= TEXTRAW; }
+    static bool isNumber(int t) { return t >= INT && t <= FLOAT5; }
+
+    static std::string str(const std::string& item) {
+        int t;
+        size_t hdr, n;
+        getHeader(bytes(item), item.size(), t, hdr, n);
+        if (t == TEXTJ || t == TEXT5) return unescape(bytes(item) + hdr, n);
+        return item.substr(hdr, n);
+    }
+
+    static bool parseDouble(const std::string& s, double& d) {
+        if (s.empty()) return false;
+        char* end = nullptr;
+        d = std::strtod(s.c_str(), &end);
+        return end && *end == '\0';
+    }
+
+    static bool number(const std::string& item, double& d) {
+        return isNumber(typeOf(item)) && parseDouble(str(item), d);
+    }
+
+    // Items of an operand; arrays are unwrapped in lax mode
+    bool operand(const Operand& o, const std::string& current, Seq& out) {
+        if (!o.path) {
+            out.push_back(o.literal);
+            return true;
+        }
+        Seq items;
+        if (!eval(*o.path, current, items)) return false;
+        for (const std::string& item : items) {
+            int t;
+            std::vector<std::pair<size_t, size_t>> kids;
+            if (!strict_ && kidsOf(item, t, kids) && t == ARRAY) {
+                for (const auto& k : kids) out.push_back(item.substr(k.first, k.second));
+            } else {
+                out.push_back(item);
+            }
+        }
+        return true;
+    }
+
+    static Truth compare(const std::string& op, const std::string& a, const std::string& b) {
+        int ta = typeOf(a), tb = typeOf(b);
+        int c;
+        if (ta == NUL || tb == NUL) {
+            bool both = ta == tb;
+            if (op == "==") return both ? TRUE_T : FALSE_T;
+            if (op == "!=") return both ? FALSE_T : TRUE_T;
+            return both && (op == "<=" || op == ">=") ? TRUE_T : FALSE_T;
+        }
+        if (isNumber(ta) && isNumber(tb)) {
+            double x, y;
+            if (!number(a, x) || !number(b, y)) return UNKNOWN_T;
+            c = x < y ? -1 : x > y ? 1 : 0;
+        } else if (isString(ta) && isString(tb)) {
+            c = str(a).compare(str(b));
+        } else if ((ta == TRUE_ || ta == FALSE_) && (tb == TRUE_ || tb == FALSE_)) {
+            c = (ta == TRUE_) - (tb == TRUE_);
+        } else {
+            return UNKNOWN_T;
+        }
+        bool r = op == "==" ? c == 0 : op == "!=" ? c != 0 : op == "<" ? c < 0 :
+                 op == "<=" ? c <= 0 : op == ">" ? c > 0 : c >= 0;
+        return r ? TRUE_T : FALSE_T;
+    }
+
+    Truth test(const Pred& p, const std::string& current) {
+        switch (p.kind) {
+            case Pred::AND: {
+                Truth a = test(*p.a, current);
+                if (a == FALSE_T) return FALSE_T;
+                Truth b = test(*p.b, current);
+                if (b == FALSE_T) return FALSE_T;
+                return a == TRUE_T && b == TRUE_T ? TRUE_T : UNKNOWN_T;
+            }
+            case Pred::OR: {
+                Truth a = test(*p.a, current);
+                if (a == TRUE_T) return TRUE_T;
+                Truth b = test(*p.b, current);
+                if (b == TRUE_T) return TRUE_T;
+                return a == FALSE_T && b == FALSE_T ? FALSE_T : UNKNOWN_T;
+            }
+            case Pred::NOT: {
+                Truth a = test(*p.a, current);
+                return a == UNKNOWN_T ? UNKNOWN_T : a == TRUE_T ? FALSE_T : TRUE_T;
+            }
+            case Pred::IS_UNKNOWN:
+                return test(*p.a, current) == UNKNOWN_T ? TRUE_T : FALSE_T;
+            case Pred::EXISTS: {
+                Seq items;
+                if (!eval(*p.l.path, current, items)) return UNKNOWN_T;
+                return items.empty() ? FALSE_T : TRUE_T;
+            }
+            default:
+                break;
+        }
+        Seq ls, rs;
+        if (!operand(p.l, current, ls)) return UNKNOWN_T;
+        if (p.kind == Pred::CMP || p.kind == Pred::STARTS) {
+            if (!operand(p.r, current, rs)) return UNKNOWN_T;
+        }
+        bool unknown = false;
+        for (const std::string& a : ls) {
+            if (p.kind == Pred::LIKE) {
+                if (!isString(typeOf(a))) { unknown = true; continue; }
+                if (std::regex_search(str(a), *p.re)) return TRUE_T;
+                continue;
+            }
+            for (const std::string& b : rs) {
+                Truth r;
+                if (p.kind == Pred::STARTS) {
+                    if (!isString(typeOf(a)) || !isString(typeOf(b))) r = UNKNOWN_T;
+                    else r = str(a).compare(0, str(b).size(), str(b)) == 0 ? TRUE_T : FALSE_T;
+                } else {
+                    r = compare(p.op, a, b);
+                }
+                if (r == TRUE_T) return TRUE_T;
+                if (r == UNKNOWN_T) unknown = true;
+            }
+        }
+        return unknown ? UNKNOWN_T : FALSE_T;
+    }
+
+    const std::string& root_;
+    bool strict_;
+};
+
+static char* dup(const std::string& s) {
+    char* out = static_cast<char*>(std::malloc(s.size() + 1));
+    if (out) std::memcpy(out, s.c_str(), s.size() + 1);
+    return out;
+}
+
+} // namespace svdb_jsonpath
+
+extern "C" {
+
+svdb_jsonb_seq_t* svdb_jsonb_path_query(const unsigned char* blob, size_t len, const char* path, char** err) {
+    if (err) *err = nullptr;
+    if (!blob || !path) return nullptr;
+    try {
+        bool strict;
+        svdb_jsonpath::Parser parser(path);
+        svdb_jsonpath::Path p = parser.parse(strict);
+        int type;
+        size_t hdr, n;
+        if (!svdb_jsonb::getHeader(blob, len, type, hdr, n) || hdr + n != len) {
+            if (err) *err = svdb_jsonpath::dup("malformed JSON");
+            return nullptr;
+        }
+        std::string root(reinterpret_cast<const char*>(blob), len);
+        svdb_jsonpath::Evaluator ev(root, strict);
+        svdb_jsonpath::Seq items;
+        if (!ev.eval(p, root, items)) {
+            if (err) *err = svdb_jsonpath::dup(ev.err);
+            return nullptr;
+        }
+        svdb_jsonb_seq_t* seq = static_cast<svdb_jsonb_seq_t*>(std::calloc(1, sizeof(svdb_jsonb_seq_t)));
+        if (!seq) return nullptr;
+        seq->items = static_cast<unsigned char**>(std::calloc(items.size() + 1, sizeof(unsigned char*)));
+        seq->lens = static_cast<size_t*>(std::calloc(items.size() + 1, sizeof(size_t)));
+        if (!seq->items || !seq->lens) {
+            svdb_jsonb_seq_free(seq);
+            return nullptr;
+        }
+        for (const std::string& item : items) {
+            unsigned char* b = static_cast<unsigned char*>(std::malloc(item.size()));
+            if (!b) {
+                svdb_jsonb_seq_free(seq);
+                return nullptr;
+            }
+            std::memcpy(b, item.data(), item.size());
+            seq->items[seq->count] = b;
+            seq->lens[seq->count] = item.size();
+            seq->count++;
+        }
+        return seq;
+    } catch (const std::exception& e) {
+        if (err) *err = svdb_jsonpath::dup(e.what());
+        return nullptr;
+    }
+}
+
+void svdb_jsonb_seq_free(svdb_jsonb_seq_t* seq) {
+    if (!seq) return;
+    for (int i = 0; i < seq->count; i++) std::free(seq->items[i]);
+    std::free(seq->items);
+    std::free(seq->lens);
+    std::free(seq);
+}
+
+} // extern "C"
@@ -427,10 +427,54 @@ TEST_F(FTS5VTabTest, PrefixSearch) {
     
     // Should find both "testing" and "tester"
     ASSERT_EQ(results.size(), 2);
-    
+
     delete vtab;
 }
 
+TEST_F(FTS5VTabTest, ReplaceAndDeleteLeaveNoStalePostings) {
+    FTS5Index index(1);
+    index.Insert(1, {"hello world"});
+    index.Insert(2, {"hello there"});
+    index.Insert(1, {"goodbye world"});  // replaces document 1
+    index.Delete(2);
+
+    ASSERT_EQ(index.DocCount(), 1);
+    ASSERT_TRUE(index.Search("hello").empty());
+    ASSERT_EQ(index.DocFreq("world"), 1);
+    ASSERT_EQ(index.Delete(2), -1);
+
+    FTS5Index fresh(1);
+    fresh.Insert(1, {"goodbye world"});
+    ASSERT_TRUE(index.SameContents(fresh));
+}
+
+TEST_F(FTS5VTabTest, HitsRestoreWithoutTokenizing) {
+    FTS5Index index(2);
+    index.Insert(7, {"red fish blue fish", "one fish"});
+
+    const auto* hits = index.Hits("fish", 7);
+    ASSERT_NE(hits, nullptr);
+    ASSERT_EQ(hits->size(), 3);
+    ASSERT_TRUE((*hits)[0] == (FTS5Index::Hit{0, 1}));
+    ASSERT_TRUE((*hits)[1] == (FTS5Index::Hit{0, 3}));
+    ASSERT_TRUE((*hits)[2] == (FTS5Index::Hit{1, 1}));
+    ASSERT_EQ(index.TermFreq(7, "fish"), 3);
+    ASSERT_EQ(index.TermsWithPrefix("fi"), std::vector<std::string>{"fish"});
+    ASSERT_EQ(index.Hits("fish", 8), nullptr);
+
+    // Documents and postings copied over match a tokenized index
+    FTS5Index copy(2);
+    copy.Restore(7, {"red fish blue fish", "one fish"}, index.DocLength(7));
+    for (const auto& term : index.GetTermHits()) {
+        for (const auto& doc : term.second) {
+            copy.RestorePosting(term.first, doc.first, doc.second);
+        }
+    }
+    copy.Compact();
+    ASSERT_TRUE(index.SameContents(copy));
+    ASSERT_EQ(copy.Search("blue"), std::vector<int64_t>{7});
+}
+
 // ============================================================================
 // FTS5Module Tests
 // ============================================================================
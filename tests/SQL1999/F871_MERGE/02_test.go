@@ -0,0 +1,208 @@
+package F871_MERGE
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/cyw0ng95/sqlvibe/tests/SQL1999"
+)
+
+func execAll(t *testing.T, db *sql.DB, stmts ...string) {
+	t.Helper()
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+}
+
+func checkRows(t *testing.T, db *sql.DB, query string, expected [][]interface{}) {
+	t.Helper()
+	rows := SQL1999.QueryRows(t, db, query)
+	if len(rows.Data) != len(expected) {
+		t.Fatalf("%s: expected %d rows, got %d: %v", query, len(expected), len(rows.Data), rows.Data)
+	}
+	for i, row := range rows.Data {
+		for j, want := range expected[i] {
+			if row[j] != want {
+				t.Errorf("%s: row %d: expected %v, got %v", query, i, expected[i], row)
+				break
+			}
+		}
+	}
+}
+
+// TestSQL1999_F871_MergeSync_L1 tests a full table sync: update matched rows,
+// insert new ones and delete rows missing from the source.
+func TestSQL1999_F871_MergeSync_L1(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	execAll(t, db,
+		"CREATE TABLE inventory (sku TEXT PRIMARY KEY, qty INTEGER)",
+		"INSERT INTO inventory VALUES ('a', 1), ('b', 2), ('c', 3)",
+		"CREATE TABLE feed (sku TEXT, qty INTEGER)",
+		"INSERT INTO feed VALUES ('a', 10), ('c', 30), ('d', 40)",
+	)
+
+	res, err := db.Exec(`MERGE INTO inventory t USING feed s ON t.sku = s.sku
+		WHEN MATCHED THEN UPDATE SET qty = s.qty
+		WHEN NOT MATCHED THEN INSERT (sku, qty) VALUES (s.sku, s.qty)
+		WHEN NOT MATCHED BY SOURCE THEN DELETE`)
+	if err != nil {
+		t.Fatalf("MERGE: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 4 {
+		t.Errorf("expected 4 rows affected, got %d", n)
+	}
+	checkRows(t, db, "SELECT sku, qty FROM inventory ORDER BY sku", [][]interface{}{
+		{"a", int64(10)}, {"c", int64(30)}, {"d", int64(40)},
+	})
+}
+
+// TestSQL1999_F871_MergeConditions_L1 tests WHEN ... AND conditions, DELETE,
+// DO NOTHING and a query as the merge source.
+func TestSQL1999_F871_MergeConditions_L1(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	execAll(t, db,
+		"CREATE TABLE accounts (id INTEGER PRIMARY KEY, balance INTEGER, note TEXT)",
+		"INSERT INTO accounts VALUES (1, 100, 'x'), (2, 50, 'y'), (3, 0, 'z')",
+		`MERGE INTO accounts a
+		 USING (SELECT 1 AS id, 20 AS amt UNION ALL SELECT 2, -50 UNION ALL SELECT 3, 5 UNION ALL SELECT 4, -1 UNION ALL SELECT 5, 7) AS tx
+		 ON a.id = tx.id
+		 WHEN MATCHED AND a.balance + tx.amt = 0 THEN DELETE
+		 WHEN MATCHED AND a.id = 3 THEN DO NOTHING
+		 WHEN MATCHED THEN UPDATE SET balance = a.balance + tx.amt, note = 'changed'
+		 WHEN NOT MATCHED AND tx.amt > 0 THEN INSERT VALUES (tx.id, tx.amt, 'opened')`,
+	)
+	checkRows(t, db, "SELECT id, balance, note FROM accounts ORDER BY id", [][]interface{}{
+		{int64(1), int64(120), "changed"},
+		{int64(3), int64(0), "z"},
+		{int64(5), int64(7), "opened"},
+	})
+}
+
+// TestSQL1999_F871_MergeTriggersAndForeignKeys_L1 tests that MERGE fires the
+// same triggers and FK actions as the equivalent INSERT/UPDATE/DELETE.
+func TestSQL1999_F871_MergeTriggersAndForeignKeys_L1(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	execAll(t, db,
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE parent (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE child (pid INTEGER REFERENCES parent(id) ON DELETE CASCADE ON UPDATE CASCADE, v TEXT)",
+		"CREATE TABLE audit (op TEXT, id INTEGER)",
+		"CREATE TRIGGER p_ins AFTER INSERT ON parent BEGIN INSERT INTO audit VALUES ('ins', NEW.id); END",
+		"CREATE TRIGGER p_upd AFTER UPDATE ON parent BEGIN INSERT INTO audit VALUES ('upd', NEW.id); END",
+		"CREATE TRIGGER p_del AFTER DELETE ON parent BEGIN INSERT INTO audit VALUES ('del', OLD.id); END",
+		"INSERT INTO parent VALUES (1, 'one'), (2, 'two')",
+		"INSERT INTO child VALUES (1, 'a'), (2, 'b')",
+		"DELETE FROM audit",
+		`MERGE INTO parent p USING (SELECT 1 AS id, 10 AS new_id UNION ALL SELECT 3, 3) s ON p.id = s.id
+		 WHEN MATCHED THEN UPDATE SET id = s.new_id
+		 WHEN NOT MATCHED THEN INSERT VALUES (s.id, 'three')
+		 WHEN NOT MATCHED BY SOURCE THEN DELETE`,
+	)
+	checkRows(t, db, "SELECT pid, v FROM child", [][]interface{}{{int64(10), "a"}})
+	checkRows(t, db, "SELECT op, id FROM audit", [][]interface{}{
+		{"upd", int64(10)}, {"ins", int64(3)}, {"del", int64(2)},
+	})
+}
+
+// TestSQL1999_F871_MergeReturning_L1 tests MERGE ... RETURNING.
+func TestSQL1999_F871_MergeReturning_L1(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	execAll(t, db,
+		"CREATE TABLE kv (k TEXT PRIMARY KEY, v INTEGER)",
+		"INSERT INTO kv VALUES ('a', 1), ('b', 2)",
+	)
+	checkRows(t, db, `MERGE INTO kv USING (SELECT 'a' AS k, 5 AS v UNION ALL SELECT 'c', 6) s ON kv.k = s.k
+		WHEN MATCHED THEN UPDATE SET v = kv.v + s.v
+		WHEN NOT MATCHED THEN INSERT VALUES (s.k, s.v)
+		RETURNING k, v`, [][]interface{}{{"a", int64(6)}, {"c", int64(6)}})
+}
+
+// TestSQL1999_F871_MergeErrors_L1 tests that a failed MERGE leaves the target unchanged.
+func TestSQL1999_F871_MergeErrors_L1(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	execAll(t, db,
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT NOT NULL)",
+		"INSERT INTO t VALUES (1, 'one')",
+	)
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"MERGE INTO t USING (SELECT 1 AS id UNION ALL SELECT 1) s ON t.id = s.id WHEN MATCHED THEN UPDATE SET v = 'x'",
+			"cannot affect row a second time"},
+		{"MERGE INTO t USING (SELECT 1 AS id UNION ALL SELECT 2) s ON t.id = s.id WHEN MATCHED THEN UPDATE SET v = 'x' WHEN NOT MATCHED THEN INSERT VALUES (s.id, NULL)",
+			"NOT NULL constraint failed"},
+		{"MERGE INTO t USING (SELECT 1 AS id) s ON t.id = s.id WHEN NOT MATCHED THEN UPDATE SET v = 'x'",
+			"WHEN NOT MATCHED"},
+		{"MERGE INTO t USING (SELECT 1 AS id) s ON t.id = s.id WHEN MATCHED THEN UPDATE SET nope = 1",
+			"no such column: nope"},
+		{"MERGE INTO missing USING t ON 1 = 1 WHEN MATCHED THEN DELETE",
+			"no such table: missing"},
+	}
+	for _, tt := range tests {
+		_, err := db.Exec(tt.sql)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.sql, tt.want, err)
+		}
+	}
+	checkRows(t, db, "SELECT id, v FROM t", [][]interface{}{{int64(1), "one"}})
+}
+
+// TestSQL1999_F871_MergeUpdateConstraints_L1 tests that WHEN MATCHED UPDATE
+// checks the NOT NULL, CHECK, PRIMARY KEY and UNIQUE constraints INSERT
+// does, and that a failure also undoes the FK actions of earlier updates.
+func TestSQL1999_F871_MergeUpdateConstraints_L1(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	execAll(t, db,
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, u TEXT UNIQUE, n TEXT NOT NULL, c INTEGER CHECK (c > 0), a INTEGER, b INTEGER, UNIQUE (a, b))",
+		"INSERT INTO t VALUES (1, 'x', 'n', 1, 1, 1), (2, 'y', 'n', 1, 2, 2)",
+		"CREATE TABLE child (tid INTEGER REFERENCES t(id) ON UPDATE CASCADE, v TEXT)",
+		"INSERT INTO child VALUES (1, 'a')",
+	)
+
+	const merge = "MERGE INTO t USING (SELECT 1 AS id) s ON t.id = s.id WHEN MATCHED THEN UPDATE SET "
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{merge + "n = NULL", "NOT NULL constraint failed: t.n"},
+		{merge + "c = 0", "CHECK constraint failed"},
+		{merge + "id = 2", "UNIQUE constraint failed: t.id"},
+		{merge + "u = 'y'", "UNIQUE constraint failed"},
+		{merge + "a = 2, b = 2", "UNIQUE constraint failed"},
+		{`MERGE INTO t USING (SELECT 1 AS id, 10 AS new_id, 1 AS c UNION ALL SELECT 2, 2, 0) s ON t.id = s.id
+		  WHEN MATCHED THEN UPDATE SET id = s.new_id, c = s.c`, "CHECK constraint failed"},
+	}
+	for _, tt := range tests {
+		_, err := db.Exec(tt.sql)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.sql, tt.want, err)
+		}
+	}
+	checkRows(t, db, "SELECT id, u, n, c, a, b FROM t ORDER BY id", [][]interface{}{
+		{int64(1), "x", "n", int64(1), int64(1), int64(1)},
+		{int64(2), "y", "n", int64(1), int64(2), int64(2)},
+	})
+	checkRows(t, db, "SELECT tid, v FROM child", [][]interface{}{{int64(1), "a"}})
+
+	// A row keeps its own keys, and NULL never conflicts
+	execAll(t, db, merge+"u = 'x', n = 'm', a = NULL, b = 2")
+	checkRows(t, db, "SELECT u, n, a, b FROM t WHERE id = 1", [][]interface{}{{"x", "m", nil, int64(2)}})
+}